@@ -111,6 +111,7 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 			ctrlctx.ConfigInformerFactory.Config().V1().Infrastructures(),
 			ctrlctx.FeatureGatesHandler,
 			ctrlctx.ClientBuilder.MachineConfigClientOrDie("cert-rotation-controller"),
+			ctrlctx.ClientBuilder.OperatorClientOrDie("cert-rotation-controller"),
 		)
 		if err != nil {
 			klog.Fatalf("unable to start cert rotation controller: %v", err)
@@ -119,7 +120,9 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 		pinnedImageSet := pinnedimageset.New(
 			ctrlctx.InformerFactory.Machineconfiguration().V1().PinnedImageSets(),
 			ctrlctx.InformerFactory.Machineconfiguration().V1().MachineConfigPools(),
+			ctrlctx.KubeInformerFactory.Core().V1().Nodes(),
 			ctrlctx.ClientBuilder.KubeClientOrDie("pinned-image-set-controller"),
+			ctrlctx.ClientBuilder.ImageClientOrDie("pinned-image-set-controller"),
 			ctrlctx.ClientBuilder.MachineConfigClientOrDie("pinned-image-set-controller"),
 		)
 		go pinnedImageSet.Run(2, ctrlctx.Stop)
@@ -160,6 +163,7 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 			bootImageController := bootimagecontroller.New(
 				ctrlctx.ClientBuilder.KubeClientOrDie("machine-set-boot-image-controller"),
 				ctrlctx.ClientBuilder.MachineClientOrDie("machine-set-boot-image-controller"),
+				ctrlctx.ClientBuilder.DynamicClientOrDie("machine-set-boot-image-controller"),
 				ctrlctx.KubeNamespacedInformerFactory.Core().V1().ConfigMaps(),
 				ctrlctx.MachineInformerFactory.Machine().V1beta1().MachineSets(),
 				ctrlctx.MachineInformerFactory.Machine().V1().ControlPlaneMachineSets(),
@@ -261,6 +265,7 @@ func createControllers(ctx *ctrlcommon.ControllerContext) []ctrlcommon.Controlle
 			ctx.ClientBuilder.KubeClientOrDie("container-runtime-config-controller"),
 			ctx.ClientBuilder.MachineConfigClientOrDie("container-runtime-config-controller"),
 			ctx.ClientBuilder.ConfigClientOrDie("container-runtime-config-controller"),
+			ctx.ClientBuilder.OperatorClientOrDie("container-runtime-config-controller"),
 			ctx.FeatureGatesHandler,
 		),
 		// The renderer creates "rendered" MCs from the MC fragments generated by
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/machine-config-operator/internal/clients"
+	"github.com/openshift/machine-config-operator/lib/resourceread"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/daemon"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/version"
+)
+
+var (
+	planCmd = &cobra.Command{
+		Use:   "plan",
+		Short: "Print what the Machine Config Daemon would do to update this node",
+		Long: `Print what the Machine Config Daemon would do to move this node from its current
+config to its desired config, without changing anything.
+
+By default, the current and desired configs and images are read from the node's
+annotations. Alternatively, both configs can be read from local MachineConfig files.`,
+		Args: cobra.NoArgs,
+		Run:  runPlanCmd,
+	}
+
+	planOpts struct {
+		kubeconfig        string
+		nodeName          string
+		rootMount         string
+		currentConfigFile string
+		desiredConfigFile string
+		currentImage      string
+		desiredImage      string
+		output            string
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.PersistentFlags().StringVar(&planOpts.kubeconfig, "kubeconfig", "", "Kubeconfig file to access a remote cluster")
+	planCmd.PersistentFlags().StringVar(&planOpts.nodeName, "node-name", "", "kubernetes node name to plan the update for. Defaults to $NODE_NAME.")
+	planCmd.PersistentFlags().StringVar(&planOpts.rootMount, "root-mount", "/rootfs", "where the nodes root filesystem is mounted.")
+	planCmd.PersistentFlags().StringVar(&planOpts.currentConfigFile, "current-config", "", "MachineConfig file to use as the current config instead of the node annotation")
+	planCmd.PersistentFlags().StringVar(&planOpts.desiredConfigFile, "desired-config", "", "MachineConfig file to use as the desired config instead of the node annotation")
+	planCmd.PersistentFlags().StringVar(&planOpts.currentImage, "current-image", "", "Image to use as the current image instead of the node annotation")
+	planCmd.PersistentFlags().StringVar(&planOpts.desiredImage, "desired-image", "", "Image to use as the desired image instead of the node annotation")
+	planCmd.PersistentFlags().StringVarP(&planOpts.output, "output", "o", "text", "Output format, either text or json")
+}
+
+func runPlanCmd(_ *cobra.Command, _ []string) {
+	flag.Set("logtostderr", "true")
+	flag.Parse()
+
+	if err := runPlan(); err != nil {
+		klog.Fatal(err)
+	}
+}
+
+func runPlan() error {
+	if planOpts.output != "text" && planOpts.output != "json" {
+		return fmt.Errorf("unknown output format %q", planOpts.output)
+	}
+
+	in, err := getPlanInput()
+	if err != nil {
+		return err
+	}
+
+	plan, err := daemon.NewUpdatePlan(*in)
+	if err != nil {
+		return fmt.Errorf("could not plan update: %w", err)
+	}
+
+	if planOpts.output == "json" {
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	plan.Print(os.Stdout)
+	return nil
+}
+
+// getPlanInput gathers the configs, images and cluster policies to plan
+// with. Local files take precedence; the cluster is only contacted when
+// something is still missing.
+func getPlanInput() (*daemon.PlanInput, error) {
+	in := &daemon.PlanInput{
+		CurrentImage: planOpts.currentImage,
+		DesiredImage: planOpts.desiredImage,
+	}
+
+	if _, err := os.Stat(filepath.Join(planOpts.rootMount, constants.MachineConfigDaemonForceFile)); err == nil {
+		in.ForceFilePresent = true
+	}
+
+	var err error
+	if planOpts.currentConfigFile != "" {
+		if in.CurrentConfig, err = readMachineConfigFile(planOpts.currentConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	if planOpts.desiredConfigFile != "" {
+		if in.DesiredConfig, err = readMachineConfigFile(planOpts.desiredConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if in.CurrentConfig != nil && in.DesiredConfig != nil {
+		return in, nil
+	}
+
+	if err := addPlanInputFromCluster(in); err != nil {
+		return nil, err
+	}
+
+	return in, nil
+}
+
+func addPlanInputFromCluster(in *daemon.PlanInput) error {
+	if planOpts.nodeName == "" {
+		planOpts.nodeName = os.Getenv("NODE_NAME")
+	}
+	if planOpts.nodeName == "" {
+		return fmt.Errorf("node-name is required when configs are not read from files")
+	}
+
+	cb, err := clients.NewBuilder(planOpts.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to initialize ClientBuilder: %w", err)
+	}
+
+	kubeClient, err := cb.KubeClient(componentName)
+	if err != nil {
+		return err
+	}
+	mcfgClient, err := cb.MachineConfigClient(componentName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.TODO()
+
+	node, err := kubeClient.CoreV1().Nodes().Get(ctx, planOpts.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get node %s: %w", planOpts.nodeName, err)
+	}
+
+	getConfig := func(annoKey string) (*mcfgv1.MachineConfig, error) {
+		name := node.Annotations[annoKey]
+		if name == "" {
+			return nil, fmt.Errorf("node %s has no %s annotation", node.Name, annoKey)
+		}
+		mc, err := mcfgClient.MachineconfigurationV1().MachineConfigs().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not get MachineConfig %s: %w", name, err)
+		}
+		return mc, nil
+	}
+
+	if in.CurrentConfig == nil {
+		if in.CurrentConfig, err = getConfig(constants.CurrentMachineConfigAnnotationKey); err != nil {
+			return err
+		}
+	}
+	if in.DesiredConfig == nil {
+		if in.DesiredConfig, err = getConfig(constants.DesiredMachineConfigAnnotationKey); err != nil {
+			return err
+		}
+	}
+	if in.CurrentImage == "" {
+		in.CurrentImage = node.Annotations[constants.CurrentImageAnnotationKey]
+	}
+	if in.DesiredImage == "" {
+		in.DesiredImage = node.Annotations[constants.DesiredImageAnnotationKey]
+	}
+
+	mcop, err := cb.OperatorClientOrDie(componentName).OperatorV1().MachineConfigurations().Get(ctx, ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Warningf("MachineConfiguration/%s not found, planning without node disruption policies", ctrlcommon.MCOOperatorKnobsObjectName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not get MachineConfiguration/%s: %w", ctrlcommon.MCOOperatorKnobsObjectName, err)
+	}
+
+	in.NodeDisruptionPolicies = &mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies
+	in.IrreconcilableOverrides = &opv1.IrreconcilableValidationOverrides{}
+	mcop.Spec.IrreconcilableValidationOverrides.DeepCopyInto(in.IrreconcilableOverrides)
+
+	featureGate, err := cb.ConfigClientOrDie(componentName).ConfigV1().FeatureGates().Get(ctx, ctrlcommon.ClusterFeatureInstanceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get FeatureGate/%s: %w", ctrlcommon.ClusterFeatureInstanceName, err)
+	}
+	if in.FeatureGates, err = ctrlcommon.NewFeatureGatesCRHandlerImpl(featureGate, version.ReleaseVersion); err != nil {
+		klog.Warningf("Could not read feature gates for version %s, planning without feature gated behavior: %v", version.ReleaseVersion, err)
+		in.FeatureGates = nil
+	}
+
+	return nil
+}
+
+func readMachineConfigFile(path string) (*mcfgv1.MachineConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read MachineConfig file: %w", err)
+	}
+	mc, err := resourceread.ReadMachineConfigV1(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse MachineConfig file %s: %w", path, err)
+	}
+	return mc, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/buildrequest"
+	"github.com/spf13/cobra"
+	"k8s.io/component-base/cli"
+	"k8s.io/klog/v2"
+)
+
+type reproOpts struct {
+	dir        string
+	mustGather string
+	tool       string
+	authfile   string
+}
+
+func main() {
+	opts := reproOpts{}
+
+	rootCmd := &cobra.Command{
+		Use:   "ocl-repro <machineosbuild>",
+		Short: "Reproduces an on-cluster build from a MachineOSBuild locally.",
+		Long:  "Writes the build context the build pod uses for the given MachineOSBuild into a directory and prints the equivalent buildah or podman command.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return reproduce(args[0], opts)
+		},
+	}
+
+	rootCmd.PersistentFlags().StringVar(&opts.dir, "dir", "", "Directory to write the build context into. Defaults to ./<machineosbuild>.")
+	rootCmd.PersistentFlags().StringVar(&opts.mustGather, "must-gather", "", "Path to a must-gather to read the objects from instead of the cluster.")
+	rootCmd.PersistentFlags().StringVar(&opts.tool, "tool", buildrequest.BuildToolPodman, fmt.Sprintf("Build tool to print the command for; one of %s or %s.", buildrequest.BuildToolPodman, buildrequest.BuildToolBuildah))
+	rootCmd.PersistentFlags().StringVar(&opts.authfile, "authfile", "", "Path to an auth file to pass to the build tool.")
+
+	os.Exit(cli.Run(rootCmd))
+}
+
+func reproduce(mosbName string, opts reproOpts) error {
+	var getter objectGetter
+	if opts.mustGather != "" {
+		klog.Infof("Reading objects from must-gather %s", opts.mustGather)
+		mg, err := newMustGatherGetter(opts.mustGather)
+		if err != nil {
+			return err
+		}
+		getter = mg
+	} else {
+		getter = newClusterGetter()
+	}
+
+	objs, err := getBuildObjects(context.Background(), getter, mosbName)
+	if err != nil {
+		return err
+	}
+
+	bc, err := buildrequest.NewBuildContext(objs.mosb, objs.mosc, objs.mc, objs.cc)
+	if err != nil {
+		return fmt.Errorf("could not create build context for MachineOSBuild %q: %w", mosbName, err)
+	}
+
+	dir := opts.dir
+	if dir == "" {
+		dir = mosbName
+	}
+
+	if err := bc.Write(dir); err != nil {
+		return err
+	}
+
+	klog.Infof("Wrote build context for MachineOSBuild %q to %s", mosbName, dir)
+
+	cmd, err := bc.Command(opts.tool, dir, opts.authfile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(shellJoin(cmd))
+
+	return nil
+}
+
+// The objects needed to construct the build context for a MachineOSBuild.
+type buildObjects struct {
+	mosb *mcfgv1.MachineOSBuild
+	mosc *mcfgv1.MachineOSConfig
+	mc   *mcfgv1.MachineConfig
+	cc   *mcfgv1.ControllerConfig
+}
+
+var (
+	shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+	envVar    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// Joins the command into a single line which can be pasted into a shell.
+// Environment variable assignments only have their value quoted so that the
+// shell still treats them as assignments.
+func shellJoin(cmd []string) string {
+	out := []string{}
+
+	for _, arg := range cmd {
+		if prefix := envVar.FindString(arg); prefix != "" {
+			out = append(out, prefix+shellQuote(strings.TrimPrefix(arg, prefix)))
+		} else {
+			out = append(out, shellQuote(arg))
+		}
+	}
+
+	return strings.Join(out, " ")
+}
+
+func shellQuote(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
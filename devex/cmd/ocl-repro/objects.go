@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/framework"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Retrieves the objects needed for the build context by name.
+type objectGetter interface {
+	MachineOSBuild(context.Context, string) (*mcfgv1.MachineOSBuild, error)
+	MachineOSConfig(context.Context, string) (*mcfgv1.MachineOSConfig, error)
+	MachineConfig(context.Context, string) (*mcfgv1.MachineConfig, error)
+	ControllerConfig(context.Context, string) (*mcfgv1.ControllerConfig, error)
+}
+
+// Retrieves the MachineOSBuild and everything it was built from. A missing
+// ControllerConfig is tolerated since it only supplies the proxy settings and
+// the additional trust bundle.
+func getBuildObjects(ctx context.Context, getter objectGetter, mosbName string) (*buildObjects, error) {
+	mosb, err := getter.MachineOSBuild(ctx, mosbName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineOSBuild %q: %w", mosbName, err)
+	}
+
+	moscName, ok := mosb.GetLabels()[constants.MachineOSConfigNameLabelKey]
+	if !ok || moscName == "" {
+		return nil, fmt.Errorf("MachineOSBuild %q is missing label %q", mosbName, constants.MachineOSConfigNameLabelKey)
+	}
+
+	mosc, err := getter.MachineOSConfig(ctx, moscName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineOSConfig %q for MachineOSBuild %q: %w", moscName, mosbName, err)
+	}
+
+	mc, err := getter.MachineConfig(ctx, mosb.Spec.MachineConfig.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineConfig %q for MachineOSBuild %q: %w", mosb.Spec.MachineConfig.Name, mosbName, err)
+	}
+
+	cc, err := getter.ControllerConfig(ctx, ctrlcommon.ControllerConfigName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get ControllerConfig %q: %w", ctrlcommon.ControllerConfigName, err)
+	}
+
+	if k8serrors.IsNotFound(err) {
+		klog.Warningf("ControllerConfig %q not found, build context will not include proxy settings or the additional trust bundle", ctrlcommon.ControllerConfigName)
+		cc = nil
+	}
+
+	return &buildObjects{
+		mosb: mosb,
+		mosc: mosc,
+		mc:   mc,
+		cc:   cc,
+	}, nil
+}
+
+// Retrieves the objects from the cluster.
+type clusterGetter struct {
+	cs *framework.ClientSet
+}
+
+func newClusterGetter() *clusterGetter {
+	return &clusterGetter{cs: framework.NewClientSet("")}
+}
+
+func (c *clusterGetter) MachineOSBuild(ctx context.Context, name string) (*mcfgv1.MachineOSBuild, error) {
+	return c.cs.MachineOSBuilds().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *clusterGetter) MachineOSConfig(ctx context.Context, name string) (*mcfgv1.MachineOSConfig, error) {
+	return c.cs.MachineOSConfigs().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *clusterGetter) MachineConfig(ctx context.Context, name string) (*mcfgv1.MachineConfig, error) {
+	return c.cs.MachineConfigs().Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *clusterGetter) ControllerConfig(ctx context.Context, name string) (*mcfgv1.ControllerConfig, error) {
+	return c.cs.ControllerConfigs().Get(ctx, name, metav1.GetOptions{})
+}
+
+// Retrieves the objects from a must-gather. Every YAML and JSON file in the
+// must-gather is indexed by kind and name, including the items of any lists.
+type mustGatherGetter struct {
+	objects map[string]map[string]json.RawMessage
+}
+
+// Just enough of an object to identify it, or its items if it is a list.
+type partialObject struct {
+	Kind     string            `json:"kind"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Items    []json.RawMessage `json:"items"`
+}
+
+func newMustGatherGetter(path string) (*mustGatherGetter, error) {
+	m := &mustGatherGetter{
+		objects: map[string]map[string]json.RawMessage{},
+	}
+
+	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !isManifestFile(path) {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		jsonBytes, err := yaml.YAMLToJSON(content)
+		if err != nil {
+			// Must-gathers contain plenty of files which are not Kube objects.
+			klog.V(4).Infof("Skipping %s: %s", path, err)
+			return nil
+		}
+
+		m.index(jsonBytes)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read must-gather %s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+func isManifestFile(path string) bool {
+	return strings.HasSuffix(path, ".yaml") ||
+		strings.HasSuffix(path, ".yml") ||
+		strings.HasSuffix(path, ".json")
+}
+
+func (m *mustGatherGetter) index(raw json.RawMessage) {
+	obj := partialObject{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return
+	}
+
+	if strings.HasSuffix(obj.Kind, "List") {
+		for _, item := range obj.Items {
+			m.index(item)
+		}
+		return
+	}
+
+	if obj.Kind == "" || obj.Metadata.Name == "" {
+		return
+	}
+
+	if _, ok := m.objects[obj.Kind]; !ok {
+		m.objects[obj.Kind] = map[string]json.RawMessage{}
+	}
+
+	m.objects[obj.Kind][obj.Metadata.Name] = raw
+}
+
+func (m *mustGatherGetter) get(kind, name string, out interface{}) error {
+	raw, ok := m.objects[kind][name]
+	if !ok {
+		return k8serrors.NewNotFound(mcfgv1.Resource(strings.ToLower(kind)), name)
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+func (m *mustGatherGetter) MachineOSBuild(_ context.Context, name string) (*mcfgv1.MachineOSBuild, error) {
+	out := &mcfgv1.MachineOSBuild{}
+	return out, m.get("MachineOSBuild", name, out)
+}
+
+func (m *mustGatherGetter) MachineOSConfig(_ context.Context, name string) (*mcfgv1.MachineOSConfig, error) {
+	out := &mcfgv1.MachineOSConfig{}
+	return out, m.get("MachineOSConfig", name, out)
+}
+
+func (m *mustGatherGetter) MachineConfig(_ context.Context, name string) (*mcfgv1.MachineConfig, error) {
+	out := &mcfgv1.MachineConfig{}
+	return out, m.get("MachineConfig", name, out)
+}
+
+func (m *mustGatherGetter) ControllerConfig(_ context.Context, name string) (*mcfgv1.ControllerConfig, error) {
+	out := &mcfgv1.ControllerConfig{}
+	return out, m.get("ControllerConfig", name, out)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openshift/machine-config-operator/pkg/controller/build/buildrequest"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+func TestMustGatherGetter(t *testing.T) {
+	t.Parallel()
+
+	objs := fixtures.NewObjectsForTest("worker")
+
+	mosb := buildrequest.NewMachineOSBuildOrDie(buildrequest.MachineOSBuildOpts{
+		MachineConfig:     objs.RenderedMachineConfig,
+		MachineOSConfig:   objs.MachineOSConfig,
+		MachineConfigPool: objs.MachineConfigPool,
+	})
+
+	mosb.TypeMeta = metav1.TypeMeta{Kind: "MachineOSBuild", APIVersion: "machineconfiguration.openshift.io/v1"}
+	objs.MachineOSConfig.TypeMeta = metav1.TypeMeta{Kind: "MachineOSConfig", APIVersion: "machineconfiguration.openshift.io/v1"}
+	objs.RenderedMachineConfig.TypeMeta = metav1.TypeMeta{Kind: "MachineConfig", APIVersion: "machineconfiguration.openshift.io/v1"}
+
+	dir := t.TempDir()
+
+	// Individual objects are written as YAML files.
+	writeManifest(t, filepath.Join(dir, "machineosbuilds", mosb.Name+".yaml"), mosb, true)
+	writeManifest(t, filepath.Join(dir, "machineosconfigs", objs.MachineOSConfig.Name+".yaml"), objs.MachineOSConfig, true)
+
+	// Lists are also indexed.
+	writeManifest(t, filepath.Join(dir, "machineconfigs.json"), &metav1.List{
+		TypeMeta: metav1.TypeMeta{Kind: "List", APIVersion: "v1"},
+		Items: []runtime.RawExtension{
+			{Object: objs.RenderedMachineConfig},
+		},
+	}, false)
+
+	// Files which are not Kube objects are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "event-filter.html"), []byte("<html></html>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "timestamp.yaml"), []byte("not: [valid"), 0o644))
+
+	getter, err := newMustGatherGetter(dir)
+	require.NoError(t, err)
+
+	buildObjs, err := getBuildObjects(context.Background(), getter, mosb.Name)
+	require.NoError(t, err)
+
+	assert.Equal(t, mosb.Name, buildObjs.mosb.Name)
+	assert.Equal(t, objs.MachineOSConfig.Name, buildObjs.mosc.Name)
+	assert.Equal(t, objs.RenderedMachineConfig.Name, buildObjs.mc.Name)
+	// The ControllerConfig is not in the must-gather.
+	assert.Nil(t, buildObjs.cc)
+
+	bc, err := buildrequest.NewBuildContext(buildObjs.mosb, buildObjs.mosc, buildObjs.mc, buildObjs.cc)
+	require.NoError(t, err)
+	assert.Equal(t, mosb.Name, bc.MachineOSBuildName())
+
+	_, err = getBuildObjects(context.Background(), getter, "nonexistent")
+	assert.ErrorContains(t, err, `could not get MachineOSBuild "nonexistent"`)
+
+	// The MachineOSConfig is required.
+	require.NoError(t, os.Remove(filepath.Join(dir, "machineosconfigs", objs.MachineOSConfig.Name+".yaml")))
+	getter, err = newMustGatherGetter(dir)
+	require.NoError(t, err)
+
+	_, err = getBuildObjects(context.Background(), getter, mosb.Name)
+	assert.ErrorContains(t, err, "could not get MachineOSConfig")
+}
+
+func TestShellJoin(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t,
+		`CONTAINERS_REGISTRIES_CONF='/tmp/build context/etc/registries.conf' podman build --build-arg=NO_PROXY= '--file=/tmp/it'\''s/Containerfile' --tag=registry.hostname.com/org/repo:tag`,
+		shellJoin([]string{
+			"CONTAINERS_REGISTRIES_CONF=/tmp/build context/etc/registries.conf",
+			"podman", "build",
+			"--build-arg=NO_PROXY=",
+			"--file=/tmp/it's/Containerfile",
+			"--tag=registry.hostname.com/org/repo:tag",
+		}))
+}
+
+func writeManifest(t *testing.T, path string, obj interface{}, asYAML bool) {
+	t.Helper()
+
+	out, err := json.Marshal(obj)
+	require.NoError(t, err)
+
+	if asYAML {
+		out, err = yaml.JSONToYAML(out)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, out, 0o644))
+}
@@ -11,6 +11,7 @@ import (
 	operatorclientset "github.com/openshift/client-go/operator/clientset/versioned"
 	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
 	apiext "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -84,6 +85,11 @@ func (cb *Builder) AROClientOrDie(name string) aroclientset.Interface {
 	return aroclientset.NewForConfigOrDie(rest.AddUserAgent(cb.config, name))
 }
 
+// DynamicClientOrDie returns the dynamic client interface for objects without a typed client.
+func (cb *Builder) DynamicClientOrDie(name string) dynamic.Interface {
+	return dynamic.NewForConfigOrDie(rest.AddUserAgent(cb.config, name))
+}
+
 // GetBuilderConfig returns a copy of the builders *rest.Config
 func (cb *Builder) GetBuilderConfig() *rest.Config {
 	return rest.CopyConfig(cb.config)
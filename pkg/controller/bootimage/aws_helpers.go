@@ -0,0 +1,186 @@
+package bootimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Keys of the AWS credentials secret referenced by the provider spec
+	awsAccessKeyIDKey     = "aws_access_key_id"
+	awsSecretAccessKeyKey = "aws_secret_access_key"
+	awsSessionTokenKey    = "aws_session_token"
+
+	// EC2 image state of an AMI that can be launched
+	ec2ImageStateAvailable = "available"
+
+	// ID of the EC2 service in the AWS SDK endpoints model, as well as in the service endpoints
+	// of the infrastructure object. Same as ec2.EndpointsID, without pulling in the EC2 client.
+	ec2EndpointsID = "ec2"
+)
+
+// newAWSBootImageVerifier is a variable so that tests can substitute a fake EC2 service
+var newAWSBootImageVerifier = newEC2ImageVerifierFromSecret
+
+// verifyAWSBootImage checks that the AMI can be launched by the cluster's AWS account in the
+// given region, using the credentials secret referenced by the provider spec.
+func verifyAWSBootImage(infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AWSMachineProviderConfig, region, ami string, secretClient clientset.Interface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bootImageVerificationTimeout)
+	defer cancel()
+
+	verifier, err := newAWSBootImageVerifier(ctx, infra, providerSpec, region, secretClient)
+	if err != nil {
+		return fmt.Errorf("failed to create EC2 client to verify AMI %s: %w", ami, err)
+	}
+	if verifier == nil {
+		return nil
+	}
+	if err := verifier.verifyImage(ctx, ami); err != nil {
+		return fmt.Errorf("failed to verify AMI %s in region %s: %w", ami, region, err)
+	}
+	klog.Infof("Verified AMI %s in region %s", ami, region)
+	return nil
+}
+
+// ec2ImageVerifier looks up AMIs with the EC2 DescribeImages API
+type ec2ImageVerifier struct {
+	httpClient      *http.Client
+	endpoint        string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	now             func() time.Time
+}
+
+// newEC2ImageVerifierFromSecret returns an EC2 client using the static credentials in the secret
+// referenced by the provider spec. Returns a nil verifier if the secret holds no static credentials,
+// e.g. on clusters using short-lived credentials, where the controller cannot assume the role.
+func newEC2ImageVerifierFromSecret(ctx context.Context, infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AWSMachineProviderConfig, region string, secretClient clientset.Interface) (bootImageVerifier, error) {
+	if providerSpec.CredentialsSecret == nil {
+		klog.Warningf("credentialsSecret is not set in the AWS provider spec, AMIs will not be verified")
+		return nil, nil
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(MachineAPINamespace).Get(ctx, providerSpec.CredentialsSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s/%s Secret during machineset sync: %w", MachineAPINamespace, providerSpec.CredentialsSecret.Name, err)
+	}
+
+	accessKeyID := string(secret.Data[awsAccessKeyIDKey])
+	secretAccessKey := string(secret.Data[awsSecretAccessKeyKey])
+	if accessKeyID == "" || secretAccessKey == "" {
+		klog.Warningf("Secret %s/%s holds no static AWS credentials, AMIs will not be verified", MachineAPINamespace, secret.Name)
+		return nil, nil
+	}
+
+	endpoint, err := getEC2Endpoint(infra, region)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := newHTTPClientWithCA(nil, bootImageVerificationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2ImageVerifier{
+		httpClient:      httpClient,
+		endpoint:        endpoint,
+		region:          region,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		sessionToken:    string(secret.Data[awsSessionTokenKey]),
+		now:             time.Now,
+	}, nil
+}
+
+// getEC2Endpoint returns the EC2 endpoint for the region, honouring a custom endpoint set on the
+// infrastructure object. Otherwise, the endpoint is resolved from the partitions known to the AWS
+// SDK, which covers the China and GovCloud regions as well.
+func getEC2Endpoint(infra *osconfigv1.Infrastructure, region string) (string, error) {
+	if infra != nil && infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.AWS != nil {
+		for _, endpoint := range infra.Status.PlatformStatus.AWS.ServiceEndpoints {
+			if endpoint.Name == ec2EndpointsID && endpoint.URL != "" {
+				return strings.TrimSuffix(endpoint.URL, "/"), nil
+			}
+		}
+	}
+	resolved, err := endpoints.DefaultResolver().EndpointFor(ec2EndpointsID, region)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve EC2 endpoint for region %s: %w", region, err)
+	}
+	return strings.TrimSuffix(resolved.URL, "/"), nil
+}
+
+// ec2DescribeImagesResponse holds the fields of a DescribeImages response that the MCO cares about
+type ec2DescribeImagesResponse struct {
+	Images []struct {
+		ImageID    string `xml:"imageId"`
+		ImageState string `xml:"imageState"`
+	} `xml:"imagesSet>item"`
+}
+
+// verifyImage returns an error unless the AMI exists, is visible to the account and is available
+func (e *ec2ImageVerifier) verifyImage(ctx context.Context, ami string) error {
+	body := []byte(url.Values{
+		"Action":    {"DescribeImages"},
+		"Version":   {"2016-11-15"},
+		"ImageId.1": {ami},
+	}.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signer := v4.NewSigner(credentials.NewStaticCredentials(e.accessKeyID, e.secretAccessKey, e.sessionToken))
+	if _, err := signer.Sign(req, bytes.NewReader(body), "ec2", e.region, e.now()); err != nil {
+		return fmt.Errorf("failed to sign DescribeImages request: %w", err)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DescribeImages returned status %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	images := ec2DescribeImagesResponse{}
+	if err := xml.Unmarshal(respBody, &images); err != nil {
+		return fmt.Errorf("failed to decode DescribeImages response: %w", err)
+	}
+	for _, image := range images.Images {
+		if image.ImageID != ami {
+			continue
+		}
+		if image.ImageState != ec2ImageStateAvailable {
+			return fmt.Errorf("AMI %s is %s", ami, image.ImageState)
+		}
+		return nil
+	}
+	return fmt.Errorf("AMI %s was not found or is not accessible", ami)
+}
@@ -0,0 +1,317 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// fakeBootImageVerifier records the images it was asked to verify
+type fakeBootImageVerifier struct {
+	err      error
+	verified []string
+}
+
+func (f *fakeBootImageVerifier) verifyImage(_ context.Context, image string) error {
+	f.verified = append(f.verified, image)
+	return f.err
+}
+
+func TestReconcileAWSProviderSpecVerification(t *testing.T) {
+	// Any AMI from the allowlist will do as the current AMI
+	currentAMI := "ami-000145e5a91e9ac22"
+
+	testSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"userData": []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[]},"systemd":{},"passwd":{}}`),
+		},
+	}
+
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Images: stream.Images{
+					Aws: &stream.AwsImage{
+						Regions: map[string]stream.SingleImage{
+							"us-east-1": {Image: "ami-new"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		verifier      *fakeBootImageVerifier
+		currentAMI    string
+		expectPatch   bool
+		expectVerify  bool
+		expectErr     bool
+		noCredentials bool
+	}{
+		{
+			name:         "Verified AMI is patched",
+			verifier:     &fakeBootImageVerifier{},
+			currentAMI:   currentAMI,
+			expectPatch:  true,
+			expectVerify: true,
+		},
+		{
+			name:         "Missing AMI is not patched",
+			verifier:     &fakeBootImageVerifier{err: fmt.Errorf("AMI ami-new was not found or is not accessible")},
+			currentAMI:   currentAMI,
+			expectVerify: true,
+			expectErr:    true,
+		},
+		{
+			name:          "AMI is patched without verification when there are no usable credentials",
+			currentAMI:    currentAMI,
+			expectPatch:   true,
+			noCredentials: true,
+		},
+		{
+			name:       "Current AMI is not verified",
+			verifier:   &fakeBootImageVerifier{},
+			currentAMI: "ami-new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newAWSBootImageVerifier = func(_ context.Context, _ *osconfigv1.Infrastructure, _ *machinev1beta1.AWSMachineProviderConfig, _ string, _ kubernetes.Interface) (bootImageVerifier, error) {
+				if tt.noCredentials {
+					return nil, nil
+				}
+				return tt.verifier, nil
+			}
+			t.Cleanup(func() { newAWSBootImageVerifier = newEC2ImageVerifierFromSecret })
+
+			providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+				AMI:            machinev1beta1.AWSResourceReference{ID: ptr.To(tt.currentAMI)},
+				Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+				UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+			}
+
+			patchRequired, patchSkipped, updatedProviderSpec, err := reconcileAWSProviderSpec(streamData, "x86_64", &osconfigv1.Infrastructure{}, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+
+			if tt.verifier != nil {
+				if tt.expectVerify {
+					assert.Equal(t, []string{"ami-new"}, tt.verifier.verified)
+				} else {
+					assert.Empty(t, tt.verifier.verified)
+				}
+			}
+
+			if tt.expectErr {
+				assert.ErrorContains(t, err, "failed to verify AMI ami-new in region us-east-1")
+				assert.Nil(t, updatedProviderSpec)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.False(t, patchSkipped)
+			assert.Equal(t, tt.expectPatch, patchRequired)
+			if tt.expectPatch {
+				assert.Equal(t, "ami-new", *updatedProviderSpec.AMI.ID)
+			}
+		})
+	}
+}
+
+// This tests the signed DescribeImages requests made by ec2ImageVerifier
+func TestEC2ImageVerifier(t *testing.T) {
+	images := map[string]string{
+		"ami-available": ec2ImageStateAvailable,
+		"ami-pending":   "pending",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		authorization := r.Header.Get("Authorization")
+		assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20250101/us-east-1/ec2/aws4_request, SignedHeaders="))
+		assert.Contains(t, authorization, "content-type;host;x-amz-date;x-amz-security-token, Signature=")
+		assert.Equal(t, "20250101T120000Z", r.Header.Get("X-Amz-Date"))
+		assert.Equal(t, "session-token", r.Header.Get("X-Amz-Security-Token"))
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		form, err := url.ParseQuery(string(body))
+		require.NoError(t, err)
+		assert.Equal(t, "DescribeImages", form.Get("Action"))
+
+		ami := form.Get("ImageId.1")
+		if ami == "ami-forbidden" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Response><Errors><Error><Code>InvalidAMIID.NotFound</Code></Error></Errors></Response>`)
+			return
+		}
+		fmt.Fprint(w, `<DescribeImagesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><imagesSet>`)
+		if state, ok := images[ami]; ok {
+			fmt.Fprintf(w, `<item><imageId>%s</imageId><imageState>%s</imageState></item>`, ami, state)
+		}
+		fmt.Fprint(w, `</imagesSet></DescribeImagesResponse>`)
+	}))
+	defer server.Close()
+
+	verifier := &ec2ImageVerifier{
+		httpClient:      server.Client(),
+		endpoint:        server.URL,
+		region:          "us-east-1",
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "secret",
+		sessionToken:    "session-token",
+		now:             func() time.Time { return time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC) },
+	}
+
+	assert.NoError(t, verifier.verifyImage(context.Background(), "ami-available"))
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "ami-pending"), "AMI ami-pending is pending")
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "ami-missing"), "AMI ami-missing was not found or is not accessible")
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "ami-forbidden"), "InvalidAMIID.NotFound")
+}
+
+func TestNewEC2ImageVerifierFromSecret(t *testing.T) {
+	secretClient := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "aws-cloud-credentials",
+				Namespace: "openshift-machine-api",
+			},
+			Data: map[string][]byte{
+				"aws_access_key_id":     []byte("AKIDEXAMPLE"),
+				"aws_secret_access_key": []byte("secret"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "aws-sts-credentials",
+				Namespace: "openshift-machine-api",
+			},
+			Data: map[string][]byte{
+				"credentials": []byte("[default]\nrole_arn = arn:aws:iam::123456789012:role/machine-api\nweb_identity_token_file = /var/run/secrets/openshift/serviceaccount/token"),
+			},
+		},
+	)
+
+	infra := &osconfigv1.Infrastructure{
+		Status: osconfigv1.InfrastructureStatus{
+			PlatformStatus: &osconfigv1.PlatformStatus{
+				AWS: &osconfigv1.AWSPlatformStatus{
+					ServiceEndpoints: []osconfigv1.AWSServiceEndpoint{
+						{Name: "ec2", URL: "https://ec2.custom.example.com/"},
+					},
+				},
+			},
+		},
+	}
+
+	providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+		CredentialsSecret: &corev1.LocalObjectReference{Name: "aws-cloud-credentials"},
+	}
+
+	verifier, err := newEC2ImageVerifierFromSecret(context.Background(), infra, providerSpec, "us-east-1", secretClient)
+	require.NoError(t, err)
+
+	ec2Verifier := verifier.(*ec2ImageVerifier)
+	assert.Equal(t, "https://ec2.custom.example.com", ec2Verifier.endpoint)
+	assert.Equal(t, "AKIDEXAMPLE", ec2Verifier.accessKeyID)
+	assert.Equal(t, "secret", ec2Verifier.secretAccessKey)
+
+	// Short-lived credentials cannot be used by the controller, so verification is skipped
+	providerSpec.CredentialsSecret.Name = "aws-sts-credentials"
+	verifier, err = newEC2ImageVerifierFromSecret(context.Background(), infra, providerSpec, "us-east-1", secretClient)
+	require.NoError(t, err)
+	assert.Nil(t, verifier)
+
+	providerSpec.CredentialsSecret.Name = "nonexistent"
+	_, err = newEC2ImageVerifierFromSecret(context.Background(), infra, providerSpec, "us-east-1", secretClient)
+	assert.Error(t, err)
+
+}
+
+func TestGetEC2Endpoint(t *testing.T) {
+	for region, expected := range map[string]string{
+		"eu-west-1":     "https://ec2.eu-west-1.amazonaws.com",
+		"cn-north-1":    "https://ec2.cn-north-1.amazonaws.com.cn",
+		"us-gov-west-1": "https://ec2.us-gov-west-1.amazonaws.com",
+		"us-iso-east-1": "https://ec2.us-iso-east-1.c2s.ic.gov",
+	} {
+		endpoint, err := getEC2Endpoint(nil, region)
+		require.NoError(t, err)
+		assert.Equal(t, expected, endpoint, region)
+	}
+
+	infra := &osconfigv1.Infrastructure{Status: osconfigv1.InfrastructureStatus{PlatformStatus: &osconfigv1.PlatformStatus{
+		AWS: &osconfigv1.AWSPlatformStatus{
+			Region: "us-east-1",
+			ServiceEndpoints: []osconfigv1.AWSServiceEndpoint{
+				{Name: "s3", URL: "https://s3.example.com"},
+				{Name: "ec2", URL: "https://ec2.example.com/"},
+			},
+		},
+	}}}
+	endpoint, err := getEC2Endpoint(infra, "us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ec2.example.com", endpoint)
+}
+
+func TestNewHTTPClientWithCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	get := func(httpClient *http.Client) error {
+		resp, err := httpClient.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	clusterTrustedCABundlePath = filepath.Join(t.TempDir(), "ca-bundle.crt")
+	t.Cleanup(func() { clusterTrustedCABundlePath = "/etc/machine-config-controller/trusted-ca/ca-bundle.crt" })
+
+	// Without the trusted CA bundle, the server is not trusted
+	httpClient, err := newHTTPClientWithCA(nil, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, httpClient.Timeout)
+	assert.Error(t, get(httpClient))
+
+	// The given CA bundle is trusted
+	httpClient, err = newHTTPClientWithCA(caBundle, time.Minute)
+	require.NoError(t, err)
+	assert.NoError(t, get(httpClient))
+
+	// The cluster's trusted CA bundle is trusted
+	require.NoError(t, os.WriteFile(clusterTrustedCABundlePath, caBundle, 0o644))
+	httpClient, err = newHTTPClientWithCA(nil, time.Minute)
+	require.NoError(t, err)
+	assert.NoError(t, get(httpClient))
+}
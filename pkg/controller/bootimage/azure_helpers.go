@@ -0,0 +1,176 @@
+package bootimage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Keys of the Azure credentials secret referenced by the provider spec
+	azureClientIDKey       = "azure_client_id"
+	azureClientSecretKey   = "azure_client_secret"
+	azureTenantIDKey       = "azure_tenant_id"
+	azureSubscriptionIDKey = "azure_subscription_id"
+
+	// API version used to look up marketplace images
+	azureComputeAPIVersion = "2024-07-01"
+)
+
+// azureCloudEndpoints holds the endpoints of an Azure cloud environment that the MCO cares about
+type azureCloudEndpoints struct {
+	activeDirectory string
+	resourceManager string
+}
+
+// azureClouds maps the cloud environments boot image updates are supported on to their endpoints
+var azureClouds = map[osconfigv1.AzureCloudEnvironment]azureCloudEndpoints{
+	osconfigv1.AzurePublicCloud: {
+		activeDirectory: "https://login.microsoftonline.com",
+		resourceManager: "https://management.azure.com",
+	},
+	osconfigv1.AzureUSGovernmentCloud: {
+		activeDirectory: "https://login.microsoftonline.us",
+		resourceManager: "https://management.usgovcloudapi.net",
+	},
+	osconfigv1.AzureChinaCloud: {
+		activeDirectory: "https://login.chinacloudapi.cn",
+		resourceManager: "https://management.chinacloudapi.cn",
+	},
+	osconfigv1.AzureGermanCloud: {
+		activeDirectory: "https://login.microsoftonline.de",
+		resourceManager: "https://management.microsoftazure.de",
+	},
+}
+
+// newAzureBootImageVerifier is a variable so that tests can substitute a fake Compute service
+var newAzureBootImageVerifier = newMarketplaceImageVerifierFromSecret
+
+// verifyAzureBootImage checks that the marketplace image can be used by the cluster's Azure
+// subscription in the given location, using the credentials secret referenced by the provider spec.
+func verifyAzureBootImage(infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AzureMachineProviderSpec, image machinev1beta1.Image, secretClient clientset.Interface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bootImageVerificationTimeout)
+	defer cancel()
+
+	urn := getAzureImageURN(image)
+	verifier, err := newAzureBootImageVerifier(ctx, infra, providerSpec, secretClient)
+	if err != nil {
+		return fmt.Errorf("failed to create Compute client to verify image %s: %w", urn, err)
+	}
+	if verifier == nil {
+		return nil
+	}
+	if err := verifier.verifyImage(ctx, urn); err != nil {
+		return fmt.Errorf("failed to verify image %s in location %s: %w", urn, providerSpec.Location, err)
+	}
+	klog.Infof("Verified image %s in location %s", urn, providerSpec.Location)
+	return nil
+}
+
+// getAzureImageURN returns the publisher:offer:sku:version URN of a marketplace image
+func getAzureImageURN(image machinev1beta1.Image) string {
+	return strings.Join([]string{image.Publisher, image.Offer, image.SKU, image.Version}, ":")
+}
+
+// marketplaceImageVerifier looks up marketplace images with the Azure Compute API
+type marketplaceImageVerifier struct {
+	// httpClient adds the service principal's access token to requests
+	httpClient     *http.Client
+	endpoint       string
+	subscriptionID string
+	location       string
+}
+
+// newMarketplaceImageVerifierFromSecret returns an Azure Compute client using the service principal
+// in the secret referenced by the provider spec. Returns a nil verifier if the secret holds no client
+// secret, e.g. on clusters using workload identity, where the controller cannot use the federated token.
+func newMarketplaceImageVerifierFromSecret(ctx context.Context, infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AzureMachineProviderSpec, secretClient clientset.Interface) (bootImageVerifier, error) {
+	if providerSpec.CredentialsSecret == nil {
+		klog.Warningf("credentialsSecret is not set in the Azure provider spec, images will not be verified")
+		return nil, nil
+	}
+
+	cloudName := osconfigv1.AzurePublicCloud
+	if infra != nil && infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Azure != nil && infra.Status.PlatformStatus.Azure.CloudName != "" {
+		cloudName = infra.Status.PlatformStatus.Azure.CloudName
+	}
+	cloud, ok := azureClouds[cloudName]
+	if !ok {
+		klog.Warningf("Azure cloud %s is not supported for image verification, images will not be verified", cloudName)
+		return nil, nil
+	}
+
+	namespace := providerSpec.CredentialsSecret.Namespace
+	if namespace == "" {
+		namespace = MachineAPINamespace
+	}
+	secret, err := secretClient.CoreV1().Secrets(namespace).Get(ctx, providerSpec.CredentialsSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s/%s Secret during machineset sync: %w", namespace, providerSpec.CredentialsSecret.Name, err)
+	}
+
+	clientID := string(secret.Data[azureClientIDKey])
+	clientSecret := string(secret.Data[azureClientSecretKey])
+	tenantID := string(secret.Data[azureTenantIDKey])
+	subscriptionID := string(secret.Data[azureSubscriptionIDKey])
+	if clientID == "" || clientSecret == "" || tenantID == "" || subscriptionID == "" {
+		klog.Warningf("Secret %s/%s holds no Azure client secret, images will not be verified", namespace, secret.Name)
+		return nil, nil
+	}
+
+	httpClient, err := newHTTPClientWithCA(nil, bootImageVerificationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMarketplaceImageVerifier(ctx, httpClient, cloud, clientID, clientSecret, tenantID, subscriptionID, providerSpec.Location), nil
+}
+
+// newMarketplaceImageVerifier returns an Azure Compute client which authenticates as the service
+// principal with the client credentials flow.
+// See https://learn.microsoft.com/en-us/entra/identity-platform/v2-oauth2-client-creds-grant-flow
+func newMarketplaceImageVerifier(ctx context.Context, httpClient *http.Client, cloud azureCloudEndpoints, clientID, clientSecret, tenantID, subscriptionID, location string) *marketplaceImageVerifier {
+	config := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", cloud.activeDirectory, url.PathEscape(tenantID)),
+		Scopes:       []string{cloud.resourceManager + "/.default"},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+
+	return &marketplaceImageVerifier{
+		httpClient:     config.Client(context.WithValue(ctx, oauth2.HTTPClient, httpClient)),
+		endpoint:       cloud.resourceManager,
+		subscriptionID: subscriptionID,
+		location:       location,
+	}
+}
+
+// verifyImage returns an error unless the marketplace image, given as a publisher:offer:sku:version
+// URN, exists in the location and is visible to the subscription.
+func (m *marketplaceImageVerifier) verifyImage(ctx context.Context, urn string) error {
+	parts := strings.Split(urn, ":")
+	if len(parts) != 4 {
+		return fmt.Errorf("invalid marketplace image URN %q", urn)
+	}
+
+	path := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Compute/locations/%s/publishers/%s/artifacttypes/vmimage/offers/%s/skus/%s/versions/%s",
+		url.PathEscape(m.subscriptionID), url.PathEscape(m.location), url.PathEscape(parts[0]), url.PathEscape(parts[1]), url.PathEscape(parts[2]), url.PathEscape(parts[3]))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint+path+"?api-version="+azureComputeAPIVersion, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = doJSONRequest(m.httpClient, req, http.StatusOK, nil)
+	return err
+}
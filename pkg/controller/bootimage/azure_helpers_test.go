@@ -0,0 +1,183 @@
+package bootimage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	"github.com/coreos/stream-metadata-go/stream/rhcos"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileAzureProviderSpecVerification(t *testing.T) {
+	testSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"userData": []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[]},"systemd":{},"passwd":{}}`),
+		},
+	}
+
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				RHELCoreOSExtensions: &rhcos.Extensions{
+					Marketplace: &rhcos.Marketplace{
+						Azure: &rhcos.AzureMarketplace{
+							NoPurchasePlan: &rhcos.AzureMarketplaceImages{
+								Gen2: &rhcos.AzureMarketplaceImage{
+									Offer:     "aro4",
+									Publisher: "azureopenshift",
+									SKU:       "419-v2",
+									Version:   "419.94.20250101",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	providerSpec := &machinev1beta1.AzureMachineProviderSpec{
+		Image: machinev1beta1.Image{
+			Offer:     "aro4",
+			Publisher: "azureopenshift",
+			SKU:       "418-v2",
+			Version:   "418.94.20241201",
+			Type:      machinev1beta1.AzureImageTypeMarketplaceNoPlan,
+		},
+		Location:       "eastus",
+		UserDataSecret: &corev1.SecretReference{Name: "test-secret"},
+	}
+
+	verifier := &fakeBootImageVerifier{}
+	newAzureBootImageVerifier = func(_ context.Context, _ *osconfigv1.Infrastructure, _ *machinev1beta1.AzureMachineProviderSpec, _ kubernetes.Interface) (bootImageVerifier, error) {
+		return verifier, nil
+	}
+	t.Cleanup(func() { newAzureBootImageVerifier = newMarketplaceImageVerifierFromSecret })
+
+	patchRequired, _, updatedProviderSpec, err := reconcileAzureProviderSpec(streamData, "x86_64", &osconfigv1.Infrastructure{}, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+	require.NoError(t, err)
+	assert.True(t, patchRequired)
+	assert.Equal(t, "419-v2", updatedProviderSpec.Image.SKU)
+	assert.Equal(t, []string{"azureopenshift:aro4:419-v2:419.94.20250101"}, verifier.verified)
+
+	verifier.err = fmt.Errorf("returned status 404 Not Found")
+	_, _, updatedProviderSpec, err = reconcileAzureProviderSpec(streamData, "x86_64", &osconfigv1.Infrastructure{}, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+	assert.ErrorContains(t, err, "failed to verify image azureopenshift:aro4:419-v2:419.94.20250101 in location eastus")
+	assert.Nil(t, updatedProviderSpec)
+}
+
+// This tests the token exchange and image lookups made by marketplaceImageVerifier
+func TestMarketplaceImageVerifier(t *testing.T) {
+	imagePath := "/subscriptions/test-subscription/providers/Microsoft.Compute/locations/eastus/publishers/azureopenshift/artifacttypes/vmimage/offers/aro4/skus/419-v2/versions/"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "test-client", r.PostForm.Get("client_id"))
+		assert.Equal(t, "test-secret", r.PostForm.Get("client_secret"))
+		assert.Equal(t, "https://management.example.com/.default", r.PostForm.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/subscriptions/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		assert.Equal(t, azureComputeAPIVersion, r.URL.Query().Get("api-version"))
+		if r.URL.Path != imagePath+"419.94.20250101" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"ImageVersionNotFound","message":"Artifact: VMImage was not found."}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name":"419.94.20250101","location":"eastus"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cloud := azureCloudEndpoints{
+		activeDirectory: server.URL,
+		resourceManager: "https://management.example.com",
+	}
+	verifier := newMarketplaceImageVerifier(context.Background(), server.Client(), cloud, "test-client", "test-secret", "test-tenant", "test-subscription", "eastus")
+	verifier.endpoint = server.URL
+
+	assert.NoError(t, verifier.verifyImage(context.Background(), "azureopenshift:aro4:419-v2:419.94.20250101"))
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "azureopenshift:aro4:419-v2:419.94.20240101"), "ImageVersionNotFound")
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "azureopenshift:aro4"), "invalid marketplace image URN")
+}
+
+func TestNewMarketplaceImageVerifierFromSecret(t *testing.T) {
+	secretClient := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "azure-cloud-credentials",
+				Namespace: "openshift-machine-api",
+			},
+			Data: map[string][]byte{
+				"azure_client_id":       []byte("test-client"),
+				"azure_client_secret":   []byte("test-secret"),
+				"azure_tenant_id":       []byte("test-tenant"),
+				"azure_subscription_id": []byte("test-subscription"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "azure-workload-identity-credentials",
+				Namespace: "openshift-machine-api",
+			},
+			Data: map[string][]byte{
+				"azure_client_id":            []byte("test-client"),
+				"azure_tenant_id":            []byte("test-tenant"),
+				"azure_subscription_id":      []byte("test-subscription"),
+				"azure_federated_token_file": []byte("/var/run/secrets/openshift/serviceaccount/token"),
+			},
+		},
+	)
+
+	infra := &osconfigv1.Infrastructure{
+		Status: osconfigv1.InfrastructureStatus{
+			PlatformStatus: &osconfigv1.PlatformStatus{
+				Azure: &osconfigv1.AzurePlatformStatus{CloudName: osconfigv1.AzureUSGovernmentCloud},
+			},
+		},
+	}
+
+	providerSpec := &machinev1beta1.AzureMachineProviderSpec{
+		CredentialsSecret: &corev1.SecretReference{Name: "azure-cloud-credentials", Namespace: "openshift-machine-api"},
+		Location:          "usgovvirginia",
+	}
+
+	verifier, err := newMarketplaceImageVerifierFromSecret(context.Background(), infra, providerSpec, secretClient)
+	require.NoError(t, err)
+
+	marketplaceVerifier := verifier.(*marketplaceImageVerifier)
+	assert.Equal(t, "https://management.usgovcloudapi.net", marketplaceVerifier.endpoint)
+	assert.Equal(t, "test-subscription", marketplaceVerifier.subscriptionID)
+	assert.Equal(t, "usgovvirginia", marketplaceVerifier.location)
+
+	// Workload identity credentials cannot be used by the controller, so verification is skipped
+	providerSpec.CredentialsSecret.Name = "azure-workload-identity-credentials"
+	verifier, err = newMarketplaceImageVerifierFromSecret(context.Background(), infra, providerSpec, secretClient)
+	require.NoError(t, err)
+	assert.Nil(t, verifier)
+
+	providerSpec.CredentialsSecret.Name = "nonexistent"
+	_, err = newMarketplaceImageVerifierFromSecret(context.Background(), infra, providerSpec, secretClient)
+	assert.Error(t, err)
+}
@@ -15,9 +15,13 @@ import (
 	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	k8sversion "k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -48,6 +52,7 @@ type Controller struct {
 	kubeClient    clientset.Interface
 	machineClient machineclientset.Interface
 	mcopClient    mcopclientset.Interface
+	dynamicClient dynamic.Interface
 	eventRecorder record.EventRecorder
 
 	syncHandler func(event string) error
@@ -66,6 +71,14 @@ type Controller struct {
 	mcopListerSynced           cache.InformerSynced
 	clusterVersionListerSynced cache.InformerSynced
 
+	// CAPI machine resources are only watched when the ClusterAPIMachineManagement
+	// feature gate is enabled.
+	capiInformerFactory               dynamicinformer.DynamicSharedInformerFactory
+	capiMachineSetLister              cache.GenericLister
+	capiMachineDeploymentLister       cache.GenericLister
+	capiMachineSetListerSynced        cache.InformerSynced
+	capiMachineDeploymentListerSynced cache.InformerSynced
+
 	queue workqueue.TypedRateLimitingInterface[string]
 
 	mapiStats                  MachineResourceStats
@@ -74,6 +87,7 @@ type Controller struct {
 	capiMachineDeploymentStats MachineResourceStats
 	mapiBootImageState         map[string]BootImageState
 	cpmsBootImageState         map[string]BootImageState
+	capiBootImageState         map[string]BootImageState
 
 	fgHandler ctrlcommon.FeatureGatesHandler
 }
@@ -139,6 +153,7 @@ const (
 func New(
 	kubeClient clientset.Interface,
 	machineClient machineclientset.Interface,
+	dynamicClient dynamic.Interface,
 	mcoCmInfomer coreinformersv1.ConfigMapInformer,
 	mapiMachineSetInformer mapimachineinformersv1beta1.MachineSetInformer,
 	cpmsInformer mapimachineinformersv1.ControlPlaneMachineSetInformer,
@@ -156,6 +171,7 @@ func New(
 		kubeClient:    kubeClient,
 		machineClient: machineClient,
 		mcopClient:    mcopClient,
+		dynamicClient: dynamicClient,
 		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-machinesetbootimagecontroller"})),
 		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
 			workqueue.DefaultTypedControllerRateLimiter[string](),
@@ -193,6 +209,26 @@ func New(
 		})
 	}
 
+	if fgHandler.Enabled(features.FeatureGateClusterAPIMachineManagement) {
+		klog.V(4).Infof("ClusterAPIMachineManagement feature gate is enabled, adding CAPI event handlers")
+		ctrl.capiInformerFactory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, 0, ClusterAPINamespace, nil)
+		capiMachineSetInformer := ctrl.capiInformerFactory.ForResource(capiMachineSetGVR)
+		capiMachineDeploymentInformer := ctrl.capiInformerFactory.ForResource(capiMachineDeploymentGVR)
+
+		ctrl.capiMachineSetLister = capiMachineSetInformer.Lister()
+		ctrl.capiMachineDeploymentLister = capiMachineDeploymentInformer.Lister()
+		ctrl.capiMachineSetListerSynced = capiMachineSetInformer.Informer().HasSynced
+		ctrl.capiMachineDeploymentListerSynced = capiMachineDeploymentInformer.Informer().HasSynced
+
+		for _, informer := range []informers.GenericInformer{capiMachineSetInformer, capiMachineDeploymentInformer} {
+			informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc:    ctrl.addCAPIMachineResource,
+				UpdateFunc: ctrl.updateCAPIMachineResource,
+				DeleteFunc: ctrl.deleteCAPIMachineResource,
+			})
+		}
+	}
+
 	mcoCmInfomer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.addConfigMap,
 		UpdateFunc: ctrl.updateConfigMap,
@@ -213,6 +249,7 @@ func New(
 
 	ctrl.mapiBootImageState = map[string]BootImageState{}
 	ctrl.cpmsBootImageState = map[string]BootImageState{}
+	ctrl.capiBootImageState = map[string]BootImageState{}
 
 	return ctrl
 }
@@ -233,6 +270,12 @@ func (ctrl *Controller) Run(stopCh <-chan struct{}) {
 	// the same and shouldn't overlap each other.
 	go wait.Until(ctrl.worker, time.Second, stopCh)
 
+	// The CAPI CRDs may not be installed, so the CAPI informers are started separately and
+	// never hold up the reconciliation of the other machine resources.
+	if ctrl.capiInformerFactory != nil {
+		go ctrl.runCAPIInformers(stopCh)
+	}
+
 	<-stopCh
 }
 
@@ -383,6 +426,68 @@ func (ctrl *Controller) deleteControlPlaneMachineSet(deletedCPMS interface{}) {
 	ctrl.enqueueEvent("ControlPlaneMachineSetDeleted")
 }
 
+// addCAPIMachineResource handles the addition of a CAPI MachineSet or MachineDeployment by
+// triggering a reconciliation of all enrolled CAPI machine resources.
+func (ctrl *Controller) addCAPIMachineResource(obj interface{}) {
+
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	klog.Infof("CAPI %s %s added, reconciling enrolled machine resources", resource.GetKind(), resource.GetName())
+
+	// Update/Check all CAPI machine resources instead of just this one. As this is using a lister,
+	// it is relatively inexpensive to do this.
+	ctrl.enqueueEvent("CAPI" + resource.GetKind() + "Added")
+}
+
+// updateCAPIMachineResource handles updates to a CAPI MachineSet or MachineDeployment by triggering
+// a reconciliation if the template, labels, annotations, or owner references changed.
+func (ctrl *Controller) updateCAPIMachineResource(oldObj, newObj interface{}) {
+
+	oldResource, ok := oldObj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	newResource, ok := newObj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	oldTemplate, _, _ := unstructured.NestedMap(oldResource.Object, "spec", "template")
+	newTemplate, _, _ := unstructured.NestedMap(newResource.Object, "spec", "template")
+
+	// Don't take action if the there is no change in the template, labels, annotations and ownerreferences
+	if reflect.DeepEqual(oldTemplate, newTemplate) &&
+		reflect.DeepEqual(oldResource.GetLabels(), newResource.GetLabels()) &&
+		reflect.DeepEqual(oldResource.GetAnnotations(), newResource.GetAnnotations()) &&
+		reflect.DeepEqual(oldResource.GetOwnerReferences(), newResource.GetOwnerReferences()) {
+		return
+	}
+
+	klog.Infof("CAPI %s %s updated, reconciling enrolled machine resources", oldResource.GetKind(), oldResource.GetName())
+
+	ctrl.enqueueEvent("CAPI" + newResource.GetKind() + "Updated")
+}
+
+// deleteCAPIMachineResource handles the deletion of a CAPI MachineSet or MachineDeployment by
+// triggering a reconciliation of all enrolled CAPI machine resources.
+func (ctrl *Controller) deleteCAPIMachineResource(obj interface{}) {
+
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	resource, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	klog.Infof("CAPI %s %s deleted, reconciling enrolled machine resources", resource.GetKind(), resource.GetName())
+
+	ctrl.enqueueEvent("CAPI" + resource.GetKind() + "Deleted")
+}
+
 // addConfigMap handles the addition of the boot images ConfigMap by triggering
 // a reconciliation of all enrolled machine resources.
 func (ctrl *Controller) addConfigMap(obj interface{}) {
@@ -471,9 +576,10 @@ func (ctrl *Controller) updateMachineConfiguration(oldMC, newMC interface{}) {
 		return
 	}
 
-	// Skip reconciliation if neither ManagedBootImagesStatus nor BootImageSkewEnforcementStatus has changed.
-	// BootImageSkewEnforcementStatus is only checked when the BootImageSkewEnforcement feature gate is enabled.
+	// Skip reconciliation if neither ManagedBootImagesStatus, the CAPI machine managers nor BootImageSkewEnforcementStatus
+	// has changed. BootImageSkewEnforcementStatus is only checked when the BootImageSkewEnforcement feature gate is enabled.
 	if reflect.DeepEqual(oldMachineConfiguration.Status.ManagedBootImagesStatus, newMachineConfiguration.Status.ManagedBootImagesStatus) &&
+		oldMachineConfiguration.GetAnnotations()[ClusterAPIManagedBootImagesAnnotationKey] == newMachineConfiguration.GetAnnotations()[ClusterAPIManagedBootImagesAnnotationKey] &&
 		(!ctrl.fgHandler.Enabled(features.FeatureGateBootImageSkewEnforcement) ||
 			reflect.DeepEqual(oldMachineConfiguration.Status.BootImageSkewEnforcementStatus, newMachineConfiguration.Status.BootImageSkewEnforcementStatus)) {
 		return
@@ -703,5 +809,12 @@ func (ctrl *Controller) syncAll(event string) error {
 
 	ctrl.syncControlPlaneMachineSets(event)
 	ctrl.syncMAPIMachineSets(event)
+	if ctrl.capiInformersSynced() {
+		ctrl.syncCAPIMachineResources(event, capiMachineSetGVR)
+		ctrl.syncCAPIMachineResources(event, capiMachineDeploymentGVR)
+		if err := ctrl.pruneCAPIMachineTemplateClones(); err != nil {
+			klog.Errorf("Failed to prune unreferenced CAPI machine template clones: %v", err)
+		}
+	}
 	return nil
 }
@@ -133,8 +133,15 @@ func cacheFile(ova *stream.Artifact, filePath, cacheDir string) (string, error)
 // download obtains a file from a given URL, puts it in the cache folder, defined by dataType parameter,
 // and returns the local file path.
 func DownloadOva(ova *stream.Artifact) (string, error) {
+	return DownloadArtifact(ova)
+}
+
+// DownloadArtifact obtains any stream artifact the same way as DownloadOva and
+// returns the local file path. The artifact is cached as-is, without being
+// decompressed.
+func DownloadArtifact(artifact *stream.Artifact) (string, error) {
 
-	fileName, err := ova.Name()
+	fileName, err := artifact.Name()
 	if err != nil {
 		return "", err
 	}
@@ -150,12 +157,12 @@ func DownloadOva(ova *stream.Artifact) (string, error) {
 	}
 	if filePath != "" {
 		// Found cached file
-		if hash == ova.Sha256 {
+		if hash == artifact.Sha256 {
 			return filePath, nil
 		}
 		klog.Infof("Cache for %v is corrupted", filePath)
 	}
 
 	filePath = filepath.Join(cacheDir, fileName)
-	return cacheFile(ova, filePath, cacheDir)
+	return cacheFile(artifact, filePath, cacheDir)
 }
@@ -0,0 +1,688 @@
+package bootimage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	operatorversion "github.com/openshift/machine-config-operator/pkg/version"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Name of cluster api namespace
+	ClusterAPINamespace = "openshift-cluster-api"
+
+	// API group and resources of the CAPI machine resources managed by this controller. The
+	// MachineConfiguration API only accepts the machine.openshift.io group, so CAPI machine
+	// managers are supplied via ClusterAPIManagedBootImagesAnnotationKey instead.
+	ClusterAPIGroup              opv1.MachineManagerMachineSetsAPIGroupType = "cluster.x-k8s.io"
+	ClusterAPIMachineSets        opv1.MachineManagerMachineSetsResourceType = "machinesets"
+	ClusterAPIMachineDeployments opv1.MachineManagerMachineSetsResourceType = "machinedeployments"
+
+	// Annotation on the cluster MachineConfiguration holding a JSON list of machine managers
+	// for CAPI machine resources, e.g.
+	// [{"resource":"machinedeployments","apiGroup":"cluster.x-k8s.io","selection":{"mode":"All"}}]
+	ClusterAPIManagedBootImagesAnnotationKey = "machineconfiguration.openshift.io/cluster-api-managed-boot-images"
+
+	// Annotation set on cloned infrastructure machine templates, recording the template the
+	// first clone was made from. This keeps clone names from growing on every boot image update.
+	CAPISourceTemplateAnnotationKey = "machineconfiguration.openshift.io/boot-image-source-template"
+
+	// How often to check whether the CAPI CRDs have been installed
+	capiCRDPollInterval = time.Minute
+)
+
+var (
+	capiMachineSetGVR        = schema.GroupVersionResource{Group: string(ClusterAPIGroup), Version: "v1beta1", Resource: string(ClusterAPIMachineSets)}
+	capiMachineDeploymentGVR = schema.GroupVersionResource{Group: string(ClusterAPIGroup), Version: "v1beta1", Resource: string(ClusterAPIMachineDeployments)}
+)
+
+// capiMachineTemplate describes an infrastructure machine template kind that this controller
+// knows how to update.
+type capiMachineTemplate struct {
+	resource  string
+	platform  osconfigv1.PlatformType
+	reconcile func(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, templateSpec map[string]any, templateName string) (patchRequired, patchSkipped bool, err error)
+}
+
+// Infrastructure machine template kinds supported by the boot image controller, keyed by kind.
+var capiMachineTemplates = map[string]capiMachineTemplate{
+	"AWSMachineTemplate": {resource: "awsmachinetemplates", platform: osconfigv1.AWSPlatformType, reconcile: reconcileAWSMachineTemplateSpec},
+	"GCPMachineTemplate": {resource: "gcpmachinetemplates", platform: osconfigv1.GCPPlatformType, reconcile: reconcileGCPMachineTemplateSpec},
+}
+
+// getCAPIMachineManagers parses the CAPI machine managers from the MachineConfiguration annotation.
+// Returns an empty list if the annotation is not set.
+func getCAPIMachineManagers(mcop *opv1.MachineConfiguration) ([]opv1.MachineManager, error) {
+	value, ok := mcop.GetAnnotations()[ClusterAPIManagedBootImagesAnnotationKey]
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	machineManagers := []opv1.MachineManager{}
+	if err := json.Unmarshal([]byte(value), &machineManagers); err != nil {
+		return nil, fmt.Errorf("could not parse %s annotation: %w", ClusterAPIManagedBootImagesAnnotationKey, err)
+	}
+	for _, machineManager := range machineManagers {
+		if machineManager.APIGroup != ClusterAPIGroup {
+			return nil, fmt.Errorf("invalid apiGroup %q in %s annotation, must be %q", machineManager.APIGroup, ClusterAPIManagedBootImagesAnnotationKey, ClusterAPIGroup)
+		}
+		if machineManager.Resource != ClusterAPIMachineSets && machineManager.Resource != ClusterAPIMachineDeployments {
+			return nil, fmt.Errorf("invalid resource %q in %s annotation, must be one of %q or %q", machineManager.Resource, ClusterAPIManagedBootImagesAnnotationKey, ClusterAPIMachineSets, ClusterAPIMachineDeployments)
+		}
+		if machineManager.Selection.Mode == opv1.Partial && machineManager.Selection.Partial == nil {
+			return nil, fmt.Errorf("partial selection mode for %s requires a partial selector in %s annotation", machineManager.Resource, ClusterAPIManagedBootImagesAnnotationKey)
+		}
+	}
+	return machineManagers, nil
+}
+
+// syncCAPIMachineResources will attempt to reconcile every enrolled CAPI MachineSet or MachineDeployment,
+// depending on the provided resource.
+func (ctrl *Controller) syncCAPIMachineResources(reason string, gvr schema.GroupVersionResource) {
+
+	kind, lister, stats := ctrl.getCAPIMachineResourceHandles(gvr)
+
+	// Get MachineConfiguration to determine which resources are enrolled
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		klog.Errorf("Failed to get MachineConfiguration: %v", err)
+		ctrl.updateConditions(reason, fmt.Errorf("failed to get MachineConfiguration while enqueueing %s: %w", kind, err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+
+	machineManagers, err := getCAPIMachineManagers(mcop)
+	if err != nil {
+		klog.Errorf("failed to get CAPI machine managers while enqueueing %s: %v", kind, err)
+		ctrl.updateConditions(reason, fmt.Errorf("failed to get CAPI machine managers while enqueueing %s: %w", kind, err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+
+	machineManagerFound, machineResourceSelector, err := getMachineResourceSelectorFromMachineManagers(machineManagers, ClusterAPIGroup, opv1.MachineManagerMachineSetsResourceType(gvr.Resource))
+	if err != nil {
+		klog.Errorf("failed to create a selector while enqueueing %s: %v", kind, err)
+		ctrl.updateConditions(reason, fmt.Errorf("failed to create a selector while enqueueing %s: %w", kind, err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+	if !machineManagerFound {
+		klog.V(4).Infof("No %s manager was found, so no %s will be enrolled.", kind, kind)
+	}
+
+	resources, err := lister.ByNamespace(ClusterAPINamespace).List(machineResourceSelector)
+	if err != nil {
+		klog.Errorf("failed to fetch %s list while enqueueing %v", kind, err)
+		ctrl.updateConditions(reason, fmt.Errorf("failed to fetch %s list while enqueueing %w", kind, err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+
+	// If no machine resources were enrolled, clear out the boot image history of this resource
+	if len(resources) == 0 {
+		klog.Infof("No %s were enrolled, so no %s will be enqueued.", kind, kind)
+		for k := range ctrl.capiBootImageState {
+			if strings.HasPrefix(k, gvr.Resource+"/") {
+				delete(ctrl.capiBootImageState, k)
+			}
+		}
+	}
+
+	// Reset stats before initiating reconciliation loop
+	stats.inProgress = 0
+	stats.totalCount = len(resources)
+	stats.skippedCount = 0
+	stats.erroredCount = 0
+
+	// Signal start of reconciliation process, by setting progressing to true
+	var syncErrors []error
+	ctrl.updateConditions(reason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+
+	for _, obj := range resources {
+		resource, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		patchSkipped, err := ctrl.syncCAPIMachineResource(gvr, resource)
+		if err == nil {
+			stats.inProgress++
+		} else {
+			klog.Errorf("Error syncing %s %v", kind, err)
+			syncErrors = append(syncErrors, fmt.Errorf("error syncing %s %s: %w", kind, resource.GetName(), err))
+			stats.erroredCount++
+		}
+		if patchSkipped {
+			stats.skippedCount++
+		}
+		// Update progressing conditions every step of the loop
+		ctrl.updateConditions(reason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+	}
+	// Update/Clear degrade conditions based on errors from this loop
+	ctrl.updateConditions(reason, kubeErrs.NewAggregate(syncErrors), opv1.MachineConfigurationBootImageUpdateDegraded)
+}
+
+// getCAPIMachineResourceHandles returns the display name, lister and stats for a CAPI machine resource
+func (ctrl *Controller) getCAPIMachineResourceHandles(gvr schema.GroupVersionResource) (string, cache.GenericLister, *MachineResourceStats) {
+	if gvr == capiMachineDeploymentGVR {
+		return "CAPI MachineDeployments", ctrl.capiMachineDeploymentLister, &ctrl.capiMachineDeploymentStats
+	}
+	return "CAPI MachineSets", ctrl.capiMachineSetLister, &ctrl.capiMachineSetStats
+}
+
+// syncCAPIMachineResource will attempt to reconcile the provided CAPI MachineSet or MachineDeployment
+func (ctrl *Controller) syncCAPIMachineResource(gvr schema.GroupVersionResource, resource *unstructured.Unstructured) (bool, error) {
+
+	kind := resource.GetKind()
+	startTime := time.Now()
+	klog.V(4).Infof("Started syncing CAPI %s %q (%v)", kind, resource.GetName(), startTime)
+	defer func() {
+		klog.V(4).Infof("Finished syncing CAPI %s %q (%v)", kind, resource.GetName(), time.Since(startTime))
+	}()
+
+	// If the resource has an owner reference, exit and log error. This means that the resource
+	// may be managed by another workflow, such as a MachineDeployment, and should not be reconciled.
+	if len(resource.GetOwnerReferences()) != 0 {
+		klog.Infof("%s %s has OwnerReference: %v, skipping boot image update", kind, resource.GetName(), resource.GetOwnerReferences()[0].Kind+"/"+resource.GetOwnerReferences()[0].Name)
+		return true, nil
+	}
+
+	// Skip if the resource has a label designating a non default stream. Not counted as skipped
+	// since the MCO intentionally excludes non-default streams.
+	if streamLabel, ok := resource.GetLabels()[OSStreamLabelKey]; ok {
+		if streamLabel != SupportedOSStream {
+			klog.Infof("%s %s has unsupported stream: %v, skipping boot image update", kind, resource.GetName(), streamLabel)
+			return false, nil
+		}
+	}
+
+	// Fetch the ClusterVersion to determine if this is a multi-arch cluster
+	clusterVersion, err := ctrl.clusterVersionLister.Get("version")
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch clusterversion during %s sync: %w", kind, err)
+	}
+
+	// Fetch the architecture type of this resource
+	arch, err := getArchFromAnnotations(resource.GetName(), resource.GetAnnotations(), clusterVersion)
+	if err != nil {
+		// If no architecture annotation was found, skip this resource without erroring
+		// A later sync loop will pick it up once the annotation is added
+		if strings.Contains(err.Error(), "no architecture annotation found") {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to fetch arch during %s sync: %w", kind, err)
+	}
+
+	// Fetch the infra object to determine the platform type
+	infra, err := ctrl.infraLister.Get("cluster")
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch infra object during %s sync: %w", kind, err)
+	}
+
+	// Fetch the bootimage configmap & ensure it has been stamped by the operator. This is done by
+	// the operator when a master node successfully updates to a new image. This is
+	// to prevent machine resources from being updated before the operator itself has updated.
+	// If it hasn't been updated, exit and wait for a resync.
+	configMap, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch coreos-bootimages config map during %s sync: %w", kind, err)
+	}
+	versionHashFromCM, versionHashFound := configMap.Data[ctrlcommon.MCOVersionHashKey]
+	if !versionHashFound {
+		klog.Infof("failed to find mco version hash in %s configmap, sync will exit to wait for the MCO upgrade to complete", ctrlcommon.BootImagesConfigMapName)
+		return true, nil
+	}
+	if versionHashFromCM != operatorversion.Hash {
+		klog.Infof("mismatch between MCO hash version stored in configmap and current MCO version; sync will exit to wait for the MCO upgrade to complete")
+		return true, nil
+	}
+	releaseVersionFromCM, releaseVersionFound := configMap.Data[ctrlcommon.OCPReleaseVersionKey]
+	if !releaseVersionFound {
+		klog.Infof("failed to find OCP release version in %s configmap, sync will exit to wait for the MCO upgrade to complete", ctrlcommon.BootImagesConfigMapName)
+		return true, nil
+	}
+	if releaseVersionFromCM != operatorversion.ReleaseVersion {
+		klog.Infof("mismatch between OCP release version stored in configmap and current MCO release version; sync will exit to wait for the MCO upgrade to complete")
+		return true, nil
+	}
+
+	// Fetch the infrastructure machine template referenced by this resource
+	templateGVR, template, err := ctrl.getCAPIMachineTemplate(resource)
+	if err != nil {
+		return false, err
+	}
+	if template == nil {
+		return true, nil
+	}
+
+	// Check if the this template requires an update
+	patchRequired, patchSkipped, newTemplate, err := checkCAPIMachineTemplate(infra, resource, template, configMap, arch, ctrl.kubeClient)
+	if err != nil {
+		return false, fmt.Errorf("failed to reconcile %s %s, err: %w", kind, resource.GetName(), err)
+	}
+
+	// Clone the template and repoint the resource at the clone if required
+	if patchRequired {
+		stateKey := gvr.Resource + "/" + resource.GetName()
+		if ctrl.checkCAPIMachineResourceHotLoop(stateKey, newTemplate) {
+			return false, fmt.Errorf("refusing to reconcile %s %s, hot loop detected. Please opt-out of boot image updates, adjust your machine provisioning workflow to prevent hot loops and opt back in to resume boot image updates", kind, resource.GetName())
+		}
+		klog.Infof("Cloning %s %s for %s %s", template.GetKind(), template.GetName(), kind, resource.GetName())
+		if err := ctrl.createCAPIMachineTemplate(templateGVR, newTemplate); err != nil {
+			return false, err
+		}
+		klog.Infof("Patching %s %s to reference %s %s", kind, resource.GetName(), newTemplate.GetKind(), newTemplate.GetName())
+		if err := ctrl.patchCAPIMachineResource(gvr, resource, newTemplate.GetName()); err != nil {
+			return false, err
+		}
+		ctrl.recordCAPIMachineResourceState(stateKey, newTemplate)
+		return false, nil
+	}
+	klog.Infof("No patching required for %s %s", kind, resource.GetName())
+	return patchSkipped, nil
+}
+
+// getCAPIMachineTemplate fetches the infrastructure machine template referenced by a CAPI MachineSet or
+// MachineDeployment. Returns a nil template if the template kind is not supported by this controller.
+func (ctrl *Controller) getCAPIMachineTemplate(resource *unstructured.Unstructured) (schema.GroupVersionResource, *unstructured.Unstructured, error) {
+	templateGVR, ref, err := getCAPIInfrastructureRef(resource)
+	if err != nil {
+		return schema.GroupVersionResource{}, nil, err
+	}
+	if ref == nil {
+		return schema.GroupVersionResource{}, nil, nil
+	}
+
+	template, err := ctrl.dynamicClient.Resource(templateGVR).Namespace(ref.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return schema.GroupVersionResource{}, nil, fmt.Errorf("failed to fetch %s %s referenced by %s %s: %w", ref.Kind, ref.Name, resource.GetKind(), resource.GetName(), err)
+	}
+	return templateGVR, template, nil
+}
+
+// getCAPIInfrastructureRef returns the resource and reference of the infrastructure machine template
+// referenced by a CAPI MachineSet or MachineDeployment, with the namespace defaulted to that of the
+// machine resource. Returns a nil reference if the template kind is not supported by this controller.
+func getCAPIInfrastructureRef(resource *unstructured.Unstructured) (schema.GroupVersionResource, *corev1.ObjectReference, error) {
+	infraRef, found, err := unstructured.NestedMap(resource.Object, "spec", "template", "spec", "infrastructureRef")
+	if err != nil {
+		return schema.GroupVersionResource{}, nil, fmt.Errorf("invalid infrastructureRef in %s %s: %w", resource.GetKind(), resource.GetName(), err)
+	}
+	if !found {
+		return schema.GroupVersionResource{}, nil, fmt.Errorf("%s %s has no infrastructureRef", resource.GetKind(), resource.GetName())
+	}
+	ref := &corev1.ObjectReference{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(infraRef, ref); err != nil {
+		return schema.GroupVersionResource{}, nil, fmt.Errorf("invalid infrastructureRef in %s %s: %w", resource.GetKind(), resource.GetName(), err)
+	}
+
+	supportedTemplate, ok := capiMachineTemplates[ref.Kind]
+	if !ok {
+		klog.Infof("%s %s references unsupported infrastructure template kind %s, skipping boot image update", resource.GetKind(), resource.GetName(), ref.Kind)
+		return schema.GroupVersionResource{}, nil, nil
+	}
+
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, nil, fmt.Errorf("invalid infrastructureRef apiVersion %q in %s %s: %w", ref.APIVersion, resource.GetKind(), resource.GetName(), err)
+	}
+
+	if ref.Namespace == "" {
+		ref.Namespace = resource.GetNamespace()
+	}
+	return gv.WithResource(supportedTemplate.resource), ref, nil
+}
+
+// This function calls the appropriate template reconcile function based on the template kind.
+// On success, it will return a bool indicating if a patch is required, and the clone of the
+// template carrying the new boot image, if any. Since infrastructure machine templates are
+// immutable, the clone is what the machine resource must be repointed at.
+func checkCAPIMachineTemplate(infra *osconfigv1.Infrastructure, resource, template *unstructured.Unstructured, configMap *corev1.ConfigMap, arch string, secretClient clientset.Interface) (patchRequired, patchSkipped bool, newTemplate *unstructured.Unstructured, err error) {
+	supportedTemplate, ok := capiMachineTemplates[template.GetKind()]
+	if !ok || supportedTemplate.platform != infra.Status.PlatformStatus.Type {
+		klog.Infof("Skipping %s %s, unsupported template kind %s on platform %s", resource.GetKind(), resource.GetName(), template.GetKind(), infra.Status.PlatformStatus.Type)
+		return false, false, nil, nil
+	}
+	klog.Infof("Reconciling CAPI %s %s on %s, with arch %s", resource.GetKind(), resource.GetName(), string(infra.Status.PlatformStatus.Type), arch)
+
+	templateSpec, found, err := unstructured.NestedMap(template.Object, "spec", "template", "spec")
+	if err != nil {
+		return false, false, nil, fmt.Errorf("invalid spec.template.spec in %s %s: %w", template.GetKind(), template.GetName(), err)
+	}
+	if !found {
+		return false, false, nil, fmt.Errorf("%s %s has no spec.template.spec", template.GetKind(), template.GetName())
+	}
+
+	// Unmarshal the configmap into a stream object
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, streamData); err != nil {
+		return false, false, nil, err
+	}
+
+	// Reconcile the template spec
+	patchRequired, patchSkipped, err = supportedTemplate.reconcile(streamData, arch, infra, templateSpec, template.GetName())
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	// If no patch is required, exit early
+	if !patchRequired {
+		return false, patchSkipped, nil, nil
+	}
+
+	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
+	if dataSecretName, found, _ := unstructured.NestedString(resource.Object, "spec", "template", "spec", "bootstrap", "dataSecretName"); found && dataSecretName != "" {
+		if err := upgradeStubIgnitionInNamespaceIfRequired(resource.GetNamespace(), dataSecretName, secretClient); err != nil {
+			return false, false, nil, err
+		}
+	}
+
+	newTemplate, err = newCAPIMachineTemplateClone(template, templateSpec)
+	if err != nil {
+		return false, false, nil, err
+	}
+	return true, false, newTemplate, nil
+}
+
+// newCAPIMachineTemplateClone returns a clone of the template with the provided template spec. The
+// clone is named after the original template with a suffix derived from the new spec, so that
+// reconciling the same boot image twice yields the same clone.
+func newCAPIMachineTemplateClone(template *unstructured.Unstructured, templateSpec map[string]any) (*unstructured.Unstructured, error) {
+	specBytes, err := json.Marshal(templateSpec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal spec of %s %s: %w", template.GetKind(), template.GetName(), err)
+	}
+	suffix := fmt.Sprintf("%x", sha256.Sum256(specBytes))[:8]
+
+	sourceName := template.GetName()
+	if name, ok := template.GetAnnotations()[CAPISourceTemplateAnnotationKey]; ok && name != "" {
+		sourceName = name
+	}
+	if maxLen := validation.DNS1123SubdomainMaxLength - len(suffix) - 1; len(sourceName) > maxLen {
+		sourceName = strings.TrimRight(sourceName[:maxLen], "-.")
+	}
+
+	newTemplate := &unstructured.Unstructured{}
+	newTemplate.SetAPIVersion(template.GetAPIVersion())
+	newTemplate.SetKind(template.GetKind())
+	newTemplate.SetName(sourceName + "-" + suffix)
+	newTemplate.SetNamespace(template.GetNamespace())
+	newTemplate.SetLabels(template.GetLabels())
+	newTemplate.SetOwnerReferences(template.GetOwnerReferences())
+
+	annotations := template.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[CAPISourceTemplateAnnotationKey] = sourceName
+	newTemplate.SetAnnotations(annotations)
+
+	spec, _, err := unstructured.NestedMap(template.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedMap(spec, templateSpec, "template", "spec"); err != nil {
+		return nil, err
+	}
+	if err := unstructured.SetNestedMap(newTemplate.Object, spec, "spec"); err != nil {
+		return nil, err
+	}
+	return newTemplate, nil
+}
+
+// reconcileAWSMachineTemplateSpec reconciles the AMI of an AWSMachineTemplate spec in place.
+// Returns whether a patch is required, whether it was skipped and any error
+func reconcileAWSMachineTemplateSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, templateSpec map[string]any, templateName string) (bool, bool, error) {
+
+	// AWSMachineTemplates do not carry a region, use the cluster's region instead
+	if infra.Status.PlatformStatus.AWS == nil {
+		klog.Infof("AWS platform status is undefined, skipping update of AWSMachineTemplate %s", templateName)
+		return false, true, nil
+	}
+	region := infra.Status.PlatformStatus.AWS.Region
+
+	awsRegionImage, err := streamData.GetAwsRegionImage(arch, region)
+	if err != nil {
+		// On a region not found error, log and skip this template
+		klog.Infof("failed to get AMI for region %s: %v, skipping update of AWSMachineTemplate %s", region, err, templateName)
+		return false, true, nil
+	}
+	newAMI := awsRegionImage.Image
+
+	// If the template does not use an AMI ID, e.g. it uses an AMI lookup, this is unsupported
+	currentAMI, _, _ := unstructured.NestedString(templateSpec, "ami", "id")
+	if currentAMI == "" {
+		klog.Infof("current AMI.ID is undefined, skipping update of AWSMachineTemplate %s", templateName)
+		return false, true, nil
+	}
+
+	// If the current AMI matches target AMI, nothing to do here
+	if newAMI == currentAMI {
+		return false, false, nil
+	}
+
+	// Validate that we're allowed to update from the current AMI
+	if !AllowedAMIs.Has(currentAMI) {
+		klog.Infof("current AMI %s is unknown, skipping update of AWSMachineTemplate %s", currentAMI, templateName)
+		return false, true, nil
+	}
+
+	klog.Infof("Current image: %s: %s", region, currentAMI)
+	klog.Infof("New target boot image: %s: %s", region, newAMI)
+
+	if err := unstructured.SetNestedStringMap(templateSpec, map[string]string{"id": newAMI}, "ami"); err != nil {
+		return false, false, err
+	}
+	return true, false, nil
+}
+
+// reconcileGCPMachineTemplateSpec reconciles the boot image of a GCPMachineTemplate spec in place.
+// Returns whether a patch is required, whether it was skipped and any error
+func reconcileGCPMachineTemplateSpec(streamData *stream.Stream, arch string, _ *osconfigv1.Infrastructure, templateSpec map[string]any, templateName string) (bool, bool, error) {
+
+	// Construct the new target bootimage from the configmap, same as for MAPI machinesets
+	newBootImage := fmt.Sprintf("projects/%s/global/images/%s", streamData.Architectures[arch].Images.Gcp.Project, streamData.Architectures[arch].Images.Gcp.Name)
+
+	// If the template does not specify an image, CAPG looks one up on its own
+	currentImage, _, _ := unstructured.NestedString(templateSpec, "image")
+	if currentImage == "" {
+		klog.Infof("current image is undefined, skipping update of GCPMachineTemplate %s", templateName)
+		return false, true, nil
+	}
+
+	// Nothing to update on a match
+	if newBootImage == currentImage {
+		return false, false, nil
+	}
+
+	klog.Infof("New target boot image: %s", newBootImage)
+	klog.Infof("Current image: %s", currentImage)
+	// If image does not start with "projects/rhcos-cloud/global/images", this is a custom boot image.
+	if !strings.HasPrefix(currentImage, "projects/rhcos-cloud/global/images") {
+		klog.Infof("current boot image %s is unknown, skipping update of GCPMachineTemplate %s", currentImage, templateName)
+		return false, true, nil
+	}
+
+	if err := unstructured.SetNestedField(templateSpec, newBootImage, "image"); err != nil {
+		return false, false, err
+	}
+	return true, false, nil
+}
+
+// createCAPIMachineTemplate creates the cloned infrastructure machine template. An existing clone
+// of the same name carries the same spec, so it is reused.
+func (ctrl *Controller) createCAPIMachineTemplate(gvr schema.GroupVersionResource, template *unstructured.Unstructured) error {
+	_, err := ctrl.dynamicClient.Resource(gvr).Namespace(template.GetNamespace()).Create(context.TODO(), template, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		klog.Infof("%s %s already exists, reusing it", template.GetKind(), template.GetName())
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create %s %s: %w", template.GetKind(), template.GetName(), err)
+	}
+	klog.Infof("Successfully created %s %s", template.GetKind(), template.GetName())
+	return nil
+}
+
+// patchCAPIMachineResource repoints the infrastructureRef of a CAPI MachineSet or MachineDeployment
+// at the named template. For MachineDeployments, this rolls out new Machines.
+func (ctrl *Controller) patchCAPIMachineResource(gvr schema.GroupVersionResource, resource *unstructured.Unstructured, templateName string) error {
+	newResource := resource.DeepCopy()
+	if err := unstructured.SetNestedField(newResource.Object, templateName, "spec", "template", "spec", "infrastructureRef", "name"); err != nil {
+		return err
+	}
+	resourceMarshal, err := json.Marshal(resource)
+	if err != nil {
+		return fmt.Errorf("unable to marshal old %s: %w", resource.GetKind(), err)
+	}
+	newResourceMarshal, err := json.Marshal(newResource)
+	if err != nil {
+		return fmt.Errorf("unable to marshal new %s: %w", resource.GetKind(), err)
+	}
+	patchBytes, err := jsonmergepatch.CreateThreeWayJSONMergePatch(resourceMarshal, newResourceMarshal, resourceMarshal)
+	if err != nil {
+		return fmt.Errorf("unable to create patch for new %s: %w", resource.GetKind(), err)
+	}
+	_, err = ctrl.dynamicClient.Resource(gvr).Namespace(resource.GetNamespace()).Patch(context.TODO(), resource.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to patch %s %s: %w", resource.GetKind(), resource.GetName(), err)
+	}
+	klog.Infof("Successfully patched %s %s", resource.GetKind(), resource.GetName())
+	return nil
+}
+
+// checkCAPIMachineResourceHotLoop returns true if repointing this resource at the template
+// would exceed the hot loop limit. Does not modify the store.
+func (ctrl *Controller) checkCAPIMachineResourceHotLoop(key string, template *unstructured.Unstructured) bool {
+	bis, ok := ctrl.capiBootImageState[key]
+	return ok && bytes.Equal(bis.value, []byte(template.GetName())) && bis.hotLoopCount >= HotLoopLimit
+}
+
+// recordCAPIMachineResourceState updates the local boot image store after a successful patch.
+func (ctrl *Controller) recordCAPIMachineResourceState(key string, template *unstructured.Unstructured) {
+	value := []byte(template.GetName())
+	hotLoopCount := 1
+	if bis, ok := ctrl.capiBootImageState[key]; ok && bytes.Equal(bis.value, value) {
+		hotLoopCount = bis.hotLoopCount + 1
+	}
+	ctrl.capiBootImageState[key] = BootImageState{
+		value:        value,
+		hotLoopCount: hotLoopCount,
+	}
+}
+
+// pruneCAPIMachineTemplateClones deletes the infrastructure machine templates cloned by this
+// controller which no CAPI MachineSet or MachineDeployment references any more. The machine
+// resources are listed from the API server rather than the listers, so that a clone which was
+// only just patched in is never mistaken for an unreferenced one.
+func (ctrl *Controller) pruneCAPIMachineTemplateClones() error {
+	referenced := map[schema.GroupVersionResource]sets.Set[string]{}
+	for _, gvr := range []schema.GroupVersionResource{capiMachineSetGVR, capiMachineDeploymentGVR} {
+		resources, err := ctrl.dynamicClient.Resource(gvr).Namespace(ClusterAPINamespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to list %s: %w", gvr.Resource, err)
+		}
+		for i := range resources.Items {
+			templateGVR, ref, err := getCAPIInfrastructureRef(&resources.Items[i])
+			if err != nil {
+				return err
+			}
+			if ref == nil || ref.Namespace != ClusterAPINamespace {
+				continue
+			}
+			if _, ok := referenced[templateGVR]; !ok {
+				referenced[templateGVR] = sets.New[string]()
+			}
+			referenced[templateGVR].Insert(ref.Name)
+		}
+	}
+
+	var errs []error
+	for templateGVR, names := range referenced {
+		templates, err := ctrl.dynamicClient.Resource(templateGVR).Namespace(ClusterAPINamespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to list %s: %w", templateGVR.Resource, err))
+			continue
+		}
+		for _, template := range templates.Items {
+			if _, ok := template.GetAnnotations()[CAPISourceTemplateAnnotationKey]; !ok || names.Has(template.GetName()) {
+				continue
+			}
+			err := ctrl.dynamicClient.Resource(templateGVR).Namespace(ClusterAPINamespace).Delete(context.TODO(), template.GetName(), metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("unable to delete %s %s: %w", template.GetKind(), template.GetName(), err))
+				continue
+			}
+			klog.Infof("Deleted %s %s, which is no longer referenced by any CAPI machine resource", template.GetKind(), template.GetName())
+		}
+	}
+	return kubeErrs.NewAggregate(errs)
+}
+
+// capiCRDsInstalled returns true once the API server serves the CAPI MachineSet and
+// MachineDeployment resources. Their informers would never sync without them.
+func (ctrl *Controller) capiCRDsInstalled() (bool, error) {
+	resources, err := ctrl.kubeClient.Discovery().ServerResourcesForGroupVersion(capiMachineSetGVR.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	served := sets.New[string]()
+	for _, resource := range resources.APIResources {
+		served.Insert(resource.Name)
+	}
+	return served.HasAll(capiMachineSetGVR.Resource, capiMachineDeploymentGVR.Resource), nil
+}
+
+// runCAPIInformers waits for the CAPI CRDs to be installed, then starts the CAPI informers and
+// triggers a reconciliation of the CAPI machine resources once they have synced.
+func (ctrl *Controller) runCAPIInformers(stopCh <-chan struct{}) {
+	ctx := wait.ContextForChannel(stopCh)
+	err := wait.PollUntilContextCancel(ctx, capiCRDPollInterval, true, func(_ context.Context) (bool, error) {
+		installed, err := ctrl.capiCRDsInstalled()
+		if err != nil {
+			klog.Warningf("Unable to check for the CAPI CRDs: %v", err)
+			return false, nil
+		}
+		if !installed {
+			klog.V(4).Infof("CAPI CRDs are not installed, CAPI machine resources will not be reconciled yet")
+		}
+		return installed, nil
+	})
+	if err != nil {
+		return
+	}
+
+	ctrl.capiInformerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, ctrl.capiMachineSetListerSynced, ctrl.capiMachineDeploymentListerSynced) {
+		return
+	}
+	klog.Infof("CAPI informers synced, reconciling enrolled CAPI machine resources")
+	ctrl.enqueueEvent("CAPIInformersSynced")
+}
+
+// capiInformersSynced returns true if the CAPI machine resources are watched and their informers
+// have synced.
+func (ctrl *Controller) capiInformersSynced() bool {
+	return ctrl.capiInformerFactory != nil && ctrl.capiMachineSetListerSynced() && ctrl.capiMachineDeploymentListerSynced()
+}
@@ -0,0 +1,425 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	archtranslater "github.com/coreos/stream-metadata-go/arch"
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configlistersv1 "github.com/openshift/client-go/config/listers/config/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	operatorversion "github.com/openshift/machine-config-operator/pkg/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var awsMachineTemplateGVR = schema.GroupVersionResource{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta2", Resource: "awsmachinetemplates"}
+
+func TestGetCAPIMachineManagers(t *testing.T) {
+	cases := []struct {
+		name          string
+		annotation    *string
+		expectedCount int
+		expectedErr   string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:          "all machinedeployments and partial machinesets",
+			annotation:    strPtr(`[{"resource":"machinedeployments","apiGroup":"cluster.x-k8s.io","selection":{"mode":"All"}},{"resource":"machinesets","apiGroup":"cluster.x-k8s.io","selection":{"mode":"Partial","partial":{"machineResourceSelector":{"matchLabels":{"a":"b"}}}}}]`),
+			expectedCount: 2,
+		},
+		{
+			name:        "invalid json",
+			annotation:  strPtr(`{`),
+			expectedErr: "could not parse",
+		},
+		{
+			name:        "MAPI api group",
+			annotation:  strPtr(`[{"resource":"machinesets","apiGroup":"machine.openshift.io","selection":{"mode":"All"}}]`),
+			expectedErr: "invalid apiGroup",
+		},
+		{
+			name:        "unsupported resource",
+			annotation:  strPtr(`[{"resource":"machines","apiGroup":"cluster.x-k8s.io","selection":{"mode":"All"}}]`),
+			expectedErr: "invalid resource",
+		},
+		{
+			name:        "partial mode without selector",
+			annotation:  strPtr(`[{"resource":"machinesets","apiGroup":"cluster.x-k8s.io","selection":{"mode":"Partial"}}]`),
+			expectedErr: "requires a partial selector",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mcop := &opv1.MachineConfiguration{}
+			if tc.annotation != nil {
+				v1.SetMetaDataAnnotation(&mcop.ObjectMeta, ClusterAPIManagedBootImagesAnnotationKey, *tc.annotation)
+			}
+			machineManagers, err := getCAPIMachineManagers(mcop)
+			if tc.expectedErr != "" {
+				assert.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, machineManagers, tc.expectedCount)
+		})
+	}
+}
+
+func TestReconcileAWSMachineTemplateSpec(t *testing.T) {
+	arch := archtranslater.CurrentRpmArch()
+	streamData := getCAPIStreamData(arch)
+	infra := getCAPIInfra()
+	knownAMI := AllowedAMIs.UnsortedList()[0]
+
+	cases := []struct {
+		name             string
+		infra            *osconfigv1.Infrastructure
+		templateSpec     map[string]any
+		expectPatch      bool
+		expectSkip       bool
+		expectedAMIAfter string
+	}{
+		{
+			name:             "known AMI is updated",
+			infra:            infra,
+			templateSpec:     map[string]any{"ami": map[string]any{"id": knownAMI}, "instanceType": "m6i.xlarge"},
+			expectPatch:      true,
+			expectedAMIAfter: "ami-new",
+		},
+		{
+			name:             "matching AMI is left alone",
+			infra:            infra,
+			templateSpec:     map[string]any{"ami": map[string]any{"id": "ami-new"}},
+			expectedAMIAfter: "ami-new",
+		},
+		{
+			name:             "unknown AMI is skipped",
+			infra:            infra,
+			templateSpec:     map[string]any{"ami": map[string]any{"id": "ami-custom"}},
+			expectSkip:       true,
+			expectedAMIAfter: "ami-custom",
+		},
+		{
+			name:         "AMI lookup is skipped",
+			infra:        infra,
+			templateSpec: map[string]any{"ami": map[string]any{"eksLookupType": "AmazonLinux"}},
+			expectSkip:   true,
+		},
+		{
+			name: "region without an AMI is skipped",
+			infra: &osconfigv1.Infrastructure{Status: osconfigv1.InfrastructureStatus{PlatformStatus: &osconfigv1.PlatformStatus{
+				Type: osconfigv1.AWSPlatformType,
+				AWS:  &osconfigv1.AWSPlatformStatus{Region: "mars-north-1"},
+			}}},
+			templateSpec:     map[string]any{"ami": map[string]any{"id": knownAMI}},
+			expectSkip:       true,
+			expectedAMIAfter: knownAMI,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			patchRequired, patchSkipped, err := reconcileAWSMachineTemplateSpec(streamData, arch, tc.infra, tc.templateSpec, "template")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectPatch, patchRequired)
+			assert.Equal(t, tc.expectSkip, patchSkipped)
+			ami, _, _ := unstructured.NestedString(tc.templateSpec, "ami", "id")
+			assert.Equal(t, tc.expectedAMIAfter, ami)
+		})
+	}
+}
+
+func TestSyncCAPIMachineResource(t *testing.T) {
+	arch := archtranslater.CurrentRpmArch()
+	knownAMI := AllowedAMIs.UnsortedList()[0]
+
+	template := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+		"kind":       "AWSMachineTemplate",
+		"metadata":   map[string]any{"name": "worker-us-east-1a", "namespace": ClusterAPINamespace},
+		"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"ami":          map[string]any{"id": knownAMI},
+			"instanceType": "m6i.xlarge",
+		}}},
+	}}
+	machineDeployment := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "MachineDeployment",
+		"metadata":   map[string]any{"name": "worker-us-east-1a", "namespace": ClusterAPINamespace},
+		"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"bootstrap": map[string]any{"dataSecretName": "worker-user-data"},
+			"infrastructureRef": map[string]any{
+				"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+				"kind":       "AWSMachineTemplate",
+				"name":       "worker-us-east-1a",
+			},
+		}}},
+	}}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		capiMachineDeploymentGVR: "MachineDeploymentList",
+		capiMachineSetGVR:        "MachineSetList",
+		awsMachineTemplateGVR:    "AWSMachineTemplateList",
+	}, template, machineDeployment)
+
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "worker-user-data", Namespace: ClusterAPINamespace},
+		Data:       map[string][]byte{ctrlcommon.UserDataKey: []byte(`{"ignition":{"version":"3.2.0"}}`)},
+	})
+
+	ctrl := &Controller{
+		kubeClient:           kubeClient,
+		dynamicClient:        dynamicClient,
+		clusterVersionLister: newCAPIClusterVersionLister(t),
+		infraLister:          newCAPIInfraLister(t),
+		mcoCmLister:          newCAPIConfigMapLister(t, arch),
+		capiBootImageState:   map[string]BootImageState{},
+	}
+
+	// The first sync clones the template and repoints the MachineDeployment at it
+	patchSkipped, err := ctrl.syncCAPIMachineResource(capiMachineDeploymentGVR, machineDeployment)
+	require.NoError(t, err)
+	assert.False(t, patchSkipped)
+
+	updated, err := dynamicClient.Resource(capiMachineDeploymentGVR).Namespace(ClusterAPINamespace).Get(context.TODO(), "worker-us-east-1a", v1.GetOptions{})
+	require.NoError(t, err)
+	cloneName, _, _ := unstructured.NestedString(updated.Object, "spec", "template", "spec", "infrastructureRef", "name")
+	assert.NotEqual(t, "worker-us-east-1a", cloneName)
+	assert.Regexp(t, `^worker-us-east-1a-[0-9a-f]{8}$`, cloneName)
+
+	clone, err := dynamicClient.Resource(awsMachineTemplateGVR).Namespace(ClusterAPINamespace).Get(context.TODO(), cloneName, v1.GetOptions{})
+	require.NoError(t, err)
+	ami, _, _ := unstructured.NestedString(clone.Object, "spec", "template", "spec", "ami", "id")
+	assert.Equal(t, "ami-new", ami)
+	instanceType, _, _ := unstructured.NestedString(clone.Object, "spec", "template", "spec", "instanceType")
+	assert.Equal(t, "m6i.xlarge", instanceType)
+	assert.Equal(t, "worker-us-east-1a", clone.GetAnnotations()[CAPISourceTemplateAnnotationKey])
+
+	// The original template is immutable and left in place for existing Machines
+	original, err := dynamicClient.Resource(awsMachineTemplateGVR).Namespace(ClusterAPINamespace).Get(context.TODO(), "worker-us-east-1a", v1.GetOptions{})
+	require.NoError(t, err)
+	ami, _, _ = unstructured.NestedString(original.Object, "spec", "template", "spec", "ami", "id")
+	assert.Equal(t, knownAMI, ami)
+
+	// Once repointed, there is nothing left to do
+	patchSkipped, err = ctrl.syncCAPIMachineResource(capiMachineDeploymentGVR, updated)
+	require.NoError(t, err)
+	assert.False(t, patchSkipped)
+	afterResync, err := dynamicClient.Resource(capiMachineDeploymentGVR).Namespace(ClusterAPINamespace).Get(context.TODO(), "worker-us-east-1a", v1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, updated.GetResourceVersion(), afterResync.GetResourceVersion())
+
+	// Something repeatedly reverting the MachineDeployment is detected as a hot loop, and the
+	// existing clone is reused every time until then.
+	for range HotLoopLimit - 1 {
+		_, err = ctrl.syncCAPIMachineResource(capiMachineDeploymentGVR, machineDeployment)
+		require.NoError(t, err)
+	}
+	_, err = ctrl.syncCAPIMachineResource(capiMachineDeploymentGVR, machineDeployment)
+	assert.ErrorContains(t, err, "hot loop detected")
+
+	templates, err := dynamicClient.Resource(awsMachineTemplateGVR).Namespace(ClusterAPINamespace).List(context.TODO(), v1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, templates.Items, 2)
+
+	// MachineSets owned by a MachineDeployment are not reconciled directly
+	ownedMachineSet := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "MachineSet",
+		"metadata": map[string]any{
+			"name":            "worker-us-east-1a-abcde",
+			"namespace":       ClusterAPINamespace,
+			"ownerReferences": []any{map[string]any{"apiVersion": "cluster.x-k8s.io/v1beta1", "kind": "MachineDeployment", "name": "worker-us-east-1a", "uid": "1234"}},
+		},
+	}}
+	patchSkipped, err = ctrl.syncCAPIMachineResource(capiMachineSetGVR, ownedMachineSet)
+	require.NoError(t, err)
+	assert.True(t, patchSkipped)
+}
+
+func TestPruneCAPIMachineTemplateClones(t *testing.T) {
+	newTemplate := func(name, source string) *unstructured.Unstructured {
+		template := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+			"kind":       "AWSMachineTemplate",
+			"metadata":   map[string]any{"name": name, "namespace": ClusterAPINamespace},
+		}}
+		if source != "" {
+			template.SetAnnotations(map[string]string{CAPISourceTemplateAnnotationKey: source})
+		}
+		return template
+	}
+	newMachineResource := func(kind, name, templateName string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       kind,
+			"metadata":   map[string]any{"name": name, "namespace": ClusterAPINamespace},
+			"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+				"infrastructureRef": map[string]any{
+					"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+					"kind":       "AWSMachineTemplate",
+					"name":       templateName,
+				},
+			}}},
+		}}
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		capiMachineDeploymentGVR: "MachineDeploymentList",
+		capiMachineSetGVR:        "MachineSetList",
+		awsMachineTemplateGVR:    "AWSMachineTemplateList",
+	},
+		newTemplate("worker", ""),
+		newTemplate("unused", ""),
+		newTemplate("worker-00000001", "worker"),
+		newTemplate("worker-00000002", "worker"),
+		newTemplate("worker-00000003", "worker"),
+		// The MachineDeployment was repointed at the latest clone, while an old MachineSet
+		// it owns still references the previous one.
+		newMachineResource("MachineDeployment", "worker", "worker-00000003"),
+		newMachineResource("MachineSet", "worker-abcde", "worker-00000002"),
+		newMachineResource("MachineSet", "other", "worker"),
+	)
+
+	ctrl := &Controller{dynamicClient: dynamicClient}
+	require.NoError(t, ctrl.pruneCAPIMachineTemplateClones())
+
+	templates, err := dynamicClient.Resource(awsMachineTemplateGVR).Namespace(ClusterAPINamespace).List(context.TODO(), v1.ListOptions{})
+	require.NoError(t, err)
+	names := []string{}
+	for _, template := range templates.Items {
+		names = append(names, template.GetName())
+	}
+	// Only unreferenced clones are deleted, templates not created by the MCO are left alone
+	assert.ElementsMatch(t, []string{"worker", "unused", "worker-00000002", "worker-00000003"}, names)
+}
+
+func TestCAPICRDsInstalled(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	fakeDiscovery := kubeClient.Discovery().(*fakediscovery.FakeDiscovery)
+	ctrl := &Controller{kubeClient: kubeClient}
+
+	installed, err := ctrl.capiCRDsInstalled()
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	fakeDiscovery.Resources = []*v1.APIResourceList{{
+		GroupVersion: capiMachineSetGVR.GroupVersion().String(),
+		APIResources: []v1.APIResource{{Name: "machinesets"}},
+	}}
+	installed, err = ctrl.capiCRDsInstalled()
+	require.NoError(t, err)
+	assert.False(t, installed)
+
+	fakeDiscovery.Resources[0].APIResources = append(fakeDiscovery.Resources[0].APIResources, v1.APIResource{Name: "machinedeployments"})
+	installed, err = ctrl.capiCRDsInstalled()
+	require.NoError(t, err)
+	assert.True(t, installed)
+}
+
+func TestNewCAPIMachineTemplateClone(t *testing.T) {
+	template := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta2",
+		"kind":       "AWSMachineTemplate",
+		"metadata": map[string]any{
+			"name":            "worker-us-east-1a-0123abcd",
+			"namespace":       ClusterAPINamespace,
+			"resourceVersion": "42",
+			"uid":             "5678",
+			"annotations":     map[string]any{CAPISourceTemplateAnnotationKey: "worker-us-east-1a"},
+		},
+		"spec": map[string]any{"template": map[string]any{"spec": map[string]any{"ami": map[string]any{"id": "ami-old"}}}},
+	}}
+
+	clone, err := newCAPIMachineTemplateClone(template, map[string]any{"ami": map[string]any{"id": "ami-new"}})
+	require.NoError(t, err)
+
+	// Clones of clones are named after the original source template
+	assert.Regexp(t, `^worker-us-east-1a-[0-9a-f]{8}$`, clone.GetName())
+	assert.Empty(t, clone.GetResourceVersion())
+	assert.Empty(t, string(clone.GetUID()))
+	assert.Equal(t, "worker-us-east-1a", clone.GetAnnotations()[CAPISourceTemplateAnnotationKey])
+
+	// The same spec results in the same clone
+	again, err := newCAPIMachineTemplateClone(template, map[string]any{"ami": map[string]any{"id": "ami-new"}})
+	require.NoError(t, err)
+	assert.Equal(t, clone.GetName(), again.GetName())
+
+	other, err := newCAPIMachineTemplateClone(template, map[string]any{"ami": map[string]any{"id": "ami-newer"}})
+	require.NoError(t, err)
+	assert.NotEqual(t, clone.GetName(), other.GetName())
+}
+
+func getCAPIStreamData(arch string) *stream.Stream {
+	return &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			arch: {
+				Images: stream.Images{
+					Aws: &stream.AwsImage{
+						Regions: map[string]stream.SingleImage{
+							"us-east-1": {Image: "ami-new"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getCAPIInfra() *osconfigv1.Infrastructure {
+	return &osconfigv1.Infrastructure{
+		ObjectMeta: v1.ObjectMeta{Name: "cluster"},
+		Status: osconfigv1.InfrastructureStatus{
+			PlatformStatus: &osconfigv1.PlatformStatus{
+				Type: osconfigv1.AWSPlatformType,
+				AWS:  &osconfigv1.AWSPlatformStatus{Region: "us-east-1"},
+			},
+		},
+	}
+}
+
+func newCAPIClusterVersionLister(t *testing.T) configlistersv1.ClusterVersionLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(&osconfigv1.ClusterVersion{ObjectMeta: v1.ObjectMeta{Name: "version"}}))
+	return configlistersv1.NewClusterVersionLister(indexer)
+}
+
+func newCAPIInfraLister(t *testing.T) configlistersv1.InfrastructureLister {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(getCAPIInfra()))
+	return configlistersv1.NewInfrastructureLister(indexer)
+}
+
+func newCAPIConfigMapLister(t *testing.T, arch string) corelisterv1.ConfigMapLister {
+	streamBytes, err := json.Marshal(getCAPIStreamData(arch))
+	require.NoError(t, err)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: ctrlcommon.BootImagesConfigMapName, Namespace: ctrlcommon.MCONamespace},
+		Data: map[string]string{
+			StreamConfigMapKey:              string(streamBytes),
+			ctrlcommon.MCOVersionHashKey:    operatorversion.Hash,
+			ctrlcommon.OCPReleaseVersionKey: operatorversion.ReleaseVersion,
+		},
+	}))
+	return corelisterv1.NewConfigMapLister(indexer)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,147 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Key of the GCP credentials secret referenced by the provider spec
+	gcpServiceAccountKey = "service_account.json"
+
+	// Credential type of a GCP service account key
+	gcpServiceAccountType = "service_account"
+
+	// Scope requested for the access token used to look up images
+	gcpComputeReadOnlyScope = "https://www.googleapis.com/auth/compute.readonly"
+
+	// Default endpoints used when the service account key does not name one
+	gcpDefaultTokenURI        = "https://oauth2.googleapis.com/token"
+	gcpDefaultComputeEndpoint = "https://compute.googleapis.com/compute/v1/"
+
+	// Compute image status of an image that can be used to create disks
+	gcpImageStatusReady = "READY"
+)
+
+// newGCPBootImageVerifier is a variable so that tests can substitute a fake Compute service
+var newGCPBootImageVerifier = newComputeImageVerifierFromSecret
+
+// verifyGCPBootImage checks that the image can be used by the cluster's GCP project, using the
+// credentials secret referenced by the provider spec.
+func verifyGCPBootImage(providerSpec *machinev1beta1.GCPMachineProviderSpec, image string, secretClient clientset.Interface) error {
+	ctx, cancel := context.WithTimeout(context.Background(), bootImageVerificationTimeout)
+	defer cancel()
+
+	verifier, err := newGCPBootImageVerifier(ctx, providerSpec, secretClient)
+	if err != nil {
+		return fmt.Errorf("failed to create Compute client to verify image %s: %w", image, err)
+	}
+	if verifier == nil {
+		return nil
+	}
+	if err := verifier.verifyImage(ctx, image); err != nil {
+		return fmt.Errorf("failed to verify image %s: %w", image, err)
+	}
+	klog.Infof("Verified image %s", image)
+	return nil
+}
+
+// gcpServiceAccount holds the fields of a GCP service account key that the MCO cares about
+type gcpServiceAccount struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// computeImageVerifier looks up images with the Compute Engine API
+type computeImageVerifier struct {
+	// httpClient adds the service account's access token to requests
+	httpClient      *http.Client
+	computeEndpoint string
+}
+
+// newComputeImageVerifierFromSecret returns a Compute client using the service account key in the
+// secret referenced by the provider spec. Returns a nil verifier if the secret does not hold a
+// service account key, e.g. on clusters using workload identity.
+func newComputeImageVerifierFromSecret(ctx context.Context, providerSpec *machinev1beta1.GCPMachineProviderSpec, secretClient clientset.Interface) (bootImageVerifier, error) {
+	if providerSpec.CredentialsSecret == nil {
+		klog.Warningf("credentialsSecret is not set in the GCP provider spec, images will not be verified")
+		return nil, nil
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(MachineAPINamespace).Get(ctx, providerSpec.CredentialsSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s/%s Secret during machineset sync: %w", MachineAPINamespace, providerSpec.CredentialsSecret.Name, err)
+	}
+
+	serviceAccount := gcpServiceAccount{}
+	if err := json.Unmarshal(secret.Data[gcpServiceAccountKey], &serviceAccount); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in Secret %s/%s: %w", gcpServiceAccountKey, MachineAPINamespace, secret.Name, err)
+	}
+	if serviceAccount.Type != gcpServiceAccountType {
+		klog.Warningf("Secret %s/%s holds %q credentials rather than a service account key, images will not be verified", MachineAPINamespace, secret.Name, serviceAccount.Type)
+		return nil, nil
+	}
+
+	httpClient, err := newHTTPClientWithCA(nil, bootImageVerificationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return newComputeImageVerifier(ctx, httpClient, serviceAccount, gcpDefaultComputeEndpoint), nil
+}
+
+// newComputeImageVerifier returns a Compute client which authenticates as the service account,
+// exchanging a JWT signed with its private key for an access token.
+// See https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+func newComputeImageVerifier(ctx context.Context, httpClient *http.Client, serviceAccount gcpServiceAccount, computeEndpoint string) *computeImageVerifier {
+	tokenURI := serviceAccount.TokenURI
+	if tokenURI == "" {
+		tokenURI = gcpDefaultTokenURI
+	}
+
+	config := &jwt.Config{
+		Email:        serviceAccount.ClientEmail,
+		PrivateKey:   []byte(serviceAccount.PrivateKey),
+		PrivateKeyID: serviceAccount.PrivateKeyID,
+		Scopes:       []string{gcpComputeReadOnlyScope},
+		TokenURL:     tokenURI,
+	}
+
+	return &computeImageVerifier{
+		httpClient:      config.Client(context.WithValue(ctx, oauth2.HTTPClient, httpClient)),
+		computeEndpoint: computeEndpoint,
+	}
+}
+
+// verifyImage returns an error unless the image, in the projects/<project>/global/images/<name>
+// form used by the provider spec, exists, is visible to the project and is ready.
+func (c *computeImageVerifier) verifyImage(ctx context.Context, image string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.computeEndpoint+strings.TrimPrefix(image, "/"), nil)
+	if err != nil {
+		return err
+	}
+
+	computeImage := struct {
+		Status string `json:"status"`
+	}{}
+	if _, err := doJSONRequest(c.httpClient, req, http.StatusOK, &computeImage); err != nil {
+		return err
+	}
+	if computeImage.Status != gcpImageStatusReady {
+		return fmt.Errorf("image %s is %s", image, computeImage.Status)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package bootimage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileGCPProviderSpecVerification(t *testing.T) {
+	newImage := "projects/rhcos-cloud/global/images/rhcos-new"
+
+	testSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"userData": []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[]},"systemd":{},"passwd":{}}`),
+		},
+	}
+
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Images: stream.Images{
+					Gcp: &stream.GcpImage{Project: "rhcos-cloud", Name: "rhcos-new"},
+				},
+			},
+		},
+	}
+
+	providerSpec := &machinev1beta1.GCPMachineProviderSpec{
+		Disks: []*machinev1beta1.GCPDisk{
+			{Boot: true, Image: "projects/rhcos-cloud/global/images/rhcos-old"},
+		},
+		UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+	}
+
+	verifier := &fakeBootImageVerifier{}
+	newGCPBootImageVerifier = func(_ context.Context, _ *machinev1beta1.GCPMachineProviderSpec, _ kubernetes.Interface) (bootImageVerifier, error) {
+		return verifier, nil
+	}
+	t.Cleanup(func() { newGCPBootImageVerifier = newComputeImageVerifierFromSecret })
+
+	patchRequired, _, updatedProviderSpec, err := reconcileGCPProviderSpec(streamData, "x86_64", &osconfigv1.Infrastructure{}, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+	require.NoError(t, err)
+	assert.True(t, patchRequired)
+	assert.Equal(t, newImage, updatedProviderSpec.Disks[0].Image)
+	assert.Equal(t, []string{newImage}, verifier.verified)
+
+	verifier.err = fmt.Errorf("image %s is PENDING", newImage)
+	_, _, updatedProviderSpec, err = reconcileGCPProviderSpec(streamData, "x86_64", &osconfigv1.Infrastructure{}, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+	assert.ErrorContains(t, err, "failed to verify image "+newImage)
+	assert.Nil(t, updatedProviderSpec)
+}
+
+// This tests the token exchange and image lookups made by computeImageVerifier
+func TestComputeImageVerifier(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	images := map[string]string{
+		"/compute/v1/projects/rhcos-cloud/global/images/rhcos-ready":   gcpImageStatusReady,
+		"/compute/v1/projects/rhcos-cloud/global/images/rhcos-pending": "PENDING",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+		// The assertion must be signed with the service account key
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		assert.NoError(t, verifyTestJWT(&privateKey.PublicKey, parts))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/compute/v1/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		status, ok := images[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"The resource was not found"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":%q}`, status)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	serviceAccount := gcpServiceAccount{
+		Type:        gcpServiceAccountType,
+		ClientEmail: "machine-api@test-project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+		TokenURI:    server.URL + "/token",
+	}
+
+	verifier := newComputeImageVerifier(context.Background(), server.Client(), serviceAccount, server.URL+"/compute/v1/")
+
+	assert.NoError(t, verifier.verifyImage(context.Background(), "projects/rhcos-cloud/global/images/rhcos-ready"))
+	assert.ErrorContains(t, verifier.verifyImage(context.Background(), "projects/rhcos-cloud/global/images/rhcos-pending"), "is PENDING")
+	assert.Error(t, verifier.verifyImage(context.Background(), "projects/rhcos-cloud/global/images/rhcos-missing"))
+}
+
+func TestNewComputeImageVerifierFromSecret(t *testing.T) {
+	secretClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "gcp-cloud-credentials",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"service_account.json": []byte(`{"type":"external_account","audience":"//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"}`),
+		},
+	})
+
+	providerSpec := &machinev1beta1.GCPMachineProviderSpec{
+		CredentialsSecret: &corev1.LocalObjectReference{Name: "gcp-cloud-credentials"},
+	}
+
+	// Workload identity credentials cannot be used by the controller, so verification is skipped
+	verifier, err := newComputeImageVerifierFromSecret(context.Background(), providerSpec, secretClient)
+	require.NoError(t, err)
+	assert.Nil(t, verifier)
+
+	providerSpec.CredentialsSecret.Name = "nonexistent"
+	_, err = newComputeImageVerifierFromSecret(context.Background(), providerSpec, secretClient)
+	assert.Error(t, err)
+}
+
+// verifyTestJWT checks the signature and claims of the JWT assertion for the service account
+func verifyTestJWT(publicKey *rsa.PublicKey, parts []string) error {
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return err
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	claims := map[string]any{}
+	if err := json.Unmarshal(rawClaims, &claims); err != nil {
+		return err
+	}
+	if claims["scope"] != gcpComputeReadOnlyScope {
+		return fmt.Errorf("unexpected scope %v", claims["scope"])
+	}
+	return nil
+}
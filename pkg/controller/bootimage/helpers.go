@@ -2,8 +2,12 @@ package bootimage
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -24,6 +28,53 @@ import (
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 )
 
+// How long to wait for the cloud to confirm that a target boot image is usable
+var bootImageVerificationTimeout = time.Minute
+
+// How long to wait for a boot image to be uploaded into or imported by the cloud
+const bootImageUploadTimeout = 2 * time.Hour
+
+// Path of the cluster's trusted CA bundle, which also holds the CA of the cluster-wide proxy.
+// It is injected into a ConfigMap mounted into the controller, and is a variable so that tests
+// can substitute their own.
+var clusterTrustedCABundlePath = "/etc/machine-config-controller/trusted-ca/ca-bundle.crt"
+
+// newHTTPClientWithCA returns an HTTP client for talking to the cloud with the given timeout. It
+// goes through the cluster-wide proxy, if any, and trusts the cluster's trusted CA bundle and the
+// given PEM bundle in addition to the system trust store.
+func newHTTPClientWithCA(caBundle []byte, timeout time.Duration) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	trustedCABundle, err := os.ReadFile(clusterTrustedCABundlePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read trusted CA bundle: %w", err)
+	}
+	if len(trustedCABundle) != 0 && !pool.AppendCertsFromPEM(trustedCABundle) {
+		return nil, fmt.Errorf("failed to parse trusted CA bundle %s", clusterTrustedCABundlePath)
+	}
+
+	if len(caBundle) != 0 && !pool.AppendCertsFromPEM(caBundle) {
+		return nil, fmt.Errorf("failed to parse CA bundle")
+	}
+
+	// The proxy settings are passed to the controller in its environment
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// bootImageVerifier checks that a boot image exists and can be used by the cluster's cloud
+// account before machine resources are pointed at it. This allows the cloud to be faked in tests.
+type bootImageVerifier interface {
+	// verifyImage returns an error unless the image exists and is available to the cluster
+	verifyImage(ctx context.Context, image string) error
+}
+
 // This function unmarshals the machineset's provider spec into
 // a ProviderSpec object. Returns an error if providerSpec field is nil,
 // or the unmarshal fails
@@ -66,6 +117,27 @@ func unmarshalStreamDataConfigMap(cm *corev1.ConfigMap, st interface{}) error {
 	return nil
 }
 
+// On platforms where the boot image is uploaded into the cloud, the installer
+// names it "<infraID>-rhcos" and the MCO names the ones it uploads
+// "<infraID>-rhcos-<release>-<arch>". The architecture keeps the images of
+// MachineSets with different architectures apart. This returns the name for
+// the given release and architecture.
+func getManagedBootImageName(infraID, release, arch string) string {
+	return fmt.Sprintf("%s-rhcos-%s-%s", infraID, release, arch)
+}
+
+// Returns true if the boot image was uploaded by either the installer or the
+// MCO for the given architecture. Any other image is considered a custom boot
+// image and is left alone.
+func isManagedBootImageName(infraID, arch, name string) bool {
+	installerName := fmt.Sprintf("%s-rhcos", infraID)
+	if name == installerName {
+		return true
+	}
+
+	return strings.HasPrefix(name, installerName+"-") && strings.HasSuffix(name, "-"+arch)
+}
+
 // This function checks if an array of machineManagers contains the target apigroup/resource and returns
 // a bool(success/fail), a label selector to filter the target resource and an error, if any.
 func getMachineResourceSelectorFromMachineManagers(machineManagers []opv1.MachineManager, apiGroup opv1.MachineManagerMachineSetsAPIGroupType, resource opv1.MachineManagerMachineSetsResourceType) (bool, labels.Selector, error) {
@@ -92,7 +164,12 @@ func getMachineResourceSelectorFromMachineManagers(machineManagers []opv1.Machin
 
 // Upgrades the Ignition stub enclosed in referenced secret if required
 func upgradeStubIgnitionIfRequired(secretName string, secretClient clientset.Interface) error {
-	secret, err := secretClient.CoreV1().Secrets(ctrlcommon.MachineAPINamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	return upgradeStubIgnitionInNamespaceIfRequired(ctrlcommon.MachineAPINamespace, secretName, secretClient)
+}
+
+// Upgrades the Ignition stub enclosed in referenced secret in the given namespace if required
+func upgradeStubIgnitionInNamespaceIfRequired(namespace, secretName string, secretClient clientset.Interface) error {
+	secret, err := secretClient.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("error grabbing user data secret referenced in machineset: %w", err)
 	}
@@ -123,7 +200,7 @@ func upgradeStubIgnitionIfRequired(secretName string, secretClient clientset.Int
 			return fmt.Errorf("failed to marshal updated ignition back to json (secret %s): %w", secret.Name, err)
 		}
 		secret.Data[ctrlcommon.UserDataKey] = updatedIgnition
-		_, err = secretClient.CoreV1().Secrets(ctrlcommon.MachineAPINamespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+		_, err = secretClient.CoreV1().Secrets(namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
 		if err != nil {
 			return fmt.Errorf("could not update secret %s: %w", secret.Name, err)
 		}
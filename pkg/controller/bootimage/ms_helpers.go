@@ -139,6 +139,27 @@ func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet
 		}
 	}
 
+	// Restore the previous boot image if a rollback was requested. This also pins the machineset,
+	// so it is counted as skipped.
+	if isBootImageRollbackRequested(machineSet) {
+		newMachineSet, err := rollbackMAPIMachineSet(machineSet)
+		if err != nil {
+			return false, err
+		}
+		klog.Infof("Rolling back boot image of MAPI machineset %s", machineSet.Name)
+		if err := ctrl.patchMachineSet(machineSet, newMachineSet); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	// Skip if the machineset is pinned to its current boot image. Counted as skipped since the
+	// machineset is intentionally left on an older boot image.
+	if isBootImagePinned(machineSet) {
+		klog.Infof("machineset %s is pinned to its current boot image, skipping boot image update", machineSet.Name)
+		return true, nil
+	}
+
 	// Fetch the ClusterVersion to determine if this is a multi-arch cluster
 	clusterVersion, err := ctrl.clusterVersionLister.Get("version")
 	if err != nil {
@@ -276,22 +297,28 @@ func (ctrl *Controller) patchMachineSet(oldMachineSet, newMachineSet *machinev1b
 
 // Returns architecture type for a given machineset
 func getArchFromMachineSet(machineset *machinev1beta1.MachineSet, clusterVersion *osconfigv1.ClusterVersion) (arch string, err error) {
+	return getArchFromAnnotations(machineset.Name, machineset.Annotations, clusterVersion)
+}
+
+// Returns architecture type for a machine resource with the given name and annotations. The
+// autoscaler capacity annotation is shared by MAPI and CAPI machine resources.
+func getArchFromAnnotations(name string, annotations map[string]string, clusterVersion *osconfigv1.ClusterVersion) (arch string, err error) {
 
 	// Valid set of machineset/node architectures
 	validArchSet := sets.New("arm64", "s390x", "amd64", "ppc64le")
 	// Check if the annotation enclosing arch label is present on this machineset
-	archLabel, archLabelMatch := machineset.Annotations[MachineSetArchAnnotationKey]
+	archLabel, archLabelMatch := annotations[MachineSetArchAnnotationKey]
 
 	if !archLabelMatch {
 		// Check if this is a multi-arch cluster
 		// clusterVersion should never be nil as it's validated by the caller
 		if clusterVersion.Status.Desired.Architecture == osconfigv1.ClusterVersionArchitectureMulti {
 			// For multi-arch clusters, we require the architecture annotation
-			klog.Errorf("No architecture annotation found on machineset %s in multi-arch cluster, skipping boot image update", machineset.Name)
-			return "", fmt.Errorf("no architecture annotation found on machineset %s", machineset.Name)
+			klog.Errorf("No architecture annotation found on machineset %s in multi-arch cluster, skipping boot image update", name)
+			return "", fmt.Errorf("no architecture annotation found on machineset %s", name)
 		}
 		// For single-arch clusters, default to control plane architecture
-		klog.Infof("No architecture annotation found on machineset %s, defaulting to control plane architecture", machineset.Name)
+		klog.Infof("No architecture annotation found on machineset %s, defaulting to control plane architecture", name)
 		return archtranslater.CurrentRpmArch(), nil
 	}
 
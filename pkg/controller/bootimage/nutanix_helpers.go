@@ -0,0 +1,353 @@
+package bootimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1 "github.com/openshift/api/machine/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// Key of the Nutanix credentials secret referenced by the provider spec
+	nutanixCredentialsKey = "credentials"
+
+	// Prism Central v3 image states
+	prismImageStateComplete = "COMPLETE"
+	prismImageStateError    = "ERROR"
+)
+
+var (
+	// How often and for how long to wait for Prism Central to import an image
+	nutanixImageImportInterval = 10 * time.Second
+	nutanixImageImportTimeout  = 30 * time.Minute
+)
+
+// nutanixImageClient is the subset of the Prism Central image API that is used to
+// manage boot images. This allows the service to be faked in tests.
+type nutanixImageClient interface {
+	// getImage returns the image with the given name, or nil if there is none
+	getImage(ctx context.Context, name string) (*prismImage, error)
+	// createImage starts importing a disk image with the given name from the given URL
+	createImage(ctx context.Context, name, sourceURI, sha256 string) (*prismImage, error)
+	// getImageByUUID returns the image with the given UUID
+	getImageByUUID(ctx context.Context, uuid string) (*prismImage, error)
+	// deleteImage deletes the image with the given UUID
+	deleteImage(ctx context.Context, uuid string) error
+}
+
+// prismImage holds the fields of a Prism Central image that the MCO cares about
+type prismImage struct {
+	UUID  string
+	Name  string
+	State string
+}
+
+// newNutanixImageClient is a variable so that tests can substitute a fake image service
+var newNutanixImageClient = newPrismImageClientFromSecret
+
+// reconcileNutanixProviderSpec reconciles the Nutanix provider spec by importing the boot
+// image from the stream into Prism Central, if needed, and pointing the MachineSet at it.
+// Returns whether a patch is required, the updated provider spec, and any error
+func reconcileNutanixProviderSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, providerSpec *machinev1.NutanixMachineProviderConfig, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1.NutanixMachineProviderConfig, error) {
+
+	if infra.Spec.PlatformSpec.Nutanix == nil {
+		klog.Warningf("Reconcile skipped: Nutanix field is nil in PlatformSpec %v", infra.Spec.PlatformSpec)
+		return false, false, nil, nil
+	}
+
+	streamArch, err := streamData.GetArchitecture(arch)
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	artifacts := streamArch.Artifacts["nutanix"]
+	if artifacts.Release == "" {
+		return false, false, nil, fmt.Errorf("%s: artifact '%s' not found", streamData.FormatPrefix(arch), "nutanix")
+	}
+
+	// Images referenced by UUID cannot be matched against the names used by the
+	// installer, so these are treated as custom boot images.
+	if providerSpec.Image.Type != machinev1.NutanixIdentifierName || providerSpec.Image.Name == nil {
+		klog.Infof("current boot image is not referenced by name, skipping update of MachineSet %s", machineSetName)
+		return false, true, nil, nil
+	}
+
+	currentImage := *providerSpec.Image.Name
+	infraID := infra.Status.InfrastructureName
+	newImage := getManagedBootImageName(infraID, artifacts.Release, arch)
+
+	// If the current image matches target image, nothing to do here
+	if currentImage == newImage {
+		return false, false, nil, nil
+	}
+
+	// Validate that the current image was not supplied by the user
+	if !isManagedBootImageName(infraID, arch, currentImage) {
+		klog.Infof("current boot image %s is unknown, skipping update of MachineSet %s", currentImage, machineSetName)
+		return false, true, nil, nil
+	}
+
+	klog.Infof("Current image: %s", currentImage)
+	klog.Infof("New target boot image: %s", newImage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	imageClient, err := newNutanixImageClient(ctx, infra, providerSpec, secretClient)
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	artifact, err := streamData.QueryDisk(arch, "nutanix", "qcow2")
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	if err := ensureNutanixBootImage(ctx, imageClient, artifact, newImage); err != nil {
+		return false, false, nil, err
+	}
+
+	newProviderSpec := providerSpec.DeepCopy()
+	newProviderSpec.Image = machinev1.NutanixResourceIdentifier{
+		Type: machinev1.NutanixIdentifierName,
+		Name: ptr.To(newImage),
+	}
+
+	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
+	if providerSpec.UserDataSecret != nil {
+		if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
+			return false, false, nil, err
+		}
+	}
+
+	return true, false, newProviderSpec, nil
+}
+
+// ensureNutanixBootImage has Prism Central import the given artifact under the given name,
+// unless a complete image with that name already exists, and waits for the import to finish.
+// Prism Central downloads the image itself, so nothing is downloaded by the MCO.
+func ensureNutanixBootImage(ctx context.Context, imageClient nutanixImageClient, artifact *stream.Artifact, name string) error {
+	image, err := imageClient.getImage(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to look up image %s: %w", name, err)
+	}
+
+	switch {
+	case image == nil:
+		klog.Infof("Importing %s into Prism Central as image %s", artifact.Location, name)
+		image, err = imageClient.createImage(ctx, name, artifact.Location, artifact.Sha256)
+		if err != nil {
+			return fmt.Errorf("failed to import image %s: %w", name, err)
+		}
+	case image.State == prismImageStateComplete:
+		klog.Infof("Image %s already exists in Prism Central, skipping import", name)
+		return nil
+	case image.State == prismImageStateError:
+		// A failed import leaves an image in the error state behind; clean it up so that
+		// the next sync retries the import.
+		if err := imageClient.deleteImage(ctx, image.UUID); err != nil {
+			return fmt.Errorf("failed to delete failed image %s: %w", name, err)
+		}
+		return fmt.Errorf("a previous import of image %s failed, it will be retried", name)
+	default:
+		klog.Infof("Image %s is still being imported into Prism Central", name)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, nutanixImageImportInterval, nutanixImageImportTimeout, true, func(ctx context.Context) (bool, error) {
+		image, err = imageClient.getImageByUUID(ctx, image.UUID)
+		if err != nil {
+			return false, err
+		}
+		switch image.State {
+		case prismImageStateComplete:
+			return true, nil
+		case prismImageStateError:
+			return false, fmt.Errorf("prism central failed to import image %s from %s", name, artifact.Location)
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for image %s to be imported: %w", name, err)
+	}
+
+	klog.Infof("Successfully imported image %s", name)
+	return nil
+}
+
+// nutanixCredential is a single entry of the credentials in the Nutanix credentials secret
+type nutanixCredential struct {
+	Type string `json:"type"`
+	Data struct {
+		PrismCentral struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"prismCentral"`
+	} `json:"data"`
+}
+
+// newPrismImageClientFromSecret returns a Prism Central client using the Prism Central
+// endpoint from the infrastructure and the credentials secret referenced by the provider spec.
+func newPrismImageClientFromSecret(ctx context.Context, infra *osconfigv1.Infrastructure, providerSpec *machinev1.NutanixMachineProviderConfig, secretClient clientset.Interface) (nutanixImageClient, error) {
+	if providerSpec.CredentialsSecret == nil {
+		return nil, fmt.Errorf("credentialsSecret is not set in the Nutanix provider spec")
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(MachineAPINamespace).Get(ctx, providerSpec.CredentialsSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s Secret during machineset sync: %w", providerSpec.CredentialsSecret.Name, err)
+	}
+
+	credentials := []nutanixCredential{}
+	if err := json.Unmarshal(secret.Data[nutanixCredentialsKey], &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in Secret %s: %w", nutanixCredentialsKey, secret.Name, err)
+	}
+
+	for _, credential := range credentials {
+		if credential.Type != "basic_auth" {
+			continue
+		}
+
+		prismCentral := infra.Spec.PlatformSpec.Nutanix.PrismCentral
+		endpoint := "https://" + net.JoinHostPort(prismCentral.Address, strconv.Itoa(int(prismCentral.Port)))
+
+		httpClient, err := newHTTPClientWithCA(nil, bootImageUploadTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return &prismImageClient{
+			httpClient: httpClient,
+			endpoint:   endpoint,
+			username:   credential.Data.PrismCentral.Username,
+			password:   credential.Data.PrismCentral.Password,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no basic_auth credentials found in Secret %s", secret.Name)
+}
+
+// prismImageClient talks to the Prism Central v3 API directly
+type prismImageClient struct {
+	httpClient *http.Client
+	endpoint   string
+	username   string
+	password   string
+}
+
+// prismImageEntity is the representation of an image in the Prism Central v3 API
+type prismImageEntity struct {
+	Metadata struct {
+		UUID string `json:"uuid"`
+	} `json:"metadata"`
+	Status struct {
+		Name  string `json:"name"`
+		State string `json:"state"`
+	} `json:"status"`
+}
+
+func (e *prismImageEntity) toImage() *prismImage {
+	return &prismImage{
+		UUID:  e.Metadata.UUID,
+		Name:  e.Status.Name,
+		State: e.Status.State,
+	}
+}
+
+func (p *prismImageClient) do(ctx context.Context, method, path string, body any, expectedStatus int, out any) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint+"/api/nutanix/v3"+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.username, p.password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	_, err = doJSONRequest(p.httpClient, req, expectedStatus, out)
+	return err
+}
+
+func (p *prismImageClient) getImage(ctx context.Context, name string) (*prismImage, error) {
+	resp := struct {
+		Entities []prismImageEntity `json:"entities"`
+	}{}
+
+	body := map[string]any{
+		"kind":   "image",
+		"filter": "name==" + name,
+	}
+
+	if err := p.do(ctx, http.MethodPost, "/images/list", body, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	// The filter is not guaranteed to be an exact match
+	for _, entity := range resp.Entities {
+		if entity.Status.Name == name {
+			return entity.toImage(), nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *prismImageClient) createImage(ctx context.Context, name, sourceURI, sha256 string) (*prismImage, error) {
+	body := map[string]any{
+		"metadata": map[string]any{
+			"kind": "image",
+		},
+		"spec": map[string]any{
+			"name":        name,
+			"description": "Created by the Machine Config Operator",
+			"resources": map[string]any{
+				"image_type": "DISK_IMAGE",
+				"source_uri": sourceURI,
+				"checksum": map[string]any{
+					"checksum_algorithm": "SHA_256",
+					"checksum_value":     sha256,
+				},
+			},
+		},
+	}
+
+	entity := &prismImageEntity{}
+	if err := p.do(ctx, http.MethodPost, "/images", body, http.StatusAccepted, entity); err != nil {
+		return nil, err
+	}
+
+	image := entity.toImage()
+	image.Name = name
+	return image, nil
+}
+
+func (p *prismImageClient) getImageByUUID(ctx context.Context, uuid string) (*prismImage, error) {
+	entity := &prismImageEntity{}
+	if err := p.do(ctx, http.MethodGet, "/images/"+uuid, nil, http.StatusOK, entity); err != nil {
+		return nil, err
+	}
+	return entity.toImage(), nil
+}
+
+func (p *prismImageClient) deleteImage(ctx context.Context, uuid string) error {
+	return p.do(ctx, http.MethodDelete, "/images/"+uuid, nil, http.StatusAccepted, nil)
+}
@@ -0,0 +1,361 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1 "github.com/openshift/api/machine/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+// fakeNutanixImageClient keeps images in memory. Created images only become
+// complete after being polled once.
+type fakeNutanixImageClient struct {
+	images  map[string]*prismImage
+	created []string
+	deleted []string
+	failed  bool
+}
+
+func newFakeNutanixImageClient(images ...*prismImage) *fakeNutanixImageClient {
+	f := &fakeNutanixImageClient{images: map[string]*prismImage{}}
+	for _, image := range images {
+		f.images[image.UUID] = image
+	}
+	return f
+}
+
+func (f *fakeNutanixImageClient) getImage(_ context.Context, name string) (*prismImage, error) {
+	for _, image := range f.images {
+		if image.Name == name {
+			copied := *image
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeNutanixImageClient) createImage(_ context.Context, name, sourceURI, _ string) (*prismImage, error) {
+	image := &prismImage{UUID: "uuid-" + name, Name: name, State: "PENDING"}
+	f.images[image.UUID] = image
+	f.created = append(f.created, sourceURI)
+	copied := *image
+	return &copied, nil
+}
+
+func (f *fakeNutanixImageClient) getImageByUUID(_ context.Context, uuid string) (*prismImage, error) {
+	image := f.images[uuid]
+	if image.State == "PENDING" {
+		image.State = prismImageStateComplete
+		if f.failed {
+			image.State = prismImageStateError
+		}
+	}
+	copied := *image
+	return &copied, nil
+}
+
+func (f *fakeNutanixImageClient) deleteImage(_ context.Context, uuid string) error {
+	delete(f.images, uuid)
+	f.deleted = append(f.deleted, uuid)
+	return nil
+}
+
+func TestReconcileNutanixProviderSpec(t *testing.T) {
+	nutanixImageImportInterval = time.Millisecond
+	t.Cleanup(func() { nutanixImageImportInterval = 10 * time.Second })
+
+	newImage := "test-infra-rhcos-9.6.20250101-0-x86_64"
+	artifactURL := "https://rhcos.mirror.openshift.com/rhcos-9.6.20250101-0-nutanix.x86_64.qcow2"
+
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Artifacts: map[string]stream.PlatformArtifacts{
+					"nutanix": {
+						Release: "9.6.20250101-0",
+						Formats: map[string]stream.ImageFormat{
+							"qcow2": {
+								Disk: &stream.Artifact{
+									Location: artifactURL,
+									Sha256:   "abcd",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"userData": []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[]},"systemd":{},"passwd":{}}`),
+		},
+	}
+
+	infra := &osconfigv1.Infrastructure{
+		Spec: osconfigv1.InfrastructureSpec{
+			PlatformSpec: osconfigv1.PlatformSpec{
+				Nutanix: &osconfigv1.NutanixPlatformSpec{},
+			},
+		},
+		Status: osconfigv1.InfrastructureStatus{
+			InfrastructureName: "test-infra",
+		},
+	}
+
+	tests := []struct {
+		name           string
+		image          machinev1.NutanixResourceIdentifier
+		existingImages []*prismImage
+		importFails    bool
+		expectPatch    bool
+		expectSkip     bool
+		expectCreate   bool
+		expectDeleted  []string
+		expectErr      bool
+	}{
+		{
+			name:         "Installer image is updated",
+			image:        machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos")},
+			expectPatch:  true,
+			expectCreate: true,
+		},
+		{
+			name:  "Current image is not updated",
+			image: machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To(newImage)},
+		},
+		{
+			name:       "Custom image is skipped",
+			image:      machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("my-custom-rhcos")},
+			expectSkip: true,
+		},
+		{
+			name:       "Image for another architecture is skipped",
+			image:      machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos-9.4.20240101-0-aarch64")},
+			expectSkip: true,
+		},
+		{
+			name:       "Image referenced by UUID is skipped",
+			image:      machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierUUID, UUID: ptr.To("1234")},
+			expectSkip: true,
+		},
+		{
+			name:           "Existing complete image is reused",
+			image:          machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos")},
+			existingImages: []*prismImage{{UUID: "existing", Name: newImage, State: prismImageStateComplete}},
+			expectPatch:    true,
+		},
+		{
+			name:           "Pending image is waited for",
+			image:          machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos")},
+			existingImages: []*prismImage{{UUID: "existing", Name: newImage, State: "PENDING"}},
+			expectPatch:    true,
+		},
+		{
+			name:           "Failed image is deleted",
+			image:          machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos")},
+			existingImages: []*prismImage{{UUID: "existing", Name: newImage, State: prismImageStateError}},
+			expectDeleted:  []string{"existing"},
+			expectErr:      true,
+		},
+		{
+			name:         "Failed import is an error",
+			image:        machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To("test-infra-rhcos")},
+			importFails:  true,
+			expectCreate: true,
+			expectErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageClient := newFakeNutanixImageClient(tt.existingImages...)
+			imageClient.failed = tt.importFails
+			newNutanixImageClient = func(_ context.Context, _ *osconfigv1.Infrastructure, _ *machinev1.NutanixMachineProviderConfig, _ kubernetes.Interface) (nutanixImageClient, error) {
+				return imageClient, nil
+			}
+			t.Cleanup(func() { newNutanixImageClient = newPrismImageClientFromSecret })
+
+			providerSpec := &machinev1.NutanixMachineProviderConfig{
+				Image: tt.image,
+				UserDataSecret: &corev1.LocalObjectReference{
+					Name: "test-secret",
+				},
+			}
+
+			patchRequired, patchSkipped, updatedProviderSpec, err := reconcileNutanixProviderSpec(streamData, "x86_64", infra, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+
+			assert.Equal(t, tt.expectDeleted, imageClient.deleted)
+
+			if tt.expectCreate {
+				assert.Equal(t, []string{artifactURL}, imageClient.created)
+			} else {
+				assert.Empty(t, imageClient.created)
+			}
+
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPatch, patchRequired)
+			assert.Equal(t, tt.expectSkip, patchSkipped)
+
+			if !tt.expectPatch {
+				assert.Nil(t, updatedProviderSpec)
+				return
+			}
+
+			assert.Equal(t, machinev1.NutanixResourceIdentifier{Type: machinev1.NutanixIdentifierName, Name: ptr.To(newImage)}, updatedProviderSpec.Image)
+		})
+	}
+}
+
+// This tests the requests made by prismImageClient against the Prism Central v3 API
+func TestPrismImageClient(t *testing.T) {
+	entities := map[string]map[string]any{}
+
+	newEntity := func(uuid, name, state string) map[string]any {
+		return map[string]any{
+			"metadata": map[string]any{"uuid": uuid},
+			"status":   map[string]any{"name": name, "state": state},
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/nutanix/v3/images/list", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "image", body["kind"])
+
+		found := []map[string]any{newEntity("other", "test-infra-rhcos-1-other", prismImageStateComplete)}
+		for _, entity := range entities {
+			if "name=="+entity["status"].(map[string]any)["name"].(string) == body["filter"] {
+				found = append(found, entity)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"entities": found})
+	})
+	mux.HandleFunc("POST /api/nutanix/v3/images", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		spec := body["spec"].(map[string]any)
+		resources := spec["resources"].(map[string]any)
+		assert.Equal(t, "DISK_IMAGE", resources["image_type"])
+		assert.Equal(t, "https://example.com/rhcos.qcow2", resources["source_uri"])
+		assert.Equal(t, "abcd", resources["checksum"].(map[string]any)["checksum_value"])
+
+		entities["new-uuid"] = newEntity("new-uuid", spec["name"].(string), "PENDING")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]any{
+			"metadata": map[string]any{"uuid": "new-uuid"},
+			"status":   map[string]any{"state": "PENDING"},
+		})
+	})
+	mux.HandleFunc("GET /api/nutanix/v3/images/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(entities[r.PathValue("uuid")])
+	})
+	mux.HandleFunc("DELETE /api/nutanix/v3/images/{uuid}", func(w http.ResponseWriter, r *http.Request) {
+		delete(entities, r.PathValue("uuid"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client := &prismImageClient{
+		httpClient: server.Client(),
+		endpoint:   server.URL,
+		username:   "admin",
+		password:   "secret",
+	}
+
+	ctx := context.Background()
+
+	image, err := client.getImage(ctx, "test-infra-rhcos-1")
+	require.NoError(t, err)
+	assert.Nil(t, image)
+
+	image, err = client.createImage(ctx, "test-infra-rhcos-1", "https://example.com/rhcos.qcow2", "abcd")
+	require.NoError(t, err)
+	assert.Equal(t, &prismImage{UUID: "new-uuid", Name: "test-infra-rhcos-1", State: "PENDING"}, image)
+
+	image, err = client.getImage(ctx, "test-infra-rhcos-1")
+	require.NoError(t, err)
+	assert.Equal(t, &prismImage{UUID: "new-uuid", Name: "test-infra-rhcos-1", State: "PENDING"}, image)
+
+	image, err = client.getImageByUUID(ctx, "new-uuid")
+	require.NoError(t, err)
+	assert.Equal(t, "test-infra-rhcos-1", image.Name)
+
+	require.NoError(t, client.deleteImage(ctx, "new-uuid"))
+	assert.Empty(t, entities)
+
+	// Credentials are checked
+	client.password = "wrong"
+	_, err = client.getImage(ctx, "test-infra-rhcos-1")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestNewPrismImageClientFromSecret(t *testing.T) {
+	infra := &osconfigv1.Infrastructure{
+		Spec: osconfigv1.InfrastructureSpec{
+			PlatformSpec: osconfigv1.PlatformSpec{
+				Nutanix: &osconfigv1.NutanixPlatformSpec{
+					PrismCentral: osconfigv1.NutanixPrismEndpoint{Address: "prism.example.com", Port: 9440},
+				},
+			},
+		},
+	}
+
+	secretClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "nutanix-credentials",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"credentials": []byte(`[{"type":"basic_auth","data":{"prismCentral":{"username":"admin","password":"secret"},"prismElements":null}}]`),
+		},
+	})
+
+	providerSpec := &machinev1.NutanixMachineProviderConfig{
+		CredentialsSecret: &corev1.LocalObjectReference{Name: "nutanix-credentials"},
+	}
+
+	client, err := newPrismImageClientFromSecret(context.Background(), infra, providerSpec, secretClient)
+	require.NoError(t, err)
+
+	prismClient := client.(*prismImageClient)
+	assert.Equal(t, "https://prism.example.com:9440", prismClient.endpoint)
+	assert.Equal(t, "admin", prismClient.username)
+	assert.Equal(t, "secret", prismClient.password)
+
+	providerSpec.CredentialsSecret.Name = "nonexistent"
+	_, err = newPrismImageClientFromSecret(context.Background(), infra, providerSpec, secretClient)
+	assert.Error(t, err)
+}
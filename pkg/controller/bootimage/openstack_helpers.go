@@ -0,0 +1,492 @@
+package bootimage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1alpha1 "github.com/openshift/api/machine/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/machine-config-operator/pkg/controller/bootimage/cache"
+)
+
+const (
+	// Keys of the OpenStack credentials secret referenced by the provider spec
+	openStackCloudsYAMLKey = "clouds.yaml"
+	openStackCACertKey     = "cacert"
+
+	// Glance image states, see https://docs.openstack.org/glance/latest/user/statuses.html
+	glanceImageStatusActive = "active"
+)
+
+// openStackImageClient is the subset of the OpenStack image service (Glance)
+// that is used to manage boot images. This allows the service to be faked in tests.
+type openStackImageClient interface {
+	// getImage returns the image with the given name, or nil if there is none
+	getImage(ctx context.Context, name string) (*glanceImage, error)
+	// createImage creates a qcow2 image with the given name and uploads its contents
+	createImage(ctx context.Context, name string, tags []string, contents io.Reader) (*glanceImage, error)
+	// deleteImage deletes the image with the given ID
+	deleteImage(ctx context.Context, id string) error
+}
+
+// glanceImage holds the fields of a Glance image that the MCO cares about
+type glanceImage struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// newOpenStackImageClient is a variable so that tests can substitute a fake image service
+var newOpenStackImageClient = newGlanceImageClientFromSecret
+
+// reconcileOpenStackProviderSpec reconciles the OpenStack provider spec by uploading the
+// boot image from the stream into Glance, if needed, and pointing the MachineSet at it.
+// Returns whether a patch is required, the updated provider spec, and any error
+func reconcileOpenStackProviderSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, providerSpec *machinev1alpha1.OpenstackProviderSpec, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1alpha1.OpenstackProviderSpec, error) {
+
+	streamArch, err := streamData.GetArchitecture(arch)
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	artifacts := streamArch.Artifacts["openstack"]
+	if artifacts.Release == "" {
+		return false, false, nil, fmt.Errorf("%s: artifact '%s' not found", streamData.FormatPrefix(arch), "openstack")
+	}
+
+	// The image field takes precedence; the deprecated rootVolume.sourceUUID field is
+	// only used by older MachineSets which boot from a volume.
+	currentImage := providerSpec.Image
+	usesRootVolume := currentImage == "" && providerSpec.RootVolume != nil && providerSpec.RootVolume.SourceUUID != ""
+	if usesRootVolume {
+		currentImage = providerSpec.RootVolume.SourceUUID
+	}
+
+	infraID := infra.Status.InfrastructureName
+	newImage := getManagedBootImageName(infraID, artifacts.Release, arch)
+
+	// If the current image matches target image, nothing to do here
+	if currentImage == newImage {
+		return false, false, nil, nil
+	}
+
+	// Validate that the current image was not supplied by the user
+	if !isManagedBootImageName(infraID, arch, currentImage) {
+		klog.Infof("current boot image %s is unknown, skipping update of MachineSet %s", currentImage, machineSetName)
+		return false, true, nil, nil
+	}
+
+	klog.Infof("Current image: %s", currentImage)
+	klog.Infof("New target boot image: %s", newImage)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	imageClient, err := newOpenStackImageClient(ctx, providerSpec, secretClient)
+	if err != nil {
+		return false, false, nil, err
+	}
+
+	if err := ensureOpenStackBootImage(ctx, imageClient, streamData, arch, newImage, infraID); err != nil {
+		return false, false, nil, err
+	}
+
+	newProviderSpec := providerSpec.DeepCopy()
+	if usesRootVolume {
+		newProviderSpec.RootVolume.SourceUUID = newImage
+	} else {
+		newProviderSpec.Image = newImage
+	}
+
+	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
+	if providerSpec.UserDataSecret != nil {
+		if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
+			return false, false, nil, err
+		}
+	}
+
+	return true, false, newProviderSpec, nil
+}
+
+// ensureOpenStackBootImage uploads the boot image for the given architecture into Glance under
+// the given name, unless an active image with that name already exists. This makes repeated
+// syncs, and syncs of other MachineSets sharing the same image, cheap.
+func ensureOpenStackBootImage(ctx context.Context, imageClient openStackImageClient, streamData *stream.Stream, arch, name, infraID string) error {
+	existing, err := imageClient.getImage(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to look up image %s: %w", name, err)
+	}
+
+	if existing != nil {
+		if existing.Status == glanceImageStatusActive {
+			klog.Infof("Image %s already exists in Glance, skipping upload", name)
+			return nil
+		}
+		// An image that is not active is left over from an interrupted upload
+		klog.Infof("Image %s exists in Glance with status %s, deleting it before uploading again", name, existing.Status)
+		if err := imageClient.deleteImage(ctx, existing.ID); err != nil {
+			return fmt.Errorf("failed to delete incomplete image %s: %w", name, err)
+		}
+	}
+
+	// The installer uploads the decompressed qcow2 image, so do the same
+	artifact, err := streamData.QueryDisk(arch, "openstack", "qcow2.gz")
+	if err != nil {
+		return err
+	}
+
+	artifactPath, err := cache.DownloadArtifact(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", artifact.Location, err)
+	}
+
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %w", artifactPath, err)
+	}
+	defer gz.Close()
+
+	hasher := sha256.New()
+
+	klog.Infof("Uploading %s to Glance as image %s", artifact.Location, name)
+	image, err := imageClient.createImage(ctx, name, []string{fmt.Sprintf("openshiftClusterID=%s", infraID)}, io.TeeReader(gz, hasher))
+	if err != nil {
+		return fmt.Errorf("failed to upload image %s: %w", name, err)
+	}
+
+	// Validate the uncompressed checksum; the compressed one was validated on download
+	if artifact.UncompressedSha256 != "" {
+		if foundChecksum := fmt.Sprintf("%x", hasher.Sum(nil)); foundChecksum != artifact.UncompressedSha256 {
+			if err := imageClient.deleteImage(ctx, image.ID); err != nil {
+				klog.Errorf("failed to delete corrupt image %s: %v", name, err)
+			}
+			return fmt.Errorf("uncompressed checksum mismatch for %s; expected=%s found=%s", artifact.Location, artifact.UncompressedSha256, foundChecksum)
+		}
+	}
+
+	klog.Infof("Successfully uploaded image %s", name)
+	return nil
+}
+
+// openStackClouds is the subset of a clouds.yaml file needed to authenticate
+// See https://docs.openstack.org/python-openstackclient/latest/configuration/index.html
+type openStackClouds struct {
+	Clouds map[string]openStackCloud `json:"clouds"`
+}
+
+type openStackCloud struct {
+	Auth       openStackAuth `json:"auth"`
+	RegionName string        `json:"region_name"`
+	Interface  string        `json:"interface"`
+}
+
+type openStackAuth struct {
+	AuthURL                     string `json:"auth_url"`
+	Username                    string `json:"username"`
+	UserID                      string `json:"user_id"`
+	Password                    string `json:"password"`
+	ProjectID                   string `json:"project_id"`
+	ProjectName                 string `json:"project_name"`
+	UserDomainID                string `json:"user_domain_id"`
+	UserDomainName              string `json:"user_domain_name"`
+	ProjectDomainID             string `json:"project_domain_id"`
+	ProjectDomainName           string `json:"project_domain_name"`
+	DomainID                    string `json:"domain_id"`
+	DomainName                  string `json:"domain_name"`
+	ApplicationCredentialID     string `json:"application_credential_id"`
+	ApplicationCredentialSecret string `json:"application_credential_secret"`
+}
+
+// newGlanceImageClientFromSecret authenticates against Keystone with the cloud from the
+// clouds.yaml in the secret referenced by the provider spec, and returns a Glance client.
+func newGlanceImageClientFromSecret(ctx context.Context, providerSpec *machinev1alpha1.OpenstackProviderSpec, secretClient clientset.Interface) (openStackImageClient, error) {
+	if providerSpec.CloudsSecret == nil {
+		return nil, fmt.Errorf("cloudsSecret is not set in the OpenStack provider spec")
+	}
+
+	namespace := providerSpec.CloudsSecret.Namespace
+	if namespace == "" {
+		namespace = MachineAPINamespace
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(namespace).Get(ctx, providerSpec.CloudsSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s/%s Secret during machineset sync: %w", namespace, providerSpec.CloudsSecret.Name, err)
+	}
+
+	clouds := openStackClouds{}
+	if err := yaml.Unmarshal(secret.Data[openStackCloudsYAMLKey], &clouds); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in Secret %s/%s: %w", openStackCloudsYAMLKey, namespace, secret.Name, err)
+	}
+
+	cloud, ok := clouds.Clouds[providerSpec.CloudName]
+	if !ok {
+		return nil, fmt.Errorf("cloud %q not found in %s in Secret %s/%s", providerSpec.CloudName, openStackCloudsYAMLKey, namespace, secret.Name)
+	}
+
+	httpClient, err := newHTTPClientWithCA(secret.Data[openStackCACertKey], bootImageUploadTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return authenticateGlanceImageClient(ctx, httpClient, cloud)
+}
+
+// glanceImageClient talks to the Glance v2 API directly
+type glanceImageClient struct {
+	httpClient *http.Client
+	endpoint   string
+	token      string
+}
+
+// authenticateGlanceImageClient requests a token from Keystone v3 and finds the Glance endpoint
+// in the returned service catalog.
+func authenticateGlanceImageClient(ctx context.Context, httpClient *http.Client, cloud openStackCloud) (*glanceImageClient, error) {
+	body, err := json.Marshal(getKeystoneAuthRequest(cloud.Auth))
+	if err != nil {
+		return nil, err
+	}
+
+	authURL := strings.TrimSuffix(cloud.Auth.AuthURL, "/")
+	if !strings.HasSuffix(authURL, "/v3") {
+		authURL += "/v3"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL+"/auth/tokens", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := struct {
+		Token struct {
+			Catalog []struct {
+				Type      string `json:"type"`
+				Endpoints []struct {
+					Interface string `json:"interface"`
+					Region    string `json:"region"`
+					RegionID  string `json:"region_id"`
+					URL       string `json:"url"`
+				} `json:"endpoints"`
+			} `json:"catalog"`
+		} `json:"token"`
+	}{}
+
+	header, err := doJSONRequest(httpClient, req, http.StatusCreated, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with Keystone: %w", err)
+	}
+
+	token := header.Get("X-Subject-Token")
+	if token == "" {
+		return nil, fmt.Errorf("no token was returned by Keystone")
+	}
+
+	endpointInterface := cloud.Interface
+	if endpointInterface == "" {
+		endpointInterface = "public"
+	}
+
+	for _, service := range resp.Token.Catalog {
+		if service.Type != "image" {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface != endpointInterface {
+				continue
+			}
+			if cloud.RegionName != "" && endpoint.Region != cloud.RegionName && endpoint.RegionID != cloud.RegionName {
+				continue
+			}
+			return &glanceImageClient{
+				httpClient: httpClient,
+				endpoint:   strings.TrimSuffix(strings.TrimSuffix(endpoint.URL, "/"), "/v2"),
+				token:      token,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no %s image service endpoint found in region %q", endpointInterface, cloud.RegionName)
+}
+
+// getKeystoneAuthRequest builds the body of a Keystone v3 token request, using application
+// credentials if present and a project-scoped password authentication otherwise.
+// See https://docs.openstack.org/api-ref/identity/v3/#authentication-and-token-management
+func getKeystoneAuthRequest(auth openStackAuth) map[string]any {
+	if auth.ApplicationCredentialID != "" {
+		return map[string]any{
+			"auth": map[string]any{
+				"identity": map[string]any{
+					"methods": []string{"application_credential"},
+					"application_credential": map[string]any{
+						"id":     auth.ApplicationCredentialID,
+						"secret": auth.ApplicationCredentialSecret,
+					},
+				},
+			},
+		}
+	}
+
+	domain := func(id, name string) map[string]any {
+		switch {
+		case id != "":
+			return map[string]any{"id": id}
+		case name != "":
+			return map[string]any{"name": name}
+		case auth.DomainID != "":
+			return map[string]any{"id": auth.DomainID}
+		default:
+			return map[string]any{"name": auth.DomainName}
+		}
+	}
+
+	user := map[string]any{"password": auth.Password}
+	if auth.UserID != "" {
+		user["id"] = auth.UserID
+	} else {
+		user["name"] = auth.Username
+		user["domain"] = domain(auth.UserDomainID, auth.UserDomainName)
+	}
+
+	project := map[string]any{}
+	if auth.ProjectID != "" {
+		project["id"] = auth.ProjectID
+	} else {
+		project["name"] = auth.ProjectName
+		project["domain"] = domain(auth.ProjectDomainID, auth.ProjectDomainName)
+	}
+
+	return map[string]any{
+		"auth": map[string]any{
+			"identity": map[string]any{
+				"methods":  []string{"password"},
+				"password": map[string]any{"user": user},
+			},
+			"scope": map[string]any{"project": project},
+		},
+	}
+}
+
+func (g *glanceImageClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, g.endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Auth-Token", g.token)
+	return req, nil
+}
+
+func (g *glanceImageClient) getImage(ctx context.Context, name string) (*glanceImage, error) {
+	req, err := g.newRequest(ctx, http.MethodGet, "/v2/images?name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := struct {
+		Images []glanceImage `json:"images"`
+	}{}
+
+	if _, err := doJSONRequest(g.httpClient, req, http.StatusOK, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Images) == 0 {
+		return nil, nil
+	}
+
+	return &resp.Images[0], nil
+}
+
+func (g *glanceImageClient) createImage(ctx context.Context, name string, tags []string, contents io.Reader) (*glanceImage, error) {
+	body, err := json.Marshal(map[string]any{
+		"name":             name,
+		"disk_format":      "qcow2",
+		"container_format": "bare",
+		"visibility":       "private",
+		"tags":             tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := g.newRequest(ctx, http.MethodPost, "/v2/images", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	image := &glanceImage{}
+	if _, err := doJSONRequest(g.httpClient, req, http.StatusCreated, image); err != nil {
+		return nil, err
+	}
+
+	req, err = g.newRequest(ctx, http.MethodPut, "/v2/images/"+image.ID+"/file", contents)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if _, err := doJSONRequest(g.httpClient, req, http.StatusNoContent, nil); err != nil {
+		// Do not leave an empty image behind
+		if deleteErr := g.deleteImage(ctx, image.ID); deleteErr != nil {
+			klog.Errorf("failed to delete image %s after failed upload: %v", name, deleteErr)
+		}
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func (g *glanceImageClient) deleteImage(ctx context.Context, id string) error {
+	req, err := g.newRequest(ctx, http.MethodDelete, "/v2/images/"+id, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = doJSONRequest(g.httpClient, req, http.StatusNoContent, nil)
+	return err
+}
+
+// doJSONRequest performs the request, checks the response status code and decodes the JSON
+// response body into out, if not nil. Returns the response headers.
+func doJSONRequest(httpClient *http.Client, req *http.Request, expectedStatus int, out any) (http.Header, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s returned status %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, fmt.Errorf("failed to decode response from %s %s: %w", req.Method, req.URL.Redacted(), err)
+		}
+	}
+
+	return resp.Header, nil
+}
@@ -0,0 +1,406 @@
+package bootimage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1alpha1 "github.com/openshift/api/machine/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/openshift/machine-config-operator/pkg/controller/bootimage/cache"
+)
+
+// fakeOpenStackImageClient keeps images in memory
+type fakeOpenStackImageClient struct {
+	images   map[string]*glanceImage
+	uploaded map[string][]byte
+	deleted  []string
+}
+
+func newFakeOpenStackImageClient(images ...*glanceImage) *fakeOpenStackImageClient {
+	f := &fakeOpenStackImageClient{
+		images:   map[string]*glanceImage{},
+		uploaded: map[string][]byte{},
+	}
+	for _, image := range images {
+		f.images[image.Name] = image
+	}
+	return f
+}
+
+func (f *fakeOpenStackImageClient) getImage(_ context.Context, name string) (*glanceImage, error) {
+	return f.images[name], nil
+}
+
+func (f *fakeOpenStackImageClient) createImage(_ context.Context, name string, _ []string, contents io.Reader) (*glanceImage, error) {
+	data, err := io.ReadAll(contents)
+	if err != nil {
+		return nil, err
+	}
+	image := &glanceImage{ID: "id-" + name, Name: name, Status: glanceImageStatusActive}
+	f.images[name] = image
+	f.uploaded[name] = data
+	return image, nil
+}
+
+func (f *fakeOpenStackImageClient) deleteImage(_ context.Context, id string) error {
+	for name, image := range f.images {
+		if image.ID == id {
+			delete(f.images, name)
+		}
+	}
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+// newOpenStackTestStream serves a gzipped qcow2 artifact and returns the stream referencing it
+func newOpenStackTestStream(t *testing.T, contents []byte) *stream.Stream {
+	t.Helper()
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(compressed.Bytes())
+	}))
+	t.Cleanup(server.Close)
+
+	// The artifact is downloaded into the shared boot image cache, so give it a name unique to this test
+	artifactName := fmt.Sprintf("rhcos-%x-openstack.x86_64.qcow2.gz", sha256.Sum256([]byte(t.Name())))
+	t.Cleanup(func() {
+		cachedPath := filepath.Join("/tmp", cache.ImageBasedApplicationName, cache.ImageDataType+"_cache", artifactName)
+		os.Remove(cachedPath)
+	})
+
+	return &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Artifacts: map[string]stream.PlatformArtifacts{
+					"openstack": {
+						Release: "9.6.20250101-0",
+						Formats: map[string]stream.ImageFormat{
+							"qcow2.gz": {
+								Disk: &stream.Artifact{
+									Location:           server.URL + "/" + artifactName,
+									Sha256:             fmt.Sprintf("%x", sha256.Sum256(compressed.Bytes())),
+									UncompressedSha256: fmt.Sprintf("%x", sha256.Sum256(contents)),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestReconcileOpenStackProviderSpec(t *testing.T) {
+	contents := []byte("qcow2 image contents")
+	newImage := "test-infra-rhcos-9.6.20250101-0-x86_64"
+
+	testSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test-secret",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"userData": []byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[]},"systemd":{},"passwd":{}}`),
+		},
+	}
+
+	infra := &osconfigv1.Infrastructure{
+		Status: osconfigv1.InfrastructureStatus{
+			InfrastructureName: "test-infra",
+		},
+	}
+
+	tests := []struct {
+		name                 string
+		image                string
+		rootVolumeSourceUUID string
+		existingImages       []*glanceImage
+		uncompressedSha256   string
+		expectPatch          bool
+		expectSkip           bool
+		expectUpload         bool
+		expectDeleted        []string
+		expectErr            bool
+	}{
+		{
+			name:         "Installer image is updated",
+			image:        "test-infra-rhcos",
+			expectPatch:  true,
+			expectUpload: true,
+		},
+		{
+			name:         "Previously updated image is updated",
+			image:        "test-infra-rhcos-9.4.20240101-0-x86_64",
+			expectPatch:  true,
+			expectUpload: true,
+		},
+		{
+			name:       "Image for another architecture is skipped",
+			image:      "test-infra-rhcos-9.4.20240101-0-aarch64",
+			expectSkip: true,
+		},
+		{
+			name:                 "Root volume image is updated",
+			rootVolumeSourceUUID: "test-infra-rhcos",
+			expectPatch:          true,
+			expectUpload:         true,
+		},
+		{
+			name:  "Current image is not updated",
+			image: newImage,
+		},
+		{
+			name:       "Custom image is skipped",
+			image:      "my-custom-rhcos",
+			expectSkip: true,
+		},
+		{
+			name:           "Existing active image is reused",
+			image:          "test-infra-rhcos",
+			existingImages: []*glanceImage{{ID: "existing", Name: newImage, Status: glanceImageStatusActive}},
+			expectPatch:    true,
+		},
+		{
+			name:           "Incomplete image is uploaded again",
+			image:          "test-infra-rhcos",
+			existingImages: []*glanceImage{{ID: "existing", Name: newImage, Status: "queued"}},
+			expectPatch:    true,
+			expectUpload:   true,
+			expectDeleted:  []string{"existing"},
+		},
+		{
+			name:               "Corrupt image is deleted",
+			image:              "test-infra-rhcos",
+			uncompressedSha256: "0000",
+			expectDeleted:      []string{"id-" + newImage},
+			expectErr:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streamData := newOpenStackTestStream(t, contents)
+			if tt.uncompressedSha256 != "" {
+				streamData.Architectures["x86_64"].Artifacts["openstack"].Formats["qcow2.gz"].Disk.UncompressedSha256 = tt.uncompressedSha256
+			}
+
+			imageClient := newFakeOpenStackImageClient(tt.existingImages...)
+			newOpenStackImageClient = func(_ context.Context, _ *machinev1alpha1.OpenstackProviderSpec, _ kubernetes.Interface) (openStackImageClient, error) {
+				return imageClient, nil
+			}
+			t.Cleanup(func() { newOpenStackImageClient = newGlanceImageClientFromSecret })
+
+			providerSpec := &machinev1alpha1.OpenstackProviderSpec{
+				Image: tt.image,
+				UserDataSecret: &corev1.SecretReference{
+					Name: "test-secret",
+				},
+			}
+			if tt.rootVolumeSourceUUID != "" {
+				providerSpec.RootVolume = &machinev1alpha1.RootVolume{SourceUUID: tt.rootVolumeSourceUUID}
+			}
+
+			patchRequired, patchSkipped, updatedProviderSpec, err := reconcileOpenStackProviderSpec(streamData, "x86_64", infra, providerSpec, "test-machineset", fake.NewSimpleClientset(testSecret))
+
+			assert.Equal(t, tt.expectDeleted, imageClient.deleted)
+
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.NotContains(t, imageClient.images, newImage)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectPatch, patchRequired)
+			assert.Equal(t, tt.expectSkip, patchSkipped)
+
+			if tt.expectUpload {
+				assert.Equal(t, contents, imageClient.uploaded[newImage])
+			} else {
+				assert.NotContains(t, imageClient.uploaded, newImage)
+			}
+
+			if !tt.expectPatch {
+				assert.Nil(t, updatedProviderSpec)
+				return
+			}
+
+			if tt.rootVolumeSourceUUID != "" {
+				assert.Equal(t, newImage, updatedProviderSpec.RootVolume.SourceUUID)
+				assert.Empty(t, updatedProviderSpec.Image)
+			} else {
+				assert.Equal(t, newImage, updatedProviderSpec.Image)
+			}
+		})
+	}
+}
+
+// This tests the Keystone authentication and Glance requests made by glanceImageClient
+func TestGlanceImageClient(t *testing.T) {
+	var serverURL string
+	images := map[string]*glanceImage{}
+	uploaded := map[string][]byte{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /identity/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		identity := body["auth"].(map[string]any)["identity"].(map[string]any)
+		user := identity["password"].(map[string]any)["user"].(map[string]any)
+		if user["name"] != "admin" || user["password"] != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("X-Subject-Token", "test-token")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":{"catalog":[
+			{"type":"compute","endpoints":[{"interface":"public","region":"regionOne","url":"%[1]s/compute"}]},
+			{"type":"image","endpoints":[
+				{"interface":"internal","region":"regionOne","url":"%[1]s/internal"},
+				{"interface":"public","region":"regionTwo","url":"%[1]s/other"},
+				{"interface":"public","region":"regionOne","url":"%[1]s/image/v2/"}
+			]}
+		]}}`, serverURL)
+	})
+	mux.HandleFunc("/image/v2/images", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test-token", r.Header.Get("X-Auth-Token"))
+		switch r.Method {
+		case http.MethodGet:
+			found := []*glanceImage{}
+			if image, ok := images[r.URL.Query().Get("name")]; ok {
+				found = append(found, image)
+			}
+			json.NewEncoder(w).Encode(map[string]any{"images": found})
+		case http.MethodPost:
+			body := map[string]any{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "qcow2", body["disk_format"])
+			assert.Equal(t, []any{"openshiftClusterID=test-infra"}, body["tags"])
+			image := &glanceImage{ID: "new-id", Name: body["name"].(string), Status: "queued"}
+			images[image.Name] = image
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(image)
+		}
+	})
+	mux.HandleFunc("/image/v2/images/{id}/file", func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		uploaded[r.PathValue("id")] = data
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /image/v2/images/{id}", func(w http.ResponseWriter, r *http.Request) {
+		for name, image := range images {
+			if image.ID == r.PathValue("id") {
+				delete(images, name)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	serverURL = server.URL
+
+	cloudsYAML := fmt.Sprintf(`clouds:
+  openstack:
+    auth:
+      auth_url: %s/identity
+      username: admin
+      password: secret
+      project_name: test
+      user_domain_name: Default
+      project_domain_name: Default
+    region_name: regionOne
+`, server.URL)
+
+	secretClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "openstack-cloud-credentials",
+			Namespace: "openshift-machine-api",
+		},
+		Data: map[string][]byte{
+			"clouds.yaml": []byte(cloudsYAML),
+		},
+	})
+
+	providerSpec := &machinev1alpha1.OpenstackProviderSpec{
+		CloudsSecret: &corev1.SecretReference{Name: "openstack-cloud-credentials"},
+		CloudName:    "openstack",
+	}
+
+	ctx := context.Background()
+
+	client, err := newGlanceImageClientFromSecret(ctx, providerSpec, secretClient)
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/image", client.(*glanceImageClient).endpoint)
+
+	image, err := client.getImage(ctx, "test-infra-rhcos-1")
+	require.NoError(t, err)
+	assert.Nil(t, image)
+
+	image, err = client.createImage(ctx, "test-infra-rhcos-1", []string{"openshiftClusterID=test-infra"}, bytes.NewReader([]byte("contents")))
+	require.NoError(t, err)
+	assert.Equal(t, "new-id", image.ID)
+	assert.Equal(t, []byte("contents"), uploaded["new-id"])
+
+	image, err = client.getImage(ctx, "test-infra-rhcos-1")
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	assert.Equal(t, "new-id", image.ID)
+
+	require.NoError(t, client.deleteImage(ctx, "new-id"))
+	assert.Empty(t, images)
+
+	// The cloud must exist in clouds.yaml
+	providerSpec.CloudName = "nonexistent"
+	_, err = newGlanceImageClientFromSecret(ctx, providerSpec, secretClient)
+	assert.ErrorContains(t, err, `cloud "nonexistent" not found`)
+}
+
+func TestGetKeystoneAuthRequest(t *testing.T) {
+	appCred := getKeystoneAuthRequest(openStackAuth{
+		ApplicationCredentialID:     "id",
+		ApplicationCredentialSecret: "secret",
+	})
+	assert.JSONEq(t, `{"auth":{"identity":{"methods":["application_credential"],"application_credential":{"id":"id","secret":"secret"}}}}`, toJSON(t, appCred))
+
+	password := getKeystoneAuthRequest(openStackAuth{
+		Username:  "admin",
+		Password:  "secret",
+		ProjectID: "project",
+		DomainID:  "default",
+	})
+	assert.JSONEq(t, `{"auth":{"identity":{"methods":["password"],"password":{"user":{"name":"admin","password":"secret","domain":{"id":"default"}}}},"scope":{"project":{"id":"project"}}}}`, toJSON(t, password))
+}
+
+func toJSON(t *testing.T, in any) string {
+	t.Helper()
+	out, err := json.Marshal(in)
+	require.NoError(t, err)
+	return string(out)
+}
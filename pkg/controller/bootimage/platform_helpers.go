@@ -55,6 +55,10 @@ func checkMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1beta
 		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileGCPProviderSpec)
 	case osconfigv1.VSpherePlatformType:
 		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileVSphereProviderSpec)
+	case osconfigv1.OpenStackPlatformType:
+		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileOpenStackProviderSpec)
+	case osconfigv1.NutanixPlatformType:
+		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileNutanixProviderSpec)
 	default:
 		klog.Infof("Skipping machineset %s, unsupported platform %s", machineSet.Name, infra.Status.PlatformStatus.Type)
 		return false, false, nil, nil
@@ -101,6 +105,11 @@ func reconcilePlatform[T any](
 	if err := marshalProviderSpec(newMachineSet, newProviderSpec); err != nil {
 		return false, false, nil, err
 	}
+
+	// Record the boot image being replaced, so that a rollback can be requested
+	if err := setPreviousBootImage(newMachineSet, providerSpec, newProviderSpec); err != nil {
+		return false, false, nil, err
+	}
 	return patchRequired, false, newMachineSet, nil
 }
 
@@ -138,6 +147,10 @@ func reconcileGCPProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 	}
 
 	if patchRequired {
+		// Ensure the new image can be used by this project before pointing the MachineSet at it
+		if err := verifyGCPBootImage(providerSpec, newBootImage, secretClient); err != nil {
+			return false, false, nil, err
+		}
 		// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
 		if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
 			return false, false, nil, err
@@ -149,7 +162,7 @@ func reconcileGCPProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 
 // reconcileAWSProviderSpec reconciles the AWS provider spec by updating AMIs
 // Returns whether a patch is required, the updated provider spec, and any error
-func reconcileAWSProviderSpec(streamData *stream.Stream, arch string, _ *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AWSMachineProviderConfig, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.AWSMachineProviderConfig, error) {
+func reconcileAWSProviderSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AWSMachineProviderConfig, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.AWSMachineProviderConfig, error) {
 
 	// Extract the region from the Placement field
 	region := providerSpec.Placement.Region
@@ -191,6 +204,11 @@ func reconcileAWSProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 	klog.Infof("Current image: %s: %s", region, currentAMI)
 	klog.Infof("New target boot image: %s: %s", region, newAMI)
 
+	// Ensure the new AMI can be launched by this account before pointing the MachineSet at it
+	if err := verifyAWSBootImage(infra, providerSpec, region, newAMI, secretClient); err != nil {
+		return false, false, nil, err
+	}
+
 	// Only one of ID, ARN or Filters in the AMI may be specified, so define
 	// a new AMI object with only an ID field.
 	newProviderSpec.AMI = machinev1beta1.AWSResourceReference{
@@ -249,7 +267,7 @@ func reconcileVSphereProviderSpec(streamData *stream.Stream, arch string, infra
 
 // reconcileAzureProviderSpec reconciles the Azure provider spec by updating AMIs
 // Returns whether a patch is required, the updated provider spec, and any error
-func reconcileAzureProviderSpec(streamData *stream.Stream, arch string, _ *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AzureMachineProviderSpec, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.AzureMachineProviderSpec, error) {
+func reconcileAzureProviderSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.AzureMachineProviderSpec, machineSetName string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.AzureMachineProviderSpec, error) {
 
 	if arch == "ppc64le" || arch == "s390x" {
 		klog.Infof("Skipping update for %s, machinesets/controlplanemachinesets with arch %s are not supported for Azure", machineSetName, arch)
@@ -333,6 +351,11 @@ func reconcileAzureProviderSpec(streamData *stream.Stream, arch string, _ *oscon
 	newProviderSpec := providerSpec.DeepCopy()
 	newProviderSpec.Image = targetImage
 
+	// Ensure the new image can be used by this subscription before pointing the MachineSet at it
+	if err := verifyAzureBootImage(infra, providerSpec, targetImage, secretClient); err != nil {
+		return false, false, nil, err
+	}
+
 	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
 	if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
 		return false, false, nil, err
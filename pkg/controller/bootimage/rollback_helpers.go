@@ -0,0 +1,160 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// Annotation that freezes the boot image of a MAPI machineset. When set to "true", the
+	// controller leaves the providerSpec as is, and the machineset is reported as skipped.
+	BootImagePinnedAnnotationKey = "machineconfiguration.openshift.io/boot-image-pinned"
+
+	// Annotation recording the boot image replaced by the last boot image update, as a JSON
+	// patch to the providerSpec, e.g.
+	// [{"op":"test","path":"/ami/id","value":"ami-new"},{"op":"replace","path":"/ami/id","value":"ami-old"}]
+	PreviousBootImageAnnotationKey = "machineconfiguration.openshift.io/previous-boot-image"
+
+	// Annotation requesting a rollback to the previous boot image. When set to "true", the
+	// controller restores the recorded boot image and pins the machineset to it.
+	BootImageRollbackAnnotationKey = "machineconfiguration.openshift.io/boot-image-rollback"
+)
+
+// isBootImagePinned returns true if the machineset is pinned to its current boot image
+func isBootImagePinned(machineSet *machinev1beta1.MachineSet) bool {
+	return machineSet.GetAnnotations()[BootImagePinnedAnnotationKey] == "true"
+}
+
+// isBootImageRollbackRequested returns true if a rollback to the previous boot image was requested
+func isBootImageRollbackRequested(machineSet *machinev1beta1.MachineSet) bool {
+	return machineSet.GetAnnotations()[BootImageRollbackAnnotationKey] == "true"
+}
+
+// setPreviousBootImage records the boot image fields of the current provider spec on the new
+// machineset. The record is a JSON patch which replaces only the fields changed by the boot image
+// update, each guarded by a test of the value the update set. Lists are compared element by
+// element, so that other entries, such as the data disks next to a GCP boot disk, are left alone.
+func setPreviousBootImage(newMachineSet *machinev1beta1.MachineSet, providerSpec, newProviderSpec interface{}) error {
+	providerSpecMarshal, err := json.Marshal(providerSpec)
+	if err != nil {
+		return fmt.Errorf("unable to marshal current providerSpec: %w", err)
+	}
+	newProviderSpecMarshal, err := json.Marshal(newProviderSpec)
+	if err != nil {
+		return fmt.Errorf("unable to marshal new providerSpec: %w", err)
+	}
+	var current, updated interface{}
+	if err := json.Unmarshal(providerSpecMarshal, &current); err != nil {
+		return fmt.Errorf("unable to unmarshal current providerSpec: %w", err)
+	}
+	if err := json.Unmarshal(newProviderSpecMarshal, &updated); err != nil {
+		return fmt.Errorf("unable to unmarshal new providerSpec: %w", err)
+	}
+	operations := diffBootImageFields("", updated, current)
+	// Nothing to roll back to if the boot image was updated in place
+	if len(operations) == 0 {
+		return nil
+	}
+	previousBootImage, err := json.Marshal(operations)
+	if err != nil {
+		return fmt.Errorf("unable to marshal previous boot image: %w", err)
+	}
+	metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, PreviousBootImageAnnotationKey, string(previousBootImage))
+	return nil
+}
+
+// jsonPatchOperation is a single RFC 6902 JSON patch operation
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// diffBootImageFields returns the JSON patch operations which turn the updated document at the
+// given path back into the previous one. Objects, and lists of the same length, are descended
+// into, so that only the fields which differ are touched.
+func diffBootImageFields(path string, updated, previous interface{}) []jsonPatchOperation {
+	switch updatedValue := updated.(type) {
+	case map[string]interface{}:
+		previousValue, ok := previous.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := sets.KeySet(updatedValue).Union(sets.KeySet(previousValue))
+		operations := []jsonPatchOperation{}
+		for _, key := range sets.List(keys) {
+			fieldPath := path + "/" + jsonPointerEscaper.Replace(key)
+			updatedField, inUpdated := updatedValue[key]
+			previousField, inPrevious := previousValue[key]
+			switch {
+			case !inPrevious:
+				operations = append(operations,
+					jsonPatchOperation{Op: "test", Path: fieldPath, Value: updatedField},
+					jsonPatchOperation{Op: "remove", Path: fieldPath})
+			case !inUpdated:
+				operations = append(operations, jsonPatchOperation{Op: "add", Path: fieldPath, Value: previousField})
+			default:
+				operations = append(operations, diffBootImageFields(fieldPath, updatedField, previousField)...)
+			}
+		}
+		return operations
+	case []interface{}:
+		previousValue, ok := previous.([]interface{})
+		if !ok || len(previousValue) != len(updatedValue) {
+			break
+		}
+		operations := []jsonPatchOperation{}
+		for i := range updatedValue {
+			operations = append(operations, diffBootImageFields(fmt.Sprintf("%s/%d", path, i), updatedValue[i], previousValue[i])...)
+		}
+		return operations
+	}
+
+	if reflect.DeepEqual(updated, previous) {
+		return nil
+	}
+	return []jsonPatchOperation{
+		{Op: "test", Path: path, Value: updated},
+		{Op: "replace", Path: path, Value: previous},
+	}
+}
+
+// jsonPointerEscaper escapes an object key for use in a JSON pointer
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// rollbackMAPIMachineSet returns a copy of the machineset with the previously recorded boot image
+// restored. The rollback request and record are cleared, and the machineset is pinned, so that the
+// next sync does not update the boot image again.
+func rollbackMAPIMachineSet(machineSet *machinev1beta1.MachineSet) (*machinev1beta1.MachineSet, error) {
+	previousBootImage, ok := machineSet.GetAnnotations()[PreviousBootImageAnnotationKey]
+	if !ok || previousBootImage == "" {
+		return nil, fmt.Errorf("boot image rollback requested for machineset %s, but no previous boot image was recorded", machineSet.Name)
+	}
+	if machineSet.Spec.Template.Spec.ProviderSpec.Value == nil {
+		return nil, fmt.Errorf("unable to roll back boot image of machineset %s: providerSpec is empty", machineSet.Name)
+	}
+
+	patch, err := jsonpatch.DecodePatch([]byte(previousBootImage))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode previous boot image %s of machineset %s: %w", previousBootImage, machineSet.Name, err)
+	}
+	// The tests in the patch fail if the boot image was changed since it was recorded
+	rolledBackProviderSpec, err := patch.Apply(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply previous boot image %s to machineset %s: %w", previousBootImage, machineSet.Name, err)
+	}
+
+	newMachineSet := machineSet.DeepCopy()
+	newMachineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = rolledBackProviderSpec
+	delete(newMachineSet.Annotations, BootImageRollbackAnnotationKey)
+	delete(newMachineSet.Annotations, PreviousBootImageAnnotationKey)
+	metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, BootImagePinnedAnnotationKey, "true")
+	return newMachineSet, nil
+}
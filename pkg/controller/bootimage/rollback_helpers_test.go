@@ -0,0 +1,187 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	fakemachineclient "github.com/openshift/client-go/machine/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+func TestSetPreviousBootImageAndRollback(t *testing.T) {
+	providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+		AMI:          machinev1beta1.AWSResourceReference{ID: ptr.To("ami-old")},
+		InstanceType: "m6i.xlarge",
+	}
+	newProviderSpec := providerSpec.DeepCopy()
+	newProviderSpec.AMI.ID = ptr.To("ami-new")
+
+	newMachineSet := getAWSMachineSet(t, "machine-set-1", newProviderSpec, nil)
+	require.NoError(t, setPreviousBootImage(newMachineSet, providerSpec, newProviderSpec))
+
+	// Only the boot image fields are recorded
+	assert.JSONEq(t, `[{"op":"test","path":"/ami/id","value":"ami-new"},{"op":"replace","path":"/ami/id","value":"ami-old"}]`, newMachineSet.Annotations[PreviousBootImageAnnotationKey])
+
+	// Nothing is recorded when the provider spec did not change
+	unchangedMachineSet := getAWSMachineSet(t, "machine-set-2", providerSpec, nil)
+	require.NoError(t, setPreviousBootImage(unchangedMachineSet, providerSpec, providerSpec.DeepCopy()))
+	assert.NotContains(t, unchangedMachineSet.Annotations, PreviousBootImageAnnotationKey)
+
+	// The instance type was changed after the boot image update and must survive the rollback
+	newProviderSpec.InstanceType = "m6i.2xlarge"
+	require.NoError(t, marshalProviderSpec(newMachineSet, newProviderSpec))
+	v1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, BootImageRollbackAnnotationKey, "true")
+
+	rolledBack, err := rollbackMAPIMachineSet(newMachineSet)
+	require.NoError(t, err)
+
+	rolledBackProviderSpec := &machinev1beta1.AWSMachineProviderConfig{}
+	require.NoError(t, unmarshalProviderSpec(rolledBack, rolledBackProviderSpec))
+	assert.Equal(t, "ami-old", *rolledBackProviderSpec.AMI.ID)
+	assert.Equal(t, "m6i.2xlarge", rolledBackProviderSpec.InstanceType)
+
+	assert.True(t, isBootImagePinned(rolledBack))
+	assert.False(t, isBootImageRollbackRequested(rolledBack))
+	assert.NotContains(t, rolledBack.Annotations, PreviousBootImageAnnotationKey)
+
+	// A second rollback has nothing to go back to
+	v1.SetMetaDataAnnotation(&rolledBack.ObjectMeta, BootImageRollbackAnnotationKey, "true")
+	_, err = rollbackMAPIMachineSet(rolledBack)
+	assert.ErrorContains(t, err, "no previous boot image was recorded")
+}
+
+func TestRollbackOnlyRestoresBootImageFields(t *testing.T) {
+	providerSpec := &machinev1beta1.GCPMachineProviderSpec{
+		Disks: []*machinev1beta1.GCPDisk{
+			{Boot: true, Image: "projects/rhcos-cloud/global/images/rhcos-old", SizeGB: 128},
+		},
+	}
+	newProviderSpec := providerSpec.DeepCopy()
+	newProviderSpec.Disks[0].Image = "projects/rhcos-cloud/global/images/rhcos-new"
+
+	newMachineSet := getGCPMachineSet(t, "machine-set-1", newProviderSpec)
+	require.NoError(t, setPreviousBootImage(newMachineSet, providerSpec, newProviderSpec))
+	v1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, BootImageRollbackAnnotationKey, "true")
+
+	// A data disk added and a boot disk resized after the boot image update must survive the rollback
+	updatedProviderSpec := newProviderSpec.DeepCopy()
+	updatedProviderSpec.Disks[0].SizeGB = 256
+	updatedProviderSpec.Disks = append(updatedProviderSpec.Disks, &machinev1beta1.GCPDisk{Image: "data", SizeGB: 64})
+	updatedMachineSet := newMachineSet.DeepCopy()
+	require.NoError(t, marshalProviderSpec(updatedMachineSet, updatedProviderSpec))
+
+	rolledBack, err := rollbackMAPIMachineSet(updatedMachineSet)
+	require.NoError(t, err)
+
+	rolledBackProviderSpec := &machinev1beta1.GCPMachineProviderSpec{}
+	require.NoError(t, unmarshalProviderSpec(rolledBack, rolledBackProviderSpec))
+	require.Len(t, rolledBackProviderSpec.Disks, 2)
+	assert.Equal(t, "projects/rhcos-cloud/global/images/rhcos-old", rolledBackProviderSpec.Disks[0].Image)
+	assert.Equal(t, int64(256), rolledBackProviderSpec.Disks[0].SizeGB)
+	assert.Equal(t, "data", rolledBackProviderSpec.Disks[1].Image)
+
+	// A boot image which was changed since the update is not rolled back
+	changedProviderSpec := newProviderSpec.DeepCopy()
+	changedProviderSpec.Disks[0].Image = "projects/my-project/global/images/custom"
+	changedMachineSet := newMachineSet.DeepCopy()
+	require.NoError(t, marshalProviderSpec(changedMachineSet, changedProviderSpec))
+	_, err = rollbackMAPIMachineSet(changedMachineSet)
+	assert.Error(t, err)
+}
+
+func TestSyncMAPIMachineSetPinnedAndRollback(t *testing.T) {
+	providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+		AMI: machinev1beta1.AWSResourceReference{ID: ptr.To("ami-new")},
+	}
+
+	pinned := getAWSMachineSet(t, "pinned", providerSpec, map[string]string{
+		BootImagePinnedAnnotationKey: "true",
+	})
+	rollback := getAWSMachineSet(t, "rollback", providerSpec, map[string]string{
+		BootImageRollbackAnnotationKey: "true",
+		PreviousBootImageAnnotationKey: `[{"op":"test","path":"/ami/id","value":"ami-new"},{"op":"replace","path":"/ami/id","value":"ami-old"}]`,
+	})
+	rollbackWithoutRecord := getAWSMachineSet(t, "rollback-without-record", providerSpec, map[string]string{
+		BootImageRollbackAnnotationKey: "true",
+	})
+
+	machineClient := fakemachineclient.NewSimpleClientset(pinned, rollback, rollbackWithoutRecord)
+	ctrl := &Controller{
+		machineClient:      machineClient,
+		mapiBootImageState: map[string]BootImageState{},
+	}
+
+	patchSkipped, err := ctrl.syncMAPIMachineSet(pinned)
+	require.NoError(t, err)
+	assert.True(t, patchSkipped)
+
+	patchSkipped, err = ctrl.syncMAPIMachineSet(rollback)
+	require.NoError(t, err)
+	assert.True(t, patchSkipped)
+
+	updated, err := machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Get(context.TODO(), "rollback", v1.GetOptions{})
+	require.NoError(t, err)
+	updatedProviderSpec := &machinev1beta1.AWSMachineProviderConfig{}
+	require.NoError(t, unmarshalProviderSpec(updated, updatedProviderSpec))
+	assert.Equal(t, "ami-old", *updatedProviderSpec.AMI.ID)
+	assert.Equal(t, map[string]string{BootImagePinnedAnnotationKey: "true"}, updated.Annotations)
+
+	_, err = ctrl.syncMAPIMachineSet(rollbackWithoutRecord)
+	assert.ErrorContains(t, err, "no previous boot image was recorded")
+
+	// Only the machineset being rolled back is patched
+	for _, action := range machineClient.Actions() {
+		if patchAction, ok := action.(k8stesting.PatchAction); ok {
+			assert.Equal(t, "rollback", patchAction.GetName())
+		}
+	}
+}
+
+// Returns an AWS machineset with the given provider spec and annotations
+func getAWSMachineSet(t *testing.T, name string, providerSpec *machinev1beta1.AWSMachineProviderConfig, annotations map[string]string) *machinev1beta1.MachineSet {
+	raw, err := json.Marshal(providerSpec)
+	require.NoError(t, err)
+	return &machinev1beta1.MachineSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:        name,
+			Namespace:   MachineAPINamespace,
+			Annotations: annotations,
+		},
+		Spec: machinev1beta1.MachineSetSpec{
+			Template: machinev1beta1.MachineTemplateSpec{
+				Spec: machinev1beta1.MachineSpec{
+					ProviderSpec: machinev1beta1.ProviderSpec{
+						Value: &runtime.RawExtension{Raw: raw},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getGCPMachineSet(t *testing.T, name string, providerSpec *machinev1beta1.GCPMachineProviderSpec) *machinev1beta1.MachineSet {
+	raw, err := json.Marshal(providerSpec)
+	require.NoError(t, err)
+	return &machinev1beta1.MachineSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: MachineAPINamespace,
+		},
+		Spec: machinev1beta1.MachineSetSpec{
+			Template: machinev1beta1.MachineTemplateSpec{
+				Spec: machinev1beta1.MachineSpec{
+					ProviderSpec: machinev1beta1.ProviderSpec{
+						Value: &runtime.RawExtension{Raw: raw},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,341 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// buildQueue limits how many MachineOSBuilds may build at once. It keeps track
+// of the MachineOSBuilds it has let out of the queue since they may not have a
+// build Job or a transient state yet.
+type buildQueue struct {
+	mu       sync.Mutex
+	admitted sets.Set[string]
+}
+
+func newBuildQueue() *buildQueue {
+	return &buildQueue{
+		admitted: sets.New[string](),
+	}
+}
+
+// getMaxConcurrentBuilds parses the maximum number of concurrent builds from
+// the global build configuration ConfigMap. Zero means that builds are not
+// limited.
+func getMaxConcurrentBuilds(cm *corev1.ConfigMap) (int, error) {
+	if cm == nil {
+		return 0, nil
+	}
+
+	value, ok := cm.Data[constants.MaxConcurrentBuildsConfigKey]
+	if !ok {
+		return 0, nil
+	}
+
+	maxBuilds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q in ConfigMap %q: %w", constants.MaxConcurrentBuildsConfigKey, value, cm.Name, err)
+	}
+
+	if maxBuilds < 0 {
+		return 0, fmt.Errorf("invalid %s %q in ConfigMap %q: must not be negative", constants.MaxConcurrentBuildsConfigKey, value, cm.Name)
+	}
+
+	return maxBuilds, nil
+}
+
+// getBuildPriority parses the build priority from the MachineOSConfig.
+// Defaults to zero.
+func getBuildPriority(mosc *mcfgv1.MachineOSConfig) (int, error) {
+	value, ok := mosc.GetAnnotations()[constants.BuildPriorityAnnotationKey]
+	if !ok {
+		return 0, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q for MachineOSConfig %s: %w", constants.BuildPriorityAnnotationKey, value, mosc.Name, err)
+	}
+
+	return priority, nil
+}
+
+// orderBuildQueue sorts the waiting MachineOSBuilds into the order they leave
+// the queue in: highest priority first, then oldest first. The name breaks any
+// remaining ties so that the order is stable.
+func orderBuildQueue(waiting []*mcfgv1.MachineOSBuild, priorities map[string]int) []*mcfgv1.MachineOSBuild {
+	out := append([]*mcfgv1.MachineOSBuild{}, waiting...)
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if priorities[out[i].Name] != priorities[out[j].Name] {
+			return priorities[out[i].Name] > priorities[out[j].Name]
+		}
+
+		if !out[i].CreationTimestamp.Equal(&out[j].CreationTimestamp) {
+			return out[i].CreationTimestamp.Before(&out[j].CreationTimestamp)
+		}
+
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// Fetches the global build configuration ConfigMap. Returns nil if it does not
+// exist. This is not an ephemeral build object, so it is not in the lister.
+func (b *buildReconciler) getBuildConfigConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+	cm, err := b.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, constants.BuildConfigConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not get ConfigMap %q: %w", constants.BuildConfigConfigMapName, err)
+	}
+
+	return cm, nil
+}
+
+// Determines whether a MachineOSBuild is currently building. This includes
+// MachineOSBuilds which were let out of the queue whose build has not yet
+// reported a transient state.
+func (b *buildReconciler) isMachineOSBuildRunning(mosb *mcfgv1.MachineOSBuild) (bool, error) {
+	mosbState := ctrlcommon.NewMachineOSBuildState(mosb)
+
+	if mosbState.IsInTerminalState() {
+		return false, nil
+	}
+
+	if mosbState.IsInTransientState() || b.buildQueue.admitted.Has(mosb.Name) {
+		return true, nil
+	}
+
+	_, err := b.jobLister.Jobs(ctrlcommon.MCONamespace).Get(utils.GetBuildJobName(mosb))
+	if err == nil {
+		return true, nil
+	}
+
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Determines whether a MachineOSBuild is waiting to build. This mirrors the
+// checks syncMachineOSBuild performs before starting a build.
+func (b *buildReconciler) isMachineOSBuildWaiting(mosb *mcfgv1.MachineOSBuild) (bool, *mcfgv1.MachineOSConfig, error) {
+	if mosb.Labels[constants.PreBuiltImageLabelKey] == constants.TrueValue {
+		return false, nil, nil
+	}
+
+	mosbState := ctrlcommon.NewMachineOSBuildState(mosb)
+	if mosbState.IsInTerminalState() || mosbState.IsInTransientState() {
+		return false, nil, nil
+	}
+
+	mcp, err := b.machineConfigPoolLister.Get(mosb.Labels[constants.TargetMachineConfigPoolLabelKey])
+	if err != nil {
+		return false, nil, ignoreErrIsNotFound(err)
+	}
+
+	if mosb.Labels[constants.RenderedMachineConfigLabelKey] != mcp.Spec.Configuration.Name {
+		return false, nil, nil
+	}
+
+	mosc, err := utils.GetMachineOSConfigForMachineOSBuild(mosb, b.utilListers())
+	if err != nil {
+		return false, nil, ignoreErrIsNotFound(err)
+	}
+
+	if isPreBuiltImageAwaitingSeeding(mosc) {
+		return false, nil, nil
+	}
+
+	return true, mosc, nil
+}
+
+// Gets the names of the MachineOSBuilds which are currently building and the
+// MachineOSBuilds which are waiting to build in the order they leave the queue.
+func (b *buildReconciler) getBuildQueue() (sets.Set[string], []*mcfgv1.MachineOSBuild, error) {
+	mosbs, err := b.machineOSBuildLister.List(labels.Everything())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not list MachineOSBuilds: %w", err)
+	}
+
+	running := sets.New[string]()
+	waiting := []*mcfgv1.MachineOSBuild{}
+	priorities := map[string]int{}
+
+	for _, mosb := range mosbs {
+		isRunning, err := b.isMachineOSBuildRunning(mosb)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not determine if MachineOSBuild %q is running: %w", mosb.Name, err)
+		}
+
+		if isRunning {
+			running.Insert(mosb.Name)
+			continue
+		}
+
+		isWaiting, mosc, err := b.isMachineOSBuildWaiting(mosb)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not determine if MachineOSBuild %q is waiting: %w", mosb.Name, err)
+		}
+
+		if !isWaiting {
+			continue
+		}
+
+		priority, err := getBuildPriority(mosc)
+		if err != nil {
+			klog.Warningf("Using default build priority for MachineOSBuild %q: %s", mosb.Name, err)
+		}
+
+		priorities[mosb.Name] = priority
+		waiting = append(waiting, mosb)
+	}
+
+	return running, orderBuildQueue(waiting, priorities), nil
+}
+
+// Determines whether the given MachineOSBuild may start building. When the
+// maximum number of concurrent builds is reached, it stays in the queue and
+// its Queued condition reports its position. Whenever a build finishes, every
+// MachineOSBuild is synced again so that the next one in the queue can start.
+func (b *buildReconciler) admitBuild(ctx context.Context, mosb *mcfgv1.MachineOSBuild) (bool, error) {
+	cm, err := b.getBuildConfigConfigMap(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	maxBuilds, err := getMaxConcurrentBuilds(cm)
+	if err != nil {
+		return false, err
+	}
+
+	b.buildQueue.mu.Lock()
+	defer b.buildQueue.mu.Unlock()
+
+	// Builds are not limited, so only MachineOSBuilds which were queued before
+	// the limit was removed need their Queued condition cleared.
+	if maxBuilds == 0 {
+		if apihelpers.GetMachineOSBuildCondition(mosb.Status, constants.MachineOSBuildQueued) == nil {
+			return true, nil
+		}
+
+		return true, b.dequeueMachineOSBuild(ctx, mosb)
+	}
+
+	running, waiting, err := b.getBuildQueue()
+	if err != nil {
+		return false, err
+	}
+
+	// Forget the MachineOSBuilds which have since finished or been deleted.
+	b.buildQueue.admitted = b.buildQueue.admitted.Intersection(running)
+
+	if running.Has(mosb.Name) {
+		return true, nil
+	}
+
+	position := len(waiting)
+	for i, waitingMosb := range waiting {
+		if waitingMosb.Name == mosb.Name {
+			position = i
+			break
+		}
+	}
+
+	if position == len(waiting) {
+		waiting = append(waiting, mosb)
+	}
+
+	if running.Len()+position < maxBuilds {
+		klog.Infof("MachineOSBuild %q left the build queue (%d of %d builds running)", mosb.Name, running.Len(), maxBuilds)
+		b.buildQueue.admitted.Insert(mosb.Name)
+		return true, b.dequeueMachineOSBuild(ctx, mosb)
+	}
+
+	msg := fmt.Sprintf("Build is at position %d of %d in the build queue; %d of %d builds running", position+1, len(waiting), running.Len(), maxBuilds)
+	klog.V(4).Infof("MachineOSBuild %q: %s", mosb.Name, msg)
+
+	cond := apihelpers.NewMachineOSBuildCondition(constants.MachineOSBuildQueued, metav1.ConditionTrue, constants.ReasonBuildQueued, msg)
+	return false, b.setQueuedCondition(ctx, mosb, cond)
+}
+
+// Returns a MachineOSBuild's place among the running builds to the queue, such
+// as when its build could not be started.
+func (b *buildReconciler) releaseBuild(mosb *mcfgv1.MachineOSBuild) {
+	b.buildQueue.mu.Lock()
+	defer b.buildQueue.mu.Unlock()
+
+	b.buildQueue.admitted.Delete(mosb.Name)
+}
+
+// Clears the Queued condition on a MachineOSBuild which was in the queue.
+func (b *buildReconciler) dequeueMachineOSBuild(ctx context.Context, mosb *mcfgv1.MachineOSBuild) error {
+	cond := apihelpers.NewMachineOSBuildCondition(constants.MachineOSBuildQueued, metav1.ConditionFalse, constants.ReasonBuildDequeued, "Build left the build queue")
+	return b.setQueuedCondition(ctx, mosb, cond)
+}
+
+// Sets the Queued condition on a MachineOSBuild unless it is already set. The
+// condition is only set to false on MachineOSBuilds which were in the queue.
+func (b *buildReconciler) setQueuedCondition(ctx context.Context, mosb *mcfgv1.MachineOSBuild, cond *metav1.Condition) error {
+	isUpToDate := func(status mcfgv1.MachineOSBuildStatus) bool {
+		current := apihelpers.GetMachineOSBuildCondition(status, constants.MachineOSBuildQueued)
+		if current == nil {
+			return cond.Status == metav1.ConditionFalse
+		}
+
+		if cond.Status == metav1.ConditionFalse {
+			return current.Status == metav1.ConditionFalse
+		}
+
+		return current.Status == cond.Status && current.Reason == cond.Reason && current.Message == cond.Message
+	}
+
+	// The lister may be stale, so it can only be trusted to skip updating the
+	// position of a MachineOSBuild which is still queued.
+	if cond.Status == metav1.ConditionTrue && isUpToDate(mosb.Status) {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		apiMosb, err := b.mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if isUpToDate(apiMosb.Status) {
+			return nil
+		}
+
+		apihelpers.SetMachineOSBuildCondition(&apiMosb.Status, *cond)
+
+		_, err = b.mcfgclient.MachineconfigurationV1().MachineOSBuilds().UpdateStatus(ctx, apiMosb, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not set %s condition on MachineOSBuild %q: %w", constants.MachineOSBuildQueued, mosb.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package build
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/buildrequest"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestGetMaxConcurrentBuilds(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		noConfigMap bool
+		expected    int
+		errExpected bool
+	}{
+		{
+			name:        "No ConfigMap",
+			noConfigMap: true,
+		},
+		{
+			name: "No limit set",
+			data: map[string]string{constants.BuildPodTolerationsConfigKey: "[]"},
+		},
+		{
+			name:     "Limit set",
+			data:     map[string]string{constants.MaxConcurrentBuildsConfigKey: "2"},
+			expected: 2,
+		},
+		{
+			name:        "Non-integer limit",
+			data:        map[string]string{constants.MaxConcurrentBuildsConfigKey: "two"},
+			errExpected: true,
+		},
+		{
+			name:        "Negative limit",
+			data:        map[string]string{constants.MaxConcurrentBuildsConfigKey: "-1"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var cm *corev1.ConfigMap
+			if !testCase.noConfigMap {
+				cm = &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: constants.BuildConfigConfigMapName,
+					},
+					Data: testCase.data,
+				}
+			}
+
+			maxBuilds, err := getMaxConcurrentBuilds(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, maxBuilds)
+		})
+	}
+}
+
+func TestOrderBuildQueue(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	newMosb := func(name string, created time.Time) *mcfgv1.MachineOSBuild {
+		return &mcfgv1.MachineOSBuild{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(created),
+			},
+		}
+	}
+
+	waiting := []*mcfgv1.MachineOSBuild{
+		newMosb("newest", now),
+		newMosb("oldest", now.Add(-time.Hour)),
+		newMosb("urgent", now),
+		newMosb("b-tied", now.Add(-time.Minute)),
+		newMosb("a-tied", now.Add(-time.Minute)),
+		newMosb("deferred", now.Add(-time.Hour*2)),
+	}
+
+	priorities := map[string]int{
+		"urgent":   10,
+		"deferred": -1,
+	}
+
+	ordered := orderBuildQueue(waiting, priorities)
+
+	assert.Equal(t, []string{"urgent", "oldest", "a-tied", "b-tied", "newest", "deferred"}, getMachineOSBuildNames(ordered))
+	// The input is not modified.
+	assert.Equal(t, "newest", waiting[0].Name)
+}
+
+func TestBuildQueue(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      constants.BuildConfigConfigMapName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string]string{
+			constants.MaxConcurrentBuildsConfigKey: "1",
+		},
+	}
+
+	infraObjects := fixtures.NewObjectsForTest("infra")
+
+	kubeclient, mcfgclient, imageclient, routeclient, lobj, kubeassert := fixtures.GetClientsForTestWithAdditionalObjects(t, []runtime.Object{cm}, infraObjects.ToRuntimeObjects())
+	startController(ctx, t, kubeclient, mcfgclient, imageclient, routeclient)
+	kubeassert = kubeassert.Eventually().WithContext(ctx).WithPollInterval(time.Millisecond)
+
+	// Creates the MachineOSConfig and returns the MachineOSBuild it should get.
+	createMachineOSConfig := func(objs fixtures.ObjectsForTest) *mcfgv1.MachineOSBuild {
+		t.Helper()
+
+		mosc := objs.MachineOSConfig
+		mosc.Name = objs.MachineConfigPool.Name + "-os-config"
+
+		_, err := mcfgclient.MachineconfigurationV1().MachineOSConfigs().Create(ctx, mosc, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		mosb := buildrequest.NewMachineOSBuildOrDie(buildrequest.MachineOSBuildOpts{
+			MachineConfig:     objs.RenderedMachineConfig,
+			MachineOSConfig:   mosc,
+			MachineConfigPool: objs.MachineConfigPool,
+		})
+
+		kubeassert.MachineOSBuildExists(mosb)
+		return mosb
+	}
+
+	// Waits for the Queued condition on the MachineOSBuild to match.
+	waitForQueuedCondition := func(mosb *mcfgv1.MachineOSBuild, status metav1.ConditionStatus) *metav1.Condition {
+		t.Helper()
+
+		var cond *metav1.Condition
+		err := wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(ctx context.Context) (bool, error) {
+			apiMosb, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+
+			cond = apihelpers.GetMachineOSBuildCondition(apiMosb.Status, constants.MachineOSBuildQueued)
+			return cond != nil && cond.Status == status, nil
+		})
+		require.NoError(t, err, "Queued condition on MachineOSBuild %s never became %s", mosb.Name, status)
+
+		return cond
+	}
+
+	workerMosb := createMachineOSConfig(*lobj)
+	kubeassert.JobExists(utils.GetBuildJobName(workerMosb))
+
+	fixtures.SetJobStatus(ctx, t, kubeclient, workerMosb, fixtures.JobStatus{Active: 1})
+	kubeassert.MachineOSBuildIsRunning(workerMosb)
+
+	// With one build running, the infra build waits in the queue.
+	infraMosb := createMachineOSConfig(infraObjects)
+
+	cond := waitForQueuedCondition(infraMosb, metav1.ConditionTrue)
+	assert.Equal(t, constants.ReasonBuildQueued, cond.Reason)
+	assert.Equal(t, "Build is at position 1 of 1 in the build queue; 1 of 1 builds running", cond.Message)
+
+	kubeassert.Now().JobDoesNotExist(utils.GetBuildJobName(infraMosb))
+
+	// Once the running build finishes, the infra build leaves the queue.
+	fixtures.SetJobStatus(ctx, t, kubeclient, workerMosb, fixtures.JobStatus{Succeeded: 1})
+	kubeassert.MachineOSBuildIsSuccessful(workerMosb)
+
+	kubeassert.JobExists(utils.GetBuildJobName(infraMosb))
+	cond = waitForQueuedCondition(infraMosb, metav1.ConditionFalse)
+	assert.Equal(t, constants.ReasonBuildDequeued, cond.Reason)
+
+	fixtures.SetJobStatus(ctx, t, kubeclient, infraMosb, fixtures.JobStatus{Succeeded: 1})
+	kubeassert.MachineOSBuildIsSuccessful(infraMosb)
+
+	// The worker build was never queued.
+	apiWorkerMosb, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, workerMosb.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, apihelpers.GetMachineOSBuildCondition(apiWorkerMosb.Status, constants.MachineOSBuildQueued))
+}
@@ -26,6 +26,10 @@ ETC_PKI_ENTITLEMENT_MOUNTPOINT="${ETC_PKI_ENTITLEMENT_MOUNTPOINT:-}"
 ETC_PKI_RPM_GPG_MOUNTPOINT="${ETC_PKI_RPM_GPG_MOUNTPOINT:-}"
 ETC_YUM_REPOS_D_MOUNTPOINT="${ETC_YUM_REPOS_D_MOUNTPOINT:-}"
 MAX_RETRIES="${MAX_RETRIES:-3}"
+BUILD_CACHE_LAYERS="${BUILD_CACHE_LAYERS:-}"
+BUILD_CACHE_REPO="${BUILD_CACHE_REPO:-}"
+SBOM_FORMAT="${SBOM_FORMAT:-}"
+IMAGE_SIGNING_KEY_MOUNTPOINT="${IMAGE_SIGNING_KEY_MOUNTPOINT:-}"
 
 export HTTP_PROXY="${HTTP_PROXY:-}"
 export HTTPS_PROXY="${HTTPS_PROXY:-}"
@@ -89,13 +93,85 @@ if [[ -n "$ETC_PKI_RPM_GPG_MOUNTPOINT" ]] && [[ -d "$ETC_PKI_RPM_GPG_MOUNTPOINT"
 	build_args+=("--volume=$configs:$ETC_PKI_RPM_GPG_MOUNTPOINT:$mount_opts")
 fi
 
-# Build our image.
-buildah bud "${build_args[@]}" "$build_context"
+# If we have a build cache, keep the intermediate layers so that subsequent
+# builds can reuse them. When a cache repository is configured, also push
+# them there and pull them from there.
+if [[ -n "$BUILD_CACHE_LAYERS" ]]; then
+	build_args+=("--layers")
+fi
+
+if [[ -n "$BUILD_CACHE_REPO" ]]; then
+	build_args+=("--cache-from=$BUILD_CACHE_REPO" "--cache-to=$BUILD_CACHE_REPO")
+fi
+
+# Build our image, keeping a copy of the output so that we can count cache hits.
+build_log="$(mktemp)"
+set -o pipefail
+buildah bud "${build_args[@]}" "$build_context" 2>&1 | tee "$build_log"
+
+# Record how many build steps were served from the cache so that the Build
+# Controller can report it on the MachineOSBuild.
+if [[ -n "$BUILD_CACHE_LAYERS" ]]; then
+	grep -c -- '--> Using cache' "$build_log" > /tmp/done/cache-hits || true
+	grep -cE 'STEP [0-9]+/[0-9]+:' "$build_log" > /tmp/done/cache-steps || true
+fi
+
+# If an SBOM was requested, list the RPMs installed in the built image and
+# render them as an SPDX or CycloneDX document. The SBOM is attached to the
+# image once it has been pushed.
+if [[ -n "$SBOM_FORMAT" ]]; then
+	sbom_ctr="$(buildah --storage-driver vfs from --pull-never "$TAG")"
+	buildah --storage-driver vfs run "$sbom_ctr" -- \
+		rpm -qa --qf '%{NAME}\t%{EPOCH}\t%{VERSION}\t%{RELEASE}\t%{ARCH}\t%{LICENSE}\n' | sort > /tmp/done/rpms.tsv
+	buildah --storage-driver vfs rm "$sbom_ctr"
+
+	awk -F '\t' \
+		-v format="$SBOM_FORMAT" \
+		-v image="$TAG" \
+		-v created="$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+		-v uuid="$(cat /proc/sys/kernel/random/uuid)" '
+		function esc(s) { gsub(/\\/, "\\\\", s); gsub(/"/, "\\\"", s); return s }
+		{
+			evr = $3 "-" $4
+			purl = "pkg:rpm/" $1 "@" evr "?arch=" $5
+			if ($2 != "(none)") { purl = purl "&epoch=" $2 }
+			if (format == "spdx") {
+				pkgs[NR] = sprintf("{\"name\":\"%s\",\"SPDXID\":\"SPDXRef-Package-%d\",\"versionInfo\":\"%s\",\"downloadLocation\":\"NOASSERTION\",\"licenseConcluded\":\"NOASSERTION\",\"licenseDeclared\":\"NOASSERTION\",\"licenseComments\":\"%s\",\"externalRefs\":[{\"referenceCategory\":\"PACKAGE-MANAGER\",\"referenceType\":\"purl\",\"referenceLocator\":\"%s\"}]}", esc($1), NR, esc(evr), esc($6), esc(purl))
+			} else {
+				pkgs[NR] = sprintf("{\"type\":\"library\",\"name\":\"%s\",\"version\":\"%s\",\"purl\":\"%s\",\"licenses\":[{\"license\":{\"name\":\"%s\"}}]}", esc($1), esc(evr), esc(purl), esc($6))
+			}
+		}
+		END {
+			if (format == "spdx") {
+				printf "{\"spdxVersion\":\"SPDX-2.3\",\"dataLicense\":\"CC0-1.0\",\"SPDXID\":\"SPDXRef-DOCUMENT\",\"name\":\"%s\",\"documentNamespace\":\"https://openshift.io/spdx/%s\",\"creationInfo\":{\"created\":\"%s\",\"creators\":[\"Tool: machine-config-operator\"]},\"packages\":[", esc(image), uuid, created
+			} else {
+				printf "{\"bomFormat\":\"CycloneDX\",\"specVersion\":\"1.5\",\"serialNumber\":\"urn:uuid:%s\",\"version\":1,\"metadata\":{\"timestamp\":\"%s\",\"component\":{\"type\":\"container\",\"name\":\"%s\"}},\"components\":[", uuid, created, esc(image)
+			}
+			for (i = 1; i <= NR; i++) { printf "%s%s", (i > 1 ? "," : ""), pkgs[i] }
+			printf "]}\n"
+		}' /tmp/done/rpms.tsv > /tmp/done/sbom.json
+fi
+
+push_args=(
+	--storage-driver vfs
+	--authfile="$FINAL_IMAGE_PUSH_CREDS"
+	--digestfile="/tmp/done/digestfile"
+	--cert-dir /var/run/secrets/kubernetes.io/serviceaccount
+)
+
+# If we have an image signing key, sign the image as we push it. The sigstore
+# signature is stored alongside the image as a cosign-compatible attachment.
+if [[ -n "$IMAGE_SIGNING_KEY_MOUNTPOINT" ]] && [[ -d "$IMAGE_SIGNING_KEY_MOUNTPOINT" ]]; then
+	mkdir -p "$HOME/.config/containers/registries.d"
+	printf 'default-docker:\n  use-sigstore-attachments: true\n' > "$HOME/.config/containers/registries.d/sigstore-attachments.yaml"
+
+	push_args+=("--sign-by-sigstore-private-key=$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.key")
+
+	if [[ -f "$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.password" ]]; then
+		push_args+=("--sign-passphrase-file=$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.password")
+	fi
+fi
 
 # Push our built image.
-buildah push \
-	--storage-driver vfs \
-	--authfile="$FINAL_IMAGE_PUSH_CREDS" \
-	--digestfile="/tmp/done/digestfile" \
-	--cert-dir /var/run/secrets/kubernetes.io/serviceaccount "$TAG"
+buildah push "${push_args[@]}" "$TAG"
 EOF
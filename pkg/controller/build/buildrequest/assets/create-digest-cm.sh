@@ -8,11 +8,33 @@ set -xeuo
 
 # Inject the contents of the digestfile into a ConfigMap.
 
+# Include the build cache statistics, if the build recorded them.
+extra_args=()
+if [[ -f /tmp/done/cache-hits ]] && [[ -f /tmp/done/cache-steps ]]; then
+    extra_args+=(--from-file=cacheHits=/tmp/done/cache-hits --from-file=cacheSteps=/tmp/done/cache-steps)
+fi
+
+# Include the digests of the SBOM and signature attached to the image, if any.
+if [[ -f /tmp/done/sbom-digest ]]; then
+    extra_args+=(--from-file=sbomDigest=/tmp/done/sbom-digest)
+fi
+
+if [[ -f /tmp/done/signature-digest ]]; then
+    extra_args+=(--from-file=signatureDigest=/tmp/done/signature-digest)
+fi
+
+# Record that the built image passed validation, since this container only
+# runs once every init container has succeeded.
+if [[ -n "${VALIDATION_ENABLED:-}" ]]; then
+    extra_args+=(--from-literal=validated=true)
+fi
+
 # Create and label the digestfile ConfigMap
 if ! oc create configmap \
     "$DIGEST_CONFIGMAP_NAME" \
     --namespace openshift-machine-config-operator \
     --from-file=digest=/tmp/done/digestfile \
+    "${extra_args[@]}" \
     --dry-run=client -o yaml | \
     oc label --local -f - $DIGEST_CONFIGMAP_LABELS -o yaml | \
     oc apply -f -; then
@@ -0,0 +1,61 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# custom build pod.
+set -xeuo
+
+DEST=/etc/pki/ca-trust/extracted
+
+# Prevent p11-kit from reading user configuration files.
+export P11_KIT_NO_USER_CONFIG=1
+
+# OpenSSL PEM bundle that includes trust flags
+/usr/bin/p11-kit extract --format=openssl-bundle --filter=certificates --overwrite --comment $DEST/openssl/ca-bundle.trust.crt
+/usr/bin/p11-kit extract --format=pem-bundle --filter=ca-anchors --overwrite --comment --purpose server-auth $DEST/pem/tls-ca-bundle.pem
+
+su -m build << 'EOF'
+set -xeuo
+
+IMAGE_SIGNING_KEY_MOUNTPOINT="${IMAGE_SIGNING_KEY_MOUNTPOINT:-}"
+
+export HTTP_PROXY="${HTTP_PROXY:-}"
+export HTTPS_PROXY="${HTTPS_PROXY:-}"
+export NO_PROXY="${NO_PROXY:-}"
+
+# Create a manifest list referencing the image built for each architecture.
+buildah --storage-driver vfs manifest create "$TAG"
+
+for pullspec in $ARCHITECTURE_IMAGE_PULLSPECS; do
+	buildah --storage-driver vfs manifest add \
+		--authfile="$FINAL_IMAGE_PUSH_CREDS" \
+		--cert-dir /var/run/secrets/kubernetes.io/serviceaccount \
+		"$TAG" "docker://$pullspec"
+done
+
+# The per-architecture images were already pushed to the same repository, so
+# only the manifest list itself needs to be pushed.
+push_args=(
+	--storage-driver vfs
+	--all=false
+	--authfile="$FINAL_IMAGE_PUSH_CREDS"
+	--digestfile="/tmp/done/digestfile"
+	--cert-dir /var/run/secrets/kubernetes.io/serviceaccount
+)
+
+# If we have an image signing key, sign the manifest list as we push it. Each
+# per-architecture image was already signed by its own build.
+if [[ -n "$IMAGE_SIGNING_KEY_MOUNTPOINT" ]] && [[ -d "$IMAGE_SIGNING_KEY_MOUNTPOINT" ]]; then
+	mkdir -p "$HOME/.config/containers/registries.d"
+	printf 'default-docker:\n  use-sigstore-attachments: true\n' > "$HOME/.config/containers/registries.d/sigstore-attachments.yaml"
+
+	push_args+=("--sign-by-sigstore-private-key=$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.key")
+
+	if [[ -f "$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.password" ]]; then
+		push_args+=("--sign-passphrase-file=$IMAGE_SIGNING_KEY_MOUNTPOINT/cosign.password")
+	fi
+fi
+
+# Push our manifest list.
+buildah manifest push "${push_args[@]}" "$TAG" "docker://$TAG"
+EOF
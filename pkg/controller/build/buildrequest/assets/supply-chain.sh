@@ -0,0 +1,77 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# custom build pod.
+
+set -xeuo pipefail
+
+SBOM_FORMAT="${SBOM_FORMAT:-}"
+IMAGE_SIGNING_KEY_MOUNTPOINT="${IMAGE_SIGNING_KEY_MOUNTPOINT:-}"
+
+skopeo_args=(
+    --authfile="$FINAL_IMAGE_PUSH_CREDS"
+    --cert-dir=/var/run/secrets/kubernetes.io/serviceaccount
+)
+
+image_digest="$(cat /tmp/done/digestfile)"
+
+# Strip the tag from the pushspec to get the repository. A colon in the last
+# path component is a tag separator, any other colon is a registry port.
+repo="${TAG%@*}"
+if [[ "${repo##*/}" == *:* ]]; then
+    repo="${repo%:*}"
+fi
+
+# Artifacts are tagged using the cosign tag scheme, e.g. sha256-<hex>.sbom, so
+# that registries without the OCI referrers API can still find them.
+attachment_tag="${image_digest/:/-}"
+
+# Writes a file into the OCI layout as a blob and prints its digest.
+put_blob() {
+    local sum
+    sum="$(sha256sum "$1" | cut -d' ' -f1)"
+    cp "$1" "$layout/blobs/sha256/$sum"
+    echo "$sum"
+}
+
+# Attach the SBOM as an OCI artifact whose subject is the built image.
+if [[ -n "$SBOM_FORMAT" ]] && [[ -f /tmp/done/sbom.json ]]; then
+    case "$SBOM_FORMAT" in
+    spdx) sbom_media_type="application/spdx+json" ;;
+    cyclonedx) sbom_media_type="application/vnd.cyclonedx+json" ;;
+    *) echo "Unknown SBOM format $SBOM_FORMAT"; exit 1 ;;
+    esac
+
+    work="$(mktemp -d)"
+    layout="$(mktemp -d)"
+    mkdir -p "$layout/blobs/sha256"
+    echo '{"imageLayoutVersion":"1.0.0"}' > "$layout/oci-layout"
+
+    printf '{}' > "$work/empty.json"
+    config_sum="$(put_blob "$work/empty.json")"
+    sbom_sum="$(put_blob /tmp/done/sbom.json)"
+
+    subject_size="$(skopeo inspect "${skopeo_args[@]}" --raw "docker://$repo@$image_digest" | wc -c)"
+
+    printf '{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"%s","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:%s","size":2},"layers":[{"mediaType":"%s","digest":"sha256:%s","size":%d}],"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"%s","size":%d}}' \
+        "$sbom_media_type" "$config_sum" "$sbom_media_type" "$sbom_sum" "$(stat -c %s /tmp/done/sbom.json)" "$image_digest" "$subject_size" > "$work/manifest.json"
+    manifest_sum="$(put_blob "$work/manifest.json")"
+
+    printf '{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:%s","size":%d,"annotations":{"org.opencontainers.image.ref.name":"sbom"}}]}' \
+        "$manifest_sum" "$(stat -c %s "$work/manifest.json")" > "$layout/index.json"
+
+    skopeo copy \
+        --dest-authfile="$FINAL_IMAGE_PUSH_CREDS" \
+        --dest-cert-dir=/var/run/secrets/kubernetes.io/serviceaccount \
+        --preserve-digests \
+        "oci:$layout:sbom" "docker://$repo:$attachment_tag.sbom"
+
+    echo "sha256:$manifest_sum" > /tmp/done/sbom-digest
+fi
+
+# Record the digest of the sigstore signature Buildah attached while pushing.
+if [[ -n "$IMAGE_SIGNING_KEY_MOUNTPOINT" ]]; then
+    signature_sum="$(skopeo inspect "${skopeo_args[@]}" --raw "docker://$repo:$attachment_tag.sig" | sha256sum | cut -d' ' -f1)"
+    echo "sha256:$signature_sum" > /tmp/done/signature-digest
+fi
@@ -0,0 +1,88 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# custom build pod.
+set -xeuo
+
+DEST=/etc/pki/ca-trust/extracted
+
+# Prevent p11-kit from reading user configuration files.
+export P11_KIT_NO_USER_CONFIG=1
+
+# OpenSSL PEM bundle that includes trust flags
+/usr/bin/p11-kit extract --format=openssl-bundle --filter=certificates --overwrite --comment $DEST/openssl/ca-bundle.trust.crt
+/usr/bin/p11-kit extract --format=pem-bundle --filter=ca-anchors --overwrite --comment --purpose server-auth $DEST/pem/tls-ca-bundle.pem
+
+su -m build << 'EOF'
+set -xeuo
+
+# Exit code which tells the build Job that the built image failed validation so
+# that it fails straight away instead of retrying. Other failures, such as not
+# being able to pull the image, are retried. Must match validationFailedExitCode
+# in buildrequest.go.
+validation_failed=4
+
+MAX_RETRIES="${MAX_RETRIES:-3}"
+VALIDATION_IMAGE="${VALIDATION_IMAGE:-}"
+VALIDATION_COMMAND="${VALIDATION_COMMAND:-}"
+
+export HTTP_PROXY="${HTTP_PROXY:-}"
+export HTTPS_PROXY="${HTTPS_PROXY:-}"
+export NO_PROXY="${NO_PROXY:-}"
+
+# Validate the image we pushed by its digest instead of by its tag, since a
+# concurrent push to the same tag would otherwise change what gets validated.
+repo="${TAG%%@*}"
+if [[ "${repo##*/}" == *:* ]]; then
+	repo="${repo%:*}"
+fi
+export TAG="$repo@$(cat /tmp/done/digestfile)"
+
+# The built image is pulled with the final image push secret. A separate
+# validation image may instead need the base image pull secret.
+image="$TAG"
+authfiles=("$FINAL_IMAGE_PUSH_CREDS")
+if [[ -n "$VALIDATION_IMAGE" ]]; then
+	image="$VALIDATION_IMAGE"
+	authfiles+=("$BASE_IMAGE_PULL_CREDS")
+fi
+
+container=""
+for i in $(seq 1 "$MAX_RETRIES"); do
+	for authfile in "${authfiles[@]}"; do
+		if container="$(buildah from --storage-driver vfs --pull-always --authfile="$authfile" --cert-dir /var/run/secrets/kubernetes.io/serviceaccount "docker://$image")"; then
+			break 2
+		fi
+	done
+	sleep "$((i * 5))"
+done
+
+if [[ -z "$container" ]]; then
+	echo "Could not pull $image"
+	exit 1
+fi
+
+if [[ -n "$VALIDATION_COMMAND" ]]; then
+	cmd=(/bin/sh -c "$VALIDATION_COMMAND")
+else
+	# Run the image's entrypoint.
+	mapfile -t cmd < <(buildah inspect --storage-driver vfs --type container --format '{{range .OCIv1.Config.Entrypoint}}{{println .}}{{end}}{{range .OCIv1.Config.Cmd}}{{println .}}{{end}}' "$container")
+fi
+
+# The last line of the logs ends up in the build failure reason, so stop
+# tracing before reporting a failed validation.
+if [[ "${#cmd[@]}" -eq 0 ]]; then
+	set +x
+	echo "$image has no entrypoint or command to validate with, set a validation command"
+	exit "$validation_failed"
+fi
+
+status=0
+buildah run --storage-driver vfs --env TAG="$TAG" "$container" -- "${cmd[@]}" || status=$?
+if [[ "$status" -ne 0 ]]; then
+	set +x
+	echo "Validation of $TAG exited with code $status"
+	exit "$validation_failed"
+fi
+EOF
@@ -0,0 +1,59 @@
+#!/usr/bin/env bash
+#
+# This script is not meant to be directly executed. Instead, it is embedded
+# within the Build Controller binary (see //go:embed) and injected into a
+# custom build pod.
+
+set -xeuo
+
+# Exit code which tells the build Job that a per-architecture build failed so
+# that it fails straight away instead of retrying. Must match
+# architectureBuildFailedExitCode in buildrequest.go.
+arch_build_failed=3
+
+# How long to wait for all of the per-architecture builds, such as when their
+# pods cannot be scheduled because there is no node of that architecture.
+timeout=$((6 * 60 * 60))
+
+# How long a per-architecture build Job may be missing before giving up on it.
+# The controller creates them right after the build Job, but may be restarted
+# in between.
+missing_timeout=$((5 * 60))
+
+deadline=$((SECONDS + timeout))
+
+# Wait for the build Job of each architecture to either complete or fail.
+for arch in $BUILD_ARCHITECTURES; do
+    job="$BUILD_JOB_NAME-$arch"
+    missing_deadline=$((SECONDS + missing_timeout))
+
+    while true; do
+        if out="$(oc get job "$job" \
+            --namespace openshift-machine-config-operator \
+            -o jsonpath='{range .status.conditions[?(@.status=="True")]}{.type}{"\n"}{end}' 2>&1)"; then
+            missing_deadline=$((SECONDS + missing_timeout))
+
+            if grep -qx 'Complete' <<< "$out"; then
+                echo "Build Job $job for $arch completed"
+                break
+            fi
+
+            if grep -qx 'Failed' <<< "$out"; then
+                echo "Build Job $job for $arch failed"
+                exit "$arch_build_failed"
+            fi
+        elif grep -q 'NotFound' <<< "$out"; then
+            if (( SECONDS >= missing_deadline )); then
+                echo "Build Job $job for $arch does not exist"
+                exit "$arch_build_failed"
+            fi
+        fi
+
+        if (( SECONDS >= deadline )); then
+            echo "Timed out waiting for build Job $job for $arch"
+            exit "$arch_build_failed"
+        fi
+
+        sleep 10
+    done
+done
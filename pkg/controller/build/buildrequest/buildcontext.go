@@ -0,0 +1,234 @@
+package buildrequest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+)
+
+// Paths within the build context directory written by BuildContext.Write.
+// These match where the build pod places each file, except for the registry
+// configs which the build pod mounts into /etc/containers.
+const (
+	BuildContextContainerfilePath         string = "Containerfile"
+	BuildContextMachineConfigPath         string = "machineconfig/" + machineConfigJSONFilename
+	BuildContextAdditionalTrustBundlePath string = "openshift-config-user-ca-bundle.crt"
+	BuildContextPolicyJSONPath            string = "etc/policy.json"
+	BuildContextRegistriesConfPath        string = "etc/registries.conf"
+)
+
+// The supported tools for BuildContext.Command.
+const (
+	BuildToolBuildah string = "buildah"
+	BuildToolPodman  string = "podman"
+)
+
+// BuildContext holds the files the build pod assembles its build context from
+// so that a build can be reproduced outside of the cluster.
+type BuildContext struct {
+	opts BuildRequestOpts
+	// The rendered Containerfile.
+	Containerfile string
+	// The gzipped and base64-encoded MachineConfig, exactly as the Containerfile
+	// expects to find it.
+	MachineConfig string
+	// The additional trust bundle from the ControllerConfig.
+	AdditionalTrustBundle []byte
+	// The /etc/containers/policy.json file from the MachineConfig, if any.
+	PolicyJSON string
+	// The /etc/containers/registries.conf file from the MachineConfig, if any.
+	RegistriesConf string
+}
+
+// Constructs the BuildContext for a MachineOSBuild from objects which were
+// already retrieved, such as from a must-gather, instead of from the Kube API
+// server. No secrets are needed since the build context does not contain any.
+// The ControllerConfig supplies the proxy settings and additional trust
+// bundle and may be nil.
+func NewBuildContext(mosb *mcfgv1.MachineOSBuild, mosc *mcfgv1.MachineOSConfig, mc *mcfgv1.MachineConfig, cc *mcfgv1.ControllerConfig) (*BuildContext, error) {
+	og := optsGetter{}
+
+	if err := og.validateMachineOSConfig(mosc); err != nil {
+		return nil, fmt.Errorf("could not validate MachineOSConfig: %w", err)
+	}
+
+	if err := og.validateMachineOSBuild(mosb); err != nil {
+		return nil, fmt.Errorf("could not validate MachineOSBuild: %w", err)
+	}
+
+	if mc == nil {
+		return nil, fmt.Errorf("expected MachineConfig not to be nil")
+	}
+
+	if mc.Name != mosb.Spec.MachineConfig.Name {
+		return nil, fmt.Errorf("MachineOSBuild %s builds MachineConfig %s, not %s", mosb.Name, mosb.Spec.MachineConfig.Name, mc.Name)
+	}
+
+	archs, err := getBuildArchitectures(mosc)
+	if err != nil {
+		return nil, fmt.Errorf("could not get build architectures for MachineOSConfig %s: %w", mosc.Name, err)
+	}
+
+	opts := BuildRequestOpts{
+		MachineOSConfig: mosc.DeepCopy(),
+		MachineOSBuild:  mosb.DeepCopy(),
+		MachineConfig:   mc.DeepCopy(),
+		Architectures:   archs,
+	}
+
+	opts.BuildCachePVC = mosc.GetAnnotations()[constants.BuildCachePVCAnnotationKey]
+	opts.BuildCacheRepo = mosc.GetAnnotations()[constants.BuildCacheRepoAnnotationKey]
+
+	if cc != nil {
+		opts.Proxy = cc.Spec.Proxy
+		opts.AdditionalTrustBundle = cc.Spec.AdditionalTrustBundle
+	}
+
+	return newBuildContext(opts)
+}
+
+// Renders the build context from the same ConfigMaps the build pod mounts.
+func newBuildContext(opts BuildRequestOpts) (*BuildContext, error) {
+	br := newBuildRequest(opts).(*buildRequestImpl)
+
+	containerfile, err := br.containerfileToConfigMap()
+	if err != nil {
+		return nil, err
+	}
+
+	machineconfig, err := br.machineconfigToConfigMap(opts.MachineConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not render MachineConfig %q: %w", opts.MachineConfig.Name, err)
+	}
+
+	etcPolicy, err := br.etcPolicyToConfigMap(opts.MachineConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not render policy.json: %w", err)
+	}
+
+	etcRegistries, err := br.etcRegistriesToConfigMap(opts.MachineConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not render registries.conf: %w", err)
+	}
+
+	bc := &BuildContext{
+		opts:                  opts,
+		Containerfile:         containerfile.Data["Containerfile"],
+		MachineConfig:         machineconfig.Data[machineConfigJSONFilename],
+		AdditionalTrustBundle: br.additionaltrustbundleToConfigMap().BinaryData["openshift-config-user-ca-bundle.crt"],
+	}
+
+	if etcPolicy != nil {
+		bc.PolicyJSON = etcPolicy.Data["policy.json"]
+	}
+
+	if etcRegistries != nil {
+		bc.RegistriesConf = etcRegistries.Data["registries.conf"]
+	}
+
+	return bc, nil
+}
+
+// Writes the build context into the given directory, creating it if needed.
+// The registry configs are only written if the MachineConfig has them.
+func (b *BuildContext) Write(dir string) error {
+	files := map[string][]byte{
+		BuildContextContainerfilePath:         []byte(b.Containerfile),
+		BuildContextMachineConfigPath:         []byte(b.MachineConfig),
+		BuildContextAdditionalTrustBundlePath: b.AdditionalTrustBundle,
+	}
+
+	if b.PolicyJSON != "" {
+		files[BuildContextPolicyJSONPath] = []byte(b.PolicyJSON)
+	}
+
+	if b.RegistriesConf != "" {
+		files[BuildContextRegistriesConfPath] = []byte(b.RegistriesConf)
+	}
+
+	for path, content := range files {
+		fullPath := filepath.Join(dir, path)
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			return fmt.Errorf("could not create directory for %s: %w", fullPath, err)
+		}
+
+		if err := os.WriteFile(fullPath, content, 0o644); err != nil {
+			return fmt.Errorf("could not write %s: %w", fullPath, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the command line which builds the image from the build context in
+// the given directory the same way the build pod does. The registry configs
+// are passed via the CONTAINERS_REGISTRIES_CONF environment variable and the
+// --signature-policy flag instead of replacing the ones in /etc/containers.
+// The build pod's entitlement and repository mounts are not reproduced.
+func (b *BuildContext) Command(tool, dir, authfile string) ([]string, error) {
+	if tool != BuildToolBuildah && tool != BuildToolPodman {
+		return nil, fmt.Errorf("unsupported build tool %q, expected %q or %q", tool, BuildToolBuildah, BuildToolPodman)
+	}
+
+	cmd := []string{}
+
+	if b.RegistriesConf != "" {
+		cmd = append(cmd, "CONTAINERS_REGISTRIES_CONF="+filepath.Join(dir, BuildContextRegistriesConfPath))
+	}
+
+	cmd = append(cmd, tool, "build")
+
+	if b.PolicyJSON != "" {
+		cmd = append(cmd, "--signature-policy="+filepath.Join(dir, BuildContextPolicyJSONPath))
+	}
+
+	if authfile != "" {
+		cmd = append(cmd, "--authfile="+authfile)
+	}
+
+	tag := string(b.opts.MachineOSBuild.Spec.RenderedImagePushSpec)
+
+	if b.opts.isMultiArch() {
+		platforms := []string{}
+		for _, arch := range b.opts.Architectures {
+			platforms = append(platforms, "linux/"+arch)
+		}
+
+		cmd = append(cmd, "--platform="+strings.Join(platforms, ","), "--manifest="+tag)
+	} else {
+		cmd = append(cmd, "--tag="+tag)
+	}
+
+	cmd = append(cmd, "--file="+filepath.Join(dir, BuildContextContainerfilePath))
+
+	proxyArgs := map[string]string{}
+	if b.opts.Proxy != nil {
+		proxyArgs["HTTP_PROXY"] = b.opts.Proxy.HTTPProxy
+		proxyArgs["HTTPS_PROXY"] = b.opts.Proxy.HTTPSProxy
+		proxyArgs["NO_PROXY"] = b.opts.Proxy.NoProxy
+	}
+
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		cmd = append(cmd, "--build-arg="+name+"="+proxyArgs[name])
+	}
+
+	if b.opts.usesBuildCache() {
+		cmd = append(cmd, "--layers")
+	}
+
+	if b.opts.BuildCacheRepo != "" {
+		cmd = append(cmd, "--cache-from="+b.opts.BuildCacheRepo, "--cache-to="+b.opts.BuildCacheRepo)
+	}
+
+	return append(cmd, dir), nil
+}
+
+// Name of the MachineOSBuild the build context is for.
+func (b *BuildContext) MachineOSBuildName() string {
+	return b.opts.MachineOSBuild.Name
+}
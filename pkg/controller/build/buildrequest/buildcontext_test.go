@@ -0,0 +1,168 @@
+package buildrequest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	configv1 "github.com/openshift/api/config/v1"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	testhelpers "github.com/openshift/machine-config-operator/test/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildContext(t *testing.T) {
+	t.Parallel()
+
+	opts := getBuildRequestOpts()
+
+	mc := testhelpers.NewMachineConfig(opts.MachineConfig.Name, map[string]string{}, "", []ign3types.File{
+		ctrlcommon.NewIgnFile("/etc/containers/policy.json", `{"default":[{"type":"insecureAcceptAnything"}]}`),
+		ctrlcommon.NewIgnFile("/etc/containers/registries.conf", `unqualified-search-registries = ["registry.hostname.com"]`),
+	})
+	mc.Spec.OSImageURL = opts.MachineConfig.Spec.OSImageURL
+
+	cc := &mcfgv1.ControllerConfig{
+		Spec: mcfgv1.ControllerConfigSpec{
+			AdditionalTrustBundle: []byte("trust-bundle"),
+			Proxy: &configv1.ProxyStatus{
+				HTTPProxy: "http://proxy.hostname.com",
+			},
+		},
+	}
+
+	bc, err := NewBuildContext(opts.MachineOSBuild, opts.MachineOSConfig, mc, cc)
+	require.NoError(t, err)
+
+	assert.Equal(t, opts.MachineOSBuild.Name, bc.MachineOSBuildName())
+
+	// The build context matches what the build pod gets.
+	br := newBuildRequest(BuildRequestOpts{
+		MachineOSConfig:       opts.MachineOSConfig,
+		MachineOSBuild:        opts.MachineOSBuild,
+		MachineConfig:         mc,
+		Proxy:                 cc.Spec.Proxy,
+		AdditionalTrustBundle: cc.Spec.AdditionalTrustBundle,
+	})
+
+	configmaps, err := br.ConfigMaps()
+	require.NoError(t, err)
+
+	for _, cm := range configmaps {
+		switch {
+		case strings.HasPrefix(cm.Name, "containerfile-"):
+			assert.Equal(t, cm.Data["Containerfile"], bc.Containerfile)
+		case strings.HasPrefix(cm.Name, "mc-"):
+			assert.Equal(t, cm.Data[machineConfigJSONFilename], bc.MachineConfig)
+		case strings.HasPrefix(cm.Name, "additionaltrustbundle-"):
+			assert.Equal(t, cm.BinaryData["openshift-config-user-ca-bundle.crt"], bc.AdditionalTrustBundle)
+		case strings.HasPrefix(cm.Name, "etc-policy-"):
+			assert.Equal(t, cm.Data["policy.json"], bc.PolicyJSON)
+		case strings.HasPrefix(cm.Name, "etc-registries-"):
+			assert.Equal(t, cm.Data["registries.conf"], bc.RegistriesConf)
+		default:
+			t.Errorf("unexpected ConfigMap %q", cm.Name)
+		}
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, bc.Write(dir))
+
+	for path, expected := range map[string]string{
+		BuildContextContainerfilePath:         bc.Containerfile,
+		BuildContextAdditionalTrustBundlePath: "trust-bundle",
+		BuildContextPolicyJSONPath:            `{"default":[{"type":"insecureAcceptAnything"}]}`,
+		BuildContextRegistriesConfPath:        `unqualified-search-registries = ["registry.hostname.com"]`,
+	} {
+		content, err := os.ReadFile(filepath.Join(dir, path))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content), path)
+	}
+
+	// The MachineConfig can be decoded the same way the Containerfile does.
+	encoded, err := os.ReadFile(filepath.Join(dir, BuildContextMachineConfigPath))
+	require.NoError(t, err)
+
+	gz, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(encoded)))
+	require.NoError(t, err)
+
+	decoded, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	decodedMC := &mcfgv1.MachineConfig{}
+	require.NoError(t, json.Unmarshal(decoded, decodedMC))
+	assert.Equal(t, mc.Name, decodedMC.Name)
+
+	cmd, err := bc.Command(BuildToolBuildah, dir, "/tmp/auth.json")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"CONTAINERS_REGISTRIES_CONF=" + filepath.Join(dir, BuildContextRegistriesConfPath),
+		"buildah", "build",
+		"--signature-policy=" + filepath.Join(dir, BuildContextPolicyJSONPath),
+		"--authfile=/tmp/auth.json",
+		"--tag=registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504",
+		"--file=" + filepath.Join(dir, BuildContextContainerfilePath),
+		"--build-arg=HTTP_PROXY=http://proxy.hostname.com",
+		"--build-arg=HTTPS_PROXY=",
+		"--build-arg=NO_PROXY=",
+		dir,
+	}, cmd)
+
+	_, err = bc.Command("docker", dir, "")
+	assert.ErrorContains(t, err, "unsupported build tool")
+}
+
+func TestBuildContextCommandVariants(t *testing.T) {
+	t.Parallel()
+
+	opts := getBuildRequestOpts()
+	metav1.SetMetaDataAnnotation(&opts.MachineOSConfig.ObjectMeta, constants.BuildArchitecturesAnnotationKey, "amd64,arm64")
+	metav1.SetMetaDataAnnotation(&opts.MachineOSConfig.ObjectMeta, constants.BuildCacheRepoAnnotationKey, "registry.hostname.com/org/cache")
+
+	// Neither a ControllerConfig nor registry configs are required.
+	bc, err := NewBuildContext(opts.MachineOSBuild, opts.MachineOSConfig, opts.MachineConfig, nil)
+	require.NoError(t, err)
+
+	assert.Empty(t, bc.PolicyJSON)
+	assert.Empty(t, bc.RegistriesConf)
+
+	dir := t.TempDir()
+	require.NoError(t, bc.Write(dir))
+	assert.NoFileExists(t, filepath.Join(dir, BuildContextPolicyJSONPath))
+	assert.NoFileExists(t, filepath.Join(dir, BuildContextRegistriesConfPath))
+
+	cmd, err := bc.Command(BuildToolPodman, dir, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"podman", "build",
+		"--platform=linux/amd64,linux/arm64",
+		"--manifest=registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504",
+		"--file=" + filepath.Join(dir, BuildContextContainerfilePath),
+		"--build-arg=HTTP_PROXY=",
+		"--build-arg=HTTPS_PROXY=",
+		"--build-arg=NO_PROXY=",
+		"--layers",
+		"--cache-from=registry.hostname.com/org/cache",
+		"--cache-to=registry.hostname.com/org/cache",
+		dir,
+	}, cmd)
+
+	// The MachineConfig must be the one the MachineOSBuild builds.
+	otherMC := opts.MachineConfig.DeepCopy()
+	otherMC.Name = "rendered-worker-2"
+	_, err = NewBuildContext(opts.MachineOSBuild, opts.MachineOSConfig, otherMC, nil)
+	assert.ErrorContains(t, err, "not rendered-worker-2")
+}
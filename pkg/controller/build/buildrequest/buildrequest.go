@@ -31,12 +31,32 @@ var digestCMScript string
 //go:embed assets/buildah-build.sh
 var buildahBuildScript string
 
+//go:embed assets/supply-chain.sh
+var supplyChainScript string
+
 //go:embed assets/podman-build.sh
 var podmanBuildScript string
 
+//go:embed assets/wait-for-architecture-builds.sh
+var waitForArchitectureBuildsScript string
+
+//go:embed assets/manifest-list.sh
+var manifestListScript string
+
+//go:embed assets/validate-image.sh
+var validateImageScript string
+
 const (
 	// Filename for the machineconfig JSON tarball expected by the build job
 	machineConfigJSONFilename string = "machineconfig.json.gz"
+
+	// Exit code wait-for-architecture-builds.sh uses when a per-architecture
+	// build Job failed, went missing or timed out.
+	architectureBuildFailedExitCode int32 = 3
+
+	// Exit code validate-image.sh uses when the built image failed
+	// validation.
+	validationFailedExitCode int32 = 4
 )
 
 // Represents the request to build a layered OS image.
@@ -77,9 +97,37 @@ func (br buildRequestImpl) Opts() BuildRequestOpts {
 	return br.opts
 }
 
-// Creates the Build Job object.
-func (br buildRequestImpl) Builder() Builder {
-	return newBuilder(br.podToJob(br.toBuildahPod()))
+// Creates the Build Job object. For multi-architecture builds, this Job
+// assembles the images built by the ArchitectureBuilders into a manifest list.
+func (br buildRequestImpl) Builder() (Builder, error) {
+	if br.opts.isMultiArch() {
+		pod, err := br.toManifestListPod()
+		if err != nil {
+			return nil, err
+		}
+
+		return newBuilder(br.podToJob(pod)), nil
+	}
+
+	return newBuilder(br.podToJob(br.toBuildahPod())), nil
+}
+
+// Creates the Build Job objects which build the image for each architecture
+// of a multi-architecture build. Returns nothing for single-architecture
+// builds.
+func (br buildRequestImpl) ArchitectureBuilders() ([]Builder, error) {
+	builders := []Builder{}
+
+	for _, arch := range br.opts.Architectures {
+		pod, err := br.toArchitectureBuildahPod(arch)
+		if err != nil {
+			return nil, err
+		}
+
+		builders = append(builders, newBuilder(br.podToJob(pod)))
+	}
+
+	return builders, nil
 }
 
 // Takes the configured secrets and creates an ephemeral clone of them, canonicalizing them, if needed.
@@ -361,7 +409,7 @@ func (br buildRequestImpl) podToJob(pod *corev1.Pod) *batchv1.Job {
 	// Set the owner ref of the job to the MOSB
 	oref := metav1.NewControllerRef(br.opts.MachineOSBuild, mcfgv1.SchemeGroupVersion.WithKind("MachineOSBuild"))
 
-	return &batchv1.Job{
+	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            pod.ObjectMeta.Name,
 			Namespace:       pod.ObjectMeta.Namespace,
@@ -382,6 +430,158 @@ func (br buildRequestImpl) podToJob(pod *corev1.Pod) *batchv1.Job {
 			},
 		},
 	}
+
+	// A failed validation or per-architecture build is not transient, so fail
+	// the job straight away instead of rebuilding the image. Any other failure
+	// of those containers, such as a failed image pull, is retried.
+	rules := []batchv1.PodFailurePolicyRule{}
+
+	if hasContainer(pod, constants.ValidationContainerName) {
+		rules = append(rules, failJobOnExitCodes(constants.ValidationContainerName, batchv1.PodFailurePolicyOnExitCodesOpIn, validationFailedExitCode))
+	}
+
+	if hasContainer(pod, constants.WaitForArchitectureBuildsContainerName) {
+		rules = append(rules, failJobOnExitCodes(constants.WaitForArchitectureBuildsContainerName, batchv1.PodFailurePolicyOnExitCodesOpIn, architectureBuildFailedExitCode))
+	}
+
+	if len(rules) != 0 {
+		job.Spec.PodFailurePolicy = &batchv1.PodFailurePolicy{Rules: rules}
+	}
+
+	return job
+}
+
+// Constructs a pod failure policy rule which fails the Job when the named
+// container exits with a matching exit code.
+func failJobOnExitCodes(containerName string, operator batchv1.PodFailurePolicyOnExitCodesOperator, exitCode int32) batchv1.PodFailurePolicyRule {
+	return batchv1.PodFailurePolicyRule{
+		Action: batchv1.PodFailurePolicyActionFailJob,
+		OnExitCodes: &batchv1.PodFailurePolicyOnExitCodesRequirement{
+			ContainerName: &containerName,
+			Operator:      operator,
+			Values:        []int32{exitCode},
+		},
+	}
+}
+
+// Determines whether the pod has an init container or container with the
+// given name.
+func hasContainer(pod *corev1.Pod, name string) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if container.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Constructs the build pod for a single architecture of a multi-architecture
+// build. It is the regular build pod pinned to nodes of that architecture,
+// which pushes to an architecture-specific tag. Since the manifest list build
+// pod creates the digest ConfigMap once every architecture has been built, the
+// last build step becomes this pod's main container instead.
+func (br buildRequestImpl) toArchitectureBuildahPod(arch string) (*corev1.Pod, error) {
+	pushspec, err := utils.GetArchitectureImagePullspec(string(br.opts.MachineOSBuild.Spec.RenderedImagePushSpec), arch)
+	if err != nil {
+		return nil, err
+	}
+
+	archBR := br
+	archBR.opts.MachineOSBuild = br.opts.MachineOSBuild.DeepCopy()
+	archBR.opts.MachineOSBuild.Spec.RenderedImagePushSpec = mcfgv1.ImageTagFormat(pushspec)
+	archBR.opts.Architectures = nil
+
+	pod := archBR.toBuildahPod()
+	pod.ObjectMeta.Name = utils.GetArchitectureBuildJobName(br.opts.MachineOSBuild, arch)
+	pod.ObjectMeta.Labels[constants.BuildArchitectureLabelKey] = arch
+	// The architecture node selector takes precedence over the configured one.
+	nodeSelector := map[string]string{}
+	for key, value := range br.opts.BuildPodNodeSelector {
+		nodeSelector[key] = value
+	}
+	nodeSelector[corev1.LabelArchStable] = arch
+	pod.Spec.NodeSelector = nodeSelector
+
+	last := len(pod.Spec.InitContainers) - 1
+	pod.Spec.Containers = []corev1.Container{pod.Spec.InitContainers[last]}
+	pod.Spec.InitContainers = pod.Spec.InitContainers[:last]
+
+	return pod, nil
+}
+
+// Constructs the build pod for a multi-architecture build. Instead of building
+// the image, it waits for the per-architecture build Jobs to complete and
+// pushes a manifest list referencing their images. The digest ConfigMap is
+// then created for the manifest list as usual.
+func (br buildRequestImpl) toManifestListPod() (*corev1.Pod, error) {
+	pod := br.toBuildahPod()
+
+	digestContainer := pod.Spec.Containers[0]
+
+	pullspecs := []string{}
+	for _, arch := range br.opts.Architectures {
+		pullspec, err := utils.GetArchitectureImagePullspec(string(br.opts.MachineOSBuild.Spec.RenderedImagePushSpec), arch)
+		if err != nil {
+			return nil, err
+		}
+
+		pullspecs = append(pullspecs, pullspec)
+	}
+
+	env := append(append([]corev1.EnvVar{}, digestContainer.Env...),
+		corev1.EnvVar{
+			Name:  "BUILD_JOB_NAME",
+			Value: br.getBuildName(),
+		},
+		corev1.EnvVar{
+			Name:  "BUILD_ARCHITECTURES",
+			Value: strings.Join(br.opts.Architectures, " "),
+		},
+		corev1.EnvVar{
+			Name:  "ARCHITECTURE_IMAGE_PULLSPECS",
+			Value: strings.Join(pullspecs, " "),
+		},
+	)
+
+	command := []string{"/bin/bash", "-c"}
+
+	pod.Spec.InitContainers = []corev1.Container{
+		{
+			// This container waits for every per-architecture build Job to
+			// complete. It uses the base OS image since it contains oc.
+			Name:                     constants.WaitForArchitectureBuildsContainerName,
+			Image:                    br.opts.MachineConfig.Spec.OSImageURL,
+			Env:                      env,
+			Command:                  append(command, waitForArchitectureBuildsScript),
+			ImagePullPolicy:          corev1.PullAlways,
+			SecurityContext:          digestContainer.SecurityContext,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+			VolumeMounts:             digestContainer.VolumeMounts,
+		},
+		{
+			// This container pushes the manifest list with Buildah.
+			Name:                     constants.ManifestListContainerName,
+			Image:                    br.opts.Images.MachineConfigOperator,
+			Env:                      env,
+			Command:                  append(command, manifestListScript),
+			ImagePullPolicy:          corev1.PullAlways,
+			SecurityContext:          digestContainer.SecurityContext,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+			VolumeMounts: append(digestContainer.VolumeMounts, corev1.VolumeMount{
+				Name:      "buildah-cache",
+				MountPath: "/home/build/.local/share/containers",
+			}),
+		},
+	}
+
+	pod.Spec.Containers[0].Env = env
+	// Validation happens in the per-architecture build pods.
+	pod.Spec.ImagePullSecrets = nil
+
+	return pod, nil
 }
 
 // We're able to run the Buildah image in an unprivileged pod provided that the
@@ -606,14 +806,54 @@ func (br buildRequestImpl) toBuildahPod() *corev1.Pod {
 			// images during the build. This seems to be required for the build-time
 			// volume mounts to work correctly, most likely due to an issue with
 			// SELinux that I have yet to figure out. Despite being called a cache
-			// directory, it gets removed whenever the build pod exits
-			Name: "buildah-cache",
-			VolumeSource: corev1.VolumeSource{
-				EmptyDir: &corev1.EmptyDirVolumeSource{},
-			},
+			// directory, it gets removed whenever the build pod exits unless a
+			// build cache PersistentVolumeClaim is configured.
+			Name:         "buildah-cache",
+			VolumeSource: br.buildahCacheVolumeSource(),
 		},
 	}
 
+	// If a build cache is configured, tell Buildah to keep intermediate layers
+	// and where to push / pull them to and from.
+	if br.opts.usesBuildCache() {
+		env = append(env, corev1.EnvVar{
+			Name:  "BUILD_CACHE_LAYERS",
+			Value: "true",
+		})
+	}
+
+	if br.opts.BuildCacheRepo != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "BUILD_CACHE_REPO",
+			Value: br.opts.BuildCacheRepo,
+		})
+	}
+
+	// If an SBOM was requested, tell Buildah which format to generate.
+	if br.opts.SBOMFormat != "" {
+		env = append(env, corev1.EnvVar{
+			Name:  "SBOM_FORMAT",
+			Value: br.opts.SBOMFormat,
+		})
+	}
+
+	// If validation is configured, tell the digest ConfigMap container so that
+	// it can record that validation passed.
+	if br.opts.usesValidation() {
+		env = append(env, corev1.EnvVar{
+			Name:  "VALIDATION_ENABLED",
+			Value: "true",
+		})
+	}
+
+	// If image signing is configured, mount the signing key into the build pod.
+	if br.opts.ImageSigningSecret != "" {
+		opts := optsForImageSigning()
+		env = append(env, opts.envVar())
+		volumeMounts = append(volumeMounts, opts.volumeMount())
+		volumes = append(volumes, opts.volumeForSecret(br.opts.ImageSigningSecret))
+	}
+
 	// If the etc-pki-entitlement secret is found, mount it into the build pod.
 	if br.opts.HasEtcPkiEntitlementKeys {
 		opts := optsForEtcPkiEntitlements()
@@ -640,6 +880,48 @@ func (br buildRequestImpl) toBuildahPod() *corev1.Pod {
 
 	var terminationGracePeriodSeconds int64 = 10
 
+	initContainers := []corev1.Container{
+		{
+			// This container performs the image build / push process.
+			Name:                     "image-build",
+			Image:                    br.opts.Images.MachineConfigOperator,
+			Env:                      env,
+			Command:                  append(command, buildahBuildScript),
+			ImagePullPolicy:          corev1.PullAlways,
+			SecurityContext:          securityContext,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+			Resources:                br.opts.BuildPodResources,
+			// Only attach the buildah-cache volume mount to the buildah container.
+			VolumeMounts: append(volumeMounts, corev1.VolumeMount{
+				Name:      "buildah-cache",
+				MountPath: "/home/build/.local/share/containers",
+			}),
+		},
+	}
+
+	// If an SBOM or signature was requested, attach the SBOM to the pushed
+	// image and record the digests of both. This uses the base OS image since
+	// it contains skopeo.
+	if br.opts.usesSupplyChainArtifacts() {
+		initContainers = append(initContainers, corev1.Container{
+			Name:                     "attach-supply-chain-artifacts",
+			Image:                    br.opts.MachineConfig.Spec.OSImageURL,
+			Env:                      env,
+			Command:                  append(command, supplyChainScript),
+			ImagePullPolicy:          corev1.PullAlways,
+			SecurityContext:          securityContext,
+			TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+			VolumeMounts:             volumeMounts,
+		})
+	}
+
+	// If validation is configured, run it against the pushed image last so
+	// that the digest ConfigMap, and therefore a successful build, is only
+	// created once it passes.
+	if br.opts.usesValidation() {
+		initContainers = append(initContainers, br.validationContainer(env, volumeMounts, securityContext))
+	}
+
 	return &corev1.Pod{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "v1",
@@ -650,23 +932,7 @@ func (br buildRequestImpl) toBuildahPod() *corev1.Pod {
 			RestartPolicy: corev1.RestartPolicyNever,
 			// Run the build process in an init container so that we can report accurate
 			// status if the build process is successful but the configmap creation container fails
-			InitContainers: []corev1.Container{
-				{
-					// This container performs the image build / push process.
-					Name:                     "image-build",
-					Image:                    br.opts.Images.MachineConfigOperator,
-					Env:                      env,
-					Command:                  append(command, buildahBuildScript),
-					ImagePullPolicy:          corev1.PullAlways,
-					SecurityContext:          securityContext,
-					TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
-					// Only attach the buildah-cache volume mount to the buildah container.
-					VolumeMounts: append(volumeMounts, corev1.VolumeMount{
-						Name:      "buildah-cache",
-						MountPath: "/home/build/.local/share/containers",
-					}),
-				},
-			},
+			InitContainers: initContainers,
 			Containers: []corev1.Container{
 				{
 					// This container waits for the init container doing the build to finish
@@ -685,12 +951,65 @@ func (br buildRequestImpl) toBuildahPod() *corev1.Pod {
 				},
 			},
 			ServiceAccountName:            "machine-os-builder",
+			NodeSelector:                  br.opts.BuildPodNodeSelector,
+			Tolerations:                   br.opts.BuildPodTolerations,
 			Volumes:                       volumes,
 			TerminationGracePeriodSeconds: &terminationGracePeriodSeconds,
 		},
 	}
 }
 
+// Constructs the container which validates the built image. It runs the built
+// image itself unless a validation image is configured, in which case the
+// built image pullspec is available to it as $TAG. Without a validation
+// command, the image's entrypoint is used. Since the pod spec is fixed before
+// the image is built, the test container is run by Buildah so that the built
+// image is referenced by the digest the image-build container recorded
+// rather than by its tag.
+func (br buildRequestImpl) validationContainer(env []corev1.EnvVar, volumeMounts []corev1.VolumeMount, securityContext *corev1.SecurityContext) corev1.Container {
+	env = append(env,
+		corev1.EnvVar{
+			Name:  "VALIDATION_IMAGE",
+			Value: br.opts.ValidationImage,
+		},
+		corev1.EnvVar{
+			Name:  "VALIDATION_COMMAND",
+			Value: br.opts.ValidationCommand,
+		},
+	)
+
+	return corev1.Container{
+		Name:                     constants.ValidationContainerName,
+		Image:                    br.opts.Images.MachineConfigOperator,
+		Env:                      env,
+		Command:                  []string{"/bin/bash", "-c", validateImageScript},
+		ImagePullPolicy:          corev1.PullAlways,
+		SecurityContext:          securityContext,
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+		VolumeMounts: append(volumeMounts, corev1.VolumeMount{
+			Name:      "buildah-cache",
+			MountPath: "/home/build/.local/share/containers",
+		}),
+	}
+}
+
+// Returns the volume source for Buildah's container storage. This is the
+// build cache PersistentVolumeClaim, if one is configured, so that base images
+// and intermediate layers survive the build pod.
+func (br buildRequestImpl) buildahCacheVolumeSource() corev1.VolumeSource {
+	if br.opts.BuildCachePVC != "" {
+		return corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: br.opts.BuildCachePVC,
+			},
+		}
+	}
+
+	return corev1.VolumeSource{
+		EmptyDir: &corev1.EmptyDirVolumeSource{},
+	}
+}
+
 // Populates the labels map for all objects created by imageBuildRequest
 func (br buildRequestImpl) getLabelsForObjectMeta() map[string]string {
 	return map[string]string{
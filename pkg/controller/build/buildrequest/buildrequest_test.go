@@ -10,8 +10,10 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 )
@@ -117,6 +119,47 @@ func TestBuildRequest(t *testing.T) {
 				return opts
 			},
 		},
+		{
+			name: "With build cache PVC",
+			optsFunc: func() BuildRequestOpts {
+				opts := getBuildRequestOpts()
+				opts.BuildCachePVC = "build-cache"
+				return opts
+			},
+		},
+		{
+			name: "With build cache repo",
+			optsFunc: func() BuildRequestOpts {
+				opts := getBuildRequestOpts()
+				opts.BuildCacheRepo = "registry.hostname.com/org/cache"
+				return opts
+			},
+		},
+		{
+			name: "With supply-chain artifacts",
+			optsFunc: func() BuildRequestOpts {
+				opts := getBuildRequestOpts()
+				opts.SBOMFormat = constants.SBOMFormatSPDX
+				opts.ImageSigningSecret = "image-signing"
+				return opts
+			},
+		},
+		{
+			name: "With validation of the built image",
+			optsFunc: func() BuildRequestOpts {
+				opts := getBuildRequestOpts()
+				opts.ValidationCommand = "bootc container lint"
+				return opts
+			},
+		},
+		{
+			name: "With validation image",
+			optsFunc: func() BuildRequestOpts {
+				opts := getBuildRequestOpts()
+				opts.ValidationImage = "registry.hostname.com/org/validator:latest"
+				return opts
+			},
+		},
 		{
 			name: "Has All Keys",
 			optsFunc: func() BuildRequestOpts {
@@ -159,7 +202,10 @@ func TestBuildRequest(t *testing.T) {
 				assert.NotContains(t, containerfile, content)
 			}
 
-			buildJob := br.Builder().GetObject().(*batchv1.Job)
+			builder, err := br.Builder()
+			require.NoError(t, err)
+
+			buildJob := builder.GetObject().(*batchv1.Job)
 
 			_, err = NewBuilder(buildJob)
 			assert.NoError(t, err)
@@ -197,6 +243,139 @@ func TestBuildRequest(t *testing.T) {
 	}
 }
 
+// Tests that a multi-architecture BuildRequest builds each architecture in its
+// own Job and assembles them into a manifest list in the build Job.
+func TestBuildRequestMultiArch(t *testing.T) {
+	t.Parallel()
+
+	opts := getBuildRequestOpts()
+	opts.Architectures = []string{"amd64", "arm64"}
+	opts.ValidationCommand = "bootc container lint"
+
+	br := newBuildRequest(opts)
+
+	builder, err := br.Builder()
+	require.NoError(t, err)
+
+	buildJob := builder.GetObject().(*batchv1.Job)
+	assert.Equal(t, "build-worker-afc35db0f874c9bfdc586e6ba39f1504", buildJob.Name)
+	assert.False(t, utils.IsArchitectureBuildJob(buildJob))
+
+	podSpec := buildJob.Spec.Template.Spec
+	require.Len(t, podSpec.InitContainers, 2)
+	assert.Equal(t, constants.WaitForArchitectureBuildsContainerName, podSpec.InitContainers[0].Name)
+	assert.Equal(t, fixtures.BaseOSContainerImage, podSpec.InitContainers[0].Image)
+	assert.Equal(t, constants.ManifestListContainerName, podSpec.InitContainers[1].Name)
+	assert.Equal(t, mcoImagePullspec, podSpec.InitContainers[1].Image)
+	assert.Equal(t, "create-digest-configmap", podSpec.Containers[0].Name)
+	assert.Empty(t, podSpec.NodeSelector)
+	assert.Empty(t, podSpec.ImagePullSecrets)
+
+	assert.Contains(t, podSpec.InitContainers[1].Env, corev1.EnvVar{
+		Name:  "ARCHITECTURE_IMAGE_PULLSPECS",
+		Value: "registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504-amd64 registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504-arm64",
+	})
+
+	require.NotNil(t, buildJob.Spec.PodFailurePolicy)
+	require.Len(t, buildJob.Spec.PodFailurePolicy.Rules, 1)
+	rule := buildJob.Spec.PodFailurePolicy.Rules[0]
+	assert.Equal(t, constants.WaitForArchitectureBuildsContainerName, *rule.OnExitCodes.ContainerName)
+	assert.Equal(t, []int32{architectureBuildFailedExitCode}, rule.OnExitCodes.Values)
+
+	builders, err := br.ArchitectureBuilders()
+	require.NoError(t, err)
+	require.Len(t, builders, 2)
+
+	for i, arch := range opts.Architectures {
+		archJob := builders[i].GetObject().(*batchv1.Job)
+		archPullspec := "registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504-" + arch
+
+		assert.Equal(t, "build-worker-afc35db0f874c9bfdc586e6ba39f1504-"+arch, archJob.Name)
+		assert.True(t, utils.IsArchitectureBuildJob(archJob))
+		assert.True(t, utils.EphemeralBuildObjectSelector().Matches(labels.Set(archJob.GetLabels())))
+		assert.Equal(t, arch, archJob.Labels[constants.BuildArchitectureLabelKey])
+
+		archPodSpec := archJob.Spec.Template.Spec
+		assert.Equal(t, map[string]string{corev1.LabelArchStable: arch}, archPodSpec.NodeSelector)
+		assert.Contains(t, archPodSpec.InitContainers[0].Env, corev1.EnvVar{Name: "TAG", Value: archPullspec})
+
+		// Validation is the last build step, so it replaces the digest
+		// ConfigMap container.
+		require.Len(t, archPodSpec.Containers, 1)
+		assert.Equal(t, constants.ValidationContainerName, archPodSpec.Containers[0].Name)
+		assert.Contains(t, archPodSpec.Containers[0].Env, corev1.EnvVar{Name: "TAG", Value: archPullspec})
+
+		require.NotNil(t, archJob.Spec.PodFailurePolicy)
+		assert.Equal(t, constants.ValidationContainerName, *archJob.Spec.PodFailurePolicy.Rules[0].OnExitCodes.ContainerName)
+	}
+
+	// The MachineOSBuild is not modified.
+	assert.Equal(t, "registry.hostname.com/org/repo:worker-afc35db0f874c9bfdc586e6ba39f1504", string(opts.MachineOSBuild.Spec.RenderedImagePushSpec))
+
+	// Single-architecture builds have no per-architecture Jobs.
+	builders, err = newBuildRequest(getBuildRequestOpts()).ArchitectureBuilders()
+	assert.NoError(t, err)
+	assert.Empty(t, builders)
+}
+
+func TestBuildRequestBuildPodPlacement(t *testing.T) {
+	t.Parallel()
+
+	opts := getBuildRequestOpts()
+	opts.BuildPodResources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("500m"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("4Gi"),
+		},
+	}
+	opts.BuildPodNodeSelector = map[string]string{
+		"node-role.kubernetes.io/builder": "",
+		corev1.LabelArchStable:            "amd64",
+	}
+	opts.BuildPodTolerations = []corev1.Toleration{
+		{
+			Key:      "builder",
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffectNoSchedule,
+		},
+	}
+
+	builder, err := newBuildRequest(opts).Builder()
+	require.NoError(t, err)
+
+	buildJob := builder.GetObject().(*batchv1.Job)
+	podSpec := buildJob.Spec.Template.Spec
+
+	assert.Equal(t, opts.BuildPodNodeSelector, podSpec.NodeSelector)
+	assert.Equal(t, opts.BuildPodTolerations, podSpec.Tolerations)
+
+	// Only the build container gets the configured resources.
+	for _, container := range append(podSpec.InitContainers, podSpec.Containers...) {
+		if container.Name == "image-build" {
+			assert.Equal(t, opts.BuildPodResources, container.Resources)
+		} else {
+			assert.Empty(t, container.Resources)
+		}
+	}
+
+	opts.Architectures = []string{"arm64"}
+
+	builders, err := newBuildRequest(opts).ArchitectureBuilders()
+	require.NoError(t, err)
+	require.Len(t, builders, 1)
+
+	// The architecture node selector overrides the configured one.
+	archPodSpec := builders[0].GetObject().(*batchv1.Job).Spec.Template.Spec
+	assert.Equal(t, map[string]string{
+		"node-role.kubernetes.io/builder": "",
+		corev1.LabelArchStable:            "arm64",
+	}, archPodSpec.NodeSelector)
+	assert.Equal(t, opts.BuildPodTolerations, archPodSpec.Tolerations)
+	assert.Equal(t, "amd64", opts.BuildPodNodeSelector[corev1.LabelArchStable])
+}
+
 func assertSecretInCorrectFormat(t *testing.T, secret *corev1.Secret) {
 	t.Helper()
 
@@ -232,6 +411,65 @@ func assertBuildJobIsCorrect(t *testing.T, buildJob *batchv1.Job, opts BuildRequ
 
 	assert.Equal(t, buildJob.Spec.Template.Spec.InitContainers[0].Image, mcoImagePullspec)
 
+	buildahCacheVolume := corev1.Volume{
+		Name: "buildah-cache",
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+
+	if opts.BuildCachePVC != "" {
+		buildahCacheVolume.VolumeSource = corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: opts.BuildCachePVC,
+			},
+		}
+	}
+
+	assertPodHasVolume(t, buildJob.Spec.Template.Spec, buildahCacheVolume)
+
+	buildCacheLayersEnvVar := corev1.EnvVar{Name: "BUILD_CACHE_LAYERS", Value: "true"}
+	buildCacheRepoEnvVar := corev1.EnvVar{Name: "BUILD_CACHE_REPO", Value: opts.BuildCacheRepo}
+	initContainerEnv := buildJob.Spec.Template.Spec.InitContainers[0].Env
+
+	if opts.BuildCachePVC != "" || opts.BuildCacheRepo != "" {
+		assert.Contains(t, initContainerEnv, buildCacheLayersEnvVar)
+	} else {
+		assert.NotContains(t, initContainerEnv, buildCacheLayersEnvVar)
+	}
+
+	if opts.BuildCacheRepo != "" {
+		assert.Contains(t, initContainerEnv, buildCacheRepoEnvVar)
+	} else {
+		assert.NotContains(t, initContainerEnv, buildCacheRepoEnvVar)
+	}
+
+	imageSigningOpts := optsForImageSigning()
+	assertBuildJobMatchesExpectations(t, opts.ImageSigningSecret != "", buildJob,
+		imageSigningOpts.envVar(),
+		imageSigningOpts.volumeForSecret(opts.ImageSigningSecret),
+		imageSigningOpts.volumeMount(),
+	)
+
+	sbomFormatEnvVar := corev1.EnvVar{Name: "SBOM_FORMAT", Value: opts.SBOMFormat}
+	if opts.SBOMFormat != "" {
+		assert.Contains(t, initContainerEnv, sbomFormatEnvVar)
+	} else {
+		assert.NotContains(t, initContainerEnv, sbomFormatEnvVar)
+	}
+
+	if opts.usesSupplyChainArtifacts() {
+		require.GreaterOrEqual(t, len(buildJob.Spec.Template.Spec.InitContainers), 2)
+		assert.Equal(t, "attach-supply-chain-artifacts", buildJob.Spec.Template.Spec.InitContainers[1].Name)
+		assert.Equal(t, fixtures.BaseOSContainerImage, buildJob.Spec.Template.Spec.InitContainers[1].Image)
+	} else {
+		for _, container := range buildJob.Spec.Template.Spec.InitContainers {
+			assert.NotEqual(t, "attach-supply-chain-artifacts", container.Name)
+		}
+	}
+
+	assertValidationIsCorrect(t, buildJob, opts)
+
 	assert.Equal(t, fixtures.BaseOSContainerImage, buildJob.Spec.Template.Spec.Containers[0].Image)
 
 	assertPodHasVolume(t, buildJob.Spec.Template.Spec, corev1.Volume{
@@ -269,6 +507,48 @@ func assertPodHasVolume(t *testing.T, pod corev1.PodSpec, volume corev1.Volume)
 	assert.Contains(t, pod.Volumes, volume)
 }
 
+func assertValidationIsCorrect(t *testing.T, buildJob *batchv1.Job, opts BuildRequestOpts) {
+	t.Helper()
+
+	podSpec := buildJob.Spec.Template.Spec
+	validationEnvVar := corev1.EnvVar{Name: "VALIDATION_ENABLED", Value: "true"}
+
+	if !opts.usesValidation() {
+		for _, container := range podSpec.InitContainers {
+			assert.NotEqual(t, constants.ValidationContainerName, container.Name)
+		}
+
+		assert.NotContains(t, podSpec.Containers[0].Env, validationEnvVar)
+		assert.Nil(t, buildJob.Spec.PodFailurePolicy)
+		assert.Empty(t, podSpec.ImagePullSecrets)
+		return
+	}
+
+	// Validation must run after everything else has pushed the image.
+	validation := podSpec.InitContainers[len(podSpec.InitContainers)-1]
+	assert.Equal(t, constants.ValidationContainerName, validation.Name)
+	assert.Contains(t, podSpec.Containers[0].Env, validationEnvVar)
+
+	// The built image is validated by digest, so the test container is run by
+	// Buildah from the MCO image instead of being pulled by the kubelet.
+	assert.Equal(t, opts.Images.MachineConfigOperator, validation.Image)
+	assert.Equal(t, []string{"/bin/bash", "-c", validateImageScript}, validation.Command)
+	assert.Contains(t, validation.Env, corev1.EnvVar{Name: "VALIDATION_IMAGE", Value: opts.ValidationImage})
+	assert.Contains(t, validation.Env, corev1.EnvVar{Name: "VALIDATION_COMMAND", Value: opts.ValidationCommand})
+	assert.Contains(t, validation.VolumeMounts, corev1.VolumeMount{Name: "done", MountPath: "/tmp/done"})
+	assert.Empty(t, podSpec.ImagePullSecrets)
+
+	require.NotNil(t, buildJob.Spec.PodFailurePolicy)
+	require.Len(t, buildJob.Spec.PodFailurePolicy.Rules, 1)
+	rule := buildJob.Spec.PodFailurePolicy.Rules[0]
+	assert.Equal(t, batchv1.PodFailurePolicyActionFailJob, rule.Action)
+	require.NotNil(t, rule.OnExitCodes)
+	assert.Equal(t, constants.ValidationContainerName, *rule.OnExitCodes.ContainerName)
+	// Only a failed validation fails the job, other failures are retried.
+	assert.Equal(t, batchv1.PodFailurePolicyOnExitCodesOpIn, rule.OnExitCodes.Operator)
+	assert.Equal(t, []int32{validationFailedExitCode}, rule.OnExitCodes.Values)
+}
+
 func assertBuildJobMatchesExpectations(t *testing.T, shouldBePresent bool, buildJob *batchv1.Job, envvar corev1.EnvVar, volume corev1.Volume, volumeMount corev1.VolumeMount) {
 	for _, container := range buildJob.Spec.Template.Spec.Containers {
 		if shouldBePresent {
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	goruntime "runtime"
+	"strconv"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -17,10 +19,16 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/secrets"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
 )
 
+// Architectures which multi-architecture builds can target. These are the
+// kubernetes.io/arch node label values OpenShift supports.
+var supportedBuildArchitectures = sets.New[string]("amd64", "arm64", "ppc64le", "s390x")
+
 // Holds all of the options used to produce a BuildRequest.
 type BuildRequestOpts struct { //nolint:revive // This name is fine.
 	MachineOSConfig *mcfgv1.MachineOSConfig
@@ -40,6 +48,31 @@ type BuildRequestOpts struct { //nolint:revive // This name is fine.
 	// Has /etc/pki/rpm-gpg configs
 	HasEtcPkiRpmGpgKeys bool
 
+	// PersistentVolumeClaim to keep Buildah's container storage in across builds
+	BuildCachePVC string
+	// Image repository to push and pull cached layers to and from
+	BuildCacheRepo string
+
+	// Format of the SBOM to generate and attach to the built image, if any
+	SBOMFormat string
+	// Secret holding the cosign private key to sign the built image with
+	ImageSigningSecret string
+
+	// Image to validate the built image in; the built image if empty
+	ValidationImage string
+	// Shell command to validate the built image with
+	ValidationCommand string
+
+	// Architectures to build the image for and push as a manifest list
+	Architectures []string
+
+	// Resource requests and limits for the image build container
+	BuildPodResources corev1.ResourceRequirements
+	// Node selector for the build pods
+	BuildPodNodeSelector map[string]string
+	// Tolerations for the build pods
+	BuildPodTolerations []corev1.Toleration
+
 	// Proxy Configurations
 	Proxy *configv1.ProxyStatus
 	// Additional trust bundles for proxy (user defined)
@@ -62,6 +95,27 @@ func (b BuildRequestOpts) getKernelPackages() (string, map[string][]string, erro
 	return ctrlcommon.GetPackagesForSupportedKernelType(newKtype)
 }
 
+// Whether the build should keep intermediate layers for later builds to reuse.
+func (b BuildRequestOpts) usesBuildCache() bool {
+	return b.BuildCachePVC != "" || b.BuildCacheRepo != ""
+}
+
+// Whether the build attaches an SBOM or signature to the built image.
+func (b BuildRequestOpts) usesSupplyChainArtifacts() bool {
+	return b.SBOMFormat != "" || b.ImageSigningSecret != ""
+}
+
+// Whether the built image is validated before the build succeeds.
+func (b BuildRequestOpts) usesValidation() bool {
+	return b.ValidationImage != "" || b.ValidationCommand != ""
+}
+
+// Whether the image is built once per architecture and pushed as a manifest
+// list.
+func (b BuildRequestOpts) isMultiArch() bool {
+	return len(b.Architectures) != 0
+}
+
 // Gets the packages for the extensions from the MachineConfig, if available.
 func (b BuildRequestOpts) getExtensionsPackages() ([]string, error) {
 	if len(b.MachineConfig.Spec.Extensions) == 0 {
@@ -135,9 +189,77 @@ func (o *optsGetter) validateMachineOSConfig(mosc *mcfgv1.MachineOSConfig) error
 		return fmt.Errorf("invalid renderedImagePushSpec for MachineOSConfig %s: %w", mosc.Name, err)
 	}
 
+	if cacheRepo, ok := mosc.GetAnnotations()[constants.BuildCacheRepoAnnotationKey]; ok {
+		if _, err := reference.ParseNamed(cacheRepo); err != nil {
+			return fmt.Errorf("invalid %s annotation for MachineOSConfig %s: %w", constants.BuildCacheRepoAnnotationKey, mosc.Name, err)
+		}
+	}
+
+	if sbomFormat, ok := mosc.GetAnnotations()[constants.SBOMFormatAnnotationKey]; ok {
+		if sbomFormat != constants.SBOMFormatSPDX && sbomFormat != constants.SBOMFormatCycloneDX {
+			return fmt.Errorf("invalid %s annotation %q for MachineOSConfig %s, expected %q or %q", constants.SBOMFormatAnnotationKey, sbomFormat, mosc.Name, constants.SBOMFormatSPDX, constants.SBOMFormatCycloneDX)
+		}
+	}
+
+	if signingSecret, ok := mosc.GetAnnotations()[constants.ImageSigningSecretAnnotationKey]; ok && signingSecret == "" {
+		return fmt.Errorf("empty %s annotation for MachineOSConfig %s", constants.ImageSigningSecretAnnotationKey, mosc.Name)
+	}
+
+	if validationImage, ok := mosc.GetAnnotations()[constants.ValidationImageAnnotationKey]; ok {
+		if _, err := reference.ParseNamed(validationImage); err != nil {
+			return fmt.Errorf("invalid %s annotation for MachineOSConfig %s: %w", constants.ValidationImageAnnotationKey, mosc.Name, err)
+		}
+	}
+
+	if priority, ok := mosc.GetAnnotations()[constants.BuildPriorityAnnotationKey]; ok {
+		if _, err := strconv.Atoi(priority); err != nil {
+			return fmt.Errorf("invalid %s annotation for MachineOSConfig %s: %w", constants.BuildPriorityAnnotationKey, mosc.Name, err)
+		}
+	}
+
+	archs, err := getBuildArchitectures(mosc)
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation for MachineOSConfig %s: %w", constants.BuildArchitecturesAnnotationKey, mosc.Name, err)
+	}
+
+	// The per-architecture builds run on different nodes at the same time, so
+	// they cannot share a build cache volume.
+	if _, ok := mosc.GetAnnotations()[constants.BuildCachePVCAnnotationKey]; ok && len(archs) != 0 {
+		return fmt.Errorf("%s annotation cannot be combined with %s annotation for MachineOSConfig %s, use %s instead", constants.BuildCachePVCAnnotationKey, constants.BuildArchitecturesAnnotationKey, mosc.Name, constants.BuildCacheRepoAnnotationKey)
+	}
+
 	return nil
 }
 
+// Parses the architectures to build the image for from the MachineOSConfig.
+// Returns nil if the image is only built for the architecture of the node the
+// build pod lands on.
+func getBuildArchitectures(mosc *mcfgv1.MachineOSConfig) ([]string, error) {
+	annoValue, ok := mosc.GetAnnotations()[constants.BuildArchitecturesAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+
+	archs := []string{}
+	seen := sets.New[string]()
+
+	for _, arch := range strings.Split(annoValue, ",") {
+		arch = strings.TrimSpace(arch)
+		if !supportedBuildArchitectures.Has(arch) {
+			return nil, fmt.Errorf("unsupported architecture %q, expected one of %v", arch, sets.List(supportedBuildArchitectures))
+		}
+
+		if seen.Has(arch) {
+			continue
+		}
+
+		seen.Insert(arch)
+		archs = append(archs, arch)
+	}
+
+	return archs, nil
+}
+
 // Validates that the required fields on a MachineOSBuild are set before beginning the build.
 func (o *optsGetter) validateMachineOSBuild(mosb *mcfgv1.MachineOSBuild) error {
 	if mosb == nil {
@@ -166,6 +288,28 @@ func (o *optsGetter) getOpts(ctx context.Context, mosb *mcfgv1.MachineOSBuild, m
 		return nil, fmt.Errorf("unable to resolve entitlements for MachineOSBuild %s: %w", mosb.Name, err)
 	}
 
+	if err := o.resolveBuildCache(ctx, mosc, opts); err != nil {
+		return nil, fmt.Errorf("unable to resolve build cache for MachineOSBuild %s: %w", mosb.Name, err)
+	}
+
+	if err := o.resolveSupplyChain(ctx, mosc, opts); err != nil {
+		return nil, fmt.Errorf("unable to resolve supply-chain artifacts for MachineOSBuild %s: %w", mosb.Name, err)
+	}
+
+	opts.ValidationImage = mosc.GetAnnotations()[constants.ValidationImageAnnotationKey]
+	opts.ValidationCommand = mosc.GetAnnotations()[constants.ValidationCommandAnnotationKey]
+
+	archs, err := getBuildArchitectures(mosc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve build architectures for MachineOSBuild %s: %w", mosb.Name, err)
+	}
+
+	opts.Architectures = archs
+
+	if err := o.resolveBuildPodConfig(ctx, opts); err != nil {
+		return nil, fmt.Errorf("unable to resolve build pod configuration for MachineOSBuild %s: %w", mosb.Name, err)
+	}
+
 	imagesConfig, err := ctrlcommon.GetImagesConfig(ctx, o.kubeclient)
 	if err != nil {
 		return nil, fmt.Errorf("could not get images.json config: %w", err)
@@ -257,6 +401,96 @@ func (o *optsGetter) resolveEntitlements(ctx context.Context, mosc *mcfgv1.Machi
 	return opts, nil
 }
 
+// Determines whether the build uses a build cache based upon the annotations
+// on the MachineOSConfig. A configured PersistentVolumeClaim must exist since
+// the build pod would otherwise never be scheduled.
+func (o *optsGetter) resolveBuildCache(ctx context.Context, mosc *mcfgv1.MachineOSConfig, opts *BuildRequestOpts) error {
+	opts.BuildCacheRepo = mosc.GetAnnotations()[constants.BuildCacheRepoAnnotationKey]
+
+	pvcName := mosc.GetAnnotations()[constants.BuildCachePVCAnnotationKey]
+	if pvcName == "" {
+		return nil
+	}
+
+	if _, err := o.kubeclient.CoreV1().PersistentVolumeClaims(ctrlcommon.MCONamespace).Get(ctx, pvcName, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("could not get build cache PersistentVolumeClaim %q: %w", pvcName, err)
+	}
+
+	klog.Infof("Build cache PersistentVolumeClaim %q found, will mount into build", pvcName)
+	opts.BuildCachePVC = pvcName
+
+	return nil
+}
+
+// Determines whether the build generates an SBOM or signs the built image
+// based upon the annotations on the MachineOSConfig. A configured signing
+// Secret must exist and contain a private key since the build would otherwise
+// push an unsigned image.
+func (o *optsGetter) resolveSupplyChain(ctx context.Context, mosc *mcfgv1.MachineOSConfig, opts *BuildRequestOpts) error {
+	opts.SBOMFormat = mosc.GetAnnotations()[constants.SBOMFormatAnnotationKey]
+
+	secretName := mosc.GetAnnotations()[constants.ImageSigningSecretAnnotationKey]
+	if secretName == "" {
+		return nil
+	}
+
+	secret, err := o.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get image signing secret %q: %w", secretName, err)
+	}
+
+	if len(secret.Data[constants.ImageSigningKeySecretKey]) == 0 {
+		return fmt.Errorf("image signing secret %q has no %q key", secretName, constants.ImageSigningKeySecretKey)
+	}
+
+	klog.Infof("Image signing secret %q found, will sign the built image", secretName)
+	opts.ImageSigningSecret = secretName
+
+	return nil
+}
+
+// Determines the resources and placement of the build pods from the global
+// build configuration ConfigMap, if it exists.
+func (o *optsGetter) resolveBuildPodConfig(ctx context.Context, opts *BuildRequestOpts) error {
+	cm, err := o.getOptionalConfigMap(ctx, constants.BuildConfigConfigMapName)
+	if err != nil {
+		return fmt.Errorf("could not determine status of optional ConfigMap %q: %w", constants.BuildConfigConfigMapName, err)
+	}
+
+	if cm == nil {
+		return nil
+	}
+
+	return parseBuildPodConfig(cm, opts)
+}
+
+// Parses the build pod resources, node selector, and tolerations from the
+// global build configuration ConfigMap into the given BuildRequestOpts. Each
+// key may hold either YAML or JSON.
+func parseBuildPodConfig(cm *corev1.ConfigMap, opts *BuildRequestOpts) error {
+	fields := []struct {
+		key string
+		out interface{}
+	}{
+		{key: constants.BuildPodResourcesConfigKey, out: &opts.BuildPodResources},
+		{key: constants.BuildPodNodeSelectorConfigKey, out: &opts.BuildPodNodeSelector},
+		{key: constants.BuildPodTolerationsConfigKey, out: &opts.BuildPodTolerations},
+	}
+
+	for _, field := range fields {
+		value, ok := cm.Data[field.key]
+		if !ok {
+			continue
+		}
+
+		if err := yaml.UnmarshalStrict([]byte(value), field.out); err != nil {
+			return fmt.Errorf("invalid %s in ConfigMap %q: %w", field.key, cm.Name, err)
+		}
+	}
+
+	return nil
+}
+
 // Fetches an optional secret to inject into the build. Returns a nil error if
 // the secret is not found.
 func (o *optsGetter) getOptionalSecret(ctx context.Context, secretName string) (*corev1.Secret, error) {
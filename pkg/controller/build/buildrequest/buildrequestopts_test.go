@@ -9,6 +9,7 @@ import (
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -29,6 +30,7 @@ func TestBuildRequestOpts(t *testing.T) {
 				assert.False(t, brOpts.HasEtcYumReposDConfigs)
 				assert.False(t, brOpts.HasEtcPkiEntitlementKeys)
 				assert.False(t, brOpts.hasUserDefinedBaseImagePullSecret)
+				assert.False(t, brOpts.usesBuildCache())
 			},
 		},
 		{
@@ -76,6 +78,7 @@ func TestBuildRequestOpts(t *testing.T) {
 				},
 			},
 			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.False(t, brOpts.usesSupplyChainArtifacts())
 				assert.True(t, brOpts.HasEtcPkiRpmGpgKeys)
 				assert.False(t, brOpts.HasEtcYumReposDConfigs)
 				assert.False(t, brOpts.HasEtcPkiEntitlementKeys)
@@ -111,6 +114,101 @@ func TestBuildRequestOpts(t *testing.T) {
 				assert.False(t, brOpts.hasUserDefinedBaseImagePullSecret)
 			},
 		},
+		{
+			name: "with build cache",
+			addlObjects: []runtime.Object{
+				&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "build-cache",
+						Namespace: ctrlcommon.MCONamespace,
+					},
+				},
+			},
+			addlObjectSetup: func(t *testing.T, lobj *fixtures.ObjectsForTest) {
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.BuildCachePVCAnnotationKey, "build-cache")
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.BuildCacheRepoAnnotationKey, "registry.hostname.com/org/cache")
+			},
+			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.Equal(t, "build-cache", brOpts.BuildCachePVC)
+				assert.Equal(t, "registry.hostname.com/org/cache", brOpts.BuildCacheRepo)
+				assert.True(t, brOpts.usesBuildCache())
+			},
+		},
+		{
+			name: "with supply-chain artifacts",
+			addlObjects: []runtime.Object{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "image-signing",
+						Namespace: ctrlcommon.MCONamespace,
+					},
+					Data: map[string][]byte{
+						constants.ImageSigningKeySecretKey: []byte("private-key"),
+					},
+				},
+			},
+			addlObjectSetup: func(t *testing.T, lobj *fixtures.ObjectsForTest) {
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.SBOMFormatAnnotationKey, constants.SBOMFormatCycloneDX)
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.ImageSigningSecretAnnotationKey, "image-signing")
+			},
+			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.Equal(t, constants.SBOMFormatCycloneDX, brOpts.SBOMFormat)
+				assert.Equal(t, "image-signing", brOpts.ImageSigningSecret)
+				assert.True(t, brOpts.usesSupplyChainArtifacts())
+			},
+		},
+		{
+			name: "with validation",
+			addlObjectSetup: func(t *testing.T, lobj *fixtures.ObjectsForTest) {
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.ValidationImageAnnotationKey, "registry.hostname.com/org/validator:latest")
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.ValidationCommandAnnotationKey, "rpm -Va")
+			},
+			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.Equal(t, "registry.hostname.com/org/validator:latest", brOpts.ValidationImage)
+				assert.Equal(t, "rpm -Va", brOpts.ValidationCommand)
+				assert.True(t, brOpts.usesValidation())
+			},
+		},
+		{
+			name: "with build architectures",
+			addlObjectSetup: func(t *testing.T, lobj *fixtures.ObjectsForTest) {
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.BuildArchitecturesAnnotationKey, "amd64, arm64,amd64")
+			},
+			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.Equal(t, []string{"amd64", "arm64"}, brOpts.Architectures)
+				assert.True(t, brOpts.isMultiArch())
+			},
+		},
+		{
+			name: "with build pod configuration",
+			addlObjects: []runtime.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      constants.BuildConfigConfigMapName,
+						Namespace: ctrlcommon.MCONamespace,
+					},
+					Data: map[string]string{
+						constants.MaxConcurrentBuildsConfigKey:  "2",
+						constants.BuildPodResourcesConfigKey:    "requests:\n  cpu: 500m\n  memory: 1Gi\nlimits:\n  memory: 4Gi\n",
+						constants.BuildPodNodeSelectorConfigKey: `{"node-role.kubernetes.io/builder": ""}`,
+						constants.BuildPodTolerationsConfigKey:  "- key: builder\n  operator: Exists\n  effect: NoSchedule\n",
+					},
+				},
+			},
+			addlAsserts: func(t *testing.T, brOpts BuildRequestOpts) {
+				assert.Equal(t, resource.MustParse("500m"), brOpts.BuildPodResources.Requests[corev1.ResourceCPU])
+				assert.Equal(t, resource.MustParse("1Gi"), brOpts.BuildPodResources.Requests[corev1.ResourceMemory])
+				assert.Equal(t, resource.MustParse("4Gi"), brOpts.BuildPodResources.Limits[corev1.ResourceMemory])
+				assert.Equal(t, map[string]string{"node-role.kubernetes.io/builder": ""}, brOpts.BuildPodNodeSelector)
+				assert.Equal(t, []corev1.Toleration{
+					{
+						Key:      "builder",
+						Operator: corev1.TolerationOpExists,
+						Effect:   corev1.TaintEffectNoSchedule,
+					},
+				}, brOpts.BuildPodTolerations)
+			},
+		},
 		{
 			name: "with user defined base image pull secret",
 			addlObjectSetup: func(t *testing.T, lobj *fixtures.ObjectsForTest) {
@@ -153,3 +251,139 @@ func TestBuildRequestOpts(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildRequestOptsMissingBuildCachePVC(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTest(t)
+
+	metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.BuildCachePVCAnnotationKey, "missing")
+
+	_, err := newBuildRequestOptsFromAPI(ctx, kubeclient, mcfgclient, lobj.MachineOSBuild, lobj.MachineOSConfig)
+	assert.ErrorContains(t, err, "could not get build cache PersistentVolumeClaim")
+}
+
+func TestBuildRequestOptsInvalidAnnotations(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		errExpected string
+	}{
+		{
+			name:        "Unknown SBOM format",
+			annotations: map[string]string{constants.SBOMFormatAnnotationKey: "swid"},
+			errExpected: "invalid " + constants.SBOMFormatAnnotationKey,
+		},
+		{
+			name:        "Invalid validation image",
+			annotations: map[string]string{constants.ValidationImageAnnotationKey: "Not An Image"},
+			errExpected: "invalid " + constants.ValidationImageAnnotationKey,
+		},
+		{
+			name:        "Non-integer build priority",
+			annotations: map[string]string{constants.BuildPriorityAnnotationKey: "high"},
+			errExpected: "invalid " + constants.BuildPriorityAnnotationKey,
+		},
+		{
+			name:        "Unsupported build architecture",
+			annotations: map[string]string{constants.BuildArchitecturesAnnotationKey: "amd64,mips"},
+			errExpected: "unsupported architecture \"mips\"",
+		},
+		{
+			name: "Build architectures with build cache PVC",
+			annotations: map[string]string{
+				constants.BuildArchitecturesAnnotationKey: "amd64,arm64",
+				constants.BuildCachePVCAnnotationKey:      "build-cache",
+			},
+			errExpected: "cannot be combined",
+		},
+		{
+			name:        "Missing signing secret",
+			annotations: map[string]string{constants.ImageSigningSecretAnnotationKey: "missing"},
+			errExpected: "could not get image signing secret",
+		},
+		{
+			name:        "Signing secret without key",
+			annotations: map[string]string{constants.ImageSigningSecretAnnotationKey: "no-key"},
+			errExpected: "has no \"cosign.key\" key",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			noKeySecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "no-key",
+					Namespace: ctrlcommon.MCONamespace,
+				},
+			}
+
+			kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTestWithAdditionalObjects(t, []runtime.Object{noKeySecret}, []runtime.Object{})
+
+			for key, value := range testCase.annotations {
+				metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, key, value)
+			}
+
+			_, err := newBuildRequestOptsFromAPI(ctx, kubeclient, mcfgclient, lobj.MachineOSBuild, lobj.MachineOSConfig)
+			assert.ErrorContains(t, err, testCase.errExpected)
+		})
+	}
+}
+
+func TestBuildRequestOptsInvalidBuildPodConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		errExpected string
+	}{
+		{
+			name:        "Malformed resources",
+			data:        map[string]string{constants.BuildPodResourcesConfigKey: "requests: [cpu]"},
+			errExpected: "invalid " + constants.BuildPodResourcesConfigKey,
+		},
+		{
+			name:        "Unknown resources field",
+			data:        map[string]string{constants.BuildPodResourcesConfigKey: "request:\n  cpu: 500m\n"},
+			errExpected: "invalid " + constants.BuildPodResourcesConfigKey,
+		},
+		{
+			name:        "Malformed tolerations",
+			data:        map[string]string{constants.BuildPodTolerationsConfigKey: "key: builder"},
+			errExpected: "invalid " + constants.BuildPodTolerationsConfigKey,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      constants.BuildConfigConfigMapName,
+					Namespace: ctrlcommon.MCONamespace,
+				},
+				Data: testCase.data,
+			}
+
+			kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTestWithAdditionalObjects(t, []runtime.Object{cm}, []runtime.Object{})
+
+			_, err := newBuildRequestOptsFromAPI(ctx, kubeclient, mcfgclient, lobj.MachineOSBuild, lobj.MachineOSConfig)
+			assert.ErrorContains(t, err, testCase.errExpected)
+		})
+	}
+}
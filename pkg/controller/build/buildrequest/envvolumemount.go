@@ -40,6 +40,15 @@ func optsForEtcRpmGpgKeys() envVolumeAndMountOpts {
 	}
 }
 
+// Gets the options for handling the image signing key.
+func optsForImageSigning() envVolumeAndMountOpts {
+	return envVolumeAndMountOpts{
+		name:       "image-signing-key",
+		envVarName: "IMAGE_SIGNING_KEY_MOUNTPOINT",
+		mountpoint: "/var/run/secrets/image-signing",
+	}
+}
+
 func (e *envVolumeAndMountOpts) mountMode() *int32 {
 	// Octal: 0755.
 	var mountMode int32 = 493
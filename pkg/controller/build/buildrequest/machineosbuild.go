@@ -227,5 +227,22 @@ func NewMachineOSBuild(opts MachineOSBuildOpts) (*mcfgv1.MachineOSBuild, error)
 		},
 	}
 
+	// Carry the image builder backend settings over from the MachineOSConfig so
+	// that the build is observed and cleaned up by the backend which started it,
+	// even if the MachineOSConfig changes or is deleted in the meantime. The
+	// architectures are carried over so that nodes know whether the image is a
+	// manifest list.
+	for _, key := range []string{
+		constants.ImageBuilderBackendAnnotationKey,
+		constants.ExternalBuilderURLAnnotationKey,
+		constants.ExternalBuilderSecretAnnotationKey,
+		constants.PromotedImageAnnotationKey,
+		constants.BuildArchitecturesAnnotationKey,
+	} {
+		if val, ok := opts.MachineOSConfig.GetAnnotations()[key]; ok {
+			mosb.Annotations[key] = val
+		}
+	}
+
 	return mosb, nil
 }
@@ -8,6 +8,7 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
 	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
 	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
 	testhelpers "github.com/openshift/machine-config-operator/test/helpers"
@@ -158,3 +159,42 @@ func TestMachineOSBuildLabelConsistency(t *testing.T) {
 	assert.Equal(t, mosb.Labels, utils.GetMachineOSBuildLabels(obj.MachineOSConfig, obj.MachineConfigPool))
 	assert.Equal(t, obj.MachineOSBuild.Labels, mosb.Labels)
 }
+
+// Ensures that the image builder backend settings are carried over from the
+// MachineOSConfig without affecting the hashed name.
+func TestMachineOSBuildCopiesImageBuilderBackendAnnotations(t *testing.T) {
+	t.Parallel()
+
+	poolName := "worker"
+
+	obj := fixtures.NewObjectsForTest(poolName)
+
+	withoutBackend, err := NewMachineOSBuild(MachineOSBuildOpts{
+		MachineConfig:     obj.RenderedMachineConfig,
+		MachineConfigPool: obj.MachineConfigPool,
+		MachineOSConfig:   obj.MachineOSConfig,
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, withoutBackend.Annotations, constants.ImageBuilderBackendAnnotationKey)
+
+	mosc := obj.MachineOSConfig.DeepCopy()
+	mosc.Annotations = map[string]string{
+		constants.ImageBuilderBackendAnnotationKey:    constants.ImageBuilderBackendExternal,
+		constants.ExternalBuilderURLAnnotationKey:     "https://builds.example.com",
+		constants.BuildArchitecturesAnnotationKey:     "amd64,arm64",
+		constants.RebuildMachineOSConfigAnnotationKey: "",
+	}
+
+	withBackend, err := NewMachineOSBuild(MachineOSBuildOpts{
+		MachineConfig:     obj.RenderedMachineConfig,
+		MachineConfigPool: obj.MachineConfigPool,
+		MachineOSConfig:   mosc,
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, withoutBackend.Name, withBackend.Name)
+	assert.Equal(t, constants.ImageBuilderBackendExternal, withBackend.Annotations[constants.ImageBuilderBackendAnnotationKey])
+	assert.Equal(t, "https://builds.example.com", withBackend.Annotations[constants.ExternalBuilderURLAnnotationKey])
+	assert.Equal(t, "amd64,arm64", withBackend.Annotations[constants.BuildArchitecturesAnnotationKey])
+	assert.NotContains(t, withBackend.Annotations, constants.RebuildMachineOSConfigAnnotationKey)
+}
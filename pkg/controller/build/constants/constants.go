@@ -54,6 +54,206 @@ const (
 	PreBuiltImageAnnotationKey = "machineconfiguration.openshift.io/pre-built-image"
 )
 
+// Build cache annotations. When either is set on a MachineOSConfig, Buildah
+// keeps intermediate layers so that later builds can reuse them.
+const (
+	// BuildCachePVCAnnotationKey names a PersistentVolumeClaim in the MCO
+	// namespace which holds Buildah's container storage across builds.
+	BuildCachePVCAnnotationKey = "machineconfiguration.openshift.io/build-cache-pvc"
+	// BuildCacheRepoAnnotationKey names an image repository which Buildah
+	// pushes intermediate layers to and pulls them from.
+	BuildCacheRepoAnnotationKey = "machineconfiguration.openshift.io/build-cache-repo"
+)
+
+// Build log annotations. These are set on a MachineOSConfig and control how
+// much of each build container's log is kept once a build completes and for
+// how many builds.
+const (
+	// BuildLogSizeLimitAnnotationKey is a quantity, such as "64Ki", which
+	// limits how much of the end of each build container's log is kept.
+	BuildLogSizeLimitAnnotationKey = "machineconfiguration.openshift.io/build-log-size-limit"
+	// BuildLogRetentionAnnotationKey is the number of build log ConfigMaps to
+	// keep for a MachineOSConfig. Setting it to zero disables log capture.
+	BuildLogRetentionAnnotationKey = "machineconfiguration.openshift.io/build-log-retention"
+)
+
+// Label added to the ConfigMaps which hold the captured build logs. These are
+// not ephemeral build objects and are not removed by the cleaner.
+const (
+	BuildLogsLabelKey = "machineconfiguration.openshift.io/build-logs"
+	// BuildPodNameAnnotationKey names the build pod the logs were captured from.
+	BuildPodNameAnnotationKey = "machineconfiguration.openshift.io/build-pod"
+)
+
+// Supply-chain annotations. These are set on a MachineOSConfig and add
+// post-build steps which generate an SBOM for the built image and sign it.
+const (
+	// SBOMFormatAnnotationKey enables SBOM generation. One of the SBOMFormat*
+	// values below. The SBOM is attached to the image as an OCI referrer.
+	SBOMFormatAnnotationKey = "machineconfiguration.openshift.io/sbom-format"
+	// ImageSigningSecretAnnotationKey names a Secret in the MCO namespace which
+	// holds a cosign-compatible private key used to sign the built image.
+	ImageSigningSecretAnnotationKey = "machineconfiguration.openshift.io/image-signing-secret"
+)
+
+// SBOM formats.
+const (
+	// SBOMFormatSPDX produces an SPDX 2.3 JSON document.
+	SBOMFormatSPDX = "spdx"
+	// SBOMFormatCycloneDX produces a CycloneDX 1.5 JSON document.
+	SBOMFormatCycloneDX = "cyclonedx"
+)
+
+// Keys in the image signing Secret.
+const (
+	// ImageSigningKeySecretKey holds the cosign private key.
+	ImageSigningKeySecretKey = "cosign.key"
+	// ImageSigningPasswordSecretKey optionally holds the private key's password.
+	ImageSigningPasswordSecretKey = "cosign.password"
+)
+
+// Validation annotations. When either is set on a MachineOSConfig, a
+// validation container runs against the built image after it is pushed and
+// the build only succeeds if it exits zero.
+const (
+	// ValidationImageAnnotationKey is the pullspec of the image to run the
+	// validation in. Defaults to the built image itself.
+	ValidationImageAnnotationKey = "machineconfiguration.openshift.io/validation-image"
+	// ValidationCommandAnnotationKey is a shell command, such as "rpm -Va" or
+	// "bootc container lint", run by /bin/sh in the validation container.
+	// Defaults to the validation image's entrypoint.
+	ValidationCommandAnnotationKey = "machineconfiguration.openshift.io/validation-command"
+)
+
+// Name of the build pod container which validates the built image.
+const (
+	ValidationContainerName = "validate-image"
+)
+
+// Multi-architecture build annotation. When set on a MachineOSConfig, the
+// image is built once per architecture on a node of that architecture and the
+// results are pushed as a single manifest list.
+const (
+	// BuildArchitecturesAnnotationKey is a comma-separated list of Go
+	// architectures, such as "amd64,arm64", to build the image for.
+	BuildArchitecturesAnnotationKey = "machineconfiguration.openshift.io/build-architectures"
+)
+
+// Label added to the per-architecture build Jobs of a multi-architecture
+// build. Its value is the architecture the Job builds for.
+const (
+	BuildArchitectureLabelKey = "machineconfiguration.openshift.io/build-architecture"
+)
+
+// Names of the build pod containers which wait for the per-architecture
+// builds and assemble their images into a manifest list.
+const (
+	WaitForArchitectureBuildsContainerName = "wait-for-architecture-builds"
+	ManifestListContainerName              = "assemble-manifest-list"
+)
+
+// Rebuild policy annotations. These are set on a MachineOSConfig and cause
+// BuildController to rebuild the current image on a schedule or when one of
+// the images it was built from changes.
+const (
+	// RebuildScheduleAnnotationKey is a standard cron expression, such as
+	// "@weekly" or "0 3 * * 0", which schedules periodic rebuilds.
+	RebuildScheduleAnnotationKey = "machineconfiguration.openshift.io/rebuild-schedule"
+	// RebuildWatchDigestsAnnotationKey, when "true", rebuilds whenever the
+	// digest of the base OS image, the extensions image, or an external image
+	// referenced by the Containerfile changes.
+	RebuildWatchDigestsAnnotationKey = "machineconfiguration.openshift.io/rebuild-watch-digests"
+)
+
+// Annotations BuildController maintains on a MachineOSConfig to track its
+// rebuild policy.
+const (
+	// WatchedImageDigestsAnnotationKey holds a JSON object mapping each
+	// watched image to its last observed digest.
+	WatchedImageDigestsAnnotationKey = "machineconfiguration.openshift.io/watched-image-digests"
+	// LastPolicyRebuildAnnotationKey is the RFC3339 time of the last rebuild
+	// triggered by the rebuild policy.
+	LastPolicyRebuildAnnotationKey = "machineconfiguration.openshift.io/last-policy-rebuild"
+	// LastPolicyRebuildReasonAnnotationKey describes why the rebuild policy
+	// last triggered a rebuild.
+	LastPolicyRebuildReasonAnnotationKey = "machineconfiguration.openshift.io/last-policy-rebuild-reason"
+)
+
+// Global build configuration. This optional ConfigMap in the MCO namespace
+// applies to the builds for every MachineOSConfig. Each key is optional.
+const (
+	// BuildConfigConfigMapName is the name of the global build configuration
+	// ConfigMap.
+	BuildConfigConfigMapName = "on-cluster-build-config"
+	// MaxConcurrentBuildsConfigKey is the maximum number of MachineOSBuilds
+	// which may build at once. Other MachineOSBuilds wait in a queue. Builds
+	// are not limited when it is unset or zero.
+	MaxConcurrentBuildsConfigKey = "maxConcurrentBuilds"
+	// BuildPodResourcesConfigKey holds the resource requests and limits for
+	// the image build container as YAML or JSON.
+	BuildPodResourcesConfigKey = "buildPodResources"
+	// BuildPodNodeSelectorConfigKey holds a node selector for build pods as
+	// YAML or JSON.
+	BuildPodNodeSelectorConfigKey = "buildPodNodeSelector"
+	// BuildPodTolerationsConfigKey holds a list of tolerations for build pods
+	// as YAML or JSON.
+	BuildPodTolerationsConfigKey = "buildPodTolerations"
+)
+
+// Build queue annotations. These are set on a MachineOSConfig and control the
+// order its MachineOSBuilds leave the build queue in.
+const (
+	// BuildPriorityAnnotationKey is an integer priority. MachineOSBuilds for a
+	// higher priority MachineOSConfig leave the queue first, and those with
+	// the same priority leave it in the order they were created. Defaults to 0.
+	BuildPriorityAnnotationKey = "machineconfiguration.openshift.io/build-priority"
+)
+
+// Image retention annotations. These are set on a MachineOSConfig and cause
+// BuildController to periodically delete old MachineOSBuilds and their images.
+// Images which are booted or desired by a node are never deleted.
+const (
+	// ImageRetentionCountAnnotationKey is the number of most recent successful
+	// MachineOSBuilds whose images are kept, such as "3".
+	ImageRetentionCountAnnotationKey = "machineconfiguration.openshift.io/image-retention-count"
+	// ImageRetentionTTLAnnotationKey is a duration, such as "168h", for which
+	// failed and superseded MachineOSBuilds are kept after they finish.
+	ImageRetentionTTLAnnotationKey = "machineconfiguration.openshift.io/image-retention-ttl"
+	// ImageRetentionPruneOrphansAnnotationKey, when "true", deletes images in
+	// the push repository which were built for the MachineOSConfig but are no
+	// longer tied to any MachineOSBuild.
+	ImageRetentionPruneOrphansAnnotationKey = "machineconfiguration.openshift.io/image-retention-prune-orphans"
+)
+
+// Image builder backend annotations. These are set on a MachineOSConfig and
+// copied onto each MachineOSBuild when it is created so that a build is always
+// observed and cleaned up by the backend which started it.
+const (
+	// ImageBuilderBackendAnnotationKey selects the backend which performs the
+	// build. One of the ImageBuilderBackend* values below; defaults to Job.
+	ImageBuilderBackendAnnotationKey = "machineconfiguration.openshift.io/image-builder-backend"
+	// ExternalBuilderURLAnnotationKey is the base URL of the external build
+	// service used by the External backend.
+	ExternalBuilderURLAnnotationKey = "machineconfiguration.openshift.io/external-builder-url"
+	// ExternalBuilderSecretAnnotationKey optionally names a Secret in the MCO
+	// namespace whose "token" key is sent as a bearer token to the external
+	// build service.
+	ExternalBuilderSecretAnnotationKey = "machineconfiguration.openshift.io/external-builder-secret"
+	// PromotedImageAnnotationKey is the digested pullspec of an image built
+	// elsewhere which the PreBuilt backend promotes.
+	PromotedImageAnnotationKey = "machineconfiguration.openshift.io/promoted-image"
+)
+
+// Image builder backends.
+const (
+	// ImageBuilderBackendJob builds the image with Buildah in a Job.
+	ImageBuilderBackendJob = "Job"
+	// ImageBuilderBackendExternal hands the build off to an external build service.
+	ImageBuilderBackendExternal = "External"
+	// ImageBuilderBackendPreBuilt validates and promotes an image built elsewhere.
+	ImageBuilderBackendPreBuilt = "PreBuilt"
+)
+
 // MachineOSConfig condition types
 // TODO: These should eventually be moved to the API package once MOSC conditions are finalized
 const (
@@ -67,6 +267,63 @@ const (
 	ReasonPreBuiltImageSeeded = "PreBuiltImageSeeded"
 )
 
+// MachineOSBuild condition types
+// TODO: These should eventually be moved to the API package.
+const (
+	// MachineOSBuildCacheUsed reports whether a build that had a build cache
+	// configured reused any cached layers.
+	MachineOSBuildCacheUsed = "BuildCacheUsed"
+	// MachineOSBuildLogsCaptured reports that the build logs were stored in a
+	// ConfigMap and, for failed builds, why the build failed.
+	MachineOSBuildLogsCaptured = "BuildLogsCaptured"
+	// MachineOSBuildSBOMAttached reports the digest of the SBOM attached to
+	// the built image.
+	MachineOSBuildSBOMAttached = "SBOMAttached"
+	// MachineOSBuildImageSigned reports the digest of the signature of the
+	// built image.
+	MachineOSBuildImageSigned = "ImageSigned"
+	// MachineOSBuildValidated reports whether the built image passed
+	// validation.
+	MachineOSBuildValidated = "Validated"
+	// MachineOSBuildQueued reports whether the build is waiting in the build
+	// queue and, if so, its position in it.
+	MachineOSBuildQueued = "Queued"
+)
+
+// MachineOSBuild condition reasons
+const (
+	// ReasonBuildCacheHit indicates at least one build step was served from the cache
+	ReasonBuildCacheHit = "CacheHit"
+	// ReasonBuildCacheMiss indicates no build step was served from the cache
+	ReasonBuildCacheMiss = "CacheMiss"
+	// ReasonImagePromoted indicates the PreBuilt backend promoted an image built elsewhere
+	ReasonImagePromoted = "ImagePromoted"
+	// ReasonImagePromotionFailed indicates the PreBuilt backend rejected the image to promote
+	ReasonImagePromotionFailed = "ImagePromotionFailed"
+	// ReasonExternalBuildFailed indicates the external build service reported a failed build
+	ReasonExternalBuildFailed = "ExternalBuildFailed"
+	// ReasonBuildLogsCaptured indicates the build logs were captured without a recognized failure
+	ReasonBuildLogsCaptured = "BuildLogsCaptured"
+	// ReasonContainerfileStepFailed indicates a Containerfile step failed
+	ReasonContainerfileStepFailed = "ContainerfileStepFailed"
+	// ReasonRPMOstreeFailed indicates rpm-ostree reported an error during the build
+	ReasonRPMOstreeFailed = "RPMOstreeFailed"
+	// ReasonImagePushFailed indicates the built image could not be pushed
+	ReasonImagePushFailed = "ImagePushFailed"
+	// ReasonSBOMAttached indicates an SBOM was attached to the built image
+	ReasonSBOMAttached = "SBOMAttached"
+	// ReasonImageSigned indicates the built image was signed
+	ReasonImageSigned = "ImageSigned"
+	// ReasonValidationPassed indicates the validation container succeeded against the built image
+	ReasonValidationPassed = "ValidationPassed"
+	// ReasonValidationFailed indicates the validation container failed against the built image
+	ReasonValidationFailed = "ValidationFailed"
+	// ReasonBuildQueued indicates the build is waiting for the number of concurrent builds to drop
+	ReasonBuildQueued = "BuildQueued"
+	// ReasonBuildDequeued indicates the build left the build queue and was started
+	ReasonBuildDequeued = "BuildDequeued"
+)
+
 // Component MachineConfig naming for pre-built images
 const (
 	// PreBuiltImageMachineConfigPrefix is the prefix for component MCs that set osImageURL from pre-built images
@@ -0,0 +1,115 @@
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// Inspects the given image pullspec using the credentials associated with the
+// given MachineOSBuild. Used by the PreBuilt backend to validate the image it
+// promotes.
+type ImageInspectFunc func(context.Context, string, *mcfgv1.MachineOSBuild) (*types.ImageInspectInfo, error)
+
+// Gets the image builder backend selected for the given MachineOSConfig or
+// MachineOSBuild. Defaults to the Job backend when none is selected.
+func GetImageBuilderBackend(obj metav1.Object) (string, error) {
+	backend, ok := obj.GetAnnotations()[constants.ImageBuilderBackendAnnotationKey]
+	if !ok || backend == "" {
+		return constants.ImageBuilderBackendJob, nil
+	}
+
+	switch backend {
+	case constants.ImageBuilderBackendJob, constants.ImageBuilderBackendExternal, constants.ImageBuilderBackendPreBuilt:
+		return backend, nil
+	}
+
+	return "", fmt.Errorf("unknown image builder backend %q on %s, expected one of %q, %q or %q", backend, obj.GetName(),
+		constants.ImageBuilderBackendJob, constants.ImageBuilderBackendExternal, constants.ImageBuilderBackendPreBuilt)
+}
+
+// Determines whether builds run by the given backend must have their status
+// polled since they do not produce any informer events.
+func IsPolledImageBuilderBackend(obj metav1.Object) bool {
+	backend, err := GetImageBuilderBackend(obj)
+	return err == nil && backend == constants.ImageBuilderBackendExternal
+}
+
+// Instantiates an ImageBuilder for the backend selected on the MachineOSBuild.
+func NewImageBuilder(kubeclient clientset.Interface, mcfgclient mcfgclientset.Interface, mosb *mcfgv1.MachineOSBuild, mosc *mcfgv1.MachineOSConfig, inspect ImageInspectFunc) (ImageBuilder, error) {
+	backend, err := GetImageBuilderBackend(mosb)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case constants.ImageBuilderBackendExternal:
+		return newExternalImageBuilder(kubeclient, mcfgclient, mosb, mosc)
+	case constants.ImageBuilderBackendPreBuilt:
+		return newPreBuiltImageBuilder(kubeclient, mcfgclient, mosb, mosc, inspect), nil
+	}
+
+	return NewJobImageBuilder(kubeclient, mcfgclient, mosb, mosc), nil
+}
+
+// Instantiates an ImageBuildObserver for the backend selected on the MachineOSBuild.
+func NewImageBuildObserver(kubeclient clientset.Interface, mcfgclient mcfgclientset.Interface, mosb *mcfgv1.MachineOSBuild, mosc *mcfgv1.MachineOSConfig) (ImageBuildObserver, error) {
+	return NewImageBuilder(kubeclient, mcfgclient, mosb, mosc, nil)
+}
+
+// Instantiates a Cleaner for the backend selected on the MachineOSBuild using
+// only the MachineOSBuild object.
+func NewImageBuildCleaner(kubeclient clientset.Interface, mcfgclient mcfgclientset.Interface, mosb *mcfgv1.MachineOSBuild) (Cleaner, error) {
+	backend, err := GetImageBuilderBackend(mosb)
+	if err != nil {
+		return nil, err
+	}
+
+	if backend == constants.ImageBuilderBackendJob {
+		return NewJobImageBuildCleaner(kubeclient, mcfgclient, mosb), nil
+	}
+
+	return NewImageBuilder(kubeclient, mcfgclient, mosb, nil, nil)
+}
+
+// Overrides the reason and message of the given condition type so that the
+// backend can explain why a build ended up in that state.
+func withConditionReason(conditions []metav1.Condition, condType mcfgv1.BuildProgress, reason, message string) []metav1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == string(condType) {
+			conditions[i].Reason = reason
+			conditions[i].Message = message
+		}
+	}
+
+	return conditions
+}
+
+// Computes the start and end times for a build which is not backed by a Kube
+// object. The start time is taken from the MachineOSBuild since the end time
+// must always come after it.
+func getBuildTimes(mosb *mcfgv1.MachineOSBuild, buildStatus mcfgv1.BuildProgress) (*metav1.Time, *metav1.Time) {
+	start := mosb.Status.BuildStart
+	if start == nil {
+		creation := mosb.GetCreationTimestamp()
+		start = &creation
+	}
+
+	if buildStatus != mcfgv1.MachineOSBuildSucceeded && buildStatus != mcfgv1.MachineOSBuildFailed && buildStatus != mcfgv1.MachineOSBuildInterrupted {
+		return start, nil
+	}
+
+	end := metav1.Now()
+	if !start.Before(&end) {
+		end = metav1.NewTime(start.Add(time.Second))
+	}
+
+	return start, &end
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
@@ -12,9 +14,11 @@ import (
 	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 )
 
 // Holds the common objects and methods needed to implement an ImageBuilder.
@@ -86,12 +90,27 @@ func (b *baseImageBuilder) getMachineOSBuildStatus(ctx context.Context, obj kube
 	}
 
 	if buildStatus == mcfgv1.MachineOSBuildSucceeded {
-		pullspec, err := b.getFinalImagePullspec(ctx)
+		digestConfigMap, err := b.getDigestConfigMap(ctx)
+		if err != nil {
+			return out, err
+		}
+
+		pullspec, err := b.getFinalImagePullspec(digestConfigMap)
 		if err != nil {
 			return out, err
 		}
 
 		out.DigestedImagePushSpec = mcfgv1.ImageDigestFormat(pullspec)
+
+		if cacheCondition := getBuildCacheCondition(digestConfigMap); cacheCondition != nil {
+			conditions = append(conditions, *cacheCondition)
+		}
+
+		conditions = append(conditions, getSupplyChainConditions(digestConfigMap)...)
+
+		if validationCondition := getValidationCondition(digestConfigMap); validationCondition != nil {
+			conditions = append(conditions, *validationCondition)
+		}
 	}
 
 	out.Conditions = conditions
@@ -135,18 +154,23 @@ func (b *baseImageBuilder) getDigestConfigMapName() (string, error) {
 	return fmt.Sprintf("digest-%s", mosbName), nil
 }
 
-// Gets the final image pullspec from the digestfile ConfigMap.
-func (b *baseImageBuilder) getFinalImagePullspec(ctx context.Context) (string, error) {
+// Gets the digestfile ConfigMap.
+func (b *baseImageBuilder) getDigestConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
 	name, err := b.getDigestConfigMapName()
 	if err != nil {
-		return "", fmt.Errorf("could not get digest configmap name: %w", err)
+		return nil, fmt.Errorf("could not get digest configmap name: %w", err)
 	}
 
 	digestConfigMap, err := b.kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return "", fmt.Errorf("could not get final image digest configmap %q: %w", name, err)
+		return nil, fmt.Errorf("could not get final image digest configmap %q: %w", name, err)
 	}
 
+	return digestConfigMap, nil
+}
+
+// Gets the final image pullspec from the digestfile ConfigMap.
+func (b *baseImageBuilder) getFinalImagePullspec(digestConfigMap *corev1.ConfigMap) (string, error) {
 	sha, err := utils.ParseImagePullspec(string(b.mosc.Spec.RenderedImagePushSpec), digestConfigMap.Data["digest"])
 	if err != nil {
 		return "", fmt.Errorf("could not create digested image pullspec from the pullspec %q and the digest %q: %w", b.mosc.Status.CurrentImagePullSpec, digestConfigMap.Data["digest"], err)
@@ -155,6 +179,84 @@ func (b *baseImageBuilder) getFinalImagePullspec(ctx context.Context) (string, e
 	return sha, nil
 }
 
+// Computes the BuildCacheUsed condition from the cache statistics the build
+// pod adds to the digestfile ConfigMap. Returns nil if the build did not use a
+// build cache.
+func getBuildCacheCondition(digestConfigMap *corev1.ConfigMap) *metav1.Condition {
+	hitsStr, hasHits := digestConfigMap.Data["cacheHits"]
+	stepsStr, hasSteps := digestConfigMap.Data["cacheSteps"]
+	if !hasHits || !hasSteps {
+		return nil
+	}
+
+	hits, err := strconv.Atoi(strings.TrimSpace(hitsStr))
+	if err != nil {
+		klog.Warningf("Could not parse build cache hits %q: %v", hitsStr, err)
+		return nil
+	}
+
+	steps, err := strconv.Atoi(strings.TrimSpace(stepsStr))
+	if err != nil {
+		klog.Warningf("Could not parse build cache steps %q: %v", stepsStr, err)
+		return nil
+	}
+
+	condition := &metav1.Condition{
+		Type:    constants.MachineOSBuildCacheUsed,
+		Status:  metav1.ConditionFalse,
+		Reason:  constants.ReasonBuildCacheMiss,
+		Message: fmt.Sprintf("%d of %d build steps were served from the build cache", hits, steps),
+	}
+
+	if hits > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = constants.ReasonBuildCacheHit
+	}
+
+	return condition
+}
+
+// Gets conditions reporting the digests of the SBOM and signature attached to
+// the built image, if the build produced them.
+func getSupplyChainConditions(digestConfigMap *corev1.ConfigMap) []metav1.Condition {
+	conditions := []metav1.Condition{}
+
+	if sbomDigest := strings.TrimSpace(digestConfigMap.Data["sbomDigest"]); sbomDigest != "" {
+		conditions = append(conditions, metav1.Condition{
+			Type:    constants.MachineOSBuildSBOMAttached,
+			Status:  metav1.ConditionTrue,
+			Reason:  constants.ReasonSBOMAttached,
+			Message: sbomDigest,
+		})
+	}
+
+	if signatureDigest := strings.TrimSpace(digestConfigMap.Data["signatureDigest"]); signatureDigest != "" {
+		conditions = append(conditions, metav1.Condition{
+			Type:    constants.MachineOSBuildImageSigned,
+			Status:  metav1.ConditionTrue,
+			Reason:  constants.ReasonImageSigned,
+			Message: signatureDigest,
+		})
+	}
+
+	return conditions
+}
+
+// Gets the condition reporting that the built image passed validation, if the
+// build validated it.
+func getValidationCondition(digestConfigMap *corev1.ConfigMap) *metav1.Condition {
+	if strings.TrimSpace(digestConfigMap.Data["validated"]) != "true" {
+		return nil
+	}
+
+	return &metav1.Condition{
+		Type:    constants.MachineOSBuildValidated,
+		Status:  metav1.ConditionTrue,
+		Reason:  constants.ReasonValidationPassed,
+		Message: "The built image passed validation",
+	}
+}
+
 // Gets the name of the MachineOSBuild name either directly from the
 // MachineOSBuild or from the Builder object.
 func (b *baseImageBuilder) getMachineOSBuildName() (string, error) {
@@ -208,5 +310,5 @@ func (b *baseImageBuilder) prepareForBuild(ctx context.Context) (buildrequest.Bu
 
 	b.buildrequest = br
 
-	return br.Builder(), nil
+	return br.Builder()
 }
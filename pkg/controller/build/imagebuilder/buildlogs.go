@@ -0,0 +1,551 @@
+package imagebuilder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// How much of the end of each build container's log is kept by default.
+	defaultBuildLogSizeLimit int64 = 64 * 1024
+	// The most that can be kept of each build container's log.
+	maxBuildLogSizeLimit int64 = 256 * 1024
+	// The most that is kept of the logs of all of the build containers
+	// together, since the build pod has several containers. Leaves room below
+	// the 1 MiB object size limit for the metadata and failure details of the
+	// build log ConfigMap.
+	maxBuildLogsTotalSize int64 = 768 * 1024
+	// How many build log ConfigMaps are kept per MachineOSConfig by default.
+	defaultBuildLogRetention int = 5
+)
+
+// Keys in the build log ConfigMap which hold the failure details parsed from
+// the logs of a failed build.
+const (
+	buildLogFailedStepKey      = "failed-step"
+	buildLogRPMOstreeErrorKey  = "rpm-ostree-error"
+	buildLogPushErrorKey       = "push-error"
+	buildLogValidationErrorKey = "validation-error"
+	buildLogContainerKeySuffix = ".log"
+)
+
+var (
+	// Buildah reports the Containerfile step which failed in its final error.
+	failedStepRegex = regexp.MustCompile(`^Error: building at STEP "(.*?)": (.*)$`)
+	// rpm-ostree reports errors with a lowercase prefix.
+	rpmOstreeErrorRegex = regexp.MustCompile(`^error: (.+)$`)
+	// Buildah reports failed pushes in its final error.
+	pushErrorRegex = regexp.MustCompile(`^Error: (.*(?:pushing|writing blob|writing manifest).*)$`)
+)
+
+// Controls how the build logs for the builds of a MachineOSConfig are kept.
+type BuildLogOptions struct {
+	// How many bytes from the end of each build container's log are kept.
+	// The logs of all of the containers are further trimmed to fit in the
+	// build log ConfigMap.
+	SizeLimit int64
+	// How many build log ConfigMaps are kept for the MachineOSConfig. Zero
+	// disables build log capture.
+	Retention int
+}
+
+// Gets the build log options from the annotations on the given
+// MachineOSConfig, falling back to the defaults for any which are unset.
+func GetBuildLogOptions(mosc *mcfgv1.MachineOSConfig) (BuildLogOptions, error) {
+	opts := BuildLogOptions{
+		SizeLimit: defaultBuildLogSizeLimit,
+		Retention: defaultBuildLogRetention,
+	}
+
+	if val, ok := mosc.GetAnnotations()[constants.BuildLogSizeLimitAnnotationKey]; ok {
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s annotation %q on MachineOSConfig %s: %w", constants.BuildLogSizeLimitAnnotationKey, val, mosc.Name, err)
+		}
+
+		size := quantity.Value()
+		if size <= 0 || size > maxBuildLogSizeLimit {
+			return opts, fmt.Errorf("invalid %s annotation %q on MachineOSConfig %s: must be greater than zero and at most %s", constants.BuildLogSizeLimitAnnotationKey, val, mosc.Name, resource.NewQuantity(maxBuildLogSizeLimit, resource.BinarySI))
+		}
+
+		opts.SizeLimit = size
+	}
+
+	if val, ok := mosc.GetAnnotations()[constants.BuildLogRetentionAnnotationKey]; ok {
+		retention, err := strconv.Atoi(val)
+		if err != nil || retention < 0 {
+			return opts, fmt.Errorf("invalid %s annotation %q on MachineOSConfig %s: must be a non-negative integer", constants.BuildLogRetentionAnnotationKey, val, mosc.Name)
+		}
+
+		opts.Retention = retention
+	}
+
+	return opts, nil
+}
+
+// Holds the reasons a build failed as parsed from its logs. Any field may be
+// empty if the logs did not say.
+type BuildFailure struct {
+	// The Containerfile step which failed.
+	Step string
+	// The last error rpm-ostree reported.
+	RPMOstreeError string
+	// The error encountered while pushing the built image.
+	PushError string
+	// How the validation container failed against the built image.
+	ValidationError string
+}
+
+// Whether any failure details were found.
+func (b BuildFailure) IsEmpty() bool {
+	return b.Step == "" && b.RPMOstreeError == "" && b.PushError == "" && b.ValidationError == ""
+}
+
+// Gets the most specific condition reason for the failure. A validation error
+// takes precedence since validation happens after the push, which in turn
+// happens after every Containerfile step succeeded.
+func (b BuildFailure) Reason() string {
+	switch {
+	case b.ValidationError != "":
+		return constants.ReasonValidationFailed
+	case b.PushError != "":
+		return constants.ReasonImagePushFailed
+	case b.RPMOstreeError != "":
+		return constants.ReasonRPMOstreeFailed
+	case b.Step != "":
+		return constants.ReasonContainerfileStepFailed
+	}
+
+	return constants.ReasonBuildLogsCaptured
+}
+
+// Summarizes the failure for use in a condition message.
+func (b BuildFailure) String() string {
+	parts := []string{}
+
+	if b.Step != "" {
+		parts = append(parts, fmt.Sprintf("Containerfile step %q failed", b.Step))
+	}
+
+	if b.RPMOstreeError != "" {
+		parts = append(parts, fmt.Sprintf("rpm-ostree error: %s", b.RPMOstreeError))
+	}
+
+	if b.PushError != "" {
+		parts = append(parts, fmt.Sprintf("push error: %s", b.PushError))
+	}
+
+	if b.ValidationError != "" {
+		parts = append(parts, fmt.Sprintf("validation error: %s", b.ValidationError))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// Fills in any fields of this BuildFailure which are empty from the other.
+func (b *BuildFailure) merge(other BuildFailure) {
+	if b.Step == "" {
+		b.Step = other.Step
+	}
+
+	if b.RPMOstreeError == "" {
+		b.RPMOstreeError = other.RPMOstreeError
+	}
+
+	if b.PushError == "" {
+		b.PushError = other.PushError
+	}
+
+	if b.ValidationError == "" {
+		b.ValidationError = other.ValidationError
+	}
+}
+
+// Parses the failure details from a build container's log. The last match of
+// each kind wins since earlier errors may have been retried.
+func ParseBuildFailure(log string) BuildFailure {
+	out := BuildFailure{}
+
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	scanner.Buffer(make([]byte, 0, 64*1024), int(maxBuildLogSizeLimit))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if matches := failedStepRegex.FindStringSubmatch(line); matches != nil {
+			out.Step = matches[1]
+			continue
+		}
+
+		if matches := rpmOstreeErrorRegex.FindStringSubmatch(line); matches != nil {
+			out.RPMOstreeError = matches[1]
+			continue
+		}
+
+		if matches := pushErrorRegex.FindStringSubmatch(line); matches != nil {
+			out.PushError = matches[1]
+		}
+	}
+
+	return out
+}
+
+// Builds the BuildLogsCaptured condition for a MachineOSBuild whose logs were
+// captured into the given ConfigMap.
+func NewBuildLogsCondition(cm *corev1.ConfigMap, failure *BuildFailure) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    constants.MachineOSBuildLogsCaptured,
+		Status:  metav1.ConditionTrue,
+		Reason:  constants.ReasonBuildLogsCaptured,
+		Message: fmt.Sprintf("Build logs stored in ConfigMap %s/%s", cm.Namespace, cm.Name),
+	}
+
+	if failure != nil && !failure.IsEmpty() {
+		condition.Reason = failure.Reason()
+		condition.Message = fmt.Sprintf("%s. %s", failure, condition.Message)
+	}
+
+	return condition
+}
+
+// Captures the end of each build container's log for the given MachineOSBuild
+// into a ConfigMap owned by its MachineOSConfig so that the logs outlive the
+// build pod as well as the MachineOSBuild. When the build failed, the failure
+// details are parsed from the logs and returned. The oldest build log
+// ConfigMaps for the MachineOSConfig beyond the retention limit are removed.
+// Returns a nil ConfigMap if capture is disabled or no build pod was found.
+func CaptureBuildLogs(ctx context.Context, kubeclient clientset.Interface, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild, opts BuildLogOptions) (*corev1.ConfigMap, *BuildFailure, error) {
+	if opts.Retention == 0 {
+		return nil, nil, nil
+	}
+
+	pod, err := getBuildPod(ctx, kubeclient, mosb)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pod == nil {
+		klog.Infof("No build pod found for MachineOSBuild %q, not capturing build logs", mosb.Name)
+		return nil, nil, nil
+	}
+
+	cm := newBuildLogsConfigMap(mosc, mosb, pod)
+
+	isFailure := ctrlcommon.NewMachineOSBuildState(mosb).IsBuildFailure()
+	failure := &BuildFailure{}
+	logs := map[string]string{}
+
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		log, err := getContainerLogTail(ctx, kubeclient, pod, container.Name, opts.SizeLimit)
+		if err != nil {
+			klog.Warningf("Could not get logs for container %q in build pod %q for MachineOSBuild %q: %s", container.Name, pod.Name, mosb.Name, err)
+			continue
+		}
+
+		logs[container.Name+buildLogContainerKeySuffix] = log
+
+		if isFailure {
+			failure.merge(ParseBuildFailure(log))
+		}
+	}
+
+	// The failure details are parsed before trimming so that none are lost.
+	fitBuildLogs(logs, maxBuildLogsTotalSize)
+	for key, log := range logs {
+		cm.Data[key] = log
+	}
+
+	if isFailure {
+		failure.ValidationError = getValidationError(pod)
+		setIfNotEmpty(cm.Data, buildLogFailedStepKey, failure.Step)
+		setIfNotEmpty(cm.Data, buildLogRPMOstreeErrorKey, failure.RPMOstreeError)
+		setIfNotEmpty(cm.Data, buildLogPushErrorKey, failure.PushError)
+		setIfNotEmpty(cm.Data, buildLogValidationErrorKey, failure.ValidationError)
+	} else {
+		failure = nil
+	}
+
+	created, err := createOrUpdateConfigMap(ctx, kubeclient, cm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not store build logs for MachineOSBuild %q: %w", mosb.Name, err)
+	}
+
+	klog.Infof("Stored build logs for MachineOSBuild %q in ConfigMap %q", mosb.Name, created.Name)
+
+	if err := pruneBuildLogs(ctx, kubeclient, mosc, created.Name, opts.Retention); err != nil {
+		return created, failure, fmt.Errorf("could not prune build logs for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	return created, failure, nil
+}
+
+// Gets how the validation container in the build pod failed, if it did. The
+// exit status is used since validation output is entirely up to the user.
+func getValidationError(pod *corev1.Pod) string {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != constants.ValidationContainerName || status.State.Terminated == nil {
+			continue
+		}
+
+		terminated := status.State.Terminated
+		if terminated.ExitCode == 0 {
+			return ""
+		}
+
+		msg := fmt.Sprintf("exited with code %d", terminated.ExitCode)
+		if message := strings.TrimSpace(terminated.Message); message != "" {
+			msg = fmt.Sprintf("%s: %s", msg, lastLine(message))
+		}
+
+		return msg
+	}
+
+	return ""
+}
+
+// Gets the last non-empty line of the given text.
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Finds the most recent pod for the build Job of the given MachineOSBuild.
+// Pods for an older Job with the same name are ignored when the Job UID is
+// known.
+func getBuildPod(ctx context.Context, kubeclient clientset.Interface, mosb *mcfgv1.MachineOSBuild) (*corev1.Pod, error) {
+	selector := labels.SelectorFromSet(labels.Set{batchv1.JobNameLabel: utils.GetBuildJobName(mosb)})
+
+	pods, err := kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("could not list build pods for MachineOSBuild %q: %w", mosb.Name, err)
+	}
+
+	jobUID := mosb.GetAnnotations()[constants.JobUIDAnnotationKey]
+
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+
+		if jobUID != "" && !hasOwnerRefWithUID(pod.ObjectMeta, jobUID) {
+			continue
+		}
+
+		if latest == nil || latest.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			latest = pod
+		}
+	}
+
+	return latest, nil
+}
+
+// Reads the given container's log, keeping only the last limit bytes. When
+// the log is truncated, the partial first line is dropped.
+func getContainerLogTail(ctx context.Context, kubeclient clientset.Interface, pod *corev1.Pod, container string, limit int64) (string, error) {
+	stream, err := kubeclient.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: container}).Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	defer stream.Close()
+
+	return tailBytes(stream, limit)
+}
+
+// Reads everything from the given reader while only ever holding on to a
+// bounded amount of it, then returns the last limit bytes.
+func tailBytes(r io.Reader, limit int64) (string, error) {
+	buf := []byte{}
+	chunk := make([]byte, 32*1024)
+	truncated := false
+
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if int64(len(buf)) > 2*limit {
+			buf = append([]byte{}, buf[int64(len(buf))-limit:]...)
+			truncated = true
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if int64(len(buf)) > limit {
+		buf = buf[int64(len(buf))-limit:]
+		truncated = true
+	}
+
+	if truncated {
+		if idx := strings.IndexByte(string(buf), '\n'); idx != -1 {
+			buf = buf[idx+1:]
+		}
+	}
+
+	return string(buf), nil
+}
+
+// Trims the given container logs so that together they are at most limit
+// bytes. The longest logs are trimmed first so that short logs, which often
+// hold the actual error, are kept whole.
+func fitBuildLogs(logs map[string]string, limit int64) {
+	total := int64(0)
+	for _, log := range logs {
+		total += int64(len(log))
+	}
+
+	if total <= limit {
+		return
+	}
+
+	keys := make([]string, 0, len(logs))
+	for key := range logs {
+		keys = append(keys, key)
+	}
+
+	// Shortest first, so that whatever the short logs do not use is shared
+	// among the longer ones.
+	sort.Slice(keys, func(i, j int) bool {
+		if len(logs[keys[i]]) == len(logs[keys[j]]) {
+			return keys[i] < keys[j]
+		}
+		return len(logs[keys[i]]) < len(logs[keys[j]])
+	})
+
+	remaining := limit
+	for i, key := range keys {
+		share := remaining / int64(len(keys)-i)
+		if int64(len(logs[key])) > share {
+			// Reading from a string cannot fail.
+			logs[key], _ = tailBytes(strings.NewReader(logs[key]), share)
+		}
+
+		remaining -= int64(len(logs[key]))
+	}
+}
+
+// Constructs the ConfigMap which holds the build logs for the given
+// MachineOSBuild. It is owned by the MachineOSConfig and is not labeled as an
+// ephemeral build object so that the cleaner leaves it alone.
+func newBuildLogsConfigMap(mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild, pod *corev1.Pod) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      utils.GetBuildLogsConfigMapName(mosb),
+			Namespace: ctrlcommon.MCONamespace,
+			Labels: map[string]string{
+				constants.OnClusterLayeringLabelKey:   "",
+				constants.BuildLogsLabelKey:           "",
+				constants.MachineOSConfigNameLabelKey: mosc.Name,
+				constants.MachineOSBuildNameLabelKey:  mosb.Name,
+			},
+			Annotations: map[string]string{
+				constants.MachineOSBuildNameAnnotationKey: mosb.Name,
+				constants.BuildPodNameAnnotationKey:       pod.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(mosc, mcfgv1.SchemeGroupVersion.WithKind("MachineOSConfig")),
+			},
+		},
+		Data: map[string]string{},
+	}
+}
+
+// Creates the given ConfigMap, replacing its contents if it already exists.
+func createOrUpdateConfigMap(ctx context.Context, kubeclient clientset.Interface, cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	created, err := kubeclient.CoreV1().ConfigMaps(cm.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+	if err == nil {
+		return created, nil
+	}
+
+	if !k8serrors.IsAlreadyExists(err) {
+		return nil, err
+	}
+
+	existing, err := kubeclient.CoreV1().ConfigMaps(cm.Namespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Labels = cm.Labels
+	existing.Annotations = cm.Annotations
+	existing.OwnerReferences = cm.OwnerReferences
+	existing.Data = cm.Data
+
+	return kubeclient.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, existing, metav1.UpdateOptions{})
+}
+
+// Deletes the oldest build log ConfigMaps for the given MachineOSConfig so that
+// at most retention of them remain. The ConfigMap that was just written is
+// always kept.
+func pruneBuildLogs(ctx context.Context, kubeclient clientset.Interface, mosc *mcfgv1.MachineOSConfig, current string, retention int) error {
+	selector := labels.SelectorFromSet(labels.Set{
+		constants.BuildLogsLabelKey:           "",
+		constants.MachineOSConfigNameLabelKey: mosc.Name,
+	})
+
+	cms, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+
+	older := []corev1.ConfigMap{}
+	for _, cm := range cms.Items {
+		if cm.Name != current {
+			older = append(older, cm)
+		}
+	}
+
+	if len(older) < retention {
+		return nil
+	}
+
+	// Newest first.
+	sort.Slice(older, func(i, j int) bool {
+		return older[j].CreationTimestamp.Before(&older[i].CreationTimestamp)
+	})
+
+	for _, cm := range older[retention-1:] {
+		err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+
+		klog.Infof("Deleted build logs ConfigMap %q for MachineOSConfig %q", cm.Name, mosc.Name)
+	}
+
+	return nil
+}
+
+func setIfNotEmpty(data map[string]string, key, val string) {
+	if val != "" {
+		data[key] = val
+	}
+}
@@ -0,0 +1,366 @@
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestParseBuildFailure(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		log            string
+		expected       BuildFailure
+		expectedReason string
+	}{
+		{
+			name: "Failed Containerfile step",
+			log: strings.Join([]string{
+				"STEP 1/3: FROM quay.io/openshift/os AS final",
+				"STEP 2/3: RUN exit 1",
+				`Error: building at STEP "RUN exit 1": while running runtime: exit status 1`,
+			}, "\n"),
+			expected:       BuildFailure{Step: "RUN exit 1"},
+			expectedReason: constants.ReasonContainerfileStepFailed,
+		},
+		{
+			name: "rpm-ostree error",
+			log: strings.Join([]string{
+				"[2/2] STEP 3/4: RUN rpm-ostree install nonexistent && ostree container commit",
+				"Checking out tree 2a3f1c5... done",
+				"error: Packages not found: nonexistent",
+				`Error: building at STEP "RUN rpm-ostree install nonexistent && ostree container commit": while running runtime: exit status 1`,
+			}, "\n"),
+			expected: BuildFailure{
+				Step:           "RUN rpm-ostree install nonexistent && ostree container commit",
+				RPMOstreeError: "Packages not found: nonexistent",
+			},
+			expectedReason: constants.ReasonRPMOstreeFailed,
+		},
+		{
+			name: "Push error",
+			log: strings.Join([]string{
+				"Getting image source signatures",
+				`Error: pushing image "localhost/image:latest" to "docker://registry.hostname.com/org/repo:latest": unauthorized: access to the requested resource is not authorized`,
+			}, "\n"),
+			expected: BuildFailure{
+				PushError: `pushing image "localhost/image:latest" to "docker://registry.hostname.com/org/repo:latest": unauthorized: access to the requested resource is not authorized`,
+			},
+			expectedReason: constants.ReasonImagePushFailed,
+		},
+		{
+			name:           "No recognized failure",
+			log:            "+ buildah bud --tag image\nsomething went wrong",
+			expectedReason: constants.ReasonBuildLogsCaptured,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			failure := ParseBuildFailure(testCase.log)
+			assert.Equal(t, testCase.expected, failure)
+			assert.Equal(t, testCase.expectedReason, failure.Reason())
+			assert.Equal(t, testCase.expected == BuildFailure{}, failure.IsEmpty())
+		})
+	}
+}
+
+func TestGetBuildLogOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    BuildLogOptions
+		errExpected bool
+	}{
+		{
+			name:     "Defaults",
+			expected: BuildLogOptions{SizeLimit: defaultBuildLogSizeLimit, Retention: defaultBuildLogRetention},
+		},
+		{
+			name: "Overridden",
+			annotations: map[string]string{
+				constants.BuildLogSizeLimitAnnotationKey: "16Ki",
+				constants.BuildLogRetentionAnnotationKey: "2",
+			},
+			expected: BuildLogOptions{SizeLimit: 16 * 1024, Retention: 2},
+		},
+		{
+			name:        "Disabled",
+			annotations: map[string]string{constants.BuildLogRetentionAnnotationKey: "0"},
+			expected:    BuildLogOptions{SizeLimit: defaultBuildLogSizeLimit, Retention: 0},
+		},
+		{
+			name:        "Size limit too large",
+			annotations: map[string]string{constants.BuildLogSizeLimitAnnotationKey: "1Mi"},
+			errExpected: true,
+		},
+		{
+			name:        "Invalid size limit",
+			annotations: map[string]string{constants.BuildLogSizeLimitAnnotationKey: "lots"},
+			errExpected: true,
+		},
+		{
+			name:        "Negative retention",
+			annotations: map[string]string{constants.BuildLogRetentionAnnotationKey: "-1"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			mosc := &mcfgv1.MachineOSConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "worker",
+					Annotations: testCase.annotations,
+				},
+			}
+
+			opts, err := GetBuildLogOptions(mosc)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, opts)
+		})
+	}
+}
+
+func TestTailBytes(t *testing.T) {
+	t.Parallel()
+
+	lines := []string{}
+	for i := 0; i < 10000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	log := strings.Join(lines, "\n")
+
+	out, err := tailBytes(strings.NewReader(log), 100)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(out), 100)
+	assert.True(t, strings.HasSuffix(out, "line 9999"))
+	assert.True(t, strings.HasPrefix(out, "line "), "expected the partial first line to be dropped, got %q", out)
+
+	out, err = tailBytes(strings.NewReader("short log"), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "short log", out)
+}
+
+func TestFitBuildLogs(t *testing.T) {
+	t.Parallel()
+
+	newLog := func(size int) string {
+		sb := strings.Builder{}
+		for i := 0; sb.Len() < size; i++ {
+			fmt.Fprintf(&sb, "line %d\n", i)
+		}
+		return sb.String()
+	}
+
+	// Logs which fit are left alone.
+	logs := map[string]string{"a.log": newLog(100), "b.log": newLog(100)}
+	expected := map[string]string{"a.log": logs["a.log"], "b.log": logs["b.log"]}
+	fitBuildLogs(logs, 1000)
+	assert.Equal(t, expected, logs)
+
+	// Every container of the build pod being at the per-container limit must
+	// still fit in a single ConfigMap.
+	short := newLog(1024)
+	logs = map[string]string{"short.log": short}
+	for _, name := range []string{"image-build", "create-digest-configmap", "validate-image", "sign-image", "sbom"} {
+		logs[name+".log"] = newLog(int(maxBuildLogSizeLimit))
+	}
+
+	fitBuildLogs(logs, maxBuildLogsTotalSize)
+
+	total := 0
+	for key, log := range logs {
+		total += len(log)
+		assert.True(t, strings.HasPrefix(log, "line "), "expected the partial first line of %s to be dropped", key)
+	}
+	assert.LessOrEqual(t, int64(total), maxBuildLogsTotalSize)
+	// The short log is kept whole.
+	assert.Equal(t, short, logs["short.log"])
+}
+
+func TestCaptureBuildLogs(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	kubeclient, _, _, _, lobj, _ := fixtures.GetClientsForTest(t)
+
+	mosc := lobj.MachineOSConfig
+	mosc.UID = types.UID("mosc-uid")
+	mosc.Annotations = map[string]string{constants.BuildLogRetentionAnnotationKey: "2"}
+
+	newFailedMosb := func(name string) *mcfgv1.MachineOSBuild {
+		mosb := lobj.MachineOSBuild.DeepCopy()
+		mosb.Name = name
+		mosb.Annotations[constants.JobUIDAnnotationKey] = name + "-job-uid"
+		mosb.Status.Conditions = apihelpers.MachineOSBuildFailedConditions()
+		return mosb
+	}
+
+	newBuildPod := func(mosb *mcfgv1.MachineOSBuild, name, jobUID string, created time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         ctrlcommon.MCONamespace,
+				Labels:            map[string]string{batchv1.JobNameLabel: utils.GetBuildJobName(mosb)},
+				CreationTimestamp: metav1.NewTime(created),
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: "Job", Name: utils.GetBuildJobName(mosb), UID: types.UID(jobUID)},
+				},
+			},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "image-build"}},
+				Containers:     []corev1.Container{{Name: "create-digest-configmap"}},
+			},
+		}
+	}
+
+	opts, err := GetBuildLogOptions(mosc)
+	require.NoError(t, err)
+
+	// A build without any pods has nothing to capture.
+	cm, failure, err := CaptureBuildLogs(ctx, kubeclient, mosc, newFailedMosb("no-pods"), opts)
+	assert.NoError(t, err)
+	assert.Nil(t, cm)
+	assert.Nil(t, failure)
+
+	now := time.Now()
+	names := []string{"first", "second", "third"}
+
+	for i, name := range names {
+		mosb := newFailedMosb(name)
+
+		pods := []*corev1.Pod{
+			// A pod from an older Job with the same name should be ignored.
+			newBuildPod(mosb, name+"-stale", "stale-job-uid", now.Add(time.Hour)),
+			newBuildPod(mosb, name+"-retry-1", name+"-job-uid", now),
+			newBuildPod(mosb, name+"-retry-2", name+"-job-uid", now.Add(time.Minute)),
+		}
+
+		for _, pod := range pods {
+			_, err := kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).Create(ctx, pod, metav1.CreateOptions{})
+			require.NoError(t, err)
+		}
+
+		cm, failure, err := CaptureBuildLogs(ctx, kubeclient, mosc, mosb, opts)
+		require.NoError(t, err)
+		require.NotNil(t, cm)
+		require.NotNil(t, failure)
+
+		assert.Equal(t, utils.GetBuildLogsConfigMapName(mosb), cm.Name)
+		assert.Equal(t, name+"-retry-2", cm.Annotations[constants.BuildPodNameAnnotationKey])
+		assert.Equal(t, mosc.Name, cm.Labels[constants.MachineOSConfigNameLabelKey])
+		assert.NotContains(t, cm.Labels, constants.EphemeralBuildObjectLabelKey)
+		assert.Equal(t, mosc.UID, cm.OwnerReferences[0].UID)
+		assert.Contains(t, cm.Data, "image-build.log")
+		assert.Contains(t, cm.Data, "create-digest-configmap.log")
+
+		condition := NewBuildLogsCondition(cm, failure)
+		assert.Equal(t, constants.MachineOSBuildLogsCaptured, condition.Type)
+		assert.Contains(t, condition.Message, cm.Name)
+
+		// Set creation timestamps since the fake client does not so that
+		// pruning removes the oldest ConfigMap.
+		cm.CreationTimestamp = metav1.NewTime(now.Add(time.Duration(i) * time.Minute))
+		_, err = kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(ctx, cm, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	// Only the two most recent build log ConfigMaps are retained.
+	for _, name := range names {
+		_, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, "build-logs-"+name, metav1.GetOptions{})
+		if name == "first" {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	// Disabling capture captures nothing.
+	cm, failure, err = CaptureBuildLogs(ctx, kubeclient, mosc, newFailedMosb("second"), BuildLogOptions{SizeLimit: defaultBuildLogSizeLimit})
+	assert.NoError(t, err)
+	assert.Nil(t, cm)
+	assert.Nil(t, failure)
+}
+
+func TestNewBuildLogsCondition(t *testing.T) {
+	t.Parallel()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "build-logs-worker",
+			Namespace: ctrlcommon.MCONamespace,
+		},
+	}
+
+	condition := NewBuildLogsCondition(cm, nil)
+	assert.Equal(t, constants.ReasonBuildLogsCaptured, condition.Reason)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Build logs stored in ConfigMap openshift-machine-config-operator/build-logs-worker", condition.Message)
+
+	condition = NewBuildLogsCondition(cm, &BuildFailure{Step: "RUN exit 1", RPMOstreeError: "Packages not found: foo"})
+	assert.Equal(t, constants.ReasonRPMOstreeFailed, condition.Reason)
+	assert.Equal(t, `Containerfile step "RUN exit 1" failed; rpm-ostree error: Packages not found: foo. Build logs stored in ConfigMap openshift-machine-config-operator/build-logs-worker`, condition.Message)
+}
+
+func TestGetValidationError(t *testing.T) {
+	t.Parallel()
+
+	newPod := func(exitCode int32, message string) *corev1.Pod {
+		return &corev1.Pod{
+			Status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:  "image-build",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+					},
+					{
+						Name:  constants.ValidationContainerName,
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Message: message}},
+					},
+				},
+			},
+		}
+	}
+
+	assert.Equal(t, "", getValidationError(&corev1.Pod{}))
+	assert.Equal(t, "", getValidationError(newPod(0, "")))
+	assert.Equal(t, "exited with code 1", getValidationError(newPod(1, "")))
+	assert.Equal(t, "exited with code 1: missing /usr/lib/systemd/system/foo.service", getValidationError(newPod(1, "checking units\nmissing /usr/lib/systemd/system/foo.service\n")))
+
+	condition := NewBuildLogsCondition(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "build-logs-worker", Namespace: ctrlcommon.MCONamespace}}, &BuildFailure{ValidationError: "exited with code 1"})
+	assert.Equal(t, constants.ReasonValidationFailed, condition.Reason)
+	assert.Equal(t, "validation error: exited with code 1. Build logs stored in ConfigMap openshift-machine-config-operator/build-logs-worker", condition.Message)
+}
@@ -0,0 +1,462 @@
+package imagebuilder
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/buildrequest"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// The external build service contract. The service is addressed by the URL in
+// the external-builder-url annotation and must implement:
+//
+//   - POST   <url>/v1/builds        Starts the build described by an
+//     ExternalBuildRequest. Returns 200 or 201, or 409 if a build with the
+//     same name already exists.
+//   - GET    <url>/v1/builds/<name> Returns the ExternalBuildStatus of the
+//     build, or 404 if it does not exist.
+//   - DELETE <url>/v1/builds/<name> Stops the build and removes anything the
+//     service kept for it. Returns 200, 202 or 204, or 404 if it does not exist.
+//
+// The service must be served over https with a certificate trusted by the
+// system roots or the cluster's additional trust bundle, i.e. the user-ca-bundle
+// and the proxy trusted CA. If the external-builder-secret
+// annotation is set, every request carries the "token" key of that Secret as a
+// bearer token. Registry credentials are never sent to the service; the build
+// request only references the Secrets holding them.
+const externalBuildsPath = "/v1/builds"
+
+// The states an external build can be in.
+const (
+	ExternalBuildPending   = "Pending"
+	ExternalBuildRunning   = "Running"
+	ExternalBuildSucceeded = "Succeeded"
+	ExternalBuildFailed    = "Failed"
+)
+
+// Everything the external build service needs to build the image. This is the
+// same content the Job backend mounts into the build pod.
+type ExternalBuildRequest struct {
+	// Name of the MachineOSBuild; used as the build ID.
+	Name                  string `json:"name"`
+	MachineOSConfig       string `json:"machineOSConfig"`
+	MachineConfigPool     string `json:"machineConfigPool"`
+	RenderedMachineConfig string `json:"renderedMachineConfig"`
+	// Tagged pullspec the built image must be pushed to.
+	RenderedImagePushSpec string `json:"renderedImagePushSpec"`
+	// The rendered Containerfile.
+	Containerfile string `json:"containerfile"`
+	// The data of the build context ConfigMaps keyed by ConfigMap name. This
+	// includes the Containerfile, the gzipped and base64-encoded MachineConfig,
+	// the additional trust bundle and the registry configs.
+	ConfigMaps map[string]map[string]string `json:"configMaps"`
+	// The base image pull and final image push secrets. The service must
+	// resolve these with its own access to the cluster.
+	BaseImagePullSecret  ExternalSecretReference `json:"baseImagePullSecret"`
+	FinalImagePushSecret ExternalSecretReference `json:"finalImagePushSecret"`
+}
+
+// A reference to a Secret holding registry credentials in either the
+// .dockercfg or .dockerconfigjson format.
+type ExternalSecretReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// The status of a build as reported by the external build service.
+type ExternalBuildStatus struct {
+	State string `json:"state"`
+	// The digest of the pushed image; required once the build has succeeded.
+	Digest  string `json:"digest,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Implements ImageBuilder by handing the build off to an external build
+// service over HTTP. Since there is no Kube object to watch, the
+// OSBuildController polls these builds for their status.
+type externalImageBuilder struct {
+	*baseImageBuilder
+	url string
+	// Created on first use, since it needs the trust bundle from the
+	// ControllerConfig.
+	client *http.Client
+}
+
+func newExternalImageBuilder(kubeclient clientset.Interface, mcfgclient mcfgclientset.Interface, mosb *mcfgv1.MachineOSBuild, mosc *mcfgv1.MachineOSConfig) (*externalImageBuilder, error) {
+	if mosb == nil {
+		return nil, fmt.Errorf("external image builder requires a MachineOSBuild")
+	}
+
+	rawURL := mosb.GetAnnotations()[constants.ExternalBuilderURLAnnotationKey]
+	if rawURL == "" {
+		return nil, fmt.Errorf("MachineOSBuild %q uses the %s image builder backend but has no %q annotation", mosb.Name, constants.ImageBuilderBackendExternal, constants.ExternalBuilderURLAnnotationKey)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external builder URL %q: %w", rawURL, err)
+	}
+
+	if parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid external builder URL %q: scheme must be https", rawURL)
+	}
+
+	return &externalImageBuilder{
+		baseImageBuilder: newBaseImageBuilder(kubeclient, mcfgclient, mosb, mosc, nil),
+		url:              strings.TrimSuffix(rawURL, "/"),
+	}, nil
+}
+
+// Gets the HTTP client for the external build service. Besides the system
+// roots, it trusts the additional trust bundle of the ControllerConfig so that
+// services signed by the user-ca-bundle or the proxy trusted CA can be reached.
+func (e *externalImageBuilder) getClient(ctx context.Context) (*http.Client, error) {
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	cc, err := e.mcfgclient.MachineconfigurationV1().ControllerConfigs().Get(ctx, ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get ControllerConfig %q: %w", ctrlcommon.ControllerConfigName, err)
+	}
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		klog.Warningf("Could not load system CAs, only trusting the additional trust bundle: %v", err)
+		rootCAs = x509.NewCertPool()
+	}
+
+	if len(cc.Spec.AdditionalTrustBundle) > 0 && !rootCAs.AppendCertsFromPEM(cc.Spec.AdditionalTrustBundle) {
+		return nil, fmt.Errorf("ControllerConfig %q has no valid PEM certificates in its additional trust bundle", ctrlcommon.ControllerConfigName)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	e.client = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+	return e.client, nil
+}
+
+// There is no Kube object backing an external build.
+func (e *externalImageBuilder) Get(_ context.Context) (buildrequest.Builder, error) {
+	return nil, e.addMachineOSBuildNameToError(fmt.Errorf("%s image builder backend has no builder object", constants.ImageBuilderBackendExternal))
+}
+
+// Renders the build request and submits it to the external build service.
+func (e *externalImageBuilder) Start(ctx context.Context) error {
+	if err := e.start(ctx); err != nil {
+		return e.addMachineOSBuildNameToError(fmt.Errorf("could not start external build: %w", err))
+	}
+
+	return nil
+}
+
+func (e *externalImageBuilder) start(ctx context.Context) error {
+	if e.mosc == nil {
+		return fmt.Errorf("MachineOSConfig is required to start a build")
+	}
+
+	br, err := buildrequest.NewBuildRequestFromAPI(ctx, e.kubeclient, e.mcfgclient, e.mosb, e.mosc)
+	if err != nil {
+		return fmt.Errorf("could not get build request: %w", err)
+	}
+
+	payload, err := newExternalBuildRequest(br)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not encode build request: %w", err)
+	}
+
+	resp, err := e.do(ctx, http.MethodPost, externalBuildsPath, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted:
+		klog.Infof("External build created for MachineOSBuild %q at %s", e.mosb.Name, e.url)
+		return nil
+	case http.StatusConflict:
+		klog.Infof("External build for MachineOSBuild %q already exists at %s", e.mosb.Name, e.url)
+		return nil
+	}
+
+	return unexpectedResponseError(resp)
+}
+
+// Converts a BuildRequest into the payload sent to the external build service.
+func newExternalBuildRequest(br buildrequest.BuildRequest) (*ExternalBuildRequest, error) {
+	opts := br.Opts()
+
+	configmaps, err := br.ConfigMaps()
+	if err != nil {
+		return nil, fmt.Errorf("could not render build context: %w", err)
+	}
+
+	out := &ExternalBuildRequest{
+		Name:                  opts.MachineOSBuild.Name,
+		MachineOSConfig:       opts.MachineOSConfig.Name,
+		MachineConfigPool:     opts.MachineOSConfig.Spec.MachineConfigPool.Name,
+		RenderedMachineConfig: opts.MachineConfig.Name,
+		RenderedImagePushSpec: string(opts.MachineOSBuild.Spec.RenderedImagePushSpec),
+		ConfigMaps:            map[string]map[string]string{},
+		BaseImagePullSecret: ExternalSecretReference{
+			Namespace: ctrlcommon.MCONamespace,
+			Name:      opts.BaseImagePullSecret.Name,
+		},
+		FinalImagePushSecret: ExternalSecretReference{
+			Namespace: ctrlcommon.MCONamespace,
+			Name:      opts.FinalImagePushSecret.Name,
+		},
+	}
+
+	for _, cm := range configmaps {
+		out.ConfigMaps[cm.Name] = cm.Data
+		if containerfile, ok := cm.Data["Containerfile"]; ok {
+			out.Containerfile = containerfile
+		}
+	}
+
+	return out, nil
+}
+
+// Determines whether the external build service knows about the build.
+func (e *externalImageBuilder) Exists(ctx context.Context) (bool, error) {
+	status, err := e.getBuild(ctx)
+	if err != nil {
+		return false, e.addMachineOSBuildNameToError(fmt.Errorf("could not determine if external build exists: %w", err))
+	}
+
+	return status != nil, nil
+}
+
+// Gets only the build progress field for the external build.
+func (e *externalImageBuilder) Status(ctx context.Context) (mcfgv1.BuildProgress, error) {
+	status, err := e.getBuild(ctx)
+	if err != nil {
+		return "", e.addMachineOSBuildNameToError(fmt.Errorf("could not get BuildProgress: %w", err))
+	}
+
+	progress, _ := mapExternalBuildStatus(status)
+	return progress, nil
+}
+
+// Gets the MachineOSBuildStatus for the external build.
+func (e *externalImageBuilder) MachineOSBuildStatus(ctx context.Context) (mcfgv1.MachineOSBuildStatus, error) {
+	status, err := e.machineOSBuildStatus(ctx)
+	if err != nil {
+		return status, e.addMachineOSBuildNameToError(fmt.Errorf("could not get MachineOSBuildStatus: %w", err))
+	}
+
+	return status, nil
+}
+
+func (e *externalImageBuilder) machineOSBuildStatus(ctx context.Context) (mcfgv1.MachineOSBuildStatus, error) {
+	status, err := e.getBuild(ctx)
+	if err != nil {
+		return mcfgv1.MachineOSBuildStatus{}, err
+	}
+
+	// A build which has not been submitted yet is not missing; it just has not
+	// started. Only builds which were already seen running can be lost.
+	if status == nil && !ctrlcommon.NewMachineOSBuildState(e.mosb).IsInTransientState() {
+		return *e.mosb.Status.DeepCopy(), nil
+	}
+
+	progress, conditions := mapExternalBuildStatus(status)
+
+	klog.Infof("External build status %+v mapped to MachineOSBuild %q progress %q", status, e.mosb.Name, progress)
+
+	out := mcfgv1.MachineOSBuildStatus{
+		Conditions: conditions,
+	}
+
+	out.BuildStart, out.BuildEnd = getBuildTimes(e.mosb, progress)
+
+	if progress == mcfgv1.MachineOSBuildSucceeded {
+		pullspec, err := utils.ParseImagePullspec(string(e.mosb.Spec.RenderedImagePushSpec), status.Digest)
+		if err != nil {
+			return out, fmt.Errorf("could not create digested image pullspec from the pullspec %q and the digest %q: %w", e.mosb.Spec.RenderedImagePushSpec, status.Digest, err)
+		}
+
+		out.DigestedImagePushSpec = mcfgv1.ImageDigestFormat(pullspec)
+	}
+
+	return out, nil
+}
+
+// Maps the status reported by the external build service to a MachineOSBuild
+// progress and conditions. A build the service does not know about was lost,
+// so it is considered interrupted.
+func mapExternalBuildStatus(status *ExternalBuildStatus) (mcfgv1.BuildProgress, []metav1.Condition) {
+	if status == nil {
+		return mcfgv1.MachineOSBuildInterrupted, withConditionReason(apihelpers.MachineOSBuildInterruptedConditions(),
+			mcfgv1.MachineOSBuildInterrupted, "Interrupted", "Build not found on the external build service")
+	}
+
+	switch status.State {
+	case ExternalBuildPending:
+		return mcfgv1.MachineOSBuildPrepared, apihelpers.MachineOSBuildPendingConditions()
+	case ExternalBuildRunning:
+		return mcfgv1.MachineOSBuilding, apihelpers.MachineOSBuildRunningConditions()
+	case ExternalBuildSucceeded:
+		return mcfgv1.MachineOSBuildSucceeded, apihelpers.MachineOSBuildSucceededConditions()
+	case ExternalBuildFailed:
+		message := "External build failed"
+		if status.Message != "" {
+			message = fmt.Sprintf("External build failed: %s", status.Message)
+		}
+		return mcfgv1.MachineOSBuildFailed, withConditionReason(apihelpers.MachineOSBuildFailedConditions(),
+			mcfgv1.MachineOSBuildFailed, constants.ReasonExternalBuildFailed, message)
+	}
+
+	return "", apihelpers.MachineOSBuildInitialConditions()
+}
+
+// Stops the external build by deleting it from the external build service.
+func (e *externalImageBuilder) Stop(ctx context.Context) error {
+	if err := e.stop(ctx); err != nil {
+		return e.addMachineOSBuildNameToError(fmt.Errorf("could not stop external build: %w", err))
+	}
+
+	return nil
+}
+
+func (e *externalImageBuilder) stop(ctx context.Context) error {
+	resp, err := e.do(ctx, http.MethodDelete, e.buildPath(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		klog.Infof("Deleted external build for MachineOSBuild %q", e.mosb.Name)
+		return nil
+	case http.StatusNotFound:
+		return nil
+	}
+
+	return unexpectedResponseError(resp)
+}
+
+// Nothing is created in the cluster for an external build, so cleaning up only
+// requires stopping it.
+func (e *externalImageBuilder) Clean(ctx context.Context) error {
+	return e.Stop(ctx)
+}
+
+// Gets the build from the external build service, returning nil if it does not exist.
+func (e *externalImageBuilder) getBuild(ctx context.Context) (*ExternalBuildStatus, error) {
+	resp, err := e.do(ctx, http.MethodGet, e.buildPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedResponseError(resp)
+	}
+
+	status := &ExternalBuildStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("could not decode external build status: %w", err)
+	}
+
+	return status, nil
+}
+
+func (e *externalImageBuilder) buildPath() string {
+	return externalBuildsPath + "/" + url.PathEscape(e.mosb.Name)
+}
+
+// Sends a request to the external build service, adding the bearer token if
+// one is configured.
+func (e *externalImageBuilder) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token, err := e.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client, err := e.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach external build service: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Gets the bearer token from the Secret named by the external-builder-secret
+// annotation, if any.
+func (e *externalImageBuilder) getToken(ctx context.Context) (string, error) {
+	name := e.mosb.GetAnnotations()[constants.ExternalBuilderSecretAnnotationKey]
+	if name == "" {
+		return "", nil
+	}
+
+	secret, err := e.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get external builder secret %q: %w", name, err)
+	}
+
+	token, ok := secret.Data["token"]
+	if !ok {
+		return "", fmt.Errorf("external builder secret %q has no %q key", name, "token")
+	}
+
+	return strings.TrimSpace(string(token)), nil
+}
+
+func unexpectedResponseError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.New(strings.TrimSpace(fmt.Sprintf("unexpected response from external build service %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, msg)))
+}
@@ -0,0 +1,361 @@
+package imagebuilder
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+// A local stand-in for an external build service which implements the HTTP
+// contract described in externalimagebuilder.go.
+type fakeExternalBuildService struct {
+	mu       sync.Mutex
+	token    string
+	requests map[string]*ExternalBuildRequest
+	statuses map[string]*ExternalBuildStatus
+}
+
+func newFakeExternalBuildService(t *testing.T, token string) (*fakeExternalBuildService, *httptest.Server) {
+	t.Helper()
+
+	f := &fakeExternalBuildService{
+		token:    token,
+		requests: map[string]*ExternalBuildRequest{},
+		statuses: map[string]*ExternalBuildStatus{},
+	}
+
+	server := httptest.NewTLSServer(f)
+	t.Cleanup(server.Close)
+
+	return f, server
+}
+
+// Adds the certificate of the fake external build service to the additional
+// trust bundle of the ControllerConfig, the way a user-ca-bundle would be.
+func trustFakeExternalBuildService(ctx context.Context, t *testing.T, mcfgclient mcfgclientset.Interface, server *httptest.Server) {
+	t.Helper()
+
+	cc, err := mcfgclient.MachineconfigurationV1().ControllerConfigs().Get(ctx, ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	cc.Spec.AdditionalTrustBundle = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	_, err = mcfgclient.MachineconfigurationV1().ControllerConfigs().Update(ctx, cc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+func (f *fakeExternalBuildService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == externalBuildsPath {
+		req := &ExternalBuildRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := f.requests[req.Name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		f.requests[req.Name] = req
+		f.statuses[req.Name] = &ExternalBuildStatus{State: ExternalBuildPending}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, externalBuildsPath+"/")
+	status, ok := f.statuses[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(status)
+	case http.MethodDelete:
+		delete(f.statuses, name)
+		delete(f.requests, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeExternalBuildService) setStatus(name string, status ExternalBuildStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.statuses[name] = &status
+}
+
+func (f *fakeExternalBuildService) getRequest(name string) *ExternalBuildRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[name]
+}
+
+func TestExternalImageBuilder(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	service, server := newFakeExternalBuildService(t, "secret-token")
+
+	kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTest(t)
+	trustFakeExternalBuildService(ctx, t, mcfgclient, server)
+
+	_, err := kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "external-builder-token"},
+		Data:       map[string][]byte{"token": []byte("secret-token\n")},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	mosb := lobj.MachineOSBuild.DeepCopy()
+	mosb.Spec.RenderedImagePushSpec = mcfgv1.ImageTagFormat("registry.hostname.com/org/repo:" + mosb.Name)
+	mosb.Annotations[constants.ImageBuilderBackendAnnotationKey] = constants.ImageBuilderBackendExternal
+	mosb.Annotations[constants.ExternalBuilderURLAnnotationKey] = server.URL + "/"
+	mosb.Annotations[constants.ExternalBuilderSecretAnnotationKey] = "external-builder-token"
+
+	builder, err := NewImageBuilder(kubeclient, mcfgclient, mosb, lobj.MachineOSConfig, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &externalImageBuilder{}, builder)
+	assert.True(t, IsPolledImageBuilderBackend(mosb))
+
+	exists, err := builder.Exists(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// A build that has not been submitted yet leaves the status alone.
+	status, err := builder.MachineOSBuildStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, mosb.Status, status)
+
+	require.NoError(t, builder.Start(ctx))
+	// Starting the same build again is tolerated.
+	require.NoError(t, builder.Start(ctx))
+
+	req := service.getRequest(mosb.Name)
+	require.NotNil(t, req)
+	assert.Equal(t, lobj.MachineOSConfig.Name, req.MachineOSConfig)
+	assert.Equal(t, lobj.MachineConfigPool.Name, req.MachineConfigPool)
+	assert.Equal(t, string(mosb.Spec.RenderedImagePushSpec), req.RenderedImagePushSpec)
+	assert.Contains(t, req.Containerfile, "FROM")
+	assert.NotEmpty(t, req.ConfigMaps)
+	// Only references to the registry credentials are sent.
+	assert.Equal(t, ExternalSecretReference{Namespace: ctrlcommon.MCONamespace, Name: ctrlcommon.GlobalPullSecretCopyName}, req.BaseImagePullSecret)
+	assert.Equal(t, ExternalSecretReference{Namespace: ctrlcommon.MCONamespace, Name: lobj.MachineOSConfig.Spec.RenderedImagePushSecret.Name}, req.FinalImagePushSecret)
+
+	// Nothing is created in the cluster for an external build.
+	cms, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{LabelSelector: constants.EphemeralBuildObjectLabelKey})
+	require.NoError(t, err)
+	assert.Empty(t, cms.Items)
+
+	exists, err = builder.Exists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	digest := "sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5"
+
+	testCases := []struct {
+		status   ExternalBuildStatus
+		progress mcfgv1.BuildProgress
+	}{
+		{
+			status:   ExternalBuildStatus{State: ExternalBuildPending},
+			progress: mcfgv1.MachineOSBuildPrepared,
+		},
+		{
+			status:   ExternalBuildStatus{State: ExternalBuildRunning},
+			progress: mcfgv1.MachineOSBuilding,
+		},
+		{
+			status:   ExternalBuildStatus{State: ExternalBuildSucceeded, Digest: digest},
+			progress: mcfgv1.MachineOSBuildSucceeded,
+		},
+		{
+			status:   ExternalBuildStatus{State: ExternalBuildFailed, Message: "out of disk"},
+			progress: mcfgv1.MachineOSBuildFailed,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.status.State, func(t *testing.T) {
+			service.setStatus(mosb.Name, testCase.status)
+
+			progress, err := builder.Status(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.progress, progress)
+
+			status, err := builder.MachineOSBuildStatus(ctx)
+			assert.NoError(t, err)
+			assert.Nil(t, status.Builder)
+			assert.NotNil(t, status.BuildStart)
+
+			bs := ctrlcommon.NewMachineOSBuildStateFromStatus(status)
+
+			switch testCase.progress {
+			case mcfgv1.MachineOSBuildSucceeded:
+				assert.True(t, bs.IsBuildSuccess())
+				assert.Equal(t, "registry.hostname.com/org/repo@"+digest, string(status.DigestedImagePushSpec))
+				assert.NotNil(t, status.BuildEnd)
+			case mcfgv1.MachineOSBuildFailed:
+				assert.True(t, bs.IsBuildFailure())
+				failed := apihelpers.GetMachineOSBuildCondition(status, mcfgv1.MachineOSBuildFailed)
+				require.NotNil(t, failed)
+				assert.Equal(t, constants.ReasonExternalBuildFailed, failed.Reason)
+				assert.Contains(t, failed.Message, "out of disk")
+				assert.Empty(t, status.DigestedImagePushSpec)
+			default:
+				assert.Equal(t, testCase.progress, bs.GetTransientState())
+				assert.Nil(t, status.BuildEnd)
+			}
+		})
+	}
+
+	// A build which was seen running but is no longer known to the service was lost.
+	running := mosb.DeepCopy()
+	running.Status.Conditions = apihelpers.MachineOSBuildRunningConditions()
+	observer, err := NewImageBuildObserver(kubeclient, mcfgclient, running, lobj.MachineOSConfig)
+	require.NoError(t, err)
+
+	require.NoError(t, builder.Clean(ctx))
+	assert.Nil(t, service.getRequest(mosb.Name))
+
+	status, err = observer.MachineOSBuildStatus(ctx)
+	assert.NoError(t, err)
+	assert.True(t, ctrlcommon.NewMachineOSBuildStateFromStatus(status).IsBuildInterrupted())
+
+	// Cleaning up a build which is already gone is tolerated.
+	cleaner, err := NewImageBuildCleaner(kubeclient, mcfgclient, mosb)
+	require.NoError(t, err)
+	assert.NoError(t, cleaner.Clean(ctx))
+}
+
+func TestExternalImageBuilderErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	_, server := newFakeExternalBuildService(t, "secret-token")
+
+	kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTest(t)
+
+	newMOSB := func(annos map[string]string) *mcfgv1.MachineOSBuild {
+		mosb := lobj.MachineOSBuild.DeepCopy()
+		mosb.Annotations[constants.ImageBuilderBackendAnnotationKey] = constants.ImageBuilderBackendExternal
+		for k, v := range annos {
+			mosb.Annotations[k] = v
+		}
+		return mosb
+	}
+
+	_, err := NewImageBuilder(kubeclient, mcfgclient, newMOSB(nil), lobj.MachineOSConfig, nil)
+	assert.ErrorContains(t, err, constants.ExternalBuilderURLAnnotationKey)
+
+	_, err = NewImageBuilder(kubeclient, mcfgclient, newMOSB(map[string]string{constants.ExternalBuilderURLAnnotationKey: "ftp://builds.example.com"}), lobj.MachineOSConfig, nil)
+	assert.ErrorContains(t, err, "scheme must be https")
+
+	_, err = NewImageBuilder(kubeclient, mcfgclient, newMOSB(map[string]string{constants.ExternalBuilderURLAnnotationKey: strings.Replace(server.URL, "https://", "http://", 1)}), lobj.MachineOSConfig, nil)
+	assert.ErrorContains(t, err, "scheme must be https")
+
+	// A service whose certificate is not trusted cannot be reached.
+	builder, err := NewImageBuilder(kubeclient, mcfgclient, newMOSB(map[string]string{constants.ExternalBuilderURLAnnotationKey: server.URL}), lobj.MachineOSConfig, nil)
+	require.NoError(t, err)
+	assert.ErrorContains(t, builder.Start(ctx), "certificate signed by unknown authority")
+
+	trustFakeExternalBuildService(ctx, t, mcfgclient, server)
+
+	// Without the token, the service rejects the request.
+	builder, err = NewImageBuilder(kubeclient, mcfgclient, newMOSB(map[string]string{constants.ExternalBuilderURLAnnotationKey: server.URL}), lobj.MachineOSConfig, nil)
+	require.NoError(t, err)
+	assert.ErrorContains(t, builder.Start(ctx), "401 Unauthorized")
+
+	// A missing token secret is surfaced.
+	builder, err = NewImageBuilder(kubeclient, mcfgclient, newMOSB(map[string]string{
+		constants.ExternalBuilderURLAnnotationKey:    server.URL,
+		constants.ExternalBuilderSecretAnnotationKey: "missing",
+	}), lobj.MachineOSConfig, nil)
+	require.NoError(t, err)
+	_, err = builder.Exists(ctx)
+	assert.ErrorContains(t, err, `could not get external builder secret "missing"`)
+
+	_, err = builder.Get(ctx)
+	assert.Error(t, err)
+}
+
+func TestGetImageBuilderBackend(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation  string
+		expected    string
+		errExpected bool
+	}{
+		{
+			expected: constants.ImageBuilderBackendJob,
+		},
+		{
+			annotation: constants.ImageBuilderBackendJob,
+			expected:   constants.ImageBuilderBackendJob,
+		},
+		{
+			annotation: constants.ImageBuilderBackendExternal,
+			expected:   constants.ImageBuilderBackendExternal,
+		},
+		{
+			annotation: constants.ImageBuilderBackendPreBuilt,
+			expected:   constants.ImageBuilderBackendPreBuilt,
+		},
+		{
+			annotation:  "Kaniko",
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			mosb := &mcfgv1.MachineOSBuild{}
+			if testCase.annotation != "" {
+				mosb.Annotations = map[string]string{constants.ImageBuilderBackendAnnotationKey: testCase.annotation}
+			}
+
+			backend, err := GetImageBuilderBackend(mosb)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, backend)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
@@ -143,16 +144,64 @@ func (j *jobImageBuilder) start(ctx context.Context) (*batchv1.Job, error) {
 				return nil, err
 			}
 		}
+
+		if err := j.startArchitectureBuilds(ctx, bj); err != nil {
+			return nil, err
+		}
+
 		return bj, nil
 	}
 
 	if k8serrors.IsAlreadyExists(err) {
-		return j.getBuildJobStrict(ctx)
+		bj, err := j.getBuildJobStrict(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// The controller may have stopped after creating the build Job but
+		// before creating the per-architecture Jobs it waits for.
+		if err := j.startArchitectureBuilds(ctx, bj); err != nil {
+			return nil, err
+		}
+
+		return bj, nil
 	}
 
 	return nil, fmt.Errorf("could not create build job: %w", err)
 }
 
+// Creates the per-architecture build Jobs of a multi-architecture build. They
+// are owned by the build Job so that they are stopped and cleaned up with it.
+// Jobs which already exist are left alone.
+func (j *jobImageBuilder) startArchitectureBuilds(ctx context.Context, buildJob *batchv1.Job) error {
+	builders, err := j.buildrequest.ArchitectureBuilders()
+	if err != nil {
+		return err
+	}
+
+	oref := metav1.NewControllerRef(buildJob, batchv1.SchemeGroupVersion.WithKind("Job"))
+	falseBool := false
+	oref.BlockOwnerDeletion = &falseBool
+
+	for _, builder := range builders {
+		archJob := builder.GetObject().(*batchv1.Job)
+		archJob.SetOwnerReferences([]metav1.OwnerReference{*oref})
+
+		_, err := j.kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Create(ctx, archJob, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not create build job %s: %w", archJob.Name, err)
+		}
+
+		klog.Infof("Build job %q created for architecture %q", archJob.Name, archJob.Labels[constants.BuildArchitectureLabelKey])
+	}
+
+	return nil
+}
+
 // Gets the build job, returning any errors in the process.
 func (j *jobImageBuilder) getBuildJobStrict(ctx context.Context) (*batchv1.Job, error) {
 	if j.getBuilderName() == "" {
@@ -337,6 +386,22 @@ func MapJobStatusToBuildStatus(job *batchv1.Job) (mcfgv1.BuildProgress, []metav1
 	if job.Status.Active == 0 && job.Status.Succeeded == 0 && job.Status.Failed == 0 && job.Status.UncountedTerminatedPods == nil {
 		return mcfgv1.MachineOSBuildPrepared, apihelpers.MachineOSBuildPendingConditions()
 	}
+
+	// The pod failure policy fails the job without using up its retries when
+	// the built image did not pass validation or, for multi-architecture
+	// builds, when one of the per-architecture builds failed.
+	if condition := getJobPodFailurePolicyCondition(job); condition != nil {
+		if !strings.Contains(condition.Message, constants.ValidationContainerName) {
+			return mcfgv1.MachineOSBuildFailed, apihelpers.MachineOSBuildFailedConditions()
+		}
+
+		return mcfgv1.MachineOSBuildFailed, append(apihelpers.MachineOSBuildFailedConditions(), metav1.Condition{
+			Type:    constants.MachineOSBuildValidated,
+			Status:  metav1.ConditionFalse,
+			Reason:  constants.ReasonValidationFailed,
+			Message: condition.Message,
+		})
+	}
 	// The build job is still running till it succeeds or maxes out it retries on failures
 	if job.Status.Active >= 0 && job.Status.Failed >= 0 && job.Status.Failed < constants.JobMaxRetries+1 && job.Status.Succeeded == 0 {
 		return mcfgv1.MachineOSBuilding, apihelpers.MachineOSBuildRunningConditions()
@@ -371,6 +436,17 @@ func MapJobStatusToBuildStatus(job *batchv1.Job) (mcfgv1.BuildProgress, []metav1
 	return "", apihelpers.MachineOSBuildInitialConditions()
 }
 
+// Gets the JobFailed condition if the job was failed by its pod failure policy.
+func getJobPodFailurePolicyCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue && condition.Reason == batchv1.JobReasonPodFailurePolicy {
+			return &condition
+		}
+	}
+
+	return nil
+}
+
 // Returns true if the provided job UID matches the job UID annotation in the provided MachineOSBuild
 func jobIsForMOSB(job *batchv1.Job, mosb *mcfgv1.MachineOSBuild) bool {
 	if mosb == nil {
@@ -7,6 +7,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -254,6 +257,53 @@ func TestJobImageBuilderCanCleanWithOnlyMachineOSBuild(t *testing.T) {
 	assertObjectsAreRemovedByCleaner(ctx, t, kubeassert, buildReq)
 }
 
+func TestJobImageBuilderStartsArchitectureBuilds(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	kubeclient, mcfgclient, _, _, lobj, kubeassert := fixtures.GetClientsForTest(t)
+	kubeassert = kubeassert.WithContext(ctx)
+
+	metav1.SetMetaDataAnnotation(&lobj.MachineOSConfig.ObjectMeta, constants.BuildArchitecturesAnnotationKey, "amd64,arm64")
+	lobj.MachineOSBuild.Spec.RenderedImagePushSpec = "registry.hostname.com/org/repo:latest"
+
+	jim := NewJobImageBuilder(kubeclient, mcfgclient, lobj.MachineOSBuild, lobj.MachineOSConfig)
+
+	assert.NoError(t, jim.Start(ctx))
+
+	buildJob, err := kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Get(ctx, utils.GetBuildJobName(lobj.MachineOSBuild), metav1.GetOptions{})
+	require.NoError(t, err)
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		archJobName := utils.GetArchitectureBuildJobName(lobj.MachineOSBuild, arch)
+		kubeassert.JobExists(archJobName)
+
+		archJob, err := kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Get(ctx, archJobName, metav1.GetOptions{})
+		require.NoError(t, err)
+
+		// The per-architecture Jobs are owned by the build Job so that they
+		// are removed along with it.
+		owner := metav1.GetControllerOf(archJob)
+		require.NotNil(t, owner)
+		assert.Equal(t, buildJob.Name, owner.Name)
+		assert.Equal(t, "Job", owner.Kind)
+	}
+
+	// Starting the build again is a no-op.
+	assert.NoError(t, jim.Start(ctx))
+
+	// If the controller stopped before creating a per-architecture Job,
+	// starting the build again creates it.
+	arm64JobName := utils.GetArchitectureBuildJobName(lobj.MachineOSBuild, "arm64")
+	require.NoError(t, kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Delete(ctx, arm64JobName, metav1.DeleteOptions{}))
+	kubeassert.JobDoesNotExist(arm64JobName)
+
+	assert.NoError(t, jim.Start(ctx))
+	kubeassert.JobExists(arm64JobName)
+}
+
 func TestJobImageBuilderSetsBuildStartAndEndTimestamp(t *testing.T) {
 	t.Parallel()
 
@@ -288,3 +338,102 @@ func TestJobImageBuilderSetsBuildStartAndEndTimestamp(t *testing.T) {
 	assert.GreaterOrEqual(t, status.BuildEnd.Time.Sub(status.BuildStart.Time), time.Second*60)
 	assert.Equal(t, status.BuildStart.Time, jobStartTime)
 }
+
+func TestGetBuildCacheCondition(t *testing.T) {
+	t.Parallel()
+
+	newConfigMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{Data: data}
+	}
+
+	assert.Nil(t, getBuildCacheCondition(newConfigMap(map[string]string{"digest": "sha256:abcd"})))
+	assert.Nil(t, getBuildCacheCondition(newConfigMap(map[string]string{"cacheHits": "lots", "cacheSteps": "5\n"})))
+
+	hit := getBuildCacheCondition(newConfigMap(map[string]string{"cacheHits": "3\n", "cacheSteps": "5\n"}))
+	require.NotNil(t, hit)
+	assert.Equal(t, constants.MachineOSBuildCacheUsed, hit.Type)
+	assert.Equal(t, metav1.ConditionTrue, hit.Status)
+	assert.Equal(t, constants.ReasonBuildCacheHit, hit.Reason)
+	assert.Equal(t, "3 of 5 build steps were served from the build cache", hit.Message)
+
+	miss := getBuildCacheCondition(newConfigMap(map[string]string{"cacheHits": "0\n", "cacheSteps": "5\n"}))
+	require.NotNil(t, miss)
+	assert.Equal(t, metav1.ConditionFalse, miss.Status)
+	assert.Equal(t, constants.ReasonBuildCacheMiss, miss.Reason)
+}
+
+func TestGetSupplyChainConditions(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, getSupplyChainConditions(&corev1.ConfigMap{Data: map[string]string{"digest": "sha256:abcd"}}))
+
+	conditions := getSupplyChainConditions(&corev1.ConfigMap{
+		Data: map[string]string{
+			"digest":          "sha256:abcd",
+			"sbomDigest":      "sha256:5b0e\n",
+			"signatureDigest": "sha256:9f1c\n",
+		},
+	})
+	require.Len(t, conditions, 2)
+
+	assert.Equal(t, constants.MachineOSBuildSBOMAttached, conditions[0].Type)
+	assert.Equal(t, metav1.ConditionTrue, conditions[0].Status)
+	assert.Equal(t, constants.ReasonSBOMAttached, conditions[0].Reason)
+	assert.Equal(t, "sha256:5b0e", conditions[0].Message)
+
+	assert.Equal(t, constants.MachineOSBuildImageSigned, conditions[1].Type)
+	assert.Equal(t, constants.ReasonImageSigned, conditions[1].Reason)
+	assert.Equal(t, "sha256:9f1c", conditions[1].Message)
+}
+
+func TestGetValidationCondition(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, getValidationCondition(&corev1.ConfigMap{Data: map[string]string{"digest": "sha256:abcd"}}))
+
+	condition := getValidationCondition(&corev1.ConfigMap{Data: map[string]string{"digest": "sha256:abcd", "validated": "true"}})
+	require.NotNil(t, condition)
+	assert.Equal(t, constants.MachineOSBuildValidated, condition.Type)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, constants.ReasonValidationPassed, condition.Reason)
+}
+
+func TestMapJobStatusToBuildStatusValidationFailed(t *testing.T) {
+	t.Parallel()
+
+	job := &batchv1.Job{
+		Status: batchv1.JobStatus{
+			Failed: 1,
+			Conditions: []batchv1.JobCondition{
+				{
+					Type:    batchv1.JobFailed,
+					Status:  corev1.ConditionTrue,
+					Reason:  batchv1.JobReasonPodFailurePolicy,
+					Message: "Container validate-image for pod openshift-machine-config-operator/build-worker failed with exit code 1 matching FailJob rule at index 0",
+				},
+			},
+		},
+	}
+
+	status, conditions := MapJobStatusToBuildStatus(job)
+	assert.Equal(t, mcfgv1.MachineOSBuildFailed, status)
+
+	validated := apimeta.FindStatusCondition(conditions, constants.MachineOSBuildValidated)
+	require.NotNil(t, validated)
+	assert.Equal(t, metav1.ConditionFalse, validated.Status)
+	assert.Equal(t, constants.ReasonValidationFailed, validated.Reason)
+	assert.Contains(t, validated.Message, "validate-image")
+
+	// A failed per-architecture build also fails the job straight away, but
+	// does not mean the image failed validation.
+	job.Status.Conditions[0].Message = "Container wait-for-architecture-builds for pod openshift-machine-config-operator/build-worker failed with exit code 3 matching FailJob rule at index 0"
+	status, conditions = MapJobStatusToBuildStatus(job)
+	assert.Equal(t, mcfgv1.MachineOSBuildFailed, status)
+	assert.Nil(t, apimeta.FindStatusCondition(conditions, constants.MachineOSBuildValidated))
+
+	// A single failed pod without a pod failure policy is retried.
+	job.Status.Conditions = nil
+	status, conditions = MapJobStatusToBuildStatus(job)
+	assert.Equal(t, mcfgv1.MachineOSBuilding, status)
+	assert.Nil(t, apimeta.FindStatusCondition(conditions, constants.MachineOSBuildValidated))
+}
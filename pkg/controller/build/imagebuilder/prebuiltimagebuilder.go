@@ -0,0 +1,156 @@
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/buildrequest"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// The image label our Containerfile sets to the rendered MachineConfig name.
+const machineConfigImageLabel = "machineconfig"
+
+// Implements ImageBuilder for images which were built elsewhere, such as by
+// CI. Instead of building anything, it validates the image named by the
+// promoted-image annotation and promotes it into the MachineOSBuild lifecycle
+// by recording the outcome directly on the MachineOSBuild status.
+type preBuiltImageBuilder struct {
+	*baseImageBuilder
+	inspect ImageInspectFunc
+}
+
+func newPreBuiltImageBuilder(kubeclient clientset.Interface, mcfgclient mcfgclientset.Interface, mosb *mcfgv1.MachineOSBuild, mosc *mcfgv1.MachineOSConfig, inspect ImageInspectFunc) *preBuiltImageBuilder {
+	return &preBuiltImageBuilder{
+		baseImageBuilder: newBaseImageBuilder(kubeclient, mcfgclient, mosb, mosc, nil),
+		inspect:          inspect,
+	}
+}
+
+// There is no Kube object backing a pre-built image.
+func (p *preBuiltImageBuilder) Get(_ context.Context) (buildrequest.Builder, error) {
+	return nil, p.addMachineOSBuildNameToError(fmt.Errorf("%s image builder backend has no builder object", constants.ImageBuilderBackendPreBuilt))
+}
+
+// Validates the pre-built image and records either its promotion or the reason
+// it was rejected on the MachineOSBuild.
+func (p *preBuiltImageBuilder) Start(ctx context.Context) error {
+	if p.mosb == nil {
+		return fmt.Errorf("pre-built image builder requires a MachineOSBuild")
+	}
+
+	status := p.promote(ctx)
+
+	mosb, err := p.mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, p.mosb.Name, metav1.GetOptions{})
+	if err != nil {
+		return p.addMachineOSBuildNameToError(fmt.Errorf("could not get MachineOSBuild: %w", err))
+	}
+
+	bs := ctrlcommon.NewMachineOSBuildState(mosb)
+	bs.SetBuildConditions(status.Conditions)
+	bs.Build.Status.DigestedImagePushSpec = status.DigestedImagePushSpec
+	bs.Build.Status.BuildStart = status.BuildStart
+	bs.Build.Status.BuildEnd = status.BuildEnd
+
+	if _, err := p.mcfgclient.MachineconfigurationV1().MachineOSBuilds().UpdateStatus(ctx, bs.Build, metav1.UpdateOptions{}); err != nil {
+		return p.addMachineOSBuildNameToError(fmt.Errorf("could not update status: %w", err))
+	}
+
+	p.mosb = bs.Build
+	return nil
+}
+
+// Computes the terminal status for the MachineOSBuild from the outcome of
+// validating the pre-built image.
+func (p *preBuiltImageBuilder) promote(ctx context.Context) mcfgv1.MachineOSBuildStatus {
+	out := mcfgv1.MachineOSBuildStatus{}
+
+	pullspec, err := p.validate(ctx)
+	if err != nil {
+		klog.Warningf("Rejecting pre-built image for MachineOSBuild %q: %s", p.mosb.Name, err)
+		out.Conditions = withConditionReason(apihelpers.MachineOSBuildFailedConditions(),
+			mcfgv1.MachineOSBuildFailed, constants.ReasonImagePromotionFailed, fmt.Sprintf("Could not promote pre-built image: %s", err))
+		out.BuildStart, out.BuildEnd = getBuildTimes(p.mosb, mcfgv1.MachineOSBuildFailed)
+		return out
+	}
+
+	klog.Infof("Promoting pre-built image %q for MachineOSBuild %q", pullspec, p.mosb.Name)
+	out.Conditions = withConditionReason(apihelpers.MachineOSBuildSucceededConditions(),
+		mcfgv1.MachineOSBuildSucceeded, constants.ReasonImagePromoted, fmt.Sprintf("Promoted pre-built image %q", pullspec))
+	out.DigestedImagePushSpec = mcfgv1.ImageDigestFormat(pullspec)
+	out.BuildStart, out.BuildEnd = getBuildTimes(p.mosb, mcfgv1.MachineOSBuildSucceeded)
+	return out
+}
+
+// Ensures that the pre-built image is referenced by digest, that it can be
+// pulled with the rendered image push secret, and that it was built for the
+// rendered MachineConfig of this MachineOSBuild if it says which one it was
+// built for.
+func (p *preBuiltImageBuilder) validate(ctx context.Context) (string, error) {
+	pullspec := p.mosb.GetAnnotations()[constants.PromotedImageAnnotationKey]
+	if pullspec == "" {
+		return "", fmt.Errorf("missing %q annotation", constants.PromotedImageAnnotationKey)
+	}
+
+	named, err := reference.ParseNormalizedNamed(pullspec)
+	if err != nil {
+		return "", fmt.Errorf("invalid image pullspec %q: %w", pullspec, err)
+	}
+
+	if _, ok := named.(reference.Digested); !ok {
+		return "", fmt.Errorf("expected a pullspec with a SHA256 digest, got %q", pullspec)
+	}
+
+	if p.inspect == nil {
+		return pullspec, nil
+	}
+
+	info, err := p.inspect(ctx, pullspec, p.mosb)
+	if err != nil {
+		return "", fmt.Errorf("could not inspect image %q: %w", pullspec, err)
+	}
+
+	if builtFor, ok := info.Labels[machineConfigImageLabel]; ok && builtFor != p.mosb.Spec.MachineConfig.Name {
+		return "", fmt.Errorf("image %q was built for MachineConfig %q, expected %q", pullspec, builtFor, p.mosb.Spec.MachineConfig.Name)
+	}
+
+	return pullspec, nil
+}
+
+// A pre-built image is considered to exist once its outcome has been recorded.
+func (p *preBuiltImageBuilder) Exists(_ context.Context) (bool, error) {
+	return ctrlcommon.NewMachineOSBuildState(p.mosb).IsInTerminalState(), nil
+}
+
+// Gets the progress recorded on the MachineOSBuild.
+func (p *preBuiltImageBuilder) Status(_ context.Context) (mcfgv1.BuildProgress, error) {
+	bs := ctrlcommon.NewMachineOSBuildState(p.mosb)
+	if bs.IsInTerminalState() {
+		return bs.GetTerminalState(), nil
+	}
+
+	return "", nil
+}
+
+// Gets the status recorded on the MachineOSBuild.
+func (p *preBuiltImageBuilder) MachineOSBuildStatus(_ context.Context) (mcfgv1.MachineOSBuildStatus, error) {
+	return *p.mosb.Status.DeepCopy(), nil
+}
+
+// Nothing runs for a pre-built image, so there is nothing to stop.
+func (p *preBuiltImageBuilder) Stop(_ context.Context) error {
+	return nil
+}
+
+// Nothing is created for a pre-built image, so there is nothing to clean up.
+func (p *preBuiltImageBuilder) Clean(_ context.Context) error {
+	return nil
+}
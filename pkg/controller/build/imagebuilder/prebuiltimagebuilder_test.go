@@ -0,0 +1,153 @@
+package imagebuilder
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestPreBuiltImageBuilder(t *testing.T) {
+	t.Parallel()
+
+	digestedPullspec := "quay.io/org/ci-built@sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5"
+
+	inspectWithLabels := func(labels map[string]string) ImageInspectFunc {
+		return func(_ context.Context, _ string, _ *mcfgv1.MachineOSBuild) (*types.ImageInspectInfo, error) {
+			return &types.ImageInspectInfo{Labels: labels}, nil
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		pullspec      string
+		inspect       ImageInspectFunc
+		errorContains string
+	}{
+		{
+			name:     "Digested image without inspection",
+			pullspec: digestedPullspec,
+		},
+		{
+			name:     "Image built for the rendered MachineConfig",
+			pullspec: digestedPullspec,
+			inspect: func(_ context.Context, _ string, mosb *mcfgv1.MachineOSBuild) (*types.ImageInspectInfo, error) {
+				return &types.ImageInspectInfo{Labels: map[string]string{machineConfigImageLabel: mosb.Spec.MachineConfig.Name}}, nil
+			},
+		},
+		{
+			name:     "Image without a machineconfig label",
+			pullspec: digestedPullspec,
+			inspect:  inspectWithLabels(nil),
+		},
+		{
+			name:          "Missing annotation",
+			errorContains: "missing",
+		},
+		{
+			name:          "Tagged image",
+			pullspec:      "quay.io/org/ci-built:latest",
+			errorContains: "expected a pullspec with a SHA256 digest",
+		},
+		{
+			name:     "Image cannot be inspected",
+			pullspec: digestedPullspec,
+			inspect: func(_ context.Context, _ string, _ *mcfgv1.MachineOSBuild) (*types.ImageInspectInfo, error) {
+				return nil, fmt.Errorf("manifest unknown")
+			},
+			errorContains: "manifest unknown",
+		},
+		{
+			name:          "Image built for another MachineConfig",
+			pullspec:      digestedPullspec,
+			inspect:       inspectWithLabels(map[string]string{machineConfigImageLabel: "rendered-worker-other"}),
+			errorContains: `was built for MachineConfig "rendered-worker-other"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			t.Cleanup(cancel)
+
+			kubeclient, mcfgclient, _, _, lobj, _ := fixtures.GetClientsForTest(t)
+
+			mosb := lobj.MachineOSBuild.DeepCopy()
+			mosb.Annotations[constants.ImageBuilderBackendAnnotationKey] = constants.ImageBuilderBackendPreBuilt
+			if testCase.pullspec != "" {
+				mosb.Annotations[constants.PromotedImageAnnotationKey] = testCase.pullspec
+			}
+
+			mosb, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Create(ctx, mosb, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			builder, err := NewImageBuilder(kubeclient, mcfgclient, mosb, lobj.MachineOSConfig, testCase.inspect)
+			require.NoError(t, err)
+			assert.False(t, IsPolledImageBuilderBackend(mosb))
+
+			exists, err := builder.Exists(ctx)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			require.NoError(t, builder.Start(ctx))
+
+			mosb, err = mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+
+			assert.NotNil(t, mosb.Status.BuildEnd)
+			assert.True(t, mosb.Status.BuildStart.Before(mosb.Status.BuildEnd))
+
+			bs := ctrlcommon.NewMachineOSBuildState(mosb)
+
+			if testCase.errorContains != "" {
+				assert.True(t, bs.IsBuildFailure())
+				assert.Empty(t, mosb.Status.DigestedImagePushSpec)
+
+				failed := apihelpers.GetMachineOSBuildCondition(mosb.Status, mcfgv1.MachineOSBuildFailed)
+				require.NotNil(t, failed)
+				assert.Equal(t, constants.ReasonImagePromotionFailed, failed.Reason)
+				assert.Contains(t, failed.Message, testCase.errorContains)
+			} else {
+				assert.True(t, bs.IsBuildSuccess())
+				assert.Equal(t, testCase.pullspec, string(mosb.Status.DigestedImagePushSpec))
+
+				succeeded := apihelpers.GetMachineOSBuildCondition(mosb.Status, mcfgv1.MachineOSBuildSucceeded)
+				require.NotNil(t, succeeded)
+				assert.Equal(t, constants.ReasonImagePromoted, succeeded.Reason)
+			}
+
+			// Observers pick up the recorded outcome from the MachineOSBuild.
+			observer, err := NewImageBuildObserver(kubeclient, mcfgclient, mosb, lobj.MachineOSConfig)
+			require.NoError(t, err)
+
+			exists, err = observer.Exists(ctx)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+
+			progress, err := observer.Status(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, bs.GetTerminalState(), progress)
+
+			status, err := observer.MachineOSBuildStatus(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, mosb.Status, status)
+
+			cleaner, err := NewImageBuildCleaner(kubeclient, mcfgclient, mosb)
+			require.NoError(t, err)
+			assert.NoError(t, cleaner.Clean(ctx))
+		})
+	}
+}
@@ -70,7 +70,8 @@ func TestPreparer(t *testing.T) {
 	// c3 uses the Builder object from the BuildRequest instead so that we can
 	// ensure that ephemeral build objects will be removed even if only the Builder object
 	// is available.
-	builder3 := br3.Builder()
+	builder3, err := br3.Builder()
+	require.NoError(t, err)
 	// Set the UID for the builder so that we can ensure that ephemeral build objects
 	// will be removed even if only the Builder object is available.
 	builder3.SetUID(types.UID(fixtures.JobUID))
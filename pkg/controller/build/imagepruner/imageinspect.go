@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/types"
 	digest "github.com/opencontainers/go-digest"
 	"github.com/openshift/machine-config-operator/pkg/imageutils"
@@ -30,11 +31,20 @@ type ImageDeleter interface {
 	DeleteImage(context.Context, *types.SystemContext, string) error
 }
 
-// ImageInspectorDeleter defines an interface that can both inspect and delete a container image,
-// combining the functionalities of ImageInspector and ImageDeleter.
+// ImageTagLister defines the interface for listing the tags in an image repository.
+type ImageTagLister interface {
+	// ListImageTags lists the tags in the repository of the specified image using the
+	// provided system context. Any tag or digest on the image is ignored.
+	ListImageTags(context.Context, *types.SystemContext, string) ([]string, error)
+}
+
+// ImageInspectorDeleter defines an interface that can inspect and delete a container image
+// and list the tags in its repository, combining the functionalities of ImageInspector,
+// ImageDeleter, and ImageTagLister.
 type ImageInspectorDeleter interface {
 	ImageInspector
 	ImageDeleter
+	ImageTagLister
 }
 
 // imageInspectorImpl is the real image inspector implementation which is wired up with
@@ -56,6 +66,12 @@ func (i *imageInspectorImpl) DeleteImage(ctx context.Context, sysCtx *types.Syst
 	return deleteImage(ctx, sysCtx, image)
 }
 
+// ListImageTags uses the provided system context to list the tags in the repository of the
+// provided image pullspec.
+func (i *imageInspectorImpl) ListImageTags(ctx context.Context, sysCtx *types.SystemContext, image string) ([]string, error) {
+	return listImageTags(ctx, sysCtx, image)
+}
+
 // deleteImage attempts to delete the specified image with retries,
 // using the provided context and system context.
 //
@@ -109,3 +125,26 @@ func imageInspect(ctx context.Context, sysCtx *types.SystemContext, imageName st
 	}
 	return inspectInfo, &imageDigest, nil
 }
+
+// listImageTags lists the tags in the repository of the specified image with retries,
+// using the provided context and system context.
+func listImageTags(ctx context.Context, sysCtx *types.SystemContext, imageName string) ([]string, error) {
+	retryOpts := retry.RetryOptions{
+		MaxRetry: cmdRetriesCount,
+	}
+
+	ref, err := imageutils.ParseImageName(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	if err := retry.IfNecessary(ctx, func() error {
+		tags, err = docker.GetRepositoryTags(ctx, sysCtx, ref)
+		return err
+	}, &retryOpts); err != nil {
+		return nil, newErrImage(imageName, err)
+	}
+
+	return tags, nil
+}
@@ -21,6 +21,9 @@ type ImagePruner interface {
 	// DeleteImage deletes the given image using the provided secret and ControllerConfig.
 	// It returns an error if the deletion fails.
 	DeleteImage(context.Context, string, *corev1.Secret, *mcfgv1.ControllerConfig) error
+	// ListImageTags lists the tags in the repository of the given image using the provided
+	// secret and ControllerConfig. It returns an error if the tags cannot be listed.
+	ListImageTags(context.Context, string, *corev1.Secret, *mcfgv1.ControllerConfig) ([]string, error)
 }
 
 // imagePrunerImpl holds the real ImagePruner implementation, utilizing an ImageInspectorDeleter.
@@ -77,3 +80,26 @@ func (i *imagePrunerImpl) DeleteImage(ctx context.Context, pullspec string, secr
 
 	return nil
 }
+
+// ListImageTags lists the tags in the repository of the given image using the provided
+// secret. It also accepts a ControllerConfig so that certificates may be placed on the
+// filesystem for authentication.
+func (i *imagePrunerImpl) ListImageTags(ctx context.Context, pullspec string, secret *corev1.Secret, cc *mcfgv1.ControllerConfig) ([]string, error) {
+	sysCtx, err := imageutils.NewSysContextBuilder().WithSecret(secret).WithControllerConfig(cc).Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare for listing image tags: %w", err)
+	}
+
+	defer func() {
+		if err := sysCtx.Cleanup(); err != nil {
+			klog.Warningf("Unable to clean up after listing tags for %s: %s", pullspec, err)
+		}
+	}()
+
+	tags, err := i.images.ListImageTags(ctx, sysCtx.SysContext, pullspec)
+	if err != nil {
+		return nil, fmt.Errorf("could not list image tags: %w", err)
+	}
+
+	return tags, nil
+}
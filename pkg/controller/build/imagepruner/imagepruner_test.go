@@ -24,6 +24,9 @@ type fakeImageInspector struct {
 	deleteImageCalled    bool
 	deleteImagePullspec  string
 	deleteImageSysCtx    *types.SystemContext
+	listTagsCalled       bool
+	listTagsPullspec     string
+	tags                 []string
 	imageApiError        bool
 }
 
@@ -47,6 +50,16 @@ func (f *fakeImageInspector) DeleteImage(_ context.Context, sysCtx *types.System
 	return nil
 }
 
+// ListImageTags is a mock implementation for testing, setting flags to indicate it was called.
+func (f *fakeImageInspector) ListImageTags(_ context.Context, sysCtx *types.SystemContext, pullspec string) ([]string, error) {
+	f.listTagsCalled = true
+	f.listTagsPullspec = pullspec
+	if f.imageApiError {
+		return nil, fmt.Errorf("fake list tags error")
+	}
+	return f.tags, nil
+}
+
 // TestImagePruner is a unit test that validates the ImagePruner's setup and teardown
 // of temporary directories for authfiles and certificates. It tests both
 // DockerConfigJSON and Dockercfg secret types.
@@ -123,6 +136,7 @@ func TestImagePruner(t *testing.T) {
 
 			fii := &fakeImageInspector{
 				imageApiError: testCase.imageError,
+				tags:          []string{"latest", "other"},
 			}
 			ip := &imagePrunerImpl{
 				images: fii,
@@ -160,6 +174,22 @@ func TestImagePruner(t *testing.T) {
 				assert.True(t, fii.deleteImageCalled)
 				assert.Equal(t, fii.deleteImagePullspec, pullspec)
 			}
+
+			tags, err := ip.ListImageTags(ctx, pullspec, testCase.inputSecret, cc)
+			if testCase.imageError {
+				assert.Error(t, err)
+				// SysContext context created but the API call failed
+				assert.True(t, fii.listTagsCalled)
+			} else if testCase.authError {
+				assert.Error(t, err)
+				// The API called wasn't performed cause the logic couldn't reach that point
+				assert.False(t, fii.listTagsCalled)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, fii.listTagsCalled)
+				assert.Equal(t, fii.listTagsPullspec, pullspec)
+				assert.Equal(t, []string{"latest", "other"}, tags)
+			}
 		})
 	}
 }
@@ -0,0 +1,457 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/imagebuilder"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/imagepruner"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconstants "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// imageRetentionPolicy holds the image retention policy configured on a
+// MachineOSConfig.
+type imageRetentionPolicy struct {
+	// keepCount is the number of most recent successful builds to keep. Zero
+	// means that every successful build other than the current one is
+	// considered superseded.
+	keepCount int
+	// ttl is how long failed and superseded builds are kept after they finish.
+	ttl          time.Duration
+	pruneOrphans bool
+}
+
+// prunesBuilds determines if the policy deletes any MachineOSBuilds, as
+// opposed to only pruning orphaned images.
+func (p *imageRetentionPolicy) prunesBuilds() bool {
+	return p.keepCount > 0 || p.ttl > 0
+}
+
+// hasImageRetentionPolicy determines if a MachineOSConfig has any image
+// retention policy annotations set.
+func hasImageRetentionPolicy(mosc *mcfgv1.MachineOSConfig) bool {
+	return metav1.HasAnnotation(mosc.ObjectMeta, constants.ImageRetentionCountAnnotationKey) ||
+		metav1.HasAnnotation(mosc.ObjectMeta, constants.ImageRetentionTTLAnnotationKey) ||
+		metav1.HasAnnotation(mosc.ObjectMeta, constants.ImageRetentionPruneOrphansAnnotationKey)
+}
+
+// getImageRetentionPolicy parses the image retention policy annotations on a
+// MachineOSConfig. Returns nil if no image retention policy is configured.
+func getImageRetentionPolicy(mosc *mcfgv1.MachineOSConfig) (*imageRetentionPolicy, error) {
+	policy := &imageRetentionPolicy{}
+
+	if val := strings.TrimSpace(mosc.Annotations[constants.ImageRetentionCountAnnotationKey]); val != "" {
+		count, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", constants.ImageRetentionCountAnnotationKey, val, err)
+		}
+
+		if count < 1 {
+			return nil, fmt.Errorf("invalid %s annotation %q: must be at least 1", constants.ImageRetentionCountAnnotationKey, val)
+		}
+
+		policy.keepCount = count
+	}
+
+	if val := strings.TrimSpace(mosc.Annotations[constants.ImageRetentionTTLAnnotationKey]); val != "" {
+		ttl, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", constants.ImageRetentionTTLAnnotationKey, val, err)
+		}
+
+		if ttl <= 0 {
+			return nil, fmt.Errorf("invalid %s annotation %q: must be positive", constants.ImageRetentionTTLAnnotationKey, val)
+		}
+
+		policy.ttl = ttl
+	}
+
+	if val, ok := mosc.Annotations[constants.ImageRetentionPruneOrphansAnnotationKey]; ok {
+		pruneOrphans, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", constants.ImageRetentionPruneOrphansAnnotationKey, val, err)
+		}
+
+		policy.pruneOrphans = pruneOrphans
+	}
+
+	if !policy.prunesBuilds() && !policy.pruneOrphans {
+		return nil, nil
+	}
+
+	return policy, nil
+}
+
+// getBuildFinishTime returns when the given MachineOSBuild finished, falling
+// back to when it was created.
+func getBuildFinishTime(mosb *mcfgv1.MachineOSBuild) time.Time {
+	if mosb.Status.BuildEnd != nil {
+		return mosb.Status.BuildEnd.Time
+	}
+
+	return mosb.CreationTimestamp.Time
+}
+
+// selectMachineOSBuildsToPrune returns the MachineOSBuilds which the given
+// policy no longer retains. In-progress builds, the current build, and builds
+// whose digested image is in the protected set or shared with a retained build
+// are never selected.
+func selectMachineOSBuildsToPrune(mosbs []*mcfgv1.MachineOSBuild, policy *imageRetentionPolicy, currentBuild string, protected sets.Set[string], now time.Time) []*mcfgv1.MachineOSBuild {
+	sorted := make([]*mcfgv1.MachineOSBuild, len(mosbs))
+	copy(sorted, mosbs)
+
+	// Newest first so that the most recent successful builds are retained.
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].CreationTimestamp.Equal(&sorted[j].CreationTimestamp) {
+			return sorted[j].CreationTimestamp.Before(&sorted[i].CreationTimestamp)
+		}
+
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	retainedImages := sets.New[string]()
+	candidates := []*mcfgv1.MachineOSBuild{}
+	successful := 0
+
+	for _, mosb := range sorted {
+		image := string(mosb.Status.DigestedImagePushSpec)
+		state := ctrlcommon.NewMachineOSBuildState(mosb)
+
+		retain := func() {
+			if image != "" {
+				retainedImages.Insert(image)
+			}
+		}
+
+		if state.IsBuildSuccess() {
+			successful++
+		}
+
+		if mosb.Name == currentBuild || !state.IsInTerminalState() {
+			retain()
+			continue
+		}
+
+		if state.IsBuildSuccess() && successful <= policy.keepCount {
+			retain()
+			continue
+		}
+
+		if now.Sub(getBuildFinishTime(mosb)) < policy.ttl {
+			retain()
+			continue
+		}
+
+		if image != "" && protected.Has(image) {
+			klog.V(4).Infof("Image %s for MachineOSBuild %q is booted or desired by a node, retaining it", image, mosb.Name)
+			retain()
+			continue
+		}
+
+		candidates = append(candidates, mosb)
+	}
+
+	// A MachineOSBuild may reuse the image of an earlier one, so an image is
+	// only pruned once no retained build refers to it.
+	out := []*mcfgv1.MachineOSBuild{}
+	for _, mosb := range candidates {
+		if image := string(mosb.Status.DigestedImagePushSpec); image != "" && retainedImages.Has(image) {
+			continue
+		}
+
+		out = append(out, mosb)
+	}
+
+	return out
+}
+
+// getProtectedImages returns the digested pullspecs of the images which are
+// booted or desired by any node, according to both the MachineConfigNodes and
+// the node annotations, along with the current image of the MachineOSConfig.
+func (b *buildReconciler) getProtectedImages(ctx context.Context, mosc *mcfgv1.MachineOSConfig) (sets.Set[string], error) {
+	protected := sets.New[string]()
+
+	insert := func(images ...string) {
+		for _, image := range images {
+			if image != "" {
+				protected.Insert(image)
+			}
+		}
+	}
+
+	insert(string(mosc.Status.CurrentImagePullSpec))
+
+	mcns, err := b.mcfgclient.MachineconfigurationV1().MachineConfigNodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list MachineConfigNodes: %w", err)
+	}
+
+	for _, mcn := range mcns.Items {
+		insert(string(mcn.Status.ConfigImage.CurrentImage), string(mcn.Status.ConfigImage.DesiredImage), string(mcn.Spec.ConfigImage.DesiredImage))
+	}
+
+	nodes, err := b.listers.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list nodes: %w", err)
+	}
+
+	for _, node := range nodes {
+		insert(node.GetAnnotations()[daemonconstants.CurrentImageAnnotationKey], node.GetAnnotations()[daemonconstants.DesiredImageAnnotationKey])
+	}
+
+	return protected, nil
+}
+
+// Evaluates the image retention policy for the given MachineOSConfig. Any
+// MachineOSBuilds which are no longer retained are deleted along with their
+// images and, if enabled, orphaned images in the push repository are pruned.
+func (b *buildReconciler) evaluateImageRetentionPolicy(ctx context.Context, mosc *mcfgv1.MachineOSConfig, now time.Time) error {
+	mosc, err := b.machineOSConfigLister.Get(mosc.Name)
+	if err != nil {
+		return ignoreErrIsNotFound(fmt.Errorf("could not evaluate image retention policy: %w", err))
+	}
+
+	policy, err := getImageRetentionPolicy(mosc)
+	if err != nil {
+		return fmt.Errorf("could not get image retention policy for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	protected, err := b.getProtectedImages(ctx, mosc)
+	if err != nil {
+		return fmt.Errorf("could not get protected images for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	if policy.prunesBuilds() {
+		mosbs, err := b.getMachineOSBuildsForMachineOSConfig(mosc)
+		if err != nil {
+			return err
+		}
+
+		for _, mosb := range selectMachineOSBuildsToPrune(mosbs, policy, mosc.Annotations[constants.CurrentMachineOSBuildAnnotationKey], protected, now) {
+			klog.Infof("Image retention policy for MachineOSConfig %q no longer retains MachineOSBuild %q", mosc.Name, mosb.Name)
+			if err := b.deleteMachineOSBuild(ctx, mosb); err != nil {
+				return fmt.Errorf("could not prune MachineOSBuild %q for MachineOSConfig %q: %w", mosb.Name, mosc.Name, err)
+			}
+		}
+	}
+
+	if !policy.pruneOrphans {
+		return nil
+	}
+
+	// Images promoted by the PreBuilt backend were built and pushed elsewhere,
+	// so they are not ours to delete.
+	backend, err := imagebuilder.GetImageBuilderBackend(mosc)
+	if err != nil {
+		return fmt.Errorf("could not evaluate image retention policy for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	if backend == constants.ImageBuilderBackendPreBuilt {
+		klog.V(4).Infof("MachineOSConfig %q uses the %s image builder backend, skipping orphaned image pruning", mosc.Name, constants.ImageBuilderBackendPreBuilt)
+		return nil
+	}
+
+	return b.pruneOrphanedImages(ctx, mosc, protected)
+}
+
+// getOrphanedImageTagRegexp returns a regexp matching the tags of the images
+// built for the given MachineOSConfig. These are named after their
+// MachineOSBuild, which is the MachineOSConfig name followed by a hash, and
+// multi-architecture builds append the architecture to them.
+func getOrphanedImageTagRegexp(mosc *mcfgv1.MachineOSConfig) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(mosc.Name) + `-([0-9a-f]{32})(-[a-z0-9]+)?$`)
+}
+
+// getOrphanedImageTags returns the tags which were built for the given
+// MachineOSConfig but whose MachineOSBuild no longer exists.
+func getOrphanedImageTags(mosc *mcfgv1.MachineOSConfig, tags []string, mosbs []*mcfgv1.MachineOSBuild) []string {
+	known := sets.New[string]()
+	for _, mosb := range mosbs {
+		known.Insert(mosb.Name)
+	}
+
+	tagRegexp := getOrphanedImageTagRegexp(mosc)
+
+	orphaned := []string{}
+	for _, tag := range tags {
+		matches := tagRegexp.FindStringSubmatch(tag)
+		if matches == nil {
+			continue
+		}
+
+		if known.Has(mosc.Name + "-" + matches[1]) {
+			continue
+		}
+
+		orphaned = append(orphaned, tag)
+	}
+
+	sort.Strings(orphaned)
+	return orphaned
+}
+
+// pruneOrphanedImages deletes the images in the push repository of the given
+// MachineOSConfig which are not tied to any MachineOSBuild, unless they are
+// booted or desired by a node or are the image of a MachineOSBuild.
+func (b *buildReconciler) pruneOrphanedImages(ctx context.Context, mosc *mcfgv1.MachineOSConfig, protected sets.Set[string]) error {
+	named, err := reference.ParseNamed(string(mosc.Spec.RenderedImagePushSpec))
+	if err != nil {
+		return fmt.Errorf("could not parse rendered image pushspec for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	repo := named.Name()
+
+	isOpenShiftRegistry, err := ctrlcommon.IsOpenShiftRegistry(ctx, repo, b.kubeclient, b.routeclient)
+	if err != nil {
+		return err
+	}
+
+	getObjects := func() (*corev1.Secret, *mcfgv1.ControllerConfig, error) {
+		return b.getObjectsForImagePrunerWithSecret(mosc.Spec.RenderedImagePushSecret.Name)
+	}
+
+	// Every MachineOSBuild is considered, not only those for the current
+	// MachineConfigPool, so that an image is never treated as orphaned while
+	// any MachineOSBuild still refers to it.
+	mosbs, err := b.machineOSBuildLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("could not list MachineOSBuilds: %w", err)
+	}
+
+	// An orphaned tag may point at the same image as a MachineOSBuild which
+	// still exists, such as when a later build produced an identical image.
+	// Deleting the tag would delete that image too.
+	protected = protected.Clone()
+	for _, mosb := range mosbs {
+		if image := string(mosb.Status.DigestedImagePushSpec); image != "" {
+			protected.Insert(image)
+		}
+	}
+
+	var digests map[string]string
+	if isOpenShiftRegistry {
+		digests, err = b.getInternalRegistryOrphanedImageDigests(ctx, mosc, repo, mosbs)
+	} else {
+		digests, err = b.getExternalRegistryOrphanedImageDigests(ctx, mosc, repo, mosbs, getObjects)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not list images in %s for MachineOSConfig %q: %w", repo, mosc.Name, err)
+	}
+
+	for _, tag := range sets.List(sets.KeySet(digests)) {
+		pullspec := repo + ":" + tag
+
+		// An image whose digest cannot be determined may be booted somewhere,
+		// so it is left alone.
+		if digests[tag] == "" {
+			klog.Warningf("Could not determine the digest of orphaned image %s for MachineOSConfig %q, will not delete", pullspec, mosc.Name)
+			continue
+		}
+
+		if protected.Has(repo + "@" + digests[tag]) {
+			klog.Infof("Orphaned image %s for MachineOSConfig %q is booted or desired by a node or used by a MachineOSBuild, will not delete", pullspec, mosc.Name)
+			continue
+		}
+
+		if err := b.deleteImageForObject(ctx, pullspec, mosc, getObjects); err != nil {
+			wrappedErr := fmt.Errorf("could not delete orphaned image %s for MachineOSConfig %q: %w", pullspec, mosc.Name, err)
+			if imagepruner.IsTolerableDeleteErr(err) || k8serrors.IsNotFound(err) {
+				klog.Warning(wrappedErr.Error())
+				continue
+			}
+
+			return wrappedErr
+		}
+
+		klog.Infof("Deleted orphaned image %s for MachineOSConfig %q", pullspec, mosc.Name)
+	}
+
+	return nil
+}
+
+// getInternalRegistryOrphanedImageDigests returns the digest of each orphaned
+// tag in the given internal registry repository, which is backed by an
+// ImageStream.
+func (b *buildReconciler) getInternalRegistryOrphanedImageDigests(ctx context.Context, mosc *mcfgv1.MachineOSConfig, repo string, mosbs []*mcfgv1.MachineOSBuild) (map[string]string, error) {
+	ns, name, err := extractNSAndNameWithTag(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	is, err := b.imageclient.ImageV1().ImageStreams(ns).Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	latest := map[string]string{}
+	for _, tag := range is.Status.Tags {
+		tags = append(tags, tag.Tag)
+		if len(tag.Items) != 0 {
+			latest[tag.Tag] = tag.Items[0].Image
+		}
+	}
+
+	digests := map[string]string{}
+	for _, tag := range getOrphanedImageTags(mosc, tags, mosbs) {
+		digests[tag] = latest[tag]
+	}
+
+	return digests, nil
+}
+
+// getExternalRegistryOrphanedImageDigests returns the digest of each orphaned
+// tag in the given external registry repository by listing and inspecting
+// them with the imagepruner.
+func (b *buildReconciler) getExternalRegistryOrphanedImageDigests(ctx context.Context, mosc *mcfgv1.MachineOSConfig, repo string, mosbs []*mcfgv1.MachineOSBuild, getObjects func() (*corev1.Secret, *mcfgv1.ControllerConfig, error)) (map[string]string, error) {
+	secret, cc, err := getObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := b.imagepruner.ListImageTags(ctx, repo, secret, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	digests := map[string]string{}
+	for _, tag := range getOrphanedImageTags(mosc, tags, mosbs) {
+		digests[tag] = ""
+
+		_, imgDigest, err := b.imagepruner.InspectImage(ctx, repo+":"+tag, secret, cc)
+		if err != nil {
+			klog.Warningf("Could not inspect orphaned image %s:%s: %s", repo, tag, err)
+			continue
+		}
+
+		if imgDigest != nil {
+			digests[tag] = imgDigest.String()
+		}
+	}
+
+	return digests, nil
+}
@@ -0,0 +1,454 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/apihelpers"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeRetentionImagePruner is an ImagePruner which serves a fixed set of tags
+// and digests and records which images were deleted.
+type fakeRetentionImagePruner struct {
+	mu      sync.Mutex
+	tags    []string
+	digests map[string]digest.Digest
+	deleted []string
+}
+
+func (f *fakeRetentionImagePruner) InspectImage(_ context.Context, pullspec string, _ *corev1.Secret, _ *mcfgv1.ControllerConfig) (*types.ImageInspectInfo, *digest.Digest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	d, ok := f.digests[pullspec]
+	if !ok {
+		return nil, nil, fmt.Errorf("image %s not found", pullspec)
+	}
+
+	return &types.ImageInspectInfo{}, &d, nil
+}
+
+func (f *fakeRetentionImagePruner) DeleteImage(_ context.Context, pullspec string, _ *corev1.Secret, _ *mcfgv1.ControllerConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.deleted = append(f.deleted, pullspec)
+	return nil
+}
+
+func (f *fakeRetentionImagePruner) ListImageTags(_ context.Context, _ string, _ *corev1.Secret, _ *mcfgv1.ControllerConfig) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.tags, nil
+}
+
+func (f *fakeRetentionImagePruner) getDeleted() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.deleted...)
+}
+
+func TestGetImageRetentionPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		annotations          map[string]string
+		expectedPolicy       bool
+		expectedKeepCount    int
+		expectedTTL          time.Duration
+		expectedPruneOrphans bool
+		errExpected          bool
+	}{
+		{
+			name: "No policy",
+		},
+		{
+			name:              "Count only",
+			annotations:       map[string]string{constants.ImageRetentionCountAnnotationKey: "3"},
+			expectedPolicy:    true,
+			expectedKeepCount: 3,
+		},
+		{
+			name:           "TTL only",
+			annotations:    map[string]string{constants.ImageRetentionTTLAnnotationKey: "168h"},
+			expectedPolicy: true,
+			expectedTTL:    time.Hour * 168,
+		},
+		{
+			name:                 "Prune orphans only",
+			annotations:          map[string]string{constants.ImageRetentionPruneOrphansAnnotationKey: "true"},
+			expectedPolicy:       true,
+			expectedPruneOrphans: true,
+		},
+		{
+			name: "Full policy",
+			annotations: map[string]string{
+				constants.ImageRetentionCountAnnotationKey:        "2",
+				constants.ImageRetentionTTLAnnotationKey:          "24h",
+				constants.ImageRetentionPruneOrphansAnnotationKey: "true",
+			},
+			expectedPolicy:       true,
+			expectedKeepCount:    2,
+			expectedTTL:          time.Hour * 24,
+			expectedPruneOrphans: true,
+		},
+		{
+			name:        "Prune orphans disabled",
+			annotations: map[string]string{constants.ImageRetentionPruneOrphansAnnotationKey: "false"},
+		},
+		{
+			name:        "Invalid count",
+			annotations: map[string]string{constants.ImageRetentionCountAnnotationKey: "three"},
+			errExpected: true,
+		},
+		{
+			name:        "Zero count",
+			annotations: map[string]string{constants.ImageRetentionCountAnnotationKey: "0"},
+			errExpected: true,
+		},
+		{
+			name:        "Invalid TTL",
+			annotations: map[string]string{constants.ImageRetentionTTLAnnotationKey: "a week"},
+			errExpected: true,
+		},
+		{
+			name:        "Negative TTL",
+			annotations: map[string]string{constants.ImageRetentionTTLAnnotationKey: "-1h"},
+			errExpected: true,
+		},
+		{
+			name:        "Invalid prune orphans",
+			annotations: map[string]string{constants.ImageRetentionPruneOrphansAnnotationKey: "sometimes"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			mosc := &mcfgv1.MachineOSConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "worker",
+					Annotations: testCase.annotations,
+				},
+			}
+
+			assert.Equal(t, len(testCase.annotations) != 0, hasImageRetentionPolicy(mosc))
+
+			policy, err := getImageRetentionPolicy(mosc)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			if !testCase.expectedPolicy {
+				assert.Nil(t, policy)
+				return
+			}
+
+			require.NotNil(t, policy)
+			assert.Equal(t, testCase.expectedKeepCount, policy.keepCount)
+			assert.Equal(t, testCase.expectedTTL, policy.ttl)
+			assert.Equal(t, testCase.expectedPruneOrphans, policy.pruneOrphans)
+		})
+	}
+}
+
+func TestSelectMachineOSBuildsToPrune(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	newMOSB := func(name string, age time.Duration, conditions []metav1.Condition) *mcfgv1.MachineOSBuild {
+		return &mcfgv1.MachineOSBuild{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Status: mcfgv1.MachineOSBuildStatus{
+				Conditions:            conditions,
+				DigestedImagePushSpec: mcfgv1.ImageDigestFormat("registry.hostname.com/org/repo@" + digest.FromString(name).String()),
+			},
+		}
+	}
+
+	withImage := func(mosb *mcfgv1.MachineOSBuild, image string) *mcfgv1.MachineOSBuild {
+		mosb.Status.DigestedImagePushSpec = mcfgv1.ImageDigestFormat(image)
+		return mosb
+	}
+
+	succeeded := apihelpers.MachineOSBuildSucceededConditions()
+	failed := apihelpers.MachineOSBuildFailedConditions()
+	running := apihelpers.MachineOSBuildRunningConditions()
+
+	testCases := []struct {
+		name      string
+		mosbs     []*mcfgv1.MachineOSBuild
+		policy    imageRetentionPolicy
+		current   string
+		protected []string
+		expected  []string
+	}{
+		{
+			name: "Keeps the newest successful builds",
+			mosbs: []*mcfgv1.MachineOSBuild{
+				newMOSB("current", time.Hour, succeeded),
+				newMOSB("previous", time.Hour*2, succeeded),
+				newMOSB("old", time.Hour*3, succeeded),
+				newMOSB("older", time.Hour*4, succeeded),
+			},
+			policy:   imageRetentionPolicy{keepCount: 2},
+			current:  "current",
+			expected: []string{"old", "older"},
+		},
+		{
+			name: "Keeps superseded and failed builds until their TTL expires",
+			mosbs: []*mcfgv1.MachineOSBuild{
+				newMOSB("current", time.Hour, succeeded),
+				newMOSB("recent", time.Hour*2, succeeded),
+				newMOSB("old", time.Hour*48, succeeded),
+				newMOSB("recent-failure", time.Hour*3, failed),
+				newMOSB("old-failure", time.Hour*30, failed),
+			},
+			policy:   imageRetentionPolicy{ttl: time.Hour * 24},
+			current:  "current",
+			expected: []string{"old-failure", "old"},
+		},
+		{
+			name: "Never prunes the current or in-progress builds",
+			mosbs: []*mcfgv1.MachineOSBuild{
+				newMOSB("running", time.Hour*48, running),
+				newMOSB("current", time.Hour*72, succeeded),
+			},
+			policy:   imageRetentionPolicy{keepCount: 1},
+			current:  "current",
+			expected: []string{},
+		},
+		{
+			name: "Never prunes protected images",
+			mosbs: []*mcfgv1.MachineOSBuild{
+				newMOSB("current", time.Hour, succeeded),
+				newMOSB("booted", time.Hour*2, succeeded),
+				newMOSB("old", time.Hour*3, succeeded),
+			},
+			policy:    imageRetentionPolicy{keepCount: 1},
+			current:   "current",
+			protected: []string{"registry.hostname.com/org/repo@" + digest.FromString("booted").String()},
+			expected:  []string{"old"},
+		},
+		{
+			name: "Never prunes images shared with a retained build",
+			mosbs: []*mcfgv1.MachineOSBuild{
+				withImage(newMOSB("current", time.Hour, succeeded), "registry.hostname.com/org/repo@"+digest.FromString("shared").String()),
+				withImage(newMOSB("reused", time.Hour*2, succeeded), "registry.hostname.com/org/repo@"+digest.FromString("shared").String()),
+				newMOSB("old", time.Hour*3, succeeded),
+			},
+			policy:   imageRetentionPolicy{keepCount: 1},
+			current:  "current",
+			expected: []string{"old"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := selectMachineOSBuildsToPrune(testCase.mosbs, &testCase.policy, testCase.current, sets.New(testCase.protected...), now)
+
+			names := []string{}
+			for _, mosb := range out {
+				names = append(names, mosb.Name)
+			}
+
+			assert.ElementsMatch(t, testCase.expected, names)
+		})
+	}
+}
+
+func TestGetOrphanedImageTags(t *testing.T) {
+	t.Parallel()
+
+	hash := func(in string) string {
+		return digest.FromString(in).Encoded()[:32]
+	}
+
+	mosc := &mcfgv1.MachineOSConfig{ObjectMeta: metav1.ObjectMeta{Name: "worker"}}
+
+	mosbs := []*mcfgv1.MachineOSBuild{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-" + hash("known")}},
+	}
+
+	tags := []string{
+		"latest",
+		"worker-" + hash("known"),
+		"worker-" + hash("known") + "-arm64",
+		"worker-" + hash("orphan"),
+		"worker-" + hash("orphan") + "-amd64",
+		"worker-extra-" + hash("other"),
+		"infra-" + hash("other"),
+		"sha256-" + digest.FromString("signed").Encoded() + ".sig",
+	}
+
+	assert.Equal(t, []string{
+		"worker-" + hash("orphan"),
+		"worker-" + hash("orphan") + "-amd64",
+	}, getOrphanedImageTags(mosc, tags, mosbs))
+}
+
+func TestEvaluateImageRetentionPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	kubeclient, mcfgclient, _, _, mosc, mosb, _, kubeassert, _, ctrl := setupOSBuildControllerForTestWithRunningBuild(ctx, t, "worker")
+
+	fixtures.SetJobStatus(ctx, t, kubeclient, mosb, fixtures.JobStatus{Succeeded: 1})
+	kubeassert.MachineOSBuildIsSuccessful(mosb)
+	kubeassert.JobDoesNotExist(utils.GetBuildJobName(mosb))
+
+	now := time.Now()
+	repo := "registry.hostname.com/org/repo"
+
+	hash := func(in string) string {
+		return digest.FromString(in).Encoded()[:32]
+	}
+
+	digestedImage := func(in string) string {
+		return repo + "@" + digest.FromString(in).String()
+	}
+
+	// The fake clients do not set a creation timestamp, so give the current
+	// build one that makes it the newest.
+	current, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	current.CreationTimestamp = metav1.NewTime(now)
+	_, err = mcfgclient.MachineconfigurationV1().MachineOSBuilds().Update(ctx, current, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// Creates an earlier MachineOSBuild for a rendered MachineConfig which the
+	// pool no longer targets, so that BuildController leaves it alone.
+	createMOSB := func(name string, age time.Duration, conditions []metav1.Condition) *mcfgv1.MachineOSBuild {
+		t.Helper()
+
+		old := current.DeepCopy()
+		old.ObjectMeta = metav1.ObjectMeta{
+			Name:              mosc.Name + "-" + hash(name),
+			Labels:            current.Labels,
+			Annotations:       current.Annotations,
+			CreationTimestamp: metav1.NewTime(now.Add(-age)),
+		}
+		old.Labels[constants.RenderedMachineConfigLabelKey] = "rendered-worker-" + name
+		old.Spec.RenderedImagePushSpec = mcfgv1.ImageTagFormat(repo + ":" + old.Name)
+		old.Status = mcfgv1.MachineOSBuildStatus{
+			Conditions:            conditions,
+			DigestedImagePushSpec: mcfgv1.ImageDigestFormat(digestedImage(name)),
+		}
+
+		created, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Create(ctx, old, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		return created
+	}
+
+	previous := createMOSB("previous", time.Hour*2, apihelpers.MachineOSBuildSucceededConditions())
+	old := createMOSB("old", time.Hour*3, apihelpers.MachineOSBuildSucceededConditions())
+	booted := createMOSB("booted", time.Hour*4, apihelpers.MachineOSBuildSucceededConditions())
+	oldFailure := createMOSB("old-failure", time.Hour*2, apihelpers.MachineOSBuildFailedConditions())
+	recentFailure := createMOSB("recent-failure", time.Minute, apihelpers.MachineOSBuildFailedConditions())
+
+	// One node is booted into an image with a MachineOSBuild and another is
+	// updating to an orphaned image.
+	for name, image := range map[string]string{"node-1": digestedImage("booted"), "node-2": digestedImage("booted-orphan")} {
+		mcn := &mcfgv1.MachineConfigNode{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if name == "node-1" {
+			mcn.Status.ConfigImage.CurrentImage = mcfgv1.ImageDigestFormat(image)
+		} else {
+			mcn.Spec.ConfigImage.DesiredImage = mcfgv1.ImageDigestFormat(image)
+		}
+
+		_, err := mcfgclient.MachineconfigurationV1().MachineConfigNodes().Create(ctx, mcn, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	orphan := mosc.Name + "-" + hash("orphan")
+	orphanArch := orphan + "-arm64"
+	bootedOrphan := mosc.Name + "-" + hash("booted-orphan")
+	// An orphaned tag for the same image as a MachineOSBuild which still
+	// exists.
+	sharedOrphan := mosc.Name + "-" + hash("shared-orphan")
+
+	pruner := &fakeRetentionImagePruner{
+		tags: []string{"latest", mosb.Name, previous.Name, booted.Name, orphan, orphanArch, bootedOrphan, sharedOrphan},
+		digests: map[string]digest.Digest{
+			repo + ":" + orphan:       digest.FromString("orphan"),
+			repo + ":" + orphanArch:   digest.FromString("orphan-arm64"),
+			repo + ":" + bootedOrphan: digest.FromString("booted-orphan"),
+			repo + ":" + sharedOrphan: digest.FromString("previous"),
+		},
+	}
+
+	reconciler := ctrl.buildReconciler.(*buildReconciler)
+	reconciler.imagepruner = pruner
+
+	err = wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(_ context.Context) (bool, error) {
+		mosbs, err := reconciler.getMachineOSBuildsForMachineOSConfig(mosc)
+		return err == nil && len(mosbs) == 6, nil
+	})
+	require.NoError(t, err)
+
+	apiMosc, err := mcfgclient.MachineconfigurationV1().MachineOSConfigs().Get(ctx, mosc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	metav1.SetMetaDataAnnotation(&apiMosc.ObjectMeta, constants.ImageRetentionCountAnnotationKey, "2")
+	metav1.SetMetaDataAnnotation(&apiMosc.ObjectMeta, constants.ImageRetentionTTLAnnotationKey, "1h")
+	metav1.SetMetaDataAnnotation(&apiMosc.ObjectMeta, constants.ImageRetentionPruneOrphansAnnotationKey, "true")
+	_, err = mcfgclient.MachineconfigurationV1().MachineOSConfigs().Update(ctx, apiMosc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(_ context.Context) (bool, error) {
+		lmosc, err := ctrl.machineOSConfigLister.Get(mosc.Name)
+		return err == nil && hasImageRetentionPolicy(lmosc), nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, reconciler.evaluateImageRetentionPolicy(ctx, mosc, now))
+
+	for _, retained := range []*mcfgv1.MachineOSBuild{mosb, previous, booted, recentFailure} {
+		_, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, retained.Name, metav1.GetOptions{})
+		assert.NoError(t, err, "expected MachineOSBuild %s to be retained", retained.Name)
+	}
+
+	for _, pruned := range []*mcfgv1.MachineOSBuild{old, oldFailure} {
+		_, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, pruned.Name, metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err), "expected MachineOSBuild %s to be pruned", pruned.Name)
+	}
+
+	assert.ElementsMatch(t, []string{
+		string(old.Spec.RenderedImagePushSpec),
+		string(oldFailure.Spec.RenderedImagePushSpec),
+		repo + ":" + orphan,
+		repo + ":" + orphanArch,
+	}, pruner.getDeleted())
+}
@@ -15,12 +15,15 @@ import (
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/imagebuilder"
 	"github.com/openshift/machine-config-operator/pkg/controller/build/imagepruner"
 	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	coreclientsetv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
@@ -69,15 +72,34 @@ type Config struct {
 
 	MaxShutdownDelay     time.Duration
 	ShutdownPollInterval time.Duration
+
+	// BuilderPollInterval is how often builds run by image builder backends
+	// which do not produce informer events, such as the External backend, are
+	// polled for their status. Polling is disabled when zero.
+	// Default: 15 seconds
+	BuilderPollInterval time.Duration
+
+	// RebuildPolicyInterval is how often the rebuild policy of each
+	// MachineOSConfig is evaluated. Evaluation is disabled when zero.
+	// Default: 10 minutes
+	RebuildPolicyInterval time.Duration
+
+	// ImageRetentionInterval is how often the image retention policy of each
+	// MachineOSConfig is evaluated. Evaluation is disabled when zero.
+	// Default: 1 hour
+	ImageRetentionInterval time.Duration
 }
 
 // Creates a Config with sensible production defaults.
 func defaultConfig() Config {
 	return Config{
-		MaxRetries:           5,
-		UpdateDelay:          time.Second * 5,
-		MaxShutdownDelay:     time.Second * 10,
-		ShutdownPollInterval: time.Millisecond * 100,
+		MaxRetries:             5,
+		UpdateDelay:            time.Second * 5,
+		MaxShutdownDelay:       time.Second * 10,
+		ShutdownPollInterval:   time.Millisecond * 100,
+		BuilderPollInterval:    time.Second * 15,
+		RebuildPolicyInterval:  time.Minute * 10,
+		ImageRetentionInterval: time.Hour,
 	}
 }
 
@@ -207,9 +229,85 @@ func (ctrl *OSBuildController) Run(parentCtx context.Context, workers int) {
 
 	ctrl.execQueue.Start(ctrlCtx, workers)
 
+	if ctrl.config.BuilderPollInterval > 0 {
+		go wait.UntilWithContext(ctrlCtx, ctrl.pollMachineOSBuilds, ctrl.config.BuilderPollInterval)
+	}
+
+	if ctrl.config.RebuildPolicyInterval > 0 {
+		go wait.UntilWithContext(ctrlCtx, ctrl.evaluateRebuildPolicies, ctrl.config.RebuildPolicyInterval)
+	}
+
+	if ctrl.config.ImageRetentionInterval > 0 {
+		go wait.UntilWithContext(ctrlCtx, ctrl.evaluateImageRetentionPolicies, ctrl.config.ImageRetentionInterval)
+	}
+
 	<-parentCtx.Done()
 }
 
+// Enqueues a status poll for each in-progress MachineOSBuild whose image
+// builder backend does not produce any informer events.
+func (ctrl *OSBuildController) pollMachineOSBuilds(_ context.Context) {
+	mosbs, err := ctrl.machineOSBuildLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list MachineOSBuilds to poll: %v", err)
+		return
+	}
+
+	for _, mosb := range mosbs {
+		if !imagebuilder.IsPolledImageBuilderBackend(mosb) {
+			continue
+		}
+
+		if ctrlcommon.NewMachineOSBuildState(mosb).IsInTerminalState() {
+			continue
+		}
+
+		ctrl.enqueueFuncForObject(mosb, func(ctx context.Context) error {
+			return ctrl.buildReconciler.PollMachineOSBuild(ctx, mosb)
+		})
+	}
+}
+
+// Enqueues a rebuild policy evaluation for each MachineOSConfig which has a
+// rebuild policy.
+func (ctrl *OSBuildController) evaluateRebuildPolicies(_ context.Context) {
+	moscs, err := ctrl.machineOSConfigLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list MachineOSConfigs to evaluate rebuild policies: %v", err)
+		return
+	}
+
+	for _, mosc := range moscs {
+		if !hasRebuildPolicy(mosc) {
+			continue
+		}
+
+		ctrl.enqueueFuncForObject(mosc, func(ctx context.Context) error {
+			return ctrl.buildReconciler.EvaluateRebuildPolicy(ctx, mosc)
+		})
+	}
+}
+
+// Enqueues an image retention policy evaluation for each MachineOSConfig which
+// has an image retention policy.
+func (ctrl *OSBuildController) evaluateImageRetentionPolicies(_ context.Context) {
+	moscs, err := ctrl.machineOSConfigLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Could not list MachineOSConfigs to evaluate image retention policies: %v", err)
+		return
+	}
+
+	for _, mosc := range moscs {
+		if !hasImageRetentionPolicy(mosc) {
+			continue
+		}
+
+		ctrl.enqueueFuncForObject(mosc, func(ctx context.Context) error {
+			return ctrl.buildReconciler.EvaluateImageRetentionPolicy(ctx, mosc)
+		})
+	}
+}
+
 type kubeObject interface {
 	k8sruntime.Object
 	GetName() string
@@ -299,6 +397,13 @@ func (ctrl *OSBuildController) deleteMachineOSBuild(cur interface{}) {
 
 func (ctrl *OSBuildController) addJob(cur interface{}) {
 	job := cur.(*batchv1.Job)
+
+	// Per-architecture build Jobs are observed through the build Job which
+	// waits for them.
+	if utils.IsArchitectureBuildJob(job) {
+		return
+	}
+
 	ctrl.enqueueFuncForObject(job, func(ctx context.Context) error {
 		return ctrl.buildReconciler.AddJob(ctx, job)
 	})
@@ -308,6 +413,10 @@ func (ctrl *OSBuildController) updateJob(old, cur interface{}) {
 	oldJob := old.(*batchv1.Job)
 	curJob := cur.(*batchv1.Job)
 
+	if utils.IsArchitectureBuildJob(curJob) {
+		return
+	}
+
 	ctrl.enqueueFuncForObject(curJob, func(ctx context.Context) error {
 		return ctrl.buildReconciler.UpdateJob(ctx, oldJob, curJob)
 	})
@@ -315,6 +424,11 @@ func (ctrl *OSBuildController) updateJob(old, cur interface{}) {
 
 func (ctrl *OSBuildController) deleteJob(cur interface{}) {
 	job := cur.(*batchv1.Job)
+
+	if utils.IsArchitectureBuildJob(job) {
+		return
+	}
+
 	ctrl.enqueueFuncForObject(job, func(ctx context.Context) error {
 		return ctrl.buildReconciler.DeleteJob(ctx, job)
 	})
@@ -44,6 +44,10 @@ func (f *fakeImagePruner) DeleteImage(ctx context.Context, _ string, _ *corev1.S
 	return nil
 }
 
+func (f *fakeImagePruner) ListImageTags(ctx context.Context, _ string, _ *corev1.Secret, _ *mcfgv1.ControllerConfig) ([]string, error) {
+	return nil, nil
+}
+
 // TODO: Remove this and deal with the resulting parameter explosion in the test suite.
 type clients struct {
 	mcfgclient mcfgclientset.Interface
@@ -592,7 +596,10 @@ func TestOSBuildControllerReconcilesJobsAfterRestart(t *testing.T) {
 			br, err := buildrequest.NewBuildRequestFromAPI(ctx, kubeclient, mcfgclient, apiMosb, mosc)
 			require.NoError(t, err)
 
-			buildJob := br.Builder().GetObject().(*batchv1.Job)
+			builder, err := br.Builder()
+			require.NoError(t, err)
+
+			buildJob := builder.GetObject().(*batchv1.Job)
 
 			_, err = kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Create(ctx, buildJob, metav1.CreateOptions{})
 			require.NoError(t, err)
@@ -609,6 +616,88 @@ func TestOSBuildControllerReconcilesJobsAfterRestart(t *testing.T) {
 	}
 }
 
+func TestOSBuildControllerCapturesBuildLogsOnFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	kubeclient, mcfgclient, _, _, mosc, mosb, _, kubeassert, _, _ := setupOSBuildControllerForTestWithRunningBuild(ctx, t, "worker")
+
+	job, err := kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).Get(ctx, utils.GetBuildJobName(mosb), metav1.GetOptions{})
+	require.NoError(t, err)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            job.Name + "-abcde",
+			Namespace:       ctrlcommon.MCONamespace,
+			Labels:          map[string]string{batchv1.JobNameLabel: job.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job"))},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "image-build"}},
+			Containers:     []corev1.Container{{Name: "create-digest-configmap"}},
+		},
+	}
+
+	_, err = kubeclient.CoreV1().Pods(ctrlcommon.MCONamespace).Create(ctx, pod, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	fixtures.SetJobStatus(ctx, t, kubeclient, mosb, fixtures.JobStatus{Failed: constants.JobMaxRetries + 1})
+	kubeassert.MachineOSBuildIsFailure(mosb)
+
+	buildLogsName := utils.GetBuildLogsConfigMapName(mosb)
+	kubeassert.ConfigMapExists(buildLogsName, "Expected build logs for MachineOSBuild %s to be captured", mosb.Name)
+
+	err = wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(ctx context.Context) (bool, error) {
+		apiMosb, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		return apihelpers.GetMachineOSBuildCondition(apiMosb.Status, constants.MachineOSBuildLogsCaptured) != nil, nil
+	})
+	require.NoError(t, err, "Expected MachineOSBuild %s to get the %s condition", mosb.Name, constants.MachineOSBuildLogsCaptured)
+
+	cm, err := kubeclient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, buildLogsName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, mosc.Name, cm.Labels[constants.MachineOSConfigNameLabelKey])
+	assert.Contains(t, cm.Data, "image-build.log")
+
+	// The build logs outlive the ephemeral build objects.
+	assertBuildObjectsAreCreated(ctx, t, kubeassert, mosb)
+}
+
+func TestOSBuildControllerPromotesPreBuiltImage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	t.Cleanup(cancel)
+
+	pullspec := "quay.io/org/ci-built@sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5"
+
+	kubeclient, mcfgclient, _, _, kubeassert, lobj, _ := setupOSBuildControllerForTest(ctx, t)
+
+	mosc := lobj.MachineOSConfig
+	mosc.Name = "worker-os-config"
+	metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.ImageBuilderBackendAnnotationKey, constants.ImageBuilderBackendPreBuilt)
+	metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.PromotedImageAnnotationKey, pullspec)
+
+	_, err := mcfgclient.MachineconfigurationV1().MachineOSConfigs().Create(ctx, mosc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	mosb := buildrequest.NewMachineOSBuildOrDie(buildrequest.MachineOSBuildOpts{
+		MachineConfig:     lobj.RenderedMachineConfig,
+		MachineOSConfig:   mosc,
+		MachineConfigPool: lobj.MachineConfigPool,
+	})
+
+	kubeassert.MachineOSBuildExists(mosb, "MachineOSBuild not created for MachineOSConfig %s", mosc.Name)
+	kubeassert.MachineOSBuildIsSuccessful(mosb, "Expected the pre-built image to be promoted for MachineOSBuild %s", mosb.Name)
+	assertMachineOSConfigGetsBuiltImagePushspec(ctx, t, mcfgclient, mosc, pullspec)
+
+	// No build Job is created for a pre-built image.
+	jobs, err := kubeclient.BatchV1().Jobs(ctrlcommon.MCONamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items)
+}
+
 func assertBuildObjectsAreCreated(ctx context.Context, t *testing.T, kubeassert *testhelpers.Assertions, mosb *mcfgv1.MachineOSBuild) {
 	t.Helper()
 
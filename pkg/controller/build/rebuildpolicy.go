@@ -0,0 +1,427 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/imagebuilder"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// bulkImageInspector is the subset of imageutils.BulkInspector used to look
+// up the digests of the images a MachineOSConfig is built from.
+type bulkImageInspector interface {
+	Inspect(context.Context, *types.SystemContext, ...string) ([]imageutils.BulkInspectResult, error)
+}
+
+// rebuildPolicy holds the rebuild policy configured on a MachineOSConfig.
+type rebuildPolicy struct {
+	schedule     cron.Schedule
+	watchDigests bool
+}
+
+// hasRebuildPolicy determines if a MachineOSConfig has any rebuild policy
+// annotations set.
+func hasRebuildPolicy(mosc *mcfgv1.MachineOSConfig) bool {
+	return metav1.HasAnnotation(mosc.ObjectMeta, constants.RebuildScheduleAnnotationKey) ||
+		metav1.HasAnnotation(mosc.ObjectMeta, constants.RebuildWatchDigestsAnnotationKey)
+}
+
+// getRebuildPolicy parses the rebuild policy annotations on a
+// MachineOSConfig. Returns nil if no rebuild policy is configured.
+func getRebuildPolicy(mosc *mcfgv1.MachineOSConfig) (*rebuildPolicy, error) {
+	policy := &rebuildPolicy{}
+
+	if val := strings.TrimSpace(mosc.Annotations[constants.RebuildScheduleAnnotationKey]); val != "" {
+		schedule, err := cron.ParseStandard(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", constants.RebuildScheduleAnnotationKey, val, err)
+		}
+
+		policy.schedule = schedule
+	}
+
+	if val, ok := mosc.Annotations[constants.RebuildWatchDigestsAnnotationKey]; ok {
+		watchDigests, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q: %w", constants.RebuildWatchDigestsAnnotationKey, val, err)
+		}
+
+		policy.watchDigests = watchDigests
+	}
+
+	if policy.schedule == nil && !policy.watchDigests {
+		return nil, nil
+	}
+
+	return policy, nil
+}
+
+// getWatchedImages returns the images whose digests are watched for the
+// given MachineOSConfig and rendered MachineConfig: the base OS image, the
+// extensions image and any external images referenced by the Containerfiles.
+func getWatchedImages(mosc *mcfgv1.MachineOSConfig, mc *mcfgv1.MachineConfig) []string {
+	images := map[string]struct{}{}
+
+	for _, image := range []string{mc.Spec.OSImageURL, mc.Spec.BaseOSExtensionsContainerImage} {
+		if isWatchableImage(image) {
+			images[image] = struct{}{}
+		}
+	}
+
+	for _, containerfile := range mosc.Spec.Containerfile {
+		for _, image := range getContainerfileImageReferences(containerfile.Content) {
+			images[image] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(images))
+	for image := range images {
+		out = append(out, image)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
+
+// getContainerfileImageReferences returns the external images referenced by
+// FROM instructions, COPY --from flags and RUN --mount from options in the
+// given Containerfile. Build stages, including the ones the MCO injects, and
+// images which cannot change, such as digested pullspecs, are omitted.
+func getContainerfileImageReferences(content string) []string {
+	stages := map[string]struct{}{
+		"configs": {},
+		"extract": {},
+	}
+
+	images := []string{}
+	seen := map[string]struct{}{}
+
+	addImage := func(image string) {
+		if _, isStage := stages[strings.ToLower(image)]; isStage {
+			return
+		}
+
+		if _, err := strconv.Atoi(image); err == nil {
+			return
+		}
+
+		if !isWatchableImage(image) {
+			return
+		}
+
+		if _, ok := seen[image]; ok {
+			return
+		}
+
+		seen[image] = struct{}{}
+		images = append(images, image)
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			args := []string{}
+			for _, field := range fields[1:] {
+				if !strings.HasPrefix(field, "--") {
+					args = append(args, field)
+				}
+			}
+
+			if len(args) == 0 {
+				continue
+			}
+
+			addImage(args[0])
+
+			if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+				stages[strings.ToLower(args[2])] = struct{}{}
+			}
+		case "COPY", "ADD":
+			for _, field := range fields[1:] {
+				if from, ok := strings.CutPrefix(field, "--from="); ok {
+					addImage(from)
+				}
+			}
+		case "RUN":
+			for _, field := range fields[1:] {
+				mount, ok := strings.CutPrefix(field, "--mount=")
+				if !ok {
+					continue
+				}
+
+				for _, opt := range strings.Split(mount, ",") {
+					if from, ok := strings.CutPrefix(opt, "from="); ok {
+						addImage(from)
+					}
+				}
+			}
+		}
+	}
+
+	return images
+}
+
+// isWatchableImage determines if an image reference may resolve to a
+// different digest over time.
+func isWatchableImage(image string) bool {
+	if image == "" || image == "scratch" {
+		return false
+	}
+
+	if strings.Contains(image, "$") || strings.Contains(image, "{{") {
+		return false
+	}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+
+	_, isDigested := named.(reference.Digested)
+	return !isDigested
+}
+
+// getWatchedImageDigests returns the image digests last observed for the
+// given MachineOSConfig.
+func getWatchedImageDigests(mosc *mcfgv1.MachineOSConfig) map[string]string {
+	digests := map[string]string{}
+
+	val, ok := mosc.Annotations[constants.WatchedImageDigestsAnnotationKey]
+	if !ok {
+		return digests
+	}
+
+	if err := json.Unmarshal([]byte(val), &digests); err != nil {
+		klog.Warningf("Ignoring invalid %s annotation on MachineOSConfig %q: %s", constants.WatchedImageDigestsAnnotationKey, mosc.Name, err)
+		return map[string]string{}
+	}
+
+	return digests
+}
+
+// getLastBuildTime returns when the current MachineOSBuild was started or when
+// the rebuild policy last triggered a rebuild, whichever is later. Scheduled
+// rebuilds are due relative to this time.
+func getLastBuildTime(mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) time.Time {
+	last := mosb.CreationTimestamp.Time
+
+	if mosb.Status.BuildStart != nil && mosb.Status.BuildStart.After(last) {
+		last = mosb.Status.BuildStart.Time
+	}
+
+	if val, ok := mosc.Annotations[constants.LastPolicyRebuildAnnotationKey]; ok {
+		if parsed, err := time.Parse(time.RFC3339, val); err == nil && parsed.After(last) {
+			last = parsed
+		}
+	}
+
+	return last
+}
+
+// Evaluates the rebuild policy for the given MachineOSConfig. If the rebuild
+// schedule is due or a watched image digest has changed since the last
+// build, the rebuild annotation is applied so that a new MachineOSBuild is
+// created.
+func (b *buildReconciler) evaluateRebuildPolicy(ctx context.Context, mosc *mcfgv1.MachineOSConfig, now time.Time) error {
+	mosc, err := b.machineOSConfigLister.Get(mosc.Name)
+	if err != nil {
+		return ignoreErrIsNotFound(fmt.Errorf("could not evaluate rebuild policy: %w", err))
+	}
+
+	policy, err := getRebuildPolicy(mosc)
+	if err != nil {
+		return fmt.Errorf("could not get rebuild policy for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	if policy == nil || hasRebuildAnnotation(mosc) || !hasCurrentBuildAnnotation(mosc) {
+		return nil
+	}
+
+	backend, err := imagebuilder.GetImageBuilderBackend(mosc)
+	if err != nil {
+		return fmt.Errorf("could not evaluate rebuild policy for MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	if backend == constants.ImageBuilderBackendPreBuilt {
+		klog.V(4).Infof("MachineOSConfig %q uses the %s image builder backend, skipping rebuild policy", mosc.Name, constants.ImageBuilderBackendPreBuilt)
+		return nil
+	}
+
+	mosb, err := b.machineOSBuildLister.Get(mosc.Annotations[constants.CurrentMachineOSBuildAnnotationKey])
+	if err != nil {
+		return ignoreErrIsNotFound(fmt.Errorf("could not get current MachineOSBuild for MachineOSConfig %q: %w", mosc.Name, err))
+	}
+
+	// Wait for the current build to finish before considering another one.
+	if !ctrlcommon.NewMachineOSBuildState(mosb).IsInTerminalState() {
+		return nil
+	}
+
+	reasons := []string{}
+
+	if last := getLastBuildTime(mosc, mosb); policy.schedule != nil && !last.IsZero() {
+		if next := policy.schedule.Next(last); !next.After(now) {
+			reasons = append(reasons, fmt.Sprintf("scheduled rebuild was due at %s", next.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	prevDigests := getWatchedImageDigests(mosc)
+	curDigests := prevDigests
+
+	if policy.watchDigests {
+		curDigests, err = b.getWatchedImageDigestsForBuild(ctx, mosc, mosb, prevDigests)
+		if err != nil {
+			return fmt.Errorf("could not get watched image digests for MachineOSConfig %q: %w", mosc.Name, err)
+		}
+
+		for _, image := range sortedKeys(curDigests) {
+			if prev, ok := prevDigests[image]; ok && prev != curDigests[image] {
+				reasons = append(reasons, fmt.Sprintf("image %s changed from %s to %s", image, prev, curDigests[image]))
+			}
+		}
+	}
+
+	digestsChanged := !equalDigests(prevDigests, curDigests)
+
+	if len(reasons) == 0 && !digestsChanged {
+		return nil
+	}
+
+	mosc = mosc.DeepCopy()
+
+	if digestsChanged {
+		out, err := json.Marshal(curDigests)
+		if err != nil {
+			return fmt.Errorf("could not encode watched image digests for MachineOSConfig %q: %w", mosc.Name, err)
+		}
+
+		metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.WatchedImageDigestsAnnotationKey, string(out))
+	}
+
+	if len(reasons) != 0 {
+		reason := strings.Join(reasons, "; ")
+		metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.RebuildMachineOSConfigAnnotationKey, "")
+		metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.LastPolicyRebuildAnnotationKey, now.UTC().Format(time.RFC3339))
+		metav1.SetMetaDataAnnotation(&mosc.ObjectMeta, constants.LastPolicyRebuildReasonAnnotationKey, reason)
+		klog.Infof("Rebuild policy for MachineOSConfig %q triggered a rebuild: %s", mosc.Name, reason)
+	}
+
+	if _, err := b.mcfgclient.MachineconfigurationV1().MachineOSConfigs().Update(ctx, mosc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update rebuild policy annotations on MachineOSConfig %q: %w", mosc.Name, err)
+	}
+
+	return nil
+}
+
+// Inspects the images watched for the given MachineOSConfig and returns their
+// current digests. Images which could not be inspected keep their previously
+// observed digest so that a transient registry error does not cause a
+// rebuild.
+func (b *buildReconciler) getWatchedImageDigestsForBuild(ctx context.Context, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild, prevDigests map[string]string) (map[string]string, error) {
+	mc, err := b.machineConfigLister.Get(mosb.Spec.MachineConfig.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get MachineConfig %q: %w", mosb.Spec.MachineConfig.Name, err)
+	}
+
+	images := getWatchedImages(mosc, mc)
+
+	digests := map[string]string{}
+	if len(images) == 0 {
+		return digests, nil
+	}
+
+	secretName := ctrlcommon.GlobalPullSecretCopyName
+	if mosc.Spec.BaseImagePullSecret != nil && mosc.Spec.BaseImagePullSecret.Name != "" {
+		secretName = mosc.Spec.BaseImagePullSecret.Name
+	}
+
+	secret, err := b.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get base image pull secret %s: %w", secretName, err)
+	}
+
+	controllerConfigs, err := b.controllerConfigLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list ControllerConfigs: %w", err)
+	}
+
+	if len(controllerConfigs) == 0 {
+		return nil, fmt.Errorf("no ControllerConfigs found")
+	}
+
+	sysCtx, err := imageutils.NewSysContextBuilder().WithSecret(secret).WithControllerConfig(controllerConfigs[0]).Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare for image inspection: %w", err)
+	}
+
+	defer func() {
+		if err := sysCtx.Cleanup(); err != nil {
+			klog.Warningf("Unable to clean up after inspecting watched images for MachineOSConfig %q: %s", mosc.Name, err)
+		}
+	}()
+
+	results, err := b.inspector.Inspect(ctx, sysCtx.SysContext, images...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		if result.Error != nil || result.Digest == "" {
+			klog.Warningf("Could not inspect watched image %s for MachineOSConfig %q: %v", result.Image, mosc.Name, result.Error)
+			if prev, ok := prevDigests[result.Image]; ok {
+				digests[result.Image] = prev
+			}
+			continue
+		}
+
+		digests[result.Image] = result.Digest.String()
+	}
+
+	return digests, nil
+}
+
+func equalDigests(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, val := range a {
+		if other, ok := b[key]; !ok || other != val {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedKeys(in map[string]string) []string {
+	out := make([]string, 0, len(in))
+	for key := range in {
+		out = append(out, key)
+	}
+
+	sort.Strings(out)
+
+	return out
+}
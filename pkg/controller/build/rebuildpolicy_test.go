@@ -0,0 +1,297 @@
+package build
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/fixtures"
+	"github.com/openshift/machine-config-operator/pkg/controller/build/utils"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+type fakeBulkInspector struct {
+	mu      sync.Mutex
+	digests map[string]digest.Digest
+}
+
+func (f *fakeBulkInspector) setDigest(image string, d digest.Digest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.digests[image] = d
+}
+
+func (f *fakeBulkInspector) Inspect(_ context.Context, _ *types.SystemContext, images ...string) ([]imageutils.BulkInspectResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	results := []imageutils.BulkInspectResult{}
+	for _, image := range images {
+		results = append(results, imageutils.BulkInspectResult{Image: image, Digest: f.digests[image]})
+	}
+
+	return results, nil
+}
+
+func TestGetRebuildPolicy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		annotations          map[string]string
+		expectedPolicy       bool
+		expectedSchedule     bool
+		expectedWatchDigests bool
+		errExpected          bool
+	}{
+		{
+			name: "No policy",
+		},
+		{
+			name:             "Schedule only",
+			annotations:      map[string]string{constants.RebuildScheduleAnnotationKey: "@weekly"},
+			expectedPolicy:   true,
+			expectedSchedule: true,
+		},
+		{
+			name:                 "Watch digests only",
+			annotations:          map[string]string{constants.RebuildWatchDigestsAnnotationKey: "true"},
+			expectedPolicy:       true,
+			expectedWatchDigests: true,
+		},
+		{
+			name: "Schedule and watch digests",
+			annotations: map[string]string{
+				constants.RebuildScheduleAnnotationKey:     "0 3 * * 0",
+				constants.RebuildWatchDigestsAnnotationKey: "true",
+			},
+			expectedPolicy:       true,
+			expectedSchedule:     true,
+			expectedWatchDigests: true,
+		},
+		{
+			name:        "Watch digests disabled",
+			annotations: map[string]string{constants.RebuildWatchDigestsAnnotationKey: "false"},
+		},
+		{
+			name:        "Invalid schedule",
+			annotations: map[string]string{constants.RebuildScheduleAnnotationKey: "every tuesday"},
+			errExpected: true,
+		},
+		{
+			name:        "Invalid watch digests",
+			annotations: map[string]string{constants.RebuildWatchDigestsAnnotationKey: "sometimes"},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			mosc := &mcfgv1.MachineOSConfig{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "worker",
+					Annotations: testCase.annotations,
+				},
+			}
+
+			assert.Equal(t, len(testCase.annotations) != 0, hasRebuildPolicy(mosc))
+
+			policy, err := getRebuildPolicy(mosc)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+
+			if !testCase.expectedPolicy {
+				assert.Nil(t, policy)
+				return
+			}
+
+			require.NotNil(t, policy)
+			assert.Equal(t, testCase.expectedSchedule, policy.schedule != nil)
+			assert.Equal(t, testCase.expectedWatchDigests, policy.watchDigests)
+		})
+	}
+}
+
+func TestGetContainerfileImageReferences(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		containerfile string
+		expected      []string
+	}{
+		{
+			name:          "Only MCO-injected stages",
+			containerfile: "FROM configs AS final\n\nRUN echo 'hi' > /etc/hi",
+			expected:      []string{},
+		},
+		{
+			name: "External images",
+			containerfile: `FROM quay.io/org/builder:latest AS builder
+RUN make
+FROM --platform=linux/amd64 configs AS final
+COPY --from=builder /out/bin /usr/bin/
+COPY --from=quay.io/org/tools:v1 /usr/bin/tool /usr/bin/
+RUN --mount=type=bind,from=quay.io/org/rpms:latest,source=/rpms,target=/rpms rpm-ostree install /rpms/*.rpm
+COPY --from=0 /out /out`,
+			expected: []string{
+				"quay.io/org/builder:latest",
+				"quay.io/org/tools:v1",
+				"quay.io/org/rpms:latest",
+			},
+		},
+		{
+			name: "Unwatchable images",
+			containerfile: `ARG TOOLS_IMAGE
+FROM scratch AS empty
+FROM ${TOOLS_IMAGE} AS tools
+COPY --from=quay.io/org/tools@sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5 /tool /tool
+FROM configs AS final
+COPY --from=empty / /
+COPY --from=tools /tool /usr/bin/tool`,
+			expected: []string{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, getContainerfileImageReferences(testCase.containerfile))
+		})
+	}
+}
+
+func TestEvaluateRebuildPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	t.Cleanup(cancel)
+
+	kubeclient, mcfgclient, _, _, mosc, mosb, _, kubeassert, _, ctrl := setupOSBuildControllerForTestWithRunningBuild(ctx, t, "worker")
+
+	fixtures.SetJobStatus(ctx, t, kubeclient, mosb, fixtures.JobStatus{Succeeded: 1})
+	kubeassert.MachineOSBuildIsSuccessful(mosb)
+	kubeassert.JobDoesNotExist(utils.GetBuildJobName(mosb))
+
+	// Point the rendered MachineConfig at a tagged base OS image so that its
+	// digest can change.
+	baseOSImage := "registry.hostname.com/org/os:latest"
+
+	mc, err := mcfgclient.MachineconfigurationV1().MachineConfigs().Get(ctx, mosb.Spec.MachineConfig.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	mc.Spec.OSImageURL = baseOSImage
+	_, err = mcfgclient.MachineconfigurationV1().MachineConfigs().Update(ctx, mc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(_ context.Context) (bool, error) {
+		lmc, err := ctrl.machineConfigLister.Get(mc.Name)
+		return err == nil && lmc.Spec.OSImageURL == baseOSImage, nil
+	})
+	require.NoError(t, err)
+
+	inspector := &fakeBulkInspector{
+		digests: map[string]digest.Digest{
+			baseOSImage: digest.FromString("base-1"),
+		},
+	}
+
+	reconciler := ctrl.buildReconciler.(*buildReconciler)
+	reconciler.inspector = inspector
+
+	// Waits for the MachineOSConfig in the lister to match the given condition.
+	waitForMachineOSConfig := func(cond func(*mcfgv1.MachineOSConfig) bool) *mcfgv1.MachineOSConfig {
+		t.Helper()
+
+		var out *mcfgv1.MachineOSConfig
+		err := wait.PollImmediateInfiniteWithContext(ctx, time.Millisecond, func(_ context.Context) (bool, error) {
+			lmosc, err := ctrl.machineOSConfigLister.Get(mosc.Name)
+			if err != nil {
+				return false, err
+			}
+
+			out = lmosc
+			return cond(lmosc), nil
+		})
+		require.NoError(t, err)
+
+		return out
+	}
+
+	apiMosc := waitForMachineOSConfig(func(m *mcfgv1.MachineOSConfig) bool {
+		return isCurrentBuildAnnotationEqual(m, mosb)
+	}).DeepCopy()
+
+	metav1.SetMetaDataAnnotation(&apiMosc.ObjectMeta, constants.RebuildScheduleAnnotationKey, "@weekly")
+	metav1.SetMetaDataAnnotation(&apiMosc.ObjectMeta, constants.RebuildWatchDigestsAnnotationKey, "true")
+	_, err = mcfgclient.MachineconfigurationV1().MachineOSConfigs().Update(ctx, apiMosc, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	waitForMachineOSConfig(hasRebuildPolicy)
+
+	// The first evaluation records the digests without rebuilding.
+	require.NoError(t, reconciler.evaluateRebuildPolicy(ctx, mosc, time.Now()))
+
+	lmosc := waitForMachineOSConfig(func(m *mcfgv1.MachineOSConfig) bool {
+		return metav1.HasAnnotation(m.ObjectMeta, constants.WatchedImageDigestsAnnotationKey)
+	})
+	assert.Contains(t, lmosc.Annotations[constants.WatchedImageDigestsAnnotationKey], digest.FromString("base-1").String())
+	assert.False(t, hasRebuildAnnotation(lmosc))
+	assert.NotContains(t, lmosc.Annotations, constants.LastPolicyRebuildAnnotationKey)
+
+	// Nothing has changed and the schedule is not yet due.
+	require.NoError(t, reconciler.evaluateRebuildPolicy(ctx, mosc, time.Now()))
+	lmosc, err = ctrl.machineOSConfigLister.Get(mosc.Name)
+	require.NoError(t, err)
+	assert.False(t, hasRebuildAnnotation(lmosc))
+
+	// Once the base OS image digest changes, a rebuild is triggered.
+	inspector.setDigest(baseOSImage, digest.FromString("base-2"))
+	require.NoError(t, reconciler.evaluateRebuildPolicy(ctx, mosc, time.Now()))
+
+	lmosc = waitForMachineOSConfig(func(m *mcfgv1.MachineOSConfig) bool {
+		return metav1.HasAnnotation(m.ObjectMeta, constants.LastPolicyRebuildReasonAnnotationKey)
+	})
+	assert.Contains(t, lmosc.Annotations[constants.LastPolicyRebuildReasonAnnotationKey], baseOSImage)
+	assert.Contains(t, lmosc.Annotations[constants.WatchedImageDigestsAnnotationKey], digest.FromString("base-2").String())
+
+	// The MachineOSBuild is replaced and the rebuild annotation is cleared.
+	lmosc = waitForMachineOSConfig(func(m *mcfgv1.MachineOSConfig) bool {
+		return !hasRebuildAnnotation(m) && !isCurrentBuildAnnotationEqual(m, mosb)
+	})
+
+	rebuiltMosb, err := mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, lmosc.Annotations[constants.CurrentMachineOSBuildAnnotationKey], metav1.GetOptions{})
+	require.NoError(t, err)
+
+	kubeassert.JobExists(utils.GetBuildJobName(rebuiltMosb), "Expected a rebuild job for MachineOSBuild %s", rebuiltMosb.Name)
+	fixtures.SetJobStatus(ctx, t, kubeclient, rebuiltMosb, fixtures.JobStatus{Succeeded: 1})
+	kubeassert.MachineOSBuildIsSuccessful(rebuiltMosb)
+	kubeassert.JobDoesNotExist(utils.GetBuildJobName(rebuiltMosb))
+
+	// A week after the last rebuild, the schedule triggers another one.
+	prevRebuild := lmosc.Annotations[constants.LastPolicyRebuildAnnotationKey]
+	lastRebuild, err := time.Parse(time.RFC3339, prevRebuild)
+	require.NoError(t, err)
+
+	require.NoError(t, reconciler.evaluateRebuildPolicy(ctx, mosc, lastRebuild.Add(time.Hour*24*8)))
+
+	lmosc = waitForMachineOSConfig(func(m *mcfgv1.MachineOSConfig) bool {
+		return m.Annotations[constants.LastPolicyRebuildAnnotationKey] != prevRebuild
+	})
+	assert.Contains(t, lmosc.Annotations[constants.LastPolicyRebuildReasonAnnotationKey], "scheduled rebuild")
+}
@@ -20,6 +20,7 @@ import (
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	daemonconstants "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
@@ -55,6 +56,10 @@ type reconciler interface {
 
 	AddMachineConfigPool(context.Context, *mcfgv1.MachineConfigPool) error
 	UpdateMachineConfigPool(context.Context, *mcfgv1.MachineConfigPool, *mcfgv1.MachineConfigPool) error
+
+	PollMachineOSBuild(context.Context, *mcfgv1.MachineOSBuild) error
+	EvaluateRebuildPolicy(context.Context, *mcfgv1.MachineOSConfig) error
+	EvaluateImageRetentionPolicy(context.Context, *mcfgv1.MachineOSConfig) error
 }
 
 // Holds the implementation of the buildReconciler. The buildReconciler's job
@@ -66,6 +71,8 @@ type buildReconciler struct {
 	imageclient imagev1clientset.Interface
 	routeclient routeclientset.Interface
 	imagepruner imagepruner.ImagePruner
+	inspector   bulkImageInspector
+	buildQueue  *buildQueue
 	*listers
 }
 
@@ -82,6 +89,8 @@ func newBuildReconcilerAsStruct(mcfgclient mcfgclientset.Interface, kubeclient c
 		imageclient: imageclient,
 		routeclient: routeclient,
 		imagepruner: imagepruner,
+		inspector:   imageutils.NewBulkInspector(&imageutils.BulkInspectorOptions{Count: 5, ResolveDigests: true}),
+		buildQueue:  newBuildQueue(),
 		listers:     l,
 	}
 }
@@ -290,6 +299,8 @@ func (b *buildReconciler) updateMachineOSBuild(ctx context.Context, old, current
 	if !oldState.IsBuildFailure() && curState.IsBuildFailure() {
 		klog.Infof("MachineOSBuild %s failed, leaving ephemeral objects in place for inspection", current.Name)
 
+		b.captureBuildLogs(ctx, mosc, current)
+
 		mcp, err := b.machineConfigPoolLister.Get(mosc.Spec.MachineConfigPool.Name)
 		if err != nil {
 			return fmt.Errorf("could not get MachineConfigPool from MachineOSConfig %q: %w", mosc.Name, err)
@@ -305,6 +316,10 @@ func (b *buildReconciler) updateMachineOSBuild(ctx context.Context, old, current
 	if !oldState.IsBuildSuccess() && curState.IsBuildSuccess() {
 		klog.Infof("MachineOSBuild %s succeeded, cleaning up all ephemeral objects used for the build", current.Name)
 
+		// The build pod is removed along with the build job, so its logs must be
+		// captured first.
+		b.captureBuildLogs(ctx, mosc, current)
+
 		mcp, err := b.machineConfigPoolLister.Get(mosc.Spec.MachineConfigPool.Name)
 		if err != nil {
 			return fmt.Errorf("could not get MachineConfigPool from MachineOSConfig %q: %w", mosc.Name, err)
@@ -316,7 +331,12 @@ func (b *buildReconciler) updateMachineOSBuild(ctx context.Context, old, current
 		}
 
 		// Clean up ephemeral objects
-		if err := imagebuilder.NewJobImageBuilder(b.kubeclient, b.mcfgclient, current, mosc).Clean(ctx); err != nil {
+		cleaner, err := imagebuilder.NewImageBuildCleaner(b.kubeclient, b.mcfgclient, current)
+		if err != nil {
+			return err
+		}
+
+		if err := cleaner.Clean(ctx); err != nil {
 			return err
 		}
 
@@ -328,6 +348,49 @@ func (b *buildReconciler) updateMachineOSBuild(ctx context.Context, old, current
 	return nil
 }
 
+// Stores the build pod logs for a completed MachineOSBuild in a ConfigMap and
+// records where they are, along with any failure details parsed from them, in
+// the BuildLogsCaptured condition. This is best-effort since the logs are only
+// a diagnostic aid and should never block the build lifecycle.
+func (b *buildReconciler) captureBuildLogs(ctx context.Context, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) {
+	if backend, err := imagebuilder.GetImageBuilderBackend(mosb); err != nil || backend != constants.ImageBuilderBackendJob {
+		return
+	}
+
+	opts, err := imagebuilder.GetBuildLogOptions(mosc)
+	if err != nil {
+		klog.Warningf("Not capturing build logs for MachineOSBuild %q: %s", mosb.Name, err)
+		return
+	}
+
+	cm, failure, err := imagebuilder.CaptureBuildLogs(ctx, b.kubeclient, mosc, mosb, opts)
+	if err != nil {
+		klog.Errorf("Could not capture build logs for MachineOSBuild %q: %s", mosb.Name, err)
+	}
+
+	if cm == nil {
+		return
+	}
+
+	condition := imagebuilder.NewBuildLogsCondition(cm, failure)
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := b.mcfgclient.MachineconfigurationV1().MachineOSBuilds().Get(ctx, mosb.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		apihelpers.SetMachineOSBuildCondition(&current.Status, *apihelpers.NewMachineOSBuildCondition(condition.Type, condition.Status, condition.Reason, condition.Message))
+
+		_, err = b.mcfgclient.MachineconfigurationV1().MachineOSBuilds().UpdateStatus(ctx, current, metav1.UpdateOptions{})
+		return err
+	})
+
+	if err != nil {
+		klog.Errorf("Could not set %s condition on MachineOSBuild %q: %s", constants.MachineOSBuildLogsCaptured, mosb.Name, err)
+	}
+}
+
 // Updates the status on the MachineOSConfig object from the supplied MachineOSBuild object.
 func (b *buildReconciler) updateMachineOSConfigStatus(ctx context.Context, mosc *mcfgv1.MachineOSConfig, mosb *mcfgv1.MachineOSBuild) error {
 	mosc, err := b.getMachineOSConfigForUpdate(mosc)
@@ -456,7 +519,12 @@ func (b *buildReconciler) startBuild(ctx context.Context, mosb *mcfgv1.MachineOS
 	}
 
 	// Next, create our new MachineOSBuild.
-	if err := imagebuilder.NewJobImageBuilder(b.kubeclient, b.mcfgclient, mosb, mosc).Start(ctx); err != nil {
+	builder, err := imagebuilder.NewImageBuilder(b.kubeclient, b.mcfgclient, mosb, mosc, b.inspectImage)
+	if err != nil {
+		return fmt.Errorf("could not get imagebuilder for MachineOSBuild %q: %w", mosb.Name, err)
+	}
+
+	if err := builder.Start(ctx); err != nil {
 		return fmt.Errorf("imagebuilder could not start build for MachineOSBuild %q: %w", mosb.Name, err)
 	}
 
@@ -692,6 +760,59 @@ func (b *buildReconciler) reuseExistingMachineOSBuildIfPossible(ctx context.Cont
 	return imageNeedsRebuild, nil
 }
 
+// Executes periodically for builds whose image builder backend does not
+// produce any informer events.
+func (b *buildReconciler) PollMachineOSBuild(ctx context.Context, mosb *mcfgv1.MachineOSBuild) error {
+	return b.timeObjectOperation(mosb, syncingVerb, func() error {
+		return b.pollMachineOSBuild(ctx, mosb)
+	})
+}
+
+// Executes periodically for each MachineOSConfig which has a rebuild policy.
+func (b *buildReconciler) EvaluateRebuildPolicy(ctx context.Context, mosc *mcfgv1.MachineOSConfig) error {
+	return b.timeObjectOperation(mosc, syncingVerb, func() error {
+		return b.evaluateRebuildPolicy(ctx, mosc, time.Now())
+	})
+}
+
+// Executes periodically for each MachineOSConfig which has an image retention
+// policy.
+func (b *buildReconciler) EvaluateImageRetentionPolicy(ctx context.Context, mosc *mcfgv1.MachineOSConfig) error {
+	return b.timeObjectOperation(mosc, syncingVerb, func() error {
+		return b.evaluateImageRetentionPolicy(ctx, mosc, time.Now())
+	})
+}
+
+// Gets the current status of the build from its image builder backend and
+// applies it to the MachineOSBuild.
+func (b *buildReconciler) pollMachineOSBuild(ctx context.Context, polled *mcfgv1.MachineOSBuild) error {
+	mosb, err := b.machineOSBuildLister.Get(polled.Name)
+	if err != nil {
+		return ignoreErrIsNotFound(fmt.Errorf("could not poll MachineOSBuild %q: %w", polled.Name, err))
+	}
+
+	if ctrlcommon.NewMachineOSBuildState(mosb).IsInTerminalState() {
+		return nil
+	}
+
+	mosc, err := utils.GetMachineOSConfigForMachineOSBuild(mosb, b.utilListers())
+	if err != nil {
+		return ignoreErrIsNotFound(fmt.Errorf("could not poll MachineOSBuild %q: %w", mosb.Name, err))
+	}
+
+	observer, err := imagebuilder.NewImageBuildObserver(b.kubeclient, b.mcfgclient, mosb, mosc)
+	if err != nil {
+		return fmt.Errorf("could not get observer for MachineOSBuild %q: %w", mosb.Name, err)
+	}
+
+	curStatus, err := observer.MachineOSBuildStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get status for MachineOSBuild %q: %w", mosb.Name, err)
+	}
+
+	return b.setStatusOnMachineOSBuildIfNeeded(ctx, mosb.DeepCopy(), mosb.Status, curStatus)
+}
+
 // Gets the MachineOSBuild status from the provided metav1.Object which can be
 // converted into a Builder.
 func (b *buildReconciler) getMachineOSBuildStatusForBuilder(ctx context.Context, obj metav1.Object) (mcfgv1.MachineOSBuildStatus, *mcfgv1.MachineOSBuild, error) {
@@ -878,9 +999,15 @@ func (b *buildReconciler) getMachineOSConfigForBuilder(builder buildrequest.Buil
 
 // Deletes the underlying build objects for a given MachineOSBuild.
 func (b *buildReconciler) deleteBuilderForMachineOSBuild(ctx context.Context, mosb *mcfgv1.MachineOSBuild) error {
-	if err := imagebuilder.NewJobImageBuildCleaner(b.kubeclient, b.mcfgclient, mosb).Clean(ctx); err != nil {
+	cleaner, err := imagebuilder.NewImageBuildCleaner(b.kubeclient, b.mcfgclient, mosb)
+	if err != nil {
+		return fmt.Errorf("could not get cleaner for build %s: %w", mosb.Name, err)
+	}
+
+	if err := cleaner.Clean(ctx); err != nil {
 		return fmt.Errorf("could not clean build %s: %w", mosb.Name, err)
 	}
+
 	// Delete the image associated with the MOSB first
 	moscName, err := utils.GetRequiredLabelValueFromObject(mosb, constants.MachineOSConfigNameLabelKey)
 	if err != nil {
@@ -934,6 +1061,13 @@ func (b *buildReconciler) deleteMachineOSBuild(ctx context.Context, mosb *mcfgv1
 }
 
 func (b *buildReconciler) deleteMOSBImage(ctx context.Context, mosb *mcfgv1.MachineOSBuild, moscName string) error {
+	// Images promoted by the PreBuilt backend were built and pushed elsewhere,
+	// so they are not ours to delete.
+	if backend, _ := imagebuilder.GetImageBuilderBackend(mosb); backend == constants.ImageBuilderBackendPreBuilt {
+		klog.Infof("MachineOSBuild %s promoted a pre-built image, will not delete it", mosb.Name)
+		return nil
+	}
+
 	moscExists := true
 	_, err := b.listers.machineOSConfigLister.Get(moscName)
 	if k8serrors.IsNotFound(err) {
@@ -1213,7 +1347,10 @@ func (b *buildReconciler) syncMachineOSBuild(ctx context.Context, mosb *mcfgv1.M
 				return nil
 			}
 
-			observer := imagebuilder.NewJobImageBuildObserver(b.kubeclient, b.mcfgclient, mosb, mosc)
+			observer, err := imagebuilder.NewImageBuildObserver(b.kubeclient, b.mcfgclient, mosb, mosc)
+			if err != nil {
+				return fmt.Errorf("could not get observer for MachineOSBuild %q: %w", mosb.Name, err)
+			}
 
 			exists, err := observer.Exists(ctx)
 			if err != nil {
@@ -1224,7 +1361,18 @@ func (b *buildReconciler) syncMachineOSBuild(ctx context.Context, mosb *mcfgv1.M
 				return nil
 			}
 
+			admitted, err := b.admitBuild(ctx, mosb)
+			if err != nil {
+				return fmt.Errorf("could not determine if MachineOSBuild %q may leave the build queue: %w", mosb.Name, err)
+			}
+
+			if !admitted {
+				klog.V(4).Infof("MachineOSBuild %q is waiting in the build queue", mosb.Name)
+				return nil
+			}
+
 			if err := b.startBuild(ctx, mosb); err != nil {
+				b.releaseBuild(mosb)
 				return fmt.Errorf("could not start build for MachineOSBuild %q: %w", mosb.Name, err)
 			}
 
@@ -1577,6 +1725,11 @@ func (b *buildReconciler) getObjectsForImagePruner(mosb *mcfgv1.MachineOSBuild)
 		return nil, nil, fmt.Errorf("MachineOSBuild %s missing annotation %s", mosb.Name, constants.RenderedImagePushSecretAnnotationKey)
 	}
 
+	return b.getObjectsForImagePrunerWithSecret(secretName)
+}
+
+// getObjectsForImagePrunerWithSecret retrieves the named secret and the ControllerConfig for use by the imagepruner.
+func (b *buildReconciler) getObjectsForImagePrunerWithSecret(secretName string) (*corev1.Secret, *mcfgv1.ControllerConfig, error) {
 	secret, err := b.kubeclient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not get rendered push secret %s: %w", secretName, err)
@@ -1607,13 +1760,28 @@ func (b *buildReconciler) inspectImage(ctx context.Context, pullspec string, mos
 
 // deleteImage retrieves the necessary objects and calls DeleteImage on the imagepruner.
 func (b *buildReconciler) deleteImage(ctx context.Context, pullspec string, mosb *mcfgv1.MachineOSBuild) error {
+	return b.deleteImageForObject(ctx, pullspec, mosb, func() (*corev1.Secret, *mcfgv1.ControllerConfig, error) {
+		return b.getObjectsForImagePruner(mosb)
+	})
+}
+
+// deleteImageForObject deletes the given image on behalf of the given object.
+// Images in the internal registry are deleted through the OpenShift API while
+// all other images are deleted by the imagepruner using the objects returned
+// by getObjects.
+func (b *buildReconciler) deleteImageForObject(ctx context.Context, pullspec string, obj kubeObject, getObjects func() (*corev1.Secret, *mcfgv1.ControllerConfig, error)) error {
+	kind, err := utils.GetKindForObject(obj)
+	if err != nil && kind == "" {
+		kind = "<unknown object kind>"
+	}
+
 	isOpenShiftRegistry, err := ctrlcommon.IsOpenShiftRegistry(ctx, pullspec, b.kubeclient, b.routeclient)
 	if err != nil {
 		return err
 	}
 
 	if isOpenShiftRegistry {
-		klog.Infof("Deleting image %s from internal registry for MachineOSBuild %s", pullspec, mosb.Name)
+		klog.Infof("Deleting image %s from internal registry for %s %s", pullspec, kind, obj.GetName())
 		// Use the openshift API to delete the image
 		ns, img, err := extractNSAndNameWithTag(pullspec)
 		if err != nil {
@@ -1621,16 +1789,16 @@ func (b *buildReconciler) deleteImage(ctx context.Context, pullspec string, mosb
 		}
 		if err := b.imageclient.ImageV1().ImageStreamTags(ns).Delete(context.TODO(), img, metav1.DeleteOptions{}); err != nil {
 			if k8serrors.IsNotFound(err) {
-				klog.Infof("image %s for MachineOSBuild %s not found", pullspec, mosb.Name)
+				klog.Infof("image %s for %s %s not found", pullspec, kind, obj.GetName())
 				return nil
 			}
-			return fmt.Errorf("could not delete image %s from internal registry for MachineOSBuild %s: %w", pullspec, mosb.Name, err)
+			return fmt.Errorf("could not delete image %s from internal registry for %s %s: %w", pullspec, kind, obj.GetName(), err)
 		}
 		return nil
 	}
 
-	klog.Infof("Deleting image %s from external registry using skopeo for MachineOSBuild %s", pullspec, mosb.Name)
-	secret, cc, err := b.getObjectsForImagePruner(mosb)
+	klog.Infof("Deleting image %s from external registry using skopeo for %s %s", pullspec, kind, obj.GetName())
+	secret, cc, err := getObjects()
 	if err != nil {
 		return err
 	}
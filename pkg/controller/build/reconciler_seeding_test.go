@@ -7,10 +7,12 @@ import (
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	fakemcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/controller/build/constants"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestAddMachineOSConfigRouting(t *testing.T) {
@@ -416,3 +418,17 @@ func TestSecretValidationInSeeding(t *testing.T) {
 	// This test verifies that our new secret validation approach is integrated
 	// The original tests still pass, confirming the refactoring was successful
 }
+
+func TestPollDeletedMachineOSBuild(t *testing.T) {
+	reconciler := &buildReconciler{
+		listers: &listers{
+			machineOSBuildLister: mcfglistersv1.NewMachineOSBuildLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		},
+	}
+
+	// A MachineOSBuild deleted while it was being polled is skipped
+	mosb := &mcfgv1.MachineOSBuild{ObjectMeta: metav1.ObjectMeta{Name: "deleted-build"}}
+	if err := reconciler.pollMachineOSBuild(context.Background(), mosb); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
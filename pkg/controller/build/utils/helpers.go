@@ -82,11 +82,43 @@ func GetBuildJobName(mosb *mcfgv1.MachineOSBuild) string {
 	return fmt.Sprintf("build-%s", getFieldFromMachineOSBuild(mosb))
 }
 
+// Computes the name of the build job for a single architecture of a
+// multi-architecture build.
+func GetArchitectureBuildJobName(mosb *mcfgv1.MachineOSBuild, arch string) string {
+	return fmt.Sprintf("%s-%s", GetBuildJobName(mosb), arch)
+}
+
+// Computes the pullspec a single architecture of a multi-architecture build is
+// pushed to by suffixing the tag with the architecture.
+func GetArchitectureImagePullspec(pullspec, arch string) (string, error) {
+	named, err := reference.ParseNamed(pullspec)
+	if err != nil {
+		return "", fmt.Errorf("could not parse image pullspec %s: %w", pullspec, err)
+	}
+
+	tag := "latest"
+	if tagged, ok := named.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+
+	archTagged, err := reference.WithTag(reference.TrimNamed(named), fmt.Sprintf("%s-%s", tag, arch))
+	if err != nil {
+		return "", fmt.Errorf("could not tag image pullspec %s for %s: %w", pullspec, arch, err)
+	}
+
+	return archTagged.String(), nil
+}
+
 // Computes the digest configmap name.
 func GetDigestConfigMapName(mosb *mcfgv1.MachineOSBuild) string {
 	return fmt.Sprintf("digest-%s", getFieldFromMachineOSBuild(mosb))
 }
 
+// Computes the build logs configmap name.
+func GetBuildLogsConfigMapName(mosb *mcfgv1.MachineOSBuild) string {
+	return fmt.Sprintf("build-logs-%s", getFieldFromMachineOSBuild(mosb))
+}
+
 // Computes the base image pull secret name.
 func GetBasePullSecretName(mosb *mcfgv1.MachineOSBuild) string {
 	return fmt.Sprintf("base-%s", getFieldFromMachineOSBuild(mosb))
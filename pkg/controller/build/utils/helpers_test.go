@@ -14,3 +14,19 @@ func TestParseImagePullspec(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "registry.hostname.com/org/repo@sha256:628e4e8f0a78d91015c6cebeee95931ae2e8defe5dfb4ced4a82830e08937573", out)
 }
+
+// Tests that the architecture is appended to the tag of a given pullspec.
+func TestGetArchitectureImagePullspec(t *testing.T) {
+	t.Parallel()
+
+	out, err := GetArchitectureImagePullspec("registry.hostname.com/org/repo:worker-1234", "arm64")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.hostname.com/org/repo:worker-1234-arm64", out)
+
+	out, err = GetArchitectureImagePullspec("registry.hostname.com/org/repo", "s390x")
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.hostname.com/org/repo:latest-s390x", out)
+
+	_, err = GetArchitectureImagePullspec("Not a pullspec", "amd64")
+	assert.Error(t, err)
+}
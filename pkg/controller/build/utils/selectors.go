@@ -167,6 +167,14 @@ func IsObjectCreatedByBuildController(obj metav1.Object) bool {
 	return false
 }
 
+// Determines if a Job builds a single architecture of a multi-architecture
+// build. These Jobs are owned by the build Job and do not reflect the status
+// of the MachineOSBuild on their own.
+func IsArchitectureBuildJob(obj metav1.Object) bool {
+	_, ok := obj.GetLabels()[constants.BuildArchitectureLabelKey]
+	return ok
+}
+
 // Determines if a secret has been canonicalized by us by checking both for the
 // suffix as well as the labels that we add to the canonicalized secret.
 func isCanonicalizedSecret(secret *corev1.Secret) bool {
@@ -0,0 +1,258 @@
+package certrotationcontroller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vincent-petithory/dataurl"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	opv1 "github.com/openshift/api/operator/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+const (
+	// Annotations on the MachineConfiguration object that override how long before expiry a certificate
+	// is reported as expiring. Values are Go durations, e.g. "720h".
+	CertExpiryWarningThresholdAnnotationKey  = "machineconfiguration.openshift.io/cert-expiry-warning-threshold"
+	CertExpiryCriticalThresholdAnnotationKey = "machineconfiguration.openshift.io/cert-expiry-critical-threshold"
+
+	defaultCertExpiryWarningThreshold  = 30 * 24 * time.Hour
+	defaultCertExpiryCriticalThreshold = 7 * 24 * time.Hour
+
+	// How often the certificate expiry inventory is refreshed
+	certExpiryResyncPeriod = 10 * time.Minute
+
+	// MachineConfigurationCertificateExpiryWarning is the MachineConfiguration status condition reporting
+	// MCO-managed certificates that are close to expiry
+	MachineConfigurationCertificateExpiryWarning = "CertificateExpiryWarning"
+
+	certExpiryReasonAsExpected       = "AsExpected"
+	certExpiryReasonWarningThreshold = "WarningThresholdReached"
+	certExpiryReasonCriticalExpiry   = "CriticalThresholdReached"
+
+	// Names of the certificates in the expiry inventory. User data secrets are reported as
+	// user-data/<secret name>.
+	certExpiryNameMCSCA          = "machine-config-server-ca"
+	certExpiryNameMCSTLS         = "machine-config-server-tls"
+	certExpiryNameIRITLS         = "internal-release-image-tls"
+	certExpiryNameKubeletCA      = "kubelet-ca"
+	certExpiryNameRootCA         = "root-ca"
+	certExpiryNameUserDataPrefix = "user-data/"
+)
+
+// certExpiry is a single entry of the certificate expiry inventory
+type certExpiry struct {
+	name     string
+	notAfter time.Time
+}
+
+// certExpiryThresholds holds how long before expiry a certificate is reported as expiring
+type certExpiryThresholds struct {
+	warning  time.Duration
+	critical time.Duration
+}
+
+// runCertExpiry refreshes the certificate expiry inventory, metrics and condition
+func (c *CertRotationController) runCertExpiry() {
+	if err := c.syncCertExpiry(time.Now()); err != nil {
+		klog.Errorf("Error syncing certificate expiry: %v", err)
+	}
+}
+
+func (c *CertRotationController) syncCertExpiry(now time.Time) error {
+	mcop, err := c.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get MachineConfiguration %s: %w", ctrlcommon.MCOOperatorKnobsObjectName, err)
+	}
+	thresholds := getCertExpiryThresholds(mcop)
+
+	inventory := c.getCertExpiryInventory()
+
+	ctrlcommon.MCCCertExpirySeconds.Reset()
+	for _, cert := range inventory {
+		ctrlcommon.MCCCertExpirySeconds.WithLabelValues(cert.name).Set(cert.notAfter.Sub(now).Seconds())
+	}
+	ctrlcommon.MCCCertExpiryThresholdSeconds.WithLabelValues("warning").Set(thresholds.warning.Seconds())
+	ctrlcommon.MCCCertExpiryThresholdSeconds.WithLabelValues("critical").Set(thresholds.critical.Seconds())
+
+	return ctrlcommon.SetMachineConfigurationCondition(context.TODO(), c.mcopClient, getCertExpiryCondition(inventory, thresholds, now))
+}
+
+// getCertExpiryThresholds returns the expiry thresholds, honouring the overrides set on the MachineConfiguration
+func getCertExpiryThresholds(mcop *opv1.MachineConfiguration) certExpiryThresholds {
+	thresholds := certExpiryThresholds{
+		warning:  defaultCertExpiryWarningThreshold,
+		critical: defaultCertExpiryCriticalThreshold,
+	}
+	for key, threshold := range map[string]*time.Duration{
+		CertExpiryWarningThresholdAnnotationKey:  &thresholds.warning,
+		CertExpiryCriticalThresholdAnnotationKey: &thresholds.critical,
+	} {
+		value, ok := mcop.GetAnnotations()[key]
+		if !ok {
+			continue
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			klog.Warningf("Ignoring invalid %s annotation %q on MachineConfiguration %s, using %s", key, value, mcop.Name, *threshold)
+			continue
+		}
+		*threshold = duration
+	}
+	if thresholds.critical > thresholds.warning {
+		klog.Warningf("Certificate expiry critical threshold %s is longer than the warning threshold %s, using %s for both", thresholds.critical, thresholds.warning, thresholds.critical)
+		thresholds.warning = thresholds.critical
+	}
+	return thresholds
+}
+
+// getCertExpiryCondition reports the certificates in the inventory that have reached a threshold
+func getCertExpiryCondition(inventory []certExpiry, thresholds certExpiryThresholds, now time.Time) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    MachineConfigurationCertificateExpiryWarning,
+		Status:  metav1.ConditionFalse,
+		Reason:  certExpiryReasonAsExpected,
+		Message: fmt.Sprintf("%d MCO-managed certificates are valid for longer than %s", len(inventory), thresholds.warning),
+	}
+
+	expiring := []string{}
+	for _, cert := range inventory {
+		remaining := cert.notAfter.Sub(now)
+		if remaining > thresholds.warning {
+			continue
+		}
+		if remaining <= 0 {
+			expiring = append(expiring, fmt.Sprintf("%s expired at %s", cert.name, cert.notAfter.UTC().Format(time.RFC3339)))
+		} else {
+			expiring = append(expiring, fmt.Sprintf("%s expires at %s", cert.name, cert.notAfter.UTC().Format(time.RFC3339)))
+		}
+		condition.Status = metav1.ConditionTrue
+		if remaining <= thresholds.critical {
+			condition.Reason = certExpiryReasonCriticalExpiry
+		} else if condition.Reason != certExpiryReasonCriticalExpiry {
+			condition.Reason = certExpiryReasonWarningThreshold
+		}
+	}
+	if len(expiring) > 0 {
+		condition.Message = strings.Join(expiring, "; ")
+	}
+	return condition
+}
+
+// getCertExpiryInventory returns the expiry of every MCO-managed certificate that can be found. For CA
+// bundles, the latest expiry is reported, as the bundle remains usable until its newest CA expires.
+func (c *CertRotationController) getCertExpiryInventory() []certExpiry {
+	inventory := []certExpiry{}
+	add := func(name string, data []byte) {
+		notAfter, err := getBundleNotAfter(data)
+		if err != nil {
+			klog.Warningf("Cannot determine expiry of certificate %s: %v", name, err)
+			return
+		}
+		inventory = append(inventory, certExpiry{name: name, notAfter: notAfter})
+	}
+
+	for name, secretName := range map[string]string{
+		certExpiryNameMCSCA:  ctrlcommon.MachineConfigServerCAName,
+		certExpiryNameMCSTLS: ctrlcommon.MachineConfigServerTLSSecretName,
+		certExpiryNameIRITLS: ctrlcommon.InternalReleaseImageTLSSecretName,
+	} {
+		secret, err := c.mcoSecretLister.Secrets(ctrlcommon.MCONamespace).Get(secretName)
+		if err != nil {
+			if !k8serrors.IsNotFound(err) {
+				klog.Warningf("Cannot get secret %s for certificate expiry: %v", secretName, err)
+			}
+			continue
+		}
+		add(name, secret.Data[corev1.TLSCertKey])
+	}
+
+	controllerConfig, err := c.mcfgClient.MachineconfigurationV1().ControllerConfigs().Get(context.TODO(), ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("Cannot get ControllerConfig %s for certificate expiry: %v", ctrlcommon.ControllerConfigName, err)
+	} else {
+		add(certExpiryNameKubeletCA, controllerConfig.Spec.KubeAPIServerServingCAData)
+		add(certExpiryNameRootCA, controllerConfig.Spec.RootCAData)
+	}
+
+	userDataSecrets, err := c.maoSecretLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("Cannot list MAO secrets for certificate expiry: %v", err)
+	}
+	for _, secret := range userDataSecrets {
+		if !isUserDataSecret(*secret) {
+			continue
+		}
+		caData, err := getUserDataCA(secret)
+		if err != nil {
+			klog.Warningf("Cannot determine expiry of certificate %s%s: %v", certExpiryNameUserDataPrefix, secret.Name, err)
+			continue
+		}
+		add(certExpiryNameUserDataPrefix+secret.Name, caData)
+	}
+
+	sort.Slice(inventory, func(i, j int) bool { return inventory[i].name < inventory[j].name })
+	return inventory
+}
+
+// getUserDataCA returns the CA bundle embedded in the pointer ignition of a *-user-data secret
+func getUserDataCA(secret *corev1.Secret) ([]byte, error) {
+	var userDataIgn interface{}
+	if err := json.Unmarshal(secret.Data[ctrlcommon.UserDataKey], &userDataIgn); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user-data: %w", err)
+	}
+	cas, found, err := unstructured.NestedSlice(userDataIgn.(map[string]interface{}), ctrlcommon.IgnFieldIgnition, "security", "tls", "certificateAuthorities")
+	if err != nil || !found || len(cas) == 0 {
+		return nil, fmt.Errorf("no certificateAuthorities found in user-data")
+	}
+	ca, ok := cas[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("malformed certificateAuthorities in user-data")
+	}
+	source, _ := ca[ctrlcommon.IgnFieldSource].(string)
+	caData, err := dataurl.DecodeString(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode CA source in user-data: %w", err)
+	}
+	return caData.Data, nil
+}
+
+// getBundleNotAfter returns the latest expiry of the certificates in a PEM bundle
+func getBundleNotAfter(data []byte) (time.Time, error) {
+	var notAfter time.Time
+	for len(data) > 0 {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		data = rest
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if cert.NotAfter.After(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	if notAfter.IsZero() {
+		return time.Time{}, fmt.Errorf("no certificates found")
+	}
+	return notAfter, nil
+}
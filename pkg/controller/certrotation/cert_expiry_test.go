@@ -0,0 +1,194 @@
+package certrotationcontroller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/crypto"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetCertExpiryThresholds(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    certExpiryThresholds
+	}{
+		{
+			name:     "Defaults",
+			expected: certExpiryThresholds{warning: defaultCertExpiryWarningThreshold, critical: defaultCertExpiryCriticalThreshold},
+		},
+		{
+			name: "Overrides",
+			annotations: map[string]string{
+				CertExpiryWarningThresholdAnnotationKey:  "2160h",
+				CertExpiryCriticalThresholdAnnotationKey: "720h",
+			},
+			expected: certExpiryThresholds{warning: 2160 * time.Hour, critical: 720 * time.Hour},
+		},
+		{
+			name: "Invalid overrides are ignored",
+			annotations: map[string]string{
+				CertExpiryWarningThresholdAnnotationKey:  "30 days",
+				CertExpiryCriticalThresholdAnnotationKey: "-1h",
+			},
+			expected: certExpiryThresholds{warning: defaultCertExpiryWarningThreshold, critical: defaultCertExpiryCriticalThreshold},
+		},
+		{
+			name: "Warning threshold is never shorter than the critical threshold",
+			annotations: map[string]string{
+				CertExpiryCriticalThresholdAnnotationKey: "2160h",
+			},
+			expected: certExpiryThresholds{warning: 2160 * time.Hour, critical: 2160 * time.Hour},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mcop := &opv1.MachineConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Annotations: test.annotations}}
+			assert.Equal(t, test.expected, getCertExpiryThresholds(mcop))
+		})
+	}
+}
+
+func TestGetCertExpiryCondition(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	thresholds := certExpiryThresholds{warning: 30 * 24 * time.Hour, critical: 7 * 24 * time.Hour}
+
+	tests := []struct {
+		name            string
+		inventory       []certExpiry
+		expectedStatus  metav1.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+	}{
+		{
+			name:            "All certificates valid",
+			inventory:       []certExpiry{{name: "machine-config-server-ca", notAfter: now.Add(365 * 24 * time.Hour)}},
+			expectedStatus:  metav1.ConditionFalse,
+			expectedReason:  certExpiryReasonAsExpected,
+			expectedMessage: "1 MCO-managed certificates are valid for longer than 720h0m0s",
+		},
+		{
+			name: "Warning threshold reached",
+			inventory: []certExpiry{
+				{name: "kubelet-ca", notAfter: now.Add(10 * 24 * time.Hour)},
+				{name: "machine-config-server-ca", notAfter: now.Add(365 * 24 * time.Hour)},
+			},
+			expectedStatus:  metav1.ConditionTrue,
+			expectedReason:  certExpiryReasonWarningThreshold,
+			expectedMessage: "kubelet-ca expires at 2025-01-11T00:00:00Z",
+		},
+		{
+			name: "Critical threshold reached",
+			inventory: []certExpiry{
+				{name: "kubelet-ca", notAfter: now.Add(10 * 24 * time.Hour)},
+				{name: "user-data/worker-user-data", notAfter: now.Add(-time.Hour)},
+			},
+			expectedStatus:  metav1.ConditionTrue,
+			expectedReason:  certExpiryReasonCriticalExpiry,
+			expectedMessage: "kubelet-ca expires at 2025-01-11T00:00:00Z; user-data/worker-user-data expired at 2024-12-31T23:00:00Z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			condition := getCertExpiryCondition(test.inventory, thresholds, now)
+			assert.Equal(t, MachineConfigurationCertificateExpiryWarning, condition.Type)
+			assert.Equal(t, test.expectedStatus, condition.Status)
+			assert.Equal(t, test.expectedReason, condition.Reason)
+			assert.Equal(t, test.expectedMessage, condition.Message)
+		})
+	}
+}
+
+func TestSyncCertExpiry(t *testing.T) {
+	now := time.Now()
+
+	mcsCA := getTestCertPEM(t, "mcs-ca", 5*24*time.Hour)
+	userDataCA := getTestCertPEM(t, "user-data-ca", 365*24*time.Hour)
+	// The kubelet CA bundle holds an old CA that is about to expire, and its replacement
+	kubeletCABundle := append(getTestCertPEM(t, "old-kubelet-ca", 24*time.Hour), getTestCertPEM(t, "new-kubelet-ca", 365*24*time.Hour)...)
+
+	f := newFixture(t)
+	f.mcoSecretLister = append(f.mcoSecretLister, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ctrlcommon.MachineConfigServerCAName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string][]byte{corev1.TLSCertKey: mcsCA},
+	})
+	f.maoSecretLister = append(f.maoSecretLister, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "worker-user-data",
+			Namespace: ctrlcommon.MachineAPINamespace,
+		},
+		Data: map[string][]byte{"userData": []byte(fmt.Sprintf(`{"ignition":{"security":{"tls":{"certificateAuthorities":[{"source":%q}]}},"version":"3.2.0"}}`, dataurl.EncodeBytes(userDataCA)))},
+	})
+	f.mcfgObjects = append(f.mcfgObjects, &mcfgv1.ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.ControllerConfigName},
+		Spec: mcfgv1.ControllerConfigSpec{
+			KubeAPIServerServingCAData: kubeletCABundle,
+		},
+	})
+	f.mcopObjects = append(f.mcopObjects, &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ctrlcommon.MCOOperatorKnobsObjectName,
+			Annotations: map[string]string{CertExpiryCriticalThresholdAnnotationKey: "168h"},
+		},
+	})
+	f.controller = f.newController()
+
+	require.NoError(t, f.controller.syncCertExpiry(now))
+
+	inventory := f.controller.getCertExpiryInventory()
+	names := []string{}
+	for _, cert := range inventory {
+		names = append(names, cert.name)
+	}
+	assert.Equal(t, []string{certExpiryNameKubeletCA, certExpiryNameMCSCA, "user-data/worker-user-data"}, names)
+
+	assert.InDelta(t, (5 * 24 * time.Hour).Seconds(), testutil.ToFloat64(ctrlcommon.MCCCertExpirySeconds.WithLabelValues(certExpiryNameMCSCA)), 60)
+	// The latest expiry of a bundle is reported
+	assert.InDelta(t, (365 * 24 * time.Hour).Seconds(), testutil.ToFloat64(ctrlcommon.MCCCertExpirySeconds.WithLabelValues(certExpiryNameKubeletCA)), 60)
+	assert.InDelta(t, (365 * 24 * time.Hour).Seconds(), testutil.ToFloat64(ctrlcommon.MCCCertExpirySeconds.WithLabelValues("user-data/worker-user-data")), 60)
+	assert.Equal(t, (7 * 24 * time.Hour).Seconds(), testutil.ToFloat64(ctrlcommon.MCCCertExpiryThresholdSeconds.WithLabelValues("critical")))
+
+	mcop, err := f.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	require.NoError(t, err)
+	condition := meta.FindStatusCondition(mcop.Status.Conditions, MachineConfigurationCertificateExpiryWarning)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, certExpiryReasonCriticalExpiry, condition.Reason)
+	assert.Contains(t, condition.Message, certExpiryNameMCSCA)
+	assert.NotContains(t, condition.Message, certExpiryNameKubeletCA)
+
+	// An unchanged condition is not written again
+	f.mcopClient.ClearActions()
+	require.NoError(t, f.controller.syncCertExpiry(now))
+	for _, action := range f.mcopClient.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+}
+
+// getTestCertPEM returns a self-signed certificate valid for the given lifetime
+func getTestCertPEM(t *testing.T, name string, lifetime time.Duration) []byte {
+	t.Helper()
+	caConfig, err := crypto.MakeSelfSignedCAConfig(name, lifetime)
+	require.NoError(t, err)
+	certPEM, _, err := caConfig.GetPEMBytes()
+	require.NoError(t, err)
+	return certPEM
+}
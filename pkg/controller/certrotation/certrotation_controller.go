@@ -35,6 +35,7 @@ import (
 	configclientset "github.com/openshift/client-go/config/clientset/versioned"
 	machineclientset "github.com/openshift/client-go/machine/clientset/versioned"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
+	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
 
 	"github.com/openshift/library-go/pkg/controller/factory"
 	"github.com/openshift/library-go/pkg/crypto"
@@ -64,6 +65,7 @@ type CertRotationController struct {
 	kubeClient   kubernetes.Interface
 	configClient configclientset.Interface
 	mcfgClient   mcfgclientset.Interface
+	mcopClient   mcopclientset.Interface
 	aroClient    aroclientset.Interface
 
 	mcoConfigMapInfomer coreinformersv1.ConfigMapInformer
@@ -98,6 +100,7 @@ func New(
 	infraInformer configinformers.InfrastructureInformer,
 	featureGatesHandler ctrlcommon.FeatureGatesHandler,
 	mcfgClient mcfgclientset.Interface,
+	mcopClient mcopclientset.Interface,
 ) (*CertRotationController, error) {
 
 	recorder := events.NewLoggingEventRecorder(componentName, clock.RealClock{})
@@ -106,6 +109,7 @@ func New(
 		kubeClient:          kubeClient,
 		configClient:        configClient,
 		mcfgClient:          mcfgClient,
+		mcopClient:          mcopClient,
 		aroClient:           aroClient,
 		recorder:            recorder,
 		maoSecretInformer:   maoSecretInformer,
@@ -211,9 +215,13 @@ func (c *CertRotationController) Run(ctx context.Context, workers int) {
 	defer klog.Infof("Shutting down %s", componentName)
 	c.WaitForReady(ctx.Done())
 
+	// Certificate expiry is reported even if no certificates are rotated by this controller
+	go wait.Until(c.runCertExpiry, certExpiryResyncPeriod, ctx.Done())
+
 	if len(c.certRotators) == 0 {
-		// If there are no cert rotators, we can shutdown
-		klog.Infof("No cert rotators needed, shutting down")
+		// If there are no cert rotators, only the expiry reporting is needed
+		klog.Infof("No cert rotators needed")
+		<-ctx.Done()
 		return
 	}
 
@@ -235,6 +243,8 @@ func (c *CertRotationController) Run(ctx context.Context, workers int) {
 		go certRotator.Run(ctx, workers)
 	}
 
+	go wait.Until(c.runMCSCARotation, mcsCARotationResyncPeriod, ctx.Done())
+
 	<-ctx.Done()
 }
 
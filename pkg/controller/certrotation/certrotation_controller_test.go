@@ -31,6 +31,7 @@ import (
 	fakeconfigv1client "github.com/openshift/client-go/config/clientset/versioned/fake"
 	fakemachineclientset "github.com/openshift/client-go/machine/clientset/versioned/fake"
 	fakemcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	fakemcopclientset "github.com/openshift/client-go/operator/clientset/versioned/fake"
 
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 )
@@ -46,6 +47,7 @@ type fixture struct {
 	configClient  *fakeconfigv1client.Clientset
 	machineClient *fakemachineclientset.Clientset
 	mcfgClient    *fakemcfgclientset.Clientset
+	mcopClient    *fakemcopclientset.Clientset
 	aroClient     *fakearoclientset.Clientset
 
 	maoSecretLister    []*corev1.Secret
@@ -57,6 +59,7 @@ type fixture struct {
 	configObjects  []runtime.Object
 	machineObjects []runtime.Object
 	mcfgObjects    []runtime.Object
+	mcopObjects    []runtime.Object
 	aroObjects     []runtime.Object
 	k8sI           kubeinformers.SharedInformerFactory
 	infraInformer  configinformers.SharedInformerFactory
@@ -71,6 +74,7 @@ func newFixture(t *testing.T) *fixture {
 	f.configObjects = []runtime.Object{}
 	f.machineObjects = []runtime.Object{}
 	f.mcfgObjects = []runtime.Object{}
+	f.mcopObjects = []runtime.Object{}
 	f.aroObjects = []runtime.Object{}
 	return f
 }
@@ -99,6 +103,7 @@ func (f *fixture) newController() *CertRotationController {
 	f.configClient = fakeconfigv1client.NewSimpleClientset(f.configObjects...)
 	f.machineClient = fakemachineclientset.NewSimpleClientset(f.machineObjects...)
 	f.mcfgClient = fakemcfgclientset.NewSimpleClientset(f.mcfgObjects...)
+	f.mcopClient = fakemcopclientset.NewSimpleClientset(f.mcopObjects...)
 	f.aroClient = fakearoclientset.NewSimpleClientset(f.aroObjects...)
 	f.k8sI = kubeinformers.NewSharedInformerFactory(f.kubeClient, noResyncPeriodFunc())
 	f.infraInformer = configinformers.NewSharedInformerFactory(f.configClient, noResyncPeriodFunc())
@@ -124,7 +129,7 @@ func (f *fixture) newController() *CertRotationController {
 		[]configv1.FeatureGateName{features.FeatureGateNoRegistryClusterInstall},
 		nil,
 	)
-	c, err := New(f.kubeClient, f.configClient, f.machineClient, f.aroClient, f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().ConfigMaps(), f.infraInformer.Config().V1().Infrastructures(), fgHandler, f.mcfgClient)
+	c, err := New(f.kubeClient, f.configClient, f.machineClient, f.aroClient, f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().ConfigMaps(), f.infraInformer.Config().V1().Infrastructures(), fgHandler, f.mcfgClient, f.mcopClient)
 	require.NoError(f.t, err)
 
 	c.StartInformers()
@@ -453,6 +458,7 @@ func TestIRICertificateReconcileSkippedWhenFeatureGateDisabled(t *testing.T) {
 	f.configClient = fakeconfigv1client.NewSimpleClientset(f.configObjects...)
 	f.machineClient = fakemachineclientset.NewSimpleClientset(f.machineObjects...)
 	f.mcfgClient = fakemcfgclientset.NewSimpleClientset(f.mcfgObjects...)
+	f.mcopClient = fakemcopclientset.NewSimpleClientset(f.mcopObjects...)
 	f.aroClient = fakearoclientset.NewSimpleClientset(f.aroObjects...)
 	f.k8sI = kubeinformers.NewSharedInformerFactory(f.kubeClient, noResyncPeriodFunc())
 	f.infraInformer = configinformers.NewSharedInformerFactory(f.configClient, noResyncPeriodFunc())
@@ -464,7 +470,7 @@ func TestIRICertificateReconcileSkippedWhenFeatureGateDisabled(t *testing.T) {
 	c, err := New(f.kubeClient, f.configClient, f.machineClient, f.aroClient,
 		f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().Secrets(),
 		f.k8sI.Core().V1().ConfigMaps(), f.infraInformer.Config().V1().Infrastructures(),
-		fgHandler, f.mcfgClient)
+		fgHandler, f.mcfgClient, f.mcopClient)
 	require.NoError(t, err)
 
 	// reconcileIRICertificate must be a no-op when the feature gate is disabled.
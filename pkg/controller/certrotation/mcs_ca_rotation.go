@@ -0,0 +1,460 @@
+package certrotationcontroller
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/openshift/library-go/pkg/crypto"
+	"github.com/openshift/library-go/pkg/operator/certrotation"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+const (
+	// Annotation on the MachineConfiguration object requesting a rotation of the MCS CA. Any new value,
+	// e.g. a timestamp, starts a new rotation once the previous one has completed.
+	MCSCARotationAnnotationKey = "machineconfiguration.openshift.io/mcs-ca-rotation"
+
+	// Annotation on the MachineConfiguration object setting how long the old MCS CA stays trusted by
+	// the user-data secrets after the MCS serving cert has been re-issued. Value is a Go duration.
+	MCSCARotationOverlapAnnotationKey = "machineconfiguration.openshift.io/mcs-ca-rotation-overlap"
+
+	defaultMCSCARotationOverlap = 24 * time.Hour
+
+	// How often an MCS CA rotation is checked for progress
+	mcsCARotationResyncPeriod = 30 * time.Second
+
+	// MCSCARotationStateConfigMapName is the name of the configmap in the MCO namespace recording the
+	// progress of an MCS CA rotation, so that it can be resumed after a controller restart
+	MCSCARotationStateConfigMapName = "machine-config-server-ca-rotation"
+
+	// MachineConfigurationMCSCARotation is the MachineConfiguration status condition reporting the phase
+	// of an MCS CA rotation
+	MachineConfigurationMCSCARotation = "MachineConfigServerCARotation"
+
+	// Keys of the rotation state configmap
+	mcsCARotationRequestKey      = "request"
+	mcsCARotationPhaseKey        = "phase"
+	mcsCARotationOldCAKey        = "oldCAFingerprint"
+	mcsCARotationNewCAKey        = "newCAFingerprint"
+	mcsCARotationOverlapStartKey = "overlapStartTime"
+)
+
+// Phases of an MCS CA rotation, in order
+const (
+	// A new CA is being generated by the MCS cert rotator
+	mcsCARotationPhaseGeneratingCA = "GeneratingCA"
+	// The CA bundle holding the old and new CAs is being published into the user-data secrets
+	mcsCARotationPhasePublishingBundle = "PublishingBundle"
+	// The MCS serving cert is being re-issued from the new CA
+	mcsCARotationPhaseReissuingServingCert = "ReissuingServingCert"
+	// Both CAs are trusted until the overlap has elapsed, then the old CA is dropped
+	mcsCARotationPhaseOverlap = "Overlap"
+	// The old CA is no longer trusted by any user-data secret
+	mcsCARotationPhaseCompleted = "Completed"
+)
+
+// mcsCARotationState is the progress of an MCS CA rotation
+type mcsCARotationState struct {
+	request      string
+	phase        string
+	oldCA        string
+	newCA        string
+	overlapStart time.Time
+}
+
+// runMCSCARotation advances an on-demand MCS CA rotation, if one was requested
+func (c *CertRotationController) runMCSCARotation() {
+	if err := c.syncMCSCARotation(time.Now()); err != nil {
+		klog.Errorf("Error syncing MCS CA rotation: %v", err)
+	}
+}
+
+func (c *CertRotationController) syncMCSCARotation(now time.Time) error {
+	mcop, err := c.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get MachineConfiguration %s: %w", ctrlcommon.MCOOperatorKnobsObjectName, err)
+	}
+	request := mcop.GetAnnotations()[MCSCARotationAnnotationKey]
+
+	state, err := c.getMCSCARotationState()
+	if err != nil {
+		return err
+	}
+
+	if state == nil || state.phase == mcsCARotationPhaseCompleted {
+		// Nothing to do unless a new rotation was requested
+		if request == "" || (state != nil && state.request == request) {
+			return nil
+		}
+		caCert, err := c.getMCSCACert()
+		if err != nil {
+			return err
+		}
+		klog.Infof("Starting MCS CA rotation %q", request)
+		state = &mcsCARotationState{
+			request: request,
+			phase:   mcsCARotationPhaseGeneratingCA,
+			oldCA:   getCertFingerprint(caCert),
+		}
+		if err := c.saveMCSCARotationState(state); err != nil {
+			return err
+		}
+	} else if state.request != request {
+		klog.Infof("MCS CA rotation %q is in progress, rotation %q will start once it completes", state.request, request)
+	}
+
+	message, err := c.advanceMCSCARotation(state, mcop.GetAnnotations()[MCSCARotationOverlapAnnotationKey], now)
+	if err != nil {
+		message = err.Error()
+	}
+	if saveErr := c.saveMCSCARotationState(state); saveErr != nil {
+		return saveErr
+	}
+	if condErr := c.setMCSCARotationCondition(state, message); condErr != nil {
+		return condErr
+	}
+	return err
+}
+
+// advanceMCSCARotation moves the rotation through as many phases as are ready, and returns a message
+// describing what the rotation is waiting on
+func (c *CertRotationController) advanceMCSCARotation(state *mcsCARotationState, overlapValue string, now time.Time) (string, error) {
+	for {
+		previousPhase := state.phase
+		message, err := c.advanceMCSCARotationPhase(state, overlapValue, now)
+		if err != nil || state.phase == previousPhase || state.phase == mcsCARotationPhaseCompleted {
+			return message, err
+		}
+		klog.Infof("MCS CA rotation %q moved from phase %s to %s", state.request, previousPhase, state.phase)
+	}
+}
+
+func (c *CertRotationController) advanceMCSCARotationPhase(state *mcsCARotationState, overlapValue string, now time.Time) (string, error) {
+	switch state.phase {
+	case mcsCARotationPhaseGeneratingCA:
+		caCert, err := c.getMCSCACert()
+		if err != nil {
+			return "", err
+		}
+		if fingerprint := getCertFingerprint(caCert); fingerprint != state.oldCA {
+			state.newCA = fingerprint
+			state.phase = mcsCARotationPhasePublishingBundle
+			return "", nil
+		}
+		if err := c.expireCertSecret(ctrlcommon.MachineConfigServerCAName, now); err != nil {
+			return "", err
+		}
+		return "Waiting for a new MCS CA to be generated", nil
+
+	case mcsCARotationPhasePublishingBundle:
+		bundle, err := c.getMCSCABundle()
+		if err != nil {
+			return "", err
+		}
+		if !bundleHasFingerprint(bundle, state.newCA) || !bundleHasFingerprint(bundle, state.oldCA) {
+			return fmt.Sprintf("Waiting for configmap %s to hold both the old and new MCS CAs", ctrlcommon.MachineConfigServerCAName), nil
+		}
+		c.reconcileUserDataSecrets()
+		pending, err := c.getUserDataSecretsWithoutCA(state.newCA, true)
+		if err != nil {
+			return "", err
+		}
+		if len(pending) > 0 {
+			return fmt.Sprintf("Waiting for user-data secrets %v to trust the new MCS CA", pending), nil
+		}
+		state.phase = mcsCARotationPhaseReissuingServingCert
+		return "", nil
+
+	case mcsCARotationPhaseReissuingServingCert:
+		reissued, err := c.isMCSServingCertIssuedBy(state.newCA)
+		if err != nil {
+			return "", err
+		}
+		if !reissued {
+			// The rotator only re-issues the serving cert on its own once its issuer has left the CA bundle,
+			// and the old CA is kept there until the end of the overlap, so the re-issue has to be forced.
+			if err := c.expireCertSecret(ctrlcommon.MachineConfigServerTLSSecretName, now); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Waiting for secret %s to be re-issued from the new MCS CA", ctrlcommon.MachineConfigServerTLSSecretName), nil
+		}
+		state.overlapStart = now
+		state.phase = mcsCARotationPhaseOverlap
+		return "", nil
+
+	case mcsCARotationPhaseOverlap:
+		overlapEnd := state.overlapStart.Add(getMCSCARotationOverlap(overlapValue))
+		if now.Before(overlapEnd) {
+			return fmt.Sprintf("The old MCS CA will be removed from the user-data secrets at %s", overlapEnd.UTC().Format(time.RFC3339)), nil
+		}
+		if err := c.removeFromMCSCABundle(state.oldCA); err != nil {
+			return "", err
+		}
+		c.reconcileUserDataSecrets()
+		pending, err := c.getUserDataSecretsWithoutCA(state.oldCA, false)
+		if err != nil {
+			return "", err
+		}
+		if len(pending) > 0 {
+			return fmt.Sprintf("Waiting for user-data secrets %v to stop trusting the old MCS CA", pending), nil
+		}
+		state.phase = mcsCARotationPhaseCompleted
+		return "", nil
+	}
+
+	return "", fmt.Errorf("unknown MCS CA rotation phase %q", state.phase)
+}
+
+// getMCSCARotationOverlap returns the overlap set on the MachineConfiguration, or the default
+func getMCSCARotationOverlap(value string) time.Duration {
+	if value == "" {
+		return defaultMCSCARotationOverlap
+	}
+	overlap, err := time.ParseDuration(value)
+	if err != nil || overlap < 0 {
+		klog.Warningf("Ignoring invalid %s annotation %q, using %s", MCSCARotationOverlapAnnotationKey, value, defaultMCSCARotationOverlap)
+		return defaultMCSCARotationOverlap
+	}
+	return overlap
+}
+
+// expireCertSecret marks the cert in the given secret as expired, so that the MCS cert rotator replaces it.
+// The cert itself stays valid: an expired CA is kept in the CA bundle until it is removed at the end of
+// the overlap, and an expired serving cert is served until the rotator has re-issued it.
+func (c *CertRotationController) expireCertSecret(name string, now time.Time) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		secret, err := c.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// Already marked as expired, waiting on the rotator
+		if notAfter, err := time.Parse(time.RFC3339, secret.Annotations[certrotation.CertificateNotAfterAnnotation]); err == nil && notAfter.Before(now) {
+			return nil
+		}
+		updated := secret.DeepCopy()
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[certrotation.CertificateNotAfterAnnotation] = now.Add(-time.Minute).Format(time.RFC3339)
+		_, err = c.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// removeFromMCSCABundle drops the CA with the given fingerprint from the MCS CA bundle configmap
+func (c *CertRotationController) removeFromMCSCABundle(fingerprint string) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		configMap, err := c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerCAName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		bundle, err := cert.ParseCertsPEM([]byte(configMap.Data["ca-bundle.crt"]))
+		if err != nil {
+			return fmt.Errorf("cannot parse MCS CA bundle: %w", err)
+		}
+		kept := []*x509.Certificate{}
+		for _, caCert := range bundle {
+			if getCertFingerprint(caCert) != fingerprint {
+				kept = append(kept, caCert)
+			}
+		}
+		if len(kept) == len(bundle) {
+			return nil
+		}
+		if len(kept) == 0 {
+			return fmt.Errorf("refusing to remove the only CA from the MCS CA bundle")
+		}
+		caBytes, err := crypto.EncodeCertificates(kept...)
+		if err != nil {
+			return err
+		}
+		updated := configMap.DeepCopy()
+		updated.Data["ca-bundle.crt"] = string(caBytes)
+		_, err = c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+		if err == nil {
+			klog.Infof("Removed old CA from configmap %s", ctrlcommon.MachineConfigServerCAName)
+		}
+		return err
+	})
+}
+
+// getUserDataSecretsWithoutCA returns the user-data secrets that do not match the expected trust of the
+// CA with the given fingerprint, i.e. secrets not trusting it if trusted is true, or still trusting it if not
+func (c *CertRotationController) getUserDataSecretsWithoutCA(fingerprint string, trusted bool) ([]string, error) {
+	// Do a fresh list here since the lister will be likely out of date
+	secrets, err := c.kubeClient.CoreV1().Secrets(ctrlcommon.MachineAPINamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list MAO secrets: %w", err)
+	}
+	pending := []string{}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !isUserDataSecret(*secret) {
+			continue
+		}
+		caData, err := getUserDataCA(secret)
+		if err != nil {
+			pending = append(pending, secret.Name)
+			continue
+		}
+		bundle, err := cert.ParseCertsPEM(caData)
+		if err != nil || bundleHasFingerprint(bundle, fingerprint) != trusted {
+			pending = append(pending, secret.Name)
+		}
+	}
+	return pending, nil
+}
+
+// isMCSServingCertIssuedBy returns true if the MCS serving cert is signed by the CA with the given fingerprint
+func (c *CertRotationController) isMCSServingCertIssuedBy(fingerprint string) (bool, error) {
+	bundle, err := c.getMCSCABundle()
+	if err != nil {
+		return false, err
+	}
+	var caCert *x509.Certificate
+	for _, bundleCert := range bundle {
+		if getCertFingerprint(bundleCert) == fingerprint {
+			caCert = bundleCert
+		}
+	}
+	if caCert == nil {
+		return false, fmt.Errorf("new MCS CA is missing from configmap %s", ctrlcommon.MachineConfigServerCAName)
+	}
+
+	secret, err := c.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerTLSSecretName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("cannot get MCS TLS secret: %w", err)
+	}
+	servingCerts, err := cert.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return false, fmt.Errorf("cannot parse MCS TLS certificate: %w", err)
+	}
+	return servingCerts[0].CheckSignatureFrom(caCert) == nil, nil
+}
+
+// getMCSCACert returns the current MCS CA certificate
+func (c *CertRotationController) getMCSCACert() (*x509.Certificate, error) {
+	secret, err := c.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerCAName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get MCS CA secret: %w", err)
+	}
+	caCerts, err := cert.ParseCertsPEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse MCS CA certificate: %w", err)
+	}
+	return caCerts[0], nil
+}
+
+// getMCSCABundle returns the CAs in the MCS CA bundle configmap
+func (c *CertRotationController) getMCSCABundle() ([]*x509.Certificate, error) {
+	configMap, err := c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerCAName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read MCS CA bundle configmap: %w", err)
+	}
+	bundle, err := cert.ParseCertsPEM([]byte(configMap.Data["ca-bundle.crt"]))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse MCS CA bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// getMCSCARotationState returns the recorded rotation state, or nil if no rotation was ever started
+func (c *CertRotationController) getMCSCARotationState() (*mcsCARotationState, error) {
+	configMap, err := c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), MCSCARotationStateConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get MCS CA rotation state: %w", err)
+	}
+	state := &mcsCARotationState{
+		request: configMap.Data[mcsCARotationRequestKey],
+		phase:   configMap.Data[mcsCARotationPhaseKey],
+		oldCA:   configMap.Data[mcsCARotationOldCAKey],
+		newCA:   configMap.Data[mcsCARotationNewCAKey],
+	}
+	if value := configMap.Data[mcsCARotationOverlapStartKey]; value != "" {
+		if state.overlapStart, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("cannot parse %s in configmap %s: %w", mcsCARotationOverlapStartKey, MCSCARotationStateConfigMapName, err)
+		}
+	}
+	return state, nil
+}
+
+// saveMCSCARotationState records the rotation state, creating the configmap if needed
+func (c *CertRotationController) saveMCSCARotationState(state *mcsCARotationState) error {
+	data := map[string]string{
+		mcsCARotationRequestKey: state.request,
+		mcsCARotationPhaseKey:   state.phase,
+		mcsCARotationOldCAKey:   state.oldCA,
+		mcsCARotationNewCAKey:   state.newCA,
+	}
+	if !state.overlapStart.IsZero() {
+		data[mcsCARotationOverlapStartKey] = state.overlapStart.UTC().Format(time.RFC3339)
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		configMap, err := c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), MCSCARotationStateConfigMapName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      MCSCARotationStateConfigMapName,
+					Namespace: ctrlcommon.MCONamespace,
+				},
+				Data: data,
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		updated := configMap.DeepCopy()
+		updated.Data = data
+		_, err = c.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), updated, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// setMCSCARotationCondition reports the rotation phase on the MachineConfiguration status
+func (c *CertRotationController) setMCSCARotationCondition(state *mcsCARotationState, message string) error {
+	condition := metav1.Condition{
+		Type:    MachineConfigurationMCSCARotation,
+		Status:  metav1.ConditionTrue,
+		Reason:  state.phase,
+		Message: fmt.Sprintf("MCS CA rotation %q: %s", state.request, message),
+	}
+	if state.phase == mcsCARotationPhaseCompleted {
+		condition.Status = metav1.ConditionFalse
+		condition.Message = fmt.Sprintf("MCS CA rotation %q completed", state.request)
+	}
+
+	return ctrlcommon.SetMachineConfigurationCondition(context.TODO(), c.mcopClient, condition)
+}
+
+// getCertFingerprint returns the SHA-256 fingerprint of a certificate
+func getCertFingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// bundleHasFingerprint returns true if the bundle holds the certificate with the given fingerprint
+func bundleHasFingerprint(bundle []*x509.Certificate, fingerprint string) bool {
+	for _, c := range bundle {
+		if getCertFingerprint(c) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+package certrotationcontroller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vincent-petithory/dataurl"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/crypto"
+	"github.com/openshift/library-go/pkg/operator/certrotation"
+	"github.com/openshift/library-go/pkg/operator/events"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/utils/clock"
+)
+
+func TestOnDemandMCSCARotation(t *testing.T) {
+	now := time.Now()
+	oldCA, oldCAPEM := getTestCA(t, "old-mcs-ca")
+	newCA, newCAPEM := getTestCA(t, "new-mcs-ca")
+
+	f := newFixture(t)
+	userDataSecret := getUserDataSecretWithCA("worker-user-data", oldCAPEM)
+	f.objects = append(f.objects,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MachineConfigServerCAName, Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string][]byte{corev1.TLSCertKey: oldCAPEM},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MachineConfigServerCAName, Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string]string{"ca-bundle.crt": string(oldCAPEM)},
+		},
+		getServingCertSecret(t, oldCA),
+		userDataSecret,
+	)
+	f.maoSecretLister = append(f.maoSecretLister, userDataSecret)
+	f.mcopObjects = append(f.mcopObjects, &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ctrlcommon.MCOOperatorKnobsObjectName,
+			Annotations: map[string]string{
+				MCSCARotationAnnotationKey:        "2025-01-01",
+				MCSCARotationOverlapAnnotationKey: "1h",
+			},
+		},
+	})
+	f.controller = f.newController()
+
+	// The current CA is marked as expired so that the rotator generates a new one
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseGeneratingCA, metav1.ConditionTrue)
+	caSecret, err := f.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerCAName, metav1.GetOptions{})
+	require.NoError(t, err)
+	notAfter, err := time.Parse(time.RFC3339, caSecret.Annotations[certrotation.CertificateNotAfterAnnotation])
+	require.NoError(t, err)
+	assert.True(t, notAfter.Before(now))
+
+	// Simulate the rotator generating a new CA and adding it to the bundle
+	caSecret.Data[corev1.TLSCertKey] = newCAPEM
+	_, err = f.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Update(context.TODO(), caSecret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	f.setMCSCABundle(t, append(append([]byte{}, oldCAPEM...), newCAPEM...))
+
+	// The bundle with both CAs is published, then the serving cert is waited on
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseReissuingServingCert, metav1.ConditionTrue)
+	assert.Equal(t, []string{"new-mcs-ca", "old-mcs-ca"}, f.getUserDataCANames(t, "worker-user-data"))
+
+	// A restarted controller resumes the rotation from the recorded phase
+	f.controller, err = New(f.kubeClient, f.configClient, f.machineClient, f.aroClient, f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().Secrets(), f.k8sI.Core().V1().ConfigMaps(), f.infraInformer.Config().V1().Infrastructures(), f.controller.featureGatesHandler, f.mcfgClient, f.mcopClient)
+	require.NoError(t, err)
+
+	// Simulate the rotator re-issuing the serving cert from the new CA
+	_, err = f.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).Update(context.TODO(), getServingCertSecret(t, newCA), metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseOverlap, metav1.ConditionTrue)
+
+	// The old CA is kept until the overlap has elapsed
+	require.NoError(t, f.controller.syncMCSCARotation(now.Add(30*time.Minute)))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseOverlap, metav1.ConditionTrue)
+	assert.Equal(t, []string{"new-mcs-ca", "old-mcs-ca"}, f.getUserDataCANames(t, "worker-user-data"))
+
+	require.NoError(t, f.controller.syncMCSCARotation(now.Add(2*time.Hour)))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseCompleted, metav1.ConditionFalse)
+	assert.Equal(t, []string{"new-mcs-ca"}, f.getUserDataCANames(t, "worker-user-data"))
+
+	// Nothing more happens until a new rotation is requested
+	f.kubeClient.ClearActions()
+	require.NoError(t, f.controller.syncMCSCARotation(now.Add(3*time.Hour)))
+	for _, action := range f.kubeClient.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
+
+// This runs the library-go rotator the MCS certs are managed by, to check that it acts on what the
+// rotation asks of it
+func TestOnDemandMCSCARotationWithRotator(t *testing.T) {
+	_, placeholderCAPEM := getTestCA(t, "placeholder-mcs-ca")
+
+	f := newFixture(t)
+	userDataSecret := getUserDataSecretWithCA("worker-user-data", placeholderCAPEM)
+	f.objects = append(f.objects, userDataSecret)
+	f.maoSecretLister = append(f.maoSecretLister, userDataSecret)
+	f.mcopObjects = append(f.mcopObjects, &opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ctrlcommon.MCOOperatorKnobsObjectName,
+			Annotations: map[string]string{
+				MCSCARotationAnnotationKey:        "2025-01-01",
+				MCSCARotationOverlapAnnotationKey: "1h",
+			},
+		},
+	})
+	f.controller = f.newController()
+	f.controller.hostnamesRotation.setHostnames([]string{"api-int.example.com"})
+
+	// The rotator creates the initial CA, bundle and serving cert
+	f.syncMCSCertRotator(t)
+	caCert, err := f.controller.getMCSCACert()
+	require.NoError(t, err)
+	oldCA := getCertFingerprint(caCert)
+
+	now := time.Now()
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseGeneratingCA, metav1.ConditionTrue)
+
+	// The rotator generates a new CA, but keeps the serving cert issued by the old one
+	f.syncMCSCertRotator(t)
+	caCert, err = f.controller.getMCSCACert()
+	require.NoError(t, err)
+	newCA := getCertFingerprint(caCert)
+	require.NotEqual(t, oldCA, newCA)
+
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseReissuingServingCert, metav1.ConditionTrue)
+	reissued, err := f.controller.isMCSServingCertIssuedBy(newCA)
+	require.NoError(t, err)
+	assert.False(t, reissued)
+
+	// The rotator re-issues the serving cert that the rotation marked as expired
+	f.syncMCSCertRotator(t)
+	reissued, err = f.controller.isMCSServingCertIssuedBy(newCA)
+	require.NoError(t, err)
+	assert.True(t, reissued)
+
+	require.NoError(t, f.controller.syncMCSCARotation(now))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseOverlap, metav1.ConditionTrue)
+
+	require.NoError(t, f.controller.syncMCSCARotation(now.Add(2*time.Hour)))
+	f.verifyMCSCARotationPhase(t, mcsCARotationPhaseCompleted, metav1.ConditionFalse)
+	pending, err := f.controller.getUserDataSecretsWithoutCA(oldCA, false)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	pending, err = f.controller.getUserDataSecretsWithoutCA(newCA, true)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// The rotator does not add the old CA back to the bundle
+	f.syncMCSCertRotator(t)
+	bundle, err := f.controller.getMCSCABundle()
+	require.NoError(t, err)
+	assert.False(t, bundleHasFingerprint(bundle, oldCA))
+	assert.True(t, bundleHasFingerprint(bundle, newCA))
+}
+
+func TestGetMCSCARotationOverlap(t *testing.T) {
+	assert.Equal(t, defaultMCSCARotationOverlap, getMCSCARotationOverlap(""))
+	assert.Equal(t, 72*time.Hour, getMCSCARotationOverlap("72h"))
+	assert.Equal(t, time.Duration(0), getMCSCARotationOverlap("0s"))
+	assert.Equal(t, defaultMCSCARotationOverlap, getMCSCARotationOverlap("3 days"))
+}
+
+// verifyMCSCARotationPhase checks the recorded rotation phase and the MachineConfiguration condition
+func (f *fixture) verifyMCSCARotationPhase(t *testing.T, phase string, status metav1.ConditionStatus) {
+	t.Helper()
+	state, err := f.controller.getMCSCARotationState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, phase, state.phase)
+
+	mcop, err := f.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	require.NoError(t, err)
+	condition := meta.FindStatusCondition(mcop.Status.Conditions, MachineConfigurationMCSCARotation)
+	require.NotNil(t, condition)
+	assert.Equal(t, phase, condition.Reason)
+	assert.Equal(t, status, condition.Status)
+}
+
+// syncMCSCertRotator runs the library-go rotator with the MCS cert settings of the controller, against
+// listers holding the current contents of the fake client
+func (f *fixture) syncMCSCertRotator(t *testing.T) {
+	t.Helper()
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	secrets, err := f.kubeClient.CoreV1().Secrets(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	for i := range secrets.Items {
+		require.NoError(t, secretIndexer.Add(&secrets.Items[i]))
+	}
+	configMapIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	configMaps, err := f.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).List(context.TODO(), metav1.ListOptions{})
+	require.NoError(t, err)
+	for i := range configMaps.Items {
+		require.NoError(t, configMapIndexer.Add(&configMaps.Items[i]))
+	}
+
+	recorder := events.NewInMemoryRecorder("test", clock.RealClock{})
+	rotator := certrotation.CertRotationController{
+		Name: "MachineConfigServerCertRotator",
+		RotatedSigningCASecret: certrotation.RotatedSigningCASecret{
+			Namespace:     ctrlcommon.MCONamespace,
+			Name:          ctrlcommon.MachineConfigServerCAName,
+			Validity:      mcsCAExpiry,
+			Refresh:       mcsCARefresh,
+			Lister:        corev1listers.NewSecretLister(secretIndexer),
+			Client:        f.kubeClient.CoreV1(),
+			EventRecorder: recorder,
+		},
+		CABundleConfigMap: certrotation.CABundleConfigMap{
+			Namespace:     ctrlcommon.MCONamespace,
+			Name:          ctrlcommon.MachineConfigServerCAName,
+			Lister:        corev1listers.NewConfigMapLister(configMapIndexer),
+			Client:        f.kubeClient.CoreV1(),
+			EventRecorder: recorder,
+		},
+		RotatedSelfSignedCertKeySecret: certrotation.RotatedSelfSignedCertKeySecret{
+			Namespace: ctrlcommon.MCONamespace,
+			Name:      ctrlcommon.MachineConfigServerTLSSecretName,
+			Validity:  mcsTLSKeyExpiry,
+			Refresh:   mcsTLSKeyRefresh,
+			CertCreator: &certrotation.ServingRotation{
+				Hostnames: f.controller.hostnamesRotation.GetHostnames,
+			},
+			Lister:        corev1listers.NewSecretLister(secretIndexer),
+			Client:        f.kubeClient.CoreV1(),
+			EventRecorder: recorder,
+		},
+	}
+	require.NoError(t, rotator.SyncWorker(context.TODO()))
+}
+
+func (f *fixture) setMCSCABundle(t *testing.T, bundle []byte) {
+	t.Helper()
+	configMap, err := f.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.MachineConfigServerCAName, metav1.GetOptions{})
+	require.NoError(t, err)
+	configMap.Data["ca-bundle.crt"] = string(bundle)
+	_, err = f.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+// getUserDataCANames returns the sorted common names of the CAs trusted by a user-data secret
+func (f *fixture) getUserDataCANames(t *testing.T, name string) []string {
+	t.Helper()
+	secret, err := f.kubeClient.CoreV1().Secrets(ctrlcommon.MachineAPINamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	caData, err := getUserDataCA(secret)
+	require.NoError(t, err)
+	caCerts, err := cert.ParseCertsPEM(caData)
+	require.NoError(t, err)
+	names := sets.New[string]()
+	for _, caCert := range caCerts {
+		names.Insert(caCert.Subject.CommonName)
+	}
+	return sets.List(names)
+}
+
+func getTestCA(t *testing.T, name string) (*crypto.CA, []byte) {
+	t.Helper()
+	caConfig, err := crypto.MakeSelfSignedCAConfig(name, 24*time.Hour)
+	require.NoError(t, err)
+	certPEM, keyPEM, err := caConfig.GetPEMBytes()
+	require.NoError(t, err)
+	ca, err := crypto.GetCAFromBytes(certPEM, keyPEM)
+	require.NoError(t, err)
+	return ca, certPEM
+}
+
+func getServingCertSecret(t *testing.T, ca *crypto.CA) *corev1.Secret {
+	t.Helper()
+	certConfig, err := ca.MakeServerCert(sets.New("api-int.example.com"), time.Hour)
+	require.NoError(t, err)
+	certPEM, keyPEM, err := certConfig.GetPEMBytes()
+	require.NoError(t, err)
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MachineConfigServerTLSSecretName, Namespace: ctrlcommon.MCONamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}
+}
+
+func getUserDataSecretWithCA(name string, caPEM []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ctrlcommon.MachineAPINamespace,
+		},
+		Data: map[string][]byte{"userData": []byte(fmt.Sprintf(`{"ignition":{"config":{"merge":[{"source":"https://test-cluster-api:22623/config/worker"}]},"security":{"tls":{"certificateAuthorities":[{"source":%q}]}},"version":"3.2.0"}}`, dataurl.EncodeBytes(caPEM)))},
+	}
+}
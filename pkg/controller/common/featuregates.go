@@ -158,6 +158,13 @@ func IsBootImageControllerRequired(ctx *ControllerContext) bool {
 // - AWS: MachineSets opt-out, CPMS opt-in
 // - vSphere: MachineSets opt-out, CPMS not supported
 // - Azure: MachineSets opt-out, CPMS opt-in (except AzureStackCloud)
+// - OpenStack: MachineSets opt-in, CPMS not supported
+// - Nutanix: MachineSets opt-in, CPMS not supported
+//
+// IBM Cloud is not supported, since its boot images can only be imported from a
+// Cloud Object Storage bucket owned by the cluster. BareMetal is not supported
+// either, since MachineSets using the machine-os-images provisioning path
+// always boot the image from the release payload.
 //
 // Returns:
 // - supported: whether the platform supports boot image updates on machinesets
@@ -179,6 +186,16 @@ func CheckBootImagePlatform(infra *configv1.Infrastructure) (supported, cpmsSupp
 			return false, false, false
 		}
 		return true, true, true
+	case configv1.OpenStackPlatformType:
+		return true, false, false
+	case configv1.NutanixPlatformType:
+		return true, false, false
+	case configv1.IBMCloudPlatformType:
+		klog.V(4).Infof("Boot image updates are not supported on %s, since boot images must be imported from a Cloud Object Storage bucket owned by the cluster", configv1.IBMCloudPlatformType)
+		return false, false, false
+	case configv1.BareMetalPlatformType:
+		klog.V(4).Infof("Boot image updates are not supported on %s, since MachineSets boot the image from the release payload", configv1.BareMetalPlatformType)
+		return false, false, false
 	}
 	return false, false, false
 }
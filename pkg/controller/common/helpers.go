@@ -35,10 +35,12 @@ import (
 	"github.com/vincent-petithory/dataurl"
 	corev1 "k8s.io/api/core/v1"
 	kerr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
+	"k8s.io/client-go/util/retry"
 	k8sapiflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog/v2"
 
@@ -48,6 +50,7 @@ import (
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
 	"github.com/openshift/library-go/pkg/crypto"
 	buildconstants "github.com/openshift/machine-config-operator/pkg/controller/build/constants"
 )
@@ -1506,3 +1509,20 @@ func GetEffectiveOSImageStreamName(pool *mcfgv1.MachineConfigPool, mcpLister mcf
 	// Return worker pool's stream (which may be empty, indicating default)
 	return workerPool.Spec.OSImageStream.Name, nil
 }
+
+// SetMachineConfigurationCondition sets the condition on the status of the cluster MachineConfiguration,
+// skipping the update if the condition is unchanged
+func SetMachineConfigurationCondition(ctx context.Context, client mcopclientset.Interface, condition metav1.Condition) error {
+	// Using a retry here as other controllers update the MachineConfiguration status concurrently
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		mcop, err := client.OperatorV1().MachineConfigurations().Get(ctx, MCOOperatorKnobsObjectName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("cannot get MachineConfiguration %s: %w", MCOOperatorKnobsObjectName, err)
+		}
+		if !meta.SetStatusCondition(&mcop.Status.Conditions, condition) {
+			return nil
+		}
+		_, err = client.OperatorV1().MachineConfigurations().UpdateStatus(ctx, mcop, metav1.UpdateOptions{})
+		return err
+	})
+}
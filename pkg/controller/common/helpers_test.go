@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"testing"
@@ -16,12 +17,15 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	informers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	fakemcopclientset "github.com/openshift/client-go/operator/clientset/versioned/fake"
 	"github.com/openshift/machine-config-operator/pkg/controller/common/fixtures"
 	"github.com/openshift/machine-config-operator/test/helpers"
 )
@@ -1921,3 +1925,33 @@ func TestGetEffectiveOSImageStreamName(t *testing.T) {
 		})
 	}
 }
+
+func TestSetMachineConfigurationCondition(t *testing.T) {
+	mcopClient := fakemcopclientset.NewSimpleClientset(&opv1.MachineConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: MCOOperatorKnobsObjectName},
+	})
+	condition := metav1.Condition{
+		Type:    "TestCondition",
+		Status:  metav1.ConditionTrue,
+		Reason:  "Testing",
+		Message: "testing",
+	}
+
+	require.NoError(t, SetMachineConfigurationCondition(context.TODO(), mcopClient, condition))
+	mcop, err := mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.True(t, meta.IsStatusConditionTrue(mcop.Status.Conditions, condition.Type))
+
+	// An unchanged condition does not update the status again
+	mcopClient.ClearActions()
+	require.NoError(t, SetMachineConfigurationCondition(context.TODO(), mcopClient, condition))
+	for _, action := range mcopClient.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+
+	condition.Status = metav1.ConditionFalse
+	require.NoError(t, SetMachineConfigurationCondition(context.TODO(), mcopClient, condition))
+	mcop, err = mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, meta.IsStatusConditionFalse(mcop.Status.Conditions, condition.Type))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	buildconstants "github.com/openshift/machine-config-operator/pkg/controller/build/constants"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -144,6 +145,7 @@ func (l *LayeredNodeState) SetDesiredStateFromPool(mcp *mcfgv1.MachineConfigPool
 
 	metav1.SetMetaDataAnnotation(&node.ObjectMeta, daemonconsts.DesiredMachineConfigAnnotationKey, mcp.Spec.Configuration.Name)
 	delete(node.Annotations, daemonconsts.DesiredImageAnnotationKey)
+	delete(node.Annotations, daemonconsts.DesiredImageIsManifestListAnnotationKey)
 
 	l.node = node
 }
@@ -166,6 +168,14 @@ func (l *LayeredNodeState) SetDesiredStateFromMachineOSConfig(mosc *mcfgv1.Machi
 		delete(node.Annotations, daemonconsts.DesiredImageAnnotationKey)
 	}
 
+	// Tells the MCD to resolve the image for its architecture from the
+	// manifest list a multi-architecture build pushes.
+	if moscs.HasOSImage() && mosb.GetAnnotations()[buildconstants.BuildArchitecturesAnnotationKey] != "" {
+		metav1.SetMetaDataAnnotation(&node.ObjectMeta, daemonconsts.DesiredImageIsManifestListAnnotationKey, "true")
+	} else {
+		delete(node.Annotations, daemonconsts.DesiredImageIsManifestListAnnotationKey)
+	}
+
 	l.node = node
 }
 
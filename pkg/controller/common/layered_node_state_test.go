@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	buildconstants "github.com/openshift/machine-config-operator/pkg/controller/build/constants"
 	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/assert"
@@ -54,6 +55,14 @@ func newMachineOSBuild(currentConfig string) *mcfgv1.MachineOSBuild {
 	return helpers.NewMachineOSBuildBuilder("mosb-1").WithDesiredConfig(currentConfig).WithMachineOSConfig("mosc-1").MachineOSBuild()
 }
 
+func newMultiArchMachineOSBuild(currentConfig string) *mcfgv1.MachineOSBuild {
+	return helpers.NewMachineOSBuildBuilder("mosb-1").
+		WithDesiredConfig(currentConfig).
+		WithMachineOSConfig("mosc-1").
+		WithAnnotations(map[string]string{buildconstants.BuildArchitecturesAnnotationKey: "amd64,arm64"}).
+		MachineOSBuild()
+}
+
 func TestLayeredNodeState(t *testing.T) {
 	t.Parallel()
 
@@ -354,6 +363,7 @@ func TestLayeredNodeStateIsMutated(t *testing.T) {
 		mosb                  *mcfgv1.MachineOSBuild
 		expectedImage         string
 		expectedMachineConfig string
+		expectedManifestList  bool
 		layered               bool
 	}{
 		{
@@ -388,6 +398,25 @@ func TestLayeredNodeStateIsMutated(t *testing.T) {
 			expectedImage: imageV1,
 			layered:       true,
 		},
+		{
+			name:                 "layered node image changes to a multi-architecture build",
+			pool:                 newMachineConfigPool(machineConfigV0),
+			node:                 newLayeredNode(machineConfigV0, machineConfigV0, imageV0, imageV0),
+			mosc:                 newMachineOSConfig(imageV1),
+			mosb:                 newMultiArchMachineOSBuild(machineConfigV0),
+			expectedImage:        imageV1,
+			expectedManifestList: true,
+			layered:              true,
+		},
+		{
+			name: "multi-architecture layered node loses desired image because mosc was deleted",
+			pool: newMachineConfigPool(machineConfigV0),
+			node: helpers.NewNodeBuilder("").
+				WithConfigsAndImages(machineConfigV0, machineConfigV0, imageV0, imageV0).
+				WithAnnotations(map[string]string{daemonconsts.DesiredImageIsManifestListAnnotationKey: "true"}).
+				Node(),
+			layered: false,
+		},
 		{
 			name:                  "layered node image and MachineConfig changes",
 			pool:                  newMachineConfigPool(machineConfigV1),
@@ -419,6 +448,12 @@ func TestLayeredNodeStateIsMutated(t *testing.T) {
 				assert.Equal(t, test.expectedImage, updatedNode.Annotations[daemonconsts.DesiredImageAnnotationKey])
 			}
 
+			if test.expectedManifestList {
+				assert.Equal(t, "true", updatedNode.Annotations[daemonconsts.DesiredImageIsManifestListAnnotationKey])
+			} else {
+				assert.NotContains(t, updatedNode.Annotations, daemonconsts.DesiredImageIsManifestListAnnotationKey)
+			}
+
 			assert.Equal(t, test.pool.Spec.Configuration.Name, updatedNode.Annotations[daemonconsts.DesiredMachineConfigAnnotationKey])
 
 			// Ensure that the original node and updated node are not the same object
@@ -90,6 +90,35 @@ var (
 			Name: "mco_unavailable_machine_count",
 			Help: "total number of unavailable machines in specified pool",
 		}, []string{"pool"})
+
+	// MCCCertExpirySeconds is the time left before an MCO-managed certificate expires
+	MCCCertExpirySeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_cert_expiry_seconds",
+			Help: "seconds until the specified MCO-managed certificate expires",
+		}, []string{"name"})
+
+	// MCCCertExpiryThresholdSeconds is how long before expiry a certificate is reported as expiring
+	// warning, critical
+	MCCCertExpiryThresholdSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mco_cert_expiry_threshold_seconds",
+			Help: "seconds before expiry at which MCO-managed certificates are reported at the specified severity",
+		}, []string{"severity"})
+
+	// MCCRegistryMirrorReachable reports whether a registry mirror of the release payload answers
+	MCCRegistryMirrorReachable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_registry_mirror_reachable",
+			Help: "1 if the registry mirror of the source answers for the release payload, 0 otherwise",
+		}, []string{"source", "mirror"})
+
+	// MCCRegistryMirrorCoverageRatio is the share of the release payload images a registry mirror serves
+	MCCRegistryMirrorCoverageRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_registry_mirror_coverage_ratio",
+			Help: "ratio of the release payload images of the source served by the registry mirror",
+		}, []string{"source", "mirror"})
 )
 
 func RegisterMCCMetrics() error {
@@ -104,6 +133,10 @@ func RegisterMCCMetrics() error {
 		MCCDegradedMachineCount,
 		MCCUnavailableMachineCount,
 		MCCBootImageSkewEnforcementNone,
+		MCCCertExpirySeconds,
+		MCCCertExpiryThresholdSeconds,
+		MCCRegistryMirrorReachable,
+		MCCRegistryMirrorCoverageRatio,
 	})
 
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/clarketm/json"
 	signature "github.com/containers/image/v5/signature"
+	imagetypes "github.com/containers/image/v5/types"
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
 	apicfgv1 "github.com/openshift/api/config/v1"
 	apicfgv1alpha1 "github.com/openshift/api/config/v1alpha1"
@@ -50,10 +51,12 @@ import (
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
+	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
 	apihelpers "github.com/openshift/machine-config-operator/pkg/apihelpers"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
 	mtmpl "github.com/openshift/machine-config-operator/pkg/controller/template"
 	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
 	"github.com/openshift/machine-config-operator/pkg/version"
 )
 
@@ -89,6 +92,7 @@ type Controller struct {
 	client        mcfgclientset.Interface
 	kubeClient    clientset.Interface
 	configClient  configclientset.Interface
+	mcopClient    mcopclientset.Interface
 	eventRecorder record.EventRecorder
 
 	syncHandler                   func(mcp string) error
@@ -134,6 +138,16 @@ type Controller struct {
 
 	fgHandler ctrlcommon.FeatureGatesHandler
 
+	imagesInspectorFactory osimagestream.ImagesInspectorFactory
+	// release image the payload images probed on registry mirrors were read from
+	mirrorHealthReleaseImage  string
+	mirrorHealthPayloadImages []string
+
+	// verifyImageSignature checks an image against a policy, verifiedSignatures caches the
+	// successful pre-rollout verifications of image policies
+	verifyImageSignature func(ctx context.Context, sysCtx *imagetypes.SystemContext, policy *signature.Policy, image string) error
+	verifiedSignatures   map[verifiedSignatureKey]struct{}
+
 	queue       workqueue.TypedRateLimitingInterface[string]
 	imgQueue    workqueue.TypedRateLimitingInterface[string]
 	criocpQueue workqueue.TypedRateLimitingInterface[string]
@@ -154,6 +168,7 @@ func New(
 	kubeClient clientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 	configClient configclientset.Interface,
+	mcopClient mcopclientset.Interface,
 	fgHandler ctrlcommon.FeatureGatesHandler,
 ) *Controller {
 	eventBroadcaster := record.NewBroadcaster()
@@ -165,12 +180,15 @@ func New(
 		client:        mcfgClient,
 		kubeClient:    kubeClient,
 		configClient:  configClient,
+		mcopClient:    mcopClient,
 		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-containerruntimeconfigcontroller"})),
 		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
 			workqueue.DefaultTypedControllerRateLimiter[string](),
 			workqueue.TypedRateLimitingQueueConfig[string]{Name: "machineconfigcontroller-containerruntimeconfigcontroller"}),
-		imgQueue:    workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
-		criocpQueue: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		imgQueue:               workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		criocpQueue:            workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		imagesInspectorFactory: &osimagestream.DefaultImagesInspectorFactory{},
+		verifyImageSignature:   verifyImageSignature,
 	}
 
 	mcrInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -288,6 +306,9 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	// Just need one worker for the CRIOCredentialProviderConfig
 	go wait.Until(ctrl.criocpWorker, time.Second, stopCh)
 
+	// Periodically probe the registry mirrors of the release payload
+	go wait.Until(ctrl.runMirrorHealth, mirrorHealthResyncPeriod, stopCh)
+
 	<-stopCh
 }
 
@@ -1110,6 +1131,20 @@ func (ctrl *Controller) syncImageConfig(key string) error {
 	if err != nil {
 		return err
 	}
+
+	// Verify the signatures requested by image policies against the rendered config before rolling it out
+	if len(mcpPools) > 0 && (len(clusterImagePolicies) > 0 || len(imagePolicies) > 0) {
+		registriesIgn, err := registriesConfigIgnition(ctrl.templatesDir, controllerConfig, mcpPools[0].Name, releaseImage,
+			imgcfg.Spec.RegistrySources.InsecureRegistries, registriesBlocked, policyBlocked, allowedRegs,
+			imgcfg.Spec.RegistrySources.ContainerRuntimeSearchRegistries, icspRules, idmsRules, itmsRules, clusterScopePolicies, scopeNamespacePolicies)
+		if err != nil {
+			return err
+		}
+		if err := ctrl.verifyImagePolicySignatures(context.TODO(), controllerConfig, releaseImage, clusterImagePolicies, imagePolicies, registriesIgn); err != nil {
+			return fmt.Errorf("image policy signature verification failed, not rolling out the registries config: %w", err)
+		}
+	}
+
 	for _, pool := range mcpPools {
 		// To keep track of whether we "actually" got an updated image config
 		applied := true
@@ -1427,7 +1462,7 @@ func (ctrl *Controller) syncImagePolicyStatusOnly(namespace, imagepolicy, condit
 		if newImagePolicy.GetGeneration() != newCondition.ObservedGeneration {
 			newCondition.ObservedGeneration = newImagePolicy.GetGeneration()
 		}
-		newImagePolicy.Status.Conditions = []metav1.Condition{*newCondition}
+		meta.SetStatusCondition(&newImagePolicy.Status.Conditions, *newCondition)
 		_, updateErr := ctrl.configClient.ConfigV1().ImagePolicies(namespace).UpdateStatus(context.TODO(), newImagePolicy, metav1.UpdateOptions{})
 		return updateErr
 	})
@@ -277,7 +277,7 @@ func (f *fixture) newController() *Controller {
 		ci,
 		oi.Operator().V1alpha1().ImageContentSourcePolicies(),
 		ci.Config().V1().ClusterVersions(),
-		k8sfake.NewSimpleClientset(), f.client, f.imgClient,
+		k8sfake.NewSimpleClientset(), f.client, f.imgClient, f.operatorClient,
 		f.fgHandler,
 	)
 
@@ -0,0 +1,393 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
+)
+
+const (
+	// How often the mirrors of the release payload are probed
+	mirrorHealthResyncPeriod = 10 * time.Minute
+
+	// How long a mirror may take to serve the release image before it is reported as unreachable.
+	// Image inspection retries network errors, so an unreachable mirror only fails once this expires.
+	mirrorReachabilityTimeout = 30 * time.Second
+
+	// How long a reachable mirror may take to serve every image of the release payload
+	mirrorCoverageTimeout = 5 * time.Minute
+
+	// MachineConfigurationRegistryMirrorsDegraded is the MachineConfiguration status condition reporting
+	// registry mirrors of the release payload that are unreachable or missing payload images
+	MachineConfigurationRegistryMirrorsDegraded = "RegistryMirrorsDegraded"
+
+	mirrorHealthReasonAsExpected     = "AsExpected"
+	mirrorHealthReasonUnreachable    = "MirrorUnreachable"
+	mirrorHealthReasonContentMissing = "MirrorContentMissing"
+)
+
+// payloadMirror is a mirror configured for a repository of the release payload
+type payloadMirror struct {
+	source string
+	mirror string
+	// neverContactSource is set when pulls do not fall back to the source if every mirror fails
+	neverContactSource bool
+}
+
+// mirrorHealth is the result of probing a payload mirror
+type mirrorHealth struct {
+	payloadMirror
+	reachable bool
+	// err is the error that made the mirror unreachable
+	err error
+	// covered is the number of payload images the mirror serves out of total
+	covered int
+	total   int
+}
+
+func (m *mirrorHealth) healthy() bool {
+	return m.reachable && m.covered == m.total
+}
+
+// runMirrorHealth probes the mirrors of the release payload and refreshes the metrics and condition
+func (ctrl *Controller) runMirrorHealth() {
+	if err := ctrl.syncMirrorHealth(context.TODO()); err != nil {
+		klog.Errorf("Error syncing registry mirror health: %v", err)
+	}
+}
+
+func (ctrl *Controller) syncMirrorHealth(ctx context.Context) error {
+	cv, err := ctrl.clusterVersionLister.Get("version")
+	if err != nil {
+		return fmt.Errorf("could not get ClusterVersion: %w", err)
+	}
+	releaseImage := cv.Status.Desired.Image
+	if releaseImage == "" {
+		return fmt.Errorf("ClusterVersion does not report a desired release image")
+	}
+
+	results, err := ctrl.probePayloadMirrors(ctx, releaseImage)
+	if err != nil {
+		return err
+	}
+
+	ctrlcommon.MCCRegistryMirrorReachable.Reset()
+	ctrlcommon.MCCRegistryMirrorCoverageRatio.Reset()
+	for _, result := range results {
+		reachable := 0.0
+		if result.reachable {
+			reachable = 1
+		}
+		coverage := 0.0
+		if result.total > 0 {
+			coverage = float64(result.covered) / float64(result.total)
+		}
+		ctrlcommon.MCCRegistryMirrorReachable.WithLabelValues(result.source, result.mirror).Set(reachable)
+		ctrlcommon.MCCRegistryMirrorCoverageRatio.WithLabelValues(result.source, result.mirror).Set(coverage)
+	}
+
+	return ctrlcommon.SetMachineConfigurationCondition(ctx, ctrl.mcopClient, getMirrorHealthCondition(results))
+}
+
+// getPayloadMirrors returns the digest mirrors configured for the repositories of the images.
+// Tag mirrors are not used for the release payload, which is always pulled by digest.
+func (ctrl *Controller) getPayloadMirrors(images []string) ([]payloadMirror, error) {
+	idmsRules, err := ctrl.idmsLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list ImageDigestMirrorSets: %w", err)
+	}
+	icspRules, err := ctrl.icspLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("could not list ImageContentSourcePolicies: %w", err)
+	}
+
+	seen := map[payloadMirror]struct{}{}
+	mirrors := []payloadMirror{}
+	add := func(source, mirror string, neverContactSource bool) {
+		m := payloadMirror{source: source, mirror: mirror, neverContactSource: neverContactSource}
+		if _, ok := seen[m]; ok || !isSourceOfAny(source, images) {
+			return
+		}
+		seen[m] = struct{}{}
+		mirrors = append(mirrors, m)
+	}
+	for _, idms := range idmsRules {
+		for _, rule := range idms.Spec.ImageDigestMirrors {
+			for _, mirror := range rule.Mirrors {
+				add(rule.Source, string(mirror), rule.MirrorSourcePolicy == apicfgv1.NeverContactSource)
+			}
+		}
+	}
+	for _, icsp := range icspRules {
+		for _, rule := range icsp.Spec.RepositoryDigestMirrors {
+			for _, mirror := range rule.Mirrors {
+				add(rule.Source, mirror, false)
+			}
+		}
+	}
+	return mirrors, nil
+}
+
+// probePayloadMirrors checks that each mirror of the release payload is reachable and serves the
+// payload images. The mirrors of the release image are probed first to read the release metadata
+// listing the component images, whose repositories may have mirrors of their own.
+func (ctrl *Controller) probePayloadMirrors(ctx context.Context, releaseImage string) ([]mirrorHealth, error) {
+	releaseMirrors, err := ctrl.getPayloadMirrors([]string{releaseImage})
+	if err != nil {
+		return nil, err
+	}
+	if len(releaseMirrors) == 0 {
+		// the release image is pulled from its source, so are the component images
+		return nil, nil
+	}
+
+	sysCtx, err := ctrl.buildMirrorSysContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sysCtx.Cleanup(); err != nil {
+			klog.Warningf("Unable to clean resources after registry mirror inspection: %s", err)
+		}
+	}()
+	inspector := ctrl.imagesInspectorFactory.ForContext(sysCtx.SysContext)
+
+	// reachability is probed with the first payload image of the mirror source
+	probed := map[payloadMirror]*mirrorHealth{}
+	probe := func(mirrors []payloadMirror, images []string) {
+		for _, mirror := range mirrors {
+			if _, ok := probed[mirror]; ok {
+				continue
+			}
+			result := &mirrorHealth{payloadMirror: mirror}
+			for _, image := range images {
+				if !isSourceImage(image, mirror.source) {
+					continue
+				}
+				probeCtx, cancel := context.WithTimeout(ctx, mirrorReachabilityTimeout)
+				result.reachable, result.err = probeMirrorRepository(probeCtx, inspector, mirrorImage(image, mirror))
+				cancel()
+				break
+			}
+			probed[mirror] = result
+		}
+	}
+	probe(releaseMirrors, []string{releaseImage})
+
+	payloadImages := ctrl.getPayloadImages(ctx, inspector, releaseImage, releaseMirrors, probed)
+	mirrors, err := ctrl.getPayloadMirrors(payloadImages)
+	if err != nil {
+		return nil, err
+	}
+	probe(mirrors, payloadImages)
+
+	results := make([]mirrorHealth, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		result := probed[mirror]
+		images := []string{}
+		for _, image := range payloadImages {
+			if isSourceImage(image, result.source) {
+				images = append(images, mirrorImage(image, result.payloadMirror))
+			}
+		}
+		result.total = len(images)
+		if result.reachable {
+			result.covered = countMirroredImages(ctx, inspector, images)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// buildMirrorSysContext builds the SysContext used to inspect mirrors with the cluster pull secret
+// and registry certificates
+func (ctrl *Controller) buildMirrorSysContext(ctx context.Context) (*imageutils.SysContext, error) {
+	cc, err := ctrl.ccLister.Get(ctrlcommon.ControllerConfigName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get ControllerConfig: %w", err)
+	}
+	builder, err := imageutils.NewSysContextBuilder().WithControllerConfigAndPullSecret(ctx, ctrl.kubeClient, cc)
+	if err != nil {
+		return nil, err
+	}
+	sysCtx, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare for registry mirror inspection: %w", err)
+	}
+	return sysCtx, nil
+}
+
+// getPayloadImages returns the release image and the component images listed in its release metadata,
+// read from the first reachable mirror of the release image. Release images are referenced by digest
+// so the metadata is only read once per release.
+func (ctrl *Controller) getPayloadImages(ctx context.Context, inspector osimagestream.ImagesInspector, releaseImage string, releaseMirrors []payloadMirror, probed map[payloadMirror]*mirrorHealth) []string {
+	if ctrl.mirrorHealthReleaseImage == releaseImage {
+		return ctrl.mirrorHealthPayloadImages
+	}
+
+	for _, mirror := range releaseMirrors {
+		if !probed[mirror].reachable {
+			continue
+		}
+		image := mirrorImage(releaseImage, mirror)
+		probeCtx, cancel := context.WithTimeout(ctx, mirrorReachabilityTimeout)
+		imageStream, err := osimagestream.NewImageStreamProviderNetwork(inspector, image).ReadImageStream(probeCtx)
+		cancel()
+		if err != nil {
+			klog.V(4).Infof("Could not read release metadata from %s: %v", image, err)
+			continue
+		}
+		images := []string{releaseImage}
+		for _, tag := range imageStream.Spec.Tags {
+			if tag.From != nil && tag.From.Kind == "DockerImage" && strings.Contains(tag.From.Name, "@") {
+				images = append(images, tag.From.Name)
+			}
+		}
+		ctrl.mirrorHealthReleaseImage = releaseImage
+		ctrl.mirrorHealthPayloadImages = images
+		return images
+	}
+
+	// coverage is limited to the release image until the release metadata can be read
+	klog.Warningf("Could not read the release metadata of %s from any registry mirror, only checking mirrors for the release image", releaseImage)
+	return []string{releaseImage}
+}
+
+// probeMirrorRepository returns true if the registry answers for the image. A registry reporting the
+// image as missing is reachable.
+func probeMirrorRepository(ctx context.Context, inspector osimagestream.ImagesInspector, image string) (bool, error) {
+	results, err := inspector.Inspect(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	for _, result := range results {
+		if result.Error != nil && !isImageNotFoundError(result.Error) {
+			return false, result.Error
+		}
+	}
+	return true, nil
+}
+
+// countMirroredImages returns how many of the images the mirror serves
+func countMirroredImages(ctx context.Context, inspector osimagestream.ImagesInspector, images []string) int {
+	if len(images) == 0 {
+		return 0
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, mirrorCoverageTimeout)
+	defer cancel()
+	results, err := inspector.Inspect(probeCtx, images...)
+	if err != nil {
+		klog.Warningf("Could not inspect mirrored release payload images: %v", err)
+		return 0
+	}
+	covered := 0
+	for _, result := range results {
+		if result.Error == nil {
+			covered++
+		} else {
+			klog.V(4).Infof("Mirrored release payload image %s is not available: %v", result.Image, result.Error)
+		}
+	}
+	return covered
+}
+
+// isSourceImage returns true if the image repository is the source or nested under it
+func isSourceImage(image, source string) bool {
+	return image == source || strings.HasPrefix(image, source+"/") || strings.HasPrefix(image, source+"@")
+}
+
+// mirrorImage returns the reference of the image in the mirror
+func mirrorImage(image string, mirror payloadMirror) string {
+	return mirror.mirror + strings.TrimPrefix(image, mirror.source)
+}
+
+// isSourceOfAny returns true if the mirror source covers the repository of any of the images
+func isSourceOfAny(source string, images []string) bool {
+	for _, image := range images {
+		if isSourceImage(image, source) {
+			return true
+		}
+	}
+	return false
+}
+
+// isImageNotFoundError returns true if the registry reported the image or its repository as unknown
+func isImageNotFoundError(err error) bool {
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) {
+		code := ec.ErrorCode()
+		return code == v2.ErrorCodeManifestUnknown || code == v2.ErrorCodeNameUnknown
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "manifest unknown")
+}
+
+// getMirrorHealthCondition reports the mirrors that are unreachable or missing payload images and
+// where pulls go in their place
+func getMirrorHealthCondition(results []mirrorHealth) metav1.Condition {
+	condition := metav1.Condition{
+		Type:   MachineConfigurationRegistryMirrorsDegraded,
+		Status: metav1.ConditionFalse,
+		Reason: mirrorHealthReasonAsExpected,
+	}
+	if len(results) == 0 {
+		condition.Message = "No registry mirrors are configured for the release payload"
+		return condition
+	}
+
+	healthyMirrors := map[string][]string{}
+	for _, result := range results {
+		if result.healthy() {
+			healthyMirrors[result.source] = append(healthyMirrors[result.source], result.mirror)
+		}
+	}
+
+	problems := []string{}
+	for _, result := range results {
+		if result.healthy() {
+			continue
+		}
+		condition.Status = metav1.ConditionTrue
+		var problem string
+		if !result.reachable {
+			condition.Reason = mirrorHealthReasonUnreachable
+			problem = fmt.Sprintf("mirror %s of %s is unreachable: %v", result.mirror, result.source, result.err)
+		} else {
+			if condition.Reason != mirrorHealthReasonUnreachable {
+				condition.Reason = mirrorHealthReasonContentMissing
+			}
+			problem = fmt.Sprintf("mirror %s of %s serves %d of %d release payload images", result.mirror, result.source, result.covered, result.total)
+		}
+
+		switch {
+		case len(healthyMirrors[result.source]) > 0:
+			problem += fmt.Sprintf(", pulls fail over to %s", healthyMirrors[result.source][0])
+		case result.neverContactSource:
+			problem += ", pulls fail as the source is never contacted"
+		default:
+			problem += ", pulls fall back to the source"
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		condition.Message = strings.Join(problems, "; ")
+		return condition
+	}
+
+	condition.Message = fmt.Sprintf("All %d registry mirrors of the release payload serve their release payload images", len(results))
+	return condition
+}
@@ -0,0 +1,190 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
+)
+
+const (
+	mirrorHealthReleaseImage = "quay.io/openshift-release-dev/ocp-release@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	mirrorHealthCLIImage     = "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	mirrorHealthCoreOSImage  = "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+
+	mirrorHealthImageReferences = `{
+  "kind": "ImageStream",
+  "apiVersion": "image.openshift.io/v1",
+  "metadata": {"name": "4.21.0"},
+  "spec": {
+    "tags": [
+      {"name": "cli", "from": {"kind": "DockerImage", "name": "` + mirrorHealthCLIImage + `"}},
+      {"name": "rhel-coreos", "from": {"kind": "DockerImage", "name": "` + mirrorHealthCoreOSImage + `"}}
+    ]
+  }
+}`
+)
+
+// fakeMirrorInspector serves the images of the reachable registries
+type fakeMirrorInspector struct {
+	images      map[string]bool
+	unreachable map[string]bool
+}
+
+func (f *fakeMirrorInspector) Inspect(_ context.Context, images ...string) ([]imageutils.BulkInspectResult, error) {
+	results := []imageutils.BulkInspectResult{}
+	for _, image := range images {
+		result := imageutils.BulkInspectResult{Image: image}
+		registry, _, _ := strings.Cut(image, "/")
+		switch {
+		case f.unreachable[registry]:
+			result.Error = fmt.Errorf("dial tcp: lookup %s: no such host", registry)
+		case !f.images[image]:
+			result.Error = v2.ErrorCodeManifestUnknown
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (f *fakeMirrorInspector) FetchImageFile(_ context.Context, image, _ string) ([]byte, error) {
+	if !f.images[image] {
+		return nil, fmt.Errorf("image %s not found", image)
+	}
+	return []byte(mirrorHealthImageReferences), nil
+}
+
+type fakeMirrorInspectorFactory struct {
+	inspector *fakeMirrorInspector
+}
+
+func (f *fakeMirrorInspectorFactory) ForContext(_ *types.SystemContext) osimagestream.ImagesInspector {
+	return f.inspector
+}
+
+func TestSyncMirrorHealth(t *testing.T) {
+	releaseMirrors := apicfgv1.ImageDigestMirrors{
+		Source:  "quay.io/openshift-release-dev/ocp-release",
+		Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ocp/release"},
+	}
+	componentMirrors := apicfgv1.ImageDigestMirrors{
+		Source:  "quay.io/openshift-release-dev/ocp-v4.0-art-dev",
+		Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ocp/art"},
+	}
+	allImages := map[string]bool{
+		"mirror.example.com/ocp/release@sha256:0000000000000000000000000000000000000000000000000000000000000000": true,
+		"mirror.example.com/ocp/art@sha256:1111111111111111111111111111111111111111111111111111111111111111":     true,
+		"mirror.example.com/ocp/art@sha256:2222222222222222222222222222222222222222222222222222222222222222":     true,
+		"backup.example.com/ocp/release@sha256:0000000000000000000000000000000000000000000000000000000000000000": true,
+	}
+
+	tests := []struct {
+		name          string
+		idms          []apicfgv1.ImageDigestMirrors
+		images        map[string]bool
+		unreachable   map[string]bool
+		wantStatus    metav1.ConditionStatus
+		wantReason    string
+		wantMessage   []string
+		wantReachable map[string]float64
+	}{
+		{
+			name:        "no mirrors",
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  mirrorHealthReasonAsExpected,
+			wantMessage: []string{"No registry mirrors are configured"},
+		},
+		{
+			name:        "all mirrors serve the payload",
+			idms:        []apicfgv1.ImageDigestMirrors{releaseMirrors, componentMirrors},
+			images:      allImages,
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  mirrorHealthReasonAsExpected,
+			wantMessage: []string{"All 2 registry mirrors"},
+		},
+		{
+			name: "component image missing",
+			idms: []apicfgv1.ImageDigestMirrors{releaseMirrors, componentMirrors},
+			images: map[string]bool{
+				"mirror.example.com/ocp/release@sha256:0000000000000000000000000000000000000000000000000000000000000000": true,
+				"mirror.example.com/ocp/art@sha256:1111111111111111111111111111111111111111111111111111111111111111":     true,
+			},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  mirrorHealthReasonContentMissing,
+			wantMessage: []string{"mirror mirror.example.com/ocp/art of quay.io/openshift-release-dev/ocp-v4.0-art-dev serves 1 of 2", "pulls fall back to the source"},
+		},
+		{
+			name: "unreachable mirror fails over",
+			idms: []apicfgv1.ImageDigestMirrors{{
+				Source:  "quay.io/openshift-release-dev/ocp-release",
+				Mirrors: []apicfgv1.ImageMirror{"mirror.example.com/ocp/release", "backup.example.com/ocp/release"},
+			}, componentMirrors},
+			images:      allImages,
+			unreachable: map[string]bool{"mirror.example.com": true},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  mirrorHealthReasonUnreachable,
+			wantMessage: []string{"mirror mirror.example.com/ocp/release of quay.io/openshift-release-dev/ocp-release is unreachable", "pulls fail over to backup.example.com/ocp/release"},
+		},
+		{
+			name: "unreachable mirror never contacting the source",
+			idms: []apicfgv1.ImageDigestMirrors{{
+				Source:             "quay.io/openshift-release-dev",
+				Mirrors:            []apicfgv1.ImageMirror{"mirror.example.com/ocp"},
+				MirrorSourcePolicy: apicfgv1.NeverContactSource,
+			}},
+			unreachable: map[string]bool{"mirror.example.com": true},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  mirrorHealthReasonUnreachable,
+			wantMessage: []string{"pulls fail as the source is never contacted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			cc := newControllerConfig(ctrlcommon.ControllerConfigName, apicfgv1.AWSPlatformType)
+			f.ccLister = append(f.ccLister, cc)
+			f.objects = append(f.objects, cc)
+			f.cvLister = append(f.cvLister, newClusterVersionConfig("version", mirrorHealthReleaseImage))
+			if tt.idms != nil {
+				f.idmsLister = append(f.idmsLister, newIDMS("release-mirrors", tt.idms))
+			}
+			f.operatorObjects = []runtime.Object{&opv1.MachineConfiguration{ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName}}}
+
+			c := f.newController()
+			c.imagesInspectorFactory = &fakeMirrorInspectorFactory{inspector: &fakeMirrorInspector{images: tt.images, unreachable: tt.unreachable}}
+
+			require.NoError(t, c.syncMirrorHealth(context.TODO()))
+
+			mcop, err := f.operatorClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+			require.NoError(t, err)
+			condition := meta.FindStatusCondition(mcop.Status.Conditions, MachineConfigurationRegistryMirrorsDegraded)
+			require.NotNil(t, condition)
+			assert.Equal(t, tt.wantStatus, condition.Status)
+			assert.Equal(t, tt.wantReason, condition.Reason)
+			for _, message := range tt.wantMessage {
+				assert.Contains(t, condition.Message, message)
+			}
+
+			// an unchanged condition is not written again
+			f.operatorClient.ClearActions()
+			require.NoError(t, c.syncMirrorHealth(context.TODO()))
+			for _, action := range f.operatorClient.Actions() {
+				assert.NotEqual(t, "update", action.GetVerb())
+			}
+		})
+	}
+}
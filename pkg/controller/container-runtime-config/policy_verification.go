@@ -0,0 +1,327 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	commonretry "github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/pkg/sysregistriesv2"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	runtimeutils "github.com/openshift/runtime-utils/pkg/registries"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+)
+
+const (
+	// VerifySignaturesAnnotationKey is set to "true" on a ClusterImagePolicy or ImagePolicy to verify the
+	// signature of the release payload against the rendered policy before it is rolled out to the nodes.
+	VerifySignaturesAnnotationKey = "machineconfiguration.openshift.io/verify-signatures"
+
+	// SignatureVerificationImageAnnotationKey is set on a ClusterImagePolicy or ImagePolicy to verify the
+	// signature of the listed image, within the policy scopes, instead of the release payload. Setting it
+	// enables the verification.
+	SignatureVerificationImageAnnotationKey = "machineconfiguration.openshift.io/signature-verification-image"
+
+	// ImagePolicySignatureVerified is the ClusterImagePolicy and ImagePolicy status condition reporting
+	// whether a signature verifies against the rendered policy
+	ImagePolicySignatureVerified = "SignatureVerified"
+
+	reasonSignatureVerified   = "Verified"
+	reasonSignatureRejected   = "VerificationFailed"
+	reasonNoVerificationImage = "NoVerificationImage"
+
+	// How long fetching and verifying the signature of an image may take
+	signatureVerificationTimeout = 2 * time.Minute
+)
+
+// signatureVerification is a policy opting into the verification of an image before rollout
+type signatureVerification struct {
+	// namespace is empty for a ClusterImagePolicy
+	namespace  string
+	name       string
+	uid        string
+	generation int64
+	// image is empty if no image within the policy scopes can be verified
+	image string
+	// policyPath is the path of the rendered policy applying to the image
+	policyPath string
+	conditions []metav1.Condition
+}
+
+func (v *signatureVerification) String() string {
+	if v.namespace == "" {
+		return fmt.Sprintf("ClusterImagePolicy %s", v.name)
+	}
+	return fmt.Sprintf("ImagePolicy %s/%s", v.namespace, v.name)
+}
+
+// verifiedSignatureKey identifies a successful verification so it is not repeated until the policy, the
+// verified image or the rendered config change
+type verifiedSignatureKey struct {
+	uid        string
+	generation int64
+	image      string
+	configHash string
+}
+
+// verifyImagePolicySignatures verifies the images of the policies opting into pre-rollout verification
+// against the rendered registries Ignition config and reports the result on the policy status. It
+// returns an error if any verification fails so that the rendered config is not rolled out.
+func (ctrl *Controller) verifyImagePolicySignatures(ctx context.Context, controllerConfig *mcfgv1.ControllerConfig, releaseImage string,
+	clusterImagePolicies []*apicfgv1.ClusterImagePolicy, imagePolicies []*apicfgv1.ImagePolicy, registriesIgn *ign3types.Config) error {
+	verifications := []signatureVerification{}
+	for _, policy := range clusterImagePolicies {
+		image, ok := signatureVerificationImage(policy.Annotations, policy.Spec.Scopes, releaseImage)
+		if !ok {
+			ctrl.setImagePolicySignatureCondition("", policy.Name, policy.Status.Conditions, nil)
+			continue
+		}
+		verifications = append(verifications, signatureVerification{
+			name: policy.Name, uid: string(policy.UID), generation: policy.Generation, image: image, policyPath: policyConfigPath,
+			conditions: policy.Status.Conditions,
+		})
+	}
+	for _, policy := range imagePolicies {
+		image, ok := signatureVerificationImage(policy.Annotations, policy.Spec.Scopes, releaseImage)
+		if !ok {
+			ctrl.setImagePolicySignatureCondition(policy.Namespace, policy.Name, policy.Status.Conditions, nil)
+			continue
+		}
+		verifications = append(verifications, signatureVerification{
+			namespace: policy.Namespace, name: policy.Name, uid: string(policy.UID), generation: policy.Generation, image: image,
+			policyPath: fmt.Sprintf(namespacedPolicyFilePathFormat, policy.Namespace), conditions: policy.Status.Conditions,
+		})
+	}
+	if len(verifications) == 0 {
+		ctrl.verifiedSignatures = nil
+		return nil
+	}
+
+	files := map[string][]byte{}
+	for _, file := range registriesIgn.Storage.Files {
+		if file.Contents.Source == nil {
+			continue
+		}
+		data, err := ctrlcommon.DecodeIgnitionFileContents(file.Contents.Source, file.Contents.Compression)
+		if err != nil {
+			return fmt.Errorf("could not decode rendered %s: %w", file.Path, err)
+		}
+		files[file.Path] = data
+	}
+
+	verified := map[verifiedSignatureKey]struct{}{}
+	errs := []error{}
+	for _, verification := range verifications {
+		condition := metav1.Condition{
+			Type:               ImagePolicySignatureVerified,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonSignatureVerified,
+			Message:            fmt.Sprintf("The signature of %s verifies against the rendered policy", verification.image),
+			ObservedGeneration: verification.generation,
+		}
+
+		// an ImagePolicy whose scopes all conflict with ClusterImagePolicies has no policy of its own
+		policyJSON, ok := files[verification.policyPath]
+		if !ok {
+			policyJSON = files[policyConfigPath]
+		}
+		configHash := hashRenderedConfig(policyJSON, files[registriesConfigPath], files[sigstoreRegistriesConfigFilePath])
+		key := verifiedSignatureKey{uid: verification.uid, generation: verification.generation, image: verification.image, configHash: configHash}
+
+		var err error
+		if _, cached := ctrl.verifiedSignatures[key]; verification.image == "" {
+			err = fmt.Errorf("no image within the policy scopes to verify, set the %s annotation to a signed image within them", SignatureVerificationImageAnnotationKey)
+			condition.Reason = reasonNoVerificationImage
+		} else if !cached {
+			err = ctrl.verifyRenderedPolicy(ctx, controllerConfig, policyJSON, files[registriesConfigPath], files[sigstoreRegistriesConfigFilePath], verification.image)
+			condition.Reason = reasonSignatureRejected
+		}
+		if err != nil {
+			condition.Status = metav1.ConditionFalse
+			condition.Message = fmt.Sprintf("Not rolling out the policy: %v", err)
+			errs = append(errs, fmt.Errorf("%s: %w", verification.String(), err))
+		} else {
+			condition.Reason = reasonSignatureVerified
+			verified[key] = struct{}{}
+		}
+		klog.V(2).Infof("Signature verification of %s: %s", verification.String(), condition.Message)
+		ctrl.setImagePolicySignatureCondition(verification.namespace, verification.name, verification.conditions, &condition)
+	}
+	ctrl.verifiedSignatures = verified
+
+	return kubeErrs.NewAggregate(errs)
+}
+
+// signatureVerificationImage returns the image to verify the policy with: the image listed on the policy
+// or else the release image. The image is empty if it is not within the policy scopes. It returns false
+// if the policy does not opt into verification.
+func signatureVerificationImage(annotations map[string]string, scopes []apicfgv1.ImageScope, releaseImage string) (string, bool) {
+	image, ok := annotations[SignatureVerificationImageAnnotationKey]
+	if !ok {
+		if annotations[VerifySignaturesAnnotationKey] != "true" {
+			return "", false
+		}
+		image = releaseImage
+	}
+
+	repository, _, _ := strings.Cut(image, "@")
+	for _, scope := range scopes {
+		if runtimeutils.ScopeIsNestedInsideScope(repository, string(scope)) || runtimeutils.ScopeIsNestedInsideScope(image, string(scope)) {
+			return image, true
+		}
+	}
+	return "", true
+}
+
+// verifyRenderedPolicy checks that the rendered policy allows the image, pulling its signatures through
+// the rendered mirrors and sigstore attachment configuration, as the nodes will
+func (ctrl *Controller) verifyRenderedPolicy(ctx context.Context, controllerConfig *mcfgv1.ControllerConfig, policyJSON, registriesTOML, registriesdYAML []byte, imageName string) error {
+	if policyJSON == nil {
+		return fmt.Errorf("no policy was rendered")
+	}
+	policy, err := signature.NewPolicyFromBytes(policyJSON)
+	if err != nil {
+		return fmt.Errorf("could not parse the rendered policy: %w", err)
+	}
+
+	builder, err := imageutils.NewSysContextBuilder().WithControllerConfigAndPullSecret(ctx, ctrl.kubeClient, controllerConfig)
+	if err != nil {
+		return err
+	}
+	if registriesTOML != nil {
+		registriesConf := &sysregistriesv2.V2RegistriesConf{}
+		if _, err := toml.Decode(string(registriesTOML), registriesConf); err != nil {
+			return fmt.Errorf("could not decode the rendered registries config: %w", err)
+		}
+		builder = builder.WithRegistriesConfig(registriesConf)
+	}
+	sysCtx, err := builder.Build()
+	if err != nil {
+		return fmt.Errorf("could not prepare for signature verification: %w", err)
+	}
+	defer func() {
+		if err := sysCtx.Cleanup(); err != nil {
+			klog.Warningf("Unable to clean resources after signature verification: %s", err)
+		}
+	}()
+	if registriesTOML != nil {
+		// the rendered registries config replaces the drop-ins of the controller image
+		sysCtx.SysContext.SystemRegistriesConfDirPath = os.DevNull
+	}
+
+	if registriesdYAML != nil {
+		registriesDir, err := os.MkdirTemp("", "registries.d")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(registriesDir)
+		if err := os.WriteFile(filepath.Join(registriesDir, filepath.Base(sigstoreRegistriesConfigFilePath)), registriesdYAML, 0o644); err != nil {
+			return err
+		}
+		sysCtx.SysContext.RegistriesDirPath = registriesDir
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, signatureVerificationTimeout)
+	defer cancel()
+	return ctrl.verifyImageSignature(verifyCtx, sysCtx.SysContext, policy, imageName)
+}
+
+// verifyImageSignature returns an error if the policy does not allow running the image
+func verifyImageSignature(ctx context.Context, sysCtx *types.SystemContext, policy *signature.Policy, imageName string) error {
+	policyCtx, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return fmt.Errorf("could not create policy context: %w", err)
+	}
+	defer func() {
+		if err := policyCtx.Destroy(); err != nil {
+			klog.Warningf("Unable to destroy policy context: %v", err)
+		}
+	}()
+
+	ref, err := imageutils.ParseImageName(imageName)
+	if err != nil {
+		return fmt.Errorf("could not parse image %s: %w", imageName, err)
+	}
+	src, err := imageutils.GetImageSourceFromReference(ctx, sysCtx, ref, &commonretry.RetryOptions{MaxRetry: 2})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := policyCtx.IsRunningImageAllowed(ctx, image.UnparsedInstance(src, nil)); err != nil {
+		return fmt.Errorf("image %s is rejected: %w", imageName, err)
+	}
+	return nil
+}
+
+// hashRenderedConfig returns a digest of the rendered files a verification depends on
+func hashRenderedConfig(files ...[]byte) string {
+	hash := sha256.New()
+	for _, data := range files {
+		hash.Write(data)
+		// separate the files so that moving content between them changes the digest
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// setImagePolicySignatureCondition sets the signature verification condition on the ClusterImagePolicy, or
+// the ImagePolicy if namespace is set, or removes it if condition is nil. The status is only written if the
+// condition changed from the cached conditions.
+func (ctrl *Controller) setImagePolicySignatureCondition(namespace, name string, conditions []metav1.Condition, condition *metav1.Condition) {
+	existing := meta.FindStatusCondition(conditions, ImagePolicySignatureVerified)
+	if condition == nil && existing == nil {
+		return
+	}
+	if condition != nil && existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return
+	}
+
+	setCondition := func(conditions *[]metav1.Condition) {
+		if condition == nil {
+			meta.RemoveStatusCondition(conditions, ImagePolicySignatureVerified)
+		} else {
+			meta.SetStatusCondition(conditions, *condition)
+		}
+	}
+	statusUpdateErr := retry.RetryOnConflict(updateBackoff, func() error {
+		if namespace == "" {
+			policy, err := ctrl.configClient.ConfigV1().ClusterImagePolicies().Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			setCondition(&policy.Status.Conditions)
+			_, err = ctrl.configClient.ConfigV1().ClusterImagePolicies().UpdateStatus(context.TODO(), policy, metav1.UpdateOptions{})
+			return err
+		}
+		policy, err := ctrl.configClient.ConfigV1().ImagePolicies(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		setCondition(&policy.Status.Conditions)
+		_, err = ctrl.configClient.ConfigV1().ImagePolicies(namespace).UpdateStatus(context.TODO(), policy, metav1.UpdateOptions{})
+		return err
+	})
+	if statusUpdateErr != nil {
+		klog.Warningf("error updating signature verification status of %s: %v", (&signatureVerification{namespace: namespace, name: name}).String(), statusUpdateErr)
+	}
+}
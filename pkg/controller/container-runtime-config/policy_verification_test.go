@@ -0,0 +1,165 @@
+package containerruntimeconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/containers/image/v5/signature"
+	imagetypes "github.com/containers/image/v5/types"
+	apicfgv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestImagePolicySignatureVerification(t *testing.T) {
+	releaseImage := "quay.io/openshift-release-dev/ocp-release@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	sampleImage := "example.com/apps/signed@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	tests := []struct {
+		name         string
+		scopes       []string
+		annotations  map[string]string
+		verifyErr    error
+		wantVerified string
+		wantStatus   metav1.ConditionStatus
+		wantReason   string
+		wantErr      bool
+	}{
+		{
+			name:   "not opted in",
+			scopes: []string{"quay.io/openshift-release-dev"},
+		},
+		{
+			name:         "release image verified",
+			scopes:       []string{"quay.io/openshift-release-dev"},
+			annotations:  map[string]string{VerifySignaturesAnnotationKey: "true"},
+			wantVerified: releaseImage,
+			wantStatus:   metav1.ConditionTrue,
+			wantReason:   reasonSignatureVerified,
+		},
+		{
+			name:         "sample image verified",
+			scopes:       []string{"example.com"},
+			annotations:  map[string]string{SignatureVerificationImageAnnotationKey: sampleImage},
+			wantVerified: sampleImage,
+			wantStatus:   metav1.ConditionTrue,
+			wantReason:   reasonSignatureVerified,
+		},
+		{
+			name:         "signature rejected",
+			scopes:       []string{"quay.io/openshift-release-dev"},
+			annotations:  map[string]string{VerifySignaturesAnnotationKey: "true"},
+			verifyErr:    fmt.Errorf("cryptographic signature verification failed"),
+			wantVerified: releaseImage,
+			wantStatus:   metav1.ConditionFalse,
+			wantReason:   reasonSignatureRejected,
+			wantErr:      true,
+		},
+		{
+			name:        "release image outside the policy scopes",
+			scopes:      []string{"example.com"},
+			annotations: map[string]string{VerifySignaturesAnnotationKey: "true"},
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  reasonNoVerificationImage,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			cc := newControllerConfig(ctrlcommon.ControllerConfigName, apicfgv1.AWSPlatformType)
+			mcp := helpers.NewMachineConfigPool("master", nil, helpers.MasterSelector, "v0")
+			mcp2 := helpers.NewMachineConfigPool("worker", nil, helpers.WorkerSelector, "v0")
+			imgcfg := newImageConfig("cluster", &apicfgv1.RegistrySources{})
+			clusterImagePolicy := newClusterImagePolicyWithPublicKey("image-policy", tt.scopes, []byte("foo bar"))
+			clusterImagePolicy.Annotations = tt.annotations
+
+			f.ccLister = append(f.ccLister, cc)
+			f.mcpLister = append(f.mcpLister, mcp, mcp2)
+			f.imgLister = append(f.imgLister, imgcfg)
+			f.cvLister = append(f.cvLister, newClusterVersionConfig("version", releaseImage))
+			f.clusterImagePolicyLister = append(f.clusterImagePolicyLister, clusterImagePolicy)
+			f.imgObjects = append(f.imgObjects, imgcfg, clusterImagePolicy)
+
+			c := f.newController()
+			c.addImagePolicyObservers()
+			verified := []string{}
+			c.verifyImageSignature = func(_ context.Context, _ *imagetypes.SystemContext, policy *signature.Policy, image string) error {
+				// the rendered policy of the ClusterImagePolicy scopes is verified
+				for _, scope := range tt.scopes {
+					assert.NotEmpty(t, policy.Transports["docker"][scope])
+				}
+				verified = append(verified, image)
+				return tt.verifyErr
+			}
+
+			err := c.syncImageConfig("cluster")
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// the MachineConfigs are only rendered once verified
+			created := false
+			for _, action := range f.client.Actions() {
+				if action.GetVerb() == "create" && action.GetResource().Resource == "machineconfigs" {
+					created = true
+				}
+			}
+			assert.Equal(t, !tt.wantErr, created)
+
+			policy, err := f.imgClient.ConfigV1().ClusterImagePolicies().Get(context.TODO(), clusterImagePolicy.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			condition := meta.FindStatusCondition(policy.Status.Conditions, ImagePolicySignatureVerified)
+			if tt.wantStatus == "" {
+				assert.Nil(t, condition)
+				assert.Empty(t, verified)
+				return
+			}
+			require.NotNil(t, condition)
+			assert.Equal(t, tt.wantStatus, condition.Status)
+			assert.Equal(t, tt.wantReason, condition.Reason)
+
+			if tt.wantVerified == "" {
+				assert.Empty(t, verified)
+				return
+			}
+			assert.Equal(t, []string{tt.wantVerified}, verified)
+
+			// successful verifications are not repeated until the policy or rendered config change
+			c.syncImageConfig("cluster")
+			if tt.verifyErr == nil {
+				assert.Len(t, verified, 1)
+			} else {
+				assert.Len(t, verified, 2)
+			}
+		})
+	}
+}
+
+func TestSignatureVerificationImage(t *testing.T) {
+	releaseImage := "quay.io/openshift-release-dev/ocp-release@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	image, ok := signatureVerificationImage(nil, []apicfgv1.ImageScope{"quay.io"}, releaseImage)
+	assert.False(t, ok)
+	assert.Empty(t, image)
+
+	image, ok = signatureVerificationImage(map[string]string{VerifySignaturesAnnotationKey: "true"}, []apicfgv1.ImageScope{"*.io"}, releaseImage)
+	assert.True(t, ok)
+	assert.Equal(t, releaseImage, image)
+
+	image, ok = signatureVerificationImage(map[string]string{VerifySignaturesAnnotationKey: "true"}, []apicfgv1.ImageScope{apicfgv1.ImageScope(releaseImage)}, releaseImage)
+	assert.True(t, ok)
+	assert.Equal(t, releaseImage, image)
+
+	image, ok = signatureVerificationImage(map[string]string{SignatureVerificationImageAnnotationKey: "registry.example.com/app:latest"}, []apicfgv1.ImageScope{"quay.io"}, releaseImage)
+	assert.True(t, ok)
+	assert.Empty(t, image)
+}
@@ -0,0 +1,235 @@
+package pinnedimageset
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	imagev1 "github.com/openshift/api/image/v1"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
+)
+
+const (
+	// ReleaseImageAnnotationKey is set on a PinnedImageSet to pin the images of
+	// a release payload referenced by digest. The controller owns the
+	// spec.pinnedImages of the PinnedImageSet and replaces them with the
+	// release image and the images listed in the release metadata.
+	ReleaseImageAnnotationKey = "machineconfiguration.openshift.io/pinned-release-image"
+
+	// ImageStreamAnnotationKey is set on a PinnedImageSet to pin the images of
+	// an ImageStream, in the <namespace>/<name> form. The controller owns the
+	// spec.pinnedImages of the PinnedImageSet and replaces them with the
+	// images of the ImageStream tags referenced by digest.
+	ImageStreamAnnotationKey = "machineconfiguration.openshift.io/pinned-image-stream"
+
+	// ExpandedFromAnnotationKey records the source the spec.pinnedImages of a
+	// PinnedImageSet were last expanded from.
+	ExpandedFromAnnotationKey = "machineconfiguration.openshift.io/pinned-images-expanded-from"
+
+	// maxPinnedImages is the maximum number of images of a PinnedImageSet
+	// accepted by the API.
+	maxPinnedImages = 500
+
+	// imageStreamResyncInterval is how often pinned images are expanded again
+	// from ImageStreams, whose tags may move.
+	imageStreamResyncInterval = 10 * time.Minute
+)
+
+// getPinnedImageSource returns the source annotation of the PinnedImageSet
+// and its value or empty strings if the images are listed manually.
+func getPinnedImageSource(imageSet *mcfgv1.PinnedImageSet) (string, string, error) {
+	release, hasRelease := imageSet.Annotations[ReleaseImageAnnotationKey]
+	imageStream, hasImageStream := imageSet.Annotations[ImageStreamAnnotationKey]
+	switch {
+	case hasRelease && hasImageStream:
+		return "", "", fmt.Errorf("PinnedImageSet %s cannot set both %s and %s", imageSet.Name, ReleaseImageAnnotationKey, ImageStreamAnnotationKey)
+	case hasRelease:
+		return ReleaseImageAnnotationKey, release, nil
+	case hasImageStream:
+		return ImageStreamAnnotationKey, imageStream, nil
+	}
+	return "", "", nil
+}
+
+// expandPinnedImageSets replaces the pinned images of the PinnedImageSets
+// referencing a release image or an ImageStream with the concrete images they
+// resolve to. It returns true if any PinnedImageSet references an ImageStream.
+func (ctrl *Controller) expandPinnedImageSets(ctx context.Context, imageSets []*mcfgv1.PinnedImageSet) (bool, error) {
+	watchImageStreams := false
+	for _, imageSet := range imageSets {
+		key, source, err := getPinnedImageSource(imageSet)
+		if err != nil {
+			return watchImageStreams, err
+		}
+
+		var images []string
+		switch key {
+		case "":
+			continue
+		case ReleaseImageAnnotationKey:
+			// release images are referenced by digest so a release is only
+			// expanded once
+			if imageSet.Annotations[ExpandedFromAnnotationKey] == source {
+				continue
+			}
+			images, err = ctrl.getReleaseImages(ctx, source)
+		case ImageStreamAnnotationKey:
+			watchImageStreams = true
+			images, err = ctrl.getImageStreamImages(ctx, source)
+		}
+		if err != nil {
+			return watchImageStreams, fmt.Errorf("failed to expand pinned images of PinnedImageSet %s: %w", imageSet.Name, err)
+		}
+
+		if err := ctrl.updatePinnedImages(ctx, imageSet, source, images); err != nil {
+			return watchImageStreams, fmt.Errorf("failed to update pinned images of PinnedImageSet %s: %w", imageSet.Name, err)
+		}
+	}
+	return watchImageStreams, nil
+}
+
+// getReleaseImages returns the release image and the images listed in the
+// image references of the release payload.
+func (ctrl *Controller) getReleaseImages(ctx context.Context, releaseImage string) ([]string, error) {
+	if !isImageDigest(releaseImage) {
+		return nil, fmt.Errorf("release image %s must be referenced by digest", releaseImage)
+	}
+
+	sysCtx, err := ctrl.buildSysContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := sysCtx.Cleanup(); err != nil {
+			klog.Warningf("Unable to clean resources after release image inspection: %s", err)
+		}
+	}()
+
+	inspector := ctrl.imagesInspectorFactory.ForContext(sysCtx.SysContext)
+	imageStream, err := osimagestream.NewImageStreamProviderNetwork(inspector, releaseImage).ReadImageStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read release metadata of %s: %w", releaseImage, err)
+	}
+	return append(getImageStreamDigests(imageStream), releaseImage), nil
+}
+
+// getImageStreamImages returns the images of the tags of the ImageStream
+// referenced as <namespace>/<name>.
+func (ctrl *Controller) getImageStreamImages(ctx context.Context, ref string) ([]string, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid ImageStream reference %q, expected <namespace>/<name>", ref)
+	}
+	imageStream, err := ctrl.imageClient.ImageV1().ImageStreams(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ImageStream %s: %w", ref, err)
+	}
+	return getImageStreamDigests(imageStream), nil
+}
+
+// getImageStreamDigests returns the images of the ImageStream tags referenced
+// by digest. The spec of release metadata already references digests while
+// the status of a cluster ImageStream holds the digest tags resolve to.
+func getImageStreamDigests(imageStream *imagev1.ImageStream) []string {
+	var images []string
+	for _, tag := range imageStream.Spec.Tags {
+		if tag.From == nil || tag.From.Kind != "DockerImage" {
+			continue
+		}
+		if isImageDigest(tag.From.Name) {
+			images = append(images, tag.From.Name)
+		}
+	}
+	for _, tag := range imageStream.Status.Tags {
+		if len(tag.Items) == 0 {
+			continue
+		}
+		// the first item is the image the tag currently points to
+		if image := tag.Items[0].DockerImageReference; isImageDigest(image) {
+			images = append(images, image)
+		} else {
+			klog.V(4).Infof("Skipping ImageStream %s/%s tag %s not referenced by digest", imageStream.Namespace, imageStream.Name, tag.Tag)
+		}
+	}
+	return images
+}
+
+// updatePinnedImages writes the images to the spec of the PinnedImageSet and
+// records the source they were expanded from.
+func (ctrl *Controller) updatePinnedImages(ctx context.Context, imageSet *mcfgv1.PinnedImageSet, source string, images []string) error {
+	pinnedImages := toPinnedImageRefs(images)
+	if len(pinnedImages) == 0 {
+		return fmt.Errorf("no images found in %s", source)
+	}
+	if len(pinnedImages) > maxPinnedImages {
+		return fmt.Errorf("%s resolves to %d images, more than the maximum of %d", source, len(pinnedImages), maxPinnedImages)
+	}
+
+	if equality.Semantic.DeepEqual(imageSet.Spec.PinnedImages, pinnedImages) && imageSet.Annotations[ExpandedFromAnnotationKey] == source {
+		return nil
+	}
+
+	newImageSet := imageSet.DeepCopy()
+	newImageSet.Spec.PinnedImages = pinnedImages
+	metav1.SetMetaDataAnnotation(&newImageSet.ObjectMeta, ExpandedFromAnnotationKey, source)
+	if _, err := ctrl.client.MachineconfigurationV1().PinnedImageSets().Update(ctx, newImageSet, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("PinnedImageSet %s expanded to %d images from %s", imageSet.Name, len(pinnedImages), source)
+	ctrl.eventRecorder.Eventf(newImageSet, "Normal", "PinnedImagesExpanded", "Expanded to %d images from %s", len(pinnedImages), source)
+	return nil
+}
+
+// buildSysContext builds the SysContext used to read release metadata with
+// the cluster pull secret and registry certificates.
+func (ctrl *Controller) buildSysContext(ctx context.Context) (*imageutils.SysContext, error) {
+	cc, err := ctrl.client.MachineconfigurationV1().ControllerConfigs().Get(ctx, ctrlcommon.ControllerConfigName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get ControllerConfig: %w", err)
+	}
+
+	builder, err := imageutils.NewSysContextBuilder().WithControllerConfigAndPullSecret(ctx, ctrl.kubeClient, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	sysCtx, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not prepare for release image inspection: %w", err)
+	}
+	return sysCtx, nil
+}
+
+// toPinnedImageRefs returns the sorted unique pinned image references of the
+// images.
+func toPinnedImageRefs(images []string) []mcfgv1.PinnedImageRef {
+	unique := make(map[string]struct{}, len(images))
+	for _, image := range images {
+		unique[image] = struct{}{}
+	}
+	names := make([]string, 0, len(unique))
+	for image := range unique {
+		names = append(names, image)
+	}
+	sort.Strings(names)
+
+	refs := make([]mcfgv1.PinnedImageRef, 0, len(names))
+	for _, name := range names {
+		refs = append(refs, mcfgv1.PinnedImageRef{Name: mcfgv1.ImageDigestFormat(name)})
+	}
+	return refs
+}
+
+func isImageDigest(image string) bool {
+	_, digest, ok := strings.Cut(image, "@sha256:")
+	return ok && len(digest) == 64
+}
@@ -0,0 +1,192 @@
+package pinnedimageset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	imagev1 "github.com/openshift/api/image/v1"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	fakeimage "github.com/openshift/client-go/image/clientset/versioned/fake"
+	fakemco "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/imageutils"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
+)
+
+const releaseImageReferences = `{
+  "kind": "ImageStream",
+  "apiVersion": "image.openshift.io/v1",
+  "metadata": {"name": "4.21.0"},
+  "spec": {
+    "tags": [
+      {"name": "cli", "from": {"kind": "DockerImage", "name": "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1111111111111111111111111111111111111111111111111111111111111111"}},
+      {"name": "rhel-coreos", "from": {"kind": "DockerImage", "name": "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:2222222222222222222222222222222222222222222222222222222222222222"}},
+      {"name": "tools", "from": {"kind": "ImageStreamTag", "name": "cli"}}
+    ]
+  }
+}`
+
+var (
+	releaseImage = "quay.io/openshift-release-dev/ocp-release@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	cliImage     = "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	coreosImage  = "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+type fakeImagesInspector struct {
+	files map[string][]byte
+}
+
+func (f *fakeImagesInspector) Inspect(_ context.Context, _ ...string) ([]imageutils.BulkInspectResult, error) {
+	return nil, nil
+}
+
+func (f *fakeImagesInspector) FetchImageFile(_ context.Context, image, path string) ([]byte, error) {
+	content, ok := f.files[image+path]
+	if !ok {
+		return nil, fmt.Errorf("image %s not found", image)
+	}
+	return content, nil
+}
+
+type fakeImagesInspectorFactory struct {
+	inspector *fakeImagesInspector
+}
+
+func (f *fakeImagesInspectorFactory) ForContext(_ *types.SystemContext) osimagestream.ImagesInspector {
+	return f.inspector
+}
+
+func TestExpandPinnedImageSets(t *testing.T) {
+	imageStream := &imagev1.ImageStream{
+		ObjectMeta: metav1.ObjectMeta{Name: "tools", Namespace: "apps"},
+		Status: imagev1.ImageStreamStatus{
+			Tags: []imagev1.NamedTagEventList{
+				{Tag: "latest", Items: []imagev1.TagEvent{{DockerImageReference: cliImage}, {DockerImageReference: coreosImage}}},
+				{Tag: "debug", Items: []imagev1.TagEvent{{DockerImageReference: "quay.io/example/debug:latest"}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name                  string
+		annotations           map[string]string
+		wantImages            []string
+		wantWatchImageStreams bool
+		wantErr               string
+	}{
+		{
+			name:       "manual images",
+			wantImages: []string{"image1"},
+		},
+		{
+			name:        "release image",
+			annotations: map[string]string{ReleaseImageAnnotationKey: releaseImage},
+			wantImages:  []string{releaseImage, cliImage, coreosImage},
+		},
+		{
+			name:        "release image already expanded",
+			annotations: map[string]string{ReleaseImageAnnotationKey: releaseImage, ExpandedFromAnnotationKey: releaseImage},
+			wantImages:  []string{"image1"},
+		},
+		{
+			name:        "release image by tag",
+			annotations: map[string]string{ReleaseImageAnnotationKey: "quay.io/openshift-release-dev/ocp-release:4.21.0"},
+			wantErr:     "must be referenced by digest",
+		},
+		{
+			name:                  "image stream",
+			annotations:           map[string]string{ImageStreamAnnotationKey: "apps/tools"},
+			wantImages:            []string{cliImage},
+			wantWatchImageStreams: true,
+		},
+		{
+			name:                  "invalid image stream reference",
+			annotations:           map[string]string{ImageStreamAnnotationKey: "tools"},
+			wantWatchImageStreams: true,
+			wantErr:               "expected <namespace>/<name>",
+		},
+		{
+			name:        "both sources",
+			annotations: map[string]string{ReleaseImageAnnotationKey: releaseImage, ImageStreamAnnotationKey: "apps/tools"},
+			wantErr:     "cannot set both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			imageSet := fakePinnedImageSet("release-set", "image1", map[string]string{"machineconfiguration.openshift.io/role": "worker"})
+			imageSet.Annotations = tt.annotations
+			cc := &mcfgv1.ControllerConfig{ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.ControllerConfigName}}
+
+			fakeClient := fake.NewSimpleClientset()
+			kubeInformers := informers.NewSharedInformerFactory(fakeClient, noResyncPeriodFunc())
+			fakeMCOClient := fakemco.NewSimpleClientset(imageSet, cc)
+			sharedInformers := mcfginformers.NewSharedInformerFactory(fakeMCOClient, noResyncPeriodFunc())
+			c := New(
+				sharedInformers.Machineconfiguration().V1().PinnedImageSets(),
+				sharedInformers.Machineconfiguration().V1().MachineConfigPools(),
+				kubeInformers.Core().V1().Nodes(),
+				fakeClient,
+				fakeimage.NewSimpleClientset(imageStream),
+				fakeMCOClient,
+			)
+			c.eventRecorder = record.NewFakeRecorder(10)
+			c.imagesInspectorFactory = &fakeImagesInspectorFactory{inspector: &fakeImagesInspector{
+				files: map[string][]byte{releaseImage + "/release-manifests/image-references": []byte(releaseImageReferences)},
+			}}
+
+			watchImageStreams, err := c.expandPinnedImageSets(ctx, []*mcfgv1.PinnedImageSet{imageSet})
+			require.Equal(tt.wantWatchImageStreams, watchImageStreams)
+			if tt.wantErr != "" {
+				require.Error(err)
+				require.True(strings.Contains(err.Error(), tt.wantErr), err.Error())
+				return
+			}
+			require.NoError(err)
+
+			updated, err := fakeMCOClient.MachineconfigurationV1().PinnedImageSets().Get(ctx, imageSet.Name, metav1.GetOptions{})
+			require.NoError(err)
+			require.Equal(toPinnedImageRefs(tt.wantImages), updated.Spec.PinnedImages)
+		})
+	}
+}
+
+func TestBuildSysContextPullSecret(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	cc := &mcfgv1.ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.ControllerConfigName},
+		Spec: mcfgv1.ControllerConfigSpec{
+			PullSecret: &corev1.ObjectReference{Namespace: "openshift-config", Name: "pull-secret"},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-config", Name: "pull-secret"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"quay.io":{"auth":"Zm9vOmJhcg=="}}}`)},
+	}
+	c := &Controller{
+		client:     fakemco.NewSimpleClientset(cc),
+		kubeClient: fake.NewSimpleClientset(secret),
+	}
+
+	sysCtx, err := c.buildSysContext(ctx)
+	require.NoError(err)
+	defer sysCtx.Cleanup()
+	require.NotEmpty(sysCtx.SysContext.AuthFilePath)
+}
@@ -13,20 +13,25 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformersv1 "k8s.io/client-go/informers/core/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	imageclientset "github.com/openshift/client-go/image/clientset/versioned"
 	mcfgclientset "github.com/openshift/client-go/machineconfiguration/clientset/versioned"
 	"github.com/openshift/client-go/machineconfiguration/clientset/versioned/scheme"
 	mcfginformersv1 "github.com/openshift/client-go/machineconfiguration/informers/externalversions/machineconfiguration/v1"
 	mcfglistersv1 "github.com/openshift/client-go/machineconfiguration/listers/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/apihelpers"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/osimagestream"
 )
 
 const (
@@ -44,8 +49,12 @@ const (
 // Controller defines the pinned image set controller.
 type Controller struct {
 	client        mcfgclientset.Interface
+	kubeClient    clientset.Interface
+	imageClient   imageclientset.Interface
 	eventRecorder record.EventRecorder
 
+	imagesInspectorFactory osimagestream.ImagesInspectorFactory
+
 	syncHandler              func(mcp string) error
 	enqueueMachineConfigPool func(*mcfgv1.MachineConfigPool)
 
@@ -55,6 +64,9 @@ type Controller struct {
 	imageSetLister mcfglistersv1.PinnedImageSetLister
 	imageSetSynced cache.InformerSynced
 
+	nodeLister       corelisterv1.NodeLister
+	nodeListerSynced cache.InformerSynced
+
 	queue workqueue.TypedRateLimitingInterface[string]
 }
 
@@ -62,7 +74,9 @@ type Controller struct {
 func New(
 	imageSetInformer mcfginformersv1.PinnedImageSetInformer,
 	mcpInformer mcfginformersv1.MachineConfigPoolInformer,
+	nodeInformer coreinformersv1.NodeInformer,
 	kubeClient clientset.Interface,
+	imageClient imageclientset.Interface,
 	mcfgClient mcfgclientset.Interface,
 ) *Controller {
 	eventBroadcaster := record.NewBroadcaster()
@@ -71,10 +85,13 @@ func New(
 
 	ctrl := &Controller{
 		client:        mcfgClient,
+		kubeClient:    kubeClient,
+		imageClient:   imageClient,
 		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-pinnedimagesetcontroller"})),
 		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
 			workqueue.DefaultTypedControllerRateLimiter[string](),
 			workqueue.TypedRateLimitingQueueConfig[string]{Name: "machineconfigcontroller-pinnedimagesetcontroller"}),
+		imagesInspectorFactory: &osimagestream.DefaultImagesInspectorFactory{},
 	}
 
 	ctrl.syncHandler = ctrl.syncMachineConfigPool
@@ -94,12 +111,20 @@ func New(
 		DeleteFunc: ctrl.deletePinnedImageSet,
 	})
 
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.updateNode,
+		DeleteFunc: ctrl.deleteNode,
+	})
+
 	ctrl.mcpLister = mcpInformer.Lister()
 	ctrl.mcpListerSynced = mcpInformer.Informer().HasSynced
 
 	ctrl.imageSetLister = imageSetInformer.Lister()
 	ctrl.imageSetSynced = imageSetInformer.Informer().HasSynced
 
+	ctrl.nodeLister = nodeInformer.Lister()
+	ctrl.nodeListerSynced = nodeInformer.Informer().HasSynced
+
 	return ctrl
 }
 
@@ -108,7 +133,7 @@ func (ctrl *Controller) Run(workers int, stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.imageSetSynced) {
+	if !cache.WaitForCacheSync(stopCh, ctrl.mcpListerSynced, ctrl.imageSetSynced, ctrl.nodeListerSynced) {
 		return
 	}
 
@@ -329,6 +354,10 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 		return err
 	}
 
+	if err := ctrl.syncPrefetchSlots(mcp); err != nil {
+		return err
+	}
+
 	pool := mcp.DeepCopy()
 	everything := metav1.LabelSelector{}
 	if reflect.DeepEqual(pool.Spec.MachineConfigSelector, &everything) {
@@ -346,6 +375,24 @@ func (ctrl *Controller) syncMachineConfigPool(key string) error {
 	}
 	sort.SliceStable(imageSets, func(i, j int) bool { return imageSets[i].Name < imageSets[j].Name })
 
+	watchImageStreams, err := ctrl.expandPinnedImageSets(context.TODO(), imageSets)
+	if watchImageStreams {
+		ctrl.enqueueAfter(pool, imageStreamResyncInterval)
+	}
+	if err != nil {
+		klog.Errorf("Error expanding pinned image sets: %v", err)
+		return ctrl.syncFailingStatus(pool, err)
+	}
+
+	for _, imageSet := range imageSets {
+		if !helpers.IsPinnedImagePreflight(imageSet) {
+			continue
+		}
+		if err := ctrl.syncPreflightReport(pool, imageSet); err != nil {
+			return fmt.Errorf("failed to sync preflight report of PinnedImageSet %s: %w", imageSet.Name, err)
+		}
+	}
+
 	if err := ctrl.syncPinnedImageSets(pool, imageSets); err != nil {
 		klog.Errorf("Error syncing pinned image sets: %v", err)
 		return ctrl.syncFailingStatus(pool, err)
@@ -378,6 +425,10 @@ func (ctrl *Controller) syncFailingStatus(pool *mcfgv1.MachineConfigPool, err er
 func (ctrl *Controller) syncPinnedImageSets(pool *mcfgv1.MachineConfigPool, imageSets []*mcfgv1.PinnedImageSet) error {
 	pinnedImageSetRefs := make([]mcfgv1.PinnedImageSetRef, 0, len(imageSets))
 	for _, imageSet := range imageSets {
+		// preflight image sets are only reported on, never pinned
+		if helpers.IsPinnedImagePreflight(imageSet) {
+			continue
+		}
 		pinnedImageSetRefs = append(pinnedImageSetRefs, mcfgv1.PinnedImageSetRef{
 			Name: imageSet.Name,
 		})
@@ -6,12 +6,16 @@ import (
 	"time"
 
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
 
+	fakeimage "github.com/openshift/client-go/image/clientset/versioned/fake"
 	fakemco "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/test/helpers"
 	"github.com/stretchr/testify/require"
 )
@@ -62,6 +66,7 @@ func TestSyncHandler(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 			fakeClient := fake.NewSimpleClientset()
+			kubeInformers := informers.NewSharedInformerFactory(fakeClient, noResyncPeriodFunc())
 			fakeMCOClient := fakemco.NewSimpleClientset(tt.machineConfigPool)
 			sharedInformers := mcfginformers.NewSharedInformerFactory(fakeMCOClient, noResyncPeriodFunc())
 			mcpInformer := sharedInformers.Machineconfiguration().V1().MachineConfigPools()
@@ -78,7 +83,7 @@ func TestSyncHandler(t *testing.T) {
 				require.NoError(err)
 			}
 
-			c := New(imageSetInformer, mcpInformer, fakeClient, fakeMCOClient)
+			c := New(imageSetInformer, mcpInformer, kubeInformers.Core().V1().Nodes(), fakeClient, fakeimage.NewSimpleClientset(), fakeMCOClient)
 			mcp, ok := tt.machineConfigPool.(*mcfgv1.MachineConfigPool)
 			require.True(ok)
 
@@ -92,6 +97,76 @@ func TestSyncHandler(t *testing.T) {
 	}
 }
 
+func TestSyncPrefetchSlots(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := workerPool.DeepCopy()
+	pool.Annotations = map[string]string{daemonconsts.PinnedImageMaxPrefetchingNodesAnnotationKey: "1"}
+	nodes := []*corev1.Node{
+		fakePrefetchNode("node-a", "prefetch-1", "prefetch-1", corev1.ConditionTrue),
+		fakePrefetchNode("node-b", "prefetch-3", "", corev1.ConditionTrue),
+		fakePrefetchNode("node-c", "prefetch-2", "", corev1.ConditionTrue),
+		fakePrefetchNode("node-d", "done", "prefetch-0", corev1.ConditionTrue),
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	kubeInformers := informers.NewSharedInformerFactory(fakeClient, noResyncPeriodFunc())
+	nodeInformer := kubeInformers.Core().V1().Nodes()
+	fakeMCOClient := fakemco.NewSimpleClientset(pool)
+	sharedInformers := mcfginformers.NewSharedInformerFactory(fakeMCOClient, noResyncPeriodFunc())
+	mcpInformer := sharedInformers.Machineconfiguration().V1().MachineConfigPools()
+	imageSetInformer := sharedInformers.Machineconfiguration().V1().PinnedImageSets()
+	require.NoError(mcpInformer.Informer().GetIndexer().Add(pool))
+	for _, node := range nodes {
+		_, err := fakeClient.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{})
+		require.NoError(err)
+		require.NoError(nodeInformer.Informer().GetIndexer().Add(node))
+	}
+
+	c := New(imageSetInformer, mcpInformer, nodeInformer, fakeClient, fakeimage.NewSimpleClientset(), fakeMCOClient)
+
+	// node-a holds the only slot
+	require.NoError(c.syncPrefetchSlots(pool))
+	require.Len(fakeClient.Actions(), 4)
+
+	// node-c requested a slot before node-b and gets the slot once node-a is done
+	doneNode := fakePrefetchNode("node-a", "done", "prefetch-1", corev1.ConditionTrue)
+	require.NoError(nodeInformer.Informer().GetIndexer().Update(doneNode))
+	require.NoError(c.syncPrefetchSlots(pool))
+	node, err := fakeClient.CoreV1().Nodes().Get(ctx, "node-c", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal("prefetch-2", node.Annotations[daemonconsts.GrantedPinnedImagePrefetchAnnotationKey])
+	node, err = fakeClient.CoreV1().Nodes().Get(ctx, "node-b", metav1.GetOptions{})
+	require.NoError(err)
+	require.Empty(node.Annotations[daemonconsts.GrantedPinnedImagePrefetchAnnotationKey])
+
+	// a node that is not ready does not hold its slot
+	grantedNode := fakePrefetchNode("node-c", "prefetch-2", "prefetch-2", corev1.ConditionFalse)
+	require.NoError(nodeInformer.Informer().GetIndexer().Update(grantedNode))
+	require.NoError(c.syncPrefetchSlots(pool))
+	node, err = fakeClient.CoreV1().Nodes().Get(ctx, "node-b", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal("prefetch-3", node.Annotations[daemonconsts.GrantedPinnedImagePrefetchAnnotationKey])
+}
+
+func fakePrefetchNode(name, desired, granted string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"node-role/worker": ""},
+			Annotations: map[string]string{
+				daemonconsts.DesiredPinnedImagePrefetchAnnotationKey: desired,
+				daemonconsts.GrantedPinnedImagePrefetchAnnotationKey: granted,
+			},
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
 func fakePinnedImageSet(name, image string, labels map[string]string) *mcfgv1.PinnedImageSet {
 	return &mcfgv1.PinnedImageSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,159 @@
+package pinnedimageset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+func (ctrl *Controller) updateNode(old, cur interface{}) {
+	oldNode := old.(*corev1.Node)
+	curNode := cur.(*corev1.Node)
+
+	if oldNode.Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey] == curNode.Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey] &&
+		oldNode.Annotations[daemonconsts.PinnedImagePreflightReportAnnotationKey] == curNode.Annotations[daemonconsts.PinnedImagePreflightReportAnnotationKey] &&
+		isNodeReady(oldNode) == isNodeReady(curNode) {
+		return
+	}
+	ctrl.enqueuePoolForNode(curNode)
+}
+
+func (ctrl *Controller) deleteNode(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("failed to get object from tombstone %#v", obj))
+			return
+		}
+		node, ok = tombstone.Obj.(*corev1.Node)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a Node %#v", obj))
+			return
+		}
+	}
+	ctrl.enqueuePoolForNode(node)
+}
+
+// enqueuePoolForNode enqueues the primary pool of a node that requested or
+// holds a pinned image prefetch slot or reported on preflight PinnedImageSets.
+func (ctrl *Controller) enqueuePoolForNode(node *corev1.Node) {
+	if node.Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey] == "" &&
+		node.Annotations[daemonconsts.PinnedImagePreflightReportAnnotationKey] == "" {
+		return
+	}
+	pool, err := helpers.GetPrimaryPoolForNode(ctrl.mcpLister, node)
+	if err != nil {
+		klog.Errorf("error finding pool for node %s: %v", node.Name, err)
+		return
+	}
+	if pool == nil {
+		return
+	}
+	ctrl.enqueueMachineConfigPool(pool)
+}
+
+// syncPrefetchSlots grants pinned image prefetch slots to the nodes of the
+// pool that requested one, in the order of their requests, while the number
+// of nodes prefetching is below the limit set on the pool.
+func (ctrl *Controller) syncPrefetchSlots(pool *mcfgv1.MachineConfigPool) error {
+	nodes, err := helpers.GetNodesForPool(ctrl.mcpLister, ctrl.nodeLister, pool)
+	if err != nil {
+		return err
+	}
+
+	maxNodes := getMaxPrefetchingNodes(pool)
+	prefetching := 0
+	var waiting []*corev1.Node
+	for _, node := range nodes {
+		desired := node.Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey]
+		if !strings.HasPrefix(desired, daemonconsts.PinnedImagePrefetchRequestPrefix) {
+			continue
+		}
+		if desired != node.Annotations[daemonconsts.GrantedPinnedImagePrefetchAnnotationKey] {
+			waiting = append(waiting, node)
+			continue
+		}
+		// a node that went away while prefetching does not hold its slot
+		if isNodeReady(node) {
+			prefetching++
+		}
+	}
+
+	sort.SliceStable(waiting, func(i, j int) bool {
+		iDesired := waiting[i].Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey]
+		jDesired := waiting[j].Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey]
+		if iDesired != jDesired {
+			return iDesired < jDesired
+		}
+		return waiting[i].Name < waiting[j].Name
+	})
+
+	for _, node := range waiting {
+		if maxNodes > 0 && prefetching >= maxNodes {
+			klog.V(4).Infof("Pool %s: %d nodes prefetching pinned images, %d waiting", pool.Name, prefetching, len(waiting))
+			break
+		}
+		desired := node.Annotations[daemonconsts.DesiredPinnedImagePrefetchAnnotationKey]
+		if err := ctrl.setNodeAnnotations(node.Name, map[string]string{
+			daemonconsts.GrantedPinnedImagePrefetchAnnotationKey: desired,
+		}); err != nil {
+			return fmt.Errorf("failed to grant pinned image prefetch slot to node %s: %w", node.Name, err)
+		}
+		klog.Infof("Pool %s: granted pinned image prefetch slot %s to node %s", pool.Name, desired, node.Name)
+		prefetching++
+	}
+
+	return nil
+}
+
+func (ctrl *Controller) setNodeAnnotations(nodeName string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ctrl.kubeClient.CoreV1().Nodes().Patch(context.TODO(), nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// getMaxPrefetchingNodes returns the maximum number of nodes of the pool
+// prefetching pinned images at once or 0 if unlimited.
+func getMaxPrefetchingNodes(pool *mcfgv1.MachineConfigPool) int {
+	value, ok := pool.Annotations[daemonconsts.PinnedImageMaxPrefetchingNodesAnnotationKey]
+	if !ok {
+		return 0
+	}
+	maxNodes, err := strconv.Atoi(value)
+	if err != nil || maxNodes < 1 {
+		klog.Warningf("Ignoring invalid %s %q on MachineConfigPool %s", daemonconsts.PinnedImageMaxPrefetchingNodesAnnotationKey, value, pool.Name)
+		return 0
+	}
+	return maxNodes
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
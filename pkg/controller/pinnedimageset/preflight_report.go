@@ -0,0 +1,216 @@
+package pinnedimageset
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+const (
+	// preflightReportPrefix prefixes the name of the preflight report
+	// ConfigMaps, followed by the pool and PinnedImageSet names.
+	preflightReportPrefix = "pinned-image-preflight"
+	// preflightReportKey is the ConfigMap key of the JSON report.
+	preflightReportKey = "report.json"
+	// preflightSummaryKey is the ConfigMap key of the human readable summary.
+	preflightSummaryKey = "summary"
+)
+
+// preflightReport aggregates the preflight reports of the nodes of a pool for
+// a PinnedImageSet.
+type preflightReport struct {
+	Pool           string `json:"pool"`
+	PinnedImageSet string `json:"pinnedImageSet"`
+	Generation     int64  `json:"generation"`
+	Images         int    `json:"images"`
+	// Ready is true once all nodes reported and the images fit on all of them.
+	Ready bool `json:"ready"`
+	// PendingNodes did not report for the current generation yet.
+	PendingNodes []string `json:"pendingNodes,omitempty"`
+	// InsufficientStorageNodes cannot fit the missing images.
+	InsufficientStorageNodes []string `json:"insufficientStorageNodes,omitempty"`
+	// FailedNodes could not compute their report.
+	FailedNodes []string `json:"failedNodes,omitempty"`
+	// TotalPullBytes is the compressed size of the images pulled by all nodes.
+	TotalPullBytes int64 `json:"totalPullBytes"`
+	// PullBandwidth is the pull bandwidth per node used for the estimate.
+	PullBandwidth int64 `json:"pullBandwidth,omitempty"`
+	// EstimatedPullTime is the time to pull the missing images on all nodes,
+	// accounting for the pool limit on nodes prefetching at once.
+	EstimatedPullTime string `json:"estimatedPullTime,omitempty"`
+	// Nodes are the reports of the nodes keyed by node name.
+	Nodes map[string]helpers.PinnedImagePreflightNodeReport `json:"nodes"`
+}
+
+// syncPreflightReport aggregates the node reports for the preflight
+// PinnedImageSet into a ConfigMap in the MCO namespace owned by the
+// PinnedImageSet.
+func (ctrl *Controller) syncPreflightReport(pool *mcfgv1.MachineConfigPool, imageSet *mcfgv1.PinnedImageSet) error {
+	nodes, err := helpers.GetNodesForPool(ctrl.mcpLister, ctrl.nodeLister, pool)
+	if err != nil {
+		return err
+	}
+
+	report := newPreflightReport(pool, imageSet, nodes)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getPreflightReportName(pool, imageSet),
+			Namespace: ctrlcommon.MCONamespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(imageSet, mcfgv1.SchemeGroupVersion.WithKind("PinnedImageSet")),
+			},
+		},
+		Data: map[string]string{
+			preflightReportKey:  string(data),
+			preflightSummaryKey: report.summary(),
+		},
+	}
+
+	configMaps := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace)
+	existing, err := configMaps.Get(context.TODO(), cm.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Data[preflightReportKey] == cm.Data[preflightReportKey] {
+		return nil
+	}
+	updated := existing.DeepCopy()
+	updated.Data = cm.Data
+	if _, err := configMaps.Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Pool %s: pinned image preflight for PinnedImageSet %s: %s", pool.Name, imageSet.Name, report.summary())
+	return nil
+}
+
+func getPreflightReportName(pool *mcfgv1.MachineConfigPool, imageSet *mcfgv1.PinnedImageSet) string {
+	return fmt.Sprintf("%s-%s-%s", preflightReportPrefix, pool.Name, imageSet.Name)
+}
+
+func newPreflightReport(pool *mcfgv1.MachineConfigPool, imageSet *mcfgv1.PinnedImageSet, nodes []*corev1.Node) *preflightReport {
+	report := &preflightReport{
+		Pool:           pool.Name,
+		PinnedImageSet: imageSet.Name,
+		Generation:     imageSet.Generation,
+		Images:         len(imageSet.Spec.PinnedImages),
+		Nodes:          map[string]helpers.PinnedImagePreflightNodeReport{},
+	}
+
+	var pullBytes []int64
+	for _, node := range nodes {
+		reports, err := helpers.GetPinnedImagePreflightReports(node)
+		if err != nil {
+			klog.Warningf("Ignoring preflight reports of node %s: %v", node.Name, err)
+		}
+		nodeReport, ok := reports[imageSet.Name]
+		if !ok || nodeReport.Generation != imageSet.Generation {
+			report.PendingNodes = append(report.PendingNodes, node.Name)
+			continue
+		}
+		report.Nodes[node.Name] = nodeReport
+		switch {
+		case nodeReport.Error != "":
+			report.FailedNodes = append(report.FailedNodes, node.Name)
+		case !nodeReport.Fits:
+			report.InsufficientStorageNodes = append(report.InsufficientStorageNodes, node.Name)
+		}
+		report.TotalPullBytes += nodeReport.PullBytes
+		pullBytes = append(pullBytes, nodeReport.PullBytes)
+	}
+	sort.Strings(report.PendingNodes)
+	sort.Strings(report.InsufficientStorageNodes)
+	sort.Strings(report.FailedNodes)
+
+	report.Ready = len(nodes) > 0 && len(report.PendingNodes) == 0 && len(report.FailedNodes) == 0 && len(report.InsufficientStorageNodes) == 0
+
+	report.PullBandwidth = getPreflightBandwidth(pool, imageSet)
+	if report.PullBandwidth > 0 {
+		report.EstimatedPullTime = estimatePullTime(pullBytes, report.PullBandwidth, getMaxPrefetchingNodes(pool)).String()
+	}
+	return report
+}
+
+// getPreflightBandwidth returns the expected pull bandwidth per node set on
+// the PinnedImageSet, capped by the pool pull bandwidth limit, or 0 if
+// unknown.
+func getPreflightBandwidth(pool *mcfgv1.MachineConfigPool, imageSet *mcfgv1.PinnedImageSet) int64 {
+	bandwidth := parseBandwidth(imageSet.Annotations, daemonconsts.PinnedImagePreflightBandwidthAnnotationKey)
+	if limit := parseBandwidth(pool.Annotations, daemonconsts.PinnedImageMaxPullBandwidthAnnotationKey); limit > 0 && (bandwidth == 0 || limit < bandwidth) {
+		bandwidth = limit
+	}
+	return bandwidth
+}
+
+func parseBandwidth(annotations map[string]string, key string) int64 {
+	value, ok := annotations[key]
+	if !ok {
+		return 0
+	}
+	bandwidth, err := resource.ParseQuantity(value)
+	if err != nil || bandwidth.Value() < 1 {
+		klog.Warningf("Ignoring invalid %s %q", key, value)
+		return 0
+	}
+	return bandwidth.Value()
+}
+
+// estimatePullTime estimates the time for the nodes to pull their bytes at
+// the bandwidth. Nodes pull in parallel, at most maxNodes at once if set, and
+// the nodes with the most bytes to pull go first.
+func estimatePullTime(pullBytes []int64, bandwidth int64, maxNodes int) time.Duration {
+	sorted := append([]int64(nil), pullBytes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	if maxNodes <= 0 {
+		maxNodes = len(sorted)
+	}
+
+	var total time.Duration
+	for i := 0; i < len(sorted); i += maxNodes {
+		// a wave lasts as long as its slowest node
+		total += time.Duration(float64(sorted[i]) / float64(bandwidth) * float64(time.Second))
+	}
+	return total.Round(time.Second)
+}
+
+func (r *preflightReport) summary() string {
+	var b strings.Builder
+	if r.Ready {
+		fmt.Fprintf(&b, "ready: all %d nodes can fit the %d images", len(r.Nodes), r.Images)
+	} else {
+		fmt.Fprintf(&b, "not ready: %d/%d nodes reported", len(r.Nodes), len(r.Nodes)+len(r.PendingNodes))
+		if len(r.InsufficientStorageNodes) > 0 {
+			fmt.Fprintf(&b, ", insufficient storage on %s", strings.Join(r.InsufficientStorageNodes, ", "))
+		}
+		if len(r.FailedNodes) > 0 {
+			fmt.Fprintf(&b, ", failed on %s", strings.Join(r.FailedNodes, ", "))
+		}
+	}
+	fmt.Fprintf(&b, "; %s to pull", resource.NewQuantity(r.TotalPullBytes, resource.BinarySI))
+	if r.EstimatedPullTime != "" {
+		fmt.Fprintf(&b, ", estimated pull time %s at %s/s per node", r.EstimatedPullTime, resource.NewQuantity(r.PullBandwidth, resource.BinarySI))
+	}
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package pinnedimageset
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	fakeimage "github.com/openshift/client-go/image/clientset/versioned/fake"
+	fakemco "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
+	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+func TestSyncPreflightReport(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	pool := workerPool.DeepCopy()
+	pool.Annotations = map[string]string{daemonconsts.PinnedImageMaxPrefetchingNodesAnnotationKey: "1"}
+	imageSet := fakePinnedImageSet("upgrade-set", "image1", map[string]string{"machineconfiguration.openshift.io/role": "worker"})
+	imageSet.Annotations = map[string]string{
+		daemonconsts.PinnedImagePreflightAnnotationKey:          "true",
+		daemonconsts.PinnedImagePreflightBandwidthAnnotationKey: "1Mi",
+	}
+	imageSet.Generation = 3
+
+	nodes := []*corev1.Node{
+		fakePreflightNode("node-a", imageSet.Name, helpers.PinnedImagePreflightNodeReport{Generation: 3, PullBytes: 60 * 1024 * 1024, Fits: true}),
+		fakePreflightNode("node-b", imageSet.Name, helpers.PinnedImagePreflightNodeReport{Generation: 3, PullBytes: 30 * 1024 * 1024, Fits: false}),
+		fakePreflightNode("node-c", imageSet.Name, helpers.PinnedImagePreflightNodeReport{Generation: 2, PullBytes: 30 * 1024 * 1024, Fits: true}),
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	kubeInformers := informers.NewSharedInformerFactory(fakeClient, noResyncPeriodFunc())
+	nodeInformer := kubeInformers.Core().V1().Nodes()
+	fakeMCOClient := fakemco.NewSimpleClientset(pool, imageSet)
+	sharedInformers := mcfginformers.NewSharedInformerFactory(fakeMCOClient, noResyncPeriodFunc())
+	mcpInformer := sharedInformers.Machineconfiguration().V1().MachineConfigPools()
+	require.NoError(mcpInformer.Informer().GetIndexer().Add(pool))
+	for _, node := range nodes {
+		require.NoError(nodeInformer.Informer().GetIndexer().Add(node))
+	}
+
+	c := New(sharedInformers.Machineconfiguration().V1().PinnedImageSets(), mcpInformer, nodeInformer, fakeClient, fakeimage.NewSimpleClientset(), fakeMCOClient)
+
+	require.NoError(c.syncPreflightReport(pool, imageSet))
+	cm, err := fakeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, "pinned-image-preflight-worker-upgrade-set", metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal(imageSet.Name, cm.OwnerReferences[0].Name)
+
+	report := &preflightReport{}
+	require.NoError(json.Unmarshal([]byte(cm.Data[preflightReportKey]), report))
+	require.False(report.Ready)
+	require.Equal([]string{"node-c"}, report.PendingNodes)
+	require.Equal([]string{"node-b"}, report.InsufficientStorageNodes)
+	require.Equal(int64(90*1024*1024), report.TotalPullBytes)
+	require.Equal(int64(1024*1024), report.PullBandwidth)
+	// one node prefetches at a time
+	require.Equal("1m30s", report.EstimatedPullTime)
+	require.Equal("not ready: 2/3 nodes reported, insufficient storage on node-b; 90Mi to pull, estimated pull time 1m30s at 1Mi/s per node", cm.Data[preflightSummaryKey])
+
+	// the report is updated once all nodes report and fit the images
+	nodes[1] = fakePreflightNode("node-b", imageSet.Name, helpers.PinnedImagePreflightNodeReport{Generation: 3, PullBytes: 30 * 1024 * 1024, Fits: true})
+	nodes[2] = fakePreflightNode("node-c", imageSet.Name, helpers.PinnedImagePreflightNodeReport{Generation: 3, Fits: true})
+	for _, node := range nodes[1:] {
+		require.NoError(nodeInformer.Informer().GetIndexer().Update(node))
+	}
+	require.NoError(c.syncPreflightReport(pool, imageSet))
+	cm, err = fakeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal("ready: all 3 nodes can fit the 1 images; 90Mi to pull, estimated pull time 1m30s at 1Mi/s per node", cm.Data[preflightSummaryKey])
+}
+
+func TestSyncPinnedImageSetsSkipsPreflight(t *testing.T) {
+	require := require.New(t)
+
+	pool := workerPool.DeepCopy()
+	pinned := fakePinnedImageSet("pinned-set", "image1", nil)
+	preflight := fakePinnedImageSet("upgrade-set", "image2", nil)
+	preflight.Annotations = map[string]string{daemonconsts.PinnedImagePreflightAnnotationKey: "true"}
+
+	fakeMCOClient := fakemco.NewSimpleClientset(pool)
+	c := &Controller{client: fakeMCOClient}
+	require.NoError(c.syncPinnedImageSets(pool, []*mcfgv1.PinnedImageSet{pinned, preflight}))
+
+	updated, err := fakeMCOClient.MachineconfigurationV1().MachineConfigPools().Get(context.Background(), pool.Name, metav1.GetOptions{})
+	require.NoError(err)
+	require.Equal([]mcfgv1.PinnedImageSetRef{{Name: "pinned-set"}}, updated.Spec.PinnedImageSets)
+}
+
+func TestEstimatePullTime(t *testing.T) {
+	mib := int64(1024 * 1024)
+	tests := []struct {
+		name      string
+		pullBytes []int64
+		maxNodes  int
+		want      time.Duration
+	}{
+		{name: "no nodes", want: 0},
+		{name: "all nodes at once", pullBytes: []int64{10 * mib, 30 * mib, 20 * mib}, want: 30 * time.Second},
+		{name: "one node at a time", pullBytes: []int64{10 * mib, 30 * mib, 20 * mib}, maxNodes: 1, want: time.Minute},
+		{name: "two nodes at a time", pullBytes: []int64{10 * mib, 30 * mib, 20 * mib}, maxNodes: 2, want: 40 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, estimatePullTime(tt.pullBytes, mib, tt.maxNodes))
+		})
+	}
+}
+
+func fakePreflightNode(name, imageSet string, report helpers.PinnedImagePreflightNodeReport) *corev1.Node {
+	data, _ := json.Marshal(map[string]helpers.PinnedImagePreflightNodeReport{imageSet: report})
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{"node-role/worker": ""},
+			Annotations: map[string]string{daemonconsts.PinnedImagePreflightReportAnnotationKey: string(data)},
+		},
+	}
+}
@@ -12,6 +12,8 @@ const (
 	CurrentImageAnnotationKey = "machineconfiguration.openshift.io/currentImage"
 	// DesiredImageAnnotationKey is used to specify the desired OS image pullspec for a machine
 	DesiredImageAnnotationKey = "machineconfiguration.openshift.io/desiredImage"
+	// DesiredImageIsManifestListAnnotationKey is set by the node controller to "true" when the desired image was built for multiple architectures and pushed as a manifest list
+	DesiredImageIsManifestListAnnotationKey = "machineconfiguration.openshift.io/desiredImageIsManifestList"
 
 	// CurrentMachineConfigAnnotationKey is used to fetch current MachineConfig for a machine
 	CurrentMachineConfigAnnotationKey = "machineconfiguration.openshift.io/currentConfig"
@@ -31,6 +33,26 @@ const (
 	DrainerStateDrain = "drain"
 	// DrainerStateUncordon is used for drainer annotation as a value to indicate needing an uncordon
 	DrainerStateUncordon = "uncordon"
+	// DesiredPinnedImagePrefetchAnnotationKey is set by the MCD to request a pinned image prefetch slot from the controller
+	DesiredPinnedImagePrefetchAnnotationKey = "machineconfiguration.openshift.io/desiredPinnedImagePrefetch"
+	// GrantedPinnedImagePrefetchAnnotationKey is set by the controller to the last pinned image prefetch request granted
+	GrantedPinnedImagePrefetchAnnotationKey = "machineconfiguration.openshift.io/grantedPinnedImagePrefetch"
+	// PinnedImagePrefetchRequestPrefix prefixes the desired prefetch annotation value while a node needs a prefetch slot
+	PinnedImagePrefetchRequestPrefix = "prefetch-"
+	// PinnedImagePrefetchStateDone is used for the desired prefetch annotation value to release the prefetch slot
+	PinnedImagePrefetchStateDone = "done"
+	// PinnedImageMaxConcurrentPullsAnnotationKey is set on a pool to limit the concurrent pinned image pulls per node
+	PinnedImageMaxConcurrentPullsAnnotationKey = "machineconfiguration.openshift.io/pinned-image-max-concurrent-pulls"
+	// PinnedImageMaxPrefetchingNodesAnnotationKey is set on a pool to limit the nodes prefetching pinned images at once
+	PinnedImageMaxPrefetchingNodesAnnotationKey = "machineconfiguration.openshift.io/pinned-image-max-prefetching-nodes"
+	// PinnedImageMaxPullBandwidthAnnotationKey is set on a pool to cap the pinned image pull bandwidth per node in bytes per second
+	PinnedImageMaxPullBandwidthAnnotationKey = "machineconfiguration.openshift.io/pinned-image-max-pull-bandwidth"
+	// PinnedImagePreflightAnnotationKey is set to "true" on a PinnedImageSet to report whether the nodes can fit its images without pinning them
+	PinnedImagePreflightAnnotationKey = "machineconfiguration.openshift.io/pinned-image-preflight"
+	// PinnedImagePreflightBandwidthAnnotationKey is set on a preflight PinnedImageSet to the expected pull bandwidth per node in bytes per second
+	PinnedImagePreflightBandwidthAnnotationKey = "machineconfiguration.openshift.io/pinned-image-preflight-bandwidth"
+	// PinnedImagePreflightReportAnnotationKey is set by the MCD to the preflight reports of the node for the preflight PinnedImageSets
+	PinnedImagePreflightReportAnnotationKey = "machineconfiguration.openshift.io/pinnedImagePreflightReport"
 	// ClusterControlPlaneTopologyAnnotationKey is set by the node controller by reading value from
 	// controllerConfig. MCD uses the annotation value to decide drain action on the node.
 	ClusterControlPlaneTopologyAnnotationKey = "machineconfiguration.openshift.io/controlPlaneTopology"
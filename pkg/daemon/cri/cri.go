@@ -32,14 +32,16 @@ func NewClient(ctx context.Context, target string) (*Client, error) {
 		return nil, err
 	}
 	return &Client{
-		conn:  conn,
-		image: runtimeapi.NewImageServiceClient(conn),
+		conn:    conn,
+		image:   runtimeapi.NewImageServiceClient(conn),
+		runtime: runtimeapi.NewRuntimeServiceClient(conn),
 	}, nil
 }
 
 type Client struct {
-	conn  *grpc.ClientConn
-	image runtimeapi.ImageServiceClient
+	conn    *grpc.ClientConn
+	image   runtimeapi.ImageServiceClient
+	runtime runtimeapi.RuntimeServiceClient
 }
 
 // PullImage pulls the image from the container runtime. The auth parameter can
@@ -83,6 +85,18 @@ func (c *Client) ImageStatus(ctx context.Context, image string) (bool, error) {
 	return false, nil
 }
 
+// GetImage returns the image from the container runtime or nil if the image
+// does not exist.
+func (c *Client) GetImage(ctx context.Context, image string) (*runtimeapi.Image, error) {
+	resp, err := c.image.ImageStatus(ctx, &runtimeapi.ImageStatusRequest{
+		Image: &runtimeapi.ImageSpec{Image: image},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image status for %q: %w", image, err)
+	}
+	return resp.Image, nil
+}
+
 // RemoveImage removes the image from the container runtime.
 func (c *Client) RemoveImage(ctx context.Context, image string) error {
 	_, err := c.image.RemoveImage(ctx, &runtimeapi.RemoveImageRequest{
@@ -108,6 +122,21 @@ func (c *Client) ListImages(ctx context.Context, filter string) ([]*runtimeapi.I
 	return resp.Images, nil
 }
 
+// ListRunningContainers returns the containers in the running state.
+func (c *Client) ListRunningContainers(ctx context.Context) ([]*runtimeapi.Container, error) {
+	resp, err := c.runtime.ListContainers(ctx, &runtimeapi.ListContainersRequest{
+		Filter: &runtimeapi.ContainerFilter{
+			State: &runtimeapi.ContainerStateValue{
+				State: runtimeapi.ContainerState_CONTAINER_RUNNING,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Containers, nil
+}
+
 // ImageFsInfo returns information about the filesystem that is used to store images.
 func (c *Client) ImageFsInfo(ctx context.Context) (*runtimeapi.ImageFsInfoResponse, error) {
 	return c.image.ImageFsInfo(ctx, &runtimeapi.ImageFsInfoRequest{})
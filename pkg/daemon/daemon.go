@@ -266,14 +266,19 @@ var (
 // pods when `GracefulNodeShutdown` feature gate is enabled.
 // kubelet uses systemd inhibitor locks to delay node shutdown to terminate pods.
 // https://kubernetes.io/docs/concepts/architecture/nodes/#graceful-node-shutdown
-func rebootCommand(rationale string, workaroundOCPBUGS51150 bool) *exec.Cmd {
+// For a kexec reboot, the new kernel must already have been loaded.
+func rebootCommand(rationale, rebootType string, workaroundOCPBUGS51150 bool) *exec.Cmd {
 	systemdRunArgs := []string{"--unit", "machine-config-daemon-reboot",
 		"--description", fmt.Sprintf("machine-config-daemon: %s", rationale)}
 	// we need this until we have https://github.com/ostreedev/ostree/pull/3389
 	if workaroundOCPBUGS51150 {
 		systemdRunArgs = append(systemdRunArgs, "-p", "Requires=ostree-finalize-staged.service", "-p", "After=ostree-finalize-staged.service")
 	}
-	systemdRunArgs = append(systemdRunArgs, "/bin/sh", "-c", "systemctl reboot")
+	systemctlVerb := "reboot"
+	if rebootType == rebootTypeKexec {
+		systemctlVerb = "kexec"
+	}
+	systemdRunArgs = append(systemdRunArgs, "/bin/sh", "-c", "systemctl "+systemctlVerb)
 	return exec.Command("systemd-run", systemdRunArgs...)
 }
 
@@ -757,6 +762,7 @@ func (dn *Daemon) syncNode(key string) error {
 
 	if node.Annotations[constants.MachineConfigDaemonPostConfigAction] == constants.MachineConfigDaemonStateRebooting {
 		klog.Info("Detected Rebooting Annotation, applying MCN.")
+		reportLastRebootType()
 		err := upgrademonitor.GenerateAndApplyMachineConfigNodes(
 			&upgrademonitor.Condition{State: mcfgv1.MachineConfigNodeUpdateRebooted, Reason: string(mcfgv1.MachineConfigNodeUpdateRebooted), Message: "Node has rebooted"},
 			nil,
@@ -1243,7 +1249,7 @@ func (dn *Daemon) RunFirstbootCompleteMachineconfig(machineConfigFile string) er
 		if err := dn.InplaceUpdateViaNewContainer(mc.Spec.OSImageURL); err != nil {
 			return err
 		}
-		rebootCmd := rebootCommand("extra reboot for in-place update", dn.os.IsCoreOSVariant())
+		rebootCmd := rebootCommand("extra reboot for in-place update", rebootTypeFull, dn.os.IsCoreOSVariant())
 		if err := rebootCmd.Run(); err != nil {
 			logSystem("failed to run reboot: %v", err)
 			return err
@@ -2399,6 +2405,10 @@ func (dn *Daemon) updateConfigAndState(state *stateAndConfigs) (bool, bool, erro
 		// Log state after node has been successfully marked as Done
 		klog.Infof("state: %s", state.state)
 
+		// The node is back and schedulable, so let the next node reboot. The
+		// update already succeeded, so a failure here must not fail it.
+		dn.releaseRebootLockWithRetry()
+
 		// If we're degraded here, it means we got an error likely on startup and we retried.
 		// If that's the case, clear it out.
 		if state.state == constants.MachineConfigDaemonStateDegraded {
@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// rebootTypeFull is a regular reboot through the firmware.
+	rebootTypeFull = "full"
+	// rebootTypeKexec boots straight into the new kernel, skipping firmware
+	// initialization (POST).
+	rebootTypeKexec = "kexec"
+
+	// kexecOptInFilePath opts a node into kexec reboots when it exists. It is
+	// meant to be written by a MachineConfig so it can be enabled per pool.
+	kexecOptInFilePath = "/etc/machine-config-daemon/reboot-kexec"
+
+	// lastRebootTypePath records the type of the last reboot initiated by the
+	// MCD, so it can be reported once the node is back up. It lives in /var
+	// since the /etc merge into the new deployment has already happened by
+	// the time the reboot type is known.
+	lastRebootTypePath = "/var/lib/machine-config-daemon/last-reboot-type"
+
+	// kexecLoadDisabledPath is set to 1 when loading new kernels is disabled
+	// until the next reboot.
+	kexecLoadDisabledPath = "/proc/sys/kernel/kexec_load_disabled"
+	// lockdownPath reports the active kernel lockdown mode in brackets, e.g.
+	// "none [integrity] confidentiality".
+	lockdownPath = "/sys/kernel/security/lockdown"
+	// secureBootEFIVarPath is the UEFI SecureBoot variable; the fifth byte
+	// is 1 when Secure Boot is enabled.
+	secureBootEFIVarPath = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+)
+
+// blsEntry is the subset of a Boot Loader Specification entry needed to
+// kexec into it.
+type blsEntry struct {
+	version int
+	linux   string
+	initrd  string
+	options string
+}
+
+// parseBLSEntry parses the contents of a BLS type 1 entry, as written by
+// ostree into /boot/loader/entries.
+func parseBLSEntry(content []byte) (*blsEntry, error) {
+	entry := &blsEntry{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "version":
+			version, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid BLS version %q: %w", value, err)
+			}
+			entry.version = version
+		case "linux":
+			entry.linux = value
+		case "initrd":
+			entry.initrd = value
+		case "options":
+			entry.options = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if entry.linux == "" {
+		return nil, fmt.Errorf("BLS entry has no linux line")
+	}
+
+	return entry, nil
+}
+
+// kexecLoader loads the kernel of the default boot entry so that the next
+// reboot can be performed with kexec.
+type kexecLoader struct {
+	cmdRunner CommandRunner
+
+	// Paths, overridable for testing.
+	bootDir              string
+	kexecLoadDisabled    string
+	lockdown             string
+	secureBootEFIVarPath string
+}
+
+func newKexecLoader(cmdRunner CommandRunner) *kexecLoader {
+	return &kexecLoader{
+		cmdRunner:            cmdRunner,
+		bootDir:              "/boot",
+		kexecLoadDisabled:    kexecLoadDisabledPath,
+		lockdown:             lockdownPath,
+		secureBootEFIVarPath: secureBootEFIVarPath,
+	}
+}
+
+// requiresSignedKernel determines whether the running kernel only accepts
+// signed kernels for kexec. This is the case whenever Secure Boot or kernel
+// lockdown is active, and means we have to use kexec_file_load(2), which
+// verifies the kernel signature, instead of kexec_load(2).
+func (k *kexecLoader) requiresSignedKernel() bool {
+	if lockdown, err := os.ReadFile(k.lockdown); err == nil && !strings.Contains(string(lockdown), "[none]") {
+		return true
+	}
+
+	sb, err := os.ReadFile(k.secureBootEFIVarPath)
+	// The first four bytes are the variable attributes.
+	return err == nil && len(sb) >= 5 && sb[4] == 1
+}
+
+// checkAllowed returns an error if kexec cannot be used on this node.
+func (k *kexecLoader) checkAllowed() error {
+	disabled, err := os.ReadFile(k.kexecLoadDisabled)
+	if err != nil {
+		return fmt.Errorf("could not determine whether kexec is enabled: %w", err)
+	}
+	if strings.TrimSpace(string(disabled)) == "1" {
+		return fmt.Errorf("kexec is disabled by %s", k.kexecLoadDisabled)
+	}
+	return nil
+}
+
+// defaultEntry returns the BLS entry the bootloader would boot by default.
+// ostree gives the default deployment the highest version.
+func (k *kexecLoader) defaultEntry() (*blsEntry, error) {
+	entriesDir := filepath.Join(k.bootDir, "loader", "entries")
+	paths, err := filepath.Glob(filepath.Join(entriesDir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultEntry *blsEntry
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := parseBLSEntry(content)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+		if defaultEntry == nil || entry.version > defaultEntry.version {
+			defaultEntry = entry
+		}
+	}
+
+	if defaultEntry == nil {
+		return nil, fmt.Errorf("no boot entries found in %s", entriesDir)
+	}
+
+	return defaultEntry, nil
+}
+
+// load finalizes the staged ostree deployment so that it becomes the default
+// boot entry, then loads its kernel with kexec. If this fails, the node is
+// still able to perform a full reboot into the new deployment.
+func (k *kexecLoader) load() error {
+	if err := k.checkAllowed(); err != nil {
+		return err
+	}
+
+	// The staged deployment is normally only written out as a boot entry by
+	// ostree-finalize-staged.service during shutdown, which is too late for
+	// us to load its kernel.
+	if _, err := k.cmdRunner.RunGetOut("ostree", "admin", "finalize-staged"); err != nil {
+		return fmt.Errorf("could not finalize staged deployment: %w", err)
+	}
+
+	entry, err := k.defaultEntry()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--load"}
+	if k.requiresSignedKernel() {
+		args = append(args, "--kexec-file-syscall")
+	}
+	args = append(args, filepath.Join(k.bootDir, entry.linux))
+	if entry.initrd != "" {
+		args = append(args, "--initrd="+filepath.Join(k.bootDir, entry.initrd))
+	}
+	args = append(args, "--append="+entry.options)
+
+	if _, err := k.cmdRunner.RunGetOut("kexec", args...); err != nil {
+		return fmt.Errorf("could not load kernel %s: %w", entry.linux, err)
+	}
+
+	return nil
+}
+
+// prepareReboot determines which kind of reboot to perform and records it
+// on disk. Nodes which have opted into kexec reboots get one if the new
+// kernel could be loaded; everything else falls back to a full reboot.
+func (dn *Daemon) prepareReboot() string {
+	rebootType := dn.selectRebootType()
+	if err := writeFileAtomicallyWithDefaults(lastRebootTypePath, []byte(rebootType)); err != nil {
+		klog.Warningf("Could not record reboot type: %v", err)
+	}
+	return rebootType
+}
+
+func (dn *Daemon) selectRebootType() string {
+	if !dn.os.IsCoreOSVariant() {
+		return rebootTypeFull
+	}
+
+	if _, err := os.Stat(kexecOptInFilePath); err != nil {
+		return rebootTypeFull
+	}
+
+	if err := newKexecLoader(dn.cmdRunner).load(); err != nil {
+		logSystem("Unable to use kexec, falling back to a full reboot: %v", err)
+		dn.maybeEventf(corev1.EventTypeWarning, "KexecFallback", "Unable to use kexec, falling back to a full reboot: %v", err)
+		return rebootTypeFull
+	}
+
+	klog.Info("Loaded new kernel, node will reboot using kexec")
+	return rebootTypeKexec
+}
+
+// reportLastRebootType exposes the type of the reboot the node just came back
+// from through the mcd_reboot_type metric.
+func reportLastRebootType() {
+	rebootType, err := os.ReadFile(lastRebootTypePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("Could not read last reboot type: %v", err)
+		}
+		return
+	}
+	UpdateStateMetric(mcdRebootType, strings.TrimSpace(string(rebootType)))
+}
@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBLSEntryOld = `title Red Hat Enterprise Linux CoreOS 9.6 (ostree:1)
+version 1
+options root=UUID=abcd rw ostree=/ostree/boot.0/rhcos/old/0
+linux /ostree/rhcos-old/vmlinuz-5.14.0-570.el9.x86_64
+initrd /ostree/rhcos-old/initramfs-5.14.0-570.el9.x86_64.img
+`
+	testBLSEntryNew = `title Red Hat Enterprise Linux CoreOS 9.6 (ostree:0)
+version 2
+options root=UUID=abcd rw ostree=/ostree/boot.0/rhcos/new/0
+linux /ostree/rhcos-new/vmlinuz-5.14.0-580.el9.x86_64
+initrd /ostree/rhcos-new/initramfs-5.14.0-580.el9.x86_64.img
+`
+)
+
+func TestParseBLSEntry(t *testing.T) {
+	entry, err := parseBLSEntry([]byte(testBLSEntryNew))
+	require.NoError(t, err)
+	assert.Equal(t, &blsEntry{
+		version: 2,
+		linux:   "/ostree/rhcos-new/vmlinuz-5.14.0-580.el9.x86_64",
+		initrd:  "/ostree/rhcos-new/initramfs-5.14.0-580.el9.x86_64.img",
+		options: "root=UUID=abcd rw ostree=/ostree/boot.0/rhcos/new/0",
+	}, entry)
+
+	_, err = parseBLSEntry([]byte("title nothing to boot\n"))
+	assert.Error(t, err)
+}
+
+func TestKexecLoader(t *testing.T) {
+	newLoader := func(t *testing.T, kexecDisabled, lockdown string, outputs map[string][]byte, errs map[string]error) *kexecLoader {
+		t.Helper()
+
+		tmpDir := t.TempDir()
+		bootDir := filepath.Join(tmpDir, "boot")
+		entriesDir := filepath.Join(bootDir, "loader", "entries")
+		require.NoError(t, os.MkdirAll(entriesDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(entriesDir, "ostree-1-rhcos.conf"), []byte(testBLSEntryOld), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(entriesDir, "ostree-2-rhcos.conf"), []byte(testBLSEntryNew), 0o644))
+
+		kexecDisabledPath := filepath.Join(tmpDir, "kexec_load_disabled")
+		require.NoError(t, os.WriteFile(kexecDisabledPath, []byte(kexecDisabled), 0o644))
+
+		lockdownPath := filepath.Join(tmpDir, "lockdown")
+		require.NoError(t, os.WriteFile(lockdownPath, []byte(lockdown), 0o644))
+
+		loader := newKexecLoader(&MockCommandRunner{outputs: outputs, errors: errs})
+		loader.bootDir = bootDir
+		loader.kexecLoadDisabled = kexecDisabledPath
+		loader.lockdown = lockdownPath
+		loader.secureBootEFIVarPath = filepath.Join(tmpDir, "SecureBoot")
+
+		return loader
+	}
+
+	kexecCmd := func(bootDir string, extraArgs ...string) string {
+		cmd := "kexec --load "
+		for _, arg := range extraArgs {
+			cmd += arg + " "
+		}
+		return cmd + fmt.Sprintf("%s/ostree/rhcos-new/vmlinuz-5.14.0-580.el9.x86_64 --initrd=%s/ostree/rhcos-new/initramfs-5.14.0-580.el9.x86_64.img --append=root=UUID=abcd rw ostree=/ostree/boot.0/rhcos/new/0", bootDir, bootDir)
+	}
+
+	finalizeCmd := "ostree admin finalize-staged"
+
+	t.Run("Loads default entry", func(t *testing.T) {
+		loader := newLoader(t, "0", "[none] integrity confidentiality", map[string][]byte{finalizeCmd: nil}, nil)
+		loader.cmdRunner.(*MockCommandRunner).outputs[kexecCmd(loader.bootDir)] = nil
+		assert.NoError(t, loader.load())
+	})
+
+	t.Run("Uses kexec_file_load under lockdown", func(t *testing.T) {
+		loader := newLoader(t, "0", "none [integrity] confidentiality", map[string][]byte{finalizeCmd: nil}, nil)
+		loader.cmdRunner.(*MockCommandRunner).outputs[kexecCmd(loader.bootDir, "--kexec-file-syscall")] = nil
+		assert.NoError(t, loader.load())
+	})
+
+	t.Run("Uses kexec_file_load with Secure Boot", func(t *testing.T) {
+		loader := newLoader(t, "0", "[none] integrity confidentiality", map[string][]byte{finalizeCmd: nil}, nil)
+		require.NoError(t, os.WriteFile(loader.secureBootEFIVarPath, []byte{0x06, 0x00, 0x00, 0x00, 0x01}, 0o644))
+		loader.cmdRunner.(*MockCommandRunner).outputs[kexecCmd(loader.bootDir, "--kexec-file-syscall")] = nil
+		assert.NoError(t, loader.load())
+	})
+
+	t.Run("Kexec disabled", func(t *testing.T) {
+		loader := newLoader(t, "1", "[none]", map[string][]byte{finalizeCmd: nil}, nil)
+		assert.ErrorContains(t, loader.load(), "kexec is disabled")
+	})
+
+	t.Run("Kernel fails signature verification", func(t *testing.T) {
+		loader := newLoader(t, "0", "none [integrity] confidentiality", map[string][]byte{finalizeCmd: nil}, map[string]error{})
+		loader.cmdRunner.(*MockCommandRunner).errors[kexecCmd(loader.bootDir, "--kexec-file-syscall")] = fmt.Errorf("Key was rejected by service")
+		assert.ErrorContains(t, loader.load(), "could not load kernel")
+	})
+
+	t.Run("Finalization fails", func(t *testing.T) {
+		loader := newLoader(t, "0", "[none]", nil, map[string]error{finalizeCmd: fmt.Errorf("no staged deployment")})
+		assert.ErrorContains(t, loader.load(), "could not finalize staged deployment")
+	})
+}
+
+func TestRebootCommand(t *testing.T) {
+	full := rebootCommand("test", rebootTypeFull, false)
+	assert.Equal(t, "systemctl reboot", full.Args[len(full.Args)-1])
+
+	kexec := rebootCommand("test", rebootTypeKexec, true)
+	assert.Equal(t, "systemctl kexec", kexec.Args[len(kexec.Args)-1])
+	assert.Contains(t, kexec.Args, "Requires=ostree-finalize-staged.service")
+}
@@ -44,6 +44,13 @@ var (
 			Help: "Total number of reboots that failed.",
 		})
 
+	// mcdRebootType records whether the node came up from a full or kexec reboot
+	mcdRebootType = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcd_reboot_type",
+			Help: "type of the last reboot performed by the MCD, either full or kexec",
+		}, []string{"type"})
+
 	// mcdUpdateState logs completed update or error
 	mcdUpdateState = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -84,6 +91,7 @@ func RegisterMCDMetrics() error {
 		mcdState,
 		kubeletHealthState,
 		mcdRebootErr,
+		mcdRebootType,
 		mcdUpdateState,
 		mcdConfigDrift,
 		unsupportedPackages,
@@ -0,0 +1,301 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+const (
+	// maxPrefetchWorkers is the upper bound of concurrent pulls per node.
+	maxPrefetchWorkers = 10
+	// prefetchProgressInterval is how often prefetch progress is reported.
+	prefetchProgressInterval = 30 * time.Second
+	// prefetchSlotRequeueDelay is how long to wait before checking again for
+	// a prefetch slot granted by the controller.
+	prefetchSlotRequeueDelay = 30 * time.Second
+)
+
+// prefetchLimits are the pool limits applied to the prefetch of pinned images.
+type prefetchLimits struct {
+	// maximum number of concurrent pulls on the node
+	maxConcurrentPulls int
+	// maximum number of nodes of the pool prefetching at once, 0 if unlimited
+	maxPrefetchingNodes int
+	// maximum pull bandwidth in bytes per second, 0 if unlimited
+	maxPullBandwidth int64
+}
+
+// getPrefetchLimits returns the prefetch limits of the pool. Invalid values
+// are ignored and the defaults are used instead.
+func getPrefetchLimits(pool *mcfgv1.MachineConfigPool, defaultConcurrentPulls int) prefetchLimits {
+	limits := prefetchLimits{maxConcurrentPulls: defaultConcurrentPulls}
+
+	if value, ok := pool.Annotations[constants.PinnedImageMaxConcurrentPullsAnnotationKey]; ok {
+		pulls, err := strconv.Atoi(value)
+		if err != nil || pulls < 1 {
+			klog.Warningf("Ignoring invalid %s %q on MachineConfigPool %s", constants.PinnedImageMaxConcurrentPullsAnnotationKey, value, pool.Name)
+		} else {
+			limits.maxConcurrentPulls = min(pulls, maxPrefetchWorkers)
+		}
+	}
+
+	if value, ok := pool.Annotations[constants.PinnedImageMaxPrefetchingNodesAnnotationKey]; ok {
+		nodes, err := strconv.Atoi(value)
+		if err != nil || nodes < 1 {
+			klog.Warningf("Ignoring invalid %s %q on MachineConfigPool %s", constants.PinnedImageMaxPrefetchingNodesAnnotationKey, value, pool.Name)
+		} else {
+			limits.maxPrefetchingNodes = nodes
+		}
+	}
+
+	if value, ok := pool.Annotations[constants.PinnedImageMaxPullBandwidthAnnotationKey]; ok {
+		bandwidth, err := resource.ParseQuantity(value)
+		if err != nil || bandwidth.Value() < 1 {
+			klog.Warningf("Ignoring invalid %s %q on MachineConfigPool %s", constants.PinnedImageMaxPullBandwidthAnnotationKey, value, pool.Name)
+		} else {
+			limits.maxPullBandwidth = bandwidth.Value()
+		}
+	}
+
+	return limits
+}
+
+// prefetchOptions are shared by the prefetch tasks of a sync.
+type prefetchOptions struct {
+	// pullSlots bounds the number of concurrent pulls
+	pullSlots chan struct{}
+	// pacer caps the pull bandwidth, nil if unlimited
+	pacer *bandwidthPacer
+	// progress tracks the prefetched images
+	progress *prefetchProgress
+}
+
+func newPrefetchOptions(limits prefetchLimits) *prefetchOptions {
+	opts := &prefetchOptions{
+		pullSlots: make(chan struct{}, limits.maxConcurrentPulls),
+		progress:  &prefetchProgress{},
+	}
+	if limits.maxPullBandwidth > 0 {
+		opts.pacer = newBandwidthPacer(limits.maxPullBandwidth)
+	}
+	return opts
+}
+
+// bandwidthPacer spaces pulls so the average pull rate stays below the cap.
+// The CRI does not expose a way to throttle a pull, so each pull reserves a
+// time window proportional to the compressed size of the image and starts
+// once the windows of the previous pulls have elapsed.
+type bandwidthPacer struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	next           time.Time
+	now            func() time.Time
+}
+
+func newBandwidthPacer(bytesPerSecond int64) *bandwidthPacer {
+	return &bandwidthPacer{bytesPerSecond: bytesPerSecond, now: time.Now}
+}
+
+// reserve reserves the window for a pull of size bytes and returns how long
+// to wait before starting it.
+func (b *bandwidthPacer) reserve(size int64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	start := now
+	if b.next.After(now) {
+		start = b.next
+	}
+	b.next = start.Add(time.Duration(float64(size) / float64(b.bytesPerSecond) * float64(time.Second)))
+	return start.Sub(now)
+}
+
+// prefetchProgress counts the images of a sync and how many were prefetched.
+type prefetchProgress struct {
+	total     atomic.Int64
+	completed atomic.Int64
+}
+
+// percent returns the percentage of images prefetched.
+func (p *prefetchProgress) percent() int64 {
+	total := p.total.Load()
+	if total == 0 {
+		return 0
+	}
+	return p.completed.Load() * 100 / total
+}
+
+func (p *prefetchProgress) String() string {
+	return fmt.Sprintf("%d%% (%d/%d images)", p.percent(), p.completed.Load(), p.total.Load())
+}
+
+// reportPrefetchProgress periodically reports the prefetch progress on the
+// MachineConfigNode until the context is done.
+func (p *PinnedImageSetManager) reportPrefetchProgress(ctx context.Context, pool *mcfgv1.MachineConfigPool, progress *prefetchProgress) {
+	ticker := time.NewTicker(prefetchProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			message := fmt.Sprintf("node is prefetching images: %s: %s", p.nodeName, progress)
+			if err := p.updateStatusProgressing([]*mcfgv1.MachineConfigPool{pool}, message); err != nil {
+				klog.Errorf("failed to update status: %v", err)
+			}
+		}
+	}
+}
+
+// syncPrefetchSlot returns true if the node may prefetch images. A slot is
+// only requested from the controller when the pool limits the number of
+// nodes prefetching at once and images are missing on the node. release is
+// true if the slot must be released once the prefetch is over.
+func (p *PinnedImageSetManager) syncPrefetchSlot(ctx context.Context, node *corev1.Node, pools []*mcfgv1.MachineConfigPool, limits prefetchLimits) (granted, release bool, err error) {
+	desired := node.Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey]
+	p.prefetchSlotMu.Lock()
+	requested := strings.HasPrefix(desired, constants.PinnedImagePrefetchRequestPrefix) && desired != p.releasedPrefetchRequest
+	p.prefetchSlotMu.Unlock()
+
+	pending := false
+	if limits.maxPrefetchingNodes > 0 {
+		imageNames, err := p.getPinnedImageNames(pools)
+		if err != nil {
+			return false, false, err
+		}
+		if pending, err = p.hasPendingPrefetch(ctx, imageNames); err != nil {
+			return false, false, err
+		}
+	}
+
+	if !pending {
+		// drop a request left over from a previous sync
+		if requested {
+			if err := p.releasePrefetchSlot(ctx); err != nil {
+				return false, false, err
+			}
+		}
+		return true, false, nil
+	}
+
+	granted, err = p.acquirePrefetchSlot(ctx, node)
+	if err != nil {
+		return false, false, err
+	}
+	return granted, granted, nil
+}
+
+// acquirePrefetchSlot requests a prefetch slot from the controller and returns
+// true once it has been granted.
+func (p *PinnedImageSetManager) acquirePrefetchSlot(ctx context.Context, node *corev1.Node) (bool, error) {
+	p.prefetchSlotMu.Lock()
+	defer p.prefetchSlotMu.Unlock()
+
+	desired := node.Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey]
+	if strings.HasPrefix(desired, constants.PinnedImagePrefetchRequestPrefix) && desired != p.releasedPrefetchRequest {
+		if node.Annotations[constants.GrantedPinnedImagePrefetchAnnotationKey] != desired {
+			return false, nil
+		}
+		p.prefetchRequest = desired
+		return true, nil
+	}
+
+	request := constants.PinnedImagePrefetchRequestPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+	klog.Infof("Requesting pinned image prefetch slot %s", request)
+	return false, p.setNodeAnnotations(ctx, map[string]string{
+		constants.DesiredPinnedImagePrefetchAnnotationKey: request,
+	})
+}
+
+// releasePrefetchSlot releases the prefetch slot of the node, granted or not.
+func (p *PinnedImageSetManager) releasePrefetchSlot(ctx context.Context) error {
+	p.prefetchSlotMu.Lock()
+	defer p.prefetchSlotMu.Unlock()
+
+	if err := p.setNodeAnnotations(ctx, map[string]string{
+		constants.DesiredPinnedImagePrefetchAnnotationKey: constants.PinnedImagePrefetchStateDone,
+	}); err != nil {
+		return err
+	}
+	if p.prefetchRequest != "" {
+		p.releasedPrefetchRequest = p.prefetchRequest
+		p.prefetchRequest = ""
+	}
+	return nil
+}
+
+func (p *PinnedImageSetManager) setNodeAnnotations(ctx context.Context, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return p.patchNode(ctx, patch)
+}
+
+func (p *PinnedImageSetManager) patchNode(ctx context.Context, patch []byte) error {
+	if _, err := p.kubeClient.CoreV1().Nodes().Patch(ctx, p.nodeName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to set annotations on node %s: %w", p.nodeName, err)
+	}
+	return nil
+}
+
+// handlePrefetchSlotGrant enqueues the pools of the node when the controller
+// grants the prefetch slot requested by the node.
+func (p *PinnedImageSetManager) handlePrefetchSlotGrant(oldObj, newObj interface{}) {
+	oldNode := oldObj.(*corev1.Node)
+	newNode := newObj.(*corev1.Node)
+	if newNode.Name != p.nodeName {
+		return
+	}
+
+	granted := newNode.Annotations[constants.GrantedPinnedImagePrefetchAnnotationKey]
+	if granted == oldNode.Annotations[constants.GrantedPinnedImagePrefetchAnnotationKey] ||
+		granted != newNode.Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey] {
+		return
+	}
+
+	pools, _, err := helpers.GetPoolsForNode(p.mcpLister, newNode)
+	if err != nil {
+		klog.Errorf("error finding pools for node %s: %v", newNode.Name, err)
+		return
+	}
+	klog.Infof("Pinned image prefetch slot %s granted", granted)
+	for _, pool := range pools {
+		p.enqueueMachineConfigPool(pool)
+	}
+}
+
+// hasPendingPrefetch returns true if any of the images is missing on the node.
+func (p *PinnedImageSetManager) hasPendingPrefetch(ctx context.Context, imageNames []string) (bool, error) {
+	for _, image := range imageNames {
+		exists, err := p.criClient.ImageStatus(ctx, image)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,238 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+const (
+	// preflightKeyPrefix prefixes the queue keys of preflight PinnedImageSets.
+	preflightKeyPrefix = "preflight/"
+	// preflightRefreshInterval is how often the preflight report of a node is
+	// refreshed as the free space and images of the node change.
+	preflightRefreshInterval = 10 * time.Minute
+)
+
+// enqueuePreflight enqueues the preflight of the PinnedImageSet.
+func (p *PinnedImageSetManager) enqueuePreflight(imageSet *mcfgv1.PinnedImageSet) {
+	p.queue.Add(preflightKeyPrefix + imageSet.Name)
+}
+
+// syncPreflight reports on the node whether the images of a preflight
+// PinnedImageSet fit on the image filesystem without pulling them. The report
+// is removed once the PinnedImageSet is deleted or no longer targets a pool of
+// the node.
+func (p *PinnedImageSetManager) syncPreflight(name string) error {
+	node, err := p.getNodeWithRetry(p.nodeName)
+	if err != nil {
+		return fmt.Errorf("failed to get node %q: %w", p.nodeName, err)
+	}
+
+	imageSet, err := p.imageSetLister.Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get PinnedImageSet %q: %w", name, err)
+	}
+	targeted := false
+	if imageSet != nil && helpers.IsPinnedImagePreflight(imageSet) {
+		if targeted, err = p.isImageSetForNode(imageSet, node); err != nil {
+			return err
+		}
+	}
+	if !targeted {
+		return p.updatePreflightReport(name, nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.prefetchTimeout)
+	defer cancel()
+	report, err := p.getPreflightReport(ctx, imageSet)
+	if err != nil {
+		klog.Errorf("failed to compute preflight report for PinnedImageSet %s: %v", name, err)
+		report.Error = err.Error()
+	}
+
+	// refresh the report as the node changes
+	p.queue.AddAfter(preflightKeyPrefix+name, preflightRefreshInterval)
+
+	return p.updatePreflightReport(name, &report)
+}
+
+// updatePreflightReport sets the preflight report of the PinnedImageSet on the
+// node or removes it if nil.
+func (p *PinnedImageSetManager) updatePreflightReport(name string, report *helpers.PinnedImagePreflightNodeReport) error {
+	// the reports of all the PinnedImageSets share an annotation
+	p.preflightMu.Lock()
+	defer p.preflightMu.Unlock()
+
+	node, err := p.kubeClient.CoreV1().Nodes().Get(context.TODO(), p.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %q: %w", p.nodeName, err)
+	}
+	reports, err := helpers.GetPinnedImagePreflightReports(node)
+	if err != nil {
+		return err
+	}
+
+	current, exists := reports[name]
+	if report == nil {
+		if !exists {
+			return nil
+		}
+		delete(reports, name)
+	} else {
+		if exists && equality.Semantic.DeepEqual(current, *report) {
+			return nil
+		}
+		reports[name] = *report
+	}
+	return p.setPreflightReports(context.TODO(), reports)
+}
+
+// getPreflightReport computes the preflight report of the node for the
+// images of the PinnedImageSet.
+func (p *PinnedImageSetManager) getPreflightReport(ctx context.Context, imageSet *mcfgv1.PinnedImageSet) (helpers.PinnedImagePreflightNodeReport, error) {
+	report := helpers.PinnedImagePreflightNodeReport{Generation: imageSet.Generation}
+
+	available, err := p.getImageFsAvailableBytes(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.AvailableBytes = available
+
+	// layers shared by images are pulled once
+	seenLayers := map[string]struct{}{}
+	for _, image := range imageSet.Spec.PinnedImages {
+		imageName := strings.TrimSpace(string(image.Name))
+		exists, err := p.criClient.ImageStatus(ctx, imageName)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			report.PresentImages++
+			continue
+		}
+		report.MissingImages++
+
+		size, err := p.getPreflightImageSize(ctx, imageName, seenLayers)
+		if err != nil {
+			return report, err
+		}
+		report.PullBytes += size
+	}
+
+	// account for decompression
+	report.RequiredBytes = report.PullBytes * 2
+	report.Fits = report.RequiredBytes < report.AvailableBytes-p.minStorageAvailableBytes.Value()
+	return report, nil
+}
+
+// getPreflightImageSize returns the compressed size of the image layers not
+// seen yet. The layers of each image are cached as images are referenced by
+// digest, so refreshing the report only queries the node again.
+func (p *PinnedImageSetManager) getPreflightImageSize(ctx context.Context, imageName string, seenLayers map[string]struct{}) (int64, error) {
+	cacheKey := preflightKeyPrefix + imageName
+	var layers []ocispec.Descriptor
+	if p.cache != nil {
+		if value, found := p.cache.Get(cacheKey); found {
+			layers, _ = value.([]ocispec.Descriptor)
+		}
+	}
+	if layers == nil {
+		var err error
+		layers, err = getImageLayers(ctx, imageName, p.authFilePath)
+		if err != nil {
+			return 0, err
+		}
+		if p.cache != nil {
+			p.cache.Add(cacheKey, layers)
+		}
+	}
+
+	var size int64
+	for _, layer := range layers {
+		if _, ok := seenLayers[layer.Digest.String()]; ok {
+			continue
+		}
+		seenLayers[layer.Digest.String()] = struct{}{}
+		size += layer.Size
+	}
+	return size, nil
+}
+
+// getImageFsAvailableBytes returns the free space of the filesystem the
+// container runtime stores images on.
+func (p *PinnedImageSetManager) getImageFsAvailableBytes(ctx context.Context) (int64, error) {
+	resp, err := p.criClient.ImageFsInfo(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get image filesystem info: %w", err)
+	}
+	filesystems := resp.GetImageFilesystems()
+	if len(filesystems) == 0 || filesystems[0].GetFsId().GetMountpoint() == "" {
+		return 0, fmt.Errorf("container runtime reported no image filesystem")
+	}
+
+	mountpoint := filesystems[0].GetFsId().GetMountpoint()
+	var stat unix.Statfs_t
+	if err := unix.Statfs(mountpoint, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat image filesystem %s: %w", mountpoint, err)
+	}
+	return int64(stat.Bavail) * stat.Bsize, nil
+}
+
+// isImageSetForNode returns true if the PinnedImageSet targets a pool of the
+// node.
+func (p *PinnedImageSetManager) isImageSetForNode(imageSet *mcfgv1.PinnedImageSet, node *corev1.Node) (bool, error) {
+	pools, _, err := helpers.GetPoolsForNode(p.mcpLister, node)
+	if err != nil {
+		return false, err
+	}
+	for _, pool := range pools {
+		selector, err := metav1.LabelSelectorAsSelector(pool.Spec.MachineConfigSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid label selector: %w", err)
+		}
+		if !selector.Empty() && selector.Matches(labels.Set(imageSet.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *PinnedImageSetManager) setPreflightReports(ctx context.Context, reports map[string]helpers.PinnedImagePreflightNodeReport) error {
+	// a null annotation removes it from the node
+	var value *string
+	if len(reports) > 0 {
+		data, err := json.Marshal(reports)
+		if err != nil {
+			return err
+		}
+		encoded := string(data)
+		value = &encoded
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{
+				constants.PinnedImagePreflightReportAnnotationKey: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return p.patchNode(ctx, patch)
+}
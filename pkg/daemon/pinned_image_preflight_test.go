@@ -0,0 +1,37 @@
+package daemon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPreflightImageSize(t *testing.T) {
+	require := require.New(t)
+
+	image1 := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	image2 := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	sharedLayer := ocispec.Descriptor{Digest: digest.FromString("shared"), Size: 100}
+
+	// the cached layers are used instead of inspecting the images in the registry
+	p := &PinnedImageSetManager{cache: newImageCache(10)}
+	p.cache.Add(preflightKeyPrefix+image1, []ocispec.Descriptor{sharedLayer, {Digest: digest.FromString("image1"), Size: 10}})
+	p.cache.Add(preflightKeyPrefix+image2, []ocispec.Descriptor{sharedLayer, {Digest: digest.FromString("image2"), Size: 20}})
+
+	seenLayers := map[string]struct{}{}
+	size, err := p.getPreflightImageSize(context.TODO(), image1, seenLayers)
+	require.NoError(err)
+	require.Equal(int64(110), size)
+
+	// layers shared with an image already accounted for are not counted again
+	size, err = p.getPreflightImageSize(context.TODO(), image2, seenLayers)
+	require.NoError(err)
+	require.Equal(int64(20), size)
+
+	size, err = p.getPreflightImageSize(context.TODO(), image2, map[string]struct{}{})
+	require.NoError(err)
+	require.Equal(int64(120), size)
+}
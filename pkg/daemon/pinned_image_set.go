@@ -28,8 +28,10 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	coreinformersv1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
 	corev1lister "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
@@ -58,6 +60,10 @@ const (
 
 	crioPinnedImagesDropInFilePath = "/etc/crio/crio.conf.d/50-pinned-images"
 
+	// pendingImageRemovalsFilePath records the unpinned images that could not
+	// be removed yet so that later syncs retry them
+	pendingImageRemovalsFilePath = "/etc/machine-config-daemon/pinned-images-pending-removal.json"
+
 	// backoff configuration
 	maxRetries    = 5
 	retryDuration = 1 * time.Second
@@ -69,6 +75,16 @@ const (
 
 	// mcn looks for conditions with this prefix if seen will degrade the pool
 	degradeMessagePrefix = "Error:"
+
+	// PinnedImageRemovalPolicyAnnotationKey is set on a MachineConfigPool to
+	// control what happens to images that are no longer pinned on its nodes.
+	PinnedImageRemovalPolicyAnnotationKey = "machineconfiguration.openshift.io/pinned-image-removal-policy"
+	// PinnedImageRemovalPolicyUnpin only removes the pin and leaves the images
+	// to the kubelet image garbage collection. This is the default.
+	PinnedImageRemovalPolicyUnpin = "Unpin"
+	// PinnedImageRemovalPolicyRemove removes the unpinned images from the node
+	// unless a running container uses them.
+	PinnedImageRemovalPolicyRemove = "Remove"
 )
 
 var (
@@ -93,6 +109,7 @@ type PinnedImageSetManager struct {
 	mcpSynced cache.InformerSynced
 
 	mcfgClient mcfgclientset.Interface
+	kubeClient kubernetes.Interface
 
 	prefetchCh chan prefetch
 
@@ -123,6 +140,16 @@ type PinnedImageSetManager struct {
 	mu       sync.Mutex
 	cancelFn context.CancelFunc
 
+	// preflightMu protects the preflight reports of the node
+	preflightMu sync.Mutex
+	// prefetchSlotMu protects the pinned image prefetch slot requests
+	prefetchSlotMu sync.Mutex
+	// prefetchRequest is the granted prefetch slot request being used
+	prefetchRequest string
+	// releasedPrefetchRequest is the last prefetch slot request released, the
+	// node lister can still report it as granted for a while
+	releasedPrefetchRequest string
+
 	once         sync.Once
 	bootstrapped bool
 }
@@ -131,6 +158,7 @@ type PinnedImageSetManager struct {
 func NewPinnedImageSetManager(
 	nodeName string,
 	criClient *cri.Client,
+	kubeClient kubernetes.Interface,
 	mcfgClient mcfgclientset.Interface,
 	imageSetInformer mcfginformersv1.PinnedImageSetInformer,
 	nodeInformer coreinformersv1.NodeInformer,
@@ -145,6 +173,7 @@ func NewPinnedImageSetManager(
 	p := &PinnedImageSetManager{
 		nodeName:                 nodeName,
 		mcfgClient:               mcfgClient,
+		kubeClient:               kubeClient,
 		runtimeEndpoint:          runtimeEndpoint,
 		authFilePath:             authFilePath,
 		registryCfgPath:          registryCfgPath,
@@ -154,7 +183,7 @@ func NewPinnedImageSetManager(
 		queue: workqueue.NewTypedRateLimitingQueueWithConfig[string](
 			workqueue.DefaultTypedControllerRateLimiter[string](),
 			workqueue.TypedRateLimitingQueueConfig[string]{Name: "pinned-image-set-manager"}),
-		prefetchCh: make(chan prefetch, defaultPrefetchWorkers*2),
+		prefetchCh: make(chan prefetch, maxPrefetchWorkers*2),
 		criClient:  criClient,
 		backoff: wait.Backoff{
 			Steps:    maxRetries,
@@ -185,8 +214,11 @@ func NewPinnedImageSetManager(
 	})
 
 	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    p.handleNodeEvent,
-		UpdateFunc: func(_, newObj interface{}) { p.handleNodeEvent(newObj) },
+		AddFunc: p.handleNodeEvent,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			p.handleNodeEvent(newObj)
+			p.handlePrefetchSlotGrant(oldObj, newObj)
+		},
 	})
 
 	imageSetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -202,6 +234,10 @@ func NewPinnedImageSetManager(
 }
 
 func (p *PinnedImageSetManager) sync(key string) error {
+	if name, ok := strings.CutPrefix(key, preflightKeyPrefix); ok {
+		klog.V(4).Infof("Syncing preflight of PinnedImageSet %q", name)
+		return p.syncPreflight(name)
+	}
 	klog.V(4).Infof("Syncing MachineConfigPool %q", key)
 	node, err := p.getNodeWithRetry(p.nodeName)
 	if err != nil {
@@ -226,11 +262,52 @@ func (p *PinnedImageSetManager) sync(key string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), p.prefetchTimeout)
 	// cancel any currently running tasks in the worker pool
 	p.resetWorkload(cancel)
-	if err := p.updateStatusProgressing([]*mcfgv1.MachineConfigPool{primaryPool}); err != nil {
+
+	workerCount, err := p.getWorkerCount()
+	if err != nil {
+		return err
+	}
+	limits := getPrefetchLimits(primaryPool, workerCount)
+
+	// wait for the controller to grant a prefetch slot if the pool limits the
+	// number of nodes prefetching at once
+	granted, release, err := p.syncPrefetchSlot(ctx, node, pools, limits)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		message := fmt.Sprintf("node is waiting for a pinned image prefetch slot: %s", node.Name)
+		if err := p.updateStatusProgressing([]*mcfgv1.MachineConfigPool{primaryPool}, message); err != nil {
+			klog.Errorf("failed to update status: %v", err)
+		}
+		p.queue.AddAfter(key, prefetchSlotRequeueDelay)
+		return nil
+	}
+	if release {
+		defer func() {
+			if err := p.releasePrefetchSlot(context.Background()); err != nil {
+				klog.Errorf("failed to release pinned image prefetch slot: %v", err)
+			}
+		}()
+	}
+
+	if err := p.updateStatusProgressing([]*mcfgv1.MachineConfigPool{primaryPool}, fmt.Sprintf("node is prefetching images: %s", node.Name)); err != nil {
 		klog.Errorf("failed to update status: %v", err)
 	}
 
-	if err := p.syncMachineConfigPools(ctx, pools); err != nil {
+	opts := newPrefetchOptions(limits)
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		p.reportPrefetchProgress(progressCtx, primaryPool, opts.progress)
+	}()
+
+	removeUnpinned := getPinnedImageRemovalPolicy(primaryPool) == PinnedImageRemovalPolicyRemove
+	reclaimed, err := p.syncMachineConfigPools(ctx, pools, opts, removeUnpinned)
+	stopProgress()
+	<-progressDone
+	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			ctxErr := fmt.Errorf("%w: %v", errRequeueAfterTimeout, p.prefetchTimeout)
 			if err := p.updateStatusError([]*mcfgv1.MachineConfigPool{primaryPool}, ctxErr); err != nil {
@@ -247,17 +324,82 @@ func (p *PinnedImageSetManager) sync(key string) error {
 		return err
 	}
 
-	return p.updateStatusProgressingComplete([]*mcfgv1.MachineConfigPool{primaryPool}, "All pinned image sets complete")
+	message := "All pinned image sets complete"
+	if reclaimed.images > 0 {
+		message = fmt.Sprintf("%s; removed %d unpinned images, reclaimed %s", message, reclaimed.images, resource.NewQuantity(reclaimed.bytes, resource.BinarySI))
+	}
+	if len(reclaimed.pending) > 0 {
+		message = fmt.Sprintf("%s; %d unpinned images pending removal", message, len(reclaimed.pending))
+	}
+	return p.updateStatusProgressingComplete([]*mcfgv1.MachineConfigPool{primaryPool}, message)
 }
 
-func (p *PinnedImageSetManager) syncMachineConfigPools(ctx context.Context, pools []*mcfgv1.MachineConfigPool) error {
-	images := make([]mcfgv1.PinnedImageRef, 0, 100)
+func (p *PinnedImageSetManager) syncMachineConfigPools(ctx context.Context, pools []*mcfgv1.MachineConfigPool, opts *prefetchOptions, removeUnpinned bool) (reclaimedImages, error) {
 	for _, pool := range pools {
-		if err := p.syncMachineConfigPool(ctx, pool); err != nil {
-			return err
+		if err := p.syncMachineConfigPool(ctx, pool, opts); err != nil {
+			return reclaimedImages{}, err
+		}
+	}
+
+	// collect all unique images from all pools
+	imageNames, err := p.getPinnedImageNames(pools)
+	if err != nil {
+		return reclaimedImages{}, err
+	}
+
+	// verify all images available if not clear the cache and requeue
+	pinnedImageIDs := make(map[string]struct{}, len(imageNames))
+	for _, image := range imageNames {
+		img, err := p.criClient.GetImage(ctx, image)
+		if err != nil {
+			return reclaimedImages{}, err
+		}
+		if img == nil {
+			p.cache.Clear()
+			return reclaimedImages{}, fmt.Errorf("%w: image removed during sync: %s", errFailedToPullImage, image)
+		}
+		pinnedImageIDs[img.Id] = struct{}{}
+	}
+
+	// the images pinned by the previous sync are read before the config is
+	// replaced so the ones that lost their pin can be removed afterwards
+	previousImageNames, err := readCrioPinnedImagesConfigFile(crioPinnedImagesDropInFilePath)
+	if err != nil {
+		return reclaimedImages{}, err
+	}
+
+	// write config and reload crio last to allow a window for kubelet to gc
+	// images in an emergency
+	if err := ensureCrioPinnedImagesConfigFile(crioPinnedImagesDropInFilePath, imageNames); err != nil {
+		klog.Errorf("failed to write crio config file: %v", err)
+		return reclaimedImages{}, err
+	}
+
+	reclaimed := reclaimedImages{}
+	if removeUnpinned {
+		// images that could not be removed by an earlier sync are retried
+		// unless they have been pinned again
+		pendingImageNames, err := readPendingImageRemovals(pendingImageRemovalsFilePath)
+		if err != nil {
+			klog.Errorf("failed to read pending image removals: %v", err)
 		}
+		unpinned := getUnpinnedImageNames(sets.List(sets.New(append(previousImageNames, pendingImageNames...)...)), imageNames)
+		reclaimed = p.removeUnpinnedImages(ctx, unpinned, pinnedImageIDs)
+	}
+
+	// failing to record the pending removals only means they are left to the
+	// kubelet image garbage collection
+	if err := writePendingImageRemovals(pendingImageRemovalsFilePath, reclaimed.pending); err != nil {
+		klog.Errorf("failed to write pending image removals: %v", err)
+	}
+
+	return reclaimed, nil
+}
 
-		// collect all unique images from all pools
+// getPinnedImageNames returns the unique sorted images of the pinned image sets of the pools.
+func (p *PinnedImageSetManager) getPinnedImageNames(pools []*mcfgv1.MachineConfigPool) ([]string, error) {
+	images := make([]mcfgv1.PinnedImageRef, 0, 100)
+	for _, pool := range pools {
 		for _, image := range pool.Spec.PinnedImageSets {
 			imageSet, err := p.imageSetLister.Get(image.Name)
 			if err != nil {
@@ -265,36 +407,158 @@ func (p *PinnedImageSetManager) syncMachineConfigPools(ctx context.Context, pool
 					klog.Warningf("PinnedImageSet %q not found", image.Name)
 					continue
 				}
-				return fmt.Errorf("failed to get PinnedImageSet %q: %w", image.Name, err)
+				return nil, fmt.Errorf("failed to get PinnedImageSet %q: %w", image.Name, err)
 			}
 			images = append(images, imageSet.Spec.PinnedImages...)
 		}
 	}
+	return uniqueSortedImageNames(images), nil
+}
 
-	// verify all images available if not clear the cache and requeue
-	imageNames := uniqueSortedImageNames(images)
-	for _, image := range imageNames {
-		exists, err := p.criClient.ImageStatus(ctx, image)
+// reclaimedImages records the images removed after losing their pin and
+// the ones that were kept and should be retried.
+type reclaimedImages struct {
+	images  int
+	bytes   int64
+	pending []string
+}
+
+// removeUnpinnedImages removes images that are no longer pinned from the
+// container runtime. Images used by a running container or sharing their ID
+// with a pinned image are kept and returned as pending. Failures are not
+// fatal because the pin is already gone and the kubelet image garbage
+// collection can still reclaim the images.
+func (p *PinnedImageSetManager) removeUnpinnedImages(ctx context.Context, imageNames []string, pinnedImageIDs map[string]struct{}) reclaimedImages {
+	reclaimed := reclaimedImages{}
+	if len(imageNames) == 0 {
+		return reclaimed
+	}
+
+	containers, err := p.criClient.ListRunningContainers(ctx)
+	if err != nil {
+		klog.Errorf("failed to list running containers, unpinned images will not be removed: %v", err)
+		reclaimed.pending = imageNames
+		return reclaimed
+	}
+	inUse := make(map[string]struct{}, len(containers))
+	for _, container := range containers {
+		inUse[container.ImageRef] = struct{}{}
+		if container.Image != nil {
+			inUse[container.Image.Image] = struct{}{}
+		}
+	}
+
+	for _, imageName := range imageNames {
+		img, err := p.criClient.GetImage(ctx, imageName)
 		if err != nil {
-			return err
+			klog.Errorf("failed to get unpinned image %s: %v", imageName, err)
+			reclaimed.pending = append(reclaimed.pending, imageName)
+			continue
 		}
-		if !exists {
-			p.cache.Clear()
-			return fmt.Errorf("%w: image removed during sync: %s", errFailedToPullImage, image)
+		if img == nil {
+			continue
+		}
+		if _, ok := pinnedImageIDs[img.Id]; ok {
+			klog.V(4).Infof("Keeping unpinned image %s: shared with a pinned image", imageName)
+			reclaimed.pending = append(reclaimed.pending, imageName)
+			continue
+		}
+		if isImageInUse(img, imageName, inUse) {
+			klog.Infof("Keeping unpinned image %s: used by a running container", imageName)
+			reclaimed.pending = append(reclaimed.pending, imageName)
+			continue
+		}
+		if err := p.criClient.RemoveImage(ctx, imageName); err != nil {
+			klog.Errorf("failed to remove unpinned image %s: %v", imageName, err)
+			reclaimed.pending = append(reclaimed.pending, imageName)
+			continue
 		}
+		klog.Infof("Removed unpinned image %s", imageName)
+		reclaimed.images++
+		reclaimed.bytes += int64(img.Size) //nolint:gosec
 	}
 
-	// write config and reload crio last to allow a window for kubelet to gc
-	// images in an emergency
-	if err := ensureCrioPinnedImagesConfigFile(crioPinnedImagesDropInFilePath, imageNames); err != nil {
-		klog.Errorf("failed to write crio config file: %v", err)
+	return reclaimed
+}
+
+func isImageInUse(img *runtimeapi.Image, imageName string, inUse map[string]struct{}) bool {
+	if _, ok := inUse[img.Id]; ok {
+		return true
+	}
+	if _, ok := inUse[imageName]; ok {
+		return true
+	}
+	for _, digest := range img.RepoDigests {
+		if _, ok := inUse[digest]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getUnpinnedImageNames returns the previously pinned images that are no longer pinned.
+func getUnpinnedImageNames(previous, current []string) []string {
+	pinned := make(map[string]struct{}, len(current))
+	for _, image := range current {
+		pinned[image] = struct{}{}
+	}
+	var unpinned []string
+	for _, image := range previous {
+		if _, ok := pinned[image]; !ok {
+			unpinned = append(unpinned, image)
+		}
+	}
+	return unpinned
+}
+
+// readPendingImageRemovals returns the unpinned images that earlier syncs
+// could not remove.
+func readPendingImageRemovals(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var imageNames []string
+	if err := json.Unmarshal(data, &imageNames); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return imageNames, nil
+}
+
+// writePendingImageRemovals records the unpinned images that could not be
+// removed, deleting the file once there are none left.
+func writePendingImageRemovals(path string, imageNames []string) error {
+	if len(imageNames) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(imageNames)
+	if err != nil {
 		return err
 	}
+	return writeFileAtomicallyWithDefaults(path, data)
+}
 
-	return nil
+// getPinnedImageRemovalPolicy returns the removal policy for images unpinned from the pool.
+func getPinnedImageRemovalPolicy(pool *mcfgv1.MachineConfigPool) string {
+	policy := pool.Annotations[PinnedImageRemovalPolicyAnnotationKey]
+	switch policy {
+	case "", PinnedImageRemovalPolicyUnpin:
+		return PinnedImageRemovalPolicyUnpin
+	case PinnedImageRemovalPolicyRemove:
+		return policy
+	default:
+		klog.Warningf("Ignoring unknown %s %q on MachineConfigPool %s", PinnedImageRemovalPolicyAnnotationKey, policy, pool.Name)
+		return PinnedImageRemovalPolicyUnpin
+	}
 }
 
-func (p *PinnedImageSetManager) syncMachineConfigPool(ctx context.Context, pool *mcfgv1.MachineConfigPool) error {
+func (p *PinnedImageSetManager) syncMachineConfigPool(ctx context.Context, pool *mcfgv1.MachineConfigPool, opts *prefetchOptions) error {
 	if pool.Spec.PinnedImageSets == nil {
 		return nil
 	}
@@ -315,7 +579,7 @@ func (p *PinnedImageSetManager) syncMachineConfigPool(ctx context.Context, pool
 	// images are cached with size information
 	p.cache.ClearDigests()
 
-	return p.prefetchImageSets(ctx, imageSets...)
+	return p.prefetchImageSets(ctx, opts, imageSets...)
 }
 
 func (p *PinnedImageSetManager) checkNodeAllocatableStorage(ctx context.Context, imageSet *mcfgv1.PinnedImageSet) error {
@@ -343,7 +607,7 @@ func (p *PinnedImageSetManager) checkNodeAllocatableStorage(ctx context.Context,
 }
 
 // prefetchImageSets schedules the prefetching of images for the given image sets and waits for completion.
-func (p *PinnedImageSetManager) prefetchImageSets(ctx context.Context, imageSets ...*mcfgv1.PinnedImageSet) error {
+func (p *PinnedImageSetManager) prefetchImageSets(ctx context.Context, opts *prefetchOptions, imageSets ...*mcfgv1.PinnedImageSet) error {
 	registryAuth, err := newRegistryAuth(p.authFilePath, p.registryCfgPath)
 	if err != nil {
 		return err
@@ -365,7 +629,7 @@ func (p *PinnedImageSetManager) prefetchImageSets(ctx context.Context, imageSets
 				continue
 			}
 		}
-		if err := p.scheduleWork(ctx, p.prefetchCh, registryAuth, imageSet.Spec.PinnedImages, monitor); err != nil {
+		if err := p.scheduleWork(ctx, p.prefetchCh, registryAuth, imageSet.Spec.PinnedImages, monitor, opts); err != nil {
 			return err
 		}
 	}
@@ -386,8 +650,9 @@ func (p *PinnedImageSetManager) prefetchImageSets(ctx context.Context, imageSets
 }
 
 // scheduleWork schedules the prefetch work for the images and collects the first error encountered.
-func (p *PinnedImageSetManager) scheduleWork(ctx context.Context, prefetchCh chan prefetch, registryAuth *registryAuth, prefetchImages []mcfgv1.PinnedImageRef, monitor *prefetchMonitor) error {
+func (p *PinnedImageSetManager) scheduleWork(ctx context.Context, prefetchCh chan prefetch, registryAuth *registryAuth, prefetchImages []mcfgv1.PinnedImageRef, monitor *prefetchMonitor, opts *prefetchOptions) error {
 	totalImages := len(prefetchImages)
+	opts.progress.total.Add(int64(totalImages))
 	updateIncrement := totalImages / 4
 	if updateIncrement == 0 {
 		updateIncrement = 1 // Ensure there's at least one update if the image count is less than 4
@@ -410,6 +675,7 @@ func (p *PinnedImageSetManager) scheduleWork(ctx context.Context, prefetchCh cha
 				if ok {
 					if imageInfo.Pulled {
 						scheduledImages++
+						opts.progress.completed.Add(1)
 						continue
 					}
 				}
@@ -424,6 +690,7 @@ func (p *PinnedImageSetManager) scheduleWork(ctx context.Context, prefetchCh cha
 				image:   image,
 				auth:    authConfig,
 				monitor: monitor,
+				opts:    opts,
 			}
 
 			scheduledImages++
@@ -535,7 +802,7 @@ func ensureCrioPinnedImagesConfigFile(path string, imageNames []string) error {
 	return nil
 }
 
-func (p *PinnedImageSetManager) updateStatusProgressing(pools []*mcfgv1.MachineConfigPool) error {
+func (p *PinnedImageSetManager) updateStatusProgressing(pools []*mcfgv1.MachineConfigPool, message string) error {
 	node, err := p.nodeLister.Get(p.nodeName)
 	if err != nil {
 		return fmt.Errorf("failed to get node %q: %w", p.nodeName, err)
@@ -557,7 +824,7 @@ func (p *PinnedImageSetManager) updateStatusProgressing(pools []*mcfgv1.MachineC
 		&upgrademonitor.Condition{
 			State:   mcfgv1.MachineConfigNodePinnedImageSetsProgressing,
 			Reason:  "ImagePrefetch",
-			Message: fmt.Sprintf("node is prefetching images: %s", node.Name),
+			Message: message,
 		},
 		nil,
 		metav1.ConditionTrue,
@@ -719,7 +986,7 @@ func checkNodeReady(node *corev1.Node) error {
 	return nil
 }
 
-// getWorkerCount returns the number of workers to use for prefetching images.
+// getWorkerCount returns the default number of concurrent pulls for prefetching images.
 func (p *PinnedImageSetManager) getWorkerCount() (int, error) {
 	node, err := p.getNodeWithRetry(p.nodeName)
 	if err != nil {
@@ -765,10 +1032,11 @@ func (p *PinnedImageSetManager) prefetchWorker(ctx context.Context) {
 			task.monitor.Done()
 			continue
 		}
-		if err := ensurePullImage(ctx, p.criClient, p.backoff, task.image, task.auth); err != nil {
+		if err := p.prefetchImage(ctx, task); err != nil {
 			task.monitor.Error(err)
 			klog.Warningf("failed to prefetch image %q: %v", task.image, err)
 		}
+		task.opts.progress.completed.Add(1)
 		task.monitor.Done()
 
 		cachedImage, ok := p.cache.Get(strings.TrimSpace(task.image))
@@ -793,6 +1061,39 @@ func (p *PinnedImageSetManager) prefetchWorker(ctx context.Context) {
 	}
 }
 
+// prefetchImage pulls the image once the bandwidth cap and the concurrent
+// pull limit allow it.
+func (p *PinnedImageSetManager) prefetchImage(ctx context.Context, task prefetch) error {
+	if task.opts.pacer != nil {
+		if delay := task.opts.pacer.reserve(p.getCachedImageSize(task.image)); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	select {
+	case task.opts.pullSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-task.opts.pullSlots }()
+
+	return ensurePullImage(ctx, p.criClient, p.backoff, task.image, task.auth)
+}
+
+// getCachedImageSize returns the compressed size of the image if known.
+func (p *PinnedImageSetManager) getCachedImageSize(image string) int64 {
+	if value, found := p.cache.Get(strings.TrimSpace(image)); found {
+		if imageInfo, ok := value.(imageInfo); ok {
+			return imageInfo.Size
+		}
+	}
+	return 0
+}
+
 func (p *PinnedImageSetManager) Run(workers int, stopCh <-chan struct{}) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
@@ -812,17 +1113,12 @@ func (p *PinnedImageSetManager) Run(workers int, stopCh <-chan struct{}) {
 		return
 	}
 
-	workerCount, err := p.getWorkerCount()
-	if err != nil {
-		klog.Fatalf("failed to get worker count: %v", err)
-		return
-	}
-
 	klog.Infof("Starting PinnedImageSet Manager")
 	defer klog.Infof("Shutting down PinnedImageSet Manager")
 
-	// start image prefetch workers
-	for i := 0; i < workerCount; i++ {
+	// start image prefetch workers, the number of concurrent pulls is limited
+	// per sync
+	for i := 0; i < maxPrefetchWorkers; i++ {
 		go p.prefetchWorker(ctx)
 	}
 
@@ -839,6 +1135,10 @@ func (p *PinnedImageSetManager) addPinnedImageSet(obj interface{}) {
 		p.deletePinnedImageSet(imageSet)
 		return
 	}
+	if helpers.IsPinnedImagePreflight(imageSet) {
+		p.enqueuePreflight(imageSet)
+		return
+	}
 
 	node, err := p.getNodeWithRetry(p.nodeName)
 	if err != nil {
@@ -878,6 +1178,10 @@ func (p *PinnedImageSetManager) deletePinnedImageSet(obj interface{}) {
 			return
 		}
 	}
+	if helpers.IsPinnedImagePreflight(imageSet) {
+		p.enqueuePreflight(imageSet)
+		return
+	}
 
 	node, err := p.getNodeWithRetry(p.nodeName)
 	if err != nil {
@@ -932,6 +1236,13 @@ func (p *PinnedImageSetManager) updatePinnedImageSet(oldObj, newObj interface{})
 	if apierrors.IsNotFound(err) {
 		return
 	}
+	if helpers.IsPinnedImagePreflight(oldImageSet) || helpers.IsPinnedImagePreflight(newImageSet) {
+		// the report is removed if the PinnedImageSet is no longer a preflight
+		p.enqueuePreflight(newImageSet)
+		if helpers.IsPinnedImagePreflight(newImageSet) {
+			return
+		}
+	}
 
 	node, err := p.getNodeWithRetry(p.nodeName)
 	if err != nil {
@@ -1132,6 +1443,25 @@ func (p *PinnedImageSetManager) handleErr(err error, key string) {
 }
 
 func (p *PinnedImageSetManager) getImageSize(ctx context.Context, imageName, authFilePath string) (int64, error) {
+	layers, err := getImageLayers(ctx, imageName, authFilePath)
+	if err != nil {
+		return 0, err
+	}
+
+	var totalSize int64
+	for _, layer := range layers {
+		if p.cache.HasDigest(layer.Digest.String()) {
+			continue
+		}
+		totalSize += layer.Size
+		p.cache.AddDigest(layer.Digest.String())
+	}
+
+	return totalSize, nil
+}
+
+// getImageLayers returns the layers of the image manifest in the registry.
+func getImageLayers(ctx context.Context, imageName, authFilePath string) ([]ocispec.Descriptor, error) {
 	args := []string{
 		"manifest",
 		"--log-level", "error", // suppress warn log output
@@ -1142,28 +1472,19 @@ func (p *PinnedImageSetManager) getImageSize(ctx context.Context, imageName, aut
 
 	output, err := exec.CommandContext(ctx, "podman", args...).CombinedOutput()
 	if err != nil && strings.Contains(err.Error(), "manifest unknown") {
-		return 0, errNotFound
+		return nil, errNotFound
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute podman manifest inspect for %q: %w", imageName, err)
+		return nil, fmt.Errorf("failed to execute podman manifest inspect for %q: %w", imageName, err)
 	}
 
 	var manifest ocispec.Manifest
 	err = json.Unmarshal(output, &manifest)
 	if err != nil {
-		return 0, fmt.Errorf("failed to unmarshal manifest for %q: %w", imageName, err)
+		return nil, fmt.Errorf("failed to unmarshal manifest for %q: %w", imageName, err)
 	}
 
-	var totalSize int64
-	for _, layer := range manifest.Layers {
-		if p.cache.HasDigest(layer.Digest.String()) {
-			continue
-		}
-		totalSize += layer.Size
-		p.cache.AddDigest(layer.Digest.String())
-	}
-
-	return totalSize, nil
+	return manifest.Layers, nil
 }
 
 // ensurePullImage first checks if the image exists locally and then will attempt to pull
@@ -1264,17 +1585,37 @@ func hasConfigFile(path string) (bool, error) {
 	return true, nil
 }
 
-// createCrioConfigFileBytes creates a crio config file with the pinned images.
-func createCrioConfigFileBytes(images []string) ([]byte, error) {
-	type tomlConfig struct {
-		Crio struct {
-			Image struct {
-				PinnedImages []string `toml:"pinned_images,omitempty"`
-			} `toml:"image"`
-		} `toml:"crio"`
+// crioPinnedImagesConfig is the crio drop-in config holding the pinned images.
+type crioPinnedImagesConfig struct {
+	Crio struct {
+		Image struct {
+			PinnedImages []string `toml:"pinned_images,omitempty"`
+		} `toml:"image"`
+	} `toml:"crio"`
+}
+
+// readCrioPinnedImagesConfigFile returns the images pinned by the crio config
+// file or nil if the file does not exist.
+func readCrioPinnedImagesConfigFile(path string) ([]string, error) {
+	cfgBytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRIO config file: %w", err)
 	}
 
-	tomlConf := tomlConfig{}
+	tomlConf := crioPinnedImagesConfig{}
+	if _, err := toml.Decode(string(cfgBytes), &tomlConf); err != nil {
+		return nil, fmt.Errorf("failed to decode CRIO config file: %w", err)
+	}
+
+	return tomlConf.Crio.Image.PinnedImages, nil
+}
+
+// createCrioConfigFileBytes creates a crio config file with the pinned images.
+func createCrioConfigFileBytes(images []string) ([]byte, error) {
+	tomlConf := crioPinnedImagesConfig{}
 	tomlConf.Crio.Image.PinnedImages = images
 
 	var buf bytes.Buffer
@@ -1545,6 +1886,7 @@ type prefetch struct {
 	image   string
 	auth    *runtimeapi.AuthConfig
 	monitor *prefetchMonitor
+	opts    *prefetchOptions
 }
 
 // prefetchMonitor is used to monitor the status of prefetch operations.
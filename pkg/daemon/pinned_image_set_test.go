@@ -11,6 +11,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -29,7 +31,9 @@ import (
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	fakemco "github.com/openshift/client-go/machineconfiguration/clientset/versioned/fake"
 	mcfginformers "github.com/openshift/client-go/machineconfiguration/informers/externalversions"
+	"github.com/openshift/machine-config-operator/pkg/daemon/constants"
 	"github.com/openshift/machine-config-operator/pkg/daemon/cri"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
 )
 
 const (
@@ -384,7 +388,8 @@ func TestPrefetchImageSets(t *testing.T) {
 				p.prefetchWorker(ctx)
 			}()
 
-			err = p.prefetchImageSets(ctx, imageSets...)
+			opts := newPrefetchOptions(prefetchLimits{maxConcurrentPulls: defaultPrefetchWorkers})
+			err = p.prefetchImageSets(ctx, opts, imageSets...)
 			if tt.wantErr != nil {
 				require.ErrorIs(err, tt.wantErr)
 				return
@@ -395,6 +400,7 @@ func TestPrefetchImageSets(t *testing.T) {
 			}
 			require.NoError(err)
 			require.Equal(tt.wantPulledImages, runtime.imagesPulled())
+			require.Equal(opts.progress.total.Load(), opts.progress.completed.Load())
 		})
 	}
 }
@@ -579,6 +585,310 @@ func TestEnsureCrioPinnedImagesConfigFile(t *testing.T) {
 	require.ErrorIs(err, os.ErrPermission) // this error is from atomic writer attempting to write.
 }
 
+func TestRemoveUnpinnedImages(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	unusedImage := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	runningImage := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	exitedImage := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	mirroredImage := "mirror.example.com/ocp-v4.0-art-dev@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	missingImage := "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:5555555555555555555555555555555555555555555555555555555555555555"
+
+	runtime := newFakeRuntime([]string{availableImage, unusedImage, runningImage, exitedImage, mirroredImage}, []string{})
+	for i := range runtime.localImages {
+		runtime.localImages[i].Id = fmt.Sprintf("image-id-%d", i)
+		runtime.localImages[i].Size = 1024 * 1024
+	}
+	// the mirrored image shares its ID with the pinned image
+	runtime.localImages[4].Id = runtime.localImages[0].Id
+	runtime.containers = []*runtimeapi.Container{
+		{Id: "running", ImageRef: runtime.localImages[2].Id, State: runtimeapi.ContainerState_CONTAINER_RUNNING},
+		{Id: "exited", ImageRef: runtime.localImages[3].Id, State: runtimeapi.ContainerState_CONTAINER_EXITED},
+	}
+	listener, err := newTestListener()
+	require.NoError(err)
+	require.NoError(runtime.Start(listener))
+	defer runtime.Stop()
+
+	criClient, err := cri.NewClient(ctx, listener.Addr().String())
+	require.NoError(err)
+	p := &PinnedImageSetManager{criClient: criClient}
+
+	unpinned := getUnpinnedImageNames([]string{availableImage, unusedImage, runningImage, exitedImage, mirroredImage, missingImage}, []string{availableImage})
+	reclaimed := p.removeUnpinnedImages(ctx, unpinned, map[string]struct{}{"image-id-0": {}})
+	require.Equal(reclaimedImages{images: 2, bytes: 2 * 1024 * 1024, pending: []string{runningImage, mirroredImage}}, reclaimed)
+	require.Equal([]string{unusedImage, exitedImage}, runtime.removedImages)
+}
+
+func TestPendingImageRemovals(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "pinned-images-pending-removal.json")
+
+	imageNames, err := readPendingImageRemovals(path)
+	require.NoError(err)
+	require.Empty(imageNames)
+
+	pending := []string{availableImage, "quay.io/openshift-release-dev/ocp-v4.0-art-dev@sha256:3333333333333333333333333333333333333333333333333333333333333333"}
+	require.NoError(writePendingImageRemovals(path, pending))
+	imageNames, err = readPendingImageRemovals(path)
+	require.NoError(err)
+	require.Equal(pending, imageNames)
+
+	// the file is removed once nothing is pending
+	require.NoError(writePendingImageRemovals(path, nil))
+	require.NoFileExists(path)
+	require.NoError(writePendingImageRemovals(path, nil))
+}
+
+func TestGetPinnedImageRemovalPolicy(t *testing.T) {
+	tests := []struct {
+		annotation string
+		want       string
+	}{
+		{annotation: "", want: PinnedImageRemovalPolicyUnpin},
+		{annotation: PinnedImageRemovalPolicyUnpin, want: PinnedImageRemovalPolicyUnpin},
+		{annotation: PinnedImageRemovalPolicyRemove, want: PinnedImageRemovalPolicyRemove},
+		{annotation: "Delete", want: PinnedImageRemovalPolicyUnpin},
+	}
+	for _, tt := range tests {
+		pool := fakeMachineConfigPool("worker", nil)
+		if tt.annotation != "" {
+			pool.Annotations = map[string]string{PinnedImageRemovalPolicyAnnotationKey: tt.annotation}
+		}
+		require.Equal(t, tt.want, getPinnedImageRemovalPolicy(pool))
+	}
+}
+
+func TestReadCrioPinnedImagesConfigFile(t *testing.T) {
+	require := require.New(t)
+	testCfgPath := filepath.Join(t.TempDir(), "50-pinned-images")
+
+	images, err := readCrioPinnedImagesConfigFile(testCfgPath)
+	require.NoError(err)
+	require.Nil(images)
+
+	cfgBytes, err := createCrioConfigFileBytes([]string{availableImage, slowImage})
+	require.NoError(err)
+	require.NoError(os.WriteFile(testCfgPath, cfgBytes, 0644))
+	images, err = readCrioPinnedImagesConfigFile(testCfgPath)
+	require.NoError(err)
+	require.Equal([]string{availableImage, slowImage}, images)
+}
+
+func TestGetPrefetchLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        prefetchLimits
+	}{
+		{
+			name: "defaults",
+			want: prefetchLimits{maxConcurrentPulls: defaultPrefetchWorkers},
+		},
+		{
+			name: "all limits",
+			annotations: map[string]string{
+				constants.PinnedImageMaxConcurrentPullsAnnotationKey:  "2",
+				constants.PinnedImageMaxPrefetchingNodesAnnotationKey: "3",
+				constants.PinnedImageMaxPullBandwidthAnnotationKey:    "10Mi",
+			},
+			want: prefetchLimits{maxConcurrentPulls: 2, maxPrefetchingNodes: 3, maxPullBandwidth: 10 * 1024 * 1024},
+		},
+		{
+			name: "concurrent pulls are capped",
+			annotations: map[string]string{
+				constants.PinnedImageMaxConcurrentPullsAnnotationKey: "100",
+			},
+			want: prefetchLimits{maxConcurrentPulls: maxPrefetchWorkers},
+		},
+		{
+			name: "invalid limits are ignored",
+			annotations: map[string]string{
+				constants.PinnedImageMaxConcurrentPullsAnnotationKey:  "0",
+				constants.PinnedImageMaxPrefetchingNodesAnnotationKey: "many",
+				constants.PinnedImageMaxPullBandwidthAnnotationKey:    "-1M",
+			},
+			want: prefetchLimits{maxConcurrentPulls: defaultPrefetchWorkers},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := fakeMachineConfigPool("worker", nil)
+			pool.Annotations = tt.annotations
+			require.Equal(t, tt.want, getPrefetchLimits(pool, defaultPrefetchWorkers))
+		})
+	}
+}
+
+func TestBandwidthPacer(t *testing.T) {
+	require := require.New(t)
+	now := time.Unix(0, 0)
+	pacer := newBandwidthPacer(1024 * 1024)
+	pacer.now = func() time.Time { return now }
+
+	require.Equal(time.Duration(0), pacer.reserve(2*1024*1024))
+	require.Equal(2*time.Second, pacer.reserve(1024*1024))
+	now = now.Add(time.Second)
+	require.Equal(2*time.Second, pacer.reserve(512*1024))
+
+	// the reservations are over
+	now = now.Add(10 * time.Second)
+	require.Equal(time.Duration(0), pacer.reserve(1024*1024))
+}
+
+func TestSyncPrefetchSlot(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := fakeStableStorageWorkerNode.DeepCopy()
+	kubeClient := fake.NewSimpleClientset(node)
+	mcoClient := fakemco.NewSimpleClientset()
+	imageSetInformer := mcfginformers.NewSharedInformerFactory(mcoClient, noResyncPeriodFunc()).Machineconfiguration().V1().PinnedImageSets()
+	require.NoError(imageSetInformer.Informer().GetIndexer().Add(fakePinnedImageSet("worker-set", slowImage, nil)))
+
+	runtime := newFakeRuntime([]string{availableImage}, []string{})
+	listener, err := newTestListener()
+	require.NoError(err)
+	require.NoError(runtime.Start(listener))
+	defer runtime.Stop()
+	criClient, err := cri.NewClient(ctx, listener.Addr().String())
+	require.NoError(err)
+
+	p := &PinnedImageSetManager{
+		nodeName:       node.Name,
+		criClient:      criClient,
+		kubeClient:     kubeClient,
+		imageSetLister: imageSetInformer.Lister(),
+	}
+	pools := []*mcfgv1.MachineConfigPool{fakeWorkerPoolPinnedImageSets}
+	getNode := func() *corev1.Node {
+		node, err := kubeClient.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+		require.NoError(err)
+		return node
+	}
+
+	// no limit on the number of nodes prefetching
+	granted, release, err := p.syncPrefetchSlot(ctx, node, pools, prefetchLimits{})
+	require.NoError(err)
+	require.True(granted)
+	require.False(release)
+
+	// a slot is requested for the missing image
+	limits := prefetchLimits{maxPrefetchingNodes: 1}
+	granted, _, err = p.syncPrefetchSlot(ctx, node, pools, limits)
+	require.NoError(err)
+	require.False(granted)
+	node = getNode()
+	desired := node.Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey]
+	require.True(strings.HasPrefix(desired, constants.PinnedImagePrefetchRequestPrefix))
+
+	// the request is not repeated while waiting for the grant
+	granted, _, err = p.syncPrefetchSlot(ctx, node, pools, limits)
+	require.NoError(err)
+	require.False(granted)
+	require.Equal(desired, getNode().Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey])
+
+	// the controller grants the slot
+	node.Annotations[constants.GrantedPinnedImagePrefetchAnnotationKey] = desired
+	granted, release, err = p.syncPrefetchSlot(ctx, node, pools, limits)
+	require.NoError(err)
+	require.True(granted)
+	require.True(release)
+
+	// the released grant is not reused from a stale node
+	require.NoError(p.releasePrefetchSlot(ctx))
+	require.Equal(constants.PinnedImagePrefetchStateDone, getNode().Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey])
+	granted, _, err = p.syncPrefetchSlot(ctx, node, pools, limits)
+	require.NoError(err)
+	require.False(granted)
+	require.NotEqual(desired, getNode().Annotations[constants.DesiredPinnedImagePrefetchAnnotationKey])
+}
+
+func TestSyncPreflight(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := fakeStableStorageWorkerNode.DeepCopy()
+	imageSet := fakePinnedImageSet("upgrade-set", availableImage, map[string]string{"machineconfiguration.openshift.io/role": "worker"})
+	imageSet.Spec.PinnedImages = append(imageSet.Spec.PinnedImages, mcfgv1.PinnedImageRef{Name: mcfgv1.ImageDigestFormat(slowImage)})
+	imageSet.Annotations = map[string]string{constants.PinnedImagePreflightAnnotationKey: "true"}
+	imageSet.Generation = 2
+
+	kubeClient := fake.NewSimpleClientset(node)
+	nodeInformer := informers.NewSharedInformerFactory(kubeClient, noResyncPeriodFunc()).Core().V1().Nodes()
+	require.NoError(nodeInformer.Informer().GetIndexer().Add(node))
+	mcoClient := fakemco.NewSimpleClientset()
+	sharedInformers := mcfginformers.NewSharedInformerFactory(mcoClient, noResyncPeriodFunc())
+	imageSetInformer := sharedInformers.Machineconfiguration().V1().PinnedImageSets()
+	mcpInformer := sharedInformers.Machineconfiguration().V1().MachineConfigPools()
+	require.NoError(imageSetInformer.Informer().GetIndexer().Add(imageSet))
+	require.NoError(mcpInformer.Informer().GetIndexer().Add(fakeWorkerPoolNoPinnedImageSets))
+
+	runtime := newFakeRuntime([]string{availableImage}, []string{})
+	runtime.ImageService.SetFakeFilesystemUsage([]*runtimeapi.FilesystemUsage{
+		{FsId: &runtimeapi.FilesystemIdentifier{Mountpoint: t.TempDir()}},
+	})
+	listener, err := newTestListener()
+	require.NoError(err)
+	require.NoError(runtime.Start(listener))
+	defer runtime.Stop()
+	criClient, err := cri.NewClient(ctx, listener.Addr().String())
+	require.NoError(err)
+
+	p := &PinnedImageSetManager{
+		nodeName:                 node.Name,
+		criClient:                criClient,
+		kubeClient:               kubeClient,
+		imageSetLister:           imageSetInformer.Lister(),
+		nodeLister:               nodeInformer.Lister(),
+		mcpLister:                mcpInformer.Lister(),
+		cache:                    newImageCache(10),
+		minStorageAvailableBytes: resource.MustParse("1Mi"),
+		prefetchTimeout:          time.Minute,
+		queue:                    workqueue.NewTypedRateLimitingQueue[string](workqueue.DefaultTypedControllerRateLimiter[string]()),
+		backoff:                  wait.Backoff{Steps: 1, Duration: time.Millisecond},
+	}
+	defer p.queue.ShutDown()
+	// the layers of the missing image are cached
+	p.cache.Add(preflightKeyPrefix+slowImage, []ocispec.Descriptor{{Digest: digest.FromString("slow"), Size: 1024 * 1024}})
+
+	getReports := func() map[string]helpers.PinnedImagePreflightNodeReport {
+		node, err := kubeClient.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		require.NoError(err)
+		reports, err := helpers.GetPinnedImagePreflightReports(node)
+		require.NoError(err)
+		return reports
+	}
+
+	require.NoError(p.sync(preflightKeyPrefix + imageSet.Name))
+	report, ok := getReports()[imageSet.Name]
+	require.True(ok)
+	require.Empty(report.Error)
+	require.Equal(int64(2), report.Generation)
+	require.Equal(1, report.PresentImages)
+	require.Equal(1, report.MissingImages)
+	require.Equal(int64(1024*1024), report.PullBytes)
+	require.Equal(int64(2*1024*1024), report.RequiredBytes)
+	require.Positive(report.AvailableBytes)
+	require.True(report.Fits)
+
+	// the images do not fit once the minimum free storage is accounted for
+	p.minStorageAvailableBytes = resource.MustParse("1Ei")
+	require.NoError(p.sync(preflightKeyPrefix + imageSet.Name))
+	require.False(getReports()[imageSet.Name].Fits)
+
+	// the report is removed once the image set is no longer a preflight
+	pinned := imageSet.DeepCopy()
+	pinned.Annotations = nil
+	require.NoError(imageSetInformer.Informer().GetIndexer().Update(pinned))
+	require.NoError(p.sync(preflightKeyPrefix + imageSet.Name))
+	require.Empty(getReports())
+}
+
 func fakePinnedImageSet(name, image string, labels map[string]string) *mcfgv1.PinnedImageSet {
 	return &mcfgv1.PinnedImageSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -666,10 +976,12 @@ func fakeMachineConfigPool(role string, pinnedImageSets []mcfgv1.PinnedImageSetR
 }
 
 var _ runtimeapi.ImageServiceServer = (*FakeRuntime)(nil)
+var _ runtimeapi.RuntimeServiceServer = (*FakeRuntime)(nil)
 
 // FakeRuntime represents a fake remote container runtime.
 type FakeRuntime struct {
 	runtimeapi.UnimplementedImageServiceServer
+	runtimeapi.UnimplementedRuntimeServiceServer
 	server *grpc.Server
 	// Fake runtime service.
 	ImageService *apitest.FakeImageService
@@ -678,8 +990,12 @@ type FakeRuntime struct {
 	localImages []runtimeapi.Image
 	// images that are available to be pulled.
 	availableImages []runtimeapi.Image
+	// containers running in the fake runtime.
+	containers []*runtimeapi.Container
 	// number of images pulled.
 	pulledImages int
+	// images removed from the fake runtime.
+	removedImages []string
 }
 
 // newFakeRuntime creates a new FakeRuntime.
@@ -706,6 +1022,7 @@ func newFakeRuntime(localImages, availableImages []string) *FakeRuntime {
 	}
 
 	runtimeapi.RegisterImageServiceServer(f.server, f)
+	runtimeapi.RegisterRuntimeServiceServer(f.server, f)
 	return f
 }
 
@@ -760,8 +1077,28 @@ func (r *FakeRuntime) PullImage(ctx context.Context, req *runtimeapi.PullImageRe
 }
 
 // RemoveImage implements v1.ImageServiceServer.
-func (*FakeRuntime) RemoveImage(context.Context, *runtimeapi.RemoveImageRequest) (*runtimeapi.RemoveImageResponse, error) {
-	panic("unimplemented")
+func (r *FakeRuntime) RemoveImage(_ context.Context, req *runtimeapi.RemoveImageRequest) (*runtimeapi.RemoveImageResponse, error) {
+	image := req.Image.Image
+	for i := range r.localImages {
+		if r.localImages[i].Spec.Image == image {
+			r.localImages = append(r.localImages[:i], r.localImages[i+1:]...)
+			r.removedImages = append(r.removedImages, image)
+			break
+		}
+	}
+	return &runtimeapi.RemoveImageResponse{}, nil
+}
+
+// ListContainers implements v1.RuntimeServiceServer.
+func (r *FakeRuntime) ListContainers(_ context.Context, req *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	containers := []*runtimeapi.Container{}
+	for _, container := range r.containers {
+		if req.Filter != nil && req.Filter.State != nil && req.Filter.State.State != container.State {
+			continue
+		}
+		containers = append(containers, container)
+	}
+	return &runtimeapi.ListContainersResponse{Containers: containers}, nil
 }
 
 // Start starts the fake remote runtime.
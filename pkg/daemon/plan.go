@@ -0,0 +1,242 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+)
+
+// PlanInput is everything needed to work out what the MCD would do to move a
+// node from its current config to its desired config.
+type PlanInput struct {
+	CurrentConfig *mcfgv1.MachineConfig
+	DesiredConfig *mcfgv1.MachineConfig
+	// CurrentImage and DesiredImage are the on-cluster layering images, if any.
+	CurrentImage string
+	DesiredImage string
+	// NodeDisruptionPolicies are the cluster's node disruption policies. When
+	// nil, the actions are calculated as they are during firstboot, when the
+	// policies are not available.
+	NodeDisruptionPolicies *opv1.NodeDisruptionPolicyClusterStatus
+	// IrreconcilableOverrides are the overrides from the MachineConfiguration,
+	// if any. As in the MCD, they only apply outside firstboot and with the
+	// IrreconcilableMachineConfig feature gate enabled in FeatureGates.
+	IrreconcilableOverrides *opv1.IrreconcilableValidationOverrides
+	// FeatureGates are the cluster's feature gates. When nil, all feature
+	// gated behavior is disabled.
+	FeatureGates ctrlcommon.FeatureGatesHandler
+	// InstalledPackages are the packages layered onto the booted deployment.
+	// When nil, they are derived from the current config's extensions.
+	InstalledPackages []string
+	// ForceFilePresent is whether the node has the MCD force file, which
+	// forces a reboot regardless of the diff.
+	ForceFilePresent bool
+}
+
+// UpdatePlan describes the update the MCD would perform, without performing
+// it.
+type UpdatePlan struct {
+	CurrentConfig string `json:"currentConfig"`
+	DesiredConfig string `json:"desiredConfig"`
+	CurrentImage  string `json:"currentImage,omitempty"`
+	DesiredImage  string `json:"desiredImage,omitempty"`
+
+	Reconcilable         bool   `json:"reconcilable"`
+	UnreconcilableReason string `json:"unreconcilableReason,omitempty"`
+
+	OSUpdate      bool `json:"osUpdate"`
+	OCL           bool `json:"ocl"`
+	RevertFromOCL bool `json:"revertFromOCL,omitempty"`
+	FIPS          bool `json:"fips"`
+	SSHKeys       bool `json:"sshKeys"`
+
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+	ChangedUnits []string `json:"changedUnits,omitempty"`
+
+	KernelType        string   `json:"kernelType,omitempty"`
+	KernelTypeChanged bool     `json:"kernelTypeChanged"`
+	KernelArguments   []string `json:"kernelArguments,omitempty"`
+	ExtensionsArgs    []string `json:"extensionsArgs,omitempty"`
+
+	ForceFilePresent        bool     `json:"forceFilePresent"`
+	NodeDisruptionPolicies  bool     `json:"nodeDisruptionPolicies"`
+	PostConfigChangeActions []string `json:"postConfigChangeActions,omitempty"`
+	DrainRequired           bool     `json:"drainRequired"`
+	RebootRequired          bool     `json:"rebootRequired"`
+}
+
+// NewUpdatePlan runs the same reconcilability checks and action calculations
+// as Daemon.update() on the given input, but does not change anything.
+func NewUpdatePlan(in PlanInput) (*UpdatePlan, error) {
+	if in.CurrentConfig == nil || in.DesiredConfig == nil {
+		return nil, fmt.Errorf("both current and desired configs are required")
+	}
+
+	oldConfig := canonicalizeEmptyMC(embedOCLImageInMachineConfig(in.CurrentImage, in.CurrentConfig))
+	newConfig := embedOCLImageInMachineConfig(in.DesiredImage, in.DesiredConfig)
+
+	plan := &UpdatePlan{
+		CurrentConfig:          in.CurrentConfig.Name,
+		DesiredConfig:          in.DesiredConfig.Name,
+		CurrentImage:           in.CurrentImage,
+		DesiredImage:           in.DesiredImage,
+		ForceFilePresent:       in.ForceFilePresent,
+		NodeDisruptionPolicies: in.NodeDisruptionPolicies != nil,
+	}
+
+	mcDiff, err := newUpdateMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Node disruption policies are not available during firstboot, and
+	// neither are the overrides.
+	firstBoot := in.NodeDisruptionPolicies == nil
+	overrides := &opv1.IrreconcilableValidationOverrides{}
+	if in.IrreconcilableOverrides != nil && irreconcilableOverridesInEffect(firstBoot, in.FeatureGates) {
+		overrides = in.IrreconcilableOverrides
+	}
+
+	diff, err := reconcilable(oldConfig, newConfig, overrides)
+	if err != nil {
+		plan.UnreconcilableReason = err.Error()
+		return plan, nil
+	}
+	plan.Reconcilable = true
+
+	plan.OSUpdate = diff.osUpdate
+	plan.OCL = mcDiff.oclEnabled
+	plan.RevertFromOCL = mcDiff.revertFromOCL
+	plan.FIPS = diff.fips
+	plan.SSHKeys = diff.passwd
+	plan.KernelTypeChanged = diff.kernelType
+	plan.KernelType = helpers.CanonicalizeKernelType(newConfig.Spec.KernelType)
+
+	oldIgnConfig, err := ctrlcommon.ParseAndConvertConfig(oldConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Ignition config failed: %w", err)
+	}
+	newIgnConfig, err := ctrlcommon.ParseAndConvertConfig(newConfig.Spec.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Ignition config failed: %w", err)
+	}
+
+	plan.ChangedFiles, _, plan.ChangedUnits = calculateChangedFilesAndUnits(&oldIgnConfig, &newIgnConfig)
+
+	if diff.kargs {
+		plan.KernelArguments = generateKargs(oldConfig.Spec.KernelArguments, newConfig.Spec.KernelArguments)
+	}
+
+	if diff.extensions || diff.kernelType {
+		plan.ExtensionsArgs = generateExtensionsArgs(plannedInstalledPackages(in, oldConfig), newConfig)
+	}
+
+	postConfigChange, err := decidePostConfigChange(diff, plan.ChangedFiles, plan.ChangedUnits, in.ForceFilePresent, in.NodeDisruptionPolicies, oldIgnConfig, newIgnConfig)
+	if err != nil {
+		return nil, err
+	}
+	plan.PostConfigChangeActions = postConfigChange.actions
+	for _, action := range postConfigChange.nodeDisruptionActions {
+		plan.PostConfigChangeActions = append(plan.PostConfigChangeActions, nodeDisruptionActionString(action))
+	}
+	plan.DrainRequired = postConfigChange.drain
+
+	plan.RebootRequired = slices.Contains(plan.PostConfigChangeActions, postConfigChangeActionReboot) ||
+		slices.Contains(plan.PostConfigChangeActions, string(opv1.RebootStatusAction))
+
+	return plan, nil
+}
+
+// plannedInstalledPackages returns the packages which are, or are assumed to
+// be, layered onto the booted deployment.
+func plannedInstalledPackages(in PlanInput, oldConfig *mcfgv1.MachineConfig) sets.Set[string] {
+	if in.InstalledPackages != nil {
+		return sets.New(in.InstalledPackages...)
+	}
+
+	installed := sets.New[string]()
+	supportedExtensions := ctrlcommon.SupportedExtensions()
+	for _, ext := range oldConfig.Spec.Extensions {
+		installed.Insert(supportedExtensions[ext]...)
+	}
+	return installed
+}
+
+// nodeDisruptionActionString renders a node disruption action the same way
+// the MCD logs them.
+func nodeDisruptionActionString(action opv1.NodeDisruptionPolicyStatusAction) string {
+	switch action.Type {
+	case opv1.ReloadStatusAction:
+		return fmt.Sprintf("%v - %v", action.Type, action.Reload.ServiceName)
+	case opv1.RestartStatusAction:
+		return fmt.Sprintf("%v - %v", action.Type, action.Restart.ServiceName)
+	default:
+		return string(action.Type)
+	}
+}
+
+// Print writes a human-readable form of the plan.
+func (p *UpdatePlan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Current config: %s\n", p.CurrentConfig)
+	fmt.Fprintf(w, "Desired config: %s\n", p.DesiredConfig)
+	if p.CurrentImage != "" || p.DesiredImage != "" {
+		fmt.Fprintf(w, "Current image: %s\n", p.CurrentImage)
+		fmt.Fprintf(w, "Desired image: %s\n", p.DesiredImage)
+	}
+
+	if !p.Reconcilable {
+		fmt.Fprintf(w, "Reconcilable: no\n  %s\n", p.UnreconcilableReason)
+		return
+	}
+	fmt.Fprintf(w, "Reconcilable: yes\n")
+
+	if p.CurrentConfig == p.DesiredConfig && p.CurrentImage == p.DesiredImage {
+		fmt.Fprintf(w, "Node is already in its desired config\n")
+	}
+
+	fmt.Fprintf(w, "OS update: %t", p.OSUpdate)
+	switch {
+	case p.RevertFromOCL:
+		fmt.Fprintf(w, " (reverting from on-cluster layering)")
+	case p.OCL:
+		fmt.Fprintf(w, " (on-cluster layering)")
+	}
+	fmt.Fprintln(w)
+
+	printList(w, "Changed files", p.ChangedFiles)
+	printList(w, "Changed units", p.ChangedUnits)
+	fmt.Fprintf(w, "SSH keys changed: %t\n", p.SSHKeys)
+	fmt.Fprintf(w, "Kernel type: %s (changed: %t)\n", p.KernelType, p.KernelTypeChanged)
+	printList(w, "Kernel arguments (rpm-ostree kargs)", p.KernelArguments)
+	printList(w, "Extensions (rpm-ostree)", p.ExtensionsArgs)
+
+	if p.ForceFilePresent {
+		fmt.Fprintf(w, "Force file present: yes, the node will reboot regardless of the diff\n")
+	}
+	source := "firstboot defaults"
+	if p.NodeDisruptionPolicies {
+		source = "node disruption policies"
+	}
+	fmt.Fprintf(w, "Post config change actions (from %s): %s\n", source, strings.Join(p.PostConfigChangeActions, ", "))
+	fmt.Fprintf(w, "Drain required: %t\n", p.DrainRequired)
+	fmt.Fprintf(w, "Reboot required: %t\n", p.RebootRequired)
+}
+
+func printList(w io.Writer, title string, items []string) {
+	if len(items) == 0 {
+		fmt.Fprintf(w, "%s: none\n", title)
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, item := range items {
+		fmt.Fprintf(w, "  %s\n", item)
+	}
+}
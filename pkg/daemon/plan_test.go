@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/api/features"
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/test/helpers"
+)
+
+func TestNewUpdatePlan(t *testing.T) {
+	osImage := "quay.io/openshift/os@sha256:abcd"
+	randomFile := ctrlcommon.NewIgnFile("/etc/random-file", "contents")
+
+	newMC := func(name string, files []ign3types.File, extensions, kargs []string) *mcfgv1.MachineConfig {
+		return helpers.NewMachineConfigExtended(name, nil, nil, files, nil, nil, extensions, false, kargs, "", osImage)
+	}
+
+	base := newMC("rendered-old", nil, nil, []string{"a=b"})
+
+	withDisks := newMC("rendered-disks", nil, nil, []string{"a=b"})
+	ignCfg, err := ctrlcommon.ParseAndConvertConfig(withDisks.Spec.Config.Raw)
+	require.NoError(t, err)
+	ignCfg.Storage.Disks = []ign3types.Disk{{Device: "/dev/sdb", WipeTable: ptr.To(true)}}
+	withDisks.Spec.Config = runtime.RawExtension{Raw: helpers.MarshalOrDie(ignCfg)}
+
+	diskOverrides := &opv1.IrreconcilableValidationOverrides{
+		Storage: []opv1.IrreconcilableValidationOverridesStorage{opv1.IrreconcilableValidationOverridesStorageDisks},
+	}
+	irreconcilableGate := ctrlcommon.NewFeatureGatesHardcodedHandler([]configv1.FeatureGateName{features.FeatureGateIrreconcilableMachineConfig}, nil)
+
+	testCases := []struct {
+		name          string
+		in            PlanInput
+		reconcilable  bool
+		actions       []string
+		drain         bool
+		reboot        bool
+		changedFiles  []string
+		kargs         []string
+		extensionArgs []string
+	}{
+		{
+			name: "No changes",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: base,
+			},
+			reconcilable: true,
+			actions:      []string{postConfigChangeActionNone},
+		},
+		{
+			name: "CA bundle change needs no action",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: newMC("rendered-new", []ign3types.File{ctrlcommon.NewIgnFile(caBundleFilePath, "ca")}, nil, []string{"a=b"}),
+			},
+			reconcilable: true,
+			actions:      []string{postConfigChangeActionNone},
+			changedFiles: []string{caBundleFilePath},
+		},
+		{
+			name: "Unknown file change reboots",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: newMC("rendered-new", []ign3types.File{randomFile}, nil, []string{"a=b"}),
+			},
+			reconcilable: true,
+			actions:      []string{postConfigChangeActionReboot},
+			drain:        true,
+			reboot:       true,
+			changedFiles: []string{"/etc/random-file"},
+		},
+		{
+			name: "Node disruption policy for a file",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: newMC("rendered-new", []ign3types.File{randomFile}, nil, []string{"a=b"}),
+				NodeDisruptionPolicies: &opv1.NodeDisruptionPolicyClusterStatus{
+					Files: []opv1.NodeDisruptionPolicyStatusFile{
+						{
+							Path: "/etc/random-file",
+							Actions: []opv1.NodeDisruptionPolicyStatusAction{
+								{Type: opv1.RestartStatusAction, Restart: &opv1.RestartService{ServiceName: "random.service"}},
+							},
+						},
+					},
+				},
+			},
+			reconcilable: true,
+			actions:      []string{"Restart - random.service"},
+			changedFiles: []string{"/etc/random-file"},
+		},
+		{
+			name: "Kernel arguments and extensions",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: newMC("rendered-new", nil, []string{"usbguard"}, []string{"c=d"}),
+			},
+			reconcilable:  true,
+			actions:       []string{postConfigChangeActionReboot},
+			drain:         true,
+			reboot:        true,
+			kargs:         []string{"--delete-if-present=a=b", "--append=c=d"},
+			extensionArgs: []string{"--install", "usbguard"},
+		},
+		{
+			name: "Force file reboots",
+			in: PlanInput{
+				CurrentConfig:    base,
+				DesiredConfig:    base,
+				ForceFilePresent: true,
+			},
+			reconcilable: true,
+			actions:      []string{postConfigChangeActionReboot},
+			drain:        true,
+			reboot:       true,
+		},
+		{
+			name: "Disk change is not reconcilable",
+			in: PlanInput{
+				CurrentConfig: base,
+				DesiredConfig: withDisks,
+			},
+		},
+		{
+			name: "Disk change overrides need the feature gate",
+			in: PlanInput{
+				CurrentConfig:           base,
+				DesiredConfig:           withDisks,
+				NodeDisruptionPolicies:  &opv1.NodeDisruptionPolicyClusterStatus{},
+				IrreconcilableOverrides: diskOverrides,
+			},
+		},
+		{
+			name: "Disk change overrides do not apply during firstboot",
+			in: PlanInput{
+				CurrentConfig:           base,
+				DesiredConfig:           withDisks,
+				IrreconcilableOverrides: diskOverrides,
+				FeatureGates:            irreconcilableGate,
+			},
+		},
+		{
+			name: "Disk change overridden",
+			in: PlanInput{
+				CurrentConfig:           base,
+				DesiredConfig:           withDisks,
+				NodeDisruptionPolicies:  &opv1.NodeDisruptionPolicyClusterStatus{},
+				IrreconcilableOverrides: diskOverrides,
+				FeatureGates:            irreconcilableGate,
+			},
+			reconcilable: true,
+			actions:      []string{string(opv1.NoneStatusAction)},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			plan, err := NewUpdatePlan(testCase.in)
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.reconcilable, plan.Reconcilable)
+			if !testCase.reconcilable {
+				assert.NotEmpty(t, plan.UnreconcilableReason)
+				return
+			}
+
+			assert.Equal(t, testCase.actions, plan.PostConfigChangeActions)
+			assert.Equal(t, testCase.drain, plan.DrainRequired)
+			assert.Equal(t, testCase.reboot, plan.RebootRequired)
+			assert.ElementsMatch(t, testCase.changedFiles, plan.ChangedFiles)
+			assert.Equal(t, testCase.kargs, plan.KernelArguments)
+			assert.Equal(t, testCase.extensionArgs, plan.ExtensionsArgs)
+
+			// Both output formats must render without error.
+			buf := &bytes.Buffer{}
+			plan.Print(buf)
+			assert.Contains(t, buf.String(), "Reboot required")
+			_, err = json.Marshal(plan)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNewUpdatePlanRequiresConfigs(t *testing.T) {
+	_, err := NewUpdatePlan(PlanInput{})
+	assert.Error(t, err)
+}
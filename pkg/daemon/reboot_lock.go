@@ -0,0 +1,545 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/helpers"
+	"github.com/openshift/machine-config-operator/pkg/upgrademonitor"
+)
+
+const (
+	// rebootLockConfigMapName is the name of the optional ConfigMap in the MCO
+	// namespace which opts the cluster into reboot coordination. When it does
+	// not exist, the MCD reboots as soon as the update is staged.
+	rebootLockConfigMapName = "machine-config-reboot-lock"
+
+	// Keys understood in the reboot lock ConfigMap.
+	rebootLockModeKey           = "mode"
+	rebootLockGroupKey          = "group"
+	rebootLockMaxConcurrentKey  = "maxConcurrent"
+	rebootLockLeaseDurationKey  = "leaseDuration"
+	rebootLockAcquireTimeoutKey = "acquireTimeout"
+	rebootLockFleetLockURLKey   = "fleetLockURL"
+
+	// rebootLockModeLease coordinates reboots using Kubernetes Leases in the MCO namespace.
+	rebootLockModeLease = "Lease"
+	// rebootLockModeFleetLock coordinates reboots using a FleetLock protocol server.
+	rebootLockModeFleetLock = "FleetLock"
+
+	defaultRebootLockGroup          = "default"
+	defaultRebootLockMaxConcurrent  = 1
+	defaultRebootLockLeaseDuration  = time.Hour
+	defaultRebootLockAcquireTimeout = time.Hour
+	rebootLockPollInterval          = 10 * time.Second
+
+	// fleetLockProtocolHeader must be set on every FleetLock request.
+	// https://coreos.github.io/zincati/development/fleetlock/protocol/
+	fleetLockProtocolHeader = "fleet-lock-protocol"
+	fleetLockPreRebootPath  = "/v1/pre-reboot"
+	fleetLockSteadyPath     = "/v1/steady-state"
+)
+
+// rebootLocker is a cluster-wide semaphore which a node must hold while it
+// reboots. Implementations must be safe to call release on when the lock is
+// not held, since the MCD cannot know whether it held the lock prior to the
+// reboot.
+type rebootLocker interface {
+	// acquire blocks until the lock is held or the context is done.
+	acquire(ctx context.Context) error
+	// release gives up the lock, if held.
+	release(ctx context.Context) error
+	// String describes the lock for logging and status reporting.
+	String() string
+}
+
+// rebootLockConfig is the parsed form of the reboot lock ConfigMap.
+type rebootLockConfig struct {
+	mode           string
+	group          string
+	maxConcurrent  int
+	leaseDuration  time.Duration
+	acquireTimeout time.Duration
+	fleetLockURL   string
+}
+
+// newRebootLockConfig parses the reboot lock ConfigMap, filling in defaults
+// for any unset keys.
+func newRebootLockConfig(cm *corev1.ConfigMap) (*rebootLockConfig, error) {
+	cfg := &rebootLockConfig{
+		mode:           rebootLockModeLease,
+		group:          defaultRebootLockGroup,
+		maxConcurrent:  defaultRebootLockMaxConcurrent,
+		leaseDuration:  defaultRebootLockLeaseDuration,
+		acquireTimeout: defaultRebootLockAcquireTimeout,
+	}
+
+	if mode, ok := cm.Data[rebootLockModeKey]; ok && mode != "" {
+		cfg.mode = mode
+	}
+
+	if group, ok := cm.Data[rebootLockGroupKey]; ok && group != "" {
+		cfg.group = group
+	}
+
+	if val, ok := cm.Data[rebootLockMaxConcurrentKey]; ok && val != "" {
+		maxConcurrent, err := strconv.Atoi(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", rebootLockMaxConcurrentKey, val, err)
+		}
+		if maxConcurrent < 1 {
+			return nil, fmt.Errorf("invalid %s %d: must be at least 1", rebootLockMaxConcurrentKey, maxConcurrent)
+		}
+		cfg.maxConcurrent = maxConcurrent
+	}
+
+	if val, ok := cm.Data[rebootLockLeaseDurationKey]; ok && val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", rebootLockLeaseDurationKey, val, err)
+		}
+		cfg.leaseDuration = d
+	}
+
+	if val, ok := cm.Data[rebootLockAcquireTimeoutKey]; ok && val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", rebootLockAcquireTimeoutKey, val, err)
+		}
+		cfg.acquireTimeout = d
+	}
+
+	cfg.fleetLockURL = strings.TrimSuffix(cm.Data[rebootLockFleetLockURLKey], "/")
+
+	switch cfg.mode {
+	case rebootLockModeLease:
+	case rebootLockModeFleetLock:
+		if cfg.fleetLockURL == "" {
+			return nil, fmt.Errorf("%s must be set when %s is %s", rebootLockFleetLockURLKey, rebootLockModeKey, rebootLockModeFleetLock)
+		}
+	default:
+		return nil, fmt.Errorf("unknown reboot lock %s %q, must be one of %s or %s", rebootLockModeKey, cfg.mode, rebootLockModeLease, rebootLockModeFleetLock)
+	}
+
+	return cfg, nil
+}
+
+// newRebootLocker constructs the rebootLocker described by the given config.
+func newRebootLocker(cfg *rebootLockConfig, kubeClient kubernetes.Interface, nodeName string) rebootLocker {
+	if cfg.mode == rebootLockModeFleetLock {
+		return &fleetLockRebootLocker{
+			url:      cfg.fleetLockURL,
+			group:    cfg.group,
+			nodeName: nodeName,
+			client:   &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+
+	return &leaseRebootLocker{
+		kubeClient:    kubeClient,
+		namespace:     ctrlcommon.MCONamespace,
+		group:         cfg.group,
+		nodeName:      nodeName,
+		slots:         cfg.maxConcurrent,
+		leaseDuration: cfg.leaseDuration,
+	}
+}
+
+// leaseRebootLocker implements a counting semaphore on top of Kubernetes
+// Leases. Each of the maxConcurrent slots is backed by its own Lease; a node
+// holds the lock when it is the holder of any one of them. Leases are not
+// renewed while the node reboots, so the lease duration must be long enough
+// to cover a reboot. Leases held by nodes which never came back are taken
+// over once they expire.
+type leaseRebootLocker struct {
+	kubeClient    kubernetes.Interface
+	namespace     string
+	group         string
+	nodeName      string
+	slots         int
+	leaseDuration time.Duration
+}
+
+func (l *leaseRebootLocker) String() string {
+	return fmt.Sprintf("Lease reboot lock %s/%s (%d slots)", l.namespace, l.leasePrefix(), l.slots)
+}
+
+func (l *leaseRebootLocker) leasePrefix() string {
+	return fmt.Sprintf("machine-config-reboot-lock-%s", l.group)
+}
+
+func (l *leaseRebootLocker) leaseName(slot int) string {
+	return fmt.Sprintf("%s-%d", l.leasePrefix(), slot)
+}
+
+func (l *leaseRebootLocker) acquire(ctx context.Context) error {
+	return wait.PollUntilContextCancel(ctx, rebootLockPollInterval, true, func(ctx context.Context) (bool, error) {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			klog.Warningf("Failed to acquire %s: %v", l, err)
+			return false, nil
+		}
+		return acquired, nil
+	})
+}
+
+// tryAcquire makes a single pass over all of the slots. If this node already
+// holds one of them, it is renewed; otherwise the first slot which is free or
+// expired is taken.
+func (l *leaseRebootLocker) tryAcquire(ctx context.Context) (bool, error) {
+	leases := l.kubeClient.CoordinationV1().Leases(l.namespace)
+	now := metav1.NewMicroTime(time.Now())
+
+	existing := make([]*coordinationv1.Lease, l.slots)
+	for slot := 0; slot < l.slots; slot++ {
+		lease, err := leases.Get(ctx, l.leaseName(slot), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("could not get lease %s: %w", l.leaseName(slot), err)
+		}
+		if ptr.Deref(lease.Spec.HolderIdentity, "") == l.nodeName {
+			return l.takeLease(ctx, lease, now)
+		}
+		existing[slot] = lease
+	}
+
+	for slot, lease := range existing {
+		if lease == nil {
+			newLease := &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      l.leaseName(slot),
+					Namespace: l.namespace,
+				},
+			}
+			l.setHolder(newLease, now)
+			_, err := leases.Create(ctx, newLease, metav1.CreateOptions{})
+			if err == nil {
+				klog.Infof("Acquired reboot lock lease %s/%s", l.namespace, newLease.Name)
+				return true, nil
+			}
+			if apierrors.IsAlreadyExists(err) {
+				// Another node beat us to it.
+				continue
+			}
+			return false, fmt.Errorf("could not create lease %s: %w", newLease.Name, err)
+		}
+
+		holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+		if holder != "" && !isLeaseExpired(lease, now.Time) {
+			continue
+		}
+		if holder != "" {
+			klog.Infof("Taking over expired reboot lock lease %s/%s from %s", l.namespace, lease.Name, holder)
+		}
+
+		acquired, err := l.takeLease(ctx, lease, now)
+		if err != nil {
+			return false, err
+		}
+		if acquired {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// takeLease makes this node the holder of the given lease. The update fails
+// with a conflict if someone else modified the lease since we read it, which
+// keeps this safe against concurrent acquisitions.
+func (l *leaseRebootLocker) takeLease(ctx context.Context, lease *coordinationv1.Lease, now metav1.MicroTime) (bool, error) {
+	lease = lease.DeepCopy()
+	l.setHolder(lease, now)
+	if _, err := l.kubeClient.CoordinationV1().Leases(l.namespace).Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not update lease %s: %w", lease.Name, err)
+	}
+	klog.Infof("Acquired reboot lock lease %s/%s", l.namespace, lease.Name)
+	return true, nil
+}
+
+func (l *leaseRebootLocker) setHolder(lease *coordinationv1.Lease, now metav1.MicroTime) {
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != l.nodeName {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = ptr.To(l.nodeName)
+	lease.Spec.LeaseDurationSeconds = ptr.To(int32(l.leaseDuration.Seconds()))
+	lease.Spec.RenewTime = &now
+}
+
+func (l *leaseRebootLocker) release(ctx context.Context) error {
+	leases := l.kubeClient.CoordinationV1().Leases(l.namespace)
+
+	for slot := 0; slot < l.slots; slot++ {
+		name := l.leaseName(slot)
+		lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not get lease %s: %w", name, err)
+		}
+
+		if ptr.Deref(lease.Spec.HolderIdentity, "") != l.nodeName {
+			continue
+		}
+
+		lease = lease.DeepCopy()
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.AcquireTime = nil
+		lease.Spec.RenewTime = nil
+		if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("could not release lease %s: %w", name, err)
+		}
+		klog.Infof("Released reboot lock lease %s/%s", l.namespace, name)
+	}
+
+	return nil
+}
+
+// isLeaseExpired determines whether the holder of the given lease has failed
+// to renew it within its lease duration.
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
+
+// fleetLockRebootLocker implements the FleetLock protocol used by Zincati.
+// https://coreos.github.io/zincati/development/fleetlock/protocol/
+type fleetLockRebootLocker struct {
+	url      string
+	group    string
+	nodeName string
+	client   *http.Client
+}
+
+// fleetLockRequest is the body of every FleetLock request.
+type fleetLockRequest struct {
+	ClientParams fleetLockClientParams `json:"client_params"`
+}
+
+type fleetLockClientParams struct {
+	ID    string `json:"id"`
+	Group string `json:"group"`
+}
+
+// fleetLockError is the body returned by a FleetLock server on failure.
+type fleetLockError struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+func (f *fleetLockRebootLocker) String() string {
+	return fmt.Sprintf("FleetLock reboot lock %s (group %s)", f.url, f.group)
+}
+
+func (f *fleetLockRebootLocker) acquire(ctx context.Context) error {
+	return wait.PollUntilContextCancel(ctx, rebootLockPollInterval, true, func(ctx context.Context) (bool, error) {
+		if err := f.do(ctx, fleetLockPreRebootPath); err != nil {
+			klog.Warningf("Failed to acquire %s: %v", f, err)
+			return false, nil
+		}
+		klog.Infof("Acquired %s", f)
+		return true, nil
+	})
+}
+
+func (f *fleetLockRebootLocker) release(ctx context.Context) error {
+	if err := f.do(ctx, fleetLockSteadyPath); err != nil {
+		return err
+	}
+	klog.Infof("Released %s", f)
+	return nil
+}
+
+func (f *fleetLockRebootLocker) do(ctx context.Context, path string) error {
+	body, err := json.Marshal(fleetLockRequest{
+		ClientParams: fleetLockClientParams{
+			ID:    f.nodeName,
+			Group: f.group,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(fleetLockProtocolHeader, "true")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	flErr := fleetLockError{}
+	if err := json.Unmarshal(respBody, &flErr); err == nil && flErr.Kind != "" {
+		return fmt.Errorf("%s returned %d: %s: %s", path, resp.StatusCode, flErr.Kind, flErr.Value)
+	}
+	return fmt.Errorf("%s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// getRebootLockConfig returns the reboot lock configuration, or nil if reboot
+// coordination is not enabled for this cluster.
+func (dn *Daemon) getRebootLockConfig() (*rebootLockConfig, error) {
+	if dn.kubeClient == nil {
+		return nil, nil
+	}
+
+	cm, err := dn.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), rebootLockConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get reboot lock configmap: %w", err)
+	}
+
+	cfg, err := newRebootLockConfig(cm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reboot lock configmap %s/%s: %w", ctrlcommon.MCONamespace, rebootLockConfigMapName, err)
+	}
+
+	return cfg, nil
+}
+
+// acquireRebootLock blocks until the configured reboot lock, if any, is held
+// by this node. The progress is reported on the MachineConfigNode.
+func (dn *Daemon) acquireRebootLock() error {
+	cfg, err := dn.getRebootLockConfig()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	locker := newRebootLocker(cfg, dn.kubeClient, dn.name)
+
+	logSystem("Waiting for %s before rebooting", locker)
+	dn.reportRebootLockState("RebootLockPending", fmt.Sprintf("Waiting to acquire %s", locker))
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "RebootLockPending", "Waiting to acquire %s", locker)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.acquireTimeout)
+	defer cancel()
+
+	if err := locker.acquire(ctx); err != nil {
+		dn.reportRebootLockState("RebootLockTimeout", fmt.Sprintf("Timed out after %s waiting to acquire %s", cfg.acquireTimeout, locker))
+		return fmt.Errorf("could not acquire %s within %s: %w", locker, cfg.acquireTimeout, err)
+	}
+
+	logSystem("Acquired %s", locker)
+	dn.reportRebootLockState("RebootLockAcquired", fmt.Sprintf("Acquired %s, node will reboot", locker))
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "RebootLockAcquired", "Acquired %s", locker)
+	}
+	return nil
+}
+
+// releaseRebootLock gives up the configured reboot lock, if any. It is safe
+// to call when the lock is not held.
+func (dn *Daemon) releaseRebootLock() error {
+	cfg, err := dn.getRebootLockConfig()
+	if err != nil {
+		return err
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	locker := newRebootLocker(cfg, dn.kubeClient, dn.name)
+	if err := locker.release(context.TODO()); err != nil {
+		return fmt.Errorf("could not release %s: %w", locker, err)
+	}
+	if dn.nodeWriter != nil {
+		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "RebootLockReleased", "Released %s", locker)
+	}
+	return nil
+}
+
+// releaseRebootLockWithRetry releases the reboot lock, retrying with backoff.
+// Failures are only logged: the node is already Done, and the release is
+// attempted again the next time the MCD starts in its desired config.
+func (dn *Daemon) releaseRebootLockWithRetry() {
+	backoff := wait.Backoff{
+		Duration: 5 * time.Second,
+		Factor:   2,
+		Steps:    4,
+	}
+
+	if err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		if err := dn.releaseRebootLock(); err != nil {
+			klog.Warningf("Failed to release reboot lock (will retry): %v", err)
+			return false, nil
+		}
+		return true, nil
+	}); err != nil {
+		klog.Errorf("Giving up releasing the reboot lock until the next MCD start: %v", err)
+		if dn.nodeWriter != nil {
+			dn.nodeWriter.Eventf(corev1.EventTypeWarning, "RebootLockReleaseFailed", "Could not release the reboot lock, it will be released on the next MCD start or when it expires")
+		}
+	}
+}
+
+// reportRebootLockState surfaces the reboot lock state on the Rebooted
+// condition of the node's MachineConfigNode.
+func (dn *Daemon) reportRebootLockState(reason, message string) {
+	if dn.node == nil || dn.mcpLister == nil {
+		return
+	}
+
+	pool, err := helpers.GetPrimaryPoolNameForMCN(dn.mcpLister, dn.node)
+	if err != nil {
+		klog.Errorf("Error getting pool for MCN reboot lock status: %v", err)
+		return
+	}
+
+	err = upgrademonitor.GenerateAndApplyMachineConfigNodes(
+		&upgrademonitor.Condition{State: mcfgv1.MachineConfigNodeUpdateRebooted, Reason: reason, Message: message},
+		nil,
+		metav1.ConditionUnknown,
+		metav1.ConditionFalse,
+		dn.node,
+		dn.mcfgClient,
+		dn.fgHandler,
+		pool,
+	)
+	if err != nil {
+		klog.Errorf("Error making MCN for reboot lock: %v", err)
+	}
+}
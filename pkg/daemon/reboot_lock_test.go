@@ -0,0 +1,265 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+)
+
+func TestNewRebootLockConfig(t *testing.T) {
+	testCases := []struct {
+		name        string
+		data        map[string]string
+		expected    *rebootLockConfig
+		errExpected bool
+	}{
+		{
+			name: "Defaults",
+			data: map[string]string{},
+			expected: &rebootLockConfig{
+				mode:           rebootLockModeLease,
+				group:          defaultRebootLockGroup,
+				maxConcurrent:  defaultRebootLockMaxConcurrent,
+				leaseDuration:  defaultRebootLockLeaseDuration,
+				acquireTimeout: defaultRebootLockAcquireTimeout,
+			},
+		},
+		{
+			name: "FleetLock",
+			data: map[string]string{
+				rebootLockModeKey:           rebootLockModeFleetLock,
+				rebootLockGroupKey:          "workers",
+				rebootLockFleetLockURLKey:   "http://fleetlock.example.com/",
+				rebootLockAcquireTimeoutKey: "30m",
+			},
+			expected: &rebootLockConfig{
+				mode:           rebootLockModeFleetLock,
+				group:          "workers",
+				maxConcurrent:  defaultRebootLockMaxConcurrent,
+				leaseDuration:  defaultRebootLockLeaseDuration,
+				acquireTimeout: 30 * time.Minute,
+				fleetLockURL:   "http://fleetlock.example.com",
+			},
+		},
+		{
+			name: "FleetLock without URL",
+			data: map[string]string{
+				rebootLockModeKey: rebootLockModeFleetLock,
+			},
+			errExpected: true,
+		},
+		{
+			name: "Unknown mode",
+			data: map[string]string{
+				rebootLockModeKey: "etcd",
+			},
+			errExpected: true,
+		},
+		{
+			name: "Invalid max concurrent",
+			data: map[string]string{
+				rebootLockMaxConcurrentKey: "0",
+			},
+			errExpected: true,
+		},
+		{
+			name: "Invalid lease duration",
+			data: map[string]string{
+				rebootLockLeaseDurationKey: "forever",
+			},
+			errExpected: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: rebootLockConfigMapName, Namespace: ctrlcommon.MCONamespace},
+				Data:       testCase.data,
+			}
+			cfg, err := newRebootLockConfig(cm)
+			if testCase.errExpected {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, cfg)
+		})
+	}
+}
+
+func TestLeaseRebootLocker(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	cfg := &rebootLockConfig{
+		mode:          rebootLockModeLease,
+		group:         defaultRebootLockGroup,
+		maxConcurrent: 2,
+		leaseDuration: time.Hour,
+	}
+
+	node1 := newRebootLocker(cfg, kubeClient, "node-1").(*leaseRebootLocker)
+	node2 := newRebootLocker(cfg, kubeClient, "node-2").(*leaseRebootLocker)
+	node3 := newRebootLocker(cfg, kubeClient, "node-3").(*leaseRebootLocker)
+
+	ctx := context.Background()
+
+	acquired, err := node1.tryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Acquiring again must not consume a second slot.
+	acquired, err = node1.tryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = node2.tryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Both slots are now held.
+	acquired, err = node3.tryAcquire(ctx)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	require.NoError(t, node1.release(ctx))
+
+	acquired, err = node3.tryAcquire(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Releasing a lock which is not held is a no-op.
+	require.NoError(t, node1.release(ctx))
+
+	assertLeaseHolders(t, kubeClient, node1, "node-3", "node-2")
+}
+
+func TestLeaseRebootLockerTakesOverExpiredLease(t *testing.T) {
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Hour))
+	kubeClient := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "machine-config-reboot-lock-default-0",
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("dead-node"),
+			LeaseDurationSeconds: ptr.To(int32(3600)),
+			AcquireTime:          &expired,
+			RenewTime:            &expired,
+		},
+	})
+
+	cfg := &rebootLockConfig{
+		mode:          rebootLockModeLease,
+		group:         defaultRebootLockGroup,
+		maxConcurrent: 1,
+		leaseDuration: time.Hour,
+	}
+	locker := newRebootLocker(cfg, kubeClient, "node-1").(*leaseRebootLocker)
+
+	acquired, err := locker.tryAcquire(context.Background())
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	assertLeaseHolders(t, kubeClient, locker, "node-1")
+}
+
+func assertLeaseHolders(t *testing.T, kubeClient *fake.Clientset, locker *leaseRebootLocker, holders ...string) {
+	t.Helper()
+
+	for slot, holder := range holders {
+		lease, err := kubeClient.CoordinationV1().Leases(ctrlcommon.MCONamespace).Get(context.Background(), locker.leaseName(slot), metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, holder, ptr.Deref(lease.Spec.HolderIdentity, ""), "slot %d", slot)
+	}
+}
+
+// fakeFleetLockServer is a minimal in-memory FleetLock server which allows a
+// single holder per group.
+type fakeFleetLockServer struct {
+	mu      sync.Mutex
+	holders map[string]string
+}
+
+func (f *fakeFleetLockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get(fleetLockProtocolHeader) != "true" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req := fleetLockRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, id := req.ClientParams.Group, req.ClientParams.ID
+	holder := f.holders[group]
+
+	switch r.URL.Path {
+	case fleetLockPreRebootPath:
+		if holder != "" && holder != id {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(fleetLockError{Kind: "failed_lock", Value: "semaphore is held by " + holder})
+			return
+		}
+		f.holders[group] = id
+	case fleetLockSteadyPath:
+		if holder == id {
+			delete(f.holders, group)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestFleetLockRebootLocker(t *testing.T) {
+	server := &fakeFleetLockServer{holders: map[string]string{}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	cfg := &rebootLockConfig{
+		mode:         rebootLockModeFleetLock,
+		group:        "workers",
+		fleetLockURL: ts.URL,
+	}
+
+	node1 := newRebootLocker(cfg, nil, "node-1").(*fleetLockRebootLocker)
+	node2 := newRebootLocker(cfg, nil, "node-2").(*fleetLockRebootLocker)
+
+	ctx := context.Background()
+
+	require.NoError(t, node1.acquire(ctx))
+	assert.Equal(t, "node-1", server.holders["workers"])
+
+	err := node2.do(ctx, fleetLockPreRebootPath)
+	assert.ErrorContains(t, err, "failed_lock")
+
+	// Acquiring should block until the context is done.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, node2.acquire(timeoutCtx))
+
+	require.NoError(t, node1.release(ctx))
+	require.NoError(t, node2.acquire(ctx))
+	assert.Equal(t, "node-2", server.holders["workers"])
+}
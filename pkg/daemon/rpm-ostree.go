@@ -202,6 +202,18 @@ func (r *RpmOstreeClient) RebaseLayered(imgURL string) error {
 	return runRpmOstree("rebase", "--experimental", "ostree-unverified-registry:"+imgURL)
 }
 
+// RebaseLayeredVerified rebases system or errors if already rebased. Unlike
+// RebaseLayered, the image signature is verified against
+// /etc/containers/policy.json.
+func (r *RpmOstreeClient) RebaseLayeredVerified(imgURL string) error {
+	// Try to re-link the merged pull secrets if they exist, since it could have been populated without a daemon reboot
+	if err := useMergedPullSecrets(rpmOstreeSystem); err != nil {
+		return fmt.Errorf("error while ensuring access to pull secrets: %w", err)
+	}
+	klog.Infof("Executing verified rebase to %s", imgURL)
+	return runRpmOstree("rebase", "--experimental", "ostree-image-signed:docker://"+imgURL)
+}
+
 // RebaseLayeredFromContainerStorage rebases the system from an existing local container storage image.
 func (r *RpmOstreeClient) RebaseLayeredFromContainerStorage(podmanImageInfo *PodmanImageInfo) error {
 	// Try to re-link the merged pull secrets if they exist, since it could have been populated without a daemon reboot
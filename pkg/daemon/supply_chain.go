@@ -0,0 +1,184 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/signature"
+	"github.com/distribution/reference"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// supplyChainPolicyFilePath opts a node into verifying the supply-chain
+	// artifacts of layered OS images when it exists. It is meant to be written
+	// by a MachineConfig so it can be enabled per pool.
+	supplyChainPolicyFilePath = "/etc/machine-config-daemon/supply-chain-policy.json"
+
+	// sigstoreSignedRequirementType is the policy.json requirement type for
+	// sigstore signatures.
+	sigstoreSignedRequirementType = "sigstoreSigned"
+)
+
+// supplyChainPolicy describes which supply-chain artifacts a layered OS image
+// must have before the MCD will rebase onto it.
+type supplyChainPolicy struct {
+	// RequireSignature rebases with signature verification enforced and
+	// refuses to do so unless /etc/containers/policy.json requires a sigstore
+	// signature for the image.
+	RequireSignature bool `json:"requireSignature"`
+	// RequireSBOM refuses to rebase unless an SBOM is attached to the image.
+	RequireSBOM bool `json:"requireSBOM"`
+}
+
+// loadSupplyChainPolicy reads the supply-chain policy from the given path.
+// Returns a nil policy if the file does not exist.
+func loadSupplyChainPolicy(path string) (*supplyChainPolicy, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read supply-chain policy %s: %w", path, err)
+	}
+
+	policy := &supplyChainPolicy{}
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("could not parse supply-chain policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// policyRequiresSigstoreSignature returns an error unless the given
+// policy.json content requires a sigstore signature for the image. The scope
+// is looked up the same way the containers/image library does: the most
+// specific docker transport scope wins, falling back to the default.
+func policyRequiresSigstoreSignature(policyContent []byte, imgURL string) error {
+	policy, err := signature.NewPolicyFromBytes(policyContent)
+	if err != nil {
+		return fmt.Errorf("could not parse image policy: %w", err)
+	}
+
+	ref, err := docker.ParseReference("//" + imgURL)
+	if err != nil {
+		return fmt.Errorf("could not parse image %s: %w", imgURL, err)
+	}
+
+	scope := "default"
+	requirements := policy.Default
+
+	if scopes, ok := policy.Transports[docker.Transport.Name()]; ok {
+		candidates := append([]string{ref.PolicyConfigurationIdentity()}, ref.PolicyConfigurationNamespaces()...)
+		for _, candidate := range candidates {
+			if reqs, ok := scopes[candidate]; ok {
+				scope = candidate
+				requirements = reqs
+				break
+			}
+		}
+	}
+
+	for _, requirement := range requirements {
+		out, err := json.Marshal(requirement)
+		if err != nil {
+			return fmt.Errorf("could not inspect image policy requirement: %w", err)
+		}
+
+		var typed struct {
+			Type string `json:"type"`
+		}
+
+		if err := json.Unmarshal(out, &typed); err != nil {
+			return fmt.Errorf("could not inspect image policy requirement: %w", err)
+		}
+
+		if typed.Type == sigstoreSignedRequirementType {
+			klog.Infof("Image policy scope %q requires a sigstore signature for %s", scope, imgURL)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("image policy scope %q does not require a sigstore signature for %s", scope, imgURL)
+}
+
+// getSBOMAttachmentTag returns the pullspec of the SBOM attached to a digested
+// image using the cosign tag scheme, e.g. repo:sha256-<hex>.sbom.
+func getSBOMAttachmentTag(imgURL string) (string, error) {
+	named, err := reference.ParseNamed(imgURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse image %s: %w", imgURL, err)
+	}
+
+	digested, ok := named.(reference.Digested)
+	if !ok {
+		return "", fmt.Errorf("image %s is not referenced by digest", imgURL)
+	}
+
+	tag := strings.Replace(digested.Digest().String(), ":", "-", 1) + ".sbom"
+	return named.Name() + ":" + tag, nil
+}
+
+// verifySBOMAttached returns an error unless an SBOM is attached to the image.
+func verifySBOMAttached(imgURL string) error {
+	sbomURL, err := getSBOMAttachmentTag(imgURL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	args := []string{"inspect", "--raw"}
+	if _, err := os.Stat(kubeletAuthFile); err == nil {
+		args = append(args, "--authfile", kubeletAuthFile)
+	}
+	args = append(args, "docker://"+sbomURL)
+	if out, err := exec.CommandContext(ctx, "skopeo", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not find SBOM %s for image %s: %w: %s", sbomURL, imgURL, err, string(out))
+	}
+
+	klog.Infof("Found SBOM %s for image %s", sbomURL, imgURL)
+	return nil
+}
+
+// checkSupplyChainPolicy enforces the node's supply-chain policy, if any, for
+// the given layered OS image. archImgURL is the image for this node's
+// architecture when imgURL is a manifest list and imgURL otherwise. Returns
+// whether the image must be pulled with signature verification enforced.
+func checkSupplyChainPolicy(imgURL, archImgURL string) (bool, error) {
+	policy, err := loadSupplyChainPolicy(supplyChainPolicyFilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if policy == nil {
+		return false, nil
+	}
+
+	if policy.RequireSBOM {
+		if err := verifySBOMAttached(archImgURL); err != nil {
+			return false, fmt.Errorf("supply-chain policy requires an SBOM: %w", err)
+		}
+	}
+
+	if policy.RequireSignature {
+		policyContent, err := os.ReadFile(imagePolicyFilePath)
+		if err != nil {
+			return false, fmt.Errorf("supply-chain policy requires a signature but %s could not be read: %w", imagePolicyFilePath, err)
+		}
+
+		if err := policyRequiresSigstoreSignature(policyContent, imgURL); err != nil {
+			return false, fmt.Errorf("supply-chain policy requires a signature: %w", err)
+		}
+	}
+
+	return policy.RequireSignature, nil
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSupplyChainPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := loadSupplyChainPolicy(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+
+	path := filepath.Join(dir, "supply-chain-policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"requireSignature": true}`), 0o644))
+
+	policy, err = loadSupplyChainPolicy(path)
+	require.NoError(t, err)
+	assert.Equal(t, &supplyChainPolicy{RequireSignature: true}, policy)
+
+	require.NoError(t, os.WriteFile(path, []byte(`requireSBOM: true`), 0o644))
+
+	_, err = loadSupplyChainPolicy(path)
+	assert.Error(t, err)
+}
+
+func TestPolicyRequiresSigstoreSignature(t *testing.T) {
+	imgURL := "registry.hostname.com/org/os@sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5"
+
+	policy := `{
+	"default": [{"type": "insecureAcceptAnything"}],
+	"transports": {
+		"docker": {
+			"registry.hostname.com/org": [{"type": "sigstoreSigned", "keyData": "a2V5", "signedIdentity": {"type": "matchRepository"}}],
+			"registry.hostname.com/org/unsigned": [{"type": "insecureAcceptAnything"}]
+		}
+	}
+}`
+
+	assert.NoError(t, policyRequiresSigstoreSignature([]byte(policy), imgURL))
+	assert.ErrorContains(t, policyRequiresSigstoreSignature([]byte(policy), "registry.hostname.com/org/unsigned:latest"), `scope "registry.hostname.com/org/unsigned"`)
+	assert.ErrorContains(t, policyRequiresSigstoreSignature([]byte(policy), "quay.io/org/os:latest"), `scope "default"`)
+	assert.Error(t, policyRequiresSigstoreSignature([]byte("not json"), imgURL))
+}
+
+func TestGetSBOMAttachmentTag(t *testing.T) {
+	tag, err := getSBOMAttachmentTag("registry.hostname.com:5000/org/os@sha256:5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.hostname.com:5000/org/os:sha256-5be476dce1f7c1fbaf41bf9c0097e1725d7d26b74ea93543989d1a2b76fef4a5.sbom", tag)
+
+	_, err = getSBOMAttachmentTag("registry.hostname.com/org/os:latest")
+	assert.Error(t, err)
+}
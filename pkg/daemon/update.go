@@ -23,6 +23,9 @@ import (
 	"github.com/coreos/go-semver/semver"
 	systemddbus "github.com/coreos/go-systemd/v22/dbus"
 	ign3types "github.com/coreos/ignition/v2/config/v3_5/types"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -737,17 +740,24 @@ func calculatePostConfigChangeAction(diff *machineConfigDiff, diffFileSet []stri
 		return []string{postConfigChangeActionReboot}, nil
 	}
 
+	return calculatePostConfigChangeActionFromDiff(diff, diffFileSet), nil
+}
+
+// calculatePostConfigChangeActionFromDiff calculates the post config change
+// actions for the given diff, without considering the force file.
+func calculatePostConfigChangeActionFromDiff(diff *machineConfigDiff, diffFileSet []string) []string {
 	if diff.osUpdate || diff.kargs || diff.fips || diff.units || diff.kernelType || diff.extensions {
 		// must reboot
-		return []string{postConfigChangeActionReboot}, nil
+		return []string{postConfigChangeActionReboot}
 	}
 
 	// Calculate actions based on file, unit and ssh diffs
-	return calculatePostConfigChangeActionFromMCDiffs(diffFileSet), nil
+	return calculatePostConfigChangeActionFromMCDiffs(diffFileSet)
 }
 
-// calculatePostConfigChangeNodeDisruptionAction takes action based on the cluster's Node disruption policies.
-func (dn *Daemon) calculatePostConfigChangeNodeDisruptionAction(diff *machineConfigDiff, diffFileSet, diffUnitSet []string) ([]opv1.NodeDisruptionPolicyStatusAction, error) {
+// getNodeDisruptionPolicies waits for the cluster's node disruption policies
+// to be up to date and returns them.
+func (dn *Daemon) getNodeDisruptionPolicies() (*opv1.NodeDisruptionPolicyClusterStatus, error) {
 
 	var mcop *opv1.MachineConfiguration
 	var pollErr error
@@ -773,53 +783,110 @@ func (dn *Daemon) calculatePostConfigChangeNodeDisruptionAction(diff *machineCon
 		return nil, fmt.Errorf("NodeDisruptionPolicyStatus was not ready: %v", pollErr)
 	}
 
-	// Continue policy calculation if no errors were encountered in fetching the policy.
-	// If a machine-config-daemon-force file is present, it means the user wants to
-	// move to desired state without additional validation. We will reboot the node in
-	// this case regardless of what MachineConfig diff is.
-	klog.Infof("Calculating node disruption actions")
-	if _, err := os.Stat(constants.MachineConfigDaemonForceFile); err == nil {
-		if err = os.Remove(constants.MachineConfigDaemonForceFile); err != nil {
-			return []opv1.NodeDisruptionPolicyStatusAction{}, fmt.Errorf("failed to remove force validation file: %w", err)
+	return &mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies, nil
+}
+
+// newUpdateMachineConfigDiff calculates the diff between the configs of an
+// update. The update is only treated as an on-cluster layering one when it
+// requires a rebuild.
+func newUpdateMachineConfigDiff(oldConfig, newConfig *mcfgv1.MachineConfig) (*machineConfigDiff, error) {
+	mcDiff, err := newMachineConfigDiff(oldConfig, newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not calculate config diff: %w", err)
+	}
+
+	if mcDiff.oclEnabled && !ctrlcommon.RequiresRebuild(oldConfig, newConfig) {
+		klog.Info("OCL enabled but no OCL-specific changes detected - applying non-OCL update")
+		mcDiff.oclEnabled = false
+	}
+
+	return mcDiff, nil
+}
+
+// calculateChangedFilesAndUnits returns the paths of the changed files, the
+// added or updated units, and the names of all units changed in some way
+// (added, removed, or updated) between the Ignition configs.
+func calculateChangedFilesAndUnits(oldIgnConfig, newIgnConfig *ign3types.Config) (diffFileSet []string, addedOrChangedUnits []ign3types.Unit, allChangedUnitNames []string) {
+	diffFileSet = ctrlcommon.CalculateConfigFileDiffs(oldIgnConfig, newIgnConfig)
+	unitDiff := ctrlcommon.GetChangedConfigUnitsByType(oldIgnConfig, newIgnConfig)
+	addedOrChangedUnits = slices.Concat(unitDiff.Added, unitDiff.Updated)
+	for _, unit := range append(addedOrChangedUnits, unitDiff.Removed...) {
+		allChangedUnitNames = append(allChangedUnitNames, unit.Name)
+	}
+	return diffFileSet, addedOrChangedUnits, allChangedUnitNames
+}
+
+// irreconcilableOverridesInEffect is whether the irreconcilable validation
+// overrides of the MachineConfiguration apply to an update. They are not
+// available during firstboot as the API is not accessible.
+func irreconcilableOverridesInEffect(firstBoot bool, fgHandler ctrlcommon.FeatureGatesHandler) bool {
+	return !firstBoot && fgHandler != nil && fgHandler.Enabled(features.FeatureGateIrreconcilableMachineConfig)
+}
+
+// postConfigChangeDecision is what the MCD does once the new config is
+// written. Only one of actions and nodeDisruptionActions is set, depending on
+// whether node disruption policies were in effect.
+type postConfigChangeDecision struct {
+	actions               []string
+	nodeDisruptionActions []opv1.NodeDisruptionPolicyStatusAction
+	drain                 bool
+}
+
+// decidePostConfigChange calculates the post config change actions of an
+// update and whether it requires a drain. Node disruption policies are only
+// used when clusterPolicies is set, as they are not available during
+// firstboot. If the force file is present, the node reboots regardless of
+// the diff.
+func decidePostConfigChange(diff *machineConfigDiff, diffFileSet, diffUnitSet []string, forceFilePresent bool, clusterPolicies *opv1.NodeDisruptionPolicyClusterStatus, oldIgnConfig, newIgnConfig ign3types.Config) (*postConfigChangeDecision, error) {
+	decision := &postConfigChangeDecision{}
+
+	var err error
+	if clusterPolicies != nil {
+		if forceFilePresent {
+			klog.Infof("Setting post config change node disruption action to Reboot; %s present", constants.MachineConfigDaemonForceFile)
+			decision.nodeDisruptionActions = []opv1.NodeDisruptionPolicyStatusAction{{
+				Type: opv1.RebootStatusAction,
+			}}
+		} else {
+			decision.nodeDisruptionActions = calculatePostConfigChangeNodeDisruptionActionFromDiff(diff, diffFileSet, diffUnitSet, *clusterPolicies)
 		}
-		klog.Infof("Setting post config change node disruption action to Reboot; %s present", constants.MachineConfigDaemonForceFile)
-		return []opv1.NodeDisruptionPolicyStatusAction{{
-			Type: opv1.RebootStatusAction,
-		}}, nil
+		decision.drain, err = isDrainRequiredForNodeDisruptionActions(decision.nodeDisruptionActions, oldIgnConfig, newIgnConfig)
+	} else {
+		if forceFilePresent {
+			klog.Infof("Setting post config change action to postConfigChangeActionReboot; %s present", constants.MachineConfigDaemonForceFile)
+			decision.actions = []string{postConfigChangeActionReboot}
+		} else {
+			decision.actions = calculatePostConfigChangeActionFromDiff(diff, diffFileSet)
+		}
+		decision.drain, err = isDrainRequired(decision.actions, diffFileSet, oldIgnConfig, newIgnConfig)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decision, nil
+}
 
+// calculatePostConfigChangeNodeDisruptionActionFromDiff calculates the node
+// disruption actions for the given diff and cluster policies, without
+// considering the force file.
+func calculatePostConfigChangeNodeDisruptionActionFromDiff(diff *machineConfigDiff, diffFileSet, diffUnitSet []string, clusterPolicies opv1.NodeDisruptionPolicyClusterStatus) []opv1.NodeDisruptionPolicyStatusAction {
 	if diff.osUpdate || diff.kargs || diff.fips || diff.kernelType || diff.extensions {
 		// must reboot
 		return []opv1.NodeDisruptionPolicyStatusAction{{
 			Type: opv1.RebootStatusAction,
-		}}, nil
+		}}
 	}
 	if !diff.files && !diff.units && !diff.passwd {
 		// This is a diff which requires no actions
 		klog.Infof("No changes in files, units or SSH keys, no NodeDisruptionPolicies are in effect")
 		return []opv1.NodeDisruptionPolicyStatusAction{{
 			Type: opv1.NoneStatusAction,
-		}}, nil
+		}}
 	}
 
 	// Calculate actions based on file, unit and ssh diffs
-	nodeDisruptionActions := calculatePostConfigChangeNodeDisruptionActionFromMCDiffs(diff.passwd, diffFileSet, diffUnitSet, mcop.Status.NodeDisruptionPolicyStatus.ClusterPolicies)
-
-	// Print out node disruption actions for debug purposes
-	klog.Infof("Calculated node disruption actions:")
-	for _, action := range nodeDisruptionActions {
-		switch action.Type {
-		case opv1.ReloadStatusAction:
-			klog.Infof("%v - %v", action.Type, action.Reload.ServiceName)
-		case opv1.RestartStatusAction:
-			klog.Infof("%v - %v", action.Type, action.Restart.ServiceName)
-		default:
-			klog.Infof("%v", action.Type)
-		}
-	}
-
-	return nodeDisruptionActions, nil
-
+	return calculatePostConfigChangeNodeDisruptionActionFromMCDiffs(diff.passwd, diffFileSet, diffUnitSet, clusterPolicies)
 }
 
 // Finalizes the revert process by enabling a special systemd unit prior to
@@ -894,18 +961,9 @@ func (dn *Daemon) finalizeRevertToNonLayering(newConfig *mcfgv1.MachineConfig) (
 func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertificateWrite, firstBoot bool) (retErr error) {
 	oldConfig = canonicalizeEmptyMC(oldConfig)
 
-	mcDiff, err := newMachineConfigDiff(oldConfig, newConfig)
+	mcDiff, err := newUpdateMachineConfigDiff(oldConfig, newConfig)
 	if err != nil {
-		return fmt.Errorf("could not calculate config diff: %w", err)
-	}
-
-	if mcDiff.oclEnabled {
-		// Check if any OCL-specific changes are present
-		oclChange := ctrlcommon.RequiresRebuild(oldConfig, newConfig)
-		if !oclChange {
-			klog.Info("OCL enabled but no OCL-specific changes detected - applying non-OCL update")
-			mcDiff.oclEnabled = false
-		}
+		return err
 	}
 
 	if mcDiff.revertFromOCL {
@@ -981,7 +1039,7 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 	// make sure we can actually reconcile this state
 
 	var mcop opv1.MachineConfiguration
-	if !firstBoot && dn.fgHandler != nil && dn.fgHandler.Enabled(features.FeatureGateIrreconcilableMachineConfig) {
+	if irreconcilableOverridesInEffect(firstBoot, dn.fgHandler) {
 		mcopPtr, err := ctrlcommon.GetIrreconcilableOverrides(dn.mcopLister)
 		if err != nil {
 			return err
@@ -1014,28 +1072,27 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 
 	logSystem("Starting update from %s to %s: %+v", oldConfigName, newConfigName, diff)
 
-	diffFileSet := ctrlcommon.CalculateConfigFileDiffs(&oldIgnConfig, &newIgnConfig)
-	// Get the added and updated units
-	unitDiff := ctrlcommon.GetChangedConfigUnitsByType(&oldIgnConfig, &newIgnConfig)
-	addedOrChangedUnits := slices.Concat(unitDiff.Added, unitDiff.Updated)
-	// Get the names of all units changed in some way (added, removed, or updated)
-	var allChangedUnitNames []string
-	for _, unit := range append(addedOrChangedUnits, unitDiff.Removed...) {
-		allChangedUnitNames = append(allChangedUnitNames, unit.Name)
-	}
+	diffFileSet, addedOrChangedUnits, allChangedUnitNames := calculateChangedFilesAndUnits(&oldIgnConfig, &newIgnConfig)
 
-	var nodeDisruptionActions []opv1.NodeDisruptionPolicyStatusAction
-	var actions []string
-	// Check for forcefile before calculatePostConfigChange* functions delete it.
+	// Check for forcefile before it is deleted below.
 	// This is needed for updateFiles to know whether to write all units (OCPBUGS-74692).
 	forceFilePresent := forceFileExists()
+	var clusterPolicies *opv1.NodeDisruptionPolicyClusterStatus
 	// Node Disruption Policies cannot be used during firstboot as API is not accessible.
 	if !firstBoot {
-		nodeDisruptionActions, err = dn.calculatePostConfigChangeNodeDisruptionAction(diff, diffFileSet, allChangedUnitNames)
+		klog.Infof("Calculating node disruption actions")
+		clusterPolicies, err = dn.getNodeDisruptionPolicies()
 	} else {
-		actions, err = calculatePostConfigChangeAction(diff, diffFileSet)
 		klog.Infof("Skipping node disruption polciies as node is executing first boot.")
 	}
+	// If a machine-config-daemon-force file is present, it means the user wants to
+	// move to desired state without additional validation. We will reboot the node in
+	// this case regardless of what MachineConfig diff is.
+	if err == nil && forceFilePresent {
+		if err = os.Remove(constants.MachineConfigDaemonForceFile); err != nil {
+			err = fmt.Errorf("failed to remove force validation file: %w", err)
+		}
+	}
 
 	if err != nil {
 		Nerr := upgrademonitor.GenerateAndApplyMachineConfigNodes(
@@ -1054,21 +1111,20 @@ func (dn *Daemon) update(oldConfig, newConfig *mcfgv1.MachineConfig, skipCertifi
 		return err
 	}
 
-	var drain bool
-	// Node Disruption Policies cannot be used during firstboot as API is not accessible.
+	postConfigChange, err := decidePostConfigChange(diff, diffFileSet, allChangedUnitNames, forceFilePresent, clusterPolicies, oldIgnConfig, newIgnConfig)
+	if err != nil {
+		return err
+	}
+	actions := postConfigChange.actions
+	nodeDisruptionActions := postConfigChange.nodeDisruptionActions
+	drain := postConfigChange.drain
 	if !firstBoot {
-		// Check actions list and perform node drain if required
-		drain, err = isDrainRequiredForNodeDisruptionActions(nodeDisruptionActions, oldIgnConfig, newIgnConfig)
-		if err != nil {
-			return err
+		// Print out node disruption actions for debug purposes
+		klog.Infof("Calculated node disruption actions:")
+		for _, action := range nodeDisruptionActions {
+			klog.Info(nodeDisruptionActionString(action))
 		}
 		klog.Infof("Drain calculated for node disruption: %v for config %s", drain, newConfigName)
-	} else {
-		// Check and perform node drain if required
-		drain, err = isDrainRequired(actions, diffFileSet, oldIgnConfig, newIgnConfig)
-		if err != nil {
-			return err
-		}
 	}
 	err = upgrademonitor.GenerateAndApplyMachineConfigNodes(
 		&upgrademonitor.Condition{State: mcfgv1.MachineConfigNodeUpdatePrepared, Reason: string(mcfgv1.MachineConfigNodeUpdatePrepared), Message: fmt.Sprintf("Update Compatible. Post Cfg Actions: %v Drain Required: %t", actions, drain)},
@@ -2913,6 +2969,34 @@ func (dn *Daemon) updateLayeredOS(config *mcfgv1.MachineConfig) error {
 		return dn.InplaceUpdateViaNewContainer(newURL)
 	}
 
+	// Multi-architecture images are pushed as a manifest list. We still rebase
+	// onto the manifest list so that the booted image matches osImageURL and
+	// rpm-ostree pulls the image for this node's architecture from it, but
+	// supply-chain artifacts such as SBOMs are attached to that image. Only
+	// multi-architecture builds push a manifest list, so the registry is not
+	// queried otherwise.
+	archURL := newURL
+	if dn.isMultiArchBuild() {
+		archURL, err = resolveArchSpecificImage(newURL)
+		if err != nil {
+			klog.Warningf("Could not resolve %s image for %q, assuming it is not a manifest list: %v", goruntime.GOARCH, newURL, err)
+			archURL = newURL
+		} else if archURL != newURL {
+			klog.Infof("Layered image %q is a manifest list, %s image is %q", newURL, goruntime.GOARCH, archURL)
+		}
+	}
+
+	// Enforce the node's supply-chain policy, if any, before pulling the image.
+	requireSignature, err := checkSupplyChainPolicy(newURL, archURL)
+	if err != nil {
+		return fmt.Errorf("OS image %s does not satisfy supply-chain policy: %w", newURL, err)
+	}
+
+	rebaseLayered := dn.NodeUpdaterClient.RebaseLayered
+	if requireSignature {
+		rebaseLayered = dn.NodeUpdaterClient.RebaseLayeredVerified
+	}
+
 	// Check to see if the new container image is already present.
 	// This could happen if PIS is configured or if the bootloader
 	// update happened (which pulls the container down).
@@ -2921,6 +3005,13 @@ func (dn *Daemon) updateLayeredOS(config *mcfgv1.MachineConfig) error {
 		return err
 	}
 
+	// Images in local container storage cannot be signature verified by
+	// rpm-ostree, so pull from the registry instead.
+	if podmanImageInfo != nil && requireSignature {
+		klog.Infof("Ignoring local copy of %s since its signature must be verified", newURL)
+		podmanImageInfo = nil
+	}
+
 	// For image mode status reporting we need the node's MCP association to populate its MCN
 	imageModeStatusReportingEnabled := dn.fgHandler != nil && dn.fgHandler.Enabled(features.FeatureGateImageModeStatusReporting)
 	pool := ""
@@ -2963,7 +3054,7 @@ func (dn *Daemon) updateLayeredOS(config *mcfgv1.MachineConfig) error {
 		}
 
 		if err := wait.ExponentialBackoff(backoff, func() (bool, error) {
-			if err := dn.NodeUpdaterClient.RebaseLayered(newURL); err != nil {
+			if err := rebaseLayered(newURL); err != nil {
 				klog.Warningf("Failed to update OS to %s (will retry): %v", newURL, err)
 				return false, nil
 			}
@@ -3106,7 +3197,9 @@ func skopeoSupportsMultiArchSigstore(imageURL string) bool {
 	return !multiArch
 }
 
-func isMultiArchImage(imageURL string) (bool, error) {
+// inspectRawManifest returns the raw manifest, or manifest list, of the given
+// image from its registry.
+func inspectRawManifest(imageURL string) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	args := []string{"inspect", "--raw"}
@@ -3117,20 +3210,88 @@ func isMultiArchImage(imageURL string) (bool, error) {
 	cmd := exec.CommandContext(ctx, "skopeo", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return false, fmt.Errorf("skopeo inspect failed for %s: %w", imageURL, err)
+		return nil, fmt.Errorf("skopeo inspect failed for %s: %w", imageURL, err)
 	}
-	var manifest struct {
-		MediaType string `json:"mediaType"`
+	return out, nil
+}
+
+// parseManifestList parses a raw manifest and reports whether it is a Docker
+// manifest list or OCI image index. Both share the same layout.
+func parseManifestList(raw []byte) (*ocispec.Index, bool, error) {
+	index := &ocispec.Index{}
+	if err := json.Unmarshal(raw, index); err != nil {
+		return nil, false, err
+	}
+	multiArch := index.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json" ||
+		index.MediaType == ocispec.MediaTypeImageIndex
+	return index, multiArch, nil
+}
+
+func isMultiArchImage(imageURL string) (bool, error) {
+	out, err := inspectRawManifest(imageURL)
+	if err != nil {
+		return false, err
 	}
-	if err := json.Unmarshal(out, &manifest); err != nil {
+	index, multiArch, err := parseManifestList(out)
+	if err != nil {
 		return false, fmt.Errorf("failed to parse manifest for %s: %w", imageURL, err)
 	}
-	multiArch := manifest.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json" ||
-		manifest.MediaType == "application/vnd.oci.image.index.v1+json"
-	klog.Infof("Image %s mediaType: %s, multi-arch: %v", imageURL, manifest.MediaType, multiArch)
+	klog.Infof("Image %s mediaType: %s, multi-arch: %v", imageURL, index.MediaType, multiArch)
 	return multiArch, nil
 }
 
+// getManifestListDigestForArch returns the digest of the linux image for the
+// given architecture from a manifest list.
+func getManifestListDigestForArch(index *ocispec.Index, arch string) (digest.Digest, error) {
+	for _, manifest := range index.Manifests {
+		if manifest.Platform != nil && manifest.Platform.OS == "linux" && manifest.Platform.Architecture == arch {
+			return manifest.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("no image for linux/%s in manifest list", arch)
+}
+
+// isMultiArchBuild determines whether the desired image was built for multiple
+// architectures, in which case it is pushed as a manifest list. The node
+// controller records this on the node alongside the desired image.
+func (dn *Daemon) isMultiArchBuild() bool {
+	if dn.node == nil {
+		return false
+	}
+
+	return dn.node.GetAnnotations()[constants.DesiredImageIsManifestListAnnotationKey] == "true"
+}
+
+// resolveArchSpecificImage returns the digested pullspec of the image for this
+// node's architecture if imageURL is a manifest list, such as one pushed by a
+// multi-architecture on-cluster build. Otherwise imageURL is returned as-is.
+func resolveArchSpecificImage(imageURL string) (string, error) {
+	out, err := inspectRawManifest(imageURL)
+	if err != nil {
+		return "", err
+	}
+	index, multiArch, err := parseManifestList(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse manifest for %s: %w", imageURL, err)
+	}
+	if !multiArch {
+		return imageURL, nil
+	}
+	archDigest, err := getManifestListDigestForArch(index, goruntime.GOARCH)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %w", imageURL, err)
+	}
+	named, err := reference.ParseNamed(imageURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse image %s: %w", imageURL, err)
+	}
+	archURL, err := reference.WithDigest(reference.TrimNamed(named), archDigest)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %s: %w", imageURL, err)
+	}
+	return archURL.String(), nil
+}
+
 // Log a message to the systemd journal as well as our stdout
 func logSystem(format string, a ...interface{}) {
 	message := fmt.Sprintf(format, a...)
@@ -3176,14 +3337,23 @@ func (dn *Daemon) CancelSIGTERM() {
 // cleans up the agent's connections
 // on failure to reboot, it throws an error and waits for the operator to try again
 func (dn *Daemon) reboot(rationale string) error {
-	// Now that everything is done, avoid delaying shutdown.
-	dn.CancelSIGTERM()
-	dn.Close()
-
 	if dn.skipReboot {
+		dn.CancelSIGTERM()
+		dn.Close()
 		return nil
 	}
 
+	// If the cluster coordinates reboots through an external lock, wait for
+	// it before proceeding. The update is already staged at this point, so we
+	// keep our SIGTERM protection while waiting.
+	if err := dn.acquireRebootLock(); err != nil {
+		return fmt.Errorf("failed to acquire reboot lock: %w", err)
+	}
+
+	// Now that everything is done, avoid delaying shutdown.
+	dn.CancelSIGTERM()
+	dn.Close()
+
 	// We'll only have a recorder if we're cluster driven
 	if dn.nodeWriter != nil {
 		dn.nodeWriter.Eventf(corev1.EventTypeNormal, "Reboot", rationale)
@@ -3204,7 +3374,8 @@ func (dn *Daemon) reboot(rationale string) error {
 	// We're not returning the error from the reboot command as it can be terminated by
 	// the system itself with signal: terminated. We can't catch the subprocess termination signal
 	// either, we just have one for the MCD itself.
-	rebootCmd := rebootCommand(rationale, dn.os.IsCoreOSVariant())
+	rebootType := dn.prepareReboot()
+	rebootCmd := rebootCommand(rationale, rebootType, dn.os.IsCoreOSVariant())
 	if err := rebootCmd.Run(); err != nil {
 		logSystem("failed to run reboot: %v", err)
 		mcdRebootErr.Inc()
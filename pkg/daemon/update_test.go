@@ -75,6 +75,73 @@ func TestTruncate(t *testing.T) {
 	assert.Equal(t, truncate("abcde", 5), "abcde")
 }
 
+func TestGetManifestListDigestForArch(t *testing.T) {
+	manifestList := `{
+		"schemaVersion": 2,
+		"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+		"manifests": [
+			{
+				"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				"size": 1000,
+				"platform": {"architecture": "amd64", "os": "linux"}
+			},
+			{
+				"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+				"digest": "sha256:2222222222222222222222222222222222222222222222222222222222222222",
+				"size": 1000,
+				"platform": {"architecture": "arm64", "os": "linux"}
+			}
+		]
+	}`
+
+	index, multiArch, err := parseManifestList([]byte(manifestList))
+	require.NoError(t, err)
+	assert.True(t, multiArch)
+
+	archDigest, err := getManifestListDigestForArch(index, "arm64")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", archDigest.String())
+
+	_, err = getManifestListDigestForArch(index, "s390x")
+	assert.ErrorContains(t, err, "no image for linux/s390x")
+
+	_, multiArch, err = parseManifestList([]byte(`{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json"}`))
+	require.NoError(t, err)
+	assert.False(t, multiArch)
+}
+
+func TestIsMultiArchBuild(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		multiArch   bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:        "single-architecture image",
+			annotations: map[string]string{constants.DesiredImageIsManifestListAnnotationKey: "false"},
+		},
+		{
+			name:        "manifest list",
+			annotations: map[string]string{constants.DesiredImageIsManifestListAnnotationKey: "true"},
+			multiArch:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			node := helpers.NewNodeBuilder("worker-0").WithAnnotations(testCase.annotations).Node()
+			dn := &Daemon{node: node}
+			assert.Equal(t, testCase.multiArch, dn.isMultiArchBuild())
+		})
+	}
+
+	assert.False(t, (&Daemon{}).isMultiArchBuild())
+}
+
 func TestRunCmdSync(t *testing.T) {
 	err := runCmdSync("echo", "hello", "world")
 	assert.Nil(t, err)
@@ -0,0 +1,52 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	daemonconsts "github.com/openshift/machine-config-operator/pkg/daemon/constants"
+)
+
+// PinnedImagePreflightNodeReport is the preflight report of a node for the
+// images of a PinnedImageSet.
+type PinnedImagePreflightNodeReport struct {
+	// Generation is the generation of the PinnedImageSet the report is for.
+	Generation int64 `json:"generation"`
+	// AvailableBytes is the free space of the image filesystem.
+	AvailableBytes int64 `json:"availableBytes"`
+	// PresentImages is the number of images already on the node.
+	PresentImages int `json:"presentImages"`
+	// MissingImages is the number of images that must be pulled.
+	MissingImages int `json:"missingImages"`
+	// PullBytes is the compressed size of the missing images.
+	PullBytes int64 `json:"pullBytes"`
+	// RequiredBytes is the image filesystem space needed by the missing images.
+	RequiredBytes int64 `json:"requiredBytes"`
+	// Fits is true if the missing images fit on the image filesystem.
+	Fits bool `json:"fits"`
+	// Error is set if the report could not be computed.
+	Error string `json:"error,omitempty"`
+}
+
+// IsPinnedImagePreflight returns true if the images of the PinnedImageSet
+// are only checked against the nodes and never pinned.
+func IsPinnedImagePreflight(imageSet *mcfgv1.PinnedImageSet) bool {
+	return imageSet.Annotations[daemonconsts.PinnedImagePreflightAnnotationKey] == "true"
+}
+
+// GetPinnedImagePreflightReports returns the preflight reports of the node
+// keyed by PinnedImageSet name.
+func GetPinnedImagePreflightReports(node *corev1.Node) (map[string]PinnedImagePreflightNodeReport, error) {
+	reports := map[string]PinnedImagePreflightNodeReport{}
+	value, ok := node.Annotations[daemonconsts.PinnedImagePreflightReportAnnotationKey]
+	if !ok || value == "" {
+		return reports, nil
+	}
+	if err := json.Unmarshal([]byte(value), &reports); err != nil {
+		return nil, fmt.Errorf("invalid %s on node %s: %w", daemonconsts.PinnedImagePreflightReportAnnotationKey, node.Name, err)
+	}
+	return reports, nil
+}
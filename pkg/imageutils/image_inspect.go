@@ -64,9 +64,11 @@ func GetInspectInfoFromImage(ctx context.Context, image types.Image, retryOpts *
 
 // BulkInspectResult represents the result of inspecting a single image in a bulk operation.
 // It contains either the inspection information or an error if the inspection failed.
+// Digest is only set when the BulkInspector resolves digests.
 type BulkInspectResult struct {
 	Image       string
 	InspectInfo *types.ImageInspectInfo
+	Digest      digest.Digest
 	Error       error
 }
 
@@ -76,18 +78,21 @@ type BulkInspectResult struct {
 // or to continue inspecting all images and collect all results (false).
 // Count limits the number of concurrent image inspections. If Count is 0 or
 // negative, no limit is applied and all images are inspected concurrently.
+// ResolveDigests additionally sets the manifest digest of each inspected image.
 type BulkInspectorOptions struct {
-	RetryOpts *retry.RetryOptions
-	FailOnErr bool
-	Count     int
+	RetryOpts      *retry.RetryOptions
+	FailOnErr      bool
+	Count          int
+	ResolveDigests bool
 }
 
 // BulkInspector performs concurrent image inspections with optional rate limiting
 // and configurable error handling.
 type BulkInspector struct {
-	retryOpts *retry.RetryOptions
-	failOnErr bool
-	count     int
+	retryOpts      *retry.RetryOptions
+	failOnErr      bool
+	count          int
+	resolveDigests bool
 }
 
 // NewBulkInspector creates a new BulkInspector with the provided options.
@@ -95,6 +100,7 @@ type BulkInspector struct {
 // - RetryOpts.MaxRetry defaults to 2
 // - FailOnErr defaults to false
 // - Count defaults to 0 (unlimited concurrency)
+// - ResolveDigests defaults to false
 func NewBulkInspector(opts *BulkInspectorOptions) *BulkInspector {
 	if opts == nil {
 		opts = &BulkInspectorOptions{}
@@ -103,9 +109,10 @@ func NewBulkInspector(opts *BulkInspectorOptions) *BulkInspector {
 		opts.RetryOpts = &retry.RetryOptions{MaxRetry: 2}
 	}
 	return &BulkInspector{
-		retryOpts: opts.RetryOpts,
-		failOnErr: opts.FailOnErr,
-		count:     opts.Count,
+		retryOpts:      opts.RetryOpts,
+		failOnErr:      opts.FailOnErr,
+		count:          opts.Count,
+		resolveDigests: opts.ResolveDigests,
 	}
 }
 
@@ -141,8 +148,8 @@ func (i *BulkInspector) Inspect(ctx context.Context, sysCtx *types.SystemContext
 			case rateLimiterChannel <- struct{}{}:
 				defer func() { <-rateLimiterChannel }()
 
-				inspectInfo, err := i.inspectImage(childContext, sysCtx, img)
-				results <- BulkInspectResult{Image: img, InspectInfo: inspectInfo, Error: err}
+				inspectInfo, imgDigest, err := i.inspectImage(childContext, sysCtx, img)
+				results <- BulkInspectResult{Image: img, InspectInfo: inspectInfo, Digest: imgDigest, Error: err}
 			case <-childContext.Done():
 				results <- BulkInspectResult{Error: childContext.Err(), Image: img, InspectInfo: nil}
 			}
@@ -160,15 +167,30 @@ func (i *BulkInspector) Inspect(ctx context.Context, sysCtx *types.SystemContext
 	return inspectInfos, nil
 }
 
-func (i *BulkInspector) inspectImage(ctx context.Context, sysCtx *types.SystemContext, image string) (inspectInfo *types.ImageInspectInfo, err error) {
+func (i *BulkInspector) inspectImage(ctx context.Context, sysCtx *types.SystemContext, image string) (inspectInfo *types.ImageInspectInfo, imgDigest digest.Digest, err error) {
 	img, imgSource, err := GetImage(ctx, sysCtx, image, i.retryOpts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if imgSourceErr := imgSource.Close(); imgSourceErr != nil {
 			err = errors.Join(err, imgSourceErr)
 		}
 	}()
-	return GetInspectInfoFromImage(ctx, img, i.retryOpts)
+
+	inspectInfo, err = GetInspectInfoFromImage(ctx, img, i.retryOpts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !i.resolveDigests {
+		return inspectInfo, "", nil
+	}
+
+	imgDigest, err = GetDigestFromImage(ctx, img, i.retryOpts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return inspectInfo, imgDigest, nil
 }
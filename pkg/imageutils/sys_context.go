@@ -2,6 +2,7 @@ package imageutils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net/url"
 	"os"
@@ -14,6 +15,8 @@ import (
 	mcfgv1 "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/openshift/machine-config-operator/pkg/secrets"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // SysContext wraps types.SystemContext and manages cleanup of temporary files.
@@ -46,6 +49,20 @@ func (b *SysContextBuilder) WithControllerConfig(cc *mcfgv1.ControllerConfig) *S
 	return b
 }
 
+// WithControllerConfigAndPullSecret adds certificates and proxy settings from ControllerConfig
+// to the SysContext, along with authentication from the cluster pull secret it references.
+func (b *SysContextBuilder) WithControllerConfigAndPullSecret(ctx context.Context, kubeClient kubernetes.Interface, cc *mcfgv1.ControllerConfig) (*SysContextBuilder, error) {
+	b = b.WithControllerConfig(cc)
+	if cc.Spec.PullSecret == nil {
+		return b, nil
+	}
+	secret, err := kubeClient.CoreV1().Secrets(cc.Spec.PullSecret.Namespace).Get(ctx, cc.Spec.PullSecret.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get the cluster pull secret: %w", err)
+	}
+	return b.WithSecret(secret), nil
+}
+
 // WithRegistriesConfig adds custom container registry configuration to the SysContext.
 // The registries config will be written as a TOML file and used for registry lookups,
 // mirrors, and pull policies.
@@ -1,6 +1,7 @@
 package imageutils
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekube "k8s.io/client-go/kubernetes/fake"
 )
 
 func TestSysContextBuilderWithSecretAndCerts(t *testing.T) {
@@ -427,3 +429,44 @@ func TestSysContextBuilderWithProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestSysContextBuilderWithControllerConfigAndPullSecret(t *testing.T) {
+	pullSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pull-secret",
+			Namespace: "openshift-config",
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.hostname.com":{"auth":"s00pers3kr1t"}}}`),
+		},
+	}
+	ccWithPullSecret := &mcfgv1.ControllerConfig{
+		Spec: mcfgv1.ControllerConfigSpec{
+			PullSecret: &corev1.ObjectReference{Name: pullSecret.Name, Namespace: pullSecret.Namespace},
+		},
+	}
+
+	t.Run("With pull secret", func(t *testing.T) {
+		builder, err := NewSysContextBuilder().WithControllerConfigAndPullSecret(context.TODO(), fakekube.NewSimpleClientset(pullSecret), ccWithPullSecret)
+		require.NoError(t, err)
+		sysCtx, err := builder.Build()
+		require.NoError(t, err)
+		defer sysCtx.Cleanup()
+		assert.FileExists(t, sysCtx.SysContext.AuthFilePath)
+	})
+
+	t.Run("Without pull secret", func(t *testing.T) {
+		builder, err := NewSysContextBuilder().WithControllerConfigAndPullSecret(context.TODO(), fakekube.NewSimpleClientset(), &mcfgv1.ControllerConfig{})
+		require.NoError(t, err)
+		sysCtx, err := builder.Build()
+		require.NoError(t, err)
+		defer sysCtx.Cleanup()
+		assert.Empty(t, sysCtx.SysContext.AuthFilePath)
+	})
+
+	t.Run("Missing pull secret", func(t *testing.T) {
+		_, err := NewSysContextBuilder().WithControllerConfigAndPullSecret(context.TODO(), fakekube.NewSimpleClientset(), ccWithPullSecret)
+		assert.ErrorContains(t, err, "could not get the cluster pull secret")
+	})
+}
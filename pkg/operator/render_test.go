@@ -254,6 +254,29 @@ func TestRenderAsset(t *testing.T) {
 			},
 			Error: true,
 		},
+		{
+			// Test that the machineconfigcontroller Deployment is rendered correctly with proxy config
+			Path: "manifests/machineconfigcontroller/deployment.yaml",
+			RenderConfig: &renderConfig{
+				TargetNamespace: "testing-namespace",
+				ReleaseVersion:  "4.8.0-rc.0",
+				Images: &ctrlcommon.RenderConfigImages{
+					MachineConfigOperator: "mco-operator-image",
+					KubeRbacProxy:         "kube-rbac-proxy-image",
+				},
+				ControllerConfig: mcfgv1.ControllerConfigSpec{
+					Proxy: &configv1.ProxyStatus{
+						HTTPSProxy: "https://i.am.a.proxy.server",
+						NoProxy:    "*",
+					},
+				},
+			},
+			FindExpected: []string{
+				"- name: HTTPS_PROXY\n          value: https://i.am.a.proxy.server",
+				"- name: NO_PROXY\n          value: \"*\"",
+				"name: machine-config-controller-trusted-ca",
+			},
+		},
 		{
 			// Test that machineconfigdaemon DaemonSets are rendered correctly with proxy config
 			Path: "manifests/machineconfigdaemon/daemonset.yaml",
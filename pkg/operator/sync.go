@@ -99,6 +99,7 @@ const (
 	mccClusterRoleBindingManifestPath                                     = "manifests/machineconfigcontroller/clusterrolebinding.yaml"
 	mccServiceAccountManifestPath                                         = "manifests/machineconfigcontroller/sa.yaml"
 	mccKubeRbacProxyConfigMapPath                                         = "manifests/machineconfigcontroller/kube-rbac-proxy-config.yaml"
+	mccTrustedCAConfigMapPath                                             = "manifests/machineconfigcontroller/trusted-ca-configmap.yaml"
 	mccKubeRbacProxyPrometheusRolePath                                    = "manifests/machineconfigcontroller/prometheus-rbac.yaml"
 	mccKubeRbacProxyPrometheusRoleBindingPath                             = "manifests/machineconfigcontroller/prometheus-rolebinding-target.yaml"
 	mccMachineConfigurationGuardsValidatingAdmissionPolicyPath            = "manifests/machineconfigcontroller/machineconfiguration-guards-validatingadmissionpolicy.yaml"
@@ -1201,6 +1202,7 @@ func (optr *Operator) syncMachineConfigController(config *renderConfig, _ *confi
 		},
 		configMaps: []string{
 			mccKubeRbacProxyConfigMapPath,
+			mccTrustedCAConfigMapPath,
 		},
 		serviceAccounts: []string{
 			mccServiceAccountManifestPath,
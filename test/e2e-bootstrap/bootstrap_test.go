@@ -601,6 +601,7 @@ func createControllers(ctx *ctrlcommon.ControllerContext) []ctrlcommon.Controlle
 			ctx.ClientBuilder.KubeClientOrDie("container-runtime-config-controller"),
 			ctx.ClientBuilder.MachineConfigClientOrDie("container-runtime-config-controller"),
 			ctx.ClientBuilder.ConfigClientOrDie("container-runtime-config-controller"),
+			ctx.ClientBuilder.OperatorClientOrDie("container-runtime-config-controller"),
 			ctx.FeatureGatesHandler,
 		),
 		// The renderer creates "rendered" MCs from the MC fragments generated by